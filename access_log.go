@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Apache's %t timestamp layout
+const accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// accessLog writes one line per request in Apache's Common or Combined Log Format, apart from
+// the structured logs, so tools such as GoAccess can read the traffic as they would a web
+// server's.
+type accessLog struct {
+	combined bool // whether the Referer and User-Agent are logged
+	clock    Clock
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// newAccessLogFromEnv writes the access log to ACCESS_LOG, a file path or "stdout" or
+// "stderr", in ACCESS_LOG_FORMAT, "combined" (the default) or "common". It returns nil
+// without ACCESS_LOG. Files are appended to, so logrotate's copytruncate can rotate them.
+func newAccessLogFromEnv() (*accessLog, error) {
+	dest := os.Getenv("ACCESS_LOG")
+	if dest == "" {
+		return nil, nil
+	}
+	l := &accessLog{combined: true, clock: realClock{}}
+	switch v := strings.ToLower(os.Getenv("ACCESS_LOG_FORMAT")); v {
+	case "", "combined":
+	case "common":
+		l.combined = false
+	default:
+		return nil, fmt.Errorf("invalid ACCESS_LOG_FORMAT %q: must be combined or common", v)
+	}
+	switch dest {
+	case "stdout":
+		l.out = os.Stdout
+	case "stderr":
+		l.out = os.Stderr
+	default:
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open ACCESS_LOG: %w", err)
+		}
+		l.out = f
+	}
+	return l, nil
+}
+
+// Close closes the log file, if it writes to one.
+func (l *accessLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f, ok := l.out.(*os.File); ok && f != os.Stdout && f != os.Stderr {
+		return f.Close()
+	}
+	return nil
+}
+
+// line formats a request as %h %l %u %t "%r" %>s %b, followed in the combined format by
+// "%{Referer}i" "%{User-Agent}i".
+func (l *accessLog) line(r *http.Request, start time.Time, status, size int) string {
+	var b strings.Builder
+	b.WriteString(clientIP(r))
+	b.WriteString(" - ")
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		b.WriteString(escapeAccessLogField(user))
+	} else {
+		b.WriteString("-")
+	}
+	b.WriteString(" [")
+	b.WriteString(start.Format(accessLogTimeLayout))
+	b.WriteString(`] "`)
+	b.WriteString(escapeAccessLogField(r.Method + " " + r.URL.RequestURI() + " " + r.Proto))
+	b.WriteString(`" `)
+	b.WriteString(strconv.Itoa(status))
+	b.WriteString(" ")
+	if size > 0 {
+		b.WriteString(strconv.Itoa(size))
+	} else {
+		b.WriteString("-")
+	}
+	if l.combined {
+		fmt.Fprintf(&b, ` "%s" "%s"`, accessLogHeader(r, "Referer"), accessLogHeader(r, "User-Agent"))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func accessLogHeader(r *http.Request, name string) string {
+	if v := r.Header.Get(name); v != "" {
+		return escapeAccessLogField(v)
+	}
+	return "-"
+}
+
+// escapeAccessLogField escapes quotes, backslashes and non-printable bytes as Apache does, so
+// a client can't forge log lines or fields.
+func escapeAccessLogField(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// middleware that writes each request to the access log once it has been served
+func accessLogMiddleware(next http.Handler, l *accessLog) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := l.clock.Now()
+		rw := newResponseRecorder(w)
+		next.ServeHTTP(rw, r)
+
+		line := l.line(r, start, rw.Status(), rw.bytes)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, err := io.WriteString(l.out, line); err != nil {
+			errorLogger.Printf("Error writing access log: %v", err)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_accessLogMiddleware(t *testing.T) {
+	start := time.Date(2024, 3, 3, 10, 4, 5, 0, time.FixedZone("", 2*60*60))
+	var out bytes.Buffer
+	l := &accessLog{combined: true, clock: newFakeClock(start), out: &out}
+	handler := accessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"count":42}`))
+	}), l)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/count?lang=en", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("Referer", "https://example.com/")
+	req.Header.Set("User-Agent", `Mozilla/5.0 "quoted"`)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodHead, "/missing", nil)
+	req.RemoteAddr = "198.51.100.2:1234"
+	req.SetBasicAuth("admin", "secret")
+	l.combined = false
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := `203.0.113.7 - - [03/Mar/2024:10:04:05 +0200] "GET /api/count?lang=en HTTP/1.1" 200 12 "https://example.com/" "Mozilla/5.0 \"quoted\""` + "\n" +
+		`198.51.100.2 - admin [03/Mar/2024:10:04:05 +0200] "HEAD /missing HTTP/1.1" 404 19` + "\n"
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+}
+
+func Test_escapeAccessLogField(t *testing.T) {
+	if got := escapeAccessLogField("a\"b\\c\nd\xff"); got != `a\"b\\c\x0ad\xff` {
+		t.Errorf("unexpected escaping: %s", got)
+	}
+}
+
+func Test_newAccessLogFromEnv(t *testing.T) {
+	if l, err := newAccessLogFromEnv(); l != nil || err != nil {
+		t.Fatalf("expected no access log without ACCESS_LOG, got %v, %v", l, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	t.Setenv("ACCESS_LOG", path)
+	t.Setenv("ACCESS_LOG_FORMAT", "common")
+	l, err := newAccessLogFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.combined {
+		t.Error("expected the common format")
+	}
+	accessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), l).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"GET /healthz HTTP/1.1" 200 -`) {
+		t.Errorf("expected the request in the log file, got %q", data)
+	}
+
+	t.Setenv("ACCESS_LOG_FORMAT", "json")
+	if _, err := newAccessLogFromEnv(); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// adminListenerConfig configures the second, mutual TLS listener internal tooling uses to
+// reach the admin endpoints and metrics with a client certificate instead of ADMIN_TOKEN.
+type adminListenerConfig struct {
+	Addr     string
+	CertFile string // the listener's own certificate and key
+	KeyFile  string
+	ClientCA string // PEM bundle of the CAs client certificates must chain to
+}
+
+// loadAdminListenerConfig reads ADMIN_LISTEN_ADDR, which enables the listener, along with
+// ADMIN_TLS_CERT, ADMIN_TLS_KEY and ADMIN_CLIENT_CA, which are then all required.
+func loadAdminListenerConfig() (adminListenerConfig, bool, error) {
+	cfg := adminListenerConfig{
+		Addr:     os.Getenv("ADMIN_LISTEN_ADDR"),
+		CertFile: os.Getenv("ADMIN_TLS_CERT"),
+		KeyFile:  os.Getenv("ADMIN_TLS_KEY"),
+		ClientCA: os.Getenv("ADMIN_CLIENT_CA"),
+	}
+	if cfg.Addr == "" {
+		return adminListenerConfig{}, false, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" || cfg.ClientCA == "" {
+		return adminListenerConfig{}, false, fmt.Errorf("ADMIN_TLS_CERT, ADMIN_TLS_KEY and ADMIN_CLIENT_CA are required when ADMIN_LISTEN_ADDR is set")
+	}
+	return cfg, true, nil
+}
+
+// newAdminTLSConfig loads the listener's key pair and the client CA bundle, requiring every
+// connection to present a certificate that chains to one of the CAs.
+func newAdminTLSConfig(cfg adminListenerConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADMIN_TLS_CERT or ADMIN_TLS_KEY: %w", err)
+	}
+	bundle, err := os.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADMIN_CLIENT_CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("invalid ADMIN_CLIENT_CA %q: no PEM certificates found", cfg.ClientCA)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// newAdminServer returns the mutual TLS server for cfg, serving handler.
+func newAdminServer(cfg adminListenerConfig, handler http.Handler) (*http.Server, error) {
+	tlsConfig, err := newAdminTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Server{Addr: cfg.Addr, Handler: handler, TLSConfig: tlsConfig}, nil
+}
+
+// hasVerifiedClientCert reports whether r came over the admin listener with a client
+// certificate that chained to ADMIN_CLIENT_CA. Other listeners never verify client
+// certificates, so requests to them always report false.
+func hasVerifiedClientCert(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
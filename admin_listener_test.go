@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key issued by parent, or self-signed when parent is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	issuer, signer := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePEM writes c's certificate and key to files in dir, returning their paths.
+func (c *testCert) writePEM(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func Test_loadAdminListenerConfig(t *testing.T) {
+	t.Setenv("ADMIN_LISTEN_ADDR", "")
+	if _, enabled, err := loadAdminListenerConfig(); enabled || err != nil {
+		t.Errorf("expected the listener disabled by default, got %v, %v", enabled, err)
+	}
+
+	t.Setenv("ADMIN_LISTEN_ADDR", ":9443")
+	t.Setenv("ADMIN_TLS_CERT", "/tls/tls.crt")
+	t.Setenv("ADMIN_TLS_KEY", "/tls/tls.key")
+	t.Setenv("ADMIN_CLIENT_CA", "")
+	if _, _, err := loadAdminListenerConfig(); err == nil {
+		t.Error("expected an error without ADMIN_CLIENT_CA")
+	}
+
+	t.Setenv("ADMIN_CLIENT_CA", "/tls/ca.crt")
+	cfg, enabled, err := loadAdminListenerConfig()
+	if err != nil || !enabled {
+		t.Fatalf("loadAdminListenerConfig() = %v, %v", enabled, err)
+	}
+	if cfg != (adminListenerConfig{Addr: ":9443", CertFile: "/tls/tls.crt", KeyFile: "/tls/tls.key", ClientCA: "/tls/ca.crt"}) {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
+
+func Test_newAdminTLSConfig_InvalidCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "server", nil).writePEM(t, dir, "server")
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAdminTLSConfig(adminListenerConfig{CertFile: certFile, KeyFile: keyFile, ClientCA: caFile}); err == nil {
+		t.Error("expected a CA bundle without certificates rejected")
+	}
+}
+
+func Test_adminListener_ClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "internal CA", nil)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	server := newTestCert(t, "admin", ca)
+	certFile, keyFile := server.writePEM(t, dir, "server")
+
+	tlsConfig, err := newAdminTLSConfig(adminListenerConfig{CertFile: certFile, KeyFile: keyFile, ClientCA: caFile})
+	if err != nil {
+		t.Fatalf("newAdminTLSConfig() error = %v", err)
+	}
+	// No ADMIN_TOKEN is configured, so only a client certificate gets through
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminAuthorized(w, r, "") {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+
+	resp, err := client(newTestCert(t, "tooling", ca).tlsCertificate()).Get(ts.URL)
+	if err != nil {
+		t.Fatalf("request with a client certificate failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected a client certificate authorized, got %d", resp.StatusCode)
+	}
+
+	// Connections without a certificate, or with one from another CA, are refused
+	for name, c := range map[string]*http.Client{
+		"no certificate":   client(),
+		"untrusted issuer": client(newTestCert(t, "intruder", newTestCert(t, "other CA", nil)).tlsCertificate()),
+	} {
+		if resp, err := c.Get(ts.URL); err == nil {
+			resp.Body.Close()
+			t.Errorf("%s: expected the connection refused, got %d", name, resp.StatusCode)
+		}
+	}
+}
+
+func Test_adminAuthorized_PlainRequestsNeedToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/admin/projects", nil)
+	r.TLS = &tls.ConnectionState{} // TLS without a verified client certificate
+	if adminAuthorized(w, r, "secret") {
+		t.Error("expected a request without a token or client certificate rejected")
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+}
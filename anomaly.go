@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	anomaliesPath = "/api/anomalies"
+
+	defaultAnomalyCheckInterval = 5 * time.Minute
+	defaultAnomalyBaselineHours = 7 * 24
+	defaultAnomalyThreshold     = 3.0
+	defaultAnomaliesDays        = 7
+)
+
+// anomalyConfig controls the visit rate analyzer.
+type anomalyConfig struct {
+	CheckInterval time.Duration
+	BaselineHours int     // hours before the checked one that form the baseline
+	Threshold     float64 // standard deviations from the mean that count as anomalous
+	WebhookURL    string  // alerts are only logged when empty
+}
+
+// loadAnomalyConfig reads ANOMALY_* settings from the environment.
+func loadAnomalyConfig() anomalyConfig {
+	cfg := anomalyConfig{
+		CheckInterval: defaultAnomalyCheckInterval,
+		BaselineHours: defaultAnomalyBaselineHours,
+		Threshold:     defaultAnomalyThreshold,
+		WebhookURL:    os.Getenv("ANOMALY_WEBHOOK_URL"),
+	}
+
+	if v := os.Getenv("ANOMALY_CHECK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid ANOMALY_CHECK_INTERVAL %q, using %s", v, cfg.CheckInterval)
+		} else {
+			cfg.CheckInterval = d
+		}
+	}
+	if v := os.Getenv("ANOMALY_BASELINE_HOURS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			log.Printf("Invalid ANOMALY_BASELINE_HOURS %q, using %d", v, cfg.BaselineHours)
+		} else {
+			cfg.BaselineHours = n
+		}
+	}
+	if v := os.Getenv("ANOMALY_STDDEV_THRESHOLD"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			log.Printf("Invalid ANOMALY_STDDEV_THRESHOLD %q, using %g", v, cfg.Threshold)
+		} else {
+			cfg.Threshold = f
+		}
+	}
+
+	return cfg
+}
+
+// detectAnomaly compares visits against the baseline. The standard deviation is floored at
+// sqrt(mean) and 1, as for Poisson counts, so a quiet, flat baseline doesn't flag every blip.
+func detectAnomaly(hour time.Time, visits int, baseline []int, threshold float64) (Anomaly, bool) {
+	if len(baseline) == 0 {
+		return Anomaly{}, false
+	}
+
+	var sum float64
+	for _, v := range baseline {
+		sum += float64(v)
+	}
+	mean := sum / float64(len(baseline))
+
+	var variance float64
+	for _, v := range baseline {
+		variance += (float64(v) - mean) * (float64(v) - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(baseline)))
+
+	spread := math.Max(stddev, math.Max(math.Sqrt(mean), 1))
+	a := Anomaly{Hour: hour, Visits: visits, Mean: mean, StdDev: stddev}
+	switch {
+	case float64(visits) > mean+threshold*spread:
+		a.Kind = "spike"
+	case float64(visits) < mean-threshold*spread:
+		a.Kind = "drop"
+	default:
+		return Anomaly{}, false
+	}
+	return a, true
+}
+
+// notifier delivers alerts; webhookNotifier is the production implementation.
+type notifier interface {
+	Notify(ctx context.Context, text string, details interface{}) error
+}
+
+// anomalyDetector checks the last complete hour of visits against the rolling baseline.
+type anomalyDetector struct {
+	store    DataStore
+	cfg      anomalyConfig
+	clock    Clock
+	notifier notifier // nil disables alerting
+}
+
+func newAnomalyDetector(store DataStore, cfg anomalyConfig, clock Clock) *anomalyDetector {
+	d := &anomalyDetector{store: store, cfg: cfg, clock: clock}
+	if cfg.WebhookURL != "" {
+		d.notifier = newWebhookNotifier(cfg.WebhookURL)
+	}
+	return d
+}
+
+// anomalyAlertDestination names anomaly alerts in the outbox
+const anomalyAlertDestination = "anomaly-alert"
+
+// queueAlerts makes the detector queue its alerts in store's outbox rather than post them
+// straight away, so a webhook outage delays alerts instead of losing them. It returns the
+// handler that posts them, or nil when alerting is disabled; call it before Run.
+func (d *anomalyDetector) queueAlerts(store DataStore) outboxHandler {
+	webhook, ok := d.notifier.(*webhookNotifier)
+	if !ok {
+		return nil
+	}
+	d.notifier = &outboxNotifier{store: store, destination: anomalyAlertDestination}
+	return webhook
+}
+
+// Check evaluates the last complete UTC hour. Anomalies are recorded once per hour, and only
+// the instance that records one sends the alert, so replicas and repeated checks don't re-alert.
+func (d *anomalyDetector) Check(ctx context.Context) error {
+	current := d.clock.Now().UTC().Truncate(time.Hour)
+	target := current.Add(-time.Hour)
+	from := target.Add(-time.Duration(d.cfg.BaselineHours) * time.Hour)
+
+	counts, err := d.store.GetHourlyVisits(ctx, from, current)
+	if err != nil {
+		return err
+	}
+
+	// Fill in hours without visits; they are part of the baseline too
+	byHour := make(map[time.Time]int, len(counts))
+	for _, c := range counts {
+		byHour[c.Hour.UTC()] = c.Visits
+	}
+	baseline := make([]int, 0, d.cfg.BaselineHours)
+	for h := from; h.Before(target); h = h.Add(time.Hour) {
+		baseline = append(baseline, byHour[h])
+	}
+
+	anomaly, ok := detectAnomaly(target, byHour[target], baseline, d.cfg.Threshold)
+	if !ok {
+		return nil
+	}
+	recorded, err := d.store.RecordAnomaly(ctx, anomaly)
+	if err != nil || !recorded {
+		return err
+	}
+
+	text := fmt.Sprintf("Visit %s: %d visits in the hour from %s, against a baseline of %.1f ± %.1f",
+		anomaly.Kind, anomaly.Visits, anomaly.Hour.Format(time.RFC3339), anomaly.Mean, anomaly.StdDev)
+	log.Println(text)
+	if d.notifier == nil {
+		return nil
+	}
+	if err := d.notifier.Notify(ctx, text, anomaly); err != nil {
+		errorLogger.Printf("Error sending anomaly alert: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Run checks every CheckInterval until ctx is done.
+func (d *anomalyDetector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Failures are logged by the store or notifier and retried on the next tick
+			_ = d.Check(ctx)
+		}
+	}
+}
+
+// anomaliesResponse is the body returned by GET /api/anomalies.
+type anomaliesResponse struct {
+	Days      int       `json:"days"`
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+// anomaliesHandler lists the visit rate anomalies detected over the last days days.
+func anomaliesHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultAnomaliesDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	now := clock.Now()
+	anomalies, err := dataStore.GetAnomalies(r.Context(), now.AddDate(0, 0, -days), now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get anomalies: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if anomalies == nil {
+		anomalies = []Anomaly{}
+	}
+
+	writeResponse(w, r, anomaliesResponse{Days: days, Anomalies: anomalies})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordingNotifier captures alerts instead of posting them
+type recordingNotifier struct {
+	texts []string
+	err   error
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, text string, details interface{}) error {
+	n.texts = append(n.texts, text)
+	return n.err
+}
+
+func Test_loadAnomalyConfig(t *testing.T) {
+	t.Setenv("ANOMALY_CHECK_INTERVAL", "1m")
+	t.Setenv("ANOMALY_BASELINE_HOURS", "1")
+	t.Setenv("ANOMALY_STDDEV_THRESHOLD", "2.5")
+	t.Setenv("ANOMALY_WEBHOOK_URL", "https://hooks.example.com/x")
+
+	cfg := loadAnomalyConfig()
+	want := anomalyConfig{
+		CheckInterval: time.Minute,
+		BaselineHours: defaultAnomalyBaselineHours, // a single hour has no spread to speak of
+		Threshold:     2.5,
+		WebhookURL:    "https://hooks.example.com/x",
+	}
+	if cfg != want {
+		t.Errorf("loadAnomalyConfig() = %+v, want %+v", cfg, want)
+	}
+}
+
+func Test_detectAnomaly(t *testing.T) {
+	hour := time.Date(2024, 3, 3, 9, 0, 0, 0, time.UTC)
+	steady := []int{10, 12, 8, 11, 9, 10, 10, 10}
+
+	tests := []struct {
+		name     string
+		visits   int
+		baseline []int
+		wantKind string
+	}{
+		{"Within baseline", 13, steady, ""},
+		{"Spike", 40, steady, "spike"},
+		{"Drop", 0, steady, "drop"},
+		{"Quiet baseline blip", 3, []int{0, 0, 0, 0, 0, 0}, ""},
+		{"Quiet baseline spike", 4, []int{0, 0, 0, 0, 0, 0}, "spike"},
+		{"No baseline", 100, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, ok := detectAnomaly(hour, tt.visits, tt.baseline, 3)
+			if ok != (tt.wantKind != "") {
+				t.Fatalf("detectAnomaly() flagged = %v, want kind %q", ok, tt.wantKind)
+			}
+			if ok && (a.Kind != tt.wantKind || a.Visits != tt.visits || !a.Hour.Equal(hour)) {
+				t.Errorf("detectAnomaly() = %+v, want a %s of %d visits at %v", a, tt.wantKind, tt.visits, hour)
+			}
+		})
+	}
+}
+
+func Test_anomalyDetector_Check(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 20, 0, 0, time.UTC))
+	target := time.Date(2024, 3, 3, 9, 0, 0, 0, time.UTC)
+	cfg := anomalyConfig{BaselineHours: 24, Threshold: 3}
+
+	// A steady 10 visits an hour, then 60 in the last complete hour
+	var hourly []HourlyCount
+	for h := target.Add(-24 * time.Hour); h.Before(target); h = h.Add(time.Hour) {
+		hourly = append(hourly, HourlyCount{Hour: h, Visits: 10})
+	}
+	hourly = append(hourly, HourlyCount{Hour: target, Visits: 60})
+
+	mockDataStore := &MockDataStore{hourly: hourly}
+	n := &recordingNotifier{}
+	d := &anomalyDetector{store: mockDataStore, cfg: cfg, clock: clock, notifier: n}
+
+	if err := d.Check(context.Background()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !mockDataStore.lastFrom.Equal(target.Add(-24*time.Hour)) || !mockDataStore.lastTo.Equal(target.Add(time.Hour)) {
+		t.Errorf("expected hourly visits from %v to %v; got %v to %v",
+			target.Add(-24*time.Hour), target.Add(time.Hour), mockDataStore.lastFrom, mockDataStore.lastTo)
+	}
+	if len(mockDataStore.anomalies) != 1 || mockDataStore.anomalies[0].Kind != "spike" || !mockDataStore.anomalies[0].Hour.Equal(target) {
+		t.Fatalf("expected a spike recorded at %v; got %+v", target, mockDataStore.anomalies)
+	}
+	if len(n.texts) != 1 {
+		t.Fatalf("expected one alert; got %d", len(n.texts))
+	}
+
+	// A later check within the same hour, or on another replica, must not alert again
+	if err := d.Check(context.Background()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(n.texts) != 1 {
+		t.Errorf("expected the anomaly to be alerted once; got %d alerts", len(n.texts))
+	}
+}
+
+func Test_anomalyDetector_Check_MissingHours(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	target := time.Date(2024, 3, 3, 9, 0, 0, 0, time.UTC)
+
+	// Hours without visits are absent from the store but still count as zero
+	mockDataStore := &MockDataStore{hourly: []HourlyCount{{Hour: target, Visits: 5}}}
+	n := &recordingNotifier{err: fmt.Errorf("webhook down")}
+	d := &anomalyDetector{store: mockDataStore, cfg: anomalyConfig{BaselineHours: 24, Threshold: 3}, clock: clock, notifier: n}
+
+	if err := d.Check(context.Background()); err == nil {
+		t.Error("expected the notifier error to be returned")
+	}
+	if len(mockDataStore.anomalies) != 1 || mockDataStore.anomalies[0].Mean != 0 {
+		t.Errorf("expected a spike against an empty baseline; got %+v", mockDataStore.anomalies)
+	}
+}
+
+func Test_anomaliesHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	hour := time.Date(2024, 3, 3, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		store          *MockDataStore
+		expectedStatus int
+		wantDays       int
+		wantAnomalies  int
+	}{
+		{"Default days", http.MethodGet, "", &MockDataStore{anomalies: []Anomaly{{Hour: hour, Kind: "spike", Visits: 60, Mean: 10}}}, http.StatusOK, defaultAnomaliesDays, 1},
+		{"Empty", http.MethodGet, "?days=1", &MockDataStore{}, http.StatusOK, 1, 0},
+		{"Invalid days", http.MethodGet, "?days=0", &MockDataStore{}, http.StatusBadRequest, 0, 0},
+		{"Invalid method", http.MethodPost, "", &MockDataStore{}, http.StatusMethodNotAllowed, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			anomaliesHandler(w, httptest.NewRequest(tt.method, anomaliesPath+tt.query, nil), tt.store, clock)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response anomaliesResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if response.Days != tt.wantDays || len(response.Anomalies) != tt.wantAnomalies {
+				t.Errorf("expected %d anomalies over %d days; got %+v", tt.wantAnomalies, tt.wantDays, response)
+			}
+			if response.Anomalies == nil {
+				t.Error("expected anomalies to encode as an empty list, not null")
+			}
+			if want := clock.Now().AddDate(0, 0, -tt.wantDays); !tt.store.lastFrom.Equal(want) {
+				t.Errorf("expected anomalies from %v; got %v", want, tt.store.lastFrom)
+			}
+		})
+	}
+}
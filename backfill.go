@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"resume-backend/internal/store"
+)
+
+const defaultBackfillBatchSize = 10000
+
+// visitCopier bulk loads visits; the Postgres store does it with COPY
+type visitCopier interface {
+	CopyVisits(ctx context.Context, visits []Visit) (int, error)
+}
+
+type backfillConfig struct {
+	File       string
+	Checkpoint string
+	BatchSize  int
+}
+
+// backfillCheckpoint records how much of the file has been loaded, so an interrupted import
+// picks up where it stopped.
+type backfillCheckpoint struct {
+	Offset int64 `json:"offset"` // bytes of the file loaded
+	Lines  int   `json:"lines"`
+	Visits int   `json:"visits"`
+}
+
+// visitLine is one line of a visits export, which the backfill command reads back: a visit
+// in the exported layout, with the page it was to if known. The backfill ignores IDs, as
+// imported visits get new ones.
+type visitLine struct {
+	exportedVisit
+	Page string `json:"page,omitempty"`
+}
+
+func newVisitLine(v VisitRow) visitLine {
+	return visitLine{exportedVisit: newExportedVisit(v), Page: v.Page}
+}
+
+// runBackfillCommand imports historical visits from a file of newline-delimited JSON into
+// the database configured by the environment.
+func runBackfillCommand(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	cfg := backfillConfig{}
+	flags.StringVar(&cfg.File, "file", "", "newline-delimited JSON visits to import, in the export layout")
+	flags.StringVar(&cfg.Checkpoint, "checkpoint", "", "file recording progress so an interrupted import resumes (default -file with .checkpoint appended)")
+	flags.IntVar(&cfg.BatchSize, "batch-size", defaultBackfillBatchSize, "visits loaded per COPY")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if cfg.File == "" {
+		return errors.New("-file is required")
+	}
+	if cfg.BatchSize < 1 {
+		return errors.New("-batch-size must be positive")
+	}
+	if cfg.Checkpoint == "" {
+		cfg.Checkpoint = cfg.File + ".checkpoint"
+	}
+
+	// Stopping between batches leaves the checkpoint matching what was loaded
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	dataStore, err := store.SetupDatabase(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to set up database: %w", err)
+	}
+	defer dataStore.Close()
+	copier, ok := dataStore.(visitCopier)
+	if !ok {
+		return errors.New("the database does not support bulk loads")
+	}
+
+	visits, err := backfill(ctx, copier, cfg)
+	if err != nil {
+		return err
+	}
+	log.Printf("Backfill complete: %d visits imported; delete %s to import the file again", visits, cfg.Checkpoint)
+	return nil
+}
+
+// backfill loads the visits in cfg.File in batches, recording each loaded batch in the
+// checkpoint file and resuming after the last one recorded. It returns the number of visits
+// loaded from the file so far, including by earlier runs. A batch loaded just before a crash
+// can be loaded again on resume, as the checkpoint is written after the batch commits.
+func backfill(ctx context.Context, copier visitCopier, cfg backfillConfig) (int, error) {
+	f, err := os.Open(cfg.File)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open backfill file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read backfill file: %w", err)
+	}
+
+	cp, err := readBackfillCheckpoint(cfg.Checkpoint)
+	if err != nil {
+		return 0, err
+	}
+	if cp.Offset > 0 {
+		log.Printf("Resuming backfill after line %d, %d visits already imported", cp.Lines, cp.Visits)
+		if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+			return cp.Visits, fmt.Errorf("failed to resume backfill file: %w", err)
+		}
+	}
+
+	started, resumedAt := time.Now(), cp.Visits
+	offset, lines := cp.Offset, cp.Lines
+	batch := make([]Visit, 0, cfg.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := copier.CopyVisits(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("failed to import visits after line %d: %w", cp.Lines, err)
+		}
+		cp = backfillCheckpoint{Offset: offset, Lines: lines, Visits: cp.Visits + n}
+		if err := writeBackfillCheckpoint(cfg.Checkpoint, cp); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		rate := float64(cp.Visits-resumedAt) / time.Since(started).Seconds()
+		log.Printf("Imported %d visits, %.1f%% of the file, %.0f visits/s", cp.Visits, 100*float64(offset)/float64(max(info.Size(), 1)), rate)
+		return nil
+	}
+
+	r := bufio.NewReaderSize(f, 1<<20)
+	for {
+		line, readErr := r.ReadBytes('\n')
+		if len(line) > 0 {
+			offset += int64(len(line))
+			lines++
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				v, err := parseBackfillVisit(line)
+				if err != nil {
+					return cp.Visits, fmt.Errorf("line %d: %w", lines, err)
+				}
+				batch = append(batch, v)
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return cp.Visits, fmt.Errorf("failed to read backfill file: %w", readErr)
+		}
+		if len(batch) == cfg.BatchSize {
+			if err := flush(); err != nil {
+				return cp.Visits, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return cp.Visits, err
+	}
+	return cp.Visits, nil
+}
+
+func parseBackfillVisit(line []byte) (Visit, error) {
+	var bv visitLine
+	if err := json.Unmarshal(line, &bv); err != nil {
+		return Visit{}, fmt.Errorf("invalid visit: %w", err)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, bv.Timestamp)
+	if err != nil {
+		return Visit{}, fmt.Errorf("invalid timestamp %q: must be RFC 3339", bv.Timestamp)
+	}
+	return Visit{
+		Timestamp: ts,
+		Page:      bv.Page,
+		Referrer:  bv.Referrer,
+		UTM:       UTM{Source: bv.UTMSource, Medium: bv.UTMMedium, Campaign: bv.UTMCampaign},
+	}, nil
+}
+
+// readBackfillCheckpoint returns the recorded progress, which is none without a checkpoint file
+func readBackfillCheckpoint(path string) (backfillCheckpoint, error) {
+	var cp backfillCheckpoint
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// writeBackfillCheckpoint replaces the checkpoint file in one step, so a crash can't leave
+// it half written.
+func writeBackfillCheckpoint(path string, cp backfillCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingCopier keeps the batches it loads, failing once it has loaded failAfter of them
+type recordingCopier struct {
+	batches   [][]Visit
+	failAfter int
+}
+
+func (c *recordingCopier) CopyVisits(ctx context.Context, visits []Visit) (int, error) {
+	if c.failAfter > 0 && len(c.batches) == c.failAfter {
+		return 0, errors.New("connection reset")
+	}
+	c.batches = append(c.batches, append([]Visit(nil), visits...))
+	return len(visits), nil
+}
+
+func writeBackfillFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "visits.ndjson")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_backfill(t *testing.T) {
+	path := writeBackfillFile(t,
+		`{"id":1,"timestamp":"2023-01-01T10:00:00Z","referrer":"linkedin.com"}`,
+		`{"id":2,"timestamp":"2023-01-01T11:00:00Z","utm_source":"newsletter","utm_campaign":"launch"}`,
+		``,
+		`{"id":3,"timestamp":"2023-01-02T09:30:00.5Z","page":"blog"}`,
+		`{"id":4,"timestamp":"2023-01-03T08:00:00+01:00"}`,
+		`{"id":5,"timestamp":"2023-01-04T08:00:00Z"}`)
+	cfg := backfillConfig{File: path, Checkpoint: path + ".checkpoint", BatchSize: 2}
+
+	// The import stops at the second batch; the checkpoint records the first
+	first := &recordingCopier{failAfter: 1}
+	if n, err := backfill(context.Background(), first, cfg); err == nil || n != 2 {
+		t.Fatalf("expected the import to fail after 2 visits; got %d, %v", n, err)
+	}
+	cp, err := readBackfillCheckpoint(cfg.Checkpoint)
+	if err != nil || cp.Lines != 2 || cp.Visits != 2 {
+		t.Fatalf("unexpected checkpoint %+v, %v", cp, err)
+	}
+
+	// Resuming loads the rest, without repeating the first batch
+	second := &recordingCopier{}
+	n, err := backfill(context.Background(), second, cfg)
+	if err != nil || n != 5 {
+		t.Fatalf("expected 5 visits imported in all; got %d, %v", n, err)
+	}
+	if len(second.batches) != 2 || len(second.batches[0]) != 2 || len(second.batches[1]) != 1 {
+		t.Fatalf("expected the remaining 3 visits in 2 batches; got %+v", second.batches)
+	}
+	want := Visit{Timestamp: time.Date(2023, 1, 2, 9, 30, 0, 500000000, time.UTC), Page: "blog"}
+	if got := second.batches[0][0]; !got.Timestamp.Equal(want.Timestamp) || got.Page != want.Page {
+		t.Errorf("expected %+v; got %+v", want, got)
+	}
+	if got := first.batches[0][1].UTM; got != (UTM{Source: "newsletter", Campaign: "launch"}) {
+		t.Errorf("unexpected UTM %+v", got)
+	}
+
+	// A finished import loads nothing more
+	third := &recordingCopier{}
+	if n, err := backfill(context.Background(), third, cfg); err != nil || n != 5 || len(third.batches) != 0 {
+		t.Errorf("expected nothing left to import; got %d, %v, %+v", n, err, third.batches)
+	}
+}
+
+func Test_backfill_InvalidLine(t *testing.T) {
+	path := writeBackfillFile(t,
+		`{"timestamp":"2023-01-01T10:00:00Z"}`,
+		`{"timestamp":"yesterday"}`)
+	_, err := backfill(context.Background(), &recordingCopier{}, backfillConfig{File: path, Checkpoint: path + ".checkpoint", BatchSize: 10})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error naming line 2; got %v", err)
+	}
+}
+
+func Test_runBackfillCommand_Flags(t *testing.T) {
+	for _, args := range [][]string{{}, {"-file", "visits.ndjson", "-batch-size", "0"}, {"-unknown"}} {
+		if err := runBackfillCommand(args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
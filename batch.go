@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"resume-backend/internal/logging"
+)
+
+const (
+	batchPath = "/api/count/batch"
+
+	// maxBatchVisits caps how many queued visits one POST /api/count/batch can flush
+	maxBatchVisits = 100
+
+	// maxBatchBodyBytes caps the body of POST /api/count/batch
+	maxBatchBodyBytes = 64 << 10
+)
+
+// batchVisit is one visit queued by the frontend while it was offline.
+type batchVisit struct {
+	Timestamp *time.Time `json:"timestamp"` // when the page was viewed; now if omitted
+	visitRequest
+}
+
+// batchResult reports what happened to one visit of a batch, by its position in the request.
+type batchResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"` // "accepted" or "rejected"
+	Error  string `json:"error,omitempty"`
+}
+
+// batchResponse is the body returned by POST /api/count/batch.
+type batchResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []batchResult `json:"results"`
+}
+
+// validateBatchVisit turns a queued visit into the visit to store, rejecting timestamps the
+// queue couldn't have produced and moving those from clocks running slightly ahead to now.
+func validateBatchVisit(r *http.Request, bv batchVisit, times clientTimeConfig, now time.Time) (Visit, error) {
+	visit := Visit{Timestamp: now}
+	if bv.Timestamp != nil {
+		ts, err := times.check(batchPath, *bv.Timestamp, now)
+		if err != nil {
+			return Visit{}, err
+		}
+		visit.Timestamp = ts
+	}
+
+	if err := bv.validate(); err != nil {
+		return Visit{}, err
+	}
+	visit.Page = bv.Page
+	if trackingAllowedFor(r.Context(), bv.Consent) {
+		visit.Referrer, visit.UTM = normalizeReferrer(bv.Referrer), normalizeUTM(bv.UTM)
+	}
+	return visit, nil
+}
+
+// batchHandler records the visits the frontend queued while offline. Each visit is checked
+// on its own and the valid ones are stored together; the response says which were rejected
+// so the queue can drop them rather than retry. Batched visits aren't counted as unique
+// visitors, as the visitor's hash for the day they were made is no longer known.
+func batchHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, times clientTimeConfig, clock Clock) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch []batchVisit
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)).Decode(&batch); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid batch body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(batch) == 0 || len(batch) > maxBatchVisits {
+		http.Error(w, fmt.Sprintf("A batch must hold between 1 and %d visits", maxBatchVisits), http.StatusBadRequest)
+		return
+	}
+
+	now := clock.Now()
+	response := batchResponse{Results: make([]batchResult, len(batch))}
+	var visits []Visit
+	for i, bv := range batch {
+		visit, err := validateBatchVisit(r, bv, times, now)
+		if err != nil {
+			response.Rejected++
+			response.Results[i] = batchResult{Index: i, Status: "rejected", Error: err.Error()}
+			continue
+		}
+		visits = append(visits, visit)
+		response.Accepted++
+		response.Results[i] = batchResult{Index: i, Status: "accepted"}
+	}
+
+	if err := dataStore.IncrementVisitCounts(r.Context(), visits); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to record visits: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logging.FromContext(r.Context()).Printf("Batch of %d visits recorded, %d rejected", response.Accepted, response.Rejected)
+	writeResponse(w, r, response)
+}
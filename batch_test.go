@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_batchHandler(t *testing.T) {
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	mockDataStore := &MockDataStore{}
+	body := `[
+		{"timestamp": "2024-03-02T18:30:00Z", "page": "Blog", "referrer": "https://www.linkedin.com/feed/"},
+		{"page": "projects"},
+		{"timestamp": "2024-03-04T10:00:00Z"},
+		{"timestamp": "2024-02-01T10:00:00Z"},
+		{"page": "../admin"}
+	]`
+	w := httptest.NewRecorder()
+	batchHandler(w, httptest.NewRequest(http.MethodPost, batchPath, strings.NewReader(body)), mockDataStore, loadClientTimeConfig(), newFakeClock(now))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d: %s", w.Code, w.Body.String())
+	}
+	var resp batchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if resp.Accepted != 2 || resp.Rejected != 3 || len(resp.Results) != 5 {
+		t.Fatalf("unexpected totals: %+v", resp)
+	}
+	for i, want := range []string{"accepted", "accepted", "rejected", "rejected", "rejected"} {
+		res := resp.Results[i]
+		if res.Index != i || res.Status != want || (want == "rejected") != (res.Error != "") {
+			t.Errorf("result %d = %+v, want status %s", i, res, want)
+		}
+	}
+
+	want := []Visit{
+		{Timestamp: time.Date(2024, 3, 2, 18, 30, 0, 0, time.UTC), Page: "blog", Referrer: "linkedin.com"},
+		{Timestamp: now, Page: "projects"},
+	}
+	if len(mockDataStore.lastVisits) != len(want) {
+		t.Fatalf("expected %d visits stored; got %+v", len(want), mockDataStore.lastVisits)
+	}
+	for i, v := range mockDataStore.lastVisits {
+		if !v.Timestamp.Equal(want[i].Timestamp) || v.Page != want[i].Page || v.Referrer != want[i].Referrer {
+			t.Errorf("visit %d = %+v, want %+v", i, v, want[i])
+		}
+	}
+}
+
+func Test_batchHandler_Invalid(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{"Wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"Not JSON", http.MethodPost, `[{`, http.StatusBadRequest},
+		{"Not an array", http.MethodPost, `{"page": "blog"}`, http.StatusBadRequest},
+		{"Empty", http.MethodPost, `[]`, http.StatusBadRequest},
+		{"Too many", http.MethodPost, "[" + strings.Repeat(`{},`, maxBatchVisits) + "{}]", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{}
+			w := httptest.NewRecorder()
+			batchHandler(w, httptest.NewRequest(tt.method, batchPath, strings.NewReader(tt.body)), mockDataStore, loadClientTimeConfig(), realClock{})
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d; got %d", tt.expectedStatus, w.Code)
+			}
+			if mockDataStore.visitCount != 0 {
+				t.Error("expected a rejected batch not to be recorded")
+			}
+		})
+	}
+}
+
+func Test_batchHandler_WithoutConsent(t *testing.T) {
+	mockDataStore := &MockDataStore{}
+	body := `[{"page": "blog", "referrer": "https://github.com/", "utm_source": "newsletter"}]`
+	req := httptest.NewRequest(http.MethodPost, batchPath, strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), trackingConsentKey, &trackingConsent{}))
+	w := httptest.NewRecorder()
+
+	batchHandler(w, req, mockDataStore, loadClientTimeConfig(), realClock{})
+
+	if w.Code != http.StatusOK || len(mockDataStore.lastVisits) != 1 {
+		t.Fatalf("expected the visit to be recorded; got status %d", w.Code)
+	}
+	if v := mockDataStore.lastVisits[0]; v.Page != "blog" || v.Referrer != "" || v.UTM != (UTM{}) {
+		t.Errorf("expected only the page to be kept without consent; got %+v", v)
+	}
+}
+
+func Test_batchHandler_StoreError(t *testing.T) {
+	w := httptest.NewRecorder()
+	batchHandler(w, httptest.NewRequest(http.MethodPost, batchPath, strings.NewReader(`[{}]`)), failingStore{}, loadClientTimeConfig(), realClock{})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500; got %d", w.Code)
+	}
+}
@@ -0,0 +1,186 @@
+//go:build !no_bigquery
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	bigQueryEndpoint    = "https://bigquery.googleapis.com/bigquery/v2"
+	gceMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+func init() {
+	registerExportSink("bigquery", func() (visitSink, error) { return newBigQuerySinkFromEnv() })
+}
+
+// bigQuerySink streams visits into a BigQuery table with tabledata.insertAll. Each row's
+// insertId is derived from the visit ID, so BigQuery drops duplicates from retried batches.
+type bigQuerySink struct {
+	endpoint string
+	project  string
+	dataset  string
+	table    string
+	token    func(ctx context.Context) (string, error)
+	client   *http.Client
+}
+
+// newBigQuerySinkFromEnv reads BIGQUERY_PROJECT, BIGQUERY_DATASET and BIGQUERY_TABLE. Requests are
+// authorized with BIGQUERY_ACCESS_TOKEN when set, otherwise with the GCE metadata server's token
+// for the attached service account, as on Cloud Run.
+func newBigQuerySinkFromEnv() (*bigQuerySink, error) {
+	s := &bigQuerySink{
+		endpoint: bigQueryEndpoint,
+		project:  os.Getenv("BIGQUERY_PROJECT"),
+		dataset:  os.Getenv("BIGQUERY_DATASET"),
+		table:    os.Getenv("BIGQUERY_TABLE"),
+		client:   &http.Client{Timeout: exportSinkTimeout},
+	}
+	if s.project == "" || s.dataset == "" {
+		return nil, errors.New("BIGQUERY_PROJECT and BIGQUERY_DATASET must be set when EXPORT_SINK is bigquery")
+	}
+	if s.table == "" {
+		s.table = defaultExportTable
+	}
+
+	if token := os.Getenv("BIGQUERY_ACCESS_TOKEN"); token != "" {
+		s.token = func(context.Context) (string, error) { return token, nil }
+	} else {
+		s.token = newMetadataTokenSource(gceMetadataTokenURL, s.client).Token
+	}
+	return s, nil
+}
+
+func (s *bigQuerySink) Name() string {
+	return "bigquery"
+}
+
+// bigQueryInsertRow is one row of an insertAll request.
+type bigQueryInsertRow struct {
+	InsertID string        `json:"insertId"`
+	JSON     exportedVisit `json:"json"`
+}
+
+// bigQueryInsertResponse carries the per-row errors of an insertAll request.
+type bigQueryInsertResponse struct {
+	InsertErrors []struct {
+		Index  int `json:"index"`
+		Errors []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"insertErrors"`
+}
+
+// Write streams rows in a single insertAll request. Any rejected row fails the whole batch
+// so it is retried; rows that were accepted are deduplicated by insertId on the retry.
+func (s *bigQuerySink) Write(ctx context.Context, rows []VisitRow) error {
+	insert := struct {
+		Rows []bigQueryInsertRow `json:"rows"`
+	}{Rows: make([]bigQueryInsertRow, len(rows))}
+	for i, row := range rows {
+		insert.Rows[i] = bigQueryInsertRow{InsertID: "visit-" + strconv.FormatInt(row.ID, 10), JSON: newExportedVisit(row)}
+	}
+	body, err := json.Marshal(insert)
+	if err != nil {
+		return fmt.Errorf("failed to encode BigQuery rows: %w", err)
+	}
+
+	token, err := s.token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get BigQuery access token: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s/insertAll",
+		s.endpoint, url.PathEscape(s.project), url.PathEscape(s.dataset), url.PathEscape(s.table))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create BigQuery request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send visits to BigQuery: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("BigQuery returned status %d: %s", res.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	var result bigQueryInsertResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode BigQuery response: %w", err)
+	}
+	if n := len(result.InsertErrors); n > 0 {
+		first := result.InsertErrors[0]
+		reason := "unknown"
+		if len(first.Errors) > 0 {
+			reason = first.Errors[0].Reason + ": " + first.Errors[0].Message
+		}
+		return fmt.Errorf("BigQuery rejected %d rows, first at index %d (%s)", n, first.Index, reason)
+	}
+	return nil
+}
+
+// metadataTokenSource fetches and caches access tokens from the GCE metadata server.
+type metadataTokenSource struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newMetadataTokenSource(url string, client *http.Client) *metadataTokenSource {
+	return &metadataTokenSource{url: url, client: client}
+}
+
+// Token returns the cached token, refreshing it a minute before it expires.
+func (m *metadataTokenSource) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != "" && time.Now().Before(m.expires) {
+		return m.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d", res.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode metadata token: %w", err)
+	}
+	m.token = token.AccessToken
+	m.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return m.token, nil
+}
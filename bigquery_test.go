@@ -0,0 +1,110 @@
+//go:build !no_bigquery
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_bigQueryRegistered(t *testing.T) {
+	if _, ok := exportSinkFactories["bigquery"]; !ok {
+		t.Error("expected the bigquery sink to be registered unless built with no_bigquery")
+	}
+}
+
+func Test_newBigQuerySinkFromEnv(t *testing.T) {
+	if _, err := newBigQuerySinkFromEnv(); err == nil {
+		t.Error("expected an error without BIGQUERY_PROJECT and BIGQUERY_DATASET")
+	}
+
+	t.Setenv("BIGQUERY_PROJECT", "resume")
+	t.Setenv("BIGQUERY_DATASET", "analytics")
+	t.Setenv("BIGQUERY_ACCESS_TOKEN", "token")
+	s, err := newBigQuerySinkFromEnv()
+	if err != nil {
+		t.Fatalf("newBigQuerySinkFromEnv() error = %v", err)
+	}
+	if token, _ := s.token(context.Background()); token != "token" || s.table != defaultExportTable {
+		t.Errorf("expected the static token and default table; got %q, %q", token, s.table)
+	}
+}
+
+func Test_bigQuerySink_Write(t *testing.T) {
+	var path, auth string
+	var insert struct {
+		Rows []bigQueryInsertRow `json:"rows"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&insert); err != nil {
+			t.Errorf("could not decode insertAll body: %v", err)
+		}
+		w.Write([]byte(`{"kind": "bigquery#tableDataInsertAllResponse"}`))
+	}))
+	defer server.Close()
+
+	s := &bigQuerySink{
+		endpoint: server.URL,
+		project:  "resume",
+		dataset:  "analytics",
+		table:    "visits",
+		token:    func(context.Context) (string, error) { return "token", nil },
+		client:   server.Client(),
+	}
+	ts := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	if err := s.Write(context.Background(), []VisitRow{{ID: 42, Visit: Visit{Timestamp: ts}}}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if path != "/projects/resume/datasets/analytics/tables/visits/insertAll" || auth != "Bearer token" {
+		t.Errorf("unexpected request to %q with %q", path, auth)
+	}
+	if len(insert.Rows) != 1 || insert.Rows[0].InsertID != "visit-42" || insert.Rows[0].JSON.Timestamp != "2024-03-03T10:00:00Z" {
+		t.Errorf("expected one row keyed by visit ID; got %+v", insert.Rows)
+	}
+}
+
+func Test_bigQuerySink_Write_InsertErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"insertErrors": [{"index": 0, "errors": [{"reason": "invalid", "message": "no such field: referrer"}]}]}`))
+	}))
+	defer server.Close()
+
+	s := &bigQuerySink{
+		endpoint: server.URL,
+		token:    func(context.Context) (string, error) { return "token", nil },
+		client:   server.Client(),
+	}
+	if err := s.Write(context.Background(), []VisitRow{{ID: 1}}); err == nil {
+		t.Error("expected rejected rows to fail the batch")
+	}
+}
+
+func Test_metadataTokenSource(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"access_token": "ya29.token", "expires_in": 3599, "token_type": "Bearer"}`))
+	}))
+	defer server.Close()
+
+	m := newMetadataTokenSource(server.URL, server.Client())
+	for i := 0; i < 2; i++ {
+		token, err := m.Token(context.Background())
+		if err != nil || token != "ya29.token" {
+			t.Fatalf("Token() = %q, %v", token, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the token to be cached; got %d requests", requests)
+	}
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultVisitFlushInterval = time.Second
+	defaultVisitBufferLimit   = 100000
+
+	// visitBufferShutdownTimeout bounds the last flush on shutdown
+	visitBufferShutdownTimeout = 5 * time.Second
+
+	// Each process logs to its own directory under VISIT_WAL_DIR, locked while it runs
+	walProcessDirPrefix = "process-"
+	walClaimDirPrefix   = ".claim-"
+	walLockFile         = "lock"
+)
+
+// errVisitBufferFull is returned for visits that would take the buffer past its limit, which
+// happens when the database has been unreachable for a while.
+var errVisitBufferFull = errors.New("visit buffer is full")
+
+// walSyncMode is when the log is synced to disk.
+type walSyncMode string
+
+const (
+	walSyncAlways walSyncMode = "always" // before each visit is acknowledged
+	walSyncFlush  walSyncMode = "flush"  // when a flush takes the visits logged since the last
+)
+
+// bufferConfig controls recording visits in memory and writing them to the database in
+// batches.
+type bufferConfig struct {
+	Enabled       bool
+	FlushInterval time.Duration
+	Limit         int    // most visits buffered before new ones are refused
+	WALDir        string // where buffered visits are logged until written; none when empty
+	WALSync       walSyncMode
+}
+
+// loadBufferConfig reads VISIT_BUFFERING, which buffers visits when "true", along with
+// VISIT_FLUSH_INTERVAL, VISIT_BUFFER_LIMIT, VISIT_WAL_DIR and VISIT_WAL_SYNC.
+func loadBufferConfig() (bufferConfig, error) {
+	cfg := bufferConfig{FlushInterval: defaultVisitFlushInterval, Limit: defaultVisitBufferLimit, WALDir: os.Getenv("VISIT_WAL_DIR"), WALSync: walSyncAlways}
+	if v := os.Getenv("VISIT_BUFFERING"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return bufferConfig{}, fmt.Errorf("invalid VISIT_BUFFERING %q: must be true or false", v)
+		}
+		cfg.Enabled = enabled
+	}
+	if v := os.Getenv("VISIT_FLUSH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return bufferConfig{}, fmt.Errorf("invalid VISIT_FLUSH_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.FlushInterval = d
+	}
+	if v := os.Getenv("VISIT_BUFFER_LIMIT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return bufferConfig{}, fmt.Errorf("invalid VISIT_BUFFER_LIMIT %q: must be a positive number", v)
+		}
+		cfg.Limit = n
+	}
+	if v := os.Getenv("VISIT_WAL_SYNC"); v != "" {
+		switch mode := walSyncMode(v); mode {
+		case walSyncAlways, walSyncFlush:
+			cfg.WALSync = mode
+		default:
+			return bufferConfig{}, fmt.Errorf("invalid VISIT_WAL_SYNC %q: must be %s or %s", v, walSyncAlways, walSyncFlush)
+		}
+	}
+	return cfg, nil
+}
+
+// bufferedCountStore is a DataStore decorator that records visits in memory and writes them
+// to the database in one batch per flush interval, keeping the count in an atomic counter so
+// neither recording a visit nor reading the count waits on the database.
+//
+// The price is durability: visits not yet written are lost if the process dies, unless
+// VISIT_WAL_DIR is set. Each visit is then appended to a log segment before it is counted
+// and, by default, synced to disk before it is acknowledged, so not even a machine crash
+// loses it. Visits arriving while a sync runs share the next one, which keeps the cost of
+// syncing per burst rather than per visit. With VISIT_WAL_SYNC=flush, segments are only
+// synced when a flush takes them, and a machine crash loses the visits of the current
+// interval. Segments are deleted once their visits are written and replayed at startup
+// otherwise, so a crash between the two writes their visits twice.
+//
+// Each process logs to a directory of its own, which it holds a lock on until it exits. Only
+// the logs of processes that exited are replayed, at startup and on every flush interval, so
+// the process a graceful restart replaces keeps its logs while it drains, and whatever its
+// last flush fails to write is picked up once it is gone.
+//
+// Visits recorded with outbox messages are written straight through, keeping the outbox's
+// guarantees, and are counted from the next flush on.
+type bufferedCountStore struct {
+	DataStore
+	cfg   bufferConfig
+	count atomic.Int64 // visits in the database at the last flush, plus those buffered since
+
+	mu         sync.Mutex
+	pending    []Visit
+	wal        *os.File // the log segment of the visits buffered since the last flush
+	walSeq     int      // sequence number of wal; segments before it belong to earlier flushes
+	walWritten int64    // log writes so far, across segments
+	walDir     string   // this process's directory under WALDir
+	walLock    *os.File // held while the process runs
+
+	// syncMu is held while syncing the log, and taken before mu. Writes up to walSynced are
+	// on disk.
+	syncMu    sync.Mutex
+	walSynced int64
+}
+
+// newBufferedCountStore replays any visits logged by processes that have exited into ds, then
+// reads the count to start from.
+func newBufferedCountStore(ctx context.Context, ds DataStore, cfg bufferConfig) (*bufferedCountStore, error) {
+	s := &bufferedCountStore{DataStore: ds, cfg: cfg}
+	if cfg.WALDir != "" {
+		if err := os.MkdirAll(cfg.WALDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create WAL directory: %w", err)
+		}
+		if err := s.claimWALDir(); err != nil {
+			return nil, err
+		}
+		err := s.replayWAL(ctx)
+		if err == nil {
+			s.wal, err = s.openWALSegment(1)
+		}
+		if err == nil {
+			if err = syncDir(s.walDir); err != nil {
+				s.wal.Close()
+			}
+		}
+		if err != nil {
+			s.walLock.Close()
+			return nil, err
+		}
+		s.walSeq = 1
+	}
+	count, err := ds.GetVisitCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.count.Store(int64(count))
+	return s, nil
+}
+
+// IncrementVisitCount buffers the visit until the next flush.
+func (s *bufferedCountStore) IncrementVisitCount(ctx context.Context, visit Visit) error {
+	return s.record([]Visit{visit})
+}
+
+// IncrementVisitCounts buffers the visits until the next flush, all of them or none.
+func (s *bufferedCountStore) IncrementVisitCounts(ctx context.Context, visits []Visit) error {
+	if len(visits) == 0 {
+		return nil
+	}
+	return s.record(visits)
+}
+
+// GetVisitCount returns the count without querying the database.
+func (s *bufferedCountStore) GetVisitCount(ctx context.Context) (int, error) {
+	return int(s.count.Load()), nil
+}
+
+func (s *bufferedCountStore) record(visits []Visit) error {
+	written, err := s.buffer(visits)
+	if err != nil || written == 0 || s.cfg.WALSync != walSyncAlways {
+		return err
+	}
+	return s.syncWAL(written)
+}
+
+// buffer adds visits to the batch for the next flush, logging them first. It returns the
+// number of the log write, if there was one.
+func (s *bufferedCountStore) buffer(visits []Visit) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending)+len(visits) > s.cfg.Limit {
+		return 0, errVisitBufferFull
+	}
+	if s.wal != nil {
+		b := getResponseBuffer()
+		defer putResponseBuffer(b)
+		for _, v := range visits {
+			if err := b.json.Encode(newVisitLine(VisitRow{Visit: v})); err != nil {
+				return 0, fmt.Errorf("failed to log visit: %w", err)
+			}
+		}
+		if err := b.writeTo(s.wal); err != nil {
+			return 0, fmt.Errorf("failed to log visit: %w", err)
+		}
+		s.walWritten++
+	}
+	s.pending = append(s.pending, visits...)
+	s.count.Add(int64(len(visits)))
+	return s.walWritten, nil
+}
+
+// syncWAL returns once log write number written is on disk. A caller that had to wait for
+// another's sync usually finds its own write covered by it; otherwise its sync covers every
+// write made so far, including those of the callers waiting behind it.
+func (s *bufferedCountStore) syncWAL(written int64) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	if s.walSynced >= written {
+		return nil
+	}
+	s.mu.Lock()
+	wal, upTo := s.wal, s.walWritten
+	s.mu.Unlock()
+	if wal == nil {
+		return nil
+	}
+	if err := wal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL segment: %w", err)
+	}
+	s.walSynced = upTo
+	return nil
+}
+
+// Flush writes the buffered visits to the database in one batch, then refreshes the count
+// with the visits other replicas recorded. Visits it fails to write stay buffered for the
+// next flush.
+func (s *bufferedCountStore) Flush(ctx context.Context) error {
+	s.syncMu.Lock()
+	s.mu.Lock()
+	batch, flushedSeq := s.pending, s.walSeq
+	if err := s.rotateWAL(); err != nil {
+		s.mu.Unlock()
+		s.syncMu.Unlock()
+		return err
+	}
+	s.pending = nil
+	s.mu.Unlock()
+	s.syncMu.Unlock()
+
+	if len(batch) > 0 {
+		if err := s.DataStore.IncrementVisitCounts(ctx, batch); err != nil {
+			s.mu.Lock()
+			s.pending = append(batch, s.pending...)
+			s.mu.Unlock()
+			return err
+		}
+	}
+	if s.wal != nil {
+		if err := s.removeWALSegments(flushedSeq); err != nil {
+			log.Printf("Failed to remove flushed WAL segments: %v", err)
+		}
+	}
+
+	count, err := s.DataStore.GetVisitCount(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.count.Store(int64(count + len(s.pending)))
+	s.mu.Unlock()
+	return nil
+}
+
+// Run flushes the buffered visits every flush interval until ctx is done, and once more then.
+func (s *bufferedCountStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), visitBufferShutdownTimeout)
+			defer cancel()
+			err := s.Flush(flushCtx)
+			if err != nil {
+				s.mu.Lock()
+				log.Printf("Failed to flush buffered visits on shutdown, %d left unwritten: %v", len(s.pending), err)
+				s.mu.Unlock()
+			}
+			s.syncMu.Lock()
+			defer s.syncMu.Unlock()
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.wal != nil {
+				s.wal.Close()
+				s.wal = nil
+				// Logs left unwritten stay for the next process to replay once the lock is released
+				if err == nil {
+					if err := os.RemoveAll(s.walDir); err != nil {
+						log.Printf("Failed to remove WAL directory: %v", err)
+					}
+				}
+				s.walLock.Close()
+			}
+			return
+		case <-ticker.C:
+			if err := s.Flush(ctx); err != nil {
+				log.Printf("Failed to flush buffered visits: %v", err)
+			}
+			if s.wal != nil {
+				if err := s.replayWAL(ctx); err != nil {
+					log.Printf("Failed to replay WAL: %v", err)
+				}
+			}
+		}
+	}
+}
+
+func (s *bufferedCountStore) walPath(seq int) string {
+	return filepath.Join(s.walDir, fmt.Sprintf("visits-%020d.wal", seq))
+}
+
+// claimWALDir creates this process's log directory and locks it. The directory is locked
+// under a temporary name before it is renamed into place, so no other process finds it
+// unlocked and replays it.
+func (s *bufferedCountStore) claimWALDir() error {
+	claim, err := os.MkdirTemp(s.cfg.WALDir, walClaimDirPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL directory: %w", err)
+	}
+	lock, _, err := lockWALDir(claim)
+	if err != nil {
+		os.RemoveAll(claim)
+		return fmt.Errorf("failed to lock WAL directory: %w", err)
+	}
+	dir := filepath.Join(s.cfg.WALDir, walProcessDirPrefix+strings.TrimPrefix(filepath.Base(claim), walClaimDirPrefix))
+	if err := os.Rename(claim, dir); err != nil {
+		lock.Close()
+		os.RemoveAll(claim)
+		return fmt.Errorf("failed to create WAL directory: %w", err)
+	}
+	if err := syncDir(s.cfg.WALDir); err != nil {
+		lock.Close()
+		return err
+	}
+	s.walDir, s.walLock = dir, lock
+	return nil
+}
+
+func (s *bufferedCountStore) openWALSegment(seq int) (*os.File, error) {
+	f, err := os.OpenFile(s.walPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL segment: %w", err)
+	}
+	return f, nil
+}
+
+// rotateWAL syncs the current segment, which now holds the visits about to be flushed, and
+// starts the next one. The caller holds syncMu and mu.
+func (s *bufferedCountStore) rotateWAL() error {
+	if s.wal == nil {
+		return nil
+	}
+	next, err := s.openWALSegment(s.walSeq + 1)
+	if err != nil {
+		return err
+	}
+	err = s.wal.Sync()
+	if err == nil && s.cfg.WALSync == walSyncAlways {
+		// The new segment must survive a crash along with the visits logged to it
+		err = syncDir(s.cfg.WALDir)
+	}
+	if err != nil {
+		next.Close()
+		os.Remove(next.Name())
+		return fmt.Errorf("failed to sync WAL segment: %w", err)
+	}
+	s.wal.Close()
+	s.wal, s.walSeq, s.walSynced = next, s.walSeq+1, s.walWritten
+	return nil
+}
+
+// syncDir syncs a directory, so the files created in it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to sync WAL directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL directory: %w", err)
+	}
+	return nil
+}
+
+// walSegments lists the log segments in dir in order, with their sequence numbers.
+func walSegments(dir string) ([]string, []int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "visits-*.wal"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+	seqs := make([]int, 0, len(paths))
+	for _, path := range paths {
+		var seq int
+		if _, err := fmt.Sscanf(filepath.Base(path), "visits-%d.wal", &seq); err != nil {
+			return nil, nil, fmt.Errorf("unexpected WAL segment %s", path)
+		}
+		seqs = append(seqs, seq)
+	}
+	return paths, seqs, nil
+}
+
+// removeWALSegments deletes the segments numbered up to seq, whose visits are written.
+func (s *bufferedCountStore) removeWALSegments(seq int) error {
+	paths, seqs, err := walSegments(s.walDir)
+	if err != nil {
+		return err
+	}
+	for i, path := range paths {
+		if seqs[i] <= seq {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replayWAL writes the visits logged by processes that have exited, then deletes their logs.
+// The directories of processes still running, such as the one a graceful restart is
+// replacing, are locked and left to them.
+func (s *bufferedCountStore) replayWAL(ctx context.Context) error {
+	// Segments directly in WALDir were logged by versions without per-process directories
+	if err := s.replayWALDir(ctx, s.cfg.WALDir); err != nil {
+		return err
+	}
+	dirs, err := filepath.Glob(filepath.Join(s.cfg.WALDir, walProcessDirPrefix+"*"))
+	if err != nil {
+		return fmt.Errorf("failed to list WAL directories: %w", err)
+	}
+	for _, dir := range dirs {
+		if dir == s.walDir {
+			continue
+		}
+		lock, ok, err := lockWALDir(dir)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && !ok) {
+			continue // replayed by another process, or still in use
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock WAL directory: %w", err)
+		}
+		err = s.replayWALDir(ctx, dir)
+		if err == nil {
+			if err := os.RemoveAll(dir); err != nil {
+				log.Printf("Failed to remove replayed WAL directory: %v", err)
+			}
+		}
+		lock.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayWALDir writes the visits logged in dir in one batch, so a failure leaves them all to
+// be replayed again, then deletes the segments.
+func (s *bufferedCountStore) replayWALDir(ctx context.Context, dir string) error {
+	paths, _, err := walSegments(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list WAL segments: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	var visits []Visit
+	for _, path := range paths {
+		segment, err := readWALSegment(path)
+		if err != nil {
+			return err
+		}
+		visits = append(visits, segment...)
+	}
+	if len(visits) > 0 {
+		if err := s.DataStore.IncrementVisitCounts(ctx, visits); err != nil {
+			return fmt.Errorf("failed to replay WAL: %w", err)
+		}
+		log.Printf("Recovered %d buffered visits from %d WAL segments", len(visits), len(paths))
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove replayed WAL segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// readWALSegment parses a log segment. Every visit is logged with its newline, so a last line
+// without one was cut short by a crash and is dropped, losing only that visit.
+func readWALSegment(path string) ([]Visit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL segment: %w", err)
+	}
+	if torn := data[bytes.LastIndexByte(data, '\n')+1:]; len(torn) > 0 {
+		log.Printf("Dropping the torn last line of WAL segment %s", path)
+		data = data[:len(data)-len(torn)]
+	}
+	var visits []Visit
+	for i, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		v, err := parseBackfillVisit(line)
+		if err != nil {
+			return nil, fmt.Errorf("WAL segment %s line %d: %w", path, i+1, err)
+		}
+		visits = append(visits, v)
+	}
+	return visits, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyBatchStore fails IncrementVisitCounts while err is set.
+type flakyBatchStore struct {
+	MockDataStore
+	err error
+}
+
+func (s *flakyBatchStore) IncrementVisitCounts(ctx context.Context, visits []Visit) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.MockDataStore.IncrementVisitCounts(ctx, visits)
+}
+
+func Test_bufferedCountStore(t *testing.T) {
+	ctx := context.Background()
+	mockDataStore := &flakyBatchStore{MockDataStore: MockDataStore{visitCount: 10}}
+	s, err := newBufferedCountStore(ctx, mockDataStore, bufferConfig{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Visits are counted at once but only written on a flush
+	visit := Visit{Timestamp: time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC), Page: "blog"}
+	if err := s.IncrementVisitCount(ctx, visit); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.IncrementVisitCounts(ctx, []Visit{visit, visit}); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := s.GetVisitCount(ctx); count != 13 || mockDataStore.visitCount != 10 {
+		t.Fatalf("expected 13 counted and nothing written; got %d counted, %d written", count, mockDataStore.visitCount)
+	}
+	if err := s.IncrementVisitCount(ctx, visit); !errors.Is(err, errVisitBufferFull) {
+		t.Errorf("expected the buffer to be full; got %v", err)
+	}
+
+	// A failed flush keeps the visits for the next one
+	mockDataStore.err = errors.New("connection refused")
+	if err := s.Flush(ctx); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	mockDataStore.err = nil
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(mockDataStore.lastVisits) != 3 || mockDataStore.lastVisits[0] != visit {
+		t.Errorf("expected the 3 visits written in one batch; got %+v", mockDataStore.lastVisits)
+	}
+
+	// The flush picks up what other replicas wrote
+	mockDataStore.visitCount += 5
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := s.GetVisitCount(ctx); count != 18 {
+		t.Errorf("expected the count refreshed to 18; got %d", count)
+	}
+}
+
+func Test_bufferedCountStore_WAL(t *testing.T) {
+	ctx := context.Background()
+	cfg := bufferConfig{Limit: 100, WALDir: filepath.Join(t.TempDir(), "wal")}
+	visit := Visit{Timestamp: time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC), Referrer: "linkedin.com", UTM: UTM{Source: "newsletter"}}
+
+	first := &flakyBatchStore{}
+	s, err := newBufferedCountStore(ctx, first, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.IncrementVisitCount(ctx, visit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A flush that fails leaves its segment to be replayed
+	first.err = errors.New("connection refused")
+	if err := s.Flush(ctx); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	if err := s.IncrementVisitCount(ctx, visit); err != nil {
+		t.Fatal(err)
+	}
+
+	// The process dies mid-write, with the visits unwritten
+	segments, _ := filepath.Glob(filepath.Join(s.walDir, "*.wal"))
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":0,"timest`)
+	f.Close()
+	s.wal.Close()
+	s.walLock.Close()
+
+	// The next process writes them before counting
+	second := &MockDataStore{}
+	s, err = newBufferedCountStore(ctx, second, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.visitCount != 3 || len(second.lastVisits) != 3 || second.lastVisits[2] != visit {
+		t.Fatalf("expected the 3 logged visits replayed in one batch; got %+v", second.lastVisits)
+	}
+	if count, _ := s.GetVisitCount(ctx); count != 3 {
+		t.Errorf("expected a count of 3; got %d", count)
+	}
+
+	// Flushed visits leave no segment behind but the current one
+	if err := s.IncrementVisitCount(ctx, visit); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(cfg.WALDir, "*", "*.wal")); len(segments) != 1 || segments[0] != s.wal.Name() {
+		t.Errorf("expected only the current segment; got %v", segments)
+	}
+	s.wal.Close()
+	s.walLock.Close()
+}
+
+func Test_bufferedCountStore_WALSharedDir(t *testing.T) {
+	ctx := context.Background()
+	cfg := bufferConfig{FlushInterval: time.Hour, Limit: 100, WALDir: t.TempDir()}
+	visit := Visit{Timestamp: time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC), Page: "blog"}
+	ds := &MockDataStore{}
+
+	// A graceful restart starts the replacement while the old process still buffers visits
+	old, err := newBufferedCountStore(ctx, ds, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := old.IncrementVisitCount(ctx, visit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replacement, err := newBufferedCountStore(ctx, ds, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.visitCount != 0 {
+		t.Fatalf("expected the running process's visits left to it; got %d replayed", ds.visitCount)
+	}
+
+	// Both log side by side, with segment numbers of their own
+	if err := replacement.IncrementVisitCount(ctx, visit); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.IncrementVisitCount(ctx, visit); err != nil {
+		t.Fatal(err)
+	}
+	if err := replacement.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ds.visitCount != 4 {
+		t.Fatalf("expected each visit written once; got %d", ds.visitCount)
+	}
+
+	// The old process exits with a visit its last flush failed to write, which the
+	// replacement picks up on its next interval
+	old.wal.Close()
+	old.walLock.Close()
+	if err := replacement.replayWAL(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ds.visitCount != 5 {
+		t.Errorf("expected the old process's last visit replayed; got %d", ds.visitCount)
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(cfg.WALDir, walProcessDirPrefix+"*")); len(dirs) != 1 || dirs[0] != replacement.walDir {
+		t.Errorf("expected only the replacement's directory left; got %v", dirs)
+	}
+
+	// A clean shutdown leaves nothing behind
+	runCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	replacement.Run(runCtx)
+	if entries, _ := os.ReadDir(cfg.WALDir); len(entries) != 0 {
+		t.Errorf("expected an empty WAL directory; got %v", entries)
+	}
+}
+
+func Test_bufferedCountStore_WALSync(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []walSyncMode{walSyncAlways, walSyncFlush} {
+		t.Run(string(mode), func(t *testing.T) {
+			s, err := newBufferedCountStore(ctx, &MockDataStore{}, bufferConfig{Limit: 1000, WALDir: t.TempDir(), WALSync: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.walLock.Close()
+			defer s.wal.Close()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := s.IncrementVisitCount(ctx, Visit{Timestamp: time.Now()}); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			// Every acknowledged visit is on disk, or waits for the flush to sync it
+			synced := s.walSynced == s.walWritten
+			if synced != (mode == walSyncAlways) {
+				t.Errorf("unexpected %d of %d writes synced", s.walSynced, s.walWritten)
+			}
+			if err := s.Flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if s.walSynced != s.walWritten {
+				t.Errorf("expected the flush to sync all %d writes; got %d", s.walWritten, s.walSynced)
+			}
+		})
+	}
+}
+
+func Test_bufferedCountStore_Run(t *testing.T) {
+	mockDataStore := &MockDataStore{}
+	s, err := newBufferedCountStore(context.Background(), mockDataStore, bufferConfig{FlushInterval: time.Hour, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	if err := s.IncrementVisitCount(context.Background(), Visit{Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stopping flushes what is buffered, however long until the next interval
+	cancel()
+	<-done
+	if mockDataStore.visitCount != 1 {
+		t.Errorf("expected the visit written on shutdown; got %d", mockDataStore.visitCount)
+	}
+}
+
+func Test_loadBufferConfig(t *testing.T) {
+	cfg, err := loadBufferConfig()
+	if err != nil || cfg.Enabled || cfg.FlushInterval != defaultVisitFlushInterval || cfg.Limit != defaultVisitBufferLimit || cfg.WALSync != walSyncAlways {
+		t.Errorf("unexpected defaults %+v, %v", cfg, err)
+	}
+
+	t.Setenv("VISIT_BUFFERING", "true")
+	t.Setenv("VISIT_FLUSH_INTERVAL", "250ms")
+	t.Setenv("VISIT_WAL_DIR", "/var/lib/resume-backend")
+	t.Setenv("VISIT_WAL_SYNC", "flush")
+	cfg, err = loadBufferConfig()
+	if err != nil || !cfg.Enabled || cfg.FlushInterval != 250*time.Millisecond || cfg.WALDir != "/var/lib/resume-backend" || cfg.WALSync != walSyncFlush {
+		t.Errorf("unexpected config %+v, %v", cfg, err)
+	}
+
+	for env, v := range map[string]string{"VISIT_BUFFERING": "maybe", "VISIT_FLUSH_INTERVAL": "0s", "VISIT_BUFFER_LIMIT": "none", "VISIT_WAL_SYNC": "never"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, v)
+			if _, err := loadBufferConfig(); err == nil {
+				t.Errorf("expected an error for %s=%q", env, v)
+			}
+		})
+	}
+}
+
+func BenchmarkBufferedCountStore_IncrementVisitCount(b *testing.B) {
+	for _, mode := range []walSyncMode{walSyncAlways, walSyncFlush} {
+		b.Run(string(mode), func(b *testing.B) {
+			s, err := newBufferedCountStore(context.Background(), &MockDataStore{}, bufferConfig{Limit: b.N + 1, WALDir: b.TempDir(), WALSync: mode})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer s.walLock.Close()
+			defer s.wal.Close()
+			visit := Visit{Timestamp: time.Now(), Page: "blog", Referrer: "linkedin.com"}
+			b.ReportAllocs()
+			b.ResetTimer()
+			// Concurrent visits share syncs, as they do under load
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := s.IncrementVisitCount(context.Background(), visit); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
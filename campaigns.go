@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	campaignsPath         = "/api/campaigns"
+	defaultCampaignsLimit = 20
+	maxCampaignsLimit     = 100
+	maxUTMLength          = 100
+)
+
+// normalizeUTM trims and lowercases UTM values so "LinkedIn" and "linkedin " group together,
+// dropping control characters and truncating anything longer than maxUTMLength.
+func normalizeUTM(u UTM) UTM {
+	return UTM{
+		Source:   normalizeUTMValue(u.Source),
+		Medium:   normalizeUTMValue(u.Medium),
+		Campaign: normalizeUTMValue(u.Campaign),
+	}
+}
+
+func normalizeUTMValue(v string) string {
+	v = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, strings.TrimSpace(v))
+
+	if runes := []rune(v); len(runes) > maxUTMLength {
+		v = strings.TrimSpace(string(runes[:maxUTMLength]))
+	}
+	return v
+}
+
+// campaignsResponse is the body returned by GET /api/campaigns.
+type campaignsResponse struct {
+	Days      int             `json:"days"`
+	Campaigns []CampaignCount `json:"campaigns"`
+}
+
+// campaignsHandler returns visits per UTM source, medium and campaign over the last days days.
+func campaignsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	limit := defaultCampaignsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCampaignsLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxCampaignsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	now := clock.Now()
+	campaigns, err := dataStore.GetCampaignVisits(r.Context(), now.AddDate(0, 0, -days), now, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get campaigns: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if campaigns == nil {
+		campaigns = []CampaignCount{}
+	}
+
+	writeResponse(w, r, campaignsResponse{Days: days, Campaigns: campaigns})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_normalizeUTM(t *testing.T) {
+	got := normalizeUTM(UTM{
+		Source:   "  LinkedIn ",
+		Medium:   "Job\tApplication\n",
+		Campaign: strings.Repeat("x", maxUTMLength+10),
+	})
+
+	want := UTM{Source: "linkedin", Medium: "jobapplication", Campaign: strings.Repeat("x", maxUTMLength)}
+	if got != want {
+		t.Errorf("normalizeUTM() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_campaignsHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		wantDays       int
+		wantLimit      int
+	}{
+		{"Defaults", "", http.StatusOK, defaultStatsDays, defaultCampaignsLimit},
+		{"Custom window", "?days=90&limit=5", http.StatusOK, 90, 5},
+		{"Invalid days", "?days=abc", http.StatusBadRequest, 0, 0},
+		{"Invalid limit", "?limit=0", http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{campaigns: []CampaignCount{
+				{UTM: UTM{Source: "linkedin", Campaign: "acme-backend"}, Visits: 3},
+			}}
+			w := httptest.NewRecorder()
+			campaignsHandler(w, httptest.NewRequest(http.MethodGet, campaignsPath+tt.query, nil), mockDataStore, clock)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response campaignsResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if response.Days != tt.wantDays || len(response.Campaigns) != 1 || response.Campaigns[0].Campaign != "acme-backend" {
+				t.Errorf("unexpected response %+v", response)
+			}
+			if mockDataStore.lastLimit != tt.wantLimit {
+				t.Errorf("expected limit %d; got %d", tt.wantLimit, mockDataStore.lastLimit)
+			}
+		})
+	}
+}
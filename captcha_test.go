@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_loadCaptchaConfig(t *testing.T) {
+	if cfg := loadCaptchaConfig(); cfg.Verifier != nil {
+		t.Fatal("expected CAPTCHA verification to be off by default")
+	}
+
+	t.Setenv("CAPTCHA_PROVIDER", "recaptcha")
+	if cfg := loadCaptchaConfig(); cfg.Verifier != nil {
+		t.Error("expected CAPTCHA verification to stay off without a secret")
+	}
+
+	t.Setenv("CAPTCHA_SECRET", "secret")
+	t.Setenv("CAPTCHA_MIN_SCORE", "0.7")
+	t.Setenv("CAPTCHA_ROUTES", "/api/events, contact")
+	cfg := loadCaptchaConfig()
+	v, ok := cfg.Verifier.(*siteVerifyCaptcha)
+	if !ok || v.url != recaptchaVerifyURL || v.minScore != 0.7 {
+		t.Fatalf("expected a reCAPTCHA verifier with min score 0.7; got %+v", cfg.Verifier)
+	}
+	if !reflect.DeepEqual(cfg.Routes, map[string]bool{eventsPath: true}) {
+		t.Errorf("expected only the API path to be protected; got %v", cfg.Routes)
+	}
+
+	t.Setenv("CAPTCHA_PROVIDER", "hcaptcha")
+	if cfg := loadCaptchaConfig(); cfg.Verifier != nil {
+		t.Error("expected an unknown provider to leave verification off")
+	}
+}
+
+func Test_siteVerifyCaptcha_Verify(t *testing.T) {
+	tests := []struct {
+		name     string
+		minScore float64
+		status   int
+		body     string
+		want     bool
+		wantErr  bool
+	}{
+		{"Accepted", 0, http.StatusOK, `{"success": true}`, true, false},
+		{"Rejected", 0, http.StatusOK, `{"success": false, "error-codes": ["invalid-input-response"]}`, false, false},
+		{"Score above minimum", 0.5, http.StatusOK, `{"success": true, "score": 0.9}`, true, false},
+		{"Score below minimum", 0.5, http.StatusOK, `{"success": true, "score": 0.1}`, false, false},
+		{"Provider error", 0, http.StatusInternalServerError, ``, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				if r.PostForm.Get("secret") != "secret" || r.PostForm.Get("response") != "token" || r.PostForm.Get("remoteip") != "203.0.113.7" {
+					t.Errorf("unexpected form %v", r.PostForm)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			c := &siteVerifyCaptcha{name: "turnstile", url: server.URL, secret: "secret", minScore: tt.minScore, client: server.Client()}
+			got, err := c.Verify(context.Background(), "token", "203.0.113.7")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Verify() = %v, %v; want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// stubCaptcha returns a fixed verdict
+type stubCaptcha struct {
+	ok  bool
+	err error
+}
+
+func (stubCaptcha) Name() string {
+	return "stub"
+}
+
+func (c stubCaptcha) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	return c.ok, c.err
+}
+
+func Test_captchaMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier stubCaptcha
+		path     string
+		token    string
+		want     int
+		metric   string
+	}{
+		{"Passed", stubCaptcha{ok: true}, eventsPath, "token", http.StatusOK, "stub/passed"},
+		{"Failed", stubCaptcha{}, eventsPath, "token", http.StatusForbidden, "stub/failed"},
+		{"Missing token", stubCaptcha{ok: true}, eventsPath, "", http.StatusForbidden, "stub/failed"},
+		{"Provider down", stubCaptcha{err: errors.New("timeout")}, eventsPath, "token", http.StatusBadGateway, "stub/error"},
+		{"Unprotected route", stubCaptcha{}, apiPath, "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := useFakeMetrics(t)
+			handler := captchaMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}), captchaConfig{Verifier: tt.verifier, Routes: map[string]bool{eventsPath: true}})
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set(captchaHeader, tt.token)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("expected %d; got %d", tt.want, rr.Code)
+			}
+			var want []string
+			if tt.metric != "" {
+				want = []string{tt.metric}
+			}
+			if !reflect.DeepEqual(metrics.captchas, want) {
+				t.Errorf("expected metrics %v; got %v", want, metrics.captchas)
+			}
+		})
+	}
+}
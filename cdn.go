@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	purgePath = "/api/admin/purge"
+
+	cdnPurgeTimeout = 10 * time.Second
+
+	// defaultCDNPurgeInterval spaces out purges, so a burst of visits costs one purge
+	defaultCDNPurgeInterval = time.Second
+
+	// surrogateKeyVisits tags every response derived from visits, which each visit purges
+	surrogateKeyVisits = "visits"
+)
+
+// surrogateKeys are the cache keys of the GET responses a CDN may cache. Each response has
+// its own key for targeted purges, and a shared key for the data it derives from.
+var surrogateKeys = map[string][]string{
+	apiPath:          {surrogateKeyVisits, "count"},
+	countsPath:       {surrogateKeyVisits, "counts"},
+	uniqueCountPath:  {surrogateKeyVisits, "uniques"},
+	statsPath:        {surrogateKeyVisits, "stats"},
+	referrersPath:    {surrogateKeyVisits, "referrers"},
+	campaignsPath:    {surrogateKeyVisits, "campaigns"},
+	anomaliesPath:    {surrogateKeyVisits, "anomalies"},
+	sessionStatsPath: {"sessions", "session_stats"},
+	eventStatsPath:   {"events", "event_stats"},
+	funnelPath:       {"events", "funnel"},
+	projectsPath:     {"projects"},
+	resumePath:       {"resume"},
+	postsPath:        {"posts"},
+}
+
+func init() {
+	registerVisitProcessor("cdn_purge", newCDNPurgeProcessorFromEnv)
+}
+
+// middleware that tags cacheable GET responses with their surrogate keys, in the
+// Surrogate-Key header Fastly reads and the Cache-Tag header Cloudflare reads. A positive
+// ttl also lets the CDN, but not browsers, cache them for that long.
+func surrogateKeyMiddleware(next http.Handler, ttl time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if keys, ok := surrogateKeys[r.URL.Path]; ok && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			w.Header().Set("Surrogate-Key", strings.Join(keys, " "))
+			w.Header().Set("Cache-Tag", strings.Join(keys, ","))
+			if ttl > 0 {
+				maxAge := fmt.Sprintf("max-age=%d", int(ttl/time.Second))
+				w.Header().Set("Surrogate-Control", maxAge)
+				w.Header().Set("CDN-Cache-Control", maxAge)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loadCDNCacheTTL reads CDN_CACHE_TTL, how long the CDN may cache tagged responses; zero,
+// the default, leaves caching to the CDN's own rules.
+func loadCDNCacheTTL() time.Duration {
+	v := os.Getenv("CDN_CACHE_TTL")
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("Invalid CDN_CACHE_TTL %q, using 0", v)
+		return 0
+	}
+	return d
+}
+
+// allSurrogateKeys lists every key the API tags responses with.
+func allSurrogateKeys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, ks := range surrogateKeys {
+		for _, k := range ks {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cdnPurger invalidates cached responses by surrogate key.
+type cdnPurger interface {
+	Purge(ctx context.Context, keys []string) error
+}
+
+// fastlyPurger purges keys from a Fastly service.
+type fastlyPurger struct {
+	baseURL   string
+	serviceID string
+	token     string
+	client    *http.Client
+}
+
+func (p *fastlyPurger) Purge(ctx context.Context, keys []string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/service/"+p.serviceID+"/purge", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create purge request: %w", err)
+	}
+	req.Header.Set("Fastly-Key", p.token)
+	req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+	return doPurge(p.client, req)
+}
+
+// cloudflarePurger purges cache tags from a Cloudflare zone.
+type cloudflarePurger struct {
+	baseURL string
+	zoneID  string
+	token   string
+	client  *http.Client
+}
+
+func (p *cloudflarePurger) Purge(ctx context.Context, keys []string) error {
+	body, err := json.Marshal(map[string][]string{"tags": keys})
+	if err != nil {
+		return fmt.Errorf("failed to encode purge request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/zones/"+p.zoneID+"/purge_cache", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create purge request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	return doPurge(p.client, req)
+}
+
+func doPurge(client *http.Client, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send purge request: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("purge request returned status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// loadCDNPurger reads CDN_PROVIDER ("fastly" or "cloudflare") and the provider's
+// credentials: FASTLY_SERVICE_ID and FASTLY_API_TOKEN, or CLOUDFLARE_ZONE_ID and
+// CLOUDFLARE_API_TOKEN. It returns nil when CDN_PROVIDER is unset.
+func loadCDNPurger() (cdnPurger, error) {
+	client := &http.Client{Timeout: cdnPurgeTimeout}
+	switch provider := os.Getenv("CDN_PROVIDER"); provider {
+	case "":
+		return nil, nil
+	case "fastly":
+		p := &fastlyPurger{baseURL: "https://api.fastly.com", serviceID: os.Getenv("FASTLY_SERVICE_ID"), token: os.Getenv("FASTLY_API_TOKEN"), client: client}
+		if p.serviceID == "" || p.token == "" {
+			return nil, errors.New("FASTLY_SERVICE_ID and FASTLY_API_TOKEN are required when CDN_PROVIDER is fastly")
+		}
+		return p, nil
+	case "cloudflare":
+		p := &cloudflarePurger{baseURL: "https://api.cloudflare.com/client/v4", zoneID: os.Getenv("CLOUDFLARE_ZONE_ID"), token: os.Getenv("CLOUDFLARE_API_TOKEN"), client: client}
+		if p.zoneID == "" || p.token == "" {
+			return nil, errors.New("CLOUDFLARE_ZONE_ID and CLOUDFLARE_API_TOKEN are required when CDN_PROVIDER is cloudflare")
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown CDN_PROVIDER %q: must be fastly or cloudflare", provider)
+	}
+}
+
+// cdnPurgeProcessor purges the visit-derived responses after visits are recorded, at most
+// once per interval, so cached counts are never more than an interval out of date.
+type cdnPurgeProcessor struct {
+	purger   cdnPurger
+	interval time.Duration
+	pending  chan struct{}
+}
+
+// newCDNPurgeProcessorFromEnv purges the CDN after visits when CDN_PROVIDER is set.
+// CDN_PURGE_INTERVAL sets the minimum time between purges.
+func newCDNPurgeProcessorFromEnv(DataStore) (VisitProcessor, error) {
+	purger, err := loadCDNPurger()
+	if err != nil || purger == nil {
+		return nil, err
+	}
+	interval := defaultCDNPurgeInterval
+	if v := os.Getenv("CDN_PURGE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid CDN_PURGE_INTERVAL %q", v)
+		}
+		interval = d
+	}
+	return newCDNPurgeProcessor(purger, interval), nil
+}
+
+func newCDNPurgeProcessor(purger cdnPurger, interval time.Duration) *cdnPurgeProcessor {
+	return &cdnPurgeProcessor{purger: purger, interval: interval, pending: make(chan struct{}, 1)}
+}
+
+func (p *cdnPurgeProcessor) Name() string {
+	return "cdn_purge"
+}
+
+// ProcessVisit schedules a purge; visits arriving before it runs share it.
+func (p *cdnPurgeProcessor) ProcessVisit(ctx context.Context, visit Visit) error {
+	select {
+	case p.pending <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run purges scheduled keys until ctx is done.
+func (p *cdnPurgeProcessor) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.pending:
+		}
+
+		if err := p.purger.Purge(ctx, []string{surrogateKeyVisits}); err != nil {
+			errorLogger.Printf("Error purging CDN cache: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// purgeRequest is the optional body of POST /api/admin/purge.
+type purgeRequest struct {
+	Keys []string `json:"keys"` // surrogate keys to purge; all of them if empty
+}
+
+// purgeHandler lets operators purge cached responses, such as after fixing data by hand. It
+// needs the CDN_PURGE_TOKEN as a bearer token and answers 404 when purging isn't set up.
+func purgeHandler(w http.ResponseWriter, r *http.Request, purger cdnPurger, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if purger == nil || token == "" {
+		http.Error(w, "CDN purging is not enabled", http.StatusNotFound)
+		return
+	}
+	if !hasBearerToken(r, token) {
+		forbiddenLogger.Printf("Invalid purge token: %s %s", r.Method, r.URL.Path)
+		http.Error(w, "Missing or invalid purge token", http.StatusUnauthorized)
+		return
+	}
+
+	var req purgeRequest
+	if r.Body != nil {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVisitBodyBytes)).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, fmt.Sprintf("Invalid purge request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	keys := allSurrogateKeys()
+	if len(req.Keys) > 0 {
+		for _, k := range req.Keys {
+			if !slices.Contains(keys, k) {
+				http.Error(w, fmt.Sprintf("Unknown surrogate key %q", k), http.StatusBadRequest)
+				return
+			}
+		}
+		keys = req.Keys
+	}
+
+	if err := purger.Purge(r.Context(), keys); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to purge CDN cache: %v", err), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, map[string][]string{"purged": keys})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_surrogateKeyMiddleware(t *testing.T) {
+	handler := surrogateKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), 90*time.Second)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantKeys   string
+		wantTags   string
+		wantMaxAge string
+	}{
+		{"Count", http.MethodGet, apiPath, "visits count", "visits,count", "max-age=90"},
+		{"Funnel", http.MethodGet, funnelPath, "events funnel", "events,funnel", "max-age=90"},
+		{"Writes aren't cached", http.MethodPost, apiPath, "", "", ""},
+		{"Untagged path", http.MethodGet, csrfPath, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if got := w.Header().Get("Surrogate-Key"); got != tt.wantKeys {
+				t.Errorf("Surrogate-Key = %q, want %q", got, tt.wantKeys)
+			}
+			if got := w.Header().Get("Cache-Tag"); got != tt.wantTags {
+				t.Errorf("Cache-Tag = %q, want %q", got, tt.wantTags)
+			}
+			if got := w.Header().Get("Surrogate-Control"); got != tt.wantMaxAge {
+				t.Errorf("Surrogate-Control = %q, want %q", got, tt.wantMaxAge)
+			}
+			if got := w.Header().Get("CDN-Cache-Control"); got != tt.wantMaxAge {
+				t.Errorf("CDN-Cache-Control = %q, want %q", got, tt.wantMaxAge)
+			}
+		})
+	}
+}
+
+func Test_surrogateKeyMiddleware_NoTTL(t *testing.T) {
+	handler := surrogateKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), 0)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, statsPath, nil))
+
+	if w.Header().Get("Surrogate-Key") == "" {
+		t.Error("expected surrogate keys without a TTL")
+	}
+	if got := w.Header().Get("Surrogate-Control"); got != "" {
+		t.Errorf("expected no Surrogate-Control without a TTL; got %q", got)
+	}
+}
+
+func Test_fastlyPurger(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer server.Close()
+
+	p := &fastlyPurger{baseURL: server.URL, serviceID: "svc123", token: "secret", client: server.Client()}
+	if err := p.Purge(context.Background(), []string{"visits", "stats"}); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if got.Method != http.MethodPost || got.URL.Path != "/service/svc123/purge" {
+		t.Errorf("unexpected request %s %s", got.Method, got.URL.Path)
+	}
+	if got.Header.Get("Fastly-Key") != "secret" || got.Header.Get("Surrogate-Key") != "visits stats" {
+		t.Errorf("unexpected headers: %v", got.Header)
+	}
+}
+
+func Test_cloudflarePurger(t *testing.T) {
+	var body map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/zones/zone123/purge_cache" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected request %s with %v", r.URL.Path, r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("could not decode body: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	p := &cloudflarePurger{baseURL: server.URL, zoneID: "zone123", token: "secret", client: server.Client()}
+	if err := p.Purge(context.Background(), []string{"visits"}); err != nil {
+		t.Fatalf("Purge() error = %v", err)
+	}
+	if strings.Join(body["tags"], ",") != "visits" {
+		t.Errorf("expected the tags to be purged; got %v", body)
+	}
+}
+
+func Test_cdnPurger_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	p := &fastlyPurger{baseURL: server.URL, serviceID: "svc123", token: "wrong", client: server.Client()}
+	if err := p.Purge(context.Background(), []string{"visits"}); err == nil {
+		t.Error("expected an error for a non-2xx response")
+	}
+}
+
+func Test_loadCDNPurger(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"Disabled", nil, "<nil>", false},
+		{"Fastly", map[string]string{"CDN_PROVIDER": "fastly", "FASTLY_SERVICE_ID": "svc", "FASTLY_API_TOKEN": "t"}, "*main.fastlyPurger", false},
+		{"Cloudflare", map[string]string{"CDN_PROVIDER": "cloudflare", "CLOUDFLARE_ZONE_ID": "z", "CLOUDFLARE_API_TOKEN": "t"}, "*main.cloudflarePurger", false},
+		{"Missing credentials", map[string]string{"CDN_PROVIDER": "fastly"}, "<nil>", true},
+		{"Unknown provider", map[string]string{"CDN_PROVIDER": "akamai"}, "<nil>", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"CDN_PROVIDER", "FASTLY_SERVICE_ID", "FASTLY_API_TOKEN", "CLOUDFLARE_ZONE_ID", "CLOUDFLARE_API_TOKEN"} {
+				t.Setenv(k, tt.env[k])
+			}
+			p, err := loadCDNPurger()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadCDNPurger() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := fmt.Sprintf("%T", p); got != tt.want {
+				t.Errorf("loadCDNPurger() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingPurger records the keys it is asked to purge.
+type recordingPurger struct {
+	mu     sync.Mutex
+	purges [][]string
+	err    error
+	purged chan struct{}
+}
+
+func (p *recordingPurger) Purge(ctx context.Context, keys []string) error {
+	p.mu.Lock()
+	p.purges = append(p.purges, keys)
+	p.mu.Unlock()
+	if p.purged != nil {
+		p.purged <- struct{}{}
+	}
+	return p.err
+}
+
+func Test_cdnPurgeProcessor(t *testing.T) {
+	purger := &recordingPurger{purged: make(chan struct{}, 10)}
+	p := newCDNPurgeProcessor(purger, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Visits before the purge runs share it
+	for i := 0; i < 3; i++ {
+		if err := p.ProcessVisit(ctx, Visit{}); err != nil {
+			t.Fatalf("ProcessVisit() error = %v", err)
+		}
+	}
+	go p.Run(ctx)
+
+	select {
+	case <-purger.purged:
+	case <-time.After(time.Second):
+		t.Fatal("expected a purge after visits")
+	}
+	select {
+	case <-purger.purged:
+		t.Fatal("expected one purge for the burst of visits")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	purger.mu.Lock()
+	defer purger.mu.Unlock()
+	if len(purger.purges) != 1 || strings.Join(purger.purges[0], ",") != surrogateKeyVisits {
+		t.Errorf("expected the visits key to be purged once; got %v", purger.purges)
+	}
+}
+
+func Test_purgeHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		purger         cdnPurger
+		token          string
+		auth           string
+		body           string
+		expectedStatus int
+		wantKeys       string
+	}{
+		{"Everything", &recordingPurger{}, "admin", "Bearer admin", "", http.StatusOK, strings.Join(allSurrogateKeys(), ",")},
+		{"Some keys", &recordingPurger{}, "admin", "Bearer admin", `{"keys": ["stats", "count"]}`, http.StatusOK, "stats,count"},
+		{"Unknown key", &recordingPurger{}, "admin", "Bearer admin", `{"keys": ["other\nheader"]}`, http.StatusBadRequest, ""},
+		{"Wrong token", &recordingPurger{}, "admin", "Bearer guess", "", http.StatusUnauthorized, ""},
+		{"No token", &recordingPurger{}, "admin", "", "", http.StatusUnauthorized, ""},
+		{"Not configured", nil, "admin", "Bearer admin", "", http.StatusNotFound, ""},
+		{"No token configured", &recordingPurger{}, "", "Bearer ", "", http.StatusNotFound, ""},
+		{"CDN error", &recordingPurger{err: errors.New("rate limited")}, "admin", "Bearer admin", "", http.StatusBadGateway, strings.Join(allSurrogateKeys(), ",")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, purgePath, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			purgeHandler(w, req, tt.purger, tt.token)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			var purged []string
+			if rp, ok := tt.purger.(*recordingPurger); ok && len(rp.purges) > 0 {
+				purged = rp.purges[0]
+			}
+			if got := strings.Join(purged, ","); got != tt.wantKeys {
+				t.Errorf("expected %q purged; got %q", tt.wantKeys, got)
+			}
+		})
+	}
+}
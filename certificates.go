@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCertInterval    = time.Hour
+	defaultCertWarningDays = 14
+)
+
+// certAddress returns domain as the host:port to fetch its certificate from, port 443
+// unless it names one.
+func certAddress(domain string) (string, error) {
+	if _, _, err := net.SplitHostPort(domain); err != nil {
+		domain = net.JoinHostPort(domain, "443")
+	}
+	host, port, err := net.SplitHostPort(domain)
+	if err != nil || host == "" || strings.ContainsAny(host, "/ ") {
+		return "", fmt.Errorf("%q is not a host or host:port", domain)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("%q is not a host or host:port", domain)
+	}
+	return domain, nil
+}
+
+// certificateAlert is the detail sent along with a certificate expiry alert.
+type certificateAlert struct {
+	Domain        string    `json:"domain"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	DaysRemaining int       `json:"days_remaining"`
+}
+
+// daysUntil returns the whole days from now until t, negative once t has passed.
+func daysUntil(t, now time.Time) int {
+	return int(math.Floor(t.Sub(now).Hours() / 24))
+}
+
+// fetchCertificate reads the certificate domain serves. The handshake accepts any
+// certificate, so an expired or untrusted one still reports its expiry; whether it is
+// trusted is checked afterwards and recorded as the check's error.
+func (m *uptimeMonitor) fetchCertificate(ctx context.Context, domain string) CertificateCheck {
+	check := CertificateCheck{Domain: domain, CheckedAt: m.clock.Now()}
+	host, _, _ := net.SplitHostPort(domain)
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: m.cfg.Timeout},
+		Config:    &tls.Config{ServerName: host, InsecureSkipVerify: true},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", domain)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		check.Error = "no certificate presented"
+		return check
+	}
+	check.ExpiresAt = certs[0].NotAfter
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         m.certRoots,
+		Intermediates: intermediates,
+		CurrentTime:   check.CheckedAt,
+	})
+	if err != nil {
+		check.Error = fmt.Sprintf("certificate not trusted: %v", err)
+	}
+	return check
+}
+
+// CheckCertificates checks each of CertDomains, recording the results and reporting the days
+// left as a gauge. A certificate with fewer than CertWarningDays left is alerted once, until
+// it is replaced by one with a different expiry.
+func (m *uptimeMonitor) CheckCertificates(ctx context.Context) error {
+	var errs []error
+	for _, domain := range m.cfg.CertDomains {
+		check := m.fetchCertificate(ctx, domain)
+		if err := m.store.SaveCertificateCheck(ctx, check); err != nil {
+			errs = append(errs, err)
+		}
+		if check.ExpiresAt.IsZero() {
+			errorLogger.Printf("Error checking the certificate of %s: %s", domain, check.Error)
+			continue
+		}
+
+		appMetrics.CertificateExpiry(domain, check.ExpiresAt.Sub(check.CheckedAt).Hours()/24)
+		days := daysUntil(check.ExpiresAt, check.CheckedAt)
+		if days >= m.cfg.CertWarningDays || m.certWarned[domain].Equal(check.ExpiresAt) {
+			continue
+		}
+
+		text := fmt.Sprintf("TLS certificate of %s expires in %d days, on %s", domain, days, check.ExpiresAt.Format(time.RFC3339))
+		if days < 0 {
+			text = fmt.Sprintf("TLS certificate of %s expired on %s", domain, check.ExpiresAt.Format(time.RFC3339))
+		}
+		log.Println(text)
+		if m.notifier != nil {
+			alert := certificateAlert{Domain: domain, ExpiresAt: check.ExpiresAt, DaysRemaining: days}
+			if err := m.notifier.Notify(ctx, text, alert); err != nil {
+				// Retried on the next check
+				errorLogger.Printf("Error sending certificate alert: %v", err)
+				errs = append(errs, err)
+				continue
+			}
+		}
+		m.certWarned[domain] = check.ExpiresAt
+	}
+	return errors.Join(errs...)
+}
+
+// certificateResponse is a domain's certificate as GET /api/uptime returns it.
+type certificateResponse struct {
+	Domain        string     `json:"domain"`
+	CheckedAt     time.Time  `json:"checked_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`     // null when no certificate could be read
+	DaysRemaining *int       `json:"days_remaining"` // null when no certificate could be read
+	Error         string     `json:"error,omitempty"`
+}
+
+// certificateResponses returns checks with the days left from now.
+func certificateResponses(checks []CertificateCheck, now time.Time) []certificateResponse {
+	resp := make([]certificateResponse, len(checks))
+	for i, c := range checks {
+		resp[i] = certificateResponse{Domain: c.Domain, CheckedAt: c.CheckedAt, Error: c.Error}
+		if !c.ExpiresAt.IsZero() {
+			expires, days := c.ExpiresAt, daysUntil(c.ExpiresAt, now)
+			resp[i].ExpiresAt, resp[i].DaysRemaining = &expires, &days
+		}
+	}
+	return resp
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_certAddress(t *testing.T) {
+	for domain, want := range map[string]string{
+		"example.com":      "example.com:443",
+		"example.com:8443": "example.com:8443",
+		"127.0.0.1":        "127.0.0.1:443",
+		"[::1]:443":        "[::1]:443",
+	} {
+		if got, err := certAddress(domain); err != nil || got != want {
+			t.Errorf("certAddress(%q) = %q, %v; want %q", domain, got, err, want)
+		}
+	}
+	for _, domain := range []string{"", ":443", "https://example.com/", "example.com:https", "example.com:99999"} {
+		if got, err := certAddress(domain); err == nil {
+			t.Errorf("expected %q rejected, got %q", domain, got)
+		}
+	}
+}
+
+func Test_uptimeMonitor_CheckCertificates(t *testing.T) {
+	site := httptest.NewTLSServer(http.NotFoundHandler())
+	defer site.Close()
+	domain := site.Listener.Addr().String()
+	roots := x509.NewCertPool()
+	roots.AddCert(site.Certificate())
+
+	expires := site.Certificate().NotAfter
+	clock := newFakeClock(expires.Add(-30 * 24 * time.Hour))
+	metrics := useFakeMetrics(t)
+	mockDataStore := &MockDataStore{}
+	n := &recordingNotifier{}
+	m := newUptimeMonitor(mockDataStore, uptimeConfig{Timeout: time.Second, CertDomains: []string{domain}, CertWarningDays: 14}, clock)
+	m.notifier = n
+	m.certRoots = roots
+
+	if err := m.CheckCertificates(context.Background()); err != nil {
+		t.Fatalf("CheckCertificates() error = %v", err)
+	}
+	if c := mockDataStore.certChecks[0]; c.Domain != domain || !c.ExpiresAt.Equal(expires) || c.Error != "" {
+		t.Errorf("unexpected check recorded %+v", c)
+	}
+	if days := metrics.certExpiry[domain]; days != 30 {
+		t.Errorf("expected 30 days reported, got %v", days)
+	}
+	if len(n.texts) != 0 {
+		t.Errorf("expected no alert with 30 days left, got %q", n.texts)
+	}
+
+	// Below the threshold it's alerted once, retrying a failed alert
+	clock.Advance(20 * 24 * time.Hour)
+	n.err = errors.New("webhook down")
+	if err := m.CheckCertificates(context.Background()); err == nil {
+		t.Error("expected the notifier error returned")
+	}
+	n.err = nil
+	for range 2 {
+		if err := m.CheckCertificates(context.Background()); err != nil {
+			t.Fatalf("CheckCertificates() error = %v", err)
+		}
+	}
+	if len(n.texts) != 2 || !strings.Contains(n.texts[1], "expires in 10 days") {
+		t.Errorf("expected the expiry alerted once it went through, got %q", n.texts)
+	}
+	if len(mockDataStore.certChecks) != 1 {
+		t.Errorf("expected only the latest check kept, got %+v", mockDataStore.certChecks)
+	}
+}
+
+func Test_uptimeMonitor_CheckCertificates_Failures(t *testing.T) {
+	site := httptest.NewTLSServer(http.NotFoundHandler())
+	defer site.Close()
+	closed := httptest.NewTLSServer(http.NotFoundHandler())
+	closed.Close()
+	trusted, unreachable := site.Listener.Addr().String(), closed.Listener.Addr().String()
+
+	metrics := useFakeMetrics(t)
+	mockDataStore := &MockDataStore{}
+	m := newUptimeMonitor(mockDataStore, uptimeConfig{Timeout: time.Second, CertDomains: []string{trusted, unreachable}, CertWarningDays: 14}, newFakeClock(time.Now()))
+	if err := m.CheckCertificates(context.Background()); err != nil {
+		t.Fatalf("CheckCertificates() error = %v", err)
+	}
+
+	// The test server's certificate isn't in the system roots, but its expiry is still read
+	checks := mockDataStore.certChecks
+	if len(checks) != 2 {
+		t.Fatalf("expected both domains recorded, got %+v", checks)
+	}
+	for _, c := range checks {
+		switch c.Domain {
+		case trusted:
+			if c.ExpiresAt.IsZero() || !strings.HasPrefix(c.Error, "certificate not trusted") {
+				t.Errorf("expected an untrusted certificate with its expiry, got %+v", c)
+			}
+		case unreachable:
+			if !c.ExpiresAt.IsZero() || c.Error == "" {
+				t.Errorf("expected an unreachable domain without an expiry, got %+v", c)
+			}
+		}
+	}
+	if _, ok := metrics.certExpiry[unreachable]; ok || len(metrics.certExpiry) != 1 {
+		t.Errorf("expected no gauge for the unreachable domain, got %v", metrics.certExpiry)
+	}
+
+	m = newUptimeMonitor(failingStore{}, m.cfg, newFakeClock(time.Now()))
+	if err := m.CheckCertificates(context.Background()); err == nil {
+		t.Error("expected the store error returned")
+	}
+}
+
+func Test_certificateResponses(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	got := certificateResponses([]CertificateCheck{
+		{Domain: "example.com:443", CheckedAt: now, ExpiresAt: now.Add(36 * time.Hour)},
+		{Domain: "old.example.com:443", CheckedAt: now, ExpiresAt: now.Add(-time.Hour), Error: "certificate not trusted: expired"},
+		{Domain: "down.example.com:443", CheckedAt: now, Error: "connection refused"},
+	}, now)
+	if *got[0].DaysRemaining != 1 || *got[1].DaysRemaining != -1 || got[2].ExpiresAt != nil || got[2].DaysRemaining != nil {
+		t.Errorf("unexpected responses %+v", got)
+	}
+}
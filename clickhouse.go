@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultExportTable = "visits"
+	exportSinkTimeout  = time.Minute
+)
+
+func init() {
+	registerExportSink("clickhouse", func() (visitSink, error) { return newClickHouseSinkFromEnv() })
+}
+
+// exportTablePattern keeps configured table names safe to interpolate into queries
+var exportTablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// clickHouseSink inserts visits through the ClickHouse HTTP interface as JSONEachRow.
+// Retried batches are deduplicated when the table is a ReplacingMergeTree ordered by id.
+type clickHouseSink struct {
+	url      string // e.g. https://host:8443
+	table    string
+	user     string
+	password string
+	client   *http.Client
+}
+
+// newClickHouseSinkFromEnv reads CLICKHOUSE_URL, CLICKHOUSE_TABLE, CLICKHOUSE_USER and CLICKHOUSE_PASSWORD.
+func newClickHouseSinkFromEnv() (*clickHouseSink, error) {
+	s := &clickHouseSink{
+		url:      os.Getenv("CLICKHOUSE_URL"),
+		table:    os.Getenv("CLICKHOUSE_TABLE"),
+		user:     os.Getenv("CLICKHOUSE_USER"),
+		password: os.Getenv("CLICKHOUSE_PASSWORD"),
+		client:   &http.Client{Timeout: exportSinkTimeout},
+	}
+	if s.url == "" {
+		return nil, errors.New("CLICKHOUSE_URL must be set when EXPORT_SINK is clickhouse")
+	}
+	if s.table == "" {
+		s.table = defaultExportTable
+	}
+	if !exportTablePattern.MatchString(s.table) {
+		return nil, fmt.Errorf("invalid CLICKHOUSE_TABLE %q", s.table)
+	}
+	return s, nil
+}
+
+func (s *clickHouseSink) Name() string {
+	return "clickhouse"
+}
+
+// Write inserts rows in a single request.
+func (s *clickHouseSink) Write(ctx context.Context, rows []VisitRow) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, row := range rows {
+		if err := enc.Encode(newExportedVisit(row)); err != nil {
+			return fmt.Errorf("failed to encode visit %d: %w", row.ID, err)
+		}
+	}
+
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.table))
+	query.Set("date_time_input_format", "best_effort") // accept RFC 3339 timestamps
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/?"+query.Encode(), &body)
+	if err != nil {
+		return fmt.Errorf("failed to create ClickHouse request: %w", err)
+	}
+	if s.user != "" {
+		req.Header.Set("X-ClickHouse-User", s.user)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send visits to ClickHouse: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("ClickHouse returned status %d: %s", res.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_newClickHouseSinkFromEnv(t *testing.T) {
+	if _, err := newClickHouseSinkFromEnv(); err == nil {
+		t.Error("expected an error without CLICKHOUSE_URL")
+	}
+
+	t.Setenv("CLICKHOUSE_URL", "https://clickhouse.example.com:8443")
+	s, err := newClickHouseSinkFromEnv()
+	if err != nil || s.table != defaultExportTable {
+		t.Errorf("expected the default table; got %v, %v", s, err)
+	}
+
+	t.Setenv("CLICKHOUSE_TABLE", "analytics.visits; DROP TABLE x")
+	if _, err := newClickHouseSinkFromEnv(); err == nil {
+		t.Error("expected an error for an unsafe table name")
+	}
+}
+
+func Test_clickHouseSink_Write(t *testing.T) {
+	var query, user string
+	var rows []exportedVisit
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, user = r.URL.Query().Get("query"), r.Header.Get("X-ClickHouse-User")
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var row exportedVisit
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Errorf("invalid JSONEachRow line %q: %v", scanner.Text(), err)
+			}
+			rows = append(rows, row)
+		}
+	}))
+	defer server.Close()
+
+	s := &clickHouseSink{url: server.URL, table: "analytics.visits", user: "exporter", password: "secret", client: server.Client()}
+	ts := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	err := s.Write(context.Background(), []VisitRow{{ID: 1, Visit: Visit{Timestamp: ts}}, {ID: 2, Visit: Visit{Timestamp: ts, Referrer: "github.com"}}})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if query != "INSERT INTO analytics.visits FORMAT JSONEachRow" || user != "exporter" {
+		t.Errorf("unexpected query %q as user %q", query, user)
+	}
+	if len(rows) != 2 || rows[1].ID != 2 || rows[1].Referrer != "github.com" {
+		t.Errorf("expected both rows to be inserted; got %+v", rows)
+	}
+}
+
+func Test_clickHouseSink_Write_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Code: 60. DB::Exception: Table analytics.visits doesn't exist", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	s := &clickHouseSink{url: server.URL, table: "analytics.visits", client: server.Client()}
+	if err := s.Write(context.Background(), []VisitRow{{ID: 1}}); err == nil {
+		t.Error("expected an error for a failed insert")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultClientClockSkew       = 5 * time.Minute
+	defaultClientTimestampMaxAge = 7 * 24 * time.Hour // how long a visit can sit in the frontend's offline queue
+	clientClockSkewEnv           = "CLIENT_CLOCK_SKEW"
+	clientTimestampMaxAgeEnv     = "CLIENT_TIMESTAMP_MAX_AGE"
+)
+
+// Violations counted by ClientTimestampViolation
+const (
+	timestampFuture = "future"  // further ahead than the skew allows; rejected
+	timestampSkewed = "skewed"  // ahead, but within the skew; moved to now
+	timestampTooOld = "too_old" // older than the max age or the retained visits; rejected
+)
+
+// clientTimeConfig bounds the times clients supply for what they recorded, such as the
+// visits the frontend queued while offline.
+type clientTimeConfig struct {
+	Skew            time.Duration // how far ahead a client's clock may run
+	MaxAge          time.Duration // how far back a client time may go
+	RetentionMonths int           // months of visits kept, from VISITS_RETENTION_MONTHS; 0 keeps them all
+}
+
+// loadClientTimeConfig reads CLIENT_CLOCK_SKEW (default 5m) and CLIENT_TIMESTAMP_MAX_AGE
+// (default 168h), along with the visit retention so times in dropped months are rejected.
+func loadClientTimeConfig() clientTimeConfig {
+	cfg := clientTimeConfig{Skew: defaultClientClockSkew, MaxAge: defaultClientTimestampMaxAge}
+	if v := os.Getenv(clientClockSkewEnv); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Invalid %s %q, using %s", clientClockSkewEnv, v, cfg.Skew)
+		} else {
+			cfg.Skew = d
+		}
+	}
+	if v := os.Getenv(clientTimestampMaxAgeEnv); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid %s %q, using %s", clientTimestampMaxAgeEnv, v, cfg.MaxAge)
+		} else {
+			cfg.MaxAge = d
+		}
+	}
+	// An invalid partitioning configuration stops the server in main
+	if partitions, err := loadPartitionConfig(); err == nil {
+		cfg.RetentionMonths = partitions.RetentionMonths
+	}
+	return cfg
+}
+
+// check returns the time to store for t, a time a client supplied to endpoint. Times ahead
+// of now by no more than the skew are moved to now, so nothing is stored in the future; times
+// further ahead, or too old to be kept, are rejected. Each adjustment or rejection is counted.
+func (c clientTimeConfig) check(endpoint string, t, now time.Time) (time.Time, error) {
+	switch {
+	case t.After(now.Add(c.Skew)):
+		appMetrics.ClientTimestampViolation(endpoint, timestampFuture)
+		return time.Time{}, fmt.Errorf("timestamp is in the future")
+	case t.After(now):
+		appMetrics.ClientTimestampViolation(endpoint, timestampSkewed)
+		return now, nil
+	case t.Before(now.Add(-c.MaxAge)):
+		appMetrics.ClientTimestampViolation(endpoint, timestampTooOld)
+		return time.Time{}, fmt.Errorf("timestamp is older than %s", c.MaxAge)
+	case c.RetentionMonths > 0 && t.Before(retentionCutoff(now, c.RetentionMonths)):
+		appMetrics.ClientTimestampViolation(endpoint, timestampTooOld)
+		return time.Time{}, fmt.Errorf("timestamp is older than the %d months of visits kept", c.RetentionMonths)
+	}
+	return t, nil
+}
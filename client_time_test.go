@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_loadClientTimeConfig(t *testing.T) {
+	t.Setenv(clientClockSkewEnv, "30s")
+	t.Setenv(clientTimestampMaxAgeEnv, "-1h")
+	t.Setenv("VISITS_PARTITIONING", "true")
+	t.Setenv("VISITS_RETENTION_MONTHS", "3")
+	cfg := loadClientTimeConfig()
+	want := clientTimeConfig{Skew: 30 * time.Second, MaxAge: defaultClientTimestampMaxAge, RetentionMonths: 3}
+	if cfg != want {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+func Test_clientTimeConfig_check(t *testing.T) {
+	m := useFakeMetrics(t)
+	now := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+	cfg := clientTimeConfig{Skew: time.Minute, MaxAge: 40 * 24 * time.Hour, RetentionMonths: 1}
+
+	tests := []struct {
+		name    string
+		t       time.Time
+		want    time.Time
+		wantErr bool
+	}{
+		{"Past", now.Add(-time.Hour), now.Add(-time.Hour), false},
+		{"Slightly ahead", now.Add(30 * time.Second), now, false},
+		{"Future", now.Add(2 * time.Minute), time.Time{}, true},
+		{"Too old", now.AddDate(0, 0, -41), time.Time{}, true},
+		// Within the max age, but in January, which retention has dropped
+		{"Before retention", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.check(batchPath, tt.t, now)
+			if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+				t.Errorf("expected %v (error %v), got %v (%v)", tt.want, tt.wantErr, got, err)
+			}
+		})
+	}
+
+	want := []string{batchPath + "/skewed", batchPath + "/future", batchPath + "/too_old", batchPath + "/too_old"}
+	if !reflect.DeepEqual(m.timestampViolations, want) {
+		t.Errorf("expected violations %v, got %v", want, m.timestampViolations)
+	}
+}
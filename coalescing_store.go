@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultCoalescedQueryTimeout bounds the shared count query when the store sets no timeout
+const defaultCoalescedQueryTimeout = 30 * time.Second
+
+// coalescingStore wraps a DataStore so concurrent GetVisitCount calls share a single query.
+type coalescingStore struct {
+	DataStore
+	timeout time.Duration
+	group   singleflight.Group
+}
+
+// newCoalescingStore decorates ds with singleflight coalescing of count queries, each
+// given timeout to finish, or defaultCoalescedQueryTimeout when it is 0.
+func newCoalescingStore(ds DataStore, timeout time.Duration) *coalescingStore {
+	if timeout <= 0 {
+		timeout = defaultCoalescedQueryTimeout
+	}
+	return &coalescingStore{DataStore: ds, timeout: timeout}
+}
+
+// GetVisitCount joins an in-flight count query if there is one, otherwise starts it.
+// The shared query isn't tied to any one caller's cancellation, but has its own timeout
+// so a hung query isn't joined forever; each caller still stops waiting when its own
+// context is done.
+func (s *coalescingStore) GetVisitCount(ctx context.Context) (int, error) {
+	ch := s.group.DoChan("visit_count", func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.timeout)
+		defer cancel()
+		return s.DataStore.GetVisitCount(ctx)
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return 0, res.Err
+		}
+		return res.Val.(int), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingStore counts GetVisitCount calls and blocks each one until release is closed.
+type blockingStore struct {
+	MockDataStore
+	calls   atomic.Int32
+	entered chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (s *blockingStore) GetVisitCount(ctx context.Context) (int, error) {
+	if s.calls.Add(1) == 1 {
+		close(s.entered)
+	}
+	select {
+	case <-s.release:
+		return 42, s.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func Test_coalescingStore_GetVisitCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		want    int
+		wantErr bool
+	}{
+		{"success", nil, 42, false},
+		{"error", errors.New("query error"), 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &blockingStore{entered: make(chan struct{}), release: make(chan struct{}), err: tt.err}
+			s := newCoalescingStore(inner, 0)
+
+			const burst = 20
+			var wg sync.WaitGroup
+			results := make(chan error, burst)
+			counts := make(chan int, burst)
+			for i := 0; i < burst; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					count, err := s.GetVisitCount(context.Background())
+					counts <- count
+					results <- err
+				}()
+			}
+
+			// Give the burst time to join the in-flight query before it completes
+			<-inner.entered
+			time.Sleep(50 * time.Millisecond)
+			close(inner.release)
+			wg.Wait()
+			close(results)
+			close(counts)
+
+			if got := inner.calls.Load(); got != 1 {
+				t.Errorf("expected 1 query per burst, got %d", got)
+			}
+			for err := range results {
+				if (err != nil) != tt.wantErr {
+					t.Errorf("GetVisitCount() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			}
+			for count := range counts {
+				if count != tt.want {
+					t.Errorf("GetVisitCount() = %d, want %d", count, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_coalescingStore_CallerCancellation(t *testing.T) {
+	inner := &blockingStore{entered: make(chan struct{}), release: make(chan struct{})}
+	defer close(inner.release)
+	s := newCoalescingStore(inner, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-inner.entered
+		cancel()
+	}()
+
+	if _, err := s.GetVisitCount(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func Test_coalescingStore_SharedTimeout(t *testing.T) {
+	inner := &blockingStore{entered: make(chan struct{}), release: make(chan struct{})}
+	defer close(inner.release)
+	s := newCoalescingStore(inner, 20*time.Millisecond)
+
+	// The hung query times out, so the next caller starts a new one instead of joining it
+	for i := 1; i <= 2; i++ {
+		if _, err := s.GetVisitCount(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+		if got := inner.calls.Load(); got != int32(i) {
+			t.Errorf("expected %d queries, got %d", i, got)
+		}
+	}
+}
+
+func Test_coalescingStore_DelegatesWrites(t *testing.T) {
+	inner := &MockDataStore{}
+	s := newCoalescingStore(inner, 0)
+
+	if err := s.IncrementVisitCount(context.Background(), Visit{Timestamp: time.Now()}); err != nil {
+		t.Fatalf("IncrementVisitCount() error = %v", err)
+	}
+	if inner.visitCount != 1 {
+		t.Errorf("expected increment to reach the wrapped store, got %d", inner.visitCount)
+	}
+}
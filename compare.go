@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+const compareStatsPath = "/api/stats/compare"
+
+// periodComparison is one period's visits so far against the same stretch of the period
+// before it.
+type periodComparison struct {
+	Current       int      `json:"current"`
+	Previous      int      `json:"previous"`
+	ChangePercent *float64 `json:"change_percent"` // null when the previous period had no visits
+	Trend         string   `json:"trend"`          // "up", "down" or "flat"
+}
+
+// compareResponse is the body returned by GET /api/stats/compare.
+type compareResponse struct {
+	Week     periodComparison `json:"week"`
+	Month    periodComparison `json:"month"`
+	Timezone string           `json:"timezone"`
+}
+
+// newPeriodComparison works out the change from previous to current.
+func newPeriodComparison(current, previous int) periodComparison {
+	c := periodComparison{Current: current, Previous: previous, Trend: "flat"}
+	switch {
+	case current > previous:
+		c.Trend = "up"
+	case current < previous:
+		c.Trend = "down"
+	}
+	if previous > 0 {
+		change := math.Round(float64(current-previous)/float64(previous)*1000) / 10
+		c.ChangePercent = &change
+	}
+	return c
+}
+
+// comparisonRanges returns this week and month so far and the same stretches of last week
+// and month, in that order. Weeks start on Monday. Last month's stretch stops at its end
+// when it is shorter than this month so far.
+func comparisonRanges(now time.Time) []TimeRange {
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	weekStart := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+
+	lastMonthStart := monthStart.AddDate(0, -1, 0)
+	lastMonthEnd := time.Date(lastMonthStart.Year(), lastMonthStart.Month(), now.Day(),
+		now.Hour(), now.Minute(), now.Second(), now.Nanosecond(), loc)
+	if lastMonthEnd.After(monthStart) {
+		lastMonthEnd = monthStart
+	}
+
+	return []TimeRange{
+		{From: weekStart, To: now},
+		{From: weekStart.AddDate(0, 0, -7), To: now.AddDate(0, 0, -7)},
+		{From: monthStart, To: now},
+		{From: lastMonthStart, To: lastMonthEnd},
+	}
+}
+
+// compareStatsHandler compares the visits of this week and this month so far with the same
+// stretch of the week and month before, so a partial period is never set against a whole
+// one. Weeks and months are calendar ones in the tz query parameter, as for the stats.
+func compareStatsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	loc, err := parseTimezone(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	counts, err := dataStore.GetVisitCountsInRanges(r.Context(), comparisonRanges(clock.Now().In(loc)))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get visit counts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, r, compareResponse{
+		Week:     newPeriodComparison(counts[0], counts[1]),
+		Month:    newPeriodComparison(counts[2], counts[3]),
+		Timezone: loc.String(),
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_comparisonRanges(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("could not load location: %v", err)
+	}
+	date := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, loc)
+	}
+
+	for _, tt := range []struct {
+		name string
+		now  time.Time
+		want []TimeRange
+	}{
+		{
+			name: "midweek",
+			now:  date(time.March, 6, 15), // a Wednesday
+			want: []TimeRange{
+				{From: date(time.March, 4, 0), To: date(time.March, 6, 15)},
+				{From: date(time.February, 26, 0), To: date(time.February, 28, 15)},
+				{From: date(time.March, 1, 0), To: date(time.March, 6, 15)},
+				{From: date(time.February, 1, 0), To: date(time.February, 6, 15)},
+			},
+		},
+		{
+			name: "sunday at the end of a long month",
+			now:  date(time.March, 31, 15),
+			want: []TimeRange{
+				{From: date(time.March, 25, 0), To: date(time.March, 31, 15)},
+				{From: date(time.March, 18, 0), To: date(time.March, 24, 15)},
+				{From: date(time.March, 1, 0), To: date(time.March, 31, 15)},
+				{From: date(time.February, 1, 0), To: date(time.March, 1, 0)}, // all of February
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := comparisonRanges(tt.now)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d ranges; got %+v", len(tt.want), got)
+			}
+			for i := range got {
+				if !got[i].From.Equal(tt.want[i].From) || !got[i].To.Equal(tt.want[i].To) {
+					t.Errorf("range %d: expected [%s, %s); got [%s, %s)", i, tt.want[i].From, tt.want[i].To, got[i].From, got[i].To)
+				}
+			}
+		})
+	}
+}
+
+func Test_newPeriodComparison(t *testing.T) {
+	for _, tt := range []struct {
+		current, previous int
+		change            float64
+		trend             string
+	}{
+		{120, 100, 20, "up"},
+		{2, 3, -33.3, "down"},
+		{7, 7, 0, "flat"},
+	} {
+		c := newPeriodComparison(tt.current, tt.previous)
+		if c.ChangePercent == nil || *c.ChangePercent != tt.change || c.Trend != tt.trend {
+			t.Errorf("%d against %d: expected %v%% %s; got %+v", tt.current, tt.previous, tt.change, tt.trend, c)
+		}
+	}
+
+	// There is no percentage change from nothing
+	if c := newPeriodComparison(5, 0); c.ChangePercent != nil || c.Trend != "up" {
+		t.Errorf("expected no change percent; got %+v", c)
+	}
+}
+
+func Test_compareStatsHandler(t *testing.T) {
+	mockDataStore := &MockDataStore{rangeCounts: []int{30, 20, 90, 100}}
+
+	rr := httptest.NewRecorder()
+	now := time.Date(2024, 3, 6, 14, 0, 0, 0, time.UTC)
+	compareStatsHandler(rr, httptest.NewRequest(http.MethodGet, compareStatsPath+"?tz=Europe/Berlin", nil), mockDataStore, newFakeClock(now))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp compareResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if resp.Week.Current != 30 || resp.Week.Previous != 20 || *resp.Week.ChangePercent != 50 || resp.Week.Trend != "up" {
+		t.Errorf("unexpected week %+v", resp.Week)
+	}
+	if resp.Month.Current != 90 || resp.Month.Previous != 100 || *resp.Month.ChangePercent != -10 || resp.Month.Trend != "down" {
+		t.Errorf("unexpected month %+v", resp.Month)
+	}
+	if resp.Timezone != "Europe/Berlin" || len(mockDataStore.lastRanges) != 4 || mockDataStore.lastRanges[0].From.Location().String() != "Europe/Berlin" {
+		t.Errorf("expected the ranges in the requested zone; got %s, %+v", resp.Timezone, mockDataStore.lastRanges)
+	}
+}
+
+func Test_compareStatsHandler_InvalidRequest(t *testing.T) {
+	for _, tt := range []struct {
+		method, url string
+		status      int
+	}{
+		{http.MethodPost, compareStatsPath, http.StatusMethodNotAllowed},
+		{http.MethodGet, compareStatsPath + "?tz=Not/AZone", http.StatusBadRequest},
+		{http.MethodGet, compareStatsPath + "?tz=Local", http.StatusBadRequest},
+	} {
+		rr := httptest.NewRecorder()
+		compareStatsHandler(rr, httptest.NewRequest(tt.method, tt.url, nil), &MockDataStore{}, newFakeClock(time.Now()))
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.url, tt.status, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Where the Kubernetes manifests mount the ConfigMap and the Secret
+	defaultConfigFile       = "/etc/resume-backend/config.yaml"
+	defaultConfigSecretsDir = "/etc/resume-backend/secrets"
+
+	defaultConfigReloadInterval = 30 * time.Second
+)
+
+// envNamePattern matches the variable names settings may be given under
+var envNamePattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// configFiles sets environment variables from files mounted into the pod, so every setting
+// can keep being read with os.Getenv. Three sources are merged, later ones winning:
+//
+//   - CONFIG_FILE, a YAML map of variable names to values, typically a ConfigMap;
+//   - CONFIG_SECRETS_DIR, a directory with one file per variable, typically a Secret;
+//   - NAME_FILE variables, which set NAME to the contents of the file they point to;
+//   - with SECRETS_PROVIDER set, the secret read from Vault or AWS Secrets Manager.
+//
+// Variables set in the real environment always win over files and secrets.
+type configFiles struct {
+	path       string
+	secretsDir string
+	secrets    *secretsCache // nil without a secrets provider
+
+	mu       sync.Mutex
+	explicit map[string]bool   // set in the real environment before any file was applied
+	applied  map[string]string // values the files last set
+	hooks    []func(changed []string)
+}
+
+// loadConfigFiles reads CONFIG_FILE and CONFIG_SECRETS_DIR, defaulting to the paths the
+// manifests mount, and applies them to the environment. A missing default path is skipped;
+// a missing path that was set explicitly is an error.
+func loadConfigFiles() (*configFiles, error) {
+	c := &configFiles{path: defaultConfigFile, secretsDir: defaultConfigSecretsDir, explicit: make(map[string]bool), applied: make(map[string]string)}
+	if v, ok := os.LookupEnv("CONFIG_FILE"); ok {
+		if _, err := os.Stat(v); v != "" && err != nil {
+			return nil, fmt.Errorf("invalid CONFIG_FILE: %w", err)
+		}
+		c.path = v
+	}
+	if v, ok := os.LookupEnv("CONFIG_SECRETS_DIR"); ok {
+		if _, err := os.Stat(v); v != "" && err != nil {
+			return nil, fmt.Errorf("invalid CONFIG_SECRETS_DIR: %w", err)
+		}
+		c.secretsDir = v
+	}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		c.explicit[name] = true
+	}
+
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	provider, err := loadSecretsProvider()
+	if err != nil {
+		return nil, err
+	}
+	if provider != nil {
+		c.secrets = newSecretsCache(provider, loadSecretsRefreshInterval())
+		if _, err := c.Reload(); err != nil {
+			return nil, err
+		}
+		log.Printf("Loading secrets from %s", provider.Name())
+	}
+	if len(c.applied) > 0 {
+		log.Printf("Loaded %d settings from config files", len(c.applied))
+	}
+	return c, nil
+}
+
+// OnChange registers hook to be called with the names of the variables a reload changed.
+func (c *configFiles) OnChange(hook func(changed []string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, hook)
+}
+
+// Reload rereads the files and updates the environment, returning the names of the variables
+// that changed. Variables that disappeared from the files are unset.
+func (c *configFiles) Reload() ([]string, error) {
+	values, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	var changed []string
+	for name, value := range values {
+		if c.explicit[name] {
+			continue
+		}
+		if old, ok := c.applied[name]; !ok || old != value {
+			os.Setenv(name, value)
+			changed = append(changed, name)
+		}
+	}
+	for name := range c.applied {
+		if _, ok := values[name]; !ok && !c.explicit[name] {
+			os.Unsetenv(name)
+			changed = append(changed, name)
+		}
+	}
+	c.applied = make(map[string]string, len(values))
+	for name, value := range values {
+		if !c.explicit[name] {
+			c.applied[name] = value
+		}
+	}
+	hooks := c.hooks
+	c.mu.Unlock()
+
+	sort.Strings(changed)
+	if len(changed) > 0 {
+		for _, hook := range hooks {
+			hook(changed)
+		}
+	}
+	return changed, nil
+}
+
+// read merges the values of every source.
+func (c *configFiles) read() (map[string]string, error) {
+	values := make(map[string]string)
+
+	if c.path != "" {
+		data, err := os.ReadFile(c.path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		default:
+			var settings map[string]string
+			if err := yaml.Unmarshal(data, &settings); err != nil {
+				return nil, fmt.Errorf("failed to parse config file %s: %w", c.path, err)
+			}
+			for name, value := range settings {
+				if !envNamePattern.MatchString(name) {
+					return nil, fmt.Errorf("invalid setting name %q in %s", name, c.path)
+				}
+				values[name] = value
+			}
+		}
+	}
+
+	if c.secretsDir != "" {
+		entries, err := os.ReadDir(c.secretsDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read secrets directory: %w", err)
+		}
+		for _, e := range entries {
+			// Kubernetes keeps the real files under ..data and friends, and links each key to them
+			if strings.HasPrefix(e.Name(), ".") || !envNamePattern.MatchString(e.Name()) {
+				continue
+			}
+			value, err := readSettingFile(filepath.Join(c.secretsDir, e.Name()))
+			if errors.Is(err, errNotSettingFile) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			values[e.Name()] = value
+		}
+	}
+
+	// NAME_FILE may come from the real environment or from the files above
+	fileVars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, path, _ := strings.Cut(kv, "=")
+		if c.explicit[name] {
+			fileVars[name] = path
+		}
+	}
+	for name, path := range values {
+		fileVars[name] = path
+	}
+	for name, path := range fileVars {
+		target, ok := strings.CutSuffix(name, "_FILE")
+		if !ok || target == "" || path == "" || target == "CONFIG" {
+			continue
+		}
+		value, err := readSettingFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		values[target] = value
+	}
+
+	if c.secrets != nil {
+		secrets, err := c.secrets.Values()
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range secrets {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+// errNotSettingFile is returned by readSettingFile for directories.
+var errNotSettingFile = errors.New("not a regular file")
+
+// readSettingFile returns the contents of a file holding one setting, without the trailing
+// newline editors and kubectl add.
+func readSettingFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read setting file: %w", err)
+	}
+	if info.IsDir() {
+		return "", errNotSettingFile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read setting file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// loadConfigReloadInterval reads CONFIG_RELOAD_INTERVAL, how often mounted files are checked
+// for changes. Kubernetes updates mounted ConfigMaps and Secrets in place within a minute or so.
+func loadConfigReloadInterval() time.Duration {
+	v := os.Getenv("CONFIG_RELOAD_INTERVAL")
+	if v == "" {
+		return defaultConfigReloadInterval
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid CONFIG_RELOAD_INTERVAL %q, using %s", v, defaultConfigReloadInterval)
+		return defaultConfigReloadInterval
+	}
+	return d
+}
+
+// Watch reloads the files every interval until ctx is done; secrets are fetched again once
+// their own refresh interval has passed. Kubernetes swaps mounted files
+// by replacing a symlink, which file notifications miss, so they are polled instead.
+func (c *configFiles) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Keep the last good settings until the files are fixed
+			if _, err := c.Reload(); err != nil {
+				errorLogger.Printf("Error reloading config files: %v", err)
+			}
+		}
+	}
+}
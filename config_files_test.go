@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// unsetenv unsets names for the test, restoring them afterwards
+func unsetenv(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_configFiles(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	secretsDir := filepath.Join(dir, "secrets")
+	if err := os.MkdirAll(filepath.Join(secretsDir, "..data"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, configPath, "ALLOWED_ORIGINS: https://example.com\nEXPORT_BATCH_SIZE: 500\nDB_HOST: config-host\n")
+	writeFile(t, filepath.Join(secretsDir, "DB_PASSWORD"), "s3cret\n")
+	writeFile(t, filepath.Join(secretsDir, "..data", "DB_PASSWORD"), "ignored")
+	tokenPath := filepath.Join(dir, "token")
+	writeFile(t, tokenPath, "purge-token\n")
+
+	unsetenv(t, "ALLOWED_ORIGINS", "EXPORT_BATCH_SIZE", "DB_PASSWORD", "CDN_PURGE_TOKEN")
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("CDN_PURGE_TOKEN_FILE", tokenPath)
+	t.Setenv("CONFIG_FILE", configPath)
+	t.Setenv("CONFIG_SECRETS_DIR", secretsDir)
+
+	c, err := loadConfigFiles()
+	if err != nil {
+		t.Fatalf("loadConfigFiles() error = %v", err)
+	}
+	for name, want := range map[string]string{
+		"ALLOWED_ORIGINS":   "https://example.com",
+		"EXPORT_BATCH_SIZE": "500",
+		"DB_PASSWORD":       "s3cret",
+		"CDN_PURGE_TOKEN":   "purge-token",
+		"DB_HOST":           "env-host", // the real environment wins
+	} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	var notified []string
+	c.OnChange(func(changed []string) { notified = changed })
+
+	// Nothing changed
+	if changed, err := c.Reload(); err != nil || len(changed) != 0 {
+		t.Errorf("Reload() = %v, %v; want no changes", changed, err)
+	}
+
+	// A rotated secret and a removed setting
+	writeFile(t, filepath.Join(secretsDir, "DB_PASSWORD"), "rotated")
+	writeFile(t, configPath, "ALLOWED_ORIGINS: https://example.com\n")
+	changed, err := c.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if want := []string{"DB_PASSWORD", "EXPORT_BATCH_SIZE"}; !reflect.DeepEqual(changed, want) || !reflect.DeepEqual(notified, want) {
+		t.Errorf("expected %v to change; got %v, notified %v", want, changed, notified)
+	}
+	if os.Getenv("DB_PASSWORD") != "rotated" {
+		t.Errorf("expected the rotated password; got %q", os.Getenv("DB_PASSWORD"))
+	}
+	if _, ok := os.LookupEnv("EXPORT_BATCH_SIZE"); ok {
+		t.Error("expected a setting removed from the file to be unset")
+	}
+
+	// A broken file keeps the last good settings
+	writeFile(t, configPath, "ALLOWED_ORIGINS: [")
+	if _, err := c.Reload(); err == nil {
+		t.Error("expected an error for invalid YAML")
+	}
+	if os.Getenv("ALLOWED_ORIGINS") != "https://example.com" {
+		t.Error("expected the settings to be kept after a failed reload")
+	}
+}
+
+func Test_loadConfigFiles_Missing(t *testing.T) {
+	dir := t.TempDir()
+
+	// The default paths are optional
+	unsetenv(t, "CONFIG_FILE", "CONFIG_SECRETS_DIR")
+	if _, err := loadConfigFiles(); err != nil {
+		t.Errorf("expected missing default paths to be skipped; got %v", err)
+	}
+
+	t.Setenv("CONFIG_FILE", filepath.Join(dir, "missing.yaml"))
+	if _, err := loadConfigFiles(); err == nil {
+		t.Error("expected an error for a missing CONFIG_FILE")
+	}
+
+	t.Setenv("CONFIG_FILE", filepath.Join(dir, "config.yaml"))
+	writeFile(t, filepath.Join(dir, "config.yaml"), "lower_case: nope\n")
+	if _, err := loadConfigFiles(); err == nil {
+		t.Error("expected an error for an invalid setting name")
+	}
+
+	writeFile(t, filepath.Join(dir, "config.yaml"), "")
+	t.Setenv("CONFIG_SECRETS_DIR", "")
+	t.Setenv("DB_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	if _, err := loadConfigFiles(); err == nil {
+		t.Error("expected an error for a missing _FILE path")
+	}
+}
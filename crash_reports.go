@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	crashReportQueue   = 100
+	crashReportTimeout = 10 * time.Second
+	crashReportFilter  = "[Filtered]"
+)
+
+// crashReports sends panics and background job failures to Sentry when SENTRY_DSN is set. It
+// is nil otherwise, and its methods do nothing.
+var crashReports *crashReporter
+
+// Request headers sent with a report; the rest, such as cookies, tokens and forwarded client
+// addresses, may identify the visitor
+var crashReportHeaders = []string{"Accept", "Accept-Language", "Content-Type", "X-Request-ID"}
+
+// Email and IP addresses, scrubbed from report messages
+var crashReportPIIPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}|\b(?:\d{1,3}\.){3}\d{1,3}\b|\b(?:[0-9a-fA-F]{1,4}:){3,7}[0-9a-fA-F]{1,4}\b`)
+
+// crashReportConfig controls reporting to Sentry, or any service speaking its protocol, such as
+// GlitchTip.
+type crashReportConfig struct {
+	Endpoint    string // the project's envelope endpoint, from the DSN
+	PublicKey   string
+	Environment string
+	Release     string
+}
+
+// loadCrashReportConfig reads SENTRY_DSN, which enables reporting, SENTRY_ENVIRONMENT
+// ("production" unless set) and SENTRY_RELEASE, which defaults to the build version.
+func loadCrashReportConfig() (crashReportConfig, bool, error) {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return crashReportConfig{}, false, nil
+	}
+	// A DSN is scheme://public_key@host[/path]/project_id
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return crashReportConfig{}, false, errors.New("invalid SENTRY_DSN: must be scheme://key@host/project")
+	}
+	// The project is the last path segment; anything before it prefixes the API
+	prefix, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return crashReportConfig{}, false, errors.New("invalid SENTRY_DSN: missing the project ID")
+	}
+
+	cfg := crashReportConfig{
+		Endpoint:    fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		PublicKey:   u.User.Username(),
+		Environment: "production",
+		Release:     buildVersion(),
+	}
+	if v := os.Getenv("SENTRY_ENVIRONMENT"); v != "" {
+		cfg.Environment = v
+	}
+	if v := os.Getenv("SENTRY_RELEASE"); v != "" {
+		cfg.Release = v
+	}
+	return cfg, true, nil
+}
+
+// crashEvent is a Sentry event, trimmed to the fields the reports use.
+type crashEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Environment string            `json:"environment"`
+	Release     string            `json:"release"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Exception   *crashExceptions  `json:"exception,omitempty"`
+	Request     *crashRequest     `json:"request,omitempty"`
+}
+
+type crashExceptions struct {
+	Values []crashException `json:"values"`
+}
+
+type crashException struct {
+	Type       string          `json:"type"`
+	Value      string          `json:"value"`
+	Stacktrace *crashStack     `json:"stacktrace,omitempty"`
+	Mechanism  *crashMechanism `json:"mechanism,omitempty"`
+}
+
+type crashMechanism struct {
+	Type    string `json:"type"`
+	Handled bool   `json:"handled"`
+}
+
+type crashStack struct {
+	Frames []crashFrame `json:"frames"`
+}
+
+type crashFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type crashRequest struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// crashReporter sends events from a queue, so reporting never blocks a request on Sentry. When
+// the queue is full, further events are dropped.
+type crashReporter struct {
+	cfg        crashReportConfig
+	clock      Clock
+	client     *http.Client
+	serverName string
+	queue      chan crashEvent
+	pending    sync.WaitGroup
+}
+
+func newCrashReporter(cfg crashReportConfig, clock Clock) *crashReporter {
+	hostname, _ := os.Hostname()
+	return &crashReporter{
+		cfg:        cfg,
+		clock:      clock,
+		client:     &http.Client{Timeout: crashReportTimeout},
+		serverName: hostname,
+		queue:      make(chan crashEvent, crashReportQueue),
+	}
+}
+
+// CapturePanic reports a panic recovered while serving r, with the stack it was raised on.
+func (c *crashReporter) CapturePanic(r *http.Request, rec any) {
+	if c == nil {
+		return
+	}
+	event := c.event("fatal", "http", panicException(rec, "recovery_middleware"))
+	event.Request = scrubRequest(r)
+	event.Tags["request_id"] = requestIDFromContext(r.Context())
+	if r.Pattern != "" {
+		event.Tags["route"] = r.Pattern
+	}
+	c.enqueue(event)
+}
+
+// CaptureError reports a background job failure, such as an outbox message given up on.
+func (c *crashReporter) CaptureError(source string, err error) {
+	if c == nil || err == nil {
+		return
+	}
+	c.enqueue(c.event("error", source, crashException{
+		Type:       fmt.Sprintf("%T", err),
+		Value:      scrubMessage(err.Error()),
+		Stacktrace: callerStack(3),
+		Mechanism:  &crashMechanism{Type: source, Handled: true},
+	}))
+}
+
+// guard reports a panic in a background job and waits for the report to be sent before
+// letting the panic take the process down.
+func (c *crashReporter) guard(source string, job func(context.Context)) func(context.Context) {
+	if c == nil {
+		return job
+	}
+	return func(ctx context.Context) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				c.enqueue(c.event("fatal", source, panicException(rec, source)))
+				flushCtx, cancel := context.WithTimeout(context.Background(), crashReportTimeout)
+				defer cancel()
+				c.Flush(flushCtx)
+				panic(rec)
+			}
+		}()
+		job(ctx)
+	}
+}
+
+func (c *crashReporter) event(level, source string, exception crashException) crashEvent {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return crashEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   c.clock.Now().UTC().Format(time.RFC3339Nano),
+		Level:       level,
+		Platform:    "go",
+		Logger:      source,
+		Environment: c.cfg.Environment,
+		Release:     c.cfg.Release,
+		ServerName:  c.serverName,
+		Tags:        map[string]string{"source": source},
+		Exception:   &crashExceptions{Values: []crashException{exception}},
+	}
+}
+
+func (c *crashReporter) enqueue(event crashEvent) {
+	c.pending.Add(1)
+	select {
+	case c.queue <- event:
+	default:
+		c.pending.Done()
+		log.Printf("Crash report queue full: dropping event %s", event.EventID)
+	}
+}
+
+// Flush waits until the queued events are sent, or ctx is done.
+func (c *crashReporter) Flush(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		c.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// Run sends queued events until ctx is done.
+func (c *crashReporter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-c.queue:
+			if err := c.send(ctx, event); err != nil {
+				// Logged with log rather than errorLogger, which a failing Sentry could flood
+				log.Printf("Error sending crash report %s: %v", event.EventID, err)
+			}
+			c.pending.Done()
+		}
+	}
+}
+
+// send posts event to Sentry as an envelope: a header, the item header and the event, one
+// JSON document per line.
+func (c *crashReporter) send(ctx context.Context, event crashEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode crash report: %w", err)
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `{"event_id":%q,"sent_at":%q}`+"\n", event.EventID, c.clock.Now().UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&body, `{"type":"event","length":%d}`+"\n", len(payload))
+	body.Write(payload)
+	body.WriteString("\n")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.Endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create crash report request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=resume-backend/%s, sentry_key=%s", c.cfg.Release, c.cfg.PublicKey))
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("sentry returned status %d: %s", res.StatusCode, msg)
+	}
+	return nil
+}
+
+// panicException describes a recovered panic. It is called from the deferred recover, so the
+// stack still holds the frames that panicked, which callerStack starts from.
+func panicException(rec any, mechanism string) crashException {
+	value := fmt.Sprint(rec)
+	if err, ok := rec.(error); ok {
+		value = err.Error()
+	}
+	return crashException{
+		Type:       fmt.Sprintf("panic: %T", rec),
+		Value:      scrubMessage(value),
+		Stacktrace: callerStack(3),
+		Mechanism:  &crashMechanism{Type: mechanism, Handled: false},
+	}
+}
+
+// callerStack returns the stack above skip frames, oldest call first as Sentry expects. While
+// panicking, it starts at the frame that panicked, leaving out the recovery.
+func callerStack(skip int) *crashStack {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []crashFrame
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" {
+			stack = stack[:0]
+			if !more {
+				break
+			}
+			continue
+		}
+		module, function := "", frame.Function
+		if i := strings.LastIndex(function, "/"); i >= 0 {
+			if dot := strings.Index(function[i:], "."); dot >= 0 {
+				module, function = function[:i+dot], function[i+dot+1:]
+			}
+		} else if dot := strings.Index(function, "."); dot >= 0 {
+			module, function = function[:dot], function[dot+1:]
+		}
+		stack = append(stack, crashFrame{
+			Function: function,
+			Module:   module,
+			Filename: filepath.Base(frame.File),
+			AbsPath:  frame.File,
+			Lineno:   frame.Line,
+			InApp:    module == "main" || strings.HasPrefix(module, "resume-backend"),
+		})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return &crashStack{Frames: stack}
+}
+
+// scrubRequest keeps what a report needs to reproduce the request: the method, path, allowed
+// headers and query parameter names, with their values filtered.
+func scrubRequest(r *http.Request) *crashRequest {
+	req := &crashRequest{Method: r.Method, URL: r.URL.Path, Headers: make(map[string]string)}
+	if r.Host != "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		req.URL = scheme + "://" + r.Host + r.URL.Path
+	}
+	for _, name := range crashReportHeaders {
+		if v := r.Header.Get(name); v != "" {
+			req.Headers[name] = v
+		}
+	}
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = url.QueryEscape(name) + "=" + crashReportFilter
+	}
+	req.QueryString = strings.Join(names, "&")
+	return req
+}
+
+// scrubMessage filters email and IP addresses out of a panic or error message.
+func scrubMessage(msg string) string {
+	return crashReportPIIPattern.ReplaceAllString(msg, crashReportFilter)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentrySink records the events posted to it
+type sentrySink struct {
+	mu     sync.Mutex
+	auth   string
+	events []crashEvent
+}
+
+func newSentrySink(t *testing.T) (*sentrySink, *crashReporter) {
+	sink := &sentrySink{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/envelope/" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		// The envelope header, item header, then the event
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(nil, 1<<20)
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		var event crashEvent
+		if len(lines) != 3 || !strings.Contains(lines[1], `"type":"event"`) || json.Unmarshal([]byte(lines[2]), &event) != nil {
+			t.Errorf("invalid envelope %q", lines)
+		}
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		sink.auth = r.Header.Get("X-Sentry-Auth")
+		sink.events = append(sink.events, event)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("SENTRY_DSN", strings.Replace(server.URL, "://", "://public@", 1)+"/42")
+	t.Setenv("SENTRY_ENVIRONMENT", "staging")
+	t.Setenv("SENTRY_RELEASE", "v1.2.3")
+	cfg, enabled, err := loadCrashReportConfig()
+	if err != nil || !enabled {
+		t.Fatalf("expected crash reports enabled, got %v, %v", enabled, err)
+	}
+	reporter := newCrashReporter(cfg, realClock{})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go reporter.Run(ctx)
+
+	previous := crashReports
+	crashReports = reporter
+	t.Cleanup(func() { crashReports = previous })
+	return sink, reporter
+}
+
+func (s *sentrySink) flushed(t *testing.T, reporter *crashReporter) []crashEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reporter.Flush(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events
+}
+
+func Test_recoveryMiddleware_CrashReport(t *testing.T) {
+	useFakeMetrics(t)
+	sink, reporter := newSentrySink(t)
+	handler := requestIDMiddleware(recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("no visits for jane@example.com from 203.0.113.7")
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/count?email=jane@example.com", nil)
+	req.Header.Set("X-Request-ID", "abc123")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept-Language", "de")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+
+	events := sink.flushed(t, reporter)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if event.Level != "fatal" || event.Environment != "staging" || event.Release != "v1.2.3" || event.Tags["request_id"] != "abc123" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if !strings.Contains(sink.auth, "sentry_key=public") {
+		t.Errorf("expected the DSN key in the auth header, got %q", sink.auth)
+	}
+	exception := event.Exception.Values[0]
+	if exception.Value != "no visits for [Filtered] from [Filtered]" {
+		t.Errorf("expected the message scrubbed, got %q", exception.Value)
+	}
+	frames := exception.Stacktrace.Frames
+	if last := frames[len(frames)-1]; !last.InApp || !strings.HasPrefix(last.Function, "Test_recoveryMiddleware_CrashReport") {
+		t.Errorf("expected the stack to end where it panicked, got %+v", last)
+	}
+	if event.Request.QueryString != "email=[Filtered]" || len(event.Request.Headers) != 2 || event.Request.Headers["Accept-Language"] != "de" {
+		t.Errorf("expected the request scrubbed, got %+v", event.Request)
+	}
+}
+
+func Test_crashReporter_guard(t *testing.T) {
+	sink, reporter := newSentrySink(t)
+	job := reporter.guard("background_job", func(ctx context.Context) { panic(errors.New("roll-up failed")) })
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the panic to continue once reported")
+			}
+		}()
+		job(context.Background())
+	}()
+
+	// guard waits for the report to be sent before panicking on
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.events) != 1 || sink.events[0].Tags["source"] != "background_job" || sink.events[0].Exception.Values[0].Value != "roll-up failed" {
+		t.Errorf("expected the panic reported, got %+v", sink.events)
+	}
+}
+
+func Test_outboxDeadLetter_CrashReport(t *testing.T) {
+	useFakeMetrics(t)
+	sink, reporter := newSentrySink(t)
+	store := newOutboxStore(OutboxMessage{Destination: "webhook", Attempts: 3})
+	processor := &recordingOutboxProcessor{recordingProcessor: recordingProcessor{name: "webhook"}, err: errors.New("connection refused")}
+	d := newOutboxDispatcher(store, []VisitProcessor{processor}, outboxConfig{MaxAttempts: 3}, realClock{})
+	d.deliver(context.Background(), store.messages[0])
+
+	events := sink.flushed(t, reporter)
+	if len(events) != 1 || events[0].Level != "error" || !strings.Contains(events[0].Exception.Values[0].Value, "connection refused") {
+		t.Errorf("expected the dead letter reported, got %+v", events)
+	}
+}
+
+func Test_loadCrashReportConfig(t *testing.T) {
+	if _, enabled, err := loadCrashReportConfig(); enabled || err != nil {
+		t.Fatalf("expected reports off by default, got %v, %v", enabled, err)
+	}
+
+	t.Setenv("SENTRY_DSN", "https://key@sentry.example.com/prefix/7")
+	cfg, _, err := loadCrashReportConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Endpoint != "https://sentry.example.com/prefix/api/7/envelope/" || cfg.PublicKey != "key" || cfg.Environment != "production" || cfg.Release != buildVersion() {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	for _, dsn := range []string{"https://sentry.example.com/7", "https://key@sentry.example.com/", "::"} {
+		t.Setenv("SENTRY_DSN", dsn)
+		if _, _, err := loadCrashReportConfig(); err == nil {
+			t.Errorf("expected an error for %q", dsn)
+		}
+	}
+}
+
+func Test_crashReporter_Disabled(t *testing.T) {
+	var reporter *crashReporter
+	reporter.CapturePanic(httptest.NewRequest(http.MethodGet, "/", nil), "boom")
+	reporter.CaptureError("outbox", errors.New("failed"))
+	reporter.Flush(context.Background())
+	called := false
+	reporter.guard("background_job", func(ctx context.Context) { called = true })(context.Background())
+	if !called {
+		t.Error("expected the job to run unguarded")
+	}
+}
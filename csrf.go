@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	csrfPath       = "/api/csrf"
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfTokenTTL   = 12 * time.Hour
+)
+
+// csrfTokenPattern matches the tokens handed out by newCSRFToken
+var csrfTokenPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// csrfConfig controls double-submit CSRF protection.
+type csrfConfig struct {
+	Routes   map[string]bool // API paths whose state-changing requests need a token
+	SameSite http.SameSite
+}
+
+// Enabled reports whether any route is protected.
+func (c csrfConfig) Enabled() bool {
+	return len(c.Routes) > 0
+}
+
+// loadCSRFConfig reads CSRF_ROUTES, a comma-separated list of API paths such as
+// "/api/count,/api/events", and CSRF_COOKIE_SAMESITE (none, lax or strict; default none, as
+// the frontend usually calls the API cross-site).
+func loadCSRFConfig() csrfConfig {
+	cfg := csrfConfig{Routes: map[string]bool{}, SameSite: http.SameSiteNoneMode}
+
+	for _, route := range strings.Split(os.Getenv("CSRF_ROUTES"), ",") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+		if !strings.HasPrefix(route, "/api/") {
+			log.Printf("Invalid CSRF_ROUTES entry %q: must be an API path, skipping", route)
+			continue
+		}
+		cfg.Routes[route] = true
+	}
+
+	switch v := strings.ToLower(os.Getenv("CSRF_COOKIE_SAMESITE")); v {
+	case "", "none":
+	case "lax":
+		cfg.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cfg.SameSite = http.SameSiteStrictMode
+	default:
+		log.Printf("Invalid CSRF_COOKIE_SAMESITE %q, using none", v)
+	}
+
+	return cfg
+}
+
+// newCSRFToken returns a random 128-bit token.
+func newCSRFToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// csrfResponse carries the token the frontend echoes in the X-CSRF-Token header.
+type csrfResponse struct {
+	Token string `json:"token"`
+}
+
+// csrfHandler sets the CSRF cookie and returns its value. The frontend is on another
+// origin and can't read the cookie, so it takes the token from the body instead; a forged
+// cross-site request carries the cookie but can't learn the token to put in the header.
+func csrfHandler(w http.ResponseWriter, r *http.Request, cfg csrfConfig) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := ""
+	if c, err := r.Cookie(csrfCookieName); err == nil && csrfTokenPattern.MatchString(c.Value) {
+		token = c.Value // keep the token stable across tabs
+	} else if token, err = newCSRFToken(); err != nil {
+		http.Error(w, "Failed to create CSRF token", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/api/",
+		MaxAge:   int(csrfTokenTTL / time.Second),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: cfg.SameSite,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, csrfResponse{Token: token})
+}
+
+// csrfSafeMethods don't change state and are never checked
+var csrfSafeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// middleware that rejects state-changing requests to the protected routes unless the
+// X-CSRF-Token header matches the CSRF cookie
+func csrfMiddleware(next http.Handler, cfg csrfConfig) http.Handler {
+	if !cfg.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if csrfSafeMethods[r.Method] || !cfg.Routes[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(csrfCookieName)
+		header := r.Header.Get(csrfHeaderName)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			forbiddenLogger.Printf("Missing or mismatched CSRF token: %s %s", r.Method, r.URL.Path)
+			http.Error(w, localize(w, r, "csrf_invalid"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_loadCSRFConfig(t *testing.T) {
+	if cfg := loadCSRFConfig(); cfg.Enabled() || cfg.SameSite != http.SameSiteNoneMode {
+		t.Errorf("expected CSRF protection off with SameSite=None by default; got %+v", cfg)
+	}
+
+	t.Setenv("CSRF_ROUTES", "/api/count, /api/events,/healthz,")
+	t.Setenv("CSRF_COOKIE_SAMESITE", "Strict")
+	cfg := loadCSRFConfig()
+	if len(cfg.Routes) != 2 || !cfg.Routes[apiPath] || !cfg.Routes[eventsPath] {
+		t.Errorf("expected the two API routes to be protected; got %v", cfg.Routes)
+	}
+	if cfg.SameSite != http.SameSiteStrictMode {
+		t.Errorf("expected SameSite=Strict; got %v", cfg.SameSite)
+	}
+
+	t.Setenv("CSRF_COOKIE_SAMESITE", "sometimes")
+	if cfg := loadCSRFConfig(); cfg.SameSite != http.SameSiteNoneMode {
+		t.Errorf("expected an invalid SameSite to fall back to None; got %v", cfg.SameSite)
+	}
+}
+
+func Test_csrfHandler(t *testing.T) {
+	cfg := csrfConfig{SameSite: http.SameSiteLaxMode}
+
+	rr := httptest.NewRecorder()
+	csrfHandler(rr, httptest.NewRequest(http.MethodGet, csrfPath, nil), cfg)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200; got %d", rr.Code)
+	}
+	var body csrfResponse
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != body.Token || !csrfTokenPattern.MatchString(body.Token) {
+		t.Fatalf("expected the cookie to carry the returned token; got %v and %q", cookies, body.Token)
+	}
+	if c := cookies[0]; !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/api/" {
+		t.Errorf("unexpected cookie attributes: %+v", c)
+	}
+
+	// An existing token is kept so other tabs keep working
+	req := httptest.NewRequest(http.MethodGet, csrfPath, nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	csrfHandler(rr, req, cfg)
+	if got := rr.Result().Cookies()[0].Value; got != body.Token {
+		t.Errorf("expected the token to be reused; got %s", got)
+	}
+
+	rr = httptest.NewRecorder()
+	csrfHandler(rr, httptest.NewRequest(http.MethodPost, csrfPath, nil), cfg)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST; got %d", rr.Code)
+	}
+}
+
+func Test_csrfMiddleware(t *testing.T) {
+	const token = "0123456789abcdef0123456789abcdef"
+	handler := csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), csrfConfig{Routes: map[string]bool{apiPath: true}})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		cookie string
+		header string
+		want   int
+	}{
+		{"Matching token", http.MethodPost, apiPath, token, token, http.StatusOK},
+		{"Missing header", http.MethodPost, apiPath, token, "", http.StatusForbidden},
+		{"Missing cookie", http.MethodPost, apiPath, "", token, http.StatusForbidden},
+		{"Mismatched token", http.MethodPost, apiPath, token, "fedcba9876543210fedcba9876543210", http.StatusForbidden},
+		{"Safe method", http.MethodGet, apiPath, "", "", http.StatusOK},
+		{"Unprotected route", http.MethodPost, eventsPath, "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeaderName, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("expected %d; got %d", tt.want, rr.Code)
+			}
+		})
+	}
+}
+
+func Test_csrfRoutes(t *testing.T) {
+	t.Setenv("CSRF_ROUTES", apiPath)
+	useFakeMetrics(t)
+	mux := http.NewServeMux()
+	registerRoutes(mux, &MockDataStore{}, realClock{})
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, apiPath, nil))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected a visit without a token to be rejected; got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+	req.Header.Set("Accept-Language", "de-AT")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if got := strings.TrimSpace(rr.Body.String()); got != "CSRF-Token fehlt oder ist ungültig" {
+		t.Errorf("expected the rejection in German; got %q", got)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, csrfPath, nil))
+	var body csrfResponse
+	json.NewDecoder(rr.Body).Decode(&body)
+
+	req = httptest.NewRequest(http.MethodPost, apiPath, nil)
+	req.AddCookie(rr.Result().Cookies()[0])
+	req.Header.Set(csrfHeaderName, body.Token)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a visit with the token to be recorded; got %d", rr.Code)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+const (
+	dashboardSchemaVersion = 39
+	dashboardPanelWidth    = 12 // half of Grafana's 24-column grid
+	dashboardPanelHeight   = 8
+)
+
+// grafanaDashboard is the subset of Grafana's dashboard JSON model the generator fills in.
+type grafanaDashboard struct {
+	UID           string                `json:"uid"`
+	Title         string                `json:"title"`
+	Tags          []string              `json:"tags"`
+	Timezone      string                `json:"timezone"`
+	SchemaVersion int                   `json:"schemaVersion"`
+	Refresh       string                `json:"refresh"`
+	Time          grafanaTimeRange      `json:"time"`
+	Templating    grafanaTemplating     `json:"templating"`
+	Annotations   grafanaAnnotationList `json:"annotations"`
+	Panels        []grafanaPanel        `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string             `json:"name"`
+	Label      string             `json:"label,omitempty"`
+	Type       string             `json:"type"`
+	Query      string             `json:"query"`
+	Datasource *grafanaDatasource `json:"datasource,omitempty"`
+	Multi      bool               `json:"multi,omitempty"`
+	IncludeAll bool               `json:"includeAll,omitempty"`
+	AllValue   string             `json:"allValue,omitempty"`
+	Refresh    int                `json:"refresh,omitempty"`
+}
+
+type grafanaAnnotationList struct {
+	List []grafanaAnnotationQuery `json:"list"`
+}
+
+type grafanaAnnotationQuery struct {
+	Name       string            `json:"name"`
+	Datasource grafanaDatasource `json:"datasource"`
+	Enable     bool              `json:"enable"`
+	Hide       bool              `json:"hide"`
+	IconColor  string            `json:"iconColor"`
+	Type       string            `json:"type"`
+	BuiltIn    int               `json:"builtIn,omitempty"`
+}
+
+type grafanaDatasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaPanel struct {
+	ID          int                 `json:"id"`
+	Type        string              `json:"type"`
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	GridPos     grafanaGridPos      `json:"gridPos"`
+	Datasource  *grafanaDatasource  `json:"datasource,omitempty"`
+	Targets     []grafanaTarget     `json:"targets,omitempty"`
+	FieldConfig *grafanaFieldConfig `json:"fieldConfig,omitempty"`
+	Collapsed   *bool               `json:"collapsed,omitempty"`
+}
+
+type grafanaGridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+type grafanaFieldConfig struct {
+	Defaults grafanaFieldDefaults `json:"defaults"`
+}
+
+type grafanaFieldDefaults struct {
+	Unit string `json:"unit"`
+}
+
+// dashboardDatasource points panels at the datasource picked in the dashboard's variable
+var dashboardDatasource = &grafanaDatasource{Type: "prometheus", UID: "${datasource}"}
+
+// endpointSelector limits series to the endpoints picked in the dashboard's variable
+const endpointSelector = `endpoint=~"$endpoint"`
+
+// timeseries returns a panel with one query per legend and expression pair in queries.
+func timeseries(title, description, unit string, queries ...string) grafanaPanel {
+	p := grafanaPanel{
+		Type:        "timeseries",
+		Title:       title,
+		Description: description,
+		Datasource:  dashboardDatasource,
+		FieldConfig: &grafanaFieldConfig{Defaults: grafanaFieldDefaults{Unit: unit}},
+	}
+	for i := 0; i+1 < len(queries); i += 2 {
+		p.Targets = append(p.Targets, grafanaTarget{RefID: string(rune('A' + i/2)), Expr: queries[i+1], LegendFormat: queries[i]})
+	}
+	return p
+}
+
+// quantileQueries returns legend and expression pairs for the p50, p95 and p99 of histogram.
+func quantileQueries(histogram string) []string {
+	var queries []string
+	for _, q := range []struct{ legend, quantile string }{{"p50", "0.5"}, {"p95", "0.95"}, {"p99", "0.99"}} {
+		queries = append(queries, q.legend, fmt.Sprintf(`histogram_quantile(%s, sum by (le) (rate(%s_bucket{%s}[$__rate_interval])))`,
+			q.quantile, histogram, endpointSelector))
+	}
+	return queries
+}
+
+// dashboardRows groups the panels into the dashboard's rows. Every metric in
+// prometheusCollectors needs a query here, which dashboard_test.go checks.
+func dashboardRows() map[string][]grafanaPanel {
+	rate := func(metric, selector, by string) string {
+		if selector != "" {
+			metric += "{" + selector + "}"
+		}
+		return fmt.Sprintf(`sum by (%s) (rate(%s[$__rate_interval]))`, by, metric)
+	}
+	return map[string][]grafanaPanel{
+		"Requests": {
+			timeseries("Request rate", "Requests per second by endpoint", "reqps",
+				"{{method}} {{endpoint}}", rate(metricHTTPRequestsTotal, endpointSelector, "method, endpoint")),
+			timeseries("Error ratio", "Share of requests answered with a 4xx or 5xx status", "percentunit",
+				"{{status_class}}", fmt.Sprintf(`%s / ignoring(status_class) group_left sum(rate(%s{%s}[$__rate_interval]))`,
+					rate(metricHTTPRequestErrorsTotal, endpointSelector, "status_class"), metricHTTPRequestsTotal, endpointSelector)),
+			timeseries("Latency", "Request duration percentiles", "s",
+				quantileQueries(metricHTTPRequestDuration)...),
+			timeseries("Response size", "Response body size percentiles", "bytes",
+				quantileQueries(metricHTTPResponseSize)...),
+			timeseries("In-flight requests", "Requests being served", "short",
+				"in flight", fmt.Sprintf(`sum(%s)`, metricHTTPRequestsInFlight)),
+			timeseries("Rejected requests and panics", "Requests rejected by the load shedder or a rate limit, and panics recovered from handlers", "reqps",
+				"shed", fmt.Sprintf(`sum(rate(%s[$__rate_interval]))`, metricHTTPRequestsShedTotal),
+				"rate limited {{endpoint}}", rate(metricHTTPRequestsRateLimited, endpointSelector, "endpoint"),
+				"panics", fmt.Sprintf(`sum(rate(%s[$__rate_interval]))`, metricPanicsTotal)),
+			timeseries("Client timestamps", "Client-supplied times rejected or moved to now, by violation", "ops",
+				"{{endpoint}} {{violation}}", rate(metricClientTimestampViolations, endpointSelector, "endpoint, violation")),
+		},
+		"Database": {
+			timeseries("Query rate", "Queries per second by operation and result", "ops",
+				"{{operation}} {{result}}", rate(metricDBQueriesTotal, "", "operation, result")),
+			timeseries("Query latency", "95th percentile query duration by operation", "s",
+				"p95 {{operation}}", fmt.Sprintf(`histogram_quantile(0.95, sum by (le, operation) (rate(%s_bucket[$__rate_interval])))`, metricDBQueryDuration)),
+			timeseries("Rows per query", "95th percentile of the rows returned or affected by operation", "short",
+				"p95 {{operation}}", fmt.Sprintf(`histogram_quantile(0.95, sum by (le, operation) (rate(%s_bucket[$__rate_interval])))`, metricDBQueryRows)),
+			timeseries("Database in use", "0 while on the primary; the failover database's position in DATABASE_FAILOVER_URLS otherwise", "short",
+				"in use", fmt.Sprintf(`max(%s)`, metricDBActiveTarget)),
+		},
+		"Integrations": {
+			timeseries("CAPTCHA verifications", "Verifications by provider and result", "ops",
+				"{{provider}} {{result}}", rate(metricCaptchaVerificationsTotal, "", "provider, result")),
+			timeseries("Outbox deliveries", "Delivery attempts by destination and result", "ops",
+				"{{destination}} {{result}}", rate(metricOutboxDeliveriesTotal, "", "destination, result")),
+			timeseries("TLS certificate expiry", "Days left on the certificate of each monitored domain", "d",
+				"{{domain}}", fmt.Sprintf(`min by (domain) (%s)`, metricCertificateExpiryDays)),
+		},
+	}
+}
+
+// dashboardRowOrder is the order the rows appear in
+var dashboardRowOrder = []string{"Requests", "Database", "Integrations"}
+
+// buildDashboard lays out the rows two panels wide and wires them to the datasource and
+// endpoint variables.
+func buildDashboard(uid, title string) grafanaDashboard {
+	d := grafanaDashboard{
+		UID:           uid,
+		Title:         title,
+		Tags:          []string{"resume-backend"},
+		Timezone:      "browser",
+		SchemaVersion: dashboardSchemaVersion,
+		Refresh:       "1m",
+		Time:          grafanaTimeRange{From: "now-6h", To: "now"},
+		Templating: grafanaTemplating{List: []grafanaVariable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			{
+				Name: "endpoint", Label: "Endpoint", Type: "query", Datasource: dashboardDatasource,
+				Query: fmt.Sprintf("label_values(%s, endpoint)", metricHTTPRequestsTotal),
+				Multi: true, IncludeAll: true, AllValue: ".*", Refresh: 2,
+			},
+		}},
+		Annotations: grafanaAnnotationList{List: []grafanaAnnotationQuery{{
+			Name: "Annotations & Alerts", Datasource: grafanaDatasource{Type: "grafana", UID: "-- Grafana --"},
+			Enable: true, Hide: true, IconColor: "rgba(0, 211, 255, 1)", Type: "dashboard", BuiltIn: 1,
+		}}},
+	}
+
+	rows := dashboardRows()
+	id, y := 1, 0
+	collapsed := false
+	for _, name := range dashboardRowOrder {
+		d.Panels = append(d.Panels, grafanaPanel{ID: id, Type: "row", Title: name, GridPos: grafanaGridPos{Y: y, W: 24, H: 1}, Collapsed: &collapsed})
+		id, y = id+1, y+1
+		for i, p := range rows[name] {
+			p.ID = id
+			p.GridPos = grafanaGridPos{X: (i % 2) * dashboardPanelWidth, Y: y + (i/2)*dashboardPanelHeight, W: dashboardPanelWidth, H: dashboardPanelHeight}
+			d.Panels = append(d.Panels, p)
+			id++
+		}
+		y += (len(rows[name]) + 1) / 2 * dashboardPanelHeight
+	}
+	return d
+}
+
+// runDashboardCommand implements the "dashboard" subcommand, writing a Grafana dashboard for
+// the metrics this build exposes as JSON to out, ready to import.
+func runDashboardCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("dashboard", flag.ContinueOnError)
+	uid := fs.String("uid", "resume-backend", "dashboard UID, kept stable so re-imports replace the dashboard")
+	title := fs.String("title", "resume-backend", "dashboard title")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(buildDashboard(*uid, *title)); err != nil {
+		return fmt.Errorf("failed to encode dashboard: %w", err)
+	}
+	return nil
+}
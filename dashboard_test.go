@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	metricRefPattern = regexp.MustCompile(`\b([a-z_]+?)(?:_bucket)?(?:\{|\[|\))`)
+	legendPattern    = regexp.MustCompile(`\{\{(\w+)\}\}`)
+	byPattern        = regexp.MustCompile(`by \(([^)]*)\)`)
+)
+
+func Test_runDashboardCommand(t *testing.T) {
+	var out bytes.Buffer
+	if err := runDashboardCommand([]string{"-uid", "resume", "-title", "Resume"}, &out); err != nil {
+		t.Fatalf("runDashboardCommand() error = %v", err)
+	}
+
+	var dashboard grafanaDashboard
+	if err := json.Unmarshal(out.Bytes(), &dashboard); err != nil {
+		t.Fatalf("could not parse generated dashboard: %v", err)
+	}
+	if dashboard.UID != "resume" || dashboard.Title != "Resume" {
+		t.Errorf("expected the flags applied, got uid %q and title %q", dashboard.UID, dashboard.Title)
+	}
+
+	// Every exposed metric must be charted, and panels may only group by labels it has
+	docs, err := describeMetrics(prometheusCollectors)
+	if err != nil {
+		t.Fatalf("describeMetrics() error = %v", err)
+	}
+	exposed := map[string][]string{}
+	for _, d := range docs {
+		exposed[d.Name] = d.Labels
+	}
+	charted := map[string]bool{}
+	for _, p := range dashboard.Panels {
+		for _, target := range p.Targets {
+			labels := map[string]bool{"le": true}
+			for _, ref := range metricRefPattern.FindAllStringSubmatch(target.Expr, -1) {
+				if metricLabels, ok := exposed[ref[1]]; ok {
+					charted[ref[1]] = true
+					for _, l := range metricLabels {
+						labels[l] = true
+					}
+				}
+			}
+			var used []string
+			for _, m := range legendPattern.FindAllStringSubmatch(target.LegendFormat, -1) {
+				used = append(used, m[1])
+			}
+			for _, m := range byPattern.FindAllStringSubmatch(target.Expr, -1) {
+				for _, l := range strings.Split(m[1], ",") {
+					used = append(used, strings.TrimSpace(l))
+				}
+			}
+			for _, l := range used {
+				if !labels[l] {
+					t.Errorf("panel %q uses label %q, which its metrics don't have: %s", p.Title, l, target.Expr)
+				}
+			}
+		}
+	}
+	for name := range exposed {
+		if !charted[name] {
+			t.Errorf("metric %s has no panel", name)
+		}
+	}
+
+	// Panels must not overlap
+	seen := map[[2]int]string{}
+	for _, p := range dashboard.Panels {
+		for x := p.GridPos.X; x < p.GridPos.X+p.GridPos.W; x++ {
+			for y := p.GridPos.Y; y < p.GridPos.Y+p.GridPos.H; y++ {
+				if other, ok := seen[[2]int{x, y}]; ok {
+					t.Fatalf("panels %q and %q overlap", other, p.Title)
+				}
+				seen[[2]int{x, y}] = p.Title
+			}
+		}
+	}
+}
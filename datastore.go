@@ -0,0 +1,43 @@
+package main
+
+import "resume-backend/internal/store"
+
+// The storage layer lives in internal/store; these aliases keep the handlers and background
+// jobs in this package reading as they did. It is the only part split out so far: the HTTP
+// API, metrics and configuration are still in package main, left for separate changes.
+type (
+	DataStore         = store.DataStore
+	Visit             = store.Visit
+	VisitRow          = store.VisitRow
+	UTM               = store.UTM
+	DailyCount        = store.DailyCount
+	Summary           = store.Summary
+	HeatmapCell       = store.HeatmapCell
+	TimeRange         = store.TimeRange
+	ReferrerCount     = store.ReferrerCount
+	CampaignCount     = store.CampaignCount
+	Exposure          = store.Exposure
+	VariantResult     = store.VariantResult
+	SessionDay        = store.SessionDay
+	Event             = store.Event
+	EventStatsQuery   = store.EventStatsQuery
+	EventCount        = store.EventCount
+	HourlyCount       = store.HourlyCount
+	Anomaly           = store.Anomaly
+	VisitorIDs        = store.VisitorIDs
+	VisitorHash       = store.VisitorHash
+	Session           = store.Session
+	VisitorData       = store.VisitorData
+	OutboxMessage     = store.OutboxMessage
+	MaintenanceAction = store.MaintenanceAction
+	Project           = store.Project
+	ProjectLink       = store.ProjectLink
+	ResumeVersion     = store.ResumeVersion
+	Post              = store.Post
+	ShortLink         = store.ShortLink
+	UptimeCheck       = store.UptimeCheck
+	CertificateCheck  = store.CertificateCheck
+	Deploy            = store.Deploy
+	QueryTrace        = store.QueryTrace
+	IntegrityCheck    = store.IntegrityCheck
+)
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDBHealthInterval = 10 * time.Second
+	defaultDBHealthTimeout  = 2 * time.Second
+	defaultDBHealthFailures = 3
+	dbHealthIntervalEnv     = "DB_HEALTH_INTERVAL"
+	dbHealthTimeoutEnv      = "DB_HEALTH_TIMEOUT"
+	dbHealthFailureLimitEnv = "DB_HEALTH_FAILURES"
+)
+
+// Set by main while the database health checker runs; /readyz fails while it reports the
+// database down
+var dbHealth *dbHealthChecker
+
+// dbHealthConfig controls how often the database is probed and when it counts as down.
+type dbHealthConfig struct {
+	Interval time.Duration // time between probes; 0 turns the checker off
+	Timeout  time.Duration // how long a probe may take before it counts as failed
+	Failures int           // consecutive failed probes before the replica reports not ready
+}
+
+// loadDBHealthConfig reads DB_HEALTH_INTERVAL (default 10s, "0" turns probing off),
+// DB_HEALTH_TIMEOUT (default 2s) and DB_HEALTH_FAILURES (default 3).
+func loadDBHealthConfig() dbHealthConfig {
+	cfg := dbHealthConfig{Interval: defaultDBHealthInterval, Timeout: defaultDBHealthTimeout, Failures: defaultDBHealthFailures}
+	if v := os.Getenv(dbHealthIntervalEnv); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Invalid %s %q, using %s", dbHealthIntervalEnv, v, cfg.Interval)
+		} else {
+			cfg.Interval = d
+		}
+	}
+	if v := os.Getenv(dbHealthTimeoutEnv); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid %s %q, using %s", dbHealthTimeoutEnv, v, cfg.Timeout)
+		} else {
+			cfg.Timeout = d
+		}
+	}
+	if v := os.Getenv(dbHealthFailureLimitEnv); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Printf("Invalid %s %q, using %d", dbHealthFailureLimitEnv, v, cfg.Failures)
+		} else {
+			cfg.Failures = n
+		}
+	}
+	return cfg
+}
+
+// dbHealthChecker probes the database in the background so dead pooled connections, such
+// as those left pointing at the old primary after a failover, are found and replaced before
+// a visitor's request runs into them. The first failed probe retires the pool's
+// connections; after cfg.Failures in a row the store moves to a DATABASE_FAILOVER_URLS
+// database, or without one the replica reports not ready until a probe succeeds again.
+type dbHealthChecker struct {
+	db  pinger
+	cfg dbHealthConfig
+
+	mu       sync.Mutex
+	failures int // consecutive failed probes
+}
+
+// pinger is the part of DataStore the checker probes with
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+func newDBHealthChecker(db pinger, cfg dbHealthConfig) *dbHealthChecker {
+	return &dbHealthChecker{db: db, cfg: cfg}
+}
+
+// Ready reports whether the database is considered up. A nil checker is always ready.
+func (c *dbHealthChecker) Ready() bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures < c.cfg.Failures
+}
+
+// Check probes the database once, resetting the pool on the first failure. When the
+// failures reach the limit it fails over to another database, if there is one, and while on
+// one it fails back as soon as the primary answers.
+func (c *dbHealthChecker) Check(ctx context.Context) {
+	pingCtx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	err := c.db.Ping(pingCtx)
+	cancel()
+
+	c.mu.Lock()
+	if err == nil {
+		if c.failures >= c.cfg.Failures {
+			log.Printf("Database health check recovered after %d failures, reporting ready", c.failures)
+		}
+		c.failures = 0
+		c.mu.Unlock()
+		c.switchDatabase(ctx, false)
+		return
+	}
+	c.failures++
+	failures := c.failures
+	c.mu.Unlock()
+
+	if failures == 1 {
+		// Pooled connections may all be dead; retire them so the next probe, and the next
+		// request, dial afresh
+		log.Printf("Database health check failed, reconnecting: %v", err)
+		if resetter, ok := c.db.(interface{ ResetConnections() }); ok {
+			resetter.ResetConnections()
+		}
+	}
+	if failures == c.cfg.Failures {
+		if c.switchDatabase(ctx, true) {
+			c.mu.Lock()
+			c.failures = 0
+			c.mu.Unlock()
+			return
+		}
+		log.Printf("Database health check failed %d times in a row, reporting not ready: %v", failures, err)
+	}
+}
+
+// failoverStore is implemented by stores that can move to another database
+type failoverStore interface {
+	Failover(ctx context.Context) error
+	FailBack(ctx context.Context) bool
+}
+
+// switchDatabase fails back to the primary if it answers, or with failover set, moves on
+// to the next database that does; it reports whether the store switched.
+func (c *dbHealthChecker) switchDatabase(ctx context.Context, failover bool) bool {
+	store, ok := c.db.(failoverStore)
+	if !ok {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	if store.FailBack(ctx) {
+		return true
+	}
+	return failover && store.Failover(ctx) == nil
+}
+
+// Run probes the database every interval until ctx is done.
+func (c *dbHealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Check(ctx)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// flakyDB fails its pings while down, counting the pool resets
+type flakyDB struct {
+	down   bool
+	resets int
+}
+
+func (d *flakyDB) Ping(ctx context.Context) error {
+	if d.down {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (d *flakyDB) ResetConnections() {
+	d.resets++
+}
+
+func Test_loadDBHealthConfig(t *testing.T) {
+	t.Setenv(dbHealthIntervalEnv, "5s")
+	t.Setenv(dbHealthTimeoutEnv, "soon")
+	t.Setenv(dbHealthFailureLimitEnv, "0")
+	cfg := loadDBHealthConfig()
+	want := dbHealthConfig{Interval: 5 * time.Second, Timeout: defaultDBHealthTimeout, Failures: defaultDBHealthFailures}
+	if cfg != want {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+func Test_dbHealthChecker(t *testing.T) {
+	db := &flakyDB{}
+	checker := newDBHealthChecker(db, dbHealthConfig{Interval: time.Second, Timeout: time.Second, Failures: 2})
+	ctx := context.Background()
+
+	checker.Check(ctx)
+	if !checker.Ready() || db.resets != 0 {
+		t.Fatalf("expected a healthy database left alone, got ready %v and %d resets", checker.Ready(), db.resets)
+	}
+
+	// The first failure reconnects straight away but keeps the replica in rotation
+	db.down = true
+	checker.Check(ctx)
+	if !checker.Ready() || db.resets != 1 {
+		t.Fatalf("expected one reset while still ready, got ready %v and %d resets", checker.Ready(), db.resets)
+	}
+
+	// Repeated failures take it out until the database answers again
+	checker.Check(ctx)
+	if checker.Ready() {
+		t.Fatal("expected not ready after two failures")
+	}
+	checker.Check(ctx)
+	if db.resets != 1 {
+		t.Errorf("expected the pool reset once per outage, got %d", db.resets)
+	}
+	db.down = false
+	checker.Check(ctx)
+	if !checker.Ready() {
+		t.Error("expected ready once the database recovers")
+	}
+}
+
+func Test_healthAndReadyHandler_DatabaseDown(t *testing.T) {
+	original := dbHealth
+	defer func() { dbHealth = original }()
+	db := &flakyDB{down: true}
+	dbHealth = newDBHealthChecker(db, dbHealthConfig{Interval: time.Second, Timeout: time.Second, Failures: 1})
+	dbHealth.Check(context.Background())
+
+	for path, want := range map[string]int{"/readyz": http.StatusServiceUnavailable, "/healthz": http.StatusOK} {
+		rr := httptest.NewRecorder()
+		healthAndReadyHandler(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, rr.Code)
+		}
+	}
+}
+
+// failoverDB is a flakyDB that can move to a failover database, which is always up
+type failoverDB struct {
+	flakyDB
+	primaryDown bool
+	onFailover  bool
+}
+
+func (d *failoverDB) Ping(ctx context.Context) error {
+	if d.primaryDown && !d.onFailover {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (d *failoverDB) Failover(ctx context.Context) error {
+	d.onFailover = true
+	return nil
+}
+
+func (d *failoverDB) FailBack(ctx context.Context) bool {
+	if !d.onFailover || d.primaryDown {
+		return false
+	}
+	d.onFailover = false
+	return true
+}
+
+func Test_dbHealthChecker_Failover(t *testing.T) {
+	db := &failoverDB{primaryDown: true}
+	checker := newDBHealthChecker(db, dbHealthConfig{Interval: time.Second, Timeout: time.Second, Failures: 2})
+	ctx := context.Background()
+
+	// The replica moves to the failover database instead of going out of rotation
+	checker.Check(ctx)
+	if db.onFailover {
+		t.Fatal("expected no failover after a single failure")
+	}
+	checker.Check(ctx)
+	if !db.onFailover || !checker.Ready() {
+		t.Fatalf("expected a failover while staying ready, got failover %v and ready %v", db.onFailover, checker.Ready())
+	}
+
+	// It stays there while the primary is down, and returns once it is back
+	checker.Check(ctx)
+	if !db.onFailover {
+		t.Fatal("expected to stay on the failover database")
+	}
+	db.primaryDown = false
+	checker.Check(ctx)
+	if db.onFailover {
+		t.Error("expected a fail back once the primary answers")
+	}
+}
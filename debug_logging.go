@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultDebugSampleRate = 1.0
+	defaultDebugMaxBody    = 1024
+	redactedValue          = "[REDACTED]"
+)
+
+// Headers whose values are never written to the debug log
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"Proxy-Authorization": true,
+	"X-Api-Key":           true,
+	"X-Csrf-Token":        true,
+	visitTokenHeader:      true,
+	visitNonceHeader:      true,
+	captchaHeader:         true,
+	signatureHeader:       true,
+}
+
+// Matches JSON string fields that look like secrets, e.g. "password":"hunter2"
+var sensitiveBodyFields = regexp.MustCompile(`(?i)("(?:password|secret|token|api_?key|authorization)[^"]*"\s*:\s*)"[^"]*"`)
+
+// Matches query parameter names whose values are never written to the debug log
+var sensitiveQueryParams = regexp.MustCompile(`(?i)password|secret|token|api_?key|authorization|signature|nonce|captcha|email`)
+
+// debugHTTPConfig controls the DEBUG_HTTP request/response logging mode.
+type debugHTTPConfig struct {
+	Enabled    bool
+	SampleRate float64
+	MaxBody    int
+}
+
+// loadDebugHTTPConfig reads DEBUG_HTTP, DEBUG_HTTP_SAMPLE_RATE and DEBUG_HTTP_MAX_BODY from the environment.
+func loadDebugHTTPConfig() debugHTTPConfig {
+	cfg := debugHTTPConfig{
+		SampleRate: defaultDebugSampleRate,
+		MaxBody:    defaultDebugMaxBody,
+	}
+	cfg.Enabled, _ = strconv.ParseBool(os.Getenv("DEBUG_HTTP"))
+
+	if v := os.Getenv("DEBUG_HTTP_SAMPLE_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			log.Printf("Invalid DEBUG_HTTP_SAMPLE_RATE %q, using %v", v, defaultDebugSampleRate)
+		} else {
+			cfg.SampleRate = rate
+		}
+	}
+
+	if v := os.Getenv("DEBUG_HTTP_MAX_BODY"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 0 {
+			log.Printf("Invalid DEBUG_HTTP_MAX_BODY %q, using %d", v, defaultDebugMaxBody)
+		} else {
+			cfg.MaxBody = size
+		}
+	}
+
+	return cfg
+}
+
+// sanitizeHeaders formats headers for logging with sensitive values redacted.
+func sanitizeHeaders(h http.Header) string {
+	parts := make([]string, 0, len(h))
+	for name, values := range h {
+		value := strings.Join(values, ", ")
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			value = redactedValue
+		}
+		parts = append(parts, name+"="+value)
+	}
+	// Map iteration order is random; keep log lines stable
+	sort.Strings(parts)
+	return strings.Join(parts, "; ")
+}
+
+// sanitizeURL formats the request path and query for logging, with the values of
+// secret-looking parameters redacted. A query that doesn't parse is left out.
+func sanitizeURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return u.Path + "?" + redactedValue
+	}
+	parts := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			if sensitiveQueryParams.MatchString(name) {
+				value = redactedValue
+			} else {
+				value = url.QueryEscape(value)
+			}
+			parts = append(parts, url.QueryEscape(name)+"="+value)
+		}
+	}
+	sort.Strings(parts)
+	return u.Path + "?" + strings.Join(parts, "&")
+}
+
+// sanitizeBody redacts secret-looking JSON fields from a captured body.
+func sanitizeBody(body []byte) string {
+	return sensitiveBodyFields.ReplaceAllString(string(body), `$1"`+redactedValue+`"`)
+}
+
+// bodyCaptureWriter records the status code and the first max bytes of the response body.
+type bodyCaptureWriter struct {
+	http.ResponseWriter
+	status    int
+	max       int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *bodyCaptureWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if remaining := w.max - w.body.Len(); remaining > 0 {
+		if len(b) > remaining {
+			w.body.Write(b[:remaining])
+			w.truncated = true
+		} else {
+			w.body.Write(b)
+		}
+	} else if len(b) > 0 {
+		w.truncated = true
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *bodyCaptureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// middleware that logs sanitized request headers and response bodies for a sample of requests
+func debugLoggingMiddleware(next http.Handler, cfg debugHTTPConfig) http.Handler {
+	if !cfg.Enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64() >= cfg.SampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &bodyCaptureWriter{ResponseWriter: w, max: cfg.MaxBody}
+		next.ServeHTTP(cw, r)
+
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		body := sanitizeBody(cw.body.Bytes())
+		if cw.truncated {
+			body += "...(truncated)"
+		}
+
+		log.Printf("DEBUG_HTTP: Request ID: %s - %s %s - Headers: %s - Status: %d - Body: %s",
+			requestIDFromContext(r.Context()), r.Method, sanitizeURL(r.URL), sanitizeHeaders(r.Header), status, body)
+	})
+}
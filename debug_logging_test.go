@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_loadDebugHTTPConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantEnable bool
+		wantRate   float64
+		wantMax    int
+	}{
+		{"Defaults", map[string]string{}, false, defaultDebugSampleRate, defaultDebugMaxBody},
+		{"Enabled with options", map[string]string{"DEBUG_HTTP": "true", "DEBUG_HTTP_SAMPLE_RATE": "0.25", "DEBUG_HTTP_MAX_BODY": "64"}, true, 0.25, 64},
+		{"Invalid options fall back", map[string]string{"DEBUG_HTTP": "1", "DEBUG_HTTP_SAMPLE_RATE": "2", "DEBUG_HTTP_MAX_BODY": "-1"}, true, defaultDebugSampleRate, defaultDebugMaxBody},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"DEBUG_HTTP", "DEBUG_HTTP_SAMPLE_RATE", "DEBUG_HTTP_MAX_BODY"} {
+				t.Setenv(k, tt.env[k])
+			}
+
+			cfg := loadDebugHTTPConfig()
+			if cfg.Enabled != tt.wantEnable || cfg.SampleRate != tt.wantRate || cfg.MaxBody != tt.wantMax {
+				t.Errorf("loadDebugHTTPConfig() = %+v, want enabled=%v rate=%v max=%d", cfg, tt.wantEnable, tt.wantRate, tt.wantMax)
+			}
+		})
+	}
+}
+
+func Test_sanitizeHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("Cookie", "session=abc")
+	h.Set("Origin", "http://allowed.com")
+	h.Set(visitTokenHeader, "1718000000.visit-mac")
+	h.Set(visitNonceHeader, "nonce-value")
+	h.Set(captchaHeader, "captcha-response")
+	h.Set(signatureHeader, "sha256=pipeline-mac")
+
+	got := sanitizeHeaders(h)
+	if strings.Contains(got, "secret") || strings.Contains(got, "session=abc") || strings.Contains(got, "visit-mac") ||
+		strings.Contains(got, "nonce-value") || strings.Contains(got, "captcha-response") || strings.Contains(got, "pipeline-mac") {
+		t.Errorf("expected sensitive headers to be redacted, got %s", got)
+	}
+	if !strings.Contains(got, "Origin=http://allowed.com") {
+		t.Errorf("expected Origin header to be kept, got %s", got)
+	}
+}
+
+func Test_sanitizeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/api/count", "/api/count"},
+		{"/api/stats?days=7&tz=Europe%2FBerlin", "/api/stats?days=7&tz=Europe%2FBerlin"},
+		{"/api/count?token=abc&days=7&Email=bob%40example.com", "/api/count?Email=[REDACTED]&days=7&token=[REDACTED]"},
+		{"/api/count?days=%zz", "/api/count?[REDACTED]"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sanitizeURL(u); got != tt.want {
+			t.Errorf("sanitizeURL(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func Test_sanitizeBody(t *testing.T) {
+	got := sanitizeBody([]byte(`{"user":"bob","password": "hunter2","api_key":"xyz"}`))
+	want := `{"user":"bob","password": "[REDACTED]","api_key":"[REDACTED]"}`
+	if got != want {
+		t.Errorf("sanitizeBody() = %s, want %s", got, want)
+	}
+}
+
+func Test_debugLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token":"abc","message":"hello world"}`))
+	})
+
+	handler := debugLoggingMiddleware(dummyHandler, debugHTTPConfig{Enabled: true, SampleRate: 1, MaxBody: 30})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/count?token=leaked", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+	if rr.Body.String() != `{"token":"abc","message":"hello world"}` {
+		t.Errorf("expected full body to reach the client, got %s", rr.Body.String())
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "DEBUG_HTTP") || !strings.Contains(out, "Status: 201") {
+		t.Errorf("expected debug log line, got %s", out)
+	}
+	if strings.Contains(out, "Bearer secret") || strings.Contains(out, `"abc"`) || strings.Contains(out, "leaked") {
+		t.Errorf("expected secrets to be redacted, got %s", out)
+	}
+	if !strings.Contains(out, "(truncated)") {
+		t.Errorf("expected body to be truncated, got %s", out)
+	}
+}
+
+func Test_debugLoggingMiddleware_Flush(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Streaming handlers flush through the capturing writer
+	var err error
+	handler := debugLoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: 1\n\n"))
+		err = http.NewResponseController(w).Flush()
+	}), debugHTTPConfig{Enabled: true, SampleRate: 1, MaxBody: 30})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/count/poll", nil))
+	if err != nil || !rr.Flushed {
+		t.Errorf("expected the response flushed, got %v", err)
+	}
+}
+
+func Test_debugLoggingMiddleware_Disabled(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := debugLoggingMiddleware(dummyHandler, debugHTTPConfig{SampleRate: 1})
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if buf.Len() != 0 {
+		t.Errorf("expected no debug output when disabled, got %s", buf.String())
+	}
+}
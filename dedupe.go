@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultDedupePruneInterval is how often expired dedupe keys are deleted
+const defaultDedupePruneInterval = time.Hour
+
+// visitDedupe counts a visitor at most once per window. Windows are claimed in the DataStore,
+// so a reload that lands on another replica is caught as well.
+type visitDedupe struct {
+	store  DataStore
+	hasher *visitorHasher
+	window time.Duration
+}
+
+// newVisitDedupeFromEnv dedupes visits when VISIT_DEDUPE_WINDOW is set to a positive duration,
+// returning nil otherwise.
+func newVisitDedupeFromEnv(store DataStore, hasher *visitorHasher) *visitDedupe {
+	v := os.Getenv("VISIT_DEDUPE_WINDOW")
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid VISIT_DEDUPE_WINDOW %q, not deduplicating visits", v)
+		return nil
+	}
+	return &visitDedupe{store: store, hasher: hasher, window: d}
+}
+
+// Claim reports whether the visitor making r may be counted, claiming their window if so. The
+// key is the visitor's daily hash, so windows end at UTC midnight at the latest, and nothing
+// kept outlives the day's salt. Store failures let the visit through, as do visitors who
+// don't allow tracking: their hash isn't stored, so their reloads are counted again.
+func (d *visitDedupe) Claim(r *http.Request, now time.Time) bool {
+	if !trackingAllowed(r.Context()) {
+		return true
+	}
+	claimed, err := d.store.ClaimDedupeKey(r.Context(), "visit:"+d.hasher.Hash(r, now), d.window)
+	return claimed || err != nil
+}
+
+// middleware that answers repeat visits within the dedupe window without counting them
+func visitDedupeMiddleware(next http.Handler, dedupe *visitDedupe, clock Clock) http.Handler {
+	if dedupe == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && !dedupe.Claim(r, clock.Now()) {
+			writeResponse(w, r, messageResponse{Message: "Visit already counted"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runDedupePruner deletes expired dedupe keys every interval until ctx is done.
+func runDedupePruner(ctx context.Context, dataStore DataStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Failures are logged by the store and retried on the next tick
+			_, _ = dataStore.DeleteExpiredDedupeKeys(ctx)
+		}
+	}
+}
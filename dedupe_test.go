@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_visitDedupeMiddleware(t *testing.T) {
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	mockDataStore := &MockDataStore{}
+	dedupe := &visitDedupe{store: mockDataStore, hasher: &visitorHasher{secret: []byte("test")}, window: 30 * time.Minute}
+	counted := 0
+	handler := visitDedupeMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counted++
+	}), dedupe, newFakeClock(now))
+
+	post := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	post("203.0.113.7")
+	w := post("203.0.113.7")
+	if counted != 1 {
+		t.Fatalf("expected a repeat visit not to be counted; counted %d", counted)
+	}
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Visit already counted") {
+		t.Errorf("expected the repeat visit to be acknowledged; got %d %s", w.Code, w.Body.String())
+	}
+
+	post("198.51.100.2")
+	if counted != 2 {
+		t.Errorf("expected another visitor to be counted; counted %d", counted)
+	}
+
+	// Reads aren't deduplicated
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, apiPath, nil))
+	if counted != 3 {
+		t.Errorf("expected GET to pass through; counted %d", counted)
+	}
+}
+
+func Test_visitDedupeMiddleware_TrackingDenied(t *testing.T) {
+	mockDataStore := &MockDataStore{}
+	dedupe := &visitDedupe{store: mockDataStore, hasher: &visitorHasher{secret: []byte("test")}, window: 30 * time.Minute}
+	counted := 0
+	handler := consentMiddleware(visitDedupeMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counted++
+	}), dedupe, realClock{}), consentConfig{DefaultGranted: true, Signals: privacySignalsAll})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+		req.Header.Set("DNT", "1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if counted != 2 || len(mockDataStore.dedupeKeys) != 0 {
+		t.Errorf("expected visits counted without storing the visitor's hash; counted %d, stored %v", counted, mockDataStore.dedupeKeys)
+	}
+}
+
+func Test_visitDedupeMiddleware_StoreError(t *testing.T) {
+	dedupe := &visitDedupe{store: failingStore{}, hasher: &visitorHasher{secret: []byte("test")}, window: time.Minute}
+	counted := 0
+	handler := visitDedupeMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counted++
+	}), dedupe, realClock{})
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, apiPath, nil))
+	}
+	if counted != 2 {
+		t.Errorf("expected visits to be counted when the dedupe store is down; counted %d", counted)
+	}
+}
+
+func Test_newVisitDedupeFromEnv(t *testing.T) {
+	hasher := &visitorHasher{secret: []byte("test")}
+	if d := newVisitDedupeFromEnv(&MockDataStore{}, hasher); d != nil {
+		t.Error("expected no dedupe without VISIT_DEDUPE_WINDOW")
+	}
+	t.Setenv("VISIT_DEDUPE_WINDOW", "30m")
+	if d := newVisitDedupeFromEnv(&MockDataStore{}, hasher); d == nil || d.window != 30*time.Minute {
+		t.Errorf("expected a 30m window; got %+v", d)
+	}
+	t.Setenv("VISIT_DEDUPE_WINDOW", "-1m")
+	if d := newVisitDedupeFromEnv(&MockDataStore{}, hasher); d != nil {
+		t.Error("expected an invalid window to disable dedupe")
+	}
+}
+
+// pruneCountingStore counts the prunes it is asked for
+type pruneCountingStore struct {
+	MockDataStore
+	prunes chan struct{}
+}
+
+func (s *pruneCountingStore) DeleteExpiredDedupeKeys(ctx context.Context) (int, error) {
+	s.prunes <- struct{}{}
+	return 0, nil
+}
+
+func Test_runDedupePruner(t *testing.T) {
+	store := &pruneCountingStore{prunes: make(chan struct{}, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runDedupePruner(ctx, store, 10*time.Millisecond)
+
+	select {
+	case <-store.prunes:
+	case <-time.After(time.Second):
+		t.Fatal("expected expired keys to be pruned")
+	}
+}
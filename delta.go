@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const countDeltaPath = "/api/count/delta"
+
+// countDeltaResponse is the body returned by GET /api/count/delta.
+type countDeltaResponse struct {
+	Visits int    `json:"visits"`
+	Since  string `json:"since"`
+	Until  string `json:"until"` // the since of the next request, so no visit is counted twice
+}
+
+// countDeltaHandler returns how many visits happened after the RFC 3339 since parameter,
+// letting a dashboard that already has a count add to it instead of reading it again.
+// Passing until back as the next since covers every visit exactly once.
+func countDeltaHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	raw := r.URL.Query().Get("since")
+	if raw == "" {
+		http.Error(w, "since is required", http.StatusBadRequest)
+		return
+	}
+	since, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid since %q: must be an RFC 3339 timestamp", raw), http.StatusBadRequest)
+		return
+	}
+	until := clock.Now().UTC()
+	if since.After(until) {
+		http.Error(w, fmt.Sprintf("invalid since %q: must not be in the future", raw), http.StatusBadRequest)
+		return
+	}
+
+	visits, err := dataStore.GetVisitCountBetween(r.Context(), since, until)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get visit count: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, r, countDeltaResponse{
+		Visits: visits,
+		Since:  since.UTC().Format(time.RFC3339Nano),
+		Until:  until.Format(time.RFC3339Nano),
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_countDeltaHandler(t *testing.T) {
+	now := time.Date(2024, 3, 3, 10, 5, 0, 0, time.UTC)
+	mockDataStore := &MockDataStore{visitCount: 4}
+
+	rr := httptest.NewRecorder()
+	countDeltaHandler(rr, httptest.NewRequest(http.MethodGet, countDeltaPath+"?since=2024-03-03T11:00:00%2B01:00", nil), mockDataStore, newFakeClock(now))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp countDeltaResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if resp != (countDeltaResponse{Visits: 4, Since: "2024-03-03T10:00:00Z", Until: "2024-03-03T10:05:00Z"}) {
+		t.Errorf("unexpected delta %+v", resp)
+	}
+
+	// The window ends now, where the next request starts
+	wantSince := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	if !mockDataStore.lastFrom.Equal(wantSince) || !mockDataStore.lastTo.Equal(now) {
+		t.Errorf("expected the window (%s, %s]; got (%s, %s]", wantSince, now, mockDataStore.lastFrom, mockDataStore.lastTo)
+	}
+}
+
+func Test_countDeltaHandler_InvalidRequest(t *testing.T) {
+	now := time.Date(2024, 3, 3, 10, 5, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		method, url string
+		status      int
+	}{
+		{http.MethodPost, countDeltaPath + "?since=2024-03-03T10:00:00Z", http.StatusMethodNotAllowed},
+		{http.MethodGet, countDeltaPath, http.StatusBadRequest},
+		{http.MethodGet, countDeltaPath + "?since=2024-03-03", http.StatusBadRequest},
+		{http.MethodGet, countDeltaPath + "?since=2024-03-03T10:06:00Z", http.StatusBadRequest},
+	} {
+		rr := httptest.NewRecorder()
+		countDeltaHandler(rr, httptest.NewRequest(tt.method, tt.url, nil), &MockDataStore{}, newFakeClock(now))
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.url, tt.status, rr.Code)
+		}
+	}
+}
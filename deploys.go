@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	deploysPath           = "/api/deploys"
+	deployAnnotationsPath = "/api/deploys/annotations"
+	deployAnnotationTag   = "deploy"
+
+	maxDeployBodyBytes         = 4 << 10
+	maxDeployDescriptionLength = 500
+	maxDeployAge               = 366 * 24 * time.Hour // how far back a deploy may be backdated
+	maxDeployClockSkew         = 5 * time.Minute      // allows for pipelines whose clocks run slightly ahead
+)
+
+// commitSHAPattern matches abbreviated or full SHA-1 and SHA-256 commit IDs
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{7,64}$`)
+
+// deployRequest is the body of POST /api/deploys. DeployedAt defaults to now.
+type deployRequest struct {
+	CommitSHA   string     `json:"commit_sha"`
+	DeployedAt  *time.Time `json:"deployed_at"`
+	Description string     `json:"description"`
+}
+
+// deployMarker is one deployment, as recorded and as it annotates the stats.
+type deployMarker struct {
+	ID          int64     `json:"id"`
+	CommitSHA   string    `json:"commit_sha"`
+	DeployedAt  time.Time `json:"deployed_at"`
+	Description string    `json:"description,omitempty"`
+}
+
+// deploysResponse is the body returned by GET /api/deploys.
+type deploysResponse struct {
+	Days    int            `json:"days"`
+	Deploys []deployMarker `json:"deploys"`
+}
+
+// grafanaAnnotation is one deploy in the format Grafana's JSON and Infinity data sources read
+// annotations in, with times in Unix milliseconds.
+type grafanaAnnotation struct {
+	Time  int64    `json:"time"`
+	Title string   `json:"title"`
+	Text  string   `json:"text"`
+	Tags  []string `json:"tags"`
+}
+
+func deployMarkers(deploys []Deploy) []deployMarker {
+	markers := make([]deployMarker, len(deploys))
+	for i, d := range deploys {
+		markers[i] = deployMarker{ID: d.ID, CommitSHA: d.CommitSHA, DeployedAt: d.DeployedAt, Description: d.Description}
+	}
+	return markers
+}
+
+// deploysHandler lists the deployments of the last days days with GET, and records one with
+// POST, which needs admin authorization: ADMIN_TOKEN, a client certificate on the admin
+// listener, or a signature when the path is in SIGNED_ROUTES.
+func deploysHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock, token string) {
+	switch r.Method {
+	case http.MethodGet:
+		listDeploys(w, r, dataStore, clock)
+	case http.MethodPost:
+		if adminAuthorized(w, r, token) {
+			recordDeploy(w, r, dataStore, clock)
+		}
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func listDeploys(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	now := clock.Now()
+	deploys, err := dataStore.GetDeploys(r.Context(), now.AddDate(0, 0, -days), now.Add(time.Second))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get deploys: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, deploysResponse{Days: days, Deploys: deployMarkers(deploys)})
+}
+
+func recordDeploy(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	var req deployRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDeployBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid deploy body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !commitSHAPattern.MatchString(req.CommitSHA) {
+		http.Error(w, "commit_sha must be 7 to 64 lowercase hex digits", http.StatusBadRequest)
+		return
+	}
+	if len(req.Description) > maxDeployDescriptionLength {
+		http.Error(w, fmt.Sprintf("description must be at most %d characters", maxDeployDescriptionLength), http.StatusBadRequest)
+		return
+	}
+	now := clock.Now()
+	deployedAt := now
+	if req.DeployedAt != nil {
+		deployedAt = *req.DeployedAt
+		if deployedAt.After(now.Add(maxDeployClockSkew)) || deployedAt.Before(now.Add(-maxDeployAge)) {
+			http.Error(w, "deployed_at must not be in the future or more than a year ago", http.StatusBadRequest)
+			return
+		}
+	}
+
+	saved, err := dataStore.RecordDeploy(r.Context(), Deploy{CommitSHA: req.CommitSHA, DeployedAt: deployedAt, Description: req.Description})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to record deploy: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponseStatus(w, r, http.StatusCreated, deployMarkers([]Deploy{saved})[0])
+}
+
+// deployAnnotationsHandler returns the deployments between from and to, Unix milliseconds as
+// Grafana's ${__from} and ${__to} give them, as Grafana annotations. Without them it covers
+// the default stats window.
+func deployAnnotationsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	to := clock.Now().Add(time.Second)
+	from := to.AddDate(0, 0, -defaultStatsDays)
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.URL.Query().Get(name); v != "" {
+			ms, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s must be a Unix time in milliseconds", name), http.StatusBadRequest)
+				return
+			}
+			*t = time.UnixMilli(ms)
+		}
+	}
+	if !from.Before(to) || to.Sub(from) > time.Duration(maxStatsDays)*24*time.Hour {
+		http.Error(w, fmt.Sprintf("from must be before to, and at most %d days apart", maxStatsDays), http.StatusBadRequest)
+		return
+	}
+
+	deploys, err := dataStore.GetDeploys(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get deploys: %v", err), http.StatusInternalServerError)
+		return
+	}
+	annotations := make([]grafanaAnnotation, len(deploys))
+	for i, d := range deploys {
+		annotations[i] = grafanaAnnotation{
+			Time:  d.DeployedAt.UnixMilli(),
+			Title: "Deploy " + d.CommitSHA,
+			Text:  d.Description,
+			Tags:  []string{deployAnnotationTag},
+		}
+	}
+	writeResponse(w, r, annotations)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_deploysHandler_Record(t *testing.T) {
+	now := time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(now)
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		want   int
+		wantAt time.Time
+	}{
+		{"Defaults to now", "admin-token", `{"commit_sha": "3f2c1ab", "description": "New projects page"}`, http.StatusCreated, now},
+		{"Backdated", "admin-token", `{"commit_sha": "3f2c1ab9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3", "deployed_at": "2024-03-01T09:30:00Z"}`, http.StatusCreated, time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)},
+		{"Invalid SHA", "admin-token", `{"commit_sha": "HEAD"}`, http.StatusBadRequest, time.Time{}},
+		{"In the future", "admin-token", `{"commit_sha": "3f2c1ab", "deployed_at": "2024-03-04T00:00:00Z"}`, http.StatusBadRequest, time.Time{}},
+		{"Unknown field", "admin-token", `{"commit_sha": "3f2c1ab", "branch": "main"}`, http.StatusBadRequest, time.Time{}},
+		{"Wrong token", "guess", `{"commit_sha": "3f2c1ab"}`, http.StatusUnauthorized, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{}
+			req := httptest.NewRequest(http.MethodPost, deploysPath, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+			deploysHandler(rr, req, mockDataStore, clock, "admin-token")
+			if rr.Code != tt.want {
+				t.Fatalf("expected %d; got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+			if tt.want != http.StatusCreated {
+				if len(mockDataStore.deploys) != 0 {
+					t.Errorf("expected nothing recorded, got %+v", mockDataStore.deploys)
+				}
+				return
+			}
+			var got deployMarker
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if got.ID != 1 || !got.DeployedAt.Equal(tt.wantAt) {
+				t.Errorf("unexpected deploy %+v", got)
+			}
+		})
+	}
+}
+
+func Test_deploysHandler_Signed(t *testing.T) {
+	now := time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(now)
+	secret := []byte("pipeline-secret")
+	mockDataStore := &MockDataStore{}
+	handler := signatureMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deploysHandler(w, r, mockDataStore, clock, "")
+	}), signingConfig{Secret: secret, Routes: map[string]bool{deploysPath: true}, MaxSkew: time.Minute}, clock)
+
+	// The build pipeline signs instead of holding the admin token, which isn't even set
+	body := `{"commit_sha": "3f2c1ab"}`
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, deploysPath, strings.NewReader(body))
+	req.Header.Set(signatureTimestampHeader, ts)
+	req.Header.Set(signatureHeader, signaturePrefix+requestSignature(secret, http.MethodPost, deploysPath, ts, []byte(body)))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated || len(mockDataStore.deploys) != 1 {
+		t.Fatalf("expected the signed deploy recorded, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func Test_deploysHandler_List(t *testing.T) {
+	now := time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)
+	mockDataStore := &MockDataStore{deploys: []Deploy{
+		{ID: 1, CommitSHA: "1111111", DeployedAt: now.AddDate(0, 0, -10)},
+		{ID: 2, CommitSHA: "2222222", DeployedAt: now.Add(-time.Hour), Description: "Fix typo"},
+	}}
+
+	rr := httptest.NewRecorder()
+	deploysHandler(rr, httptest.NewRequest(http.MethodGet, deploysPath+"?days=7", nil), mockDataStore, newFakeClock(now), "")
+	var got deploysResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if got.Days != 7 || len(got.Deploys) != 1 || got.Deploys[0].CommitSHA != "2222222" {
+		t.Errorf("expected the deploy of the last week, got %+v", got)
+	}
+}
+
+func Test_deployAnnotationsHandler(t *testing.T) {
+	now := time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)
+	deployed := now.Add(-time.Hour)
+	mockDataStore := &MockDataStore{deploys: []Deploy{{ID: 1, CommitSHA: "3f2c1ab", DeployedAt: deployed, Description: "New projects page"}}}
+
+	query := "?from=" + strconv.FormatInt(now.Add(-2*time.Hour).UnixMilli(), 10) + "&to=" + strconv.FormatInt(now.UnixMilli(), 10)
+	rr := httptest.NewRecorder()
+	deployAnnotationsHandler(rr, httptest.NewRequest(http.MethodGet, deployAnnotationsPath+query, nil), mockDataStore, newFakeClock(now))
+	var got []grafanaAnnotation
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	want := grafanaAnnotation{Time: deployed.UnixMilli(), Title: "Deploy 3f2c1ab", Text: "New projects page", Tags: []string{"deploy"}}
+	if len(got) != 1 || got[0].Time != want.Time || got[0].Title != want.Title || got[0].Text != want.Text || strings.Join(got[0].Tags, ",") != "deploy" {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	// An empty range is rejected
+	rr = httptest.NewRecorder()
+	deployAnnotationsHandler(rr, httptest.NewRequest(http.MethodGet, deployAnnotationsPath+"?from=2000&to=1000", nil), mockDataStore, newFakeClock(now))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rr.Code)
+	}
+}
+
+func Test_statsHandler_DeployMarkers(t *testing.T) {
+	now := time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)
+	mockDataStore := &MockDataStore{deploys: []Deploy{
+		{ID: 1, CommitSHA: "1111111", DeployedAt: now.AddDate(0, 0, -40)},
+		{ID: 2, CommitSHA: "2222222", DeployedAt: now.AddDate(0, 0, -2)},
+	}}
+
+	rr := httptest.NewRecorder()
+	statsHandler(rr, httptest.NewRequest(http.MethodGet, statsPath+"?days=7", nil), mockDataStore, newFakeClock(now))
+	var got statsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(got.Deploys) != 1 || got.Deploys[0].CommitSHA != "2222222" {
+		t.Errorf("expected the deploy in the window marked, got %+v", got.Deploys)
+	}
+}
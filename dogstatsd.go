@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// dogStatsDMetrics emits request metrics as DogStatsD datagrams over UDP.
+type dogStatsDMetrics struct {
+	conn     net.Conn
+	prefix   string
+	inFlight atomic.Int64
+}
+
+func newDogStatsDMetrics(addr, prefix string) (*dogStatsDMetrics, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to DogStatsD at %s: %w", addr, err)
+	}
+	return &dogStatsDMetrics{conn: conn, prefix: prefix}, nil
+}
+
+// send writes a single datagram like "prefix.name:value|type|#tag:value".
+// Delivery is best-effort; UDP errors are logged through the burst logger.
+func (m *dogStatsDMetrics) send(name, value, metricType string, tags ...string) {
+	var b strings.Builder
+	b.WriteString(m.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(metricType)
+	if len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(tags, ","))
+	}
+
+	if _, err := m.conn.Write([]byte(b.String())); err != nil {
+		errorLogger.Printf("Error sending DogStatsD metric %s: %v", name, err)
+	}
+}
+
+func (m *dogStatsDMetrics) RequestStarted() {
+	m.send("http.requests.in_flight", fmt.Sprint(m.inFlight.Add(1)), "g")
+}
+
+func (m *dogStatsDMetrics) RequestFinished(r *http.Request, method, endpoint string, status, size int, duration time.Duration) {
+	m.send("http.requests.in_flight", fmt.Sprint(m.inFlight.Add(-1)), "g")
+
+	tags := []string{"method:" + method, "endpoint:" + endpoint}
+	m.send("http.requests", "1", "c", tags...)
+	m.send("http.request.duration", fmt.Sprintf("%g", float64(duration)/float64(time.Millisecond)), "ms", tags...)
+	m.send("http.response.size", fmt.Sprint(size), "h", tags...)
+	if class := statusClass(status); class != "" {
+		m.send("http.request.errors", "1", "c", append(tags, "status_class:"+class)...)
+	}
+}
+
+func (m *dogStatsDMetrics) PanicRecovered() {
+	m.send("panics", "1", "c")
+}
+
+func (m *dogStatsDMetrics) RequestShed() {
+	m.send("http.requests.shed", "1", "c")
+}
+
+func (m *dogStatsDMetrics) RequestRateLimited(endpoint string) {
+	m.send("http.requests.rate_limited", "1", "c", "endpoint:"+endpoint)
+}
+
+func (m *dogStatsDMetrics) CaptchaVerified(provider, result string) {
+	m.send("captcha.verifications", "1", "c", "provider:"+provider, "result:"+result)
+}
+
+func (m *dogStatsDMetrics) OutboxDelivered(destination, result string) {
+	m.send("outbox.deliveries", "1", "c", "destination:"+destination, "result:"+result)
+}
+
+func (m *dogStatsDMetrics) CertificateExpiry(domain string, days float64) {
+	m.send("tls.certificate.expiry_days", fmt.Sprintf("%g", days), "g", "domain:"+domain)
+}
+
+func (m *dogStatsDMetrics) QueryFinished(ctx context.Context, operation, result string, rows int64, duration time.Duration) {
+	m.send("db.queries", "1", "c", "operation:"+operation, "result:"+result)
+	m.send("db.query.duration", fmt.Sprintf("%g", float64(duration)/float64(time.Millisecond)), "ms", "operation:"+operation)
+	if result == "ok" {
+		m.send("db.query.rows", fmt.Sprint(rows), "h", "operation:"+operation)
+	}
+}
+
+func (m *dogStatsDMetrics) DatabaseTarget(target int) {
+	m.send("db.active_target", fmt.Sprint(target), "g")
+}
+
+func (m *dogStatsDMetrics) ClientTimestampViolation(endpoint, violation string) {
+	m.send("client_timestamp.violations", "1", "c", "endpoint:"+endpoint, "violation:"+violation)
+}
+
+// Close closes the UDP connection.
+func (m *dogStatsDMetrics) Close() {
+	if err := m.conn.Close(); err != nil {
+		log.Printf("Error closing DogStatsD connection: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_dogStatsDMetrics(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer conn.Close()
+
+	m, err := newDogStatsDMetrics(conn.LocalAddr().String(), "test.")
+	if err != nil {
+		t.Fatalf("newDogStatsDMetrics() error = %v", err)
+	}
+	defer m.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/count", nil)
+	m.RequestStarted()
+	m.RequestFinished(req, http.MethodGet, "/api/count", http.StatusServiceUnavailable, 42, 3*time.Millisecond)
+	m.PanicRecovered()
+	m.RequestShed()
+	m.CaptchaVerified("turnstile", "failed")
+	m.OutboxDelivered("webhook", "retried")
+	m.CertificateExpiry("example.com:443", 12.5)
+
+	want := []string{
+		"test.http.requests.in_flight:1|g",
+		"test.http.requests.in_flight:0|g",
+		"test.http.requests:1|c|#method:GET,endpoint:/api/count",
+		"test.http.request.duration:3|ms|#method:GET,endpoint:/api/count",
+		"test.http.response.size:42|h|#method:GET,endpoint:/api/count",
+		"test.http.request.errors:1|c|#method:GET,endpoint:/api/count,status_class:5xx",
+		"test.panics:1|c",
+		"test.http.requests.shed:1|c",
+		"test.captcha.verifications:1|c|#provider:turnstile,result:failed",
+		"test.outbox.deliveries:1|c|#destination:webhook,result:retried",
+		"test.tls.certificate.expiry_days:12.5|g|#domain:example.com:443",
+	}
+
+	buf := make([]byte, 1024)
+	for _, w := range want {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("expected datagram %q, got error: %v", w, err)
+		}
+		if got := strings.TrimSpace(string(buf[:n])); got != w {
+			t.Errorf("expected datagram %q, got %q", w, got)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"resume-backend/internal/logging"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// responseEncoder writes API responses in one media type.
+type responseEncoder interface {
+	// ContentType is the Content-Type header sent with the response
+	ContentType() string
+	// Accepts reports whether the media type names this encoding
+	Accepts(mediaType string) bool
+	// Encode writes v, the response to r
+	Encode(w io.Writer, r *http.Request, v interface{}) error
+}
+
+// responseEncoders are the encodings clients can ask for in Accept. JSON comes first, so it
+// wins ties and is used when nothing else matches.
+var responseEncoders = []responseEncoder{jsonEncoder{}, xmlEncoder{}, msgpackEncoder{}, protobufEncoder{}, jsonAPIEncoder{}}
+
+// negotiateEncoder picks the encoder with the highest quality in the request's Accept header.
+// Without a header, or when no supported type is acceptable, the response is JSON.
+func negotiateEncoder(r *http.Request) responseEncoder {
+	accept := r.Header.Get("Accept")
+	// What browsers and most clients send is settled without parsing
+	switch accept {
+	case "", "*/*", "application/json":
+		return responseEncoders[0]
+	}
+
+	best, bestQ := responseEncoders[0], 0.0
+	for _, enc := range responseEncoders {
+		if q := acceptQuality(accept, enc); q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value accept gives enc, preferring the most specific range that
+// matches: an exact type over type/*, and type/* over */*.
+func acceptQuality(accept string, enc responseEncoder) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch {
+		case enc.Accepts(mediaType):
+			s = 2
+		case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(enc.ContentType(), strings.TrimSuffix(mediaType, "*")):
+			s = 1
+		case mediaType == "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q
+}
+
+// writeResponse encodes v in the encoding the client negotiated. Callers set any other
+// headers, such as Cache-Control, first.
+func writeResponse(w http.ResponseWriter, r *http.Request, v interface{}) {
+	enc := negotiateEncoder(r)
+	setResponseHeaders(w, enc)
+	if err := enc.Encode(w, r, v); err != nil {
+		logging.FromContext(r.Context()).Printf("Error encoding response: %v", err)
+	}
+}
+
+// writeResponseStatus is writeResponse with a status other than 200 OK.
+func writeResponseStatus(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	enc := negotiateEncoder(r)
+	setResponseHeaders(w, enc)
+	w.WriteHeader(status)
+	if err := enc.Encode(w, r, v); err != nil {
+		logging.FromContext(r.Context()).Printf("Error encoding response: %v", err)
+	}
+}
+
+// Header values shared by every response. Assigning them directly, rather than through
+// Header.Set, saves an allocation per header on hot paths; net/http only reads them, and
+// an Add to a full slice copies it before appending.
+var (
+	jsonContentType = []string{"application/json"}
+	varyAccept      = []string{"Accept"}
+)
+
+// setResponseHeaders sets the Content-Type of enc and notes that it was negotiated.
+func setResponseHeaders(w http.ResponseWriter, enc responseEncoder) {
+	h := w.Header()
+	if _, ok := enc.(jsonEncoder); ok {
+		h["Content-Type"] = jsonContentType
+	} else {
+		h.Set("Content-Type", enc.ContentType())
+	}
+	if len(h["Vary"]) == 0 {
+		h["Vary"] = varyAccept
+	} else {
+		h.Add("Vary", "Accept")
+	}
+}
+
+// responseBuffer is a buffer and the encoders writing into it, reused between responses so
+// that polling clients don't have each response allocate them anew. Responses are encoded
+// into the buffer and written in one Write.
+type responseBuffer struct {
+	buf  bytes.Buffer
+	json *json.Encoder
+	xml  *xml.Encoder
+}
+
+// maxPooledResponseBuffer caps the buffers kept for reuse, so one large response doesn't pin
+// its memory for good
+const maxPooledResponseBuffer = 64 << 10
+
+var responseBuffers = sync.Pool{New: func() interface{} {
+	b := &responseBuffer{}
+	b.buf.Grow(512)
+	b.json = json.NewEncoder(&b.buf)
+	b.xml = xml.NewEncoder(&b.buf)
+	return b
+}}
+
+func getResponseBuffer() *responseBuffer {
+	b := responseBuffers.Get().(*responseBuffer)
+	b.buf.Reset()
+	return b
+}
+
+// putResponseBuffer returns b for reuse. Buffers whose encoders failed part way must not be
+// returned, as the XML encoder would keep its unclosed elements.
+func putResponseBuffer(b *responseBuffer) {
+	if b.buf.Cap() <= maxPooledResponseBuffer {
+		responseBuffers.Put(b)
+	}
+}
+
+// writeTo writes what was encoded into the buffer to w.
+func (b *responseBuffer) writeTo(w io.Writer) error {
+	_, err := w.Write(b.buf.Bytes())
+	return err
+}
+
+// jsonEncoder is the API's native encoding.
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+func (jsonEncoder) Accepts(mediaType string) bool { return mediaType == "application/json" }
+
+func (jsonEncoder) Encode(w io.Writer, r *http.Request, v interface{}) error {
+	b := getResponseBuffer()
+	defer putResponseBuffer(b)
+	if err := b.json.Encode(v); err != nil {
+		return err
+	}
+	return b.writeTo(w)
+}
+
+// The other encodings are derived from the JSON form, so field names, omitted fields and
+// value formats (RFC 3339 timestamps, for one) are the same whichever a client asks for.
+
+// jsonField is one member of a decoded JSON object.
+type jsonField struct {
+	Name  string
+	Value interface{}
+}
+
+// jsonObject is a decoded JSON object with its member order kept.
+type jsonObject []jsonField
+
+// MarshalJSON writes the object back out with its members in order.
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, f := range o {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, err := json.Marshal(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(append(buf, name...), ':'), value...)
+	}
+	return append(buf, '}'), nil
+}
+
+// toJSONTree round-trips v through JSON into jsonObject, []interface{}, string, json.Number,
+// bool or nil values.
+func toJSONTree(v interface{}) (interface{}, error) {
+	b := getResponseBuffer()
+	defer putResponseBuffer(b)
+	if err := b.json.Encode(v); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	dec.UseNumber()
+	return decodeJSONTree(dec)
+}
+
+func decodeJSONTree(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{Name: name.(string), Value: value})
+		}
+		_, err := dec.Token() // closing brace
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			value, err := decodeJSONTree(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err := dec.Token() // closing bracket
+		return arr, err
+	default:
+		return tok, nil
+	}
+}
+
+// xmlEncoder writes responses as a <response> element with a child element per field.
+// Array items are <item> elements, and fields whose names aren't valid XML names, such as
+// free-form event properties, are written as <field name="...">.
+type xmlEncoder struct{}
+
+func (xmlEncoder) ContentType() string { return "application/xml" }
+
+func (xmlEncoder) Accepts(mediaType string) bool {
+	return mediaType == "application/xml" || mediaType == "text/xml"
+}
+
+func (xmlEncoder) Encode(w io.Writer, r *http.Request, v interface{}) error {
+	tree, err := toJSONTree(v)
+	if err != nil {
+		return err
+	}
+	b := getResponseBuffer()
+	b.buf.WriteString(xml.Header)
+	if err := encodeXML(b.xml, xml.StartElement{Name: xml.Name{Local: "response"}}, tree); err != nil {
+		return err
+	}
+	if err := b.xml.Flush(); err != nil {
+		return err
+	}
+	defer putResponseBuffer(b)
+	return b.writeTo(w)
+}
+
+// xmlNamePattern matches the field names that can be used as element names as-is
+var xmlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+func encodeXML(enc *xml.Encoder, start xml.StartElement, v interface{}) error {
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case jsonObject:
+		for _, f := range v {
+			child := xml.StartElement{Name: xml.Name{Local: f.Name}}
+			if !xmlNamePattern.MatchString(f.Name) || strings.HasPrefix(strings.ToLower(f.Name), "xml") {
+				child = xml.StartElement{Name: xml.Name{Local: "field"}, Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: f.Name}}}
+			}
+			if err := encodeXML(enc, child, f.Value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := encodeXML(enc, xml.StartElement{Name: xml.Name{Local: "item"}}, item); err != nil {
+				return err
+			}
+		}
+	case nil:
+		// null is an empty element
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// msgpackEncoder writes responses as MessagePack, with objects as maps keyed by field name.
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) ContentType() string { return "application/msgpack" }
+
+func (msgpackEncoder) Accepts(mediaType string) bool {
+	return mediaType == "application/msgpack" || mediaType == "application/x-msgpack" || mediaType == "application/vnd.msgpack"
+}
+
+func (msgpackEncoder) Encode(w io.Writer, r *http.Request, v interface{}) error {
+	tree, err := toJSONTree(v)
+	if err != nil {
+		return err
+	}
+	b := getResponseBuffer()
+	defer putResponseBuffer(b)
+	_, err = w.Write(appendMsgpack(b.buf.AvailableBuffer(), tree))
+	return err
+}
+
+// appendMsgpack appends the MessagePack encoding of a JSON tree value to b, using the
+// smallest format that holds each value.
+func appendMsgpack(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, n)
+		}
+		f, _ := v.Float64()
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f))
+	case string:
+		return appendMsgpackString(b, v)
+	case []interface{}:
+		b = appendMsgpackLength(b, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			b = appendMsgpack(b, item)
+		}
+		return b
+	case jsonObject:
+		b = appendMsgpackLength(b, len(v), 0x80, 0xde)
+		for _, f := range v {
+			b = appendMsgpackString(b, f.Name) // not through appendMsgpack, which would box it
+			b = appendMsgpack(b, f.Value)
+		}
+		return b
+	default:
+		panic(fmt.Sprintf("unexpected JSON tree value %T", v))
+	}
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// appendMsgpackLength writes an array or map header: fix is the fixarray/fixmap prefix and
+// wide the 16-bit form, which is followed by the 32-bit one.
+func appendMsgpackLength(b []byte, n int, fix, wide byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, wide), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, wide+1), uint32(n))
+	}
+}
+
+func appendMsgpackInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n < 128:
+		return append(b, byte(n))
+	case n >= -32 && n < 0:
+		return append(b, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		return append(b, 0xcc, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	case n >= 0:
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), uint64(n))
+	case n >= math.MinInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+	}
+}
+
+// protobufEncoder writes responses as a google.protobuf.Value, the well-known type for
+// schemaless JSON, so clients can decode them without this API's .proto files. As with
+// JSON in proto3, all numbers are doubles.
+type protobufEncoder struct{}
+
+func (protobufEncoder) ContentType() string {
+	return "application/x-protobuf; proto=google.protobuf.Value"
+}
+
+func (protobufEncoder) Accepts(mediaType string) bool {
+	return mediaType == "application/x-protobuf" || mediaType == "application/protobuf"
+}
+
+func (protobufEncoder) Encode(w io.Writer, r *http.Request, v interface{}) error {
+	tree, err := toJSONTree(v)
+	if err != nil {
+		return err
+	}
+	b := getResponseBuffer()
+	defer putResponseBuffer(b)
+	data, err := proto.MarshalOptions{}.MarshalAppend(b.buf.AvailableBuffer(), protobufValue(tree))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// protobufValue converts a JSON tree value to a structpb.Value.
+func protobufValue(v interface{}) *structpb.Value {
+	switch v := v.(type) {
+	case nil:
+		return structpb.NewNullValue()
+	case bool:
+		return structpb.NewBoolValue(v)
+	case json.Number:
+		f, _ := v.Float64()
+		return structpb.NewNumberValue(f)
+	case string:
+		return structpb.NewStringValue(v)
+	case []interface{}:
+		list := &structpb.ListValue{Values: make([]*structpb.Value, len(v))}
+		for i, item := range v {
+			list.Values[i] = protobufValue(item)
+		}
+		return structpb.NewListValue(list)
+	case jsonObject:
+		s := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(v))}
+		for _, f := range v {
+			s.Fields[f.Name] = protobufValue(f.Value)
+		}
+		return structpb.NewStructValue(s)
+	default:
+		panic(fmt.Sprintf("unexpected JSON tree value %T", v))
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func Test_negotiateEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{"No header", "", "application/json"},
+		{"JSON", "application/json", "application/json"},
+		{"Anything", "*/*", "application/json"},
+		{"XML", "application/xml", "application/xml"},
+		{"Text XML", "text/xml", "application/xml"},
+		{"MsgPack", "application/msgpack", "application/msgpack"},
+		{"Legacy MsgPack", "application/x-msgpack", "application/msgpack"},
+		{"JSON:API", "application/vnd.api+json", "application/vnd.api+json"},
+		{"Protobuf", "application/x-protobuf", "application/x-protobuf; proto=google.protobuf.Value"},
+		{"Quality order", "application/json;q=0.5, application/xml;q=0.9", "application/xml"},
+		{"Excluded JSON", "application/json;q=0, */*", "application/xml"},
+		{"Specific range wins", "application/*;q=0.1, application/msgpack", "application/msgpack"},
+		{"Unsupported falls back to JSON", "text/html", "application/json"},
+		{"Browser default", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "application/xml"},
+		{"Malformed range ignored", "garbage;;, application/msgpack", "application/msgpack"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, apiPath, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if got := negotiateEncoder(req).ContentType(); got != tt.want {
+				t.Errorf("negotiateEncoder(%q) = %s, want %s", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+// encodingFixture exercises nesting, arrays, numbers, nulls and names XML can't use as-is.
+type encodingFixture struct {
+	Days       int             `json:"days"`
+	Rate       float64         `json:"rate"`
+	Missing    *string         `json:"missing"`
+	Referrers  []ReferrerCount `json:"referrers"`
+	Properties map[string]bool `json:"properties"`
+}
+
+var testEncodingFixture = encodingFixture{
+	Days:       7,
+	Rate:       0.5,
+	Referrers:  []ReferrerCount{{Domain: "linkedin.com", Visits: 300}},
+	Properties: map[string]bool{"1st": true},
+}
+
+func Test_xmlEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (xmlEncoder{}).Encode(&buf, nil, testEncodingFixture); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+
+	want := xml.Header + `<response><days>7</days><rate>0.5</rate><missing></missing>` +
+		`<referrers><item><domain>linkedin.com</domain><visits>300</visits></item></referrers>` +
+		`<properties><field name="1st">true</field></properties></response>`
+	if buf.String() != want {
+		t.Errorf("Encode() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func Test_msgpackEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (msgpackEncoder{}).Encode(&buf, nil, testEncodingFixture); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+
+	var want []byte
+	want = append(want, 0x85)
+	want = append(want, 0xa4, 'd', 'a', 'y', 's', 0x07)
+	want = append(want, 0xa4, 'r', 'a', 't', 'e', 0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0)
+	want = append(want, 0xa7, 'm', 'i', 's', 's', 'i', 'n', 'g', 0xc0)
+	want = append(want, 0xa9, 'r', 'e', 'f', 'e', 'r', 'r', 'e', 'r', 's', 0x91, 0x82)
+	want = append(want, 0xa6, 'd', 'o', 'm', 'a', 'i', 'n', 0xac, 'l', 'i', 'n', 'k', 'e', 'd', 'i', 'n', '.', 'c', 'o', 'm')
+	want = append(want, 0xa6, 'v', 'i', 's', 'i', 't', 's', 0xcd, 0x01, 0x2c)
+	want = append(want, 0xaa, 'p', 'r', 'o', 'p', 'e', 'r', 't', 'i', 'e', 's', 0x81, 0xa3, '1', 's', 't', 0xc3)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Encode() = % x\nwant       % x", buf.Bytes(), want)
+	}
+}
+
+func Test_appendMsgpack_Sizes(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want []byte
+	}{
+		{"Negative fixint", int64(-5), []byte{0xfb}},
+		{"Int8", int64(-100), []byte{0xd0, 0x9c}},
+		{"Uint8", int64(200), []byte{0xcc, 0xc8}},
+		{"Uint32", int64(70000), []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{"Int64", int64(-1 << 40), []byte{0xd3, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"Str8", strings.Repeat("a", 40), append([]byte{0xd9, 40}, strings.Repeat("a", 40)...)},
+		{"Array16", make([]interface{}, 16), append([]byte{0xdc, 0x00, 0x10}, bytes.Repeat([]byte{0xc0}, 16)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			if n, ok := tt.v.(int64); ok {
+				got = appendMsgpackInt(nil, n)
+			} else {
+				got = appendMsgpack(nil, tt.v)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_protobufEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (protobufEncoder{}).Encode(&buf, nil, testEncodingFixture); err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+
+	var value structpb.Value
+	if err := proto.Unmarshal(buf.Bytes(), &value); err != nil {
+		t.Fatalf("response is not a google.protobuf.Value: %v", err)
+	}
+	fields := value.GetStructValue().GetFields()
+	if fields["days"].GetNumberValue() != 7 || fields["rate"].GetNumberValue() != 0.5 {
+		t.Errorf("unexpected numbers: %v", fields)
+	}
+	if _, ok := fields["missing"].GetKind().(*structpb.Value_NullValue); !ok {
+		t.Errorf("expected missing to be null; got %v", fields["missing"])
+	}
+	referrer := fields["referrers"].GetListValue().GetValues()[0].GetStructValue().GetFields()
+	if referrer["domain"].GetStringValue() != "linkedin.com" || referrer["visits"].GetNumberValue() != 300 {
+		t.Errorf("unexpected referrer: %v", referrer)
+	}
+	if !fields["properties"].GetStructValue().GetFields()["1st"].GetBoolValue() {
+		t.Errorf("unexpected properties: %v", fields["properties"])
+	}
+}
+
+func Test_writeResponse(t *testing.T) {
+	mockDataStore := &MockDataStore{referrers: []ReferrerCount{{Domain: "github.com", Visits: 3}}}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		accept   string
+		wantType string
+		wantBody string
+	}{
+		{"", "application/json", `{"days":30,"referrers":[{"domain":"github.com","visits":3}]}` + "\n"},
+		{"application/xml", "application/xml", xml.Header + `<response><days>30</days><referrers><item><domain>github.com</domain><visits>3</visits></item></referrers></response>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantType, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, referrersPath, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			referrersHandler(w, req, mockDataStore, clock)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200; got %d", w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("expected Content-Type %s; got %s", tt.wantType, ct)
+			}
+			if vary := w.Header().Get("Vary"); vary != "Accept" {
+				t.Errorf("expected Vary: Accept; got %q", vary)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("unexpected body:\n%s\nwant\n%s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func BenchmarkWriteResponse(b *testing.B) {
+	for _, enc := range responseEncoders {
+		b.Run(enc.ContentType(), func(b *testing.B) {
+			req := httptest.NewRequest(http.MethodGet, referrersPath, nil)
+			req.Header.Set("Accept", enc.ContentType())
+			w := &discardResponseWriter{header: make(http.Header)}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w.reset()
+				writeResponse(w, req, testEncodingFixture)
+			}
+		})
+	}
+}
+
+func Test_responseEncoders_Reuse(t *testing.T) {
+	for _, enc := range responseEncoders {
+		req := httptest.NewRequest(http.MethodGet, referrersPath, nil)
+		var first, second bytes.Buffer
+		if err := enc.Encode(&first, req, testEncodingFixture); err != nil {
+			t.Fatalf("%s: Encode() error: %v", enc.ContentType(), err)
+		}
+		// A response encoded in between, with a pooled buffer, mustn't leak into the next
+		if err := enc.Encode(io.Discard, req, ReferrerCount{Domain: "github.com"}); err != nil {
+			t.Fatalf("%s: Encode() error: %v", enc.ContentType(), err)
+		}
+		if err := enc.Encode(&second, req, testEncodingFixture); err != nil {
+			t.Fatalf("%s: Encode() error: %v", enc.ContentType(), err)
+		}
+		if _, ok := enc.(protobufEncoder); ok {
+			// Struct fields are a map, so only the messages compare equal, not their bytes
+			var a, b structpb.Value
+			if proto.Unmarshal(first.Bytes(), &a) != nil || proto.Unmarshal(second.Bytes(), &b) != nil || !proto.Equal(&a, &b) {
+				t.Errorf("%s: expected the same response again", enc.ContentType())
+			}
+			continue
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("%s: expected the same response again; got\n%q\nthen\n%q", enc.ContentType(), first.Bytes(), second.Bytes())
+		}
+	}
+}
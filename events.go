@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+const (
+	eventsPath     = "/api/events"
+	eventStatsPath = "/api/events/stats"
+
+	maxEventBodyBytes = 8 << 10
+)
+
+// eventTypePattern keeps event types to snake_case identifiers like "clicked_github"
+var eventTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// eventPropertyPattern limits which property keys can be aggregated on
+var eventPropertyPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+// eventRequest is the body of POST /api/events.
+type eventRequest struct {
+	Type       string          `json:"type"`
+	SessionID  string          `json:"session_id"`
+	Properties json.RawMessage `json:"properties"`
+}
+
+// eventsHandler records a typed event with optional JSON object properties.
+func eventsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req eventRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEventBodyBytes)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid event body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !eventTypePattern.MatchString(req.Type) {
+		http.Error(w, "type must be a snake_case identifier of at most 64 characters", http.StatusBadRequest)
+		return
+	}
+	if props := bytes.TrimSpace(req.Properties); bytes.Equal(props, []byte("null")) {
+		req.Properties = nil
+	} else if len(props) > 0 && props[0] != '{' {
+		http.Error(w, "properties must be a JSON object", http.StatusBadRequest)
+		return
+	}
+	if req.SessionID != "" && !sessionIDPattern.MatchString(req.SessionID) {
+		req.SessionID = "" // a stale or forged token shouldn't reject the event
+	}
+	if !trackingAllowed(r.Context()) {
+		req.SessionID = "" // the event still counts, but isn't tied to the visitor
+	}
+
+	event := Event{Type: req.Type, Timestamp: clock.Now(), SessionID: req.SessionID, Properties: req.Properties}
+	if err := dataStore.RecordEvent(r.Context(), event); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to record event: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, r, messageResponse{Message: "Event recorded"})
+}
+
+// eventStatsResponse is the body returned by GET /api/events/stats.
+type eventStatsResponse struct {
+	Days     int          `json:"days"`
+	Type     string       `json:"type,omitempty"`
+	Property string       `json:"property,omitempty"`
+	Events   []EventCount `json:"events"`
+}
+
+// eventStatsHandler counts events per type over the last days days. With type and property
+// set, it counts that type's events per value of the property instead.
+func eventStatsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	eventType := r.URL.Query().Get("type")
+	if eventType != "" && !eventTypePattern.MatchString(eventType) {
+		http.Error(w, "type must be a snake_case identifier of at most 64 characters", http.StatusBadRequest)
+		return
+	}
+	property := r.URL.Query().Get("property")
+	if property != "" && (eventType == "" || !eventPropertyPattern.MatchString(property)) {
+		http.Error(w, "property needs a type and must be an identifier of at most 64 characters", http.StatusBadRequest)
+		return
+	}
+
+	now := clock.Now()
+	counts, err := dataStore.GetEventStats(r.Context(), EventStatsQuery{
+		From:     now.AddDate(0, 0, -days),
+		To:       now,
+		Type:     eventType,
+		Property: property,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get event stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if counts == nil {
+		counts = []EventCount{}
+	}
+
+	response := eventStatsResponse{Days: days, Type: eventType, Property: property, Events: counts}
+	writeResponse(w, r, response)
+}
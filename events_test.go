@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_eventsHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	sessionID := strings.Repeat("ab", 16)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		wantEvent      Event
+	}{
+		{"Event with properties", `{"type": "expanded_project", "properties": {"project": "resume-backend"}, "session_id": "` + sessionID + `"}`,
+			http.StatusOK, Event{Type: "expanded_project", SessionID: sessionID, Properties: json.RawMessage(`{"project": "resume-backend"}`)}},
+		{"Event without properties", `{"type": "clicked_github"}`, http.StatusOK, Event{Type: "clicked_github"}},
+		{"Null properties", `{"type": "clicked_github", "properties": null}`, http.StatusOK, Event{Type: "clicked_github"}},
+		{"Malformed session ID dropped", `{"type": "clicked_github", "session_id": "nope"}`, http.StatusOK, Event{Type: "clicked_github"}},
+		{"Missing type", `{"properties": {}}`, http.StatusBadRequest, Event{}},
+		{"Invalid type", `{"type": "Clicked GitHub"}`, http.StatusBadRequest, Event{}},
+		{"Properties not an object", `{"type": "clicked_github", "properties": [1, 2]}`, http.StatusBadRequest, Event{}},
+		{"Invalid JSON", `{"type":`, http.StatusBadRequest, Event{}},
+		{"Too large", `{"type": "clicked_github", "properties": {"x": "` + strings.Repeat("a", maxEventBodyBytes) + `"}}`, http.StatusBadRequest, Event{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{}
+			w := httptest.NewRecorder()
+			eventsHandler(w, httptest.NewRequest(http.MethodPost, eventsPath, strings.NewReader(tt.body)), mockDataStore, clock)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %d: %s", tt.expectedStatus, w.Code, w.Body)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				if len(mockDataStore.events) != 0 {
+					t.Error("expected a rejected event not to be recorded")
+				}
+				return
+			}
+
+			if len(mockDataStore.events) != 1 {
+				t.Fatalf("expected 1 event; got %d", len(mockDataStore.events))
+			}
+			got := mockDataStore.events[0]
+			if got.Type != tt.wantEvent.Type || got.SessionID != tt.wantEvent.SessionID ||
+				string(got.Properties) != string(tt.wantEvent.Properties) || !got.Timestamp.Equal(clock.Now()) {
+				t.Errorf("recorded %+v, want %+v", got, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func Test_eventStatsHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		wantQuery      EventStatsQuery
+	}{
+		{"All types", "", http.StatusOK, EventStatsQuery{From: clock.Now().AddDate(0, 0, -defaultStatsDays), To: clock.Now()}},
+		{"One type by property", "?days=7&type=expanded_project&property=project", http.StatusOK,
+			EventStatsQuery{From: clock.Now().AddDate(0, 0, -7), To: clock.Now(), Type: "expanded_project", Property: "project"}},
+		{"Property without type", "?property=project", http.StatusBadRequest, EventStatsQuery{}},
+		{"Invalid property", "?type=expanded_project&property=a.b", http.StatusBadRequest, EventStatsQuery{}},
+		{"Invalid type", "?type=DROP", http.StatusBadRequest, EventStatsQuery{}},
+		{"Invalid days", "?days=0", http.StatusBadRequest, EventStatsQuery{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{eventCounts: []EventCount{{Type: "expanded_project", Value: "resume-backend", Count: 3}}}
+			w := httptest.NewRecorder()
+			eventStatsHandler(w, httptest.NewRequest(http.MethodGet, eventStatsPath+tt.query, nil), mockDataStore, clock)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if mockDataStore.lastQuery != tt.wantQuery {
+				t.Errorf("queried %+v, want %+v", mockDataStore.lastQuery, tt.wantQuery)
+			}
+
+			var response eventStatsResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if len(response.Events) != 1 || response.Events[0].Count != 3 {
+				t.Errorf("unexpected response %+v", response)
+			}
+		})
+	}
+}
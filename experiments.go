@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const experimentPath = "/api/experiment/"
+
+// experimentNamePattern restricts experiment and variant names to what is safe in URLs and labels
+var experimentNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// experiments maps each experiment name to its variants, in the order they were configured
+type experiments map[string][]string
+
+// loadExperiments reads EXPERIMENTS, a list like "layout:control,compact;cta:a,b".
+// Invalid entries are logged and skipped.
+func loadExperiments() experiments {
+	exps := experiments{}
+	for _, entry := range strings.Split(os.Getenv("EXPERIMENTS"), ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, list, _ := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		var variants []string
+		for _, v := range strings.Split(list, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				variants = append(variants, v)
+			}
+		}
+
+		if !experimentNamePattern.MatchString(name) || len(variants) < 2 || !validVariants(variants) {
+			log.Printf("Invalid experiment %q in EXPERIMENTS: needs a name and at least two distinct variants", entry)
+			continue
+		}
+		exps[name] = variants
+	}
+	return exps
+}
+
+func validVariants(variants []string) bool {
+	seen := make(map[string]bool, len(variants))
+	for _, v := range variants {
+		if !experimentNamePattern.MatchString(v) || seen[v] {
+			return false
+		}
+		seen[v] = true
+	}
+	return true
+}
+
+// assignVariant deterministically buckets the visitor into one of variants. The key doesn't
+// rotate, so visitors keep their variant across days, and each experiment buckets independently.
+func assignVariant(h *visitorHasher, r *http.Request, experiment string, variants []string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(experiment))
+	mac.Write([]byte{0})
+	mac.Write([]byte(clientIP(r)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(r.UserAgent()))
+	return variants[binary.BigEndian.Uint64(mac.Sum(nil))%uint64(len(variants))]
+}
+
+// experimentAssignment is the body returned by GET /api/experiment/{name}.
+type experimentAssignment struct {
+	Experiment string `json:"experiment"`
+	Variant    string `json:"variant"`
+}
+
+// experimentHandler assigns the visitor a variant of the named experiment and records the exposure.
+func experimentHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, hasher *visitorHasher, exps experiments, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PathValue("name")
+	variants, ok := exps[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown experiment: %s", name), http.StatusNotFound)
+		return
+	}
+
+	variant := assignVariant(hasher, r, name, variants)
+	// The assignment is deterministic, so a lost exposure only affects results, not what the visitor sees
+	if trackingAllowed(r.Context()) {
+		now := clock.Now()
+		_ = dataStore.RecordExposure(r.Context(), Exposure{
+			Experiment:  name,
+			Variant:     variant,
+			Day:         now,
+			VisitorHash: hasher.Hash(r, now),
+		})
+	}
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	writeResponse(w, r, experimentAssignment{Experiment: name, Variant: variant})
+}
+
+// variantStats is one variant's entry in the results response.
+type variantStats struct {
+	VariantResult
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// experimentResultsResponse is the body returned by GET /api/experiment/{name}/results.
+type experimentResultsResponse struct {
+	Experiment string         `json:"experiment"`
+	Days       int            `json:"days"`
+	Variants   []variantStats `json:"variants"`
+}
+
+// experimentResultsHandler compares visit conversion per variant over the last days UTC days.
+func experimentResultsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, exps experiments, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PathValue("name")
+	variants, ok := exps[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown experiment: %s", name), http.StatusNotFound)
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	now := clock.Now().UTC()
+	results, err := dataStore.GetExperimentResults(r.Context(), name, now.AddDate(0, 0, -(days-1)), now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get experiment results: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Report every configured variant, including ones without exposures yet
+	byVariant := make(map[string]VariantResult, len(results))
+	for _, res := range results {
+		byVariant[res.Variant] = res
+	}
+	response := experimentResultsResponse{Experiment: name, Days: days, Variants: make([]variantStats, 0, len(variants))}
+	for _, v := range variants {
+		res, ok := byVariant[v]
+		if !ok {
+			res = VariantResult{Variant: v}
+		}
+		stats := variantStats{VariantResult: res}
+		if res.Exposures > 0 {
+			stats.ConversionRate = float64(res.Conversions) / float64(res.Exposures)
+		}
+		response.Variants = append(response.Variants, stats)
+	}
+
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_loadExperiments(t *testing.T) {
+	t.Setenv("EXPERIMENTS", "layout: control, compact ,timeline; cta:a; Bad Name:x,y; dup:a,a;;empty:")
+
+	want := experiments{"layout": {"control", "compact", "timeline"}}
+	if got := loadExperiments(); !reflect.DeepEqual(got, want) {
+		t.Errorf("loadExperiments() = %v, want %v", got, want)
+	}
+}
+
+func Test_assignVariant(t *testing.T) {
+	h := &visitorHasher{secret: []byte("test-secret")}
+	variants := []string{"control", "compact"}
+
+	seen := map[string]int{}
+	for i := 0; i < 200; i++ {
+		req := httptest.NewRequest(http.MethodGet, experimentPath+"layout", nil)
+		req.RemoteAddr = fmt.Sprintf("203.0.113.%d:1234", i)
+		req.Header.Set("User-Agent", fmt.Sprintf("agent-%d", i%7))
+
+		variant := assignVariant(h, req, "layout", variants)
+		if again := assignVariant(h, req, "layout", variants); again != variant {
+			t.Fatalf("expected a stable assignment, got %s then %s", variant, again)
+		}
+		seen[variant]++
+	}
+
+	for _, v := range variants {
+		if seen[v] < 60 {
+			t.Errorf("expected visitors to be spread across variants, got %v", seen)
+		}
+	}
+}
+
+func Test_experimentHandler(t *testing.T) {
+	h := &visitorHasher{secret: []byte("test-secret")}
+	exps := experiments{"layout": {"control", "compact"}}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+
+	mux := http.NewServeMux()
+	mockDataStore := &MockDataStore{}
+	mux.HandleFunc(experimentPath+"{name}", func(w http.ResponseWriter, r *http.Request) {
+		experimentHandler(w, r, mockDataStore, h, exps, clock)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, experimentPath+"layout", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+	var response experimentAssignment
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if want := assignVariant(h, req, "layout", exps["layout"]); response.Variant != want || response.Experiment != "layout" {
+		t.Errorf("unexpected assignment %+v, want variant %s", response, want)
+	}
+
+	if len(mockDataStore.exposures) != 1 {
+		t.Fatalf("expected 1 exposure; got %d", len(mockDataStore.exposures))
+	}
+	exposure := mockDataStore.exposures[0]
+	if exposure.Variant != response.Variant || exposure.VisitorHash != h.Hash(req, clock.Now()) || !exposure.Day.Equal(clock.Now()) {
+		t.Errorf("unexpected exposure %+v", exposure)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, experimentPath+"unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown experiment; got %d", w.Code)
+	}
+}
+
+func Test_experimentResultsHandler(t *testing.T) {
+	exps := experiments{"layout": {"control", "compact"}}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	mockDataStore := &MockDataStore{results: []VariantResult{{Variant: "control", Exposures: 8, Conversions: 2}}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(experimentPath+"{name}/results", func(w http.ResponseWriter, r *http.Request) {
+		experimentResultsHandler(w, r, mockDataStore, exps, clock)
+	})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, experimentPath+"layout/results?days=7", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+
+	var response experimentResultsResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	want := []variantStats{
+		{VariantResult: VariantResult{Variant: "control", Exposures: 8, Conversions: 2}, ConversionRate: 0.25},
+		{VariantResult: VariantResult{Variant: "compact"}},
+	}
+	if !reflect.DeepEqual(response.Variants, want) {
+		t.Errorf("unexpected variants %+v", response.Variants)
+	}
+	if wantFrom := time.Date(2024, 2, 26, 10, 0, 0, 0, time.UTC); !mockDataStore.lastFrom.Equal(wantFrom) {
+		t.Errorf("expected window to start on %v; got %v", wantFrom, mockDataStore.lastFrom)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, experimentPath+"layout/results?days=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid days; got %d", w.Code)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultExportInterval  = 5 * time.Minute
+	defaultExportBatchSize = 1000
+	maxExportBatchSize     = 10000
+
+	// exportSettleDelay holds back visits this recent: IDs are handed out before commit,
+	// so a newer row can become visible before an older one
+	exportSettleDelay = time.Minute
+)
+
+// visitSink ships visit rows to an analytics store. Write must be safe to retry with the
+// same rows; sinks dedupe on the visit ID where the backend allows it.
+type visitSink interface {
+	Name() string
+	Write(ctx context.Context, rows []VisitRow) error
+}
+
+// exportedVisit is the row layout written to every sink.
+type exportedVisit struct {
+	ID          int64  `json:"id"`
+	Timestamp   string `json:"timestamp"`
+	Referrer    string `json:"referrer,omitempty"`
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+}
+
+func newExportedVisit(v VisitRow) exportedVisit {
+	return exportedVisit{
+		ID:          v.ID,
+		Timestamp:   v.Timestamp.UTC().Format(time.RFC3339Nano),
+		Referrer:    v.Referrer,
+		UTMSource:   v.UTM.Source,
+		UTMMedium:   v.UTM.Medium,
+		UTMCampaign: v.UTM.Campaign,
+	}
+}
+
+// exportSinkFactory builds a sink from its environment settings.
+type exportSinkFactory func() (visitSink, error)
+
+// Factories by EXPORT_SINK name, added by registerExportSink from each sink's file
+var exportSinkFactories = map[string]exportSinkFactory{}
+
+// registerExportSink makes a sink available as an EXPORT_SINK value. It is meant to be
+// called from init functions and panics on duplicate names.
+func registerExportSink(name string, factory exportSinkFactory) {
+	if _, ok := exportSinkFactories[name]; ok {
+		panic("export sink registered twice: " + name)
+	}
+	exportSinkFactories[name] = factory
+}
+
+// exportConfig controls the visit exporter.
+type exportConfig struct {
+	Interval  time.Duration
+	BatchSize int
+}
+
+// loadExportConfig reads EXPORT_SINK and the settings of the chosen sink. The exporter is
+// disabled when EXPORT_SINK is unset.
+func loadExportConfig() (exportConfig, visitSink, error) {
+	cfg := exportConfig{Interval: defaultExportInterval, BatchSize: defaultExportBatchSize}
+
+	name := strings.ToLower(os.Getenv("EXPORT_SINK"))
+	if name == "" {
+		return exportConfig{}, nil, nil
+	}
+	factory, ok := exportSinkFactories[name]
+	if !ok {
+		names := make([]string, 0, len(exportSinkFactories))
+		for n := range exportSinkFactories {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return exportConfig{}, nil, fmt.Errorf("unknown EXPORT_SINK %q: available are %s", name, strings.Join(names, ", "))
+	}
+	sink, err := factory()
+	if err != nil {
+		return exportConfig{}, nil, err
+	}
+
+	if v := os.Getenv("EXPORT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return exportConfig{}, nil, fmt.Errorf("invalid EXPORT_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.Interval = d
+	}
+	if v := os.Getenv("EXPORT_BATCH_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxExportBatchSize {
+			return exportConfig{}, nil, fmt.Errorf("invalid EXPORT_BATCH_SIZE %q: must be between 1 and %d", v, maxExportBatchSize)
+		}
+		cfg.BatchSize = n
+	}
+
+	log.Printf("Visit export enabled: shipping to %s every %s", sink.Name(), cfg.Interval)
+	return cfg, sink, nil
+}
+
+// visitExporter incrementally ships visits to a sink, keeping its high-water mark in the DataStore.
+type visitExporter struct {
+	store DataStore
+	sink  visitSink
+	cfg   exportConfig
+	clock Clock
+}
+
+func newVisitExporter(store DataStore, sink visitSink, cfg exportConfig, clock Clock) *visitExporter {
+	return &visitExporter{store: store, sink: sink, cfg: cfg, clock: clock}
+}
+
+// Export ships batches until it catches up, returning the number of visits shipped. The mark
+// only advances after a batch is written, so a failed write is retried from the same place.
+func (e *visitExporter) Export(ctx context.Context) (int, error) {
+	mark, err := e.store.GetExportMark(ctx, e.sink.Name())
+	if err != nil {
+		return 0, err
+	}
+
+	before := e.clock.Now().Add(-exportSettleDelay)
+	shipped := 0
+	for {
+		// Stop between batches on shutdown; the mark keeps what has been shipped
+		if err := ctx.Err(); err != nil {
+			return shipped, err
+		}
+		rows, err := e.store.GetVisitsAfter(ctx, mark, before, e.cfg.BatchSize)
+		if err != nil || len(rows) == 0 {
+			return shipped, err
+		}
+		if err := e.sink.Write(ctx, rows); err != nil {
+			errorLogger.Printf("Error exporting visits to %s: %v", e.sink.Name(), err)
+			return shipped, err
+		}
+		mark = rows[len(rows)-1].ID
+		if err := e.store.SetExportMark(ctx, e.sink.Name(), mark); err != nil {
+			return shipped, err
+		}
+		shipped += len(rows)
+		if len(rows) < e.cfg.BatchSize {
+			return shipped, nil
+		}
+	}
+}
+
+// Run exports every Interval until ctx is done.
+func (e *visitExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Failures are logged and the next tick resumes from the mark
+			_, _ = e.Export(ctx)
+		}
+	}
+}
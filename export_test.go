@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingSink keeps the batches written to it
+type recordingSink struct {
+	batches [][]VisitRow
+	err     error
+}
+
+func (s *recordingSink) Name() string {
+	return "test"
+}
+
+func (s *recordingSink) Write(ctx context.Context, rows []VisitRow) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, rows)
+	return nil
+}
+
+func Test_loadExportConfig(t *testing.T) {
+	cfg, sink, err := loadExportConfig()
+	if sink != nil || err != nil {
+		t.Fatalf("expected export to be off without EXPORT_SINK; got %v, %v", sink, err)
+	}
+
+	t.Setenv("EXPORT_SINK", "ClickHouse")
+	t.Setenv("CLICKHOUSE_URL", "https://clickhouse.example.com:8443")
+	t.Setenv("EXPORT_INTERVAL", "1m")
+	t.Setenv("EXPORT_BATCH_SIZE", "500")
+	cfg, sink, err = loadExportConfig()
+	if err != nil {
+		t.Fatalf("loadExportConfig() error = %v", err)
+	}
+	if sink.Name() != "clickhouse" || cfg != (exportConfig{Interval: time.Minute, BatchSize: 500}) {
+		t.Errorf("expected a clickhouse sink every minute in batches of 500; got %s, %+v", sink.Name(), cfg)
+	}
+
+	for _, tt := range []struct{ env, value string }{
+		{"EXPORT_BATCH_SIZE", "0"},
+		{"EXPORT_INTERVAL", "never"},
+		{"EXPORT_SINK", "snowflake"},
+	} {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			if _, _, err := loadExportConfig(); err == nil {
+				t.Errorf("expected an error for %s=%q", tt.env, tt.value)
+			}
+		})
+	}
+}
+
+func Test_registerExportSink(t *testing.T) {
+	saved := exportSinkFactories
+	exportSinkFactories = map[string]exportSinkFactory{}
+	t.Cleanup(func() { exportSinkFactories = saved })
+
+	registerExportSink("test", func() (visitSink, error) { return nil, nil })
+	t.Setenv("EXPORT_SINK", "bigquery")
+	if _, _, err := loadExportConfig(); err == nil || !strings.Contains(err.Error(), "available are test") {
+		t.Errorf("expected an error listing the compiled-in sinks; got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a name twice to panic")
+		}
+	}()
+	registerExportSink("test", func() (visitSink, error) { return nil, nil })
+}
+
+func Test_visitExporter_Export(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	old := clock.Now().Add(-time.Hour)
+	mockDataStore := &MockDataStore{
+		visitRows: []VisitRow{
+			{ID: 1, Visit: Visit{Timestamp: old}},
+			{ID: 2, Visit: Visit{Timestamp: old}},
+			{ID: 3, Visit: Visit{Timestamp: old}},
+			{ID: 4, Visit: Visit{Timestamp: old}},
+			{ID: 5, Visit: Visit{Timestamp: old}},
+			// Still inside the settle delay
+			{ID: 6, Visit: Visit{Timestamp: clock.Now().Add(-time.Second)}},
+		},
+		exportMarks: map[string]int64{"test": 1},
+	}
+	sink := &recordingSink{}
+	e := newVisitExporter(mockDataStore, sink, exportConfig{BatchSize: 2}, clock)
+
+	shipped, err := e.Export(context.Background())
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if shipped != 4 || len(sink.batches) != 2 {
+		t.Errorf("expected 4 visits in 2 batches; got %d in %d", shipped, len(sink.batches))
+	}
+	if mark := mockDataStore.exportMarks["test"]; mark != 5 {
+		t.Errorf("expected the mark to advance to 5; got %d", mark)
+	}
+
+	// Once settled, the last visit goes out on the next run
+	clock.Advance(exportSettleDelay)
+	if shipped, _ := e.Export(context.Background()); shipped != 1 || mockDataStore.exportMarks["test"] != 6 {
+		t.Errorf("expected the settled visit to be shipped; got %d, mark %d", shipped, mockDataStore.exportMarks["test"])
+	}
+}
+
+func Test_visitExporter_Export_SinkError(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	mockDataStore := &MockDataStore{visitRows: []VisitRow{{ID: 1, Visit: Visit{Timestamp: clock.Now().Add(-time.Hour)}}}}
+	e := newVisitExporter(mockDataStore, &recordingSink{err: errors.New("sink down")}, exportConfig{BatchSize: 10}, clock)
+
+	if _, err := e.Export(context.Background()); err == nil {
+		t.Fatal("expected the sink error to be returned")
+	}
+	if mark := mockDataStore.exportMarks["test"]; mark != 0 {
+		t.Errorf("expected the mark not to move on failure; got %d", mark)
+	}
+}
+
+func Test_visitExporter_Export_Canceled(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	old := clock.Now().Add(-time.Hour)
+	mockDataStore := &MockDataStore{visitRows: []VisitRow{
+		{ID: 1, Visit: Visit{Timestamp: old}},
+		{ID: 2, Visit: Visit{Timestamp: old}},
+		{ID: 3, Visit: Visit{Timestamp: old}},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &cancelingSink{cancel: cancel}
+	e := newVisitExporter(mockDataStore, sink, exportConfig{BatchSize: 1}, clock)
+
+	shipped, err := e.Export(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the export to stop with context.Canceled; got %v", err)
+	}
+	if shipped != 1 || len(sink.batches) != 1 || mockDataStore.exportMarks["test"] != 1 {
+		t.Errorf("expected one batch shipped and marked before stopping; got %d, mark %d", shipped, mockDataStore.exportMarks["test"])
+	}
+}
+
+// cancelingSink cancels the export's context after the first batch
+type cancelingSink struct {
+	recordingSink
+	cancel context.CancelFunc
+}
+
+func (s *cancelingSink) Write(ctx context.Context, rows []VisitRow) error {
+	s.cancel()
+	return s.recordingSink.Write(ctx, rows)
+}
+
+func Test_newExportedVisit(t *testing.T) {
+	v := newExportedVisit(VisitRow{ID: 7, Visit: Visit{
+		Timestamp: time.Date(2024, 3, 3, 11, 0, 0, 0, time.FixedZone("CET", 3600)),
+		Referrer:  "github.com",
+		UTM:       UTM{Campaign: "launch"},
+	}})
+	want := exportedVisit{ID: 7, Timestamp: "2024-03-03T10:00:00Z", Referrer: "github.com", UTMCampaign: "launch"}
+	if v != want {
+		t.Errorf("newExportedVisit() = %+v, want %+v", v, want)
+	}
+}
@@ -0,0 +1,730 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+)
+
+// errInjectedFault is returned by FaultyStore for injected failures.
+var errInjectedFault = errors.New("injected fault")
+
+const defaultChaosTimeout = 30 * time.Second
+
+// faultConfig controls the failures FaultyStore injects.
+type faultConfig struct {
+	ErrorRate   float64       // fraction of calls that fail with errInjectedFault
+	Latency     time.Duration // delay added before every call
+	TimeoutRate float64       // fraction of calls that hang until the context ends or Timeout elapses
+	Timeout     time.Duration
+}
+
+// FaultyStore is a DataStore decorator that injects errors, latency and timeouts
+// so retry and timeout behaviour can be exercised in tests and staging.
+type FaultyStore struct {
+	DataStore
+	cfg  faultConfig
+	roll func() float64 // returns a value in [0, 1); swapped out for deterministic tests
+}
+
+// NewFaultyStore wraps ds with fault injection.
+func NewFaultyStore(ds DataStore, cfg faultConfig) *FaultyStore {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultChaosTimeout
+	}
+	return &FaultyStore{DataStore: ds, cfg: cfg, roll: rand.Float64}
+}
+
+// inject applies the configured latency and possibly fails the call.
+func (s *FaultyStore) inject(ctx context.Context) error {
+	if s.cfg.Latency > 0 {
+		if err := sleepContext(ctx, s.cfg.Latency); err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.TimeoutRate > 0 && s.roll() < s.cfg.TimeoutRate {
+		if err := sleepContext(ctx, s.cfg.Timeout); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %w", errInjectedFault, context.DeadlineExceeded)
+	}
+
+	if s.cfg.ErrorRate > 0 && s.roll() < s.cfg.ErrorRate {
+		return errInjectedFault
+	}
+	return nil
+}
+
+// IncrementVisitCount injects faults before delegating to the wrapped store.
+func (s *FaultyStore) IncrementVisitCount(ctx context.Context, visit Visit) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to increment visit count: %w", err)
+	}
+	return s.DataStore.IncrementVisitCount(ctx, visit)
+}
+
+// IncrementVisitCounts injects faults before delegating to the wrapped store.
+func (s *FaultyStore) IncrementVisitCounts(ctx context.Context, visits []Visit) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to increment visit counts: %w", err)
+	}
+	return s.DataStore.IncrementVisitCounts(ctx, visits)
+}
+
+// GetVisitCount injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetVisitCount(ctx context.Context) (int, error) {
+	if err := s.inject(ctx); err != nil {
+		return 0, fmt.Errorf("failed to get visit count: %w", err)
+	}
+	return s.DataStore.GetVisitCount(ctx)
+}
+
+// GetVisitCountBetween injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetVisitCountBetween(ctx context.Context, since, until time.Time) (int, error) {
+	if err := s.inject(ctx); err != nil {
+		return 0, fmt.Errorf("failed to get visit count in range: %w", err)
+	}
+	return s.DataStore.GetVisitCountBetween(ctx, since, until)
+}
+
+// GetVisitCountsInRanges injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetVisitCountsInRanges(ctx context.Context, ranges []TimeRange) ([]int, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get visit counts in ranges: %w", err)
+	}
+	return s.DataStore.GetVisitCountsInRanges(ctx, ranges)
+}
+
+// GetPageCounts injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetPageCounts(ctx context.Context, pages []string) (map[string]int, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get page counts: %w", err)
+	}
+	return s.DataStore.GetPageCounts(ctx, pages)
+}
+
+// GetDailyVisits injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get daily visits: %w", err)
+	}
+	return s.DataStore.GetDailyVisits(ctx, from, to, loc)
+}
+
+// GetSummary injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetSummary(ctx context.Context, from, to time.Time, loc *time.Location) (Summary, error) {
+	if err := s.inject(ctx); err != nil {
+		return Summary{}, fmt.Errorf("failed to get summary: %w", err)
+	}
+	return s.DataStore.GetSummary(ctx, from, to, loc)
+}
+
+// GetVisitHeatmap injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetVisitHeatmap(ctx context.Context, from, to time.Time, loc *time.Location) ([]HeatmapCell, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get visit heatmap: %w", err)
+	}
+	return s.DataStore.GetVisitHeatmap(ctx, from, to, loc)
+}
+
+// RecordUniqueVisitor injects faults before delegating to the wrapped store.
+func (s *FaultyStore) RecordUniqueVisitor(ctx context.Context, day time.Time, visitorHash string) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to record unique visitor: %w", err)
+	}
+	return s.DataStore.RecordUniqueVisitor(ctx, day, visitorHash)
+}
+
+// GetUniqueVisitorCount injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetUniqueVisitorCount(ctx context.Context, from, to time.Time) (int, error) {
+	if err := s.inject(ctx); err != nil {
+		return 0, fmt.Errorf("failed to get unique visitor count: %w", err)
+	}
+	return s.DataStore.GetUniqueVisitorCount(ctx, from, to)
+}
+
+// SaveVisitorSketch injects faults before delegating to the wrapped store.
+func (s *FaultyStore) SaveVisitorSketch(ctx context.Context, day time.Time, sketch []byte) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to save visitor sketch: %w", err)
+	}
+	return s.DataStore.SaveVisitorSketch(ctx, day, sketch)
+}
+
+// GetVisitorSketches injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetVisitorSketches(ctx context.Context, from, to time.Time) ([][]byte, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get visitor sketches: %w", err)
+	}
+	return s.DataStore.GetVisitorSketches(ctx, from, to)
+}
+
+// GetTopReferrers injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get top referrers: %w", err)
+	}
+	return s.DataStore.GetTopReferrers(ctx, from, to, limit)
+}
+
+// GetCampaignVisits injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetCampaignVisits(ctx context.Context, from, to time.Time, limit int) ([]CampaignCount, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get campaign visits: %w", err)
+	}
+	return s.DataStore.GetCampaignVisits(ctx, from, to, limit)
+}
+
+// RecordExposure injects faults before delegating to the wrapped store.
+func (s *FaultyStore) RecordExposure(ctx context.Context, exposure Exposure) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to record experiment exposure: %w", err)
+	}
+	return s.DataStore.RecordExposure(ctx, exposure)
+}
+
+// GetExperimentResults injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetExperimentResults(ctx context.Context, experiment string, from, to time.Time) ([]VariantResult, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get experiment results: %w", err)
+	}
+	return s.DataStore.GetExperimentResults(ctx, experiment, from, to)
+}
+
+// StartSession injects faults before delegating to the wrapped store.
+func (s *FaultyStore) StartSession(ctx context.Context, id string, now time.Time) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	return s.DataStore.StartSession(ctx, id, now)
+}
+
+// TouchSession injects faults before delegating to the wrapped store.
+func (s *FaultyStore) TouchSession(ctx context.Context, id string, now, idleSince time.Time) (bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return false, fmt.Errorf("failed to touch session: %w", err)
+	}
+	return s.DataStore.TouchSession(ctx, id, now, idleSince)
+}
+
+// CountActiveSessions injects faults before delegating to the wrapped store.
+func (s *FaultyStore) CountActiveSessions(ctx context.Context, since time.Time) (int, error) {
+	if err := s.inject(ctx); err != nil {
+		return 0, fmt.Errorf("failed to count active sessions: %w", err)
+	}
+	return s.DataStore.CountActiveSessions(ctx, since)
+}
+
+// AggregateSessions injects faults before delegating to the wrapped store.
+func (s *FaultyStore) AggregateSessions(ctx context.Context, since, closedBefore time.Time) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to aggregate sessions: %w", err)
+	}
+	return s.DataStore.AggregateSessions(ctx, since, closedBefore)
+}
+
+// GetSessionStats injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetSessionStats(ctx context.Context, from, to time.Time) ([]SessionDay, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get session stats: %w", err)
+	}
+	return s.DataStore.GetSessionStats(ctx, from, to)
+}
+
+// RecordEvent injects faults before delegating to the wrapped store.
+func (s *FaultyStore) RecordEvent(ctx context.Context, event Event) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to record event: %w", err)
+	}
+	return s.DataStore.RecordEvent(ctx, event)
+}
+
+// GetEventStats injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetEventStats(ctx context.Context, query EventStatsQuery) ([]EventCount, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get event stats: %w", err)
+	}
+	return s.DataStore.GetEventStats(ctx, query)
+}
+
+// GetFunnel injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetFunnel(ctx context.Context, from, to time.Time, steps []string) ([]int, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get funnel: %w", err)
+	}
+	return s.DataStore.GetFunnel(ctx, from, to, steps)
+}
+
+// GetHourlyVisits injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetHourlyVisits(ctx context.Context, from, to time.Time) ([]HourlyCount, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get hourly visits: %w", err)
+	}
+	return s.DataStore.GetHourlyVisits(ctx, from, to)
+}
+
+// RecordAnomaly injects faults before delegating to the wrapped store.
+func (s *FaultyStore) RecordAnomaly(ctx context.Context, anomaly Anomaly) (bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return false, fmt.Errorf("failed to record anomaly: %w", err)
+	}
+	return s.DataStore.RecordAnomaly(ctx, anomaly)
+}
+
+// GetAnomalies injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetAnomalies(ctx context.Context, from, to time.Time) ([]Anomaly, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get anomalies: %w", err)
+	}
+	return s.DataStore.GetAnomalies(ctx, from, to)
+}
+
+// GetVisitsAfter injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetVisitsAfter(ctx context.Context, afterID int64, before time.Time, limit int) ([]VisitRow, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get visits to export: %w", err)
+	}
+	return s.DataStore.GetVisitsAfter(ctx, afterID, before, limit)
+}
+
+// StreamVisits injects faults before delegating to the wrapped store.
+func (s *FaultyStore) StreamVisits(ctx context.Context, afterID int64, before time.Time, limit int, fn func(VisitRow) error) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to stream visits: %w", err)
+	}
+	return s.DataStore.StreamVisits(ctx, afterID, before, limit, fn)
+}
+
+// GetExportMark injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetExportMark(ctx context.Context, sink string) (int64, error) {
+	if err := s.inject(ctx); err != nil {
+		return 0, fmt.Errorf("failed to get export mark: %w", err)
+	}
+	return s.DataStore.GetExportMark(ctx, sink)
+}
+
+// SetExportMark injects faults before delegating to the wrapped store.
+func (s *FaultyStore) SetExportMark(ctx context.Context, sink string, lastID int64) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to set export mark: %w", err)
+	}
+	return s.DataStore.SetExportMark(ctx, sink, lastID)
+}
+
+// IncrementVisitCountsWithOutbox injects faults before delegating to the wrapped store.
+func (s *FaultyStore) IncrementVisitCountsWithOutbox(ctx context.Context, visits []Visit, messages []OutboxMessage) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to increment visit counts with outbox: %w", err)
+	}
+	return s.DataStore.IncrementVisitCountsWithOutbox(ctx, visits, messages)
+}
+
+// EnqueueOutbox injects faults before delegating to the wrapped store.
+func (s *FaultyStore) EnqueueOutbox(ctx context.Context, messages []OutboxMessage) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to enqueue outbox messages: %w", err)
+	}
+	return s.DataStore.EnqueueOutbox(ctx, messages)
+}
+
+// GetFailedOutbox injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetFailedOutbox(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to query failed outbox messages: %w", err)
+	}
+	return s.DataStore.GetFailedOutbox(ctx, limit)
+}
+
+// RetryOutbox injects faults before delegating to the wrapped store.
+func (s *FaultyStore) RetryOutbox(ctx context.Context, id int64) (bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return false, fmt.Errorf("failed to retry outbox message: %w", err)
+	}
+	return s.DataStore.RetryOutbox(ctx, id)
+}
+
+// RunMaintenance injects faults before delegating to the wrapped store.
+func (s *FaultyStore) RunMaintenance(ctx context.Context, action MaintenanceAction, progress func(step string, elapsed time.Duration)) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to run maintenance: %w", err)
+	}
+	return s.DataStore.RunMaintenance(ctx, action, progress)
+}
+
+// ClaimOutbox injects faults before delegating to the wrapped store.
+func (s *FaultyStore) ClaimOutbox(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxMessage, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+	return s.DataStore.ClaimOutbox(ctx, now, leaseUntil, limit)
+}
+
+// CompleteOutbox injects faults before delegating to the wrapped store.
+func (s *FaultyStore) CompleteOutbox(ctx context.Context, id int64) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to complete outbox message: %w", err)
+	}
+	return s.DataStore.CompleteOutbox(ctx, id)
+}
+
+// FailOutbox injects faults before delegating to the wrapped store.
+func (s *FaultyStore) FailOutbox(ctx context.Context, id int64, retryAt *time.Time, lastError string) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to record outbox failure: %w", err)
+	}
+	return s.DataStore.FailOutbox(ctx, id, retryAt, lastError)
+}
+
+// AcquireLease injects faults before delegating to the wrapped store.
+func (s *FaultyStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return s.DataStore.AcquireLease(ctx, name, holder, ttl)
+}
+
+// ReleaseLease injects faults before delegating to the wrapped store.
+func (s *FaultyStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return s.DataStore.ReleaseLease(ctx, name, holder)
+}
+
+// ClaimDedupeKey injects faults before delegating to the wrapped store.
+func (s *FaultyStore) ClaimDedupeKey(ctx context.Context, key string, window time.Duration) (bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return false, fmt.Errorf("failed to claim dedupe key: %w", err)
+	}
+	return s.DataStore.ClaimDedupeKey(ctx, key, window)
+}
+
+// DeleteExpiredDedupeKeys injects faults before delegating to the wrapped store.
+func (s *FaultyStore) DeleteExpiredDedupeKeys(ctx context.Context) (int, error) {
+	if err := s.inject(ctx); err != nil {
+		return 0, fmt.Errorf("failed to delete expired dedupe keys: %w", err)
+	}
+	return s.DataStore.DeleteExpiredDedupeKeys(ctx)
+}
+
+// EnsureVisitPartitions injects faults before delegating to the wrapped store.
+func (s *FaultyStore) EnsureVisitPartitions(ctx context.Context, now time.Time, ahead int) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to create visits partitions: %w", err)
+	}
+	return s.DataStore.EnsureVisitPartitions(ctx, now, ahead)
+}
+
+// DropVisitPartitions injects faults before delegating to the wrapped store.
+func (s *FaultyStore) DropVisitPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to drop visits partitions: %w", err)
+	}
+	return s.DataStore.DropVisitPartitions(ctx, before)
+}
+
+// GetVisitorData injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetVisitorData(ctx context.Context, ids VisitorIDs) (VisitorData, error) {
+	if err := s.inject(ctx); err != nil {
+		return VisitorData{}, fmt.Errorf("failed to get visitor data: %w", err)
+	}
+	return s.DataStore.GetVisitorData(ctx, ids)
+}
+
+// RefreshSummaries injects faults before delegating to the wrapped store.
+func (s *FaultyStore) RefreshSummaries(ctx context.Context) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to refresh summaries: %w", err)
+	}
+	return s.DataStore.RefreshSummaries(ctx)
+}
+
+// GetSummarizedDailyVisits injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetSummarizedDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get summarized daily visits: %w", err)
+	}
+	return s.DataStore.GetSummarizedDailyVisits(ctx, from, to, loc)
+}
+
+// GetSummarizedTopReferrers injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetSummarizedTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get summarized top referrers: %w", err)
+	}
+	return s.DataStore.GetSummarizedTopReferrers(ctx, from, to, limit)
+}
+
+// ListProjects injects faults before delegating to the wrapped store.
+func (s *FaultyStore) ListProjects(ctx context.Context) ([]Project, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+	return s.DataStore.ListProjects(ctx)
+}
+
+// GetProject injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetProject(ctx context.Context, id int64) (Project, bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return Project{}, false, fmt.Errorf("failed to get project: %w", err)
+	}
+	return s.DataStore.GetProject(ctx, id)
+}
+
+// CreateProject injects faults before delegating to the wrapped store.
+func (s *FaultyStore) CreateProject(ctx context.Context, p Project) (Project, error) {
+	if err := s.inject(ctx); err != nil {
+		return Project{}, fmt.Errorf("failed to create project: %w", err)
+	}
+	return s.DataStore.CreateProject(ctx, p)
+}
+
+// UpdateProject injects faults before delegating to the wrapped store.
+func (s *FaultyStore) UpdateProject(ctx context.Context, p Project) (Project, bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return Project{}, false, fmt.Errorf("failed to update project: %w", err)
+	}
+	return s.DataStore.UpdateProject(ctx, p)
+}
+
+// DeleteProject injects faults before delegating to the wrapped store.
+func (s *FaultyStore) DeleteProject(ctx context.Context, id int64) (bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return false, fmt.Errorf("failed to delete project: %w", err)
+	}
+	return s.DataStore.DeleteProject(ctx, id)
+}
+
+// GetPublishedResume injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetPublishedResume(ctx context.Context) (ResumeVersion, bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return ResumeVersion{}, false, fmt.Errorf("failed to get published resume: %w", err)
+	}
+	return s.DataStore.GetPublishedResume(ctx)
+}
+
+// GetResumeDraft injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetResumeDraft(ctx context.Context) (ResumeVersion, bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return ResumeVersion{}, false, fmt.Errorf("failed to get resume draft: %w", err)
+	}
+	return s.DataStore.GetResumeDraft(ctx)
+}
+
+// SaveResumeDraft injects faults before delegating to the wrapped store.
+func (s *FaultyStore) SaveResumeDraft(ctx context.Context, content json.RawMessage) (ResumeVersion, error) {
+	if err := s.inject(ctx); err != nil {
+		return ResumeVersion{}, fmt.Errorf("failed to save resume draft: %w", err)
+	}
+	return s.DataStore.SaveResumeDraft(ctx, content)
+}
+
+// PublishResumeVersion injects faults before delegating to the wrapped store.
+func (s *FaultyStore) PublishResumeVersion(ctx context.Context, id int64) (ResumeVersion, bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return ResumeVersion{}, false, fmt.Errorf("failed to publish resume version: %w", err)
+	}
+	return s.DataStore.PublishResumeVersion(ctx, id)
+}
+
+// ListResumeVersions injects faults before delegating to the wrapped store.
+func (s *FaultyStore) ListResumeVersions(ctx context.Context, limit int) ([]ResumeVersion, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list resume versions: %w", err)
+	}
+	return s.DataStore.ListResumeVersions(ctx, limit)
+}
+
+// ListPosts injects faults before delegating to the wrapped store.
+func (s *FaultyStore) ListPosts(ctx context.Context, publishedBy *time.Time, limit, offset int) ([]Post, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list posts: %w", err)
+	}
+	return s.DataStore.ListPosts(ctx, publishedBy, limit, offset)
+}
+
+// GetPost injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetPost(ctx context.Context, slug string) (Post, bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return Post{}, false, fmt.Errorf("failed to get post: %w", err)
+	}
+	return s.DataStore.GetPost(ctx, slug)
+}
+
+// SavePost injects faults before delegating to the wrapped store.
+func (s *FaultyStore) SavePost(ctx context.Context, p Post) (Post, bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return Post{}, false, fmt.Errorf("failed to save post: %w", err)
+	}
+	return s.DataStore.SavePost(ctx, p)
+}
+
+// DeletePost injects faults before delegating to the wrapped store.
+func (s *FaultyStore) DeletePost(ctx context.Context, slug string) (bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return false, fmt.Errorf("failed to delete post: %w", err)
+	}
+	return s.DataStore.DeletePost(ctx, slug)
+}
+
+// ListShortLinks injects faults before delegating to the wrapped store.
+func (s *FaultyStore) ListShortLinks(ctx context.Context) ([]ShortLink, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list short links: %w", err)
+	}
+	return s.DataStore.ListShortLinks(ctx)
+}
+
+// GetShortLink injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetShortLink(ctx context.Context, slug string) (ShortLink, bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return ShortLink{}, false, fmt.Errorf("failed to get short link: %w", err)
+	}
+	return s.DataStore.GetShortLink(ctx, slug)
+}
+
+// SaveShortLink injects faults before delegating to the wrapped store.
+func (s *FaultyStore) SaveShortLink(ctx context.Context, l ShortLink) (ShortLink, bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return ShortLink{}, false, fmt.Errorf("failed to save short link: %w", err)
+	}
+	return s.DataStore.SaveShortLink(ctx, l)
+}
+
+// DeleteShortLink injects faults before delegating to the wrapped store.
+func (s *FaultyStore) DeleteShortLink(ctx context.Context, slug string) (bool, error) {
+	if err := s.inject(ctx); err != nil {
+		return false, fmt.Errorf("failed to delete short link: %w", err)
+	}
+	return s.DataStore.DeleteShortLink(ctx, slug)
+}
+
+// RecordUptimeCheck injects faults before delegating to the wrapped store.
+func (s *FaultyStore) RecordUptimeCheck(ctx context.Context, check UptimeCheck) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to record uptime check: %w", err)
+	}
+	return s.DataStore.RecordUptimeCheck(ctx, check)
+}
+
+// GetUptimeChecks injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetUptimeChecks(ctx context.Context, from, to time.Time) ([]UptimeCheck, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get uptime checks: %w", err)
+	}
+	return s.DataStore.GetUptimeChecks(ctx, from, to)
+}
+
+// SaveCertificateCheck injects faults before delegating to the wrapped store.
+func (s *FaultyStore) SaveCertificateCheck(ctx context.Context, check CertificateCheck) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to save certificate check: %w", err)
+	}
+	return s.DataStore.SaveCertificateCheck(ctx, check)
+}
+
+// GetCertificateChecks injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetCertificateChecks(ctx context.Context) ([]CertificateCheck, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get certificate checks: %w", err)
+	}
+	return s.DataStore.GetCertificateChecks(ctx)
+}
+
+// RecordDeploy injects faults before delegating to the wrapped store.
+func (s *FaultyStore) RecordDeploy(ctx context.Context, deploy Deploy) (Deploy, error) {
+	if err := s.inject(ctx); err != nil {
+		return Deploy{}, fmt.Errorf("failed to record deploy: %w", err)
+	}
+	return s.DataStore.RecordDeploy(ctx, deploy)
+}
+
+// GetDeploys injects faults before delegating to the wrapped store.
+func (s *FaultyStore) GetDeploys(ctx context.Context, from, to time.Time) ([]Deploy, error) {
+	if err := s.inject(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get deploys: %w", err)
+	}
+	return s.DataStore.GetDeploys(ctx, from, to)
+}
+
+// DeleteVisitorData injects faults before delegating to the wrapped store.
+func (s *FaultyStore) DeleteVisitorData(ctx context.Context, ids VisitorIDs) (int, error) {
+	if err := s.inject(ctx); err != nil {
+		return 0, fmt.Errorf("failed to delete visitor data: %w", err)
+	}
+	return s.DataStore.DeleteVisitorData(ctx, ids)
+}
+
+// Ping injects faults before delegating to the wrapped store.
+func (s *FaultyStore) Ping(ctx context.Context) error {
+	if err := s.inject(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return s.DataStore.Ping(ctx)
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loadChaosConfig reads the CHAOS_* settings. Chaos mode needs CHAOS_MODE=true
+// and is always refused when APP_ENV is prod.
+func loadChaosConfig() (faultConfig, bool, error) {
+	enabled, _ := strconv.ParseBool(os.Getenv("CHAOS_MODE"))
+	if !enabled {
+		return faultConfig{}, false, nil
+	}
+	if os.Getenv("APP_ENV") == "prod" {
+		return faultConfig{}, false, errors.New("CHAOS_MODE cannot be enabled when APP_ENV is prod")
+	}
+
+	cfg := faultConfig{Timeout: defaultChaosTimeout}
+	for _, setting := range []struct {
+		env string
+		dst *float64
+	}{
+		{"CHAOS_ERROR_RATE", &cfg.ErrorRate},
+		{"CHAOS_TIMEOUT_RATE", &cfg.TimeoutRate},
+	} {
+		if v := os.Getenv(setting.env); v != "" {
+			rate, err := strconv.ParseFloat(v, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return faultConfig{}, false, fmt.Errorf("invalid %s %q: must be between 0 and 1", setting.env, v)
+			}
+			*setting.dst = rate
+		}
+	}
+	for _, setting := range []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"CHAOS_LATENCY", &cfg.Latency},
+		{"CHAOS_TIMEOUT", &cfg.Timeout},
+	} {
+		if v := os.Getenv(setting.env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return faultConfig{}, false, fmt.Errorf("invalid %s %q: must be a non-negative duration", setting.env, v)
+			}
+			*setting.dst = d
+		}
+	}
+
+	log.Printf("Chaos mode enabled: error rate %v, latency %s, timeout rate %v", cfg.ErrorRate, cfg.Latency, cfg.TimeoutRate)
+	return cfg, true, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"resume-backend/internal/store"
+	"resume-backend/internal/store/storetest"
+)
+
+// sequenceRoll returns the given values in order, repeating the last one.
+func sequenceRoll(values ...float64) func() float64 {
+	return func() float64 {
+		v := values[0]
+		if len(values) > 1 {
+			values = values[1:]
+		}
+		return v
+	}
+}
+
+func TestFaultyStore_GetVisitCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     faultConfig
+		rolls   []float64
+		want    int
+		wantErr error
+	}{
+		{"no faults", faultConfig{}, []float64{0}, 5, nil},
+		{"error injected", faultConfig{ErrorRate: 0.5}, []float64{0.1}, 0, errInjectedFault},
+		{"error not injected", faultConfig{ErrorRate: 0.5}, []float64{0.9}, 5, nil},
+		{"timeout injected", faultConfig{TimeoutRate: 0.5, Timeout: time.Millisecond}, []float64{0.1}, 0, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewFaultyStore(&MockDataStore{visitCount: 5}, tt.cfg)
+			s.roll = sequenceRoll(tt.rolls...)
+
+			got, err := s.GetVisitCount(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("GetVisitCount() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetVisitCount() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFaultyStore_IncrementVisitCount(t *testing.T) {
+	inner := &MockDataStore{}
+	s := NewFaultyStore(inner, faultConfig{ErrorRate: 0.5})
+	s.roll = sequenceRoll(0.1, 0.9)
+
+	if err := s.IncrementVisitCount(context.Background(), Visit{Timestamp: time.Now()}); !errors.Is(err, errInjectedFault) {
+		t.Errorf("expected injected fault, got %v", err)
+	}
+	if err := s.IncrementVisitCount(context.Background(), Visit{Timestamp: time.Now()}); err != nil {
+		t.Errorf("expected success, got %v", err)
+	}
+	if inner.visitCount != 1 {
+		t.Errorf("expected only the successful call to reach the store, got %d", inner.visitCount)
+	}
+}
+
+func TestFaultyStore_Latency(t *testing.T) {
+	s := NewFaultyStore(&MockDataStore{}, faultConfig{Latency: 20 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := s.GetVisitCount(context.Background()); err != nil {
+		t.Fatalf("GetVisitCount() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected at least 20ms of injected latency, got %s", elapsed)
+	}
+
+	// Latency respects the caller's deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := s.GetVisitCount(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func Test_loadChaosConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		want        faultConfig
+		wantEnabled bool
+		wantErr     bool
+	}{
+		{"Disabled", map[string]string{}, faultConfig{}, false, false},
+		{"Enabled", map[string]string{"CHAOS_MODE": "true", "CHAOS_ERROR_RATE": "0.2", "CHAOS_LATENCY": "50ms"},
+			faultConfig{ErrorRate: 0.2, Latency: 50 * time.Millisecond, Timeout: defaultChaosTimeout}, true, false},
+		{"Refused in prod", map[string]string{"CHAOS_MODE": "true", "APP_ENV": "prod"}, faultConfig{}, false, true},
+		{"Invalid rate", map[string]string{"CHAOS_MODE": "true", "CHAOS_TIMEOUT_RATE": "1.5"}, faultConfig{}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"CHAOS_MODE", "APP_ENV", "CHAOS_ERROR_RATE", "CHAOS_TIMEOUT_RATE", "CHAOS_LATENCY", "CHAOS_TIMEOUT"} {
+				t.Setenv(k, tt.env[k])
+			}
+
+			got, enabled, err := loadChaosConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadChaosConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if enabled != tt.wantEnabled || got != tt.want {
+				t.Errorf("loadChaosConfig() = %+v, %v, want %+v, %v", got, enabled, tt.want, tt.wantEnabled)
+			}
+		})
+	}
+}
+
+func TestFaultyStore_Cancellation(t *testing.T) {
+	// Injected latency must end when the caller gives up
+	storetest.CheckCancellation(t, func(pool store.DatabasePool) store.DataStore {
+		return NewFaultyStore(store.NewPostgresStore(pool), faultConfig{Latency: time.Hour})
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	funnelPath     = "/api/funnel"
+	maxFunnelSteps = 10
+)
+
+// funnelStep is one step of the funnel response.
+type funnelStep struct {
+	Step               string  `json:"step"`
+	Sessions           int     `json:"sessions"`
+	ConversionRate     float64 `json:"conversion_rate"`      // relative to the first step
+	StepConversionRate float64 `json:"step_conversion_rate"` // relative to the previous step
+}
+
+// funnelResponse is the body returned by GET /api/funnel.
+type funnelResponse struct {
+	Days  int          `json:"days"`
+	Steps []funnelStep `json:"steps"`
+}
+
+// parseFunnelSteps validates the comma-separated steps query parameter.
+func parseFunnelSteps(v string) ([]string, error) {
+	steps := strings.Split(v, ",")
+	if len(steps) < 2 || len(steps) > maxFunnelSteps {
+		return nil, fmt.Errorf("steps must list between 2 and %d event types", maxFunnelSteps)
+	}
+	seen := make(map[string]bool, len(steps))
+	for i, step := range steps {
+		step = strings.TrimSpace(step)
+		if !eventTypePattern.MatchString(step) {
+			return nil, fmt.Errorf("invalid step %q", step)
+		}
+		if seen[step] {
+			return nil, fmt.Errorf("step %q is repeated", step)
+		}
+		seen[step] = true
+		steps[i] = step
+	}
+	return steps, nil
+}
+
+// funnelHandler reports how many sessions went through each event type in order.
+func funnelHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	steps, err := parseFunnelSteps(r.URL.Query().Get("steps"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	now := clock.Now()
+	counts, err := dataStore.GetFunnel(r.Context(), now.AddDate(0, 0, -days), now, steps)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get funnel: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := funnelResponse{Days: days, Steps: make([]funnelStep, len(steps))}
+	for i, step := range steps {
+		response.Steps[i] = funnelStep{Step: step, Sessions: counts[i]}
+		if counts[0] > 0 {
+			response.Steps[i].ConversionRate = float64(counts[i]) / float64(counts[0])
+		}
+		if i == 0 {
+			if counts[0] > 0 {
+				response.Steps[i].StepConversionRate = 1
+			}
+		} else if counts[i-1] > 0 {
+			response.Steps[i].StepConversionRate = float64(counts[i]) / float64(counts[i-1])
+		}
+	}
+
+	writeResponse(w, r, response)
+}
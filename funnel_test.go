@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseFunnelSteps(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"view,download", []string{"view", "download"}, false},
+		{" view , download ,contact", []string{"view", "download", "contact"}, false},
+		{"view", nil, true},
+		{"", nil, true},
+		{"view,view", nil, true},
+		{"view,Bad Step", nil, true},
+		{"a,b,c,d,e,f,g,h,i,j,k", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseFunnelSteps(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFunnelSteps(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFunnelSteps(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_funnelHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	mockDataStore := &MockDataStore{funnel: []int{4, 2, 1}}
+	w := httptest.NewRecorder()
+	funnelHandler(w, httptest.NewRequest(http.MethodGet, funnelPath+"?steps=view,download,contact&days=7", nil), mockDataStore, clock)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+
+	var response funnelResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	want := []funnelStep{
+		{Step: "view", Sessions: 4, ConversionRate: 1, StepConversionRate: 1},
+		{Step: "download", Sessions: 2, ConversionRate: 0.5, StepConversionRate: 0.5},
+		{Step: "contact", Sessions: 1, ConversionRate: 0.25, StepConversionRate: 0.5},
+	}
+	if response.Days != 7 || !reflect.DeepEqual(response.Steps, want) {
+		t.Errorf("unexpected response %+v", response)
+	}
+	if !mockDataStore.lastFrom.Equal(clock.Now().AddDate(0, 0, -7)) || !mockDataStore.lastTo.Equal(clock.Now()) {
+		t.Errorf("expected the last 7 days queried, got %s to %s", mockDataStore.lastFrom, mockDataStore.lastTo)
+	}
+
+	w = httptest.NewRecorder()
+	funnelHandler(w, httptest.NewRequest(http.MethodGet, funnelPath+"?steps=view,download&days=0", nil), mockDataStore, clock)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid days; got %d", w.Code)
+	}
+}
+
+func Test_funnelHandler_NoSessions(t *testing.T) {
+	w := httptest.NewRecorder()
+	funnelHandler(w, httptest.NewRequest(http.MethodGet, funnelPath+"?steps=view,download", nil), &MockDataStore{}, realClock{})
+
+	var response funnelResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	for _, step := range response.Steps {
+		if step.Sessions != 0 || step.ConversionRate != 0 || step.StepConversionRate != 0 {
+			t.Errorf("expected zero rates without sessions, got %+v", step)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+const geoSelfPath = "/api/geo/self"
+
+// geoConfig controls how a visitor's country and locale are derived.
+type geoConfig struct {
+	CountryHeader string         // request header a CDN or load balancer sets to the visitor's country
+	Database      *geoDatabase   // consulted when the header is unset or unknown
+	Locales       []language.Tag // the locales the frontend has translations for, the first the default
+	matcher       language.Matcher
+}
+
+// newGeoConfig returns a config offering locales, which must not be empty.
+func newGeoConfig(countryHeader string, db *geoDatabase, locales ...language.Tag) geoConfig {
+	return geoConfig{CountryHeader: countryHeader, Database: db, Locales: locales, matcher: language.NewMatcher(locales)}
+}
+
+// defaultGeoConfig offers English alone, without looking up countries.
+func defaultGeoConfig() geoConfig {
+	return newGeoConfig("", nil, language.English)
+}
+
+// loadGeoConfig reads GEO_COUNTRY_HEADER, such as CF-IPCountry or CloudFront-Viewer-Country,
+// which must only be set when the proxy in front overwrites it; GEOIP_DATABASE, the path to a
+// CSV of IP ranges and their countries; and GEO_LOCALES, a comma-separated list of locales,
+// "en" by default.
+func loadGeoConfig() (geoConfig, error) {
+	locales := []language.Tag{language.English}
+	if v := os.Getenv("GEO_LOCALES"); v != "" {
+		locales = nil
+		for _, l := range strings.Split(v, ",") {
+			tag, err := language.Parse(strings.TrimSpace(l))
+			if err != nil {
+				return geoConfig{}, fmt.Errorf("invalid GEO_LOCALES locale %q: %w", l, err)
+			}
+			locales = append(locales, tag)
+		}
+	}
+
+	var db *geoDatabase
+	if path := os.Getenv("GEOIP_DATABASE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return geoConfig{}, fmt.Errorf("failed to open GEOIP_DATABASE: %w", err)
+		}
+		defer f.Close()
+		if db, err = readGeoDatabase(f); err != nil {
+			return geoConfig{}, fmt.Errorf("failed to read GEOIP_DATABASE %s: %w", path, err)
+		}
+	}
+	return newGeoConfig(os.Getenv("GEO_COUNTRY_HEADER"), db, locales...), nil
+}
+
+// geoRange is a range of addresses in one country.
+type geoRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// geoDatabase maps IP addresses to countries, from ranges held in memory sorted by their start.
+type geoDatabase struct {
+	ranges []geoRange
+}
+
+// readGeoDatabase reads rows of first address, last address and country code, the layout
+// of the free DB-IP and IPLocate country CSVs; further columns are ignored. Ranges may be
+// IPv4 or IPv6 but must not overlap.
+func readGeoDatabase(r io.Reader) (*geoDatabase, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	db := &geoDatabase{}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: expected first address, last address and country", line)
+		}
+		start, err1 := netip.ParseAddr(strings.TrimSpace(record[0]))
+		end, err2 := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err := errors.Join(err1, err2); err != nil {
+			if line == 1 {
+				continue // a header row
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		start, end = start.Unmap(), end.Unmap()
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: invalid range %s-%s", line, start, end)
+		}
+		if country, ok := countryCode(record[2]); ok {
+			db.ranges = append(db.ranges, geoRange{start: start, end: end, country: country})
+		}
+	}
+
+	// IPv4 addresses sort before IPv6 ones, so both families share the one list
+	slices.SortFunc(db.ranges, func(a, b geoRange) int { return a.start.Compare(b.start) })
+	return db, nil
+}
+
+// Country returns the country of addr, or "" when no range covers it.
+func (db *geoDatabase) Country(addr netip.Addr) string {
+	addr = addr.Unmap()
+	// The last range starting at or before addr is the only one that can hold it
+	i, _ := slices.BinarySearchFunc(db.ranges, addr, func(r geoRange, a netip.Addr) int {
+		if r.start.Compare(a) <= 0 {
+			return -1
+		}
+		return 1
+	})
+	if i == 0 || db.ranges[i-1].end.Less(addr) {
+		return ""
+	}
+	return db.ranges[i-1].country
+}
+
+// countryCode returns s as an upper-case ISO 3166-1 alpha-2 code, rejecting the XX and ZZ
+// placeholders CDNs send for unknown countries and their codes for Tor and the like.
+func countryCode(s string) (string, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) != 2 || s[0] < 'A' || s[0] > 'Z' || s[1] < 'A' || s[1] > 'Z' || s == "XX" || s == "ZZ" {
+		return "", false
+	}
+	return s, true
+}
+
+// countryFlag returns the emoji flag of a country code, a pair of regional indicator symbols.
+func countryFlag(country string) string {
+	return string([]rune{rune(0x1F1E6 + rune(country[0]-'A')), rune(0x1F1E6 + rune(country[1]-'A'))})
+}
+
+// country derives the visitor's country from the configured header, falling back to the
+// database. It returns "" when neither knows.
+func (cfg geoConfig) country(r *http.Request) string {
+	if cfg.CountryHeader != "" {
+		if country, ok := countryCode(r.Header.Get(cfg.CountryHeader)); ok {
+			return country
+		}
+	}
+	if cfg.Database != nil {
+		if addr, err := netip.ParseAddr(clientIP(r)); err == nil {
+			return cfg.Database.Country(addr)
+		}
+	}
+	return ""
+}
+
+// locale suggests one of Locales from the visitor's Accept-Language, in which a language
+// without a region takes the visitor's country, and then from the country's own language.
+func (cfg geoConfig) locale(acceptLanguage, country string) language.Tag {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	region, err := language.ParseRegion(country)
+	if country != "" && err == nil {
+		for i, tag := range tags {
+			if _, conf := tag.Region(); conf != language.Exact {
+				tags[i], _ = language.Compose(tag, region)
+			}
+		}
+		tags = append(tags, language.Make("und-"+country))
+	}
+	_, i, _ := cfg.matcher.Match(tags...)
+	return cfg.Locales[i]
+}
+
+// geoSelfResponse is the body returned by GET /api/geo/self.
+type geoSelfResponse struct {
+	Country *string `json:"country"` // null when unknown
+	Flag    *string `json:"flag"`    // null when the country is unknown
+	Locale  string  `json:"locale"`
+}
+
+// geoSelfHandler tells the caller their country and the locale to greet them in. Nothing
+// about the request is stored.
+func geoSelfHandler(w http.ResponseWriter, r *http.Request, cfg geoConfig) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp geoSelfResponse
+	country := cfg.country(r)
+	if country != "" {
+		flag := countryFlag(country)
+		resp.Country, resp.Flag = &country, &flag
+	}
+	resp.Locale = cfg.locale(r.Header.Get("Accept-Language"), country).String()
+
+	// The answer is the caller's own, so shared caches must not keep it
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Add("Vary", "Accept-Language")
+	writeResponse(w, r, resp)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+const testGeoDatabase = `ip_start,ip_end,country
+81.2.69.0,81.2.69.255,GB
+2a02:c7c::,2a02:c7f:ffff:ffff:ffff:ffff:ffff:ffff,GB
+1.0.0.0,1.0.0.255,AU
+90.0.0.0,90.127.255.255,FR
+10.0.0.0,10.255.255.255,ZZ
+`
+
+func Test_readGeoDatabase(t *testing.T) {
+	db, err := readGeoDatabase(strings.NewReader(testGeoDatabase))
+	if err != nil {
+		t.Fatalf("readGeoDatabase() error = %v", err)
+	}
+	for addr, want := range map[string]string{
+		"81.2.69.160":             "GB",
+		"81.2.70.1":               "",
+		"1.0.0.0":                 "AU",
+		"1.0.0.255":               "AU",
+		"0.255.255.255":           "",
+		"90.100.1.1":              "FR",
+		"::ffff:90.100.1.1":       "FR",
+		"10.1.2.3":                "", // unknown country
+		"2a02:c7d:1234::1":        "GB",
+		"2a03::1":                 "",
+		"255.255.255.255":         "",
+		"ffff:ffff:ffff:ffff::ff": "",
+	} {
+		if got := db.Country(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Country(%s) = %q; want %q", addr, got, want)
+		}
+	}
+
+	for name, csv := range map[string]string{
+		"too few columns": "1.0.0.0,1.0.0.255\n",
+		"bad address":     "1.0.0.0,1.0.0.255,AU\n1.0.1.0,nope,CN\n",
+		"mixed families":  "1.0.0.0,::1,AU\n",
+		"reversed range":  "1.0.0.255,1.0.0.0,AU\n",
+	} {
+		if _, err := readGeoDatabase(strings.NewReader(csv)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func Test_loadGeoConfig(t *testing.T) {
+	cfg, err := loadGeoConfig()
+	if err != nil || cfg.CountryHeader != "" || cfg.Database != nil || len(cfg.Locales) != 1 || cfg.Locales[0] != language.English {
+		t.Fatalf("expected English without a country lookup by default, got %+v, %v", cfg, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "countries.csv")
+	if err := os.WriteFile(path, []byte(testGeoDatabase), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GEO_COUNTRY_HEADER", "CF-IPCountry")
+	t.Setenv("GEOIP_DATABASE", path)
+	t.Setenv("GEO_LOCALES", "en, fr")
+	cfg, err = loadGeoConfig()
+	if err != nil || cfg.CountryHeader != "CF-IPCountry" || len(cfg.Database.ranges) != 4 || len(cfg.Locales) != 2 {
+		t.Errorf("unexpected config %+v, %v", cfg, err)
+	}
+
+	for name, value := range map[string]string{
+		"GEO_LOCALES":    "en,!!",
+		"GEOIP_DATABASE": filepath.Join(t.TempDir(), "missing.csv"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := loadGeoConfig(); err == nil {
+				t.Errorf("expected %s=%q rejected", name, value)
+			}
+		})
+	}
+}
+
+func Test_geoConfig_locale(t *testing.T) {
+	cfg := newGeoConfig("", nil, language.English, language.BritishEnglish, language.French, language.German)
+	for _, tt := range []struct {
+		acceptLanguage, country, want string
+	}{
+		{"fr-CH, en;q=0.8", "", "fr"},
+		{"en", "", "en"},
+		{"en", "GB", "en-GB"}, // the country fills in the region
+		{"en-US", "GB", "en"}, // but doesn't override one that's given
+		{"de", "FR", "de"},
+		{"", "FR", "fr"}, // without a preference, the country's language
+		{"", "JP", "en"},
+		{"ja", "", "en"},
+		{"not a language", "", "en"},
+	} {
+		if got := cfg.locale(tt.acceptLanguage, tt.country).String(); got != tt.want {
+			t.Errorf("locale(%q, %q) = %s; want %s", tt.acceptLanguage, tt.country, got, tt.want)
+		}
+	}
+}
+
+func Test_geoSelfHandler(t *testing.T) {
+	db, err := readGeoDatabase(strings.NewReader(testGeoDatabase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newGeoConfig("CF-IPCountry", db, language.English, language.French)
+
+	for _, tt := range []struct {
+		name, remoteAddr, header, acceptLanguage string
+		want                                     string
+	}{
+		{"from the header", "1.0.0.1:1234", "fr", "", `{"country":"FR","flag":"🇫🇷","locale":"fr"}`},
+		{"from the database", "1.0.0.1:1234", "", "en-AU", `{"country":"AU","flag":"🇦🇺","locale":"en"}`},
+		{"unknown header value", "90.1.1.1:1234", "XX", "", `{"country":"FR","flag":"🇫🇷","locale":"fr"}`},
+		{"unknown", "192.0.2.1:1234", "", "fr", `{"country":null,"flag":null,"locale":"fr"}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, geoSelfPath, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				req.Header.Set("CF-IPCountry", tt.header)
+			}
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			rr := httptest.NewRecorder()
+			geoSelfHandler(rr, req, cfg)
+
+			var got, want geoSelfResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+			}
+			json.Unmarshal([]byte(tt.want), &want)
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("got %s; want %s", gotJSON, wantJSON)
+			}
+			if cc := rr.Header().Get("Cache-Control"); cc != "private, no-store" {
+				t.Errorf("expected the response kept out of shared caches, got %q", cc)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	geoSelfHandler(rr, httptest.NewRequest(http.MethodPost, geoSelfPath, nil), cfg)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
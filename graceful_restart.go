@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Set by the old process for its replacement: the listeners it passes, as name=fd pairs,
+	// and the pipe to report readiness on
+	restartListenersEnv = "RESTART_LISTENERS"
+	restartReadyEnv     = "RESTART_READY_FD"
+
+	defaultRestartTimeout = 30 * time.Second
+)
+
+// restartConfig controls zero-downtime restarts, for upgrading the binary on a single server.
+type restartConfig struct {
+	Enabled bool
+	Timeout time.Duration // how long the replacement gets to start serving
+	PIDFile string        // rewritten by each replacement, for systemd's PIDFile=
+}
+
+// loadRestartConfig reads GRACEFUL_RESTART, which enables restarts on SIGUSR2 when "true",
+// RESTART_TIMEOUT and RESTART_PID_FILE.
+func loadRestartConfig() (restartConfig, error) {
+	cfg := restartConfig{
+		Enabled: os.Getenv("GRACEFUL_RESTART") == "true",
+		Timeout: defaultRestartTimeout,
+		PIDFile: os.Getenv("RESTART_PID_FILE"),
+	}
+	if cfg.Enabled && len(restartSignals) == 0 {
+		return restartConfig{}, errors.New("GRACEFUL_RESTART is not supported on this platform")
+	}
+	if v := os.Getenv("RESTART_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return restartConfig{}, fmt.Errorf("invalid RESTART_TIMEOUT %q: must be a positive duration", v)
+		}
+		cfg.Timeout = d
+	}
+	return cfg, nil
+}
+
+// restarter hands the listening sockets to a replacement process, started from the binary
+// now on disk, so an upgrade never refuses a connection: the replacement accepts on the same
+// sockets while the old process drains its in-flight requests and exits.
+type restarter struct {
+	cfg  restartConfig
+	exe  string
+	args []string
+
+	mu        sync.Mutex
+	inherited map[string]*os.File // listeners passed by the old process, until claimed
+	ready     *os.File            // the old process's readiness pipe
+	names     []string
+	listeners map[string]net.Listener
+}
+
+// newRestarter picks up the listeners passed by the process this one replaces, if any.
+func newRestarter(cfg restartConfig) (*restarter, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the executable: %w", err)
+	}
+	r := &restarter{cfg: cfg, exe: exe, args: os.Args, inherited: make(map[string]*os.File), listeners: make(map[string]net.Listener)}
+	if v := os.Getenv(restartListenersEnv); v != "" {
+		for _, pair := range strings.Split(v, ",") {
+			name, fd, ok := strings.Cut(pair, "=")
+			n, err := strconv.Atoi(fd)
+			if !ok || err != nil || n < 3 {
+				return nil, fmt.Errorf("invalid %s entry %q", restartListenersEnv, pair)
+			}
+			r.inherited[name] = os.NewFile(uintptr(n), name)
+		}
+	}
+	if v := os.Getenv(restartReadyEnv); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 3 {
+			return nil, fmt.Errorf("invalid %s %q", restartReadyEnv, v)
+		}
+		r.ready = os.NewFile(uintptr(n), "ready")
+	}
+	return r, nil
+}
+
+// Listen returns the listener the old process passed under name, or else listens on addr.
+func (r *restarter) Listen(name, addr string) (net.Listener, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ln net.Listener
+	var err error
+	if f, ok := r.inherited[name]; ok {
+		delete(r.inherited, name)
+		ln, err = net.FileListener(f)
+		f.Close() // FileListener keeps its own copy
+		if err != nil {
+			return nil, fmt.Errorf("failed to use the inherited %s listener: %w", name, err)
+		}
+		log.Printf("Inherited the %s listener on %s", name, ln.Addr())
+	} else if ln, err = net.Listen("tcp", addr); err != nil {
+		return nil, err
+	}
+	r.names = append(r.names, name)
+	r.listeners[name] = ln
+	return ln, nil
+}
+
+// Ready tells the old process this one is serving, so it can drain and exit, and records the
+// new PID. Listeners passed but never claimed are closed.
+func (r *restarter) Ready() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, f := range r.inherited {
+		f.Close()
+		delete(r.inherited, name)
+	}
+	if r.cfg.PIDFile != "" {
+		if err := os.WriteFile(r.cfg.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to write RESTART_PID_FILE: %w", err)
+		}
+	}
+	if r.ready == nil {
+		return nil
+	}
+	defer func() { r.ready = nil }()
+	defer r.ready.Close()
+	if _, err := r.ready.Write([]byte{1}); err != nil {
+		return fmt.Errorf("failed to report readiness: %w", err)
+	}
+	return nil
+}
+
+// Upgrade starts the replacement with the listeners and waits for it to be ready. On an error
+// the replacement is gone and this process should carry on serving.
+func (r *restarter) Upgrade() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	defer func() {
+		// The replacement has its own copies
+		for _, f := range files[3:] {
+			f.Close()
+		}
+	}()
+	specs := make([]string, 0, len(r.names))
+	for _, name := range r.names {
+		l, ok := r.listeners[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("the %s listener can't be passed on", name)
+		}
+		f, err := l.File()
+		if err != nil {
+			return fmt.Errorf("failed to pass the %s listener: %w", name, err)
+		}
+		specs = append(specs, fmt.Sprintf("%s=%d", name, len(files)))
+		files = append(files, f)
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create the readiness pipe: %w", err)
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, restartListenersEnv+"=") && !strings.HasPrefix(kv, restartReadyEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, restartListenersEnv+"="+strings.Join(specs, ","), fmt.Sprintf("%s=%d", restartReadyEnv, len(files)-1))
+
+	proc, err := os.StartProcess(r.exe, r.args, &os.ProcAttr{Env: env, Files: files})
+	if err != nil {
+		return fmt.Errorf("failed to start the replacement: %w", err)
+	}
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	// The pipe closes without a byte if the replacement exits first
+	ready := make(chan bool, 1)
+	go func() {
+		_, err := io.ReadFull(readyR, make([]byte, 1))
+		ready <- err == nil
+	}()
+	timer := time.NewTimer(r.cfg.Timeout)
+	defer timer.Stop()
+	select {
+	case ok := <-ready:
+		if ok {
+			return nil
+		}
+		state, _ := proc.Wait()
+		return fmt.Errorf("the replacement exited before it was ready: %v", state)
+	case <-timer.C:
+		_ = proc.Kill()
+		_, _ = proc.Wait()
+		return fmt.Errorf("the replacement wasn't ready within %s", r.cfg.Timeout)
+	}
+}
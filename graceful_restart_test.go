@@ -0,0 +1,130 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test_restarterReplacement is the replacement process Test_restarter_Upgrade starts: it
+// serves one request on the listener it inherits, then exits.
+func Test_restarterReplacement(t *testing.T) {
+	if os.Getenv("RESTART_TEST_REPLACEMENT") != "1" {
+		t.Skip("only run as the replacement process")
+	}
+	r, err := newRestarter(restartConfig{PIDFile: os.Getenv("RESTART_PID_FILE")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := r.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan struct{})
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		w.Write([]byte(strconv.Itoa(os.Getpid())))
+		close(served)
+	}))
+	if err := r.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-served:
+		time.Sleep(100 * time.Millisecond) // let the response go out
+	case <-time.After(10 * time.Second):
+		t.Fatal("no request served")
+	}
+}
+
+// trimEnvironment empties variables too large to pass to a new process, such as certificate
+// bundles a NAME_FILE resolved into in an earlier test.
+func trimEnvironment(t *testing.T) {
+	for _, kv := range os.Environ() {
+		if name, value, _ := strings.Cut(kv, "="); len(value) > 64<<10 {
+			t.Setenv(name, "")
+		}
+	}
+}
+
+func Test_restarter_Upgrade(t *testing.T) {
+	trimEnvironment(t)
+	pidFile := filepath.Join(t.TempDir(), "app.pid")
+	t.Setenv("RESTART_TEST_REPLACEMENT", "1")
+	t.Setenv("RESTART_PID_FILE", pidFile)
+	r, err := newRestarter(restartConfig{Enabled: true, Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.args = []string{r.exe, "-test.run=^Test_restarterReplacement$"}
+	listener, err := r.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+
+	if err := r.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	pid, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With this process's copy closed, the replacement still accepts on the socket
+	listener.Close()
+	res, err := http.Get("http://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != strings.TrimSpace(string(pid)) || string(body) == strconv.Itoa(os.Getpid()) {
+		t.Errorf("expected the replacement %s to answer, got %q", pid, body)
+	}
+}
+
+func Test_restarter_UpgradeFailure(t *testing.T) {
+	trimEnvironment(t)
+	r, err := newRestarter(restartConfig{Enabled: true, Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without RESTART_TEST_REPLACEMENT, the replacement exits without reporting ready
+	r.args = []string{r.exe, "-test.run=^Test_restarterReplacement$"}
+	listener, err := r.Listen("http", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if err := r.Upgrade(); err == nil || !strings.Contains(err.Error(), "exited before it was ready") {
+		t.Errorf("expected the failed replacement reported, got %v", err)
+	}
+}
+
+func Test_loadRestartConfig(t *testing.T) {
+	cfg, err := loadRestartConfig()
+	if err != nil || cfg.Enabled || cfg.Timeout != defaultRestartTimeout {
+		t.Fatalf("expected restarts off by default, got %+v, %v", cfg, err)
+	}
+	t.Setenv("GRACEFUL_RESTART", "true")
+	t.Setenv("RESTART_TIMEOUT", "1m")
+	if cfg, err := loadRestartConfig(); err != nil || !cfg.Enabled || cfg.Timeout != time.Minute {
+		t.Errorf("unexpected config %+v, %v", cfg, err)
+	}
+	t.Setenv("RESTART_TIMEOUT", "never")
+	if _, err := loadRestartConfig(); err == nil {
+		t.Error("expected an error for an invalid timeout")
+	}
+	t.Setenv(restartListenersEnv, "http")
+	if _, err := newRestarter(restartConfig{}); err == nil {
+		t.Error("expected an error for an invalid listener")
+	}
+}
@@ -2,48 +2,166 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"time"
+
+	"resume-backend/internal/i18n"
+	"resume-backend/internal/logging"
+)
+
+const apiPath = "/api/count"
+
+// Kubernetes checks on startup
+func healthAndReadyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "OK")
+	case "/readyz":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !dbHealth.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "Database unavailable")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "Ready")
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
+
+// messageResponse is the body of responses that only confirm what was done.
+type messageResponse struct {
+	Message string `json:"message"`
+}
+
+// errorResponse is the body of JSON error responses.
+type errorResponse struct {
+	Error string `json:"error"`          // in the visitor's language
+	Code  string `json:"code,omitempty"` // the same in any language, for clients to act on
+}
+
+// localize returns the message for key in the language r's Accept-Language prefers, marking
+// the response with the language it is in.
+func localize(w http.ResponseWriter, r *http.Request, key string, args ...any) string {
+	message, tag := i18n.Default().Translate(r.Header.Get("Accept-Language"), key, args...)
+	w.Header().Set("Content-Language", tag.String())
+	w.Header().Add("Vary", "Accept-Language")
+	return message
+}
+
+// writeJSONError writes the message for key, localized, as a JSON object with the given
+// status code.
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, key string) {
+	message := localize(w, r, key)
+	w.Header()["Content-Type"] = jsonContentType
+	w.WriteHeader(status)
+	if err := (jsonEncoder{}).Encode(w, nil, errorResponse{Error: message, Code: key}); err != nil {
+		log.Printf("Error encoding error response: %v", err)
+	}
+}
+
+const (
+	// maxVisitBodyBytes caps the optional JSON body of POST /api/count
+	maxVisitBodyBytes = 4 << 10
+
+	// maxReferrerLength caps the referrer URL, matching what browsers send in practice
+	maxReferrerLength = 2048
 )
 
-// incrementVisitCount increments the visit count in the database.
-func incrementVisitCount(w http.ResponseWriter, r *http.Request, dataStore DataStore) {
-	err := dataStore.IncrementVisitCount(r.Context(), time.Now()) // Pass the request context
+// visitRequest is the optional body the frontend sends when recording a visit.
+type visitRequest struct {
+	Consent  consentLevel `json:"consent"`  // "full" or "anonymous"; the X-Tracking-Consent header decides if empty
+	Page     string       `json:"page"`     // slug of the page being viewed, such as "blog"
+	Referrer string       `json:"referrer"` // document.referrer of the page being viewed
+	UTM                   // utm_* parameters of the page URL
+}
+
+// validate checks the body against the VisitRequest schema in openapi.json and normalizes
+// the page slug.
+func (req *visitRequest) validate() error {
+	switch req.Consent {
+	case consentUnset, consentFull, consentAnonymous:
+	default:
+		return fmt.Errorf("consent must be %q or %q", consentFull, consentAnonymous)
+	}
+	if len(req.Referrer) > maxReferrerLength {
+		return fmt.Errorf("referrer is longer than %d bytes", maxReferrerLength)
+	}
+	page, err := normalizePage(req.Page)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to increment visit count: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	req.Page = page
+	return nil
+}
+
+// decodeVisitRequest reads and validates the optional visit body; an empty body is a direct
+// visit.
+func decodeVisitRequest(w http.ResponseWriter, r *http.Request) (visitRequest, error) {
+	var req visitRequest
+	if r.Body == nil {
+		return req, nil
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVisitBodyBytes)).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return visitRequest{}, err
+	}
+	if err := req.validate(); err != nil {
+		return visitRequest{}, err
+	}
+	return req, nil
+}
+
+// incrementVisitCount increments the visit count in the database. With full consent the
+// visit keeps its referrer and UTM parameters and the visitor is counted as unique; an
+// anonymous visit only adds to the counts.
+func incrementVisitCount(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	req, err := decodeVisitRequest(w, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid visit body: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	log.Println("Visit count incremented")
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	response := map[string]string{"message": "Visit count incremented"}
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		log.Printf("Error encoding response: %v", err)
+	allowed := trackingAllowedFor(r.Context(), req.Consent)
+	setTrackingAllowed(r.Context(), allowed)
+
+	visit := Visit{Timestamp: clock.Now(), Page: req.Page}
+	if allowed {
+		visit.Referrer, visit.UTM = normalizeReferrer(req.Referrer), normalizeUTM(req.UTM)
+	}
+	err = dataStore.IncrementVisitCount(r.Context(), visit) // Pass the request context
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to increment visit count: %v", err), http.StatusInternalServerError)
 		return
 	}
+
+	logging.FromContext(r.Context()).Printf("Visit count incremented")
+	writeResponse(w, r, messageResponse{Message: "Visit count incremented"})
 }
 
-// getVisitCount retrieves the visit count from the database.
+// getVisitCount retrieves the visit count from the database, or the last known count while
+// the database is unreachable.
 func getVisitCount(w http.ResponseWriter, r *http.Request, dataStore DataStore) {
 	count, err := dataStore.GetVisitCount(r.Context()) // Pass the request context
-	if err != nil {
+	if err != nil && !isStaleCount(err) {
 		http.Error(w, fmt.Sprintf("Failed to get visit count: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]int{"visits": count})
+	writeVisitCount(w, r, count, err)
 }
 
 // visitCountHandler handles POST and GET requests for the visit count.
-func visitCountHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore) {
+func visitCountHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
 	switch r.Method {
 	case http.MethodPost:
-		incrementVisitCount(w, r, dataStore)
+		incrementVisitCount(w, r, dataStore, clock)
 	case http.MethodGet:
 		getVisitCount(w, r, dataStore)
 	default:
@@ -1,21 +1,72 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
 
 // MockDataStore is a mock implementation of the DataStore interface for testing.
 type MockDataStore struct {
-	visitCount int
+	visitCount   int
+	pageCounts   map[string]int
+	lastPages    []string
+	lastVisit    Visit
+	lastVisits   []Visit
+	dailyCounts  []DailyCount
+	lastFrom     time.Time
+	lastTo       time.Time
+	rangeCounts  []int
+	lastRanges   []TimeRange
+	heatmap      []HeatmapCell
+	projects     []Project
+	resume       []ResumeVersion
+	posts        []Post
+	links        []ShortLink
+	uptimeChecks []UptimeCheck
+	certChecks   []CertificateCheck
+	deploys      []Deploy
+	uniques      map[string]bool
+	sketches     map[string][]byte
+	referrers    []ReferrerCount
+	campaigns    []CampaignCount
+	exposures    []Exposure
+	results      []VariantResult
+	sessions     map[string]time.Time
+	sessionDays  []SessionDay
+	aggregated   [2]time.Time
+	events       []Event
+	funnel       []int // counts GetFunnel returns, one per step
+	eventCounts  []EventCount
+	lastQuery    EventStatsQuery
+	hourly       []HourlyCount
+	anomalies    []Anomaly
+	visitRows    []VisitRow
+	exportMarks  map[string]int64
+	outbox       []OutboxMessage
+	dedupeKeys   map[string]bool
+	lastIDs      VisitorIDs
+	pingErr      error
+	lastLimit    int
+	summarized   int // reads answered from the summaries
+	refreshes    int
 }
 
-func (m *MockDataStore) IncrementVisitCount(ctx context.Context, timestamp time.Time) error {
+func (m *MockDataStore) IncrementVisitCount(ctx context.Context, visit Visit) error {
 	m.visitCount++
+	m.lastVisit = visit
+	return nil
+}
+
+func (m *MockDataStore) IncrementVisitCounts(ctx context.Context, visits []Visit) error {
+	m.visitCount += len(visits)
+	m.lastVisits = visits
 	return nil
 }
 
@@ -23,10 +74,629 @@ func (m *MockDataStore) GetVisitCount(ctx context.Context) (int, error) {
 	return m.visitCount, nil
 }
 
+func (m *MockDataStore) GetVisitCountBetween(ctx context.Context, since, until time.Time) (int, error) {
+	m.lastFrom, m.lastTo = since, until
+	return m.visitCount, nil
+}
+
+func (m *MockDataStore) GetVisitCountsInRanges(ctx context.Context, ranges []TimeRange) ([]int, error) {
+	m.lastRanges = ranges
+	counts := make([]int, len(ranges))
+	for i := range ranges {
+		if i < len(m.rangeCounts) {
+			counts[i] = m.rangeCounts[i]
+		}
+	}
+	return counts, nil
+}
+
+func (m *MockDataStore) GetPageCounts(ctx context.Context, pages []string) (map[string]int, error) {
+	m.lastPages = pages
+	counts := make(map[string]int)
+	for _, page := range pages {
+		if n, ok := m.pageCounts[page]; ok {
+			counts[page] = n
+		}
+	}
+	return counts, nil
+}
+
+func (m *MockDataStore) GetDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error) {
+	m.lastFrom, m.lastTo = from, to
+	return m.dailyCounts, nil
+}
+
+func (m *MockDataStore) GetSummary(ctx context.Context, from, to time.Time, loc *time.Location) (Summary, error) {
+	m.lastFrom, m.lastTo = from, to
+	return Summary{Visits: m.visitCount, UniqueVisitors: len(m.uniques), Daily: m.dailyCounts}, nil
+}
+
+func (m *MockDataStore) GetVisitHeatmap(ctx context.Context, from, to time.Time, loc *time.Location) ([]HeatmapCell, error) {
+	m.lastFrom, m.lastTo = from, to
+	return m.heatmap, nil
+}
+
+func (m *MockDataStore) RecordUniqueVisitor(ctx context.Context, day time.Time, visitorHash string) error {
+	if m.uniques == nil {
+		m.uniques = make(map[string]bool)
+	}
+	m.uniques[day.UTC().Format(time.DateOnly)+"/"+visitorHash] = true
+	return nil
+}
+
+func (m *MockDataStore) GetUniqueVisitorCount(ctx context.Context, from, to time.Time) (int, error) {
+	m.lastFrom, m.lastTo = from, to
+	return len(m.uniques), nil
+}
+
+func (m *MockDataStore) SaveVisitorSketch(ctx context.Context, day time.Time, sketch []byte) error {
+	if m.sketches == nil {
+		m.sketches = make(map[string][]byte)
+	}
+	m.sketches[day.UTC().Format(time.DateOnly)] = sketch
+	return nil
+}
+
+func (m *MockDataStore) GetVisitorSketches(ctx context.Context, from, to time.Time) ([][]byte, error) {
+	var sketches [][]byte
+	for day := from.UTC(); day.Format(time.DateOnly) <= to.UTC().Format(time.DateOnly); day = day.AddDate(0, 0, 1) {
+		if sketch, ok := m.sketches[day.Format(time.DateOnly)]; ok {
+			sketches = append(sketches, sketch)
+		}
+	}
+	return sketches, nil
+}
+
+func (m *MockDataStore) GetTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	m.lastFrom, m.lastTo, m.lastLimit = from, to, limit
+	return m.referrers, nil
+}
+
+func (m *MockDataStore) GetCampaignVisits(ctx context.Context, from, to time.Time, limit int) ([]CampaignCount, error) {
+	m.lastFrom, m.lastTo, m.lastLimit = from, to, limit
+	return m.campaigns, nil
+}
+
+func (m *MockDataStore) RecordExposure(ctx context.Context, exposure Exposure) error {
+	m.exposures = append(m.exposures, exposure)
+	return nil
+}
+
+func (m *MockDataStore) GetExperimentResults(ctx context.Context, experiment string, from, to time.Time) ([]VariantResult, error) {
+	m.lastFrom, m.lastTo = from, to
+	return m.results, nil
+}
+
+func (m *MockDataStore) StartSession(ctx context.Context, id string, now time.Time) error {
+	if m.sessions == nil {
+		m.sessions = make(map[string]time.Time)
+	}
+	m.sessions[id] = now
+	return nil
+}
+
+func (m *MockDataStore) TouchSession(ctx context.Context, id string, now, idleSince time.Time) (bool, error) {
+	lastSeen, ok := m.sessions[id]
+	if !ok || lastSeen.Before(idleSince) {
+		return false, nil
+	}
+	m.sessions[id] = now
+	return true, nil
+}
+
+func (m *MockDataStore) CountActiveSessions(ctx context.Context, since time.Time) (int, error) {
+	count := 0
+	for _, lastSeen := range m.sessions {
+		if !lastSeen.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockDataStore) AggregateSessions(ctx context.Context, since, closedBefore time.Time) error {
+	m.aggregated = [2]time.Time{since, closedBefore}
+	return nil
+}
+
+func (m *MockDataStore) GetSessionStats(ctx context.Context, from, to time.Time) ([]SessionDay, error) {
+	m.lastFrom, m.lastTo = from, to
+	return m.sessionDays, nil
+}
+
+func (m *MockDataStore) RecordEvent(ctx context.Context, event Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *MockDataStore) GetEventStats(ctx context.Context, query EventStatsQuery) ([]EventCount, error) {
+	m.lastQuery = query
+	return m.eventCounts, nil
+}
+
+func (m *MockDataStore) GetFunnel(ctx context.Context, from, to time.Time, steps []string) ([]int, error) {
+	m.lastFrom, m.lastTo = from, to
+	counts := make([]int, len(steps))
+	copy(counts, m.funnel)
+	return counts, nil
+}
+
+func (m *MockDataStore) GetHourlyVisits(ctx context.Context, from, to time.Time) ([]HourlyCount, error) {
+	m.lastFrom, m.lastTo = from, to
+	return m.hourly, nil
+}
+
+func (m *MockDataStore) RecordAnomaly(ctx context.Context, anomaly Anomaly) (bool, error) {
+	for _, a := range m.anomalies {
+		if a.Hour.Equal(anomaly.Hour) {
+			return false, nil
+		}
+	}
+	m.anomalies = append(m.anomalies, anomaly)
+	return true, nil
+}
+
+func (m *MockDataStore) GetAnomalies(ctx context.Context, from, to time.Time) ([]Anomaly, error) {
+	m.lastFrom, m.lastTo = from, to
+	return m.anomalies, nil
+}
+
+func (m *MockDataStore) GetVisitsAfter(ctx context.Context, afterID int64, before time.Time, limit int) ([]VisitRow, error) {
+	var rows []VisitRow
+	for _, v := range m.visitRows {
+		if v.ID > afterID && v.Timestamp.Before(before) && len(rows) < limit {
+			rows = append(rows, v)
+		}
+	}
+	return rows, nil
+}
+
+func (m *MockDataStore) StreamVisits(ctx context.Context, afterID int64, before time.Time, limit int, fn func(VisitRow) error) error {
+	rows, _ := m.GetVisitsAfter(ctx, afterID, before, limit)
+	for _, v := range rows {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MockDataStore) GetExportMark(ctx context.Context, sink string) (int64, error) {
+	return m.exportMarks[sink], nil
+}
+
+func (m *MockDataStore) SetExportMark(ctx context.Context, sink string, lastID int64) error {
+	if m.exportMarks == nil {
+		m.exportMarks = make(map[string]int64)
+	}
+	m.exportMarks[sink] = max(m.exportMarks[sink], lastID)
+	return nil
+}
+
+func (m *MockDataStore) IncrementVisitCountsWithOutbox(ctx context.Context, visits []Visit, messages []OutboxMessage) error {
+	m.visitCount += len(visits)
+	m.lastVisits = visits
+	m.outbox = append(m.outbox, messages...)
+	return nil
+}
+
+func (m *MockDataStore) EnqueueOutbox(ctx context.Context, messages []OutboxMessage) error {
+	m.outbox = append(m.outbox, messages...)
+	return nil
+}
+
+func (m *MockDataStore) ClaimOutbox(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxMessage, error) {
+	return nil, nil
+}
+
+func (m *MockDataStore) CompleteOutbox(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (m *MockDataStore) FailOutbox(ctx context.Context, id int64, retryAt *time.Time, lastError string) error {
+	return nil
+}
+
+// Outbox messages with attempts stand in for dead letters
+func (m *MockDataStore) GetFailedOutbox(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	var failed []OutboxMessage
+	for i := len(m.outbox) - 1; i >= 0 && len(failed) < limit; i-- {
+		if m.outbox[i].Attempts > 0 {
+			failed = append(failed, m.outbox[i])
+		}
+	}
+	return failed, nil
+}
+
+func (m *MockDataStore) RetryOutbox(ctx context.Context, id int64) (bool, error) {
+	for i := range m.outbox {
+		if m.outbox[i].ID == id && m.outbox[i].Attempts > 0 {
+			m.outbox[i].Attempts = 0
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDataStore) RunMaintenance(ctx context.Context, action MaintenanceAction, progress func(step string, elapsed time.Duration)) error {
+	for _, step := range []string{"visits", "outbox"} {
+		progress(step, time.Millisecond)
+	}
+	return nil
+}
+
+func (m *MockDataStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (m *MockDataStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	return nil
+}
+
+func (m *MockDataStore) ClaimDedupeKey(ctx context.Context, key string, window time.Duration) (bool, error) {
+	if m.dedupeKeys == nil {
+		m.dedupeKeys = make(map[string]bool)
+	}
+	if m.dedupeKeys[key] {
+		return false, nil
+	}
+	m.dedupeKeys[key] = true
+	return true, nil
+}
+
+func (m *MockDataStore) DeleteExpiredDedupeKeys(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (m *MockDataStore) EnsureVisitPartitions(ctx context.Context, now time.Time, ahead int) error {
+	return nil
+}
+
+func (m *MockDataStore) DropVisitPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	return nil, nil
+}
+
+func (m *MockDataStore) GetVisitorData(ctx context.Context, ids VisitorIDs) (VisitorData, error) {
+	m.lastIDs = ids
+	hashes, sessionIDs := mockVisitorKeys(ids)
+	var data VisitorData
+	for _, h := range ids.Hashes {
+		if m.uniques[h.Day.UTC().Format(time.DateOnly)+"/"+h.Hash] {
+			data.UniqueDays = append(data.UniqueDays, h)
+		}
+	}
+	for _, e := range m.exposures {
+		if hashes[e.Day.UTC().Format(time.DateOnly)+"/"+e.VisitorHash] {
+			data.Exposures = append(data.Exposures, e)
+		}
+	}
+	for _, id := range ids.SessionIDs {
+		if lastSeen, ok := m.sessions[id]; ok {
+			data.Sessions = append(data.Sessions, Session{ID: id, LastSeen: lastSeen})
+		}
+	}
+	for _, e := range m.events {
+		if sessionIDs[e.SessionID] {
+			data.Events = append(data.Events, e)
+		}
+	}
+	return data, nil
+}
+
+func (m *MockDataStore) RefreshSummaries(ctx context.Context) error {
+	m.refreshes++
+	return nil
+}
+
+func (m *MockDataStore) GetSummarizedDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error) {
+	m.summarized++
+	return m.GetDailyVisits(ctx, from, to, loc)
+}
+
+func (m *MockDataStore) GetSummarizedTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	m.summarized++
+	return m.GetTopReferrers(ctx, from, to, limit)
+}
+
+func (m *MockDataStore) ListProjects(ctx context.Context) ([]Project, error) {
+	projects := slices.Clone(m.projects)
+	slices.SortStableFunc(projects, func(a, b Project) int {
+		return cmp.Or(cmp.Compare(a.Position, b.Position), cmp.Compare(a.ID, b.ID))
+	})
+	return projects, nil
+}
+
+func (m *MockDataStore) GetProject(ctx context.Context, id int64) (Project, bool, error) {
+	for _, p := range m.projects {
+		if p.ID == id {
+			return p, true, nil
+		}
+	}
+	return Project{}, false, nil
+}
+
+func (m *MockDataStore) CreateProject(ctx context.Context, p Project) (Project, error) {
+	p.ID = 1
+	for _, existing := range m.projects {
+		p.ID = max(p.ID, existing.ID+1)
+	}
+	m.projects = append(m.projects, p)
+	return p, nil
+}
+
+func (m *MockDataStore) UpdateProject(ctx context.Context, p Project) (Project, bool, error) {
+	for i := range m.projects {
+		if m.projects[i].ID == p.ID {
+			m.projects[i] = p
+			return p, true, nil
+		}
+	}
+	return Project{}, false, nil
+}
+
+func (m *MockDataStore) DeleteProject(ctx context.Context, id int64) (bool, error) {
+	for i, p := range m.projects {
+		if p.ID == id {
+			m.projects = slices.Delete(m.projects, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDataStore) GetPublishedResume(ctx context.Context) (ResumeVersion, bool, error) {
+	var published ResumeVersion
+	for _, v := range m.resume {
+		if v.PublishedAt != nil && (published.PublishedAt == nil || !v.PublishedAt.Before(*published.PublishedAt)) {
+			published = v
+		}
+	}
+	return published, published.PublishedAt != nil, nil
+}
+
+func (m *MockDataStore) GetResumeDraft(ctx context.Context) (ResumeVersion, bool, error) {
+	for _, v := range m.resume {
+		if v.PublishedAt == nil {
+			return v, true, nil
+		}
+	}
+	return ResumeVersion{}, false, nil
+}
+
+func (m *MockDataStore) SaveResumeDraft(ctx context.Context, content json.RawMessage) (ResumeVersion, error) {
+	for i, v := range m.resume {
+		if v.PublishedAt == nil {
+			m.resume[i].Content = content
+			return m.resume[i], nil
+		}
+	}
+	v := ResumeVersion{ID: int64(len(m.resume)) + 1, Content: content}
+	m.resume = append(m.resume, v)
+	return v, nil
+}
+
+func (m *MockDataStore) PublishResumeVersion(ctx context.Context, id int64) (ResumeVersion, bool, error) {
+	for i, v := range m.resume {
+		if v.ID == id {
+			// Each publish is later than the last, as the database clock would have it
+			published := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+			for _, other := range m.resume {
+				if other.PublishedAt != nil && !other.PublishedAt.Before(published) {
+					published = other.PublishedAt.Add(time.Second)
+				}
+			}
+			m.resume[i].PublishedAt = &published
+			return m.resume[i], true, nil
+		}
+	}
+	return ResumeVersion{}, false, nil
+}
+
+func (m *MockDataStore) ListResumeVersions(ctx context.Context, limit int) ([]ResumeVersion, error) {
+	m.lastLimit = limit
+	versions := slices.Clone(m.resume)
+	slices.Reverse(versions)
+	return versions[:min(limit, len(versions))], nil
+}
+
+func (m *MockDataStore) ListPosts(ctx context.Context, publishedBy *time.Time, limit, offset int) ([]Post, error) {
+	var posts []Post
+	for _, p := range m.posts {
+		if publishedBy == nil || (p.PublishedAt != nil && !p.PublishedAt.After(*publishedBy)) {
+			posts = append(posts, p)
+		}
+	}
+	slices.SortFunc(posts, func(a, b Post) int {
+		switch {
+		case a.PublishedAt == nil && b.PublishedAt == nil:
+		case a.PublishedAt == nil:
+			return -1
+		case b.PublishedAt == nil:
+			return 1
+		case !a.PublishedAt.Equal(*b.PublishedAt):
+			return b.PublishedAt.Compare(*a.PublishedAt)
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	posts = posts[min(offset, len(posts)):]
+	return posts[:min(limit, len(posts))], nil
+}
+
+func (m *MockDataStore) GetPost(ctx context.Context, slug string) (Post, bool, error) {
+	for _, p := range m.posts {
+		if p.Slug == slug {
+			return p, true, nil
+		}
+	}
+	return Post{}, false, nil
+}
+
+func (m *MockDataStore) SavePost(ctx context.Context, p Post) (Post, bool, error) {
+	for i := range m.posts {
+		if m.posts[i].Slug == p.Slug {
+			p.ID = m.posts[i].ID
+			m.posts[i] = p
+			return p, false, nil
+		}
+	}
+	p.ID = int64(len(m.posts)) + 1
+	m.posts = append(m.posts, p)
+	return p, true, nil
+}
+
+func (m *MockDataStore) DeletePost(ctx context.Context, slug string) (bool, error) {
+	for i, p := range m.posts {
+		if p.Slug == slug {
+			m.posts = slices.Delete(m.posts, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDataStore) ListShortLinks(ctx context.Context) ([]ShortLink, error) {
+	links := slices.Clone(m.links)
+	slices.SortFunc(links, func(a, b ShortLink) int { return cmp.Compare(a.Slug, b.Slug) })
+	return links, nil
+}
+
+func (m *MockDataStore) GetShortLink(ctx context.Context, slug string) (ShortLink, bool, error) {
+	for _, l := range m.links {
+		if l.Slug == slug {
+			return l, true, nil
+		}
+	}
+	return ShortLink{}, false, nil
+}
+
+func (m *MockDataStore) SaveShortLink(ctx context.Context, l ShortLink) (ShortLink, bool, error) {
+	for i := range m.links {
+		if m.links[i].Slug == l.Slug {
+			m.links[i] = l
+			return l, false, nil
+		}
+	}
+	m.links = append(m.links, l)
+	return l, true, nil
+}
+
+func (m *MockDataStore) DeleteShortLink(ctx context.Context, slug string) (bool, error) {
+	for i, l := range m.links {
+		if l.Slug == slug {
+			m.links = slices.Delete(m.links, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDataStore) RecordUptimeCheck(ctx context.Context, check UptimeCheck) error {
+	m.uptimeChecks = append(m.uptimeChecks, check)
+	return nil
+}
+
+func (m *MockDataStore) GetUptimeChecks(ctx context.Context, from, to time.Time) ([]UptimeCheck, error) {
+	m.lastFrom, m.lastTo = from, to
+	var checks []UptimeCheck
+	for _, c := range m.uptimeChecks {
+		if !c.CheckedAt.Before(from) && c.CheckedAt.Before(to) {
+			checks = append(checks, c)
+		}
+	}
+	return checks, nil
+}
+
+func (m *MockDataStore) SaveCertificateCheck(ctx context.Context, check CertificateCheck) error {
+	for i := range m.certChecks {
+		if m.certChecks[i].Domain == check.Domain {
+			m.certChecks[i] = check
+			return nil
+		}
+	}
+	m.certChecks = append(m.certChecks, check)
+	return nil
+}
+
+func (m *MockDataStore) GetCertificateChecks(ctx context.Context) ([]CertificateCheck, error) {
+	checks := slices.Clone(m.certChecks)
+	slices.SortFunc(checks, func(a, b CertificateCheck) int { return cmp.Compare(a.Domain, b.Domain) })
+	return checks, nil
+}
+
+func (m *MockDataStore) RecordDeploy(ctx context.Context, deploy Deploy) (Deploy, error) {
+	deploy.ID = int64(len(m.deploys) + 1)
+	m.deploys = append(m.deploys, deploy)
+	return deploy, nil
+}
+
+func (m *MockDataStore) GetDeploys(ctx context.Context, from, to time.Time) ([]Deploy, error) {
+	var deploys []Deploy
+	for _, d := range m.deploys {
+		if !d.DeployedAt.Before(from) && d.DeployedAt.Before(to) {
+			deploys = append(deploys, d)
+		}
+	}
+	return deploys, nil
+}
+
+func (m *MockDataStore) DeleteVisitorData(ctx context.Context, ids VisitorIDs) (int, error) {
+	m.lastIDs = ids
+	hashes, sessionIDs := mockVisitorKeys(ids)
+	deleted := 0
+	for key := range hashes {
+		if m.uniques[key] {
+			delete(m.uniques, key)
+			deleted++
+		}
+	}
+	exposures := m.exposures[:0]
+	for _, e := range m.exposures {
+		if hashes[e.Day.UTC().Format(time.DateOnly)+"/"+e.VisitorHash] {
+			deleted++
+			continue
+		}
+		exposures = append(exposures, e)
+	}
+	m.exposures = exposures
+	for id := range sessionIDs {
+		if _, ok := m.sessions[id]; ok {
+			delete(m.sessions, id)
+			deleted++
+		}
+	}
+	events := m.events[:0]
+	for _, e := range m.events {
+		if e.SessionID != "" && sessionIDs[e.SessionID] {
+			deleted++
+			continue
+		}
+		events = append(events, e)
+	}
+	m.events = events
+	return deleted, nil
+}
+
+// mockVisitorKeys indexes ids the way MockDataStore keys uniques and sessions
+func mockVisitorKeys(ids VisitorIDs) (map[string]bool, map[string]bool) {
+	hashes, sessionIDs := make(map[string]bool), make(map[string]bool)
+	for _, h := range ids.Hashes {
+		hashes[h.Day.UTC().Format(time.DateOnly)+"/"+h.Hash] = true
+	}
+	for _, id := range ids.SessionIDs {
+		sessionIDs[id] = true
+	}
+	return hashes, sessionIDs
+}
+
+func (m *MockDataStore) Ping(ctx context.Context) error {
+	return m.pingErr
+}
+
 func (m *MockDataStore) Close() {}
 
 func Test_incrementVisitCount(t *testing.T) {
 	mockDataStore := &MockDataStore{}
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
 
 	// Create a response recorder
 	w := httptest.NewRecorder()
@@ -35,7 +705,7 @@ func Test_incrementVisitCount(t *testing.T) {
 		t.Fatalf("could not create request: %v", err)
 	}
 
-	incrementVisitCount(w, req, mockDataStore)
+	incrementVisitCount(w, req, mockDataStore, clock)
 
 	res := w.Result()
 	if res.StatusCode != http.StatusOK {
@@ -54,6 +724,50 @@ func Test_incrementVisitCount(t *testing.T) {
 	if mockDataStore.visitCount != 1 {
 		t.Errorf("expected visit count to be 1; got %d", mockDataStore.visitCount)
 	}
+
+	if !mockDataStore.lastVisit.Timestamp.Equal(clock.Now()) {
+		t.Errorf("expected visit timestamp %v from the clock; got %v", clock.Now(), mockDataStore.lastVisit.Timestamp)
+	}
+}
+
+func Test_incrementVisitCount_Body(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		wantReferrer   string
+		wantUTM        UTM
+	}{
+		{"Empty body", "", http.StatusOK, "", UTM{}},
+		{"Referrer", `{"referrer": "https://www.linkedin.com/feed/?utm_source=share"}`, http.StatusOK, "linkedin.com", UTM{}},
+		{"Unknown fields ignored", `{"extra": true}`, http.StatusOK, "", UTM{}},
+		{"UTM parameters", `{"utm_source": "LinkedIn", "utm_campaign": "acme-backend"}`, http.StatusOK, "", UTM{Source: "linkedin", Campaign: "acme-backend"}},
+		{"Invalid JSON", `{"referrer":`, http.StatusBadRequest, "", UTM{}},
+		{"Too large", `{"referrer": "` + strings.Repeat("a", maxVisitBodyBytes) + `"}`, http.StatusBadRequest, "", UTM{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, apiPath, strings.NewReader(tt.body))
+
+			incrementVisitCount(w, req, mockDataStore, realClock{})
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %d", tt.expectedStatus, w.Code)
+			}
+			if mockDataStore.lastVisit.UTM != tt.wantUTM {
+				t.Errorf("expected normalized UTM parameters; got %+v", mockDataStore.lastVisit.UTM)
+			}
+			if mockDataStore.lastVisit.Referrer != tt.wantReferrer {
+				t.Errorf("expected referrer %q; got %q", tt.wantReferrer, mockDataStore.lastVisit.Referrer)
+			}
+			if tt.expectedStatus != http.StatusOK && mockDataStore.visitCount != 0 {
+				t.Error("expected a rejected visit not to be recorded")
+			}
+		})
+	}
 }
 
 func Test_getVisitCount(t *testing.T) {
@@ -105,7 +819,7 @@ func Test_visitCountHandler(t *testing.T) {
 				t.Fatalf("could not create request: %v", err)
 			}
 
-			visitCountHandler(w, req, mockDataStore)
+			visitCountHandler(w, req, mockDataStore, realClock{})
 
 			res := w.Result()
 			if res.StatusCode != tt.expectedStatus {
@@ -114,3 +828,51 @@ func Test_visitCountHandler(t *testing.T) {
 		})
 	}
 }
+
+// discardResponseWriter drops the response, keeping its header map between requests so a
+// benchmark measures only what the handler allocates
+type discardResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(status int)      { w.status = status }
+
+func (w *discardResponseWriter) reset() {
+	clear(w.header)
+	w.status = 0
+}
+
+func BenchmarkGetVisitCount(b *testing.B) {
+	dataStore := newStaleCountStore(&MockDataStore{visitCount: 1234}, realClock{})
+	req := httptest.NewRequest(http.MethodGet, apiPath, nil)
+	w := &discardResponseWriter{header: make(http.Header)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.reset()
+		getVisitCount(w, req, dataStore)
+	}
+}
+
+// getVisitCountAllocs is the allocation budget for reading the count as JSON
+const getVisitCountAllocs = 1
+
+func Test_getVisitCount_Allocations(t *testing.T) {
+	dataStore := newStaleCountStore(&MockDataStore{visitCount: 1234}, realClock{})
+	w := &discardResponseWriter{header: make(http.Header)}
+	for _, accept := range []string{"", "*/*", "application/json"} {
+		req := httptest.NewRequest(http.MethodGet, apiPath, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			w.reset()
+			getVisitCount(w, req, dataStore)
+		})
+		if allocs > getVisitCountAllocs {
+			t.Errorf("Accept %q: expected at most %d allocations per request, got %.1f", accept, getVisitCountAllocs, allocs)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const heatmapPath = "/api/stats/heatmap"
+
+// heatmapWeekdays are the heatmap's rows, in order; weeks start on Monday, as for the
+// comparison.
+var heatmapWeekdays = [7]time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// heatmapResponse is the body returned by GET /api/stats/heatmap.
+type heatmapResponse struct {
+	Timezone string     `json:"timezone"`
+	Days     int        `json:"days"`
+	Weekdays []string   `json:"weekdays"` // the row labels, Monday first
+	Visits   [7][24]int `json:"visits"`   // Visits[day][hour]
+	Max      int        `json:"max"`      // the busiest cell, for scaling colours
+}
+
+// heatmapMatrix lays cells out as rows of hours, one per day of the week from Monday.
+func heatmapMatrix(cells []HeatmapCell) (matrix [7][24]int, busiest int) {
+	for _, c := range cells {
+		if c.Hour < 0 || c.Hour > 23 || c.Weekday < time.Sunday || c.Weekday > time.Saturday {
+			continue
+		}
+		day := (int(c.Weekday) + 6) % 7
+		matrix[day][c.Hour] += c.Visits
+		busiest = max(busiest, matrix[day][c.Hour])
+	}
+	return matrix, busiest
+}
+
+// heatmapHandler returns the visits of the last days by day of the week and hour of the day,
+// on the clock in the tz query parameter, to show when visitors come.
+func heatmapHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	loc, err := parseTimezone(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := clock.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from := today.AddDate(0, 0, -(days - 1))
+	to := today.AddDate(0, 0, 1)
+
+	cells, err := dataStore.GetVisitHeatmap(r.Context(), from, to, loc)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get heatmap: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := heatmapResponse{Timezone: loc.String(), Days: days, Weekdays: make([]string, 0, len(heatmapWeekdays))}
+	for _, d := range heatmapWeekdays {
+		response.Weekdays = append(response.Weekdays, d.String())
+	}
+	response.Visits, response.Max = heatmapMatrix(cells)
+	writeResponse(w, r, response)
+}
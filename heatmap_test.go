@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_heatmapMatrix(t *testing.T) {
+	matrix, busiest := heatmapMatrix([]HeatmapCell{
+		{Weekday: time.Monday, Hour: 0, Visits: 1},
+		{Weekday: time.Tuesday, Hour: 9, Visits: 6},
+		{Weekday: time.Sunday, Hour: 23, Visits: 2},
+		{Weekday: time.Sunday, Hour: 24, Visits: 9}, // out of range
+	})
+	if matrix[0][0] != 1 || matrix[1][9] != 6 || matrix[6][23] != 2 {
+		t.Errorf("expected Monday first and Sunday last; got %v", matrix)
+	}
+	if busiest != 6 {
+		t.Errorf("expected the busiest cell to have 6 visits; got %d", busiest)
+	}
+}
+
+func Test_heatmapHandler(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("could not load location: %v", err)
+	}
+	// 02:00 UTC on March 3rd is still March 2nd in New York
+	now := time.Date(2024, 3, 3, 2, 0, 0, 0, time.UTC)
+	mockDataStore := &MockDataStore{heatmap: []HeatmapCell{{Weekday: time.Saturday, Hour: 21, Visits: 4}}}
+
+	rr := httptest.NewRecorder()
+	heatmapHandler(rr, httptest.NewRequest(http.MethodGet, heatmapPath+"?days=7&tz=America/New_York", nil), mockDataStore, newFakeClock(now))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp heatmapResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if resp.Timezone != "America/New_York" || resp.Days != 7 || resp.Max != 4 || resp.Visits[5][21] != 4 {
+		t.Errorf("unexpected heatmap %+v", resp)
+	}
+	if len(resp.Weekdays) != 7 || resp.Weekdays[0] != "Monday" || resp.Weekdays[6] != "Sunday" {
+		t.Errorf("unexpected weekdays %v", resp.Weekdays)
+	}
+
+	// The window is whole days in the requested zone, ending with today
+	wantFrom, wantTo := time.Date(2024, 2, 25, 0, 0, 0, 0, loc), time.Date(2024, 3, 3, 0, 0, 0, 0, loc)
+	if !mockDataStore.lastFrom.Equal(wantFrom) || !mockDataStore.lastTo.Equal(wantTo) {
+		t.Errorf("expected the window [%s, %s); got [%s, %s)", wantFrom, wantTo, mockDataStore.lastFrom, mockDataStore.lastTo)
+	}
+}
+
+func Test_heatmapHandler_InvalidRequest(t *testing.T) {
+	for _, tt := range []struct {
+		method, url string
+		status      int
+	}{
+		{http.MethodPost, heatmapPath, http.StatusMethodNotAllowed},
+		{http.MethodGet, heatmapPath + "?days=367", http.StatusBadRequest},
+		{http.MethodGet, heatmapPath + "?tz=Not/AZone", http.StatusBadRequest},
+		{http.MethodGet, heatmapPath + "?tz=Local", http.StatusBadRequest},
+	} {
+		rr := httptest.NewRecorder()
+		heatmapHandler(rr, httptest.NewRequest(tt.method, tt.url, nil), &MockDataStore{}, newFakeClock(time.Now()))
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.url, tt.status, rr.Code)
+		}
+	}
+}
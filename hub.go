@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// countHub wakes requests waiting for the visit count to change. It is in-process only, so
+// with several replicas a waiter hears about the visits recorded on its own instance and
+// picks up the rest when its poll times out.
+type countHub struct {
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+func newCountHub() *countHub {
+	return &countHub{changed: make(chan struct{})}
+}
+
+// Changed returns a channel that is closed at the next change. Waiters take it before
+// reading the count, so a visit recorded in between still wakes them.
+func (h *countHub) Changed() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.changed
+}
+
+// Notify wakes every waiter.
+func (h *countHub) Notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// notifyingStore is a DataStore decorator that notifies the hub after each recorded visit.
+type notifyingStore struct {
+	DataStore
+	hub *countHub
+}
+
+func newNotifyingStore(ds DataStore, hub *countHub) *notifyingStore {
+	return &notifyingStore{DataStore: ds, hub: hub}
+}
+
+// IncrementVisitCount records the visit, then wakes the waiters.
+func (s *notifyingStore) IncrementVisitCount(ctx context.Context, visit Visit) error {
+	if err := s.DataStore.IncrementVisitCount(ctx, visit); err != nil {
+		return err
+	}
+	s.hub.Notify()
+	return nil
+}
+
+// IncrementVisitCounts records the visits, then wakes the waiters once.
+func (s *notifyingStore) IncrementVisitCounts(ctx context.Context, visits []Visit) error {
+	if err := s.DataStore.IncrementVisitCounts(ctx, visits); err != nil {
+		return err
+	}
+	if len(visits) > 0 {
+		s.hub.Notify()
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// splitmix64 spreads sequential integers over 64 bits, standing in for visitor hashes
+func splitmix64(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
+
+func Test_hyperLogLog_Estimate(t *testing.T) {
+	tests := []int{0, 1, 1000, 100000}
+
+	for _, n := range tests {
+		h := newHyperLogLog()
+		for i := 0; i < n; i++ {
+			h.Add(splitmix64(uint64(i)))
+			h.Add(splitmix64(uint64(i))) // repeats must not count
+		}
+
+		got := h.Estimate()
+		if math.Abs(float64(got-n)) > 0.03*float64(n) {
+			t.Errorf("estimate for %d distinct values = %d, want within 3%%", n, got)
+		}
+	}
+}
+
+func Test_hyperLogLog_Merge(t *testing.T) {
+	a, b := newHyperLogLog(), newHyperLogLog()
+	for i := 0; i < 30000; i++ {
+		a.Add(splitmix64(uint64(i)))
+	}
+	for i := 20000; i < 50000; i++ {
+		b.Add(splitmix64(uint64(i)))
+	}
+
+	a.Merge(b)
+	if got := a.Estimate(); math.Abs(float64(got-50000)) > 0.03*50000 {
+		t.Errorf("merged estimate = %d, want about 50000", got)
+	}
+}
+
+func Test_hyperLogLog_Binary(t *testing.T) {
+	h := newHyperLogLog()
+	for i := 0; i < 5000; i++ {
+		h.Add(splitmix64(uint64(i)))
+	}
+
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	decoded := newHyperLogLog()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if decoded.Estimate() != h.Estimate() {
+		t.Errorf("decoded estimate = %d, want %d", decoded.Estimate(), h.Estimate())
+	}
+
+	if err := decoded.UnmarshalBinary(data[:100]); err == nil {
+		t.Error("expected truncated sketch to be rejected")
+	}
+	data[0] = hllPrecision + 1
+	if err := decoded.UnmarshalBinary(data); err == nil {
+		t.Error("expected sketch with a different precision to be rejected")
+	}
+}
@@ -0,0 +1,321 @@
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"resume-backend/internal/store"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const defaultIntegrationPostgresImage = "postgres:16-alpine"
+
+// startPostgres runs a throwaway Postgres container through the docker CLI and
+// points the DB_* environment at it. Tests are skipped when docker isn't available.
+func startPostgres(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not available, skipping integration test")
+	}
+
+	image := os.Getenv("INTEGRATION_POSTGRES_IMAGE")
+	if image == "" {
+		image = defaultIntegrationPostgresImage
+	}
+
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "POSTGRES_USER=resume",
+		"-e", "POSTGRES_PASSWORD=resume",
+		"-e", "POSTGRES_DB=resume",
+		"-p", "127.0.0.1::5432",
+		image,
+	).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to start postgres container: %v: %s", err, out)
+	}
+	containerID := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		if out, err := exec.Command("docker", "rm", "-f", containerID).CombinedOutput(); err != nil {
+			t.Logf("failed to remove postgres container: %v: %s", err, out)
+		}
+	})
+
+	out, err = exec.Command("docker", "port", containerID, "5432/tcp").Output()
+	if err != nil {
+		t.Fatalf("failed to get postgres port: %v", err)
+	}
+	host, port, err := net.SplitHostPort(strings.TrimSpace(strings.Split(string(out), "\n")[0]))
+	if err != nil {
+		t.Fatalf("failed to parse postgres port %q: %v", out, err)
+	}
+
+	t.Setenv("DB_USER", "resume")
+	t.Setenv("DB_PASSWORD", "resume")
+	t.Setenv("DB_HOST", host)
+	t.Setenv("DB_PORT", port)
+	t.Setenv("DB_NAME", "resume")
+
+	waitForPostgres(t, fmt.Sprintf("postgres://resume:resume@%s:%s/resume", host, port))
+}
+
+// waitForPostgres polls until the database accepts connections.
+func waitForPostgres(t *testing.T, connString string) {
+	t.Helper()
+	deadline := time.Now().Add(60 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		conn, err := pgx.Connect(ctx, connString)
+		if err == nil {
+			err = conn.Ping(ctx)
+			conn.Close(ctx)
+		}
+		cancel()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("postgres did not become ready: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// newIntegrationStore sets up the real database, migrated as at startup.
+func newIntegrationStore(t *testing.T) store.DataStore {
+	t.Helper()
+	startPostgres(t)
+
+	dataStore, err := store.SetupDatabase(context.Background())
+	if err != nil {
+		t.Fatalf("SetupDatabase() error = %v", err)
+	}
+	t.Cleanup(dataStore.Close)
+	return dataStore
+}
+
+// integrationConn connects to the database startPostgres set up, for checking tables directly.
+func integrationConn(t *testing.T) *pgx.Conn {
+	t.Helper()
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%s@%s/%s",
+		os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), net.JoinHostPort(os.Getenv("DB_HOST"), os.Getenv("DB_PORT")), os.Getenv("DB_NAME")))
+	if err != nil {
+		t.Fatalf("failed to connect to postgres: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(ctx) })
+	return conn
+}
+
+// countRows returns the single count query returns.
+func countRows(t *testing.T, conn *pgx.Conn, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(context.Background(), query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+// newIntegrationServer sets up the real database and serves the full route table.
+func newIntegrationServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	dataStore := newIntegrationStore(t)
+	t.Setenv("ALLOWED_ORIGINS", "http://allowed.com")
+
+	mux := http.NewServeMux()
+	registerRoutes(mux, newCoalescingStore(dataStore, 0), realClock{})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestIntegration_VisitCount(t *testing.T) {
+	srv := newIntegrationServer(t)
+
+	for i := 0; i < 3; i++ {
+		res, err := http.Post(srv.URL+apiPath, "application/json", nil)
+		if err != nil {
+			t.Fatalf("POST %s error = %v", apiPath, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200 OK; got %v", res.Status)
+		}
+	}
+
+	res, err := http.Get(srv.URL + apiPath)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", apiPath, err)
+	}
+	defer res.Body.Close()
+
+	var response map[string]int
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if response["visits"] != 3 {
+		t.Errorf("expected visit count to be 3; got %d", response["visits"])
+	}
+}
+
+func TestIntegration_HealthChecks(t *testing.T) {
+	srv := newIntegrationServer(t)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: expected status 200 OK; got %v", path, res.Status)
+		}
+	}
+}
+
+func TestIntegration_PartitionExistingVisits(t *testing.T) {
+	const partitionMonth = "y2006m01" // how internal/store names the monthly partitions
+	dataStore := newIntegrationStore(t)
+	conn := integrationConn(t)
+	ctx := context.Background()
+
+	// A populated, unpartitioned table, with visits in each of the last three months
+	now := time.Now().UTC()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var visits []Visit
+	for months := 1; months <= 3; months++ {
+		for i := 0; i < months*10; i++ {
+			visits = append(visits, Visit{Timestamp: thisMonth.AddDate(0, -months, 0).Add(time.Duration(i) * time.Hour), Referrer: "github.com"})
+		}
+	}
+	if err := dataStore.IncrementVisitCounts(ctx, visits); err != nil {
+		t.Fatalf("IncrementVisitCounts() error = %v", err)
+	}
+	months := countRows(t, conn, "SELECT count(DISTINCT date_trunc('month', timestamp AT TIME ZONE 'UTC')) FROM visits")
+	if total := countRows(t, conn, "SELECT count(*) FROM visits"); total != len(visits) || months != 3 {
+		t.Fatalf("expected %d visits over 3 months before partitioning, got %d over %d", len(visits), total, months)
+	}
+
+	if err := dataStore.EnsureVisitPartitions(ctx, now, 2); err != nil {
+		t.Fatalf("EnsureVisitPartitions() error = %v", err)
+	}
+	// Running it again finds the table already partitioned
+	if err := dataStore.EnsureVisitPartitions(ctx, now, 2); err != nil {
+		t.Fatalf("second EnsureVisitPartitions() error = %v", err)
+	}
+
+	if countRows(t, conn, "SELECT count(*) FROM pg_partitioned_table WHERE partrelid = 'visits'::regclass") != 1 {
+		t.Fatal("expected visits to be partitioned")
+	}
+	if total := countRows(t, conn, "SELECT count(*) FROM visits"); total != len(visits) {
+		t.Errorf("expected the %d visits kept, got %d", len(visits), total)
+	}
+	for months := 1; months <= 3; months++ {
+		from := thisMonth.AddDate(0, -months, 0)
+		if n := countRows(t, conn, "SELECT count(*) FROM visits WHERE timestamp >= $1 AND timestamp < $2", from, from.AddDate(0, 1, 0)); n != months*10 {
+			t.Errorf("expected %d visits in %s, got %d", months*10, from.Format("2006-01"), n)
+		}
+	}
+	legacy := "visits_before_" + thisMonth.Format(partitionMonth)
+	if n := countRows(t, conn, "SELECT count(*) FROM visits WHERE tableoid = $1::regclass", legacy); n != len(visits) {
+		t.Errorf("expected the old rows in %s, got %d there", legacy, n)
+	}
+
+	// New visits land in this month's partition, and the rebuilt views still refresh
+	if err := dataStore.IncrementVisitCounts(ctx, []Visit{{Timestamp: now}}); err != nil {
+		t.Fatalf("IncrementVisitCounts() after partitioning error = %v", err)
+	}
+	current := "visits_" + thisMonth.Format(partitionMonth)
+	if n := countRows(t, conn, "SELECT count(*) FROM visits WHERE tableoid = $1::regclass", current); n != 1 {
+		t.Errorf("expected the new visit in %s, got %d there", current, n)
+	}
+	if err := dataStore.RefreshSummaries(ctx); err != nil {
+		t.Errorf("RefreshSummaries() after partitioning error = %v", err)
+	}
+}
+
+func TestIntegration_SummaryViews(t *testing.T) {
+	dataStore := newIntegrationStore(t)
+	conn := integrationConn(t)
+	ctx := context.Background()
+
+	// Visits over two days, on quarter hour and hour boundaries and between them
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -2)
+	record := func(n int, referrers ...string) {
+		t.Helper()
+		var visits []Visit
+		for i := 0; i < n; i++ {
+			visits = append(visits, Visit{Timestamp: start.Add(time.Duration(i) * 7 * time.Minute), Referrer: referrers[i%len(referrers)]})
+		}
+		if err := dataStore.IncrementVisitCounts(ctx, visits); err != nil {
+			t.Fatalf("IncrementVisitCounts() error = %v", err)
+		}
+	}
+
+	// The views are compared with the visits they summarize, bucket by bucket
+	const quarterHourDrift = `SELECT count(*) FROM (
+			SELECT to_timestamp(floor(extract(epoch FROM timestamp) / 900) * 900) AS bucket, count(*) AS visits
+			FROM visits GROUP BY bucket
+		) AS base FULL OUTER JOIN visits_by_quarter_hour AS summary USING (bucket)
+		WHERE base.visits IS DISTINCT FROM summary.visits`
+	const referrerDrift = `SELECT count(*) FROM (
+			SELECT date_trunc('hour', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS hour, referrer, count(*) AS visits
+			FROM visits WHERE referrer IS NOT NULL GROUP BY hour, referrer
+		) AS base FULL OUTER JOIN referrers_by_hour AS summary USING (hour, referrer)
+		WHERE base.visits IS DISTINCT FROM summary.visits`
+	check := func(total int) {
+		t.Helper()
+		if err := dataStore.RefreshSummaries(ctx); err != nil {
+			t.Fatalf("RefreshSummaries() error = %v", err)
+		}
+		if n := countRows(t, conn, "SELECT COALESCE(SUM(visits), 0) FROM visits_by_quarter_hour"); n != total {
+			t.Errorf("expected %d visits summarized, got %d", total, n)
+		}
+		if n := countRows(t, conn, quarterHourDrift); n != 0 {
+			t.Errorf("expected visits_by_quarter_hour to match visits, %d buckets differ", n)
+		}
+		if n := countRows(t, conn, referrerDrift); n != 0 {
+			t.Errorf("expected referrers_by_hour to match visits, %d hours differ", n)
+		}
+
+		// The summarized stats agree with the ones read from visits, in a zone off UTC by a half hour
+		loc, err := time.LoadLocation("Asia/Kolkata")
+		if err != nil {
+			t.Fatal(err)
+		}
+		from, to := start.AddDate(0, 0, -1), start.AddDate(0, 0, 3)
+		want, err := dataStore.GetDailyVisits(ctx, from, to, loc)
+		if err != nil {
+			t.Fatalf("GetDailyVisits() error = %v", err)
+		}
+		got, err := dataStore.GetSummarizedDailyVisits(ctx, from, to, loc)
+		if err != nil {
+			t.Fatalf("GetSummarizedDailyVisits() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected summarized daily visits %v, got %v", want, got)
+		}
+	}
+
+	record(300, "github.com", "news.ycombinator.com", "")
+	check(300)
+
+	// A refresh picks up visits recorded since the last one
+	record(50, "linkedin.com")
+	check(350)
+}
@@ -0,0 +1,155 @@
+// Package awsauth signs requests to AWS with Signature Version 4, for the few AWS APIs the
+// backend calls, without pulling in the AWS SDK.
+package awsauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const algorithm = "AWS4-HMAC-SHA256"
+
+// Credentials sign requests to AWS.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // set for temporary credentials
+}
+
+// CredentialsFromEnv reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+func CredentialsFromEnv() Credentials {
+	return Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// Valid reports whether the credentials can sign requests.
+func (c Credentials) Valid() bool {
+	return c.AccessKeyID != "" && c.SecretAccessKey != ""
+}
+
+// RegionFromEnv reads AWS_REGION, falling back to AWS_DEFAULT_REGION.
+func RegionFromEnv() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// Presign returns query with the parameters that presign a bodiless request to host and
+// path at now, valid for expires, as an encoded query string.
+func Presign(method, host, path string, query url.Values, service, region string, creds Credentials, now time.Time, expires time.Duration) string {
+	now = now.UTC()
+	scope := scope(now, region, service)
+	signed := url.Values{}
+	for k, v := range query {
+		signed[k] = v
+	}
+	signed.Set("X-Amz-Algorithm", algorithm)
+	signed.Set("X-Amz-Credential", creds.AccessKeyID+"/"+scope)
+	signed.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	signed.Set("X-Amz-Expires", fmt.Sprint(int(expires/time.Second)))
+	signed.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		signed.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	canonicalQuery := canonicalQueryString(signed)
+
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		method, path, canonicalQuery, "host:" + host + "\n", "host", hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	return canonicalQuery + "&X-Amz-Signature=" + signature(canonicalRequest, now, scope, region, service, creds)
+}
+
+// Sign adds the headers that authenticate req, whose body is body, at now. Every header
+// already set on req is signed, so Sign must be called after setting them.
+func Sign(req *http.Request, body []byte, service, region string, creds Credentials, now time.Time) {
+	now = now.UTC()
+	scope := scope(now, region, service)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, canonicalQueryString(req.URL.Query()), canonicalHeaders.String(), signedHeaders, hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, creds.AccessKeyID, scope, signedHeaders, signature(canonicalRequest, now, scope, region, service, creds)))
+}
+
+func scope(now time.Time, region, service string) string {
+	return now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+}
+
+// signature signs canonicalRequest with a key derived for the day, region and service.
+func signature(canonicalRequest string, now time.Time, scope, region, service string, creds Credentials) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{algorithm, now.Format("20060102T150405Z"), scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalQueryString encodes query sorted by key, percent-encoding all but unreserved characters.
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, escape(k)+"="+escape(v))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,67 @@
+package awsauth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The credentials and date of the AWS SigV4 test suite
+var (
+	testCreds = Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	testNow   = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+)
+
+func TestSign(t *testing.T) {
+	// get-vanilla from the AWS SigV4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	Sign(req, nil, "service", "us-east-1", testCreds, testNow)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %s\nwant %s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %s", got)
+	}
+}
+
+func TestSign_SessionToken(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://secretsmanager.eu-west-1.amazonaws.com/", nil)
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	creds := testCreds
+	creds.SessionToken = "session"
+	Sign(req, []byte(`{}`), "secretsmanager", "eu-west-1", creds, testNow)
+
+	if req.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Error("expected the session token header")
+	}
+	if auth := req.Header.Get("Authorization"); !strings.Contains(auth, "SignedHeaders=host;x-amz-date;x-amz-security-token;x-amz-target,") {
+		t.Errorf("expected every header to be signed; got %s", auth)
+	}
+}
+
+func TestPresign(t *testing.T) {
+	query := Presign(http.MethodGet, "db.example.com:5432", "/", url.Values{"Action": {"connect"}, "DBUser": {"app"}},
+		"rds-db", "us-east-1", testCreds, testNow, 15*time.Minute)
+
+	want := "Action=connect&DBUser=app&X-Amz-Algorithm=AWS4-HMAC-SHA256" +
+		"&X-Amz-Credential=AKIDEXAMPLE%2F20150830%2Fus-east-1%2Frds-db%2Faws4_request" +
+		"&X-Amz-Date=20150830T123600Z&X-Amz-Expires=900&X-Amz-SignedHeaders=host&X-Amz-Signature="
+	if !strings.HasPrefix(query, want) || len(query) != len(want)+64 {
+		t.Errorf("Presign() = %s, want %s<signature>", query, want)
+	}
+	if again := Presign(http.MethodGet, "db.example.com:5432", "/", url.Values{"Action": {"connect"}, "DBUser": {"app"}},
+		"rds-db", "us-east-1", testCreds, testNow.Add(time.Second), 15*time.Minute); again == query {
+		t.Error("expected the signature to depend on the time")
+	}
+}
+
+func TestCanonicalQueryString(t *testing.T) {
+	got := canonicalQueryString(url.Values{"b": {"2", "1"}, "a": {"x y/~"}})
+	if want := "a=x%20y%2F~&b=1&b=2"; got != want {
+		t.Errorf("canonicalQueryString() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,109 @@
+// Package i18n translates the messages the API shows visitors, from catalogs embedded in
+// the binary, so a visitor whose browser asks for another language gets errors they can read.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Fallback is the language of the catalog every message must be in, used when none of the
+// languages a visitor accepts has it.
+var Fallback = language.English
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// Catalog holds messages by language and key.
+type Catalog struct {
+	messages map[language.Tag]map[string]string
+}
+
+// New reads a catalog from the JSON files in fsys, each an object of messages by key named
+// after its language, such as fr.json or pt-BR.json. The Fallback language's file must be
+// among them and hold every key the others do.
+func New(fsys fs.FS) (*Catalog, error) {
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{messages: make(map[language.Tag]map[string]string, len(names))}
+	for _, name := range names {
+		tag, err := language.Parse(strings.TrimSuffix(path.Base(name), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("i18n: %s is not named after a language: %w", name, err)
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("i18n: invalid %s: %w", name, err)
+		}
+		c.messages[tag] = messages
+	}
+
+	fallback, ok := c.messages[Fallback]
+	if !ok {
+		return nil, fmt.Errorf("i18n: no %s.json", Fallback)
+	}
+	for tag, messages := range c.messages {
+		for key := range messages {
+			if _, ok := fallback[key]; !ok {
+				return nil, fmt.Errorf("i18n: %s has %q, which %s.json doesn't", tag, key, Fallback)
+			}
+		}
+	}
+	return c, nil
+}
+
+var defaultCatalog = mustNew()
+
+func mustNew() *Catalog {
+	fsys, err := fs.Sub(locales, "locales")
+	if err != nil {
+		panic(err)
+	}
+	c, err := New(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Default returns the catalog embedded in the binary.
+func Default() *Catalog {
+	return defaultCatalog
+}
+
+// Translate returns the message for key in the language acceptLanguage, an Accept-Language
+// header, prefers, along with that language. Each accepted language falls back to its
+// parents, so fr-CA is served from fr, before the next is tried; after them all comes the
+// Fallback language, and then the key itself. args fill in the message's verbs as with
+// fmt.Sprintf.
+func (c *Catalog) Translate(acceptLanguage, key string, args ...any) (string, language.Tag) {
+	// Malformed headers yield the tags parsed before the error
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	for _, tag := range append(tags, Fallback) {
+		for t := tag; ; t = t.Parent() {
+			if message, ok := c.messages[t][key]; ok {
+				if len(args) > 0 {
+					message = fmt.Sprintf(message, args...)
+				}
+				return message, t
+			}
+			if t.IsRoot() {
+				break
+			}
+		}
+	}
+	return key, Fallback
+}
@@ -0,0 +1,72 @@
+package i18n
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/text/language"
+)
+
+func TestDefault(t *testing.T) {
+	c := Default()
+	fallback := c.messages[Fallback]
+	if len(fallback) == 0 {
+		t.Fatal("expected the embedded fallback catalog to hold messages")
+	}
+	// Partial translations fall back per key, but the shipped ones should be complete
+	for tag, messages := range c.messages {
+		if len(messages) != len(fallback) {
+			t.Errorf("%s.json has %d of the %d messages", tag, len(messages), len(fallback))
+		}
+	}
+}
+
+func TestCatalog_Translate(t *testing.T) {
+	c, err := New(fstest.MapFS{
+		"en.json":    {Data: []byte(`{"hello": "Hello", "bye": "Goodbye", "days": "Pick between 1 and %d days"}`)},
+		"fr.json":    {Data: []byte(`{"hello": "Bonjour", "days": "Choisissez entre 1 et %d jours"}`)},
+		"pt-BR.json": {Data: []byte(`{"hello": "Olá"}`)},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		acceptLanguage, key string
+		want                string
+		wantTag             language.Tag
+	}{
+		{"fr", "hello", "Bonjour", language.French},
+		{"fr-CA", "hello", "Bonjour", language.French},          // from the parent language
+		{"fr-CA, en;q=0.5", "bye", "Goodbye", language.English}, // missing keys fall back
+		{"de, fr;q=0.8", "hello", "Bonjour", language.French},   // in order of preference
+		{"fr;q=0.2, pt-BR", "hello", "Olá", language.BrazilianPortuguese},
+		{"pt-PT", "hello", "Hello", language.English}, // pt-BR isn't pt-PT's parent
+		{"", "hello", "Hello", language.English},
+		{"fr;q=0", "hello", "Hello", language.English},
+		{"no such language!", "hello", "Hello", language.English},
+		{"fr", "missing", "missing", language.English},
+	} {
+		got, tag := c.Translate(tt.acceptLanguage, tt.key)
+		if got != tt.want || tag != tt.wantTag {
+			t.Errorf("Translate(%q, %q) = %q, %s; want %q, %s", tt.acceptLanguage, tt.key, got, tag, tt.want, tt.wantTag)
+		}
+	}
+
+	if got, _ := c.Translate("fr", "days", 366); got != "Choisissez entre 1 et 366 jours" {
+		t.Errorf("expected the arguments filled in, got %q", got)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"no fallback":         {"fr.json": {Data: []byte(`{"hello": "Bonjour"}`)}},
+		"not a language":      {"en.json": {Data: []byte(`{}`)}, "messages.json": {Data: []byte(`{}`)}},
+		"invalid JSON":        {"en.json": {Data: []byte(`{"hello": 1}`)}},
+		"key not in fallback": {"en.json": {Data: []byte(`{"hello": "Hello"}`)}, "fr.json": {Data: []byte(`{"helo": "Bonjour"}`)}},
+	} {
+		if _, err := New(fsys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Package logging carries a request-scoped logger in the context, so lines logged by
+// handlers and stores can be correlated with the request that caused them.
+package logging
+
+import (
+	"context"
+	"log"
+)
+
+// Logger is the subset of *log.Logger used for error logging.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type contextKey struct{}
+
+// Returned by FromContext for contexts without a logger; see SetDefault
+var defaultLogger Logger = log.Default()
+
+// SetDefault sets the logger used outside requests, such as by background jobs. It is
+// meant to be called once at startup.
+func SetDefault(l Logger) {
+	defaultLogger = l
+}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger attached to ctx, or the default logger.
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(contextKey{}).(Logger); ok {
+		return l
+	}
+	return defaultLogger
+}
@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestFromContext(t *testing.T) {
+	saved := defaultLogger
+	defer SetDefault(saved)
+
+	fallback := &recordingLogger{}
+	SetDefault(fallback)
+	FromContext(context.Background()).Printf("background %d", 1)
+
+	scoped := &recordingLogger{}
+	ctx := NewContext(context.Background(), scoped)
+	FromContext(ctx).Printf("request %d", 2)
+
+	if len(fallback.lines) != 1 || fallback.lines[0] != "background 1" {
+		t.Errorf("expected the default logger outside requests; got %q", fallback.lines)
+	}
+	if len(scoped.lines) != 1 || scoped.lines[0] != "request 2" {
+		t.Errorf("expected the context's logger; got %q", scoped.lines)
+	}
+}
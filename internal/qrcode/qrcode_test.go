@@ -0,0 +1,242 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomonRemainder(t *testing.T) {
+	// HELLO WORLD at 1-M, from the worked example of the standard
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomonRemainder(data, reedSolomonDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNumDataCodewords(t *testing.T) {
+	for _, tt := range []struct {
+		version int
+		level   Level
+		want    int
+	}{
+		{1, Low, 19}, {1, Medium, 16}, {1, Quartile, 13}, {1, High, 9},
+		{5, Quartile, 62}, {10, Medium, 216}, {40, Low, 2956}, {40, High, 1276},
+	} {
+		if got := numDataCodewords(tt.version, tt.level); got != tt.want {
+			t.Errorf("%d-%d: got %d, want %d", tt.version, tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestAlignmentPositions(t *testing.T) {
+	for version, want := range map[int][]int{
+		1:  nil,
+		2:  {6, 18},
+		7:  {6, 22, 38},
+		14: {6, 26, 46, 66},
+		32: {6, 34, 60, 86, 112, 138},
+		40: {6, 30, 58, 86, 114, 142, 170},
+	} {
+		if got := alignmentPositions(version); !slices.Equal(got, want) {
+			t.Errorf("version %d: got %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestEncode_functionPatterns(t *testing.T) {
+	c, err := Encode([]byte(strings.Repeat("a", 150)), Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != 8 || c.Size != 49 {
+		t.Fatalf("expected version 8, got %d of size %d", c.Version, c.Size)
+	}
+
+	// The format bits read back as the level and mask, once the fixed mask is removed
+	format := 0
+	for i, p := range [][2]int{{0, 8}, {1, 8}, {2, 8}, {3, 8}, {4, 8}, {5, 8}, {7, 8}, {8, 8}, {8, 7}, {8, 5}, {8, 4}, {8, 3}, {8, 2}, {8, 1}, {8, 0}} {
+		if c.Dark(p[1], p[0]) {
+			format |= 1 << (14 - i)
+		}
+	}
+	format ^= 0x5412
+	if level, mask := format>>13, format>>10&7; level != formatLevelBits[Medium] || mask != c.Mask {
+		t.Errorf("format bits %015b: level %d mask %d, want %d and %d", format, level, mask, formatLevelBits[Medium], c.Mask)
+	}
+
+	// Version 8 is 001000 followed by its BCH bits, drawn least significant first
+	version := 0
+	for i := 0; i < 18; i++ {
+		if c.Dark(i/3, c.Size-11+i%3) {
+			version |= 1 << i
+		}
+	}
+	if version != 0b001000010110111100 {
+		t.Errorf("version bits %018b", version)
+	}
+
+	for _, corner := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+		if !c.Dark(corner[0], corner[1]) || c.Dark(corner[0]+1, corner[1]+1) || !c.Dark(corner[0]+3, corner[1]+3) {
+			t.Errorf("no finder at %v", corner)
+		}
+	}
+	if !c.Dark(8, c.Size-8) {
+		t.Error("expected the dark module")
+	}
+}
+
+func TestEncode_roundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		data    string
+		level   Level
+		version int
+	}{
+		{"https://example.com/r/cv", Medium, 2},
+		{"https://example.com/r/" + strings.Repeat("x", 200), Quartile, 13},
+		{"", High, 1},
+	} {
+		c, err := Encode([]byte(tt.data), tt.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Version != tt.version {
+			t.Errorf("expected version %d, got %d", tt.version, c.Version)
+		}
+		if got := readData(t, c); got != tt.data {
+			t.Errorf("read back %q, want %q", got, tt.data)
+		}
+	}
+}
+
+// readData reads a code's data back: it removes the mask, reads the codewords in placement
+// order, splits the blocks and checks their error correction, then decodes the byte segment.
+func readData(t *testing.T, c *Code) string {
+	t.Helper()
+	clean := *c
+	clean.modules = newGrid(c.Size)
+	for y := range c.modules {
+		copy(clean.modules[y], c.modules[y])
+	}
+	clean.applyMask(c.Mask)
+
+	var bits bitBuffer
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y][x] {
+					bits = append(bits, clean.modules[y][x])
+				}
+			}
+		}
+	}
+	raw := make([]byte, numRawDataModules(c.Version)/8)
+	for i := range raw {
+		for _, bit := range bits[8*i : 8*i+8] {
+			raw[i] <<= 1
+			if bit {
+				raw[i] |= 1
+			}
+		}
+	}
+
+	numBlocks := eccBlocks[c.Level][c.Version]
+	eccLen := eccCodewordsPerBlock[c.Level][c.Version]
+	numShortBlocks := numBlocks - len(raw)%numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < len(raw)/numBlocks-eccLen+1; i++ {
+		for j := range blocks {
+			if i < len(raw)/numBlocks-eccLen || j >= numShortBlocks {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	divisor := reedSolomonDivisor(eccLen)
+	for j, block := range blocks {
+		ecc := make([]byte, eccLen)
+		for i := range ecc {
+			ecc[i] = raw[k+i*numBlocks+j]
+		}
+		if want := reedSolomonRemainder(block, divisor); !bytes.Equal(ecc, want) {
+			t.Fatalf("block %d: error correction %v, want %v", j, ecc, want)
+		}
+		data = append(data, block...)
+	}
+
+	var buf bitBuffer
+	for _, b := range data {
+		buf.append(int(b), 8)
+	}
+	read := func(n int) int {
+		v := 0
+		for _, bit := range buf[:n] {
+			v <<= 1
+			if bit {
+				v |= 1
+			}
+		}
+		buf = buf[n:]
+		return v
+	}
+	if mode := read(4); mode != 0x4 {
+		t.Fatalf("expected byte mode, got %04b", mode)
+	}
+	out := make([]byte, read(countBits(c.Version)))
+	for i := range out {
+		out[i] = byte(read(8))
+	}
+	return string(out)
+}
+
+func TestEncode_tooLong(t *testing.T) {
+	if c, err := Encode(make([]byte, 2953), Low); err != nil || c.Version != 40 {
+		t.Errorf("expected the largest code to fit, got %v", err)
+	}
+	if _, err := Encode(make([]byte, 2954), Low); !errors.Is(err, ErrTooLong) {
+		t.Errorf("expected ErrTooLong, got %v", err)
+	}
+}
+
+func TestCode_PNG(t *testing.T) {
+	c, _ := Encode([]byte("https://example.com/r/cv"), Medium)
+	b, err := c.PNG(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if side := (c.Size + 2*QuietZone) * 3; img.Bounds().Dx() != side || img.Bounds().Dy() != side {
+		t.Errorf("expected %dpx square, got %v", side, img.Bounds())
+	}
+	dark := func(x, y int) bool { r, _, _, _ := img.At(x, y).RGBA(); return r == 0 }
+	if dark(0, 0) || !dark(QuietZone*3, QuietZone*3) || !dark(QuietZone*3+2, QuietZone*3+2) {
+		t.Error("expected the quiet zone light and the finder corner dark")
+	}
+}
+
+func TestCode_SVG(t *testing.T) {
+	c, _ := Encode([]byte("https://example.com/r/cv"), Medium)
+	svg := string(c.SVG(10))
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="330" height="330" viewBox="0 0 33 33"`) {
+		t.Errorf("unexpected SVG header: %.120s", svg)
+	}
+	if !strings.Contains(svg, "M4,4h1v1h-1z") || strings.Contains(svg, "M0,0h1") {
+		t.Error("expected the finder drawn inside the quiet zone")
+	}
+}
@@ -0,0 +1,14 @@
+package store_test
+
+import (
+	"testing"
+
+	"resume-backend/internal/store"
+	"resume-backend/internal/store/storetest"
+)
+
+func TestPostgresStore_Cancellation(t *testing.T) {
+	storetest.CheckCancellation(t, func(pool store.DatabasePool) store.DataStore {
+		return store.NewPostgresStore(pool)
+	})
+}
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// sslModes are the sslmode values libpq and pgx accept, weakest first; modes from require
+// on never fall back to an unencrypted connection.
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// configProblem is one missing or invalid database setting, with how to fix it.
+type configProblem struct {
+	Name    string
+	Problem string
+	Hint    string
+}
+
+// ConfigError lists every problem found with the database settings, so a misconfigured
+// deployment learns about all of them from one failed start.
+type ConfigError struct {
+	Problems []configProblem
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid database configuration:")
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  - %s %s: %s", p.Name, p.Problem, p.Hint)
+	}
+	return b.String()
+}
+
+// dbConfig is the validated connection settings SetupDatabase builds its DSN from.
+type dbConfig struct {
+	URL      string // DATABASE_URL; when set, the separate DB_* settings are not used
+	User     string
+	Password string
+	Host     string
+	Port     string
+	Name     string
+	SSLMode  string
+	IAMAuth  string
+
+	FailoverURLs []string // DATABASE_FAILOVER_URLS: databases to fall back to, read-only, in order
+	LogQueries   bool     // DB_LOG_QUERIES: log every query's SQL template, row count and duration
+}
+
+// loadDBConfig reads DATABASE_URL or the DB_* variables, checking all of them before
+// returning so every problem is reported together. DB_PASSWORD is only required without
+// DB_IAM_AUTH, which replaces it with short-lived tokens that must only be sent over TLS.
+func loadDBConfig() (dbConfig, error) {
+	cfg := dbConfig{
+		URL:      os.Getenv("DATABASE_URL"),
+		User:     os.Getenv("DB_USER"),
+		Password: os.Getenv("DB_PASSWORD"),
+		Host:     os.Getenv("DB_HOST"),
+		Port:     os.Getenv("DB_PORT"),
+		Name:     os.Getenv("DB_NAME"),
+		SSLMode:  os.Getenv("DB_SSLMODE"),
+		IAMAuth:  os.Getenv("DB_IAM_AUTH"),
+	}
+	var problems []configProblem
+	missing := func(name, hint string) {
+		problems = append(problems, configProblem{Name: name, Problem: "is not set", Hint: hint})
+	}
+
+	switch cfg.IAMAuth {
+	case "", "aws", "gcp":
+	default:
+		problems = append(problems, configProblem{Name: "DB_IAM_AUTH", Problem: fmt.Sprintf("is %q", cfg.IAMAuth), Hint: "must be aws or gcp, or unset to use DB_PASSWORD"})
+	}
+
+	if cfg.URL != "" {
+		// The DSN is not echoed back, as it may hold the password
+		parsed, err := pgxpool.ParseConfig(cfg.URL)
+		if err != nil {
+			problems = append(problems, configProblem{Name: "DATABASE_URL", Problem: "cannot be parsed", Hint: "must be a postgres:// URL or a key=value DSN, with special characters in the password percent-encoded"})
+		} else if cfg.IAMAuth != "" && !requiresTLS(parsed) {
+			problems = append(problems, configProblem{Name: "DATABASE_URL", Problem: "allows unencrypted connections", Hint: "set sslmode=require or stricter, as DB_IAM_AUTH tokens must not be sent in the clear"})
+		}
+	} else {
+		if cfg.User == "" {
+			missing("DB_USER", "set it to the database role to log in as, or set DATABASE_URL")
+		}
+		if cfg.Host == "" {
+			missing("DB_HOST", "set it to the database hostname or IP address, or set DATABASE_URL")
+		}
+		if cfg.Port == "" {
+			missing("DB_PORT", "set it to the database port, usually 5432, or set DATABASE_URL")
+		} else if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, configProblem{Name: "DB_PORT", Problem: fmt.Sprintf("is %q", cfg.Port), Hint: "must be a port number between 1 and 65535"})
+		}
+		if cfg.Name == "" {
+			missing("DB_NAME", "set it to the name of the database to use, or set DATABASE_URL")
+		}
+		if cfg.IAMAuth == "" && cfg.Password == "" {
+			missing("DB_PASSWORD", "set it, or DB_PASSWORD_FILE, to the role's password, or set DB_IAM_AUTH to log in with IAM tokens")
+		}
+		if cfg.SSLMode != "" {
+			mode := slices.Index(sslModes, cfg.SSLMode)
+			switch {
+			case mode < 0:
+				problems = append(problems, configProblem{Name: "DB_SSLMODE", Problem: fmt.Sprintf("is %q", cfg.SSLMode), Hint: "must be one of " + strings.Join(sslModes, ", ")})
+			case cfg.IAMAuth != "" && mode < slices.Index(sslModes, "require"):
+				problems = append(problems, configProblem{Name: "DB_SSLMODE", Problem: fmt.Sprintf("is %q", cfg.SSLMode), Hint: "must be require or stricter, as DB_IAM_AUTH tokens must not be sent in the clear"})
+			}
+		} else if cfg.IAMAuth != "" {
+			cfg.SSLMode = "require"
+		}
+	}
+
+	cfg.FailoverURLs = splitDSNList(os.Getenv("DATABASE_FAILOVER_URLS"))
+	for i, dsn := range cfg.FailoverURLs {
+		name := fmt.Sprintf("DATABASE_FAILOVER_URLS entry %d", i+1)
+		parsed, err := pgxpool.ParseConfig(dsn)
+		if err != nil || !strings.Contains(dsn, "://") {
+			problems = append(problems, configProblem{Name: name, Problem: "cannot be parsed", Hint: "must be a postgres:// URL, with special characters in the password percent-encoded"})
+		} else if cfg.IAMAuth != "" && !requiresTLS(parsed) {
+			problems = append(problems, configProblem{Name: name, Problem: "allows unencrypted connections", Hint: "set sslmode=require or stricter, as DB_IAM_AUTH tokens must not be sent in the clear"})
+		}
+	}
+
+	if v := os.Getenv("DB_LOG_QUERIES"); v != "" {
+		logQueries, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, configProblem{Name: "DB_LOG_QUERIES", Problem: fmt.Sprintf("is %q", v), Hint: "must be true or false"})
+		}
+		cfg.LogQueries = logQueries
+	}
+
+	for _, name := range []string{"DB_SLOW_QUERY_THRESHOLD", "DB_QUERY_TIMEOUT"} {
+		if _, _, err := parseQueryLimit(name); err != nil {
+			problems = append(problems, configProblem{Name: name, Problem: fmt.Sprintf("is %q", os.Getenv(name)), Hint: "must be a duration such as 2s, or 0 to turn it off"})
+		}
+	}
+
+	if len(problems) > 0 {
+		return dbConfig{}, &ConfigError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// ConnString returns DATABASE_URL as given, or a URL built from the DB_* settings with the
+// credentials escaped, so passwords may hold any character.
+func (c dbConfig) ConnString() string {
+	if c.URL != "" {
+		return c.URL
+	}
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(c.User, c.Password),
+		Host:   net.JoinHostPort(c.Host, c.Port),
+		Path:   "/" + c.Name,
+	}
+	if c.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": {c.SSLMode}}.Encode()
+	}
+	return u.String()
+}
+
+// splitDSNList splits a comma-separated list of postgres:// URLs. Commas also separate the
+// hosts of a multi-host URL, so a part that doesn't start a new URL continues the last one.
+func splitDSNList(v string) []string {
+	var dsns []string
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case strings.Contains(part, "://") || len(dsns) == 0:
+			dsns = append(dsns, part)
+		default:
+			dsns[len(dsns)-1] += "," + part
+		}
+	}
+	return dsns
+}
+
+// setsParam reports whether DATABASE_URL sets param itself, such as pool_max_conns, so
+// SetupDatabase's defaults leave it alone.
+func (c dbConfig) setsParam(param string) bool {
+	return c.URL != "" && strings.Contains(c.URL, param+"=")
+}
+
+// requiresTLS reports whether every connection attempt config makes is encrypted.
+func requiresTLS(config *pgxpool.Config) bool {
+	if config.ConnConfig.TLSConfig == nil {
+		return false
+	}
+	for _, fallback := range config.ConnConfig.Fallbacks {
+		if fallback.TLSConfig == nil {
+			return false
+		}
+	}
+	return true
+}
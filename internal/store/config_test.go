@@ -0,0 +1,122 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setDBEnv(t *testing.T, env map[string]string) {
+	for _, name := range []string{"DB_USER", "DB_PASSWORD", "DB_HOST", "DB_PORT", "DB_NAME", "DB_IAM_AUTH", "DB_SSLMODE", "DATABASE_URL", "DB_SLOW_QUERY_THRESHOLD", "DB_QUERY_TIMEOUT", "DB_LOG_QUERIES", "DATABASE_FAILOVER_URLS"} {
+		t.Setenv(name, env[name])
+	}
+}
+
+func Test_loadDBConfig(t *testing.T) {
+	valid := map[string]string{"DB_USER": "app", "DB_PASSWORD": "secret", "DB_HOST": "db", "DB_PORT": "5432", "DB_NAME": "resume"}
+
+	t.Run("valid", func(t *testing.T) {
+		setDBEnv(t, valid)
+		cfg, err := loadDBConfig()
+		require.NoError(t, err)
+		assert.Equal(t, dbConfig{User: "app", Password: "secret", Host: "db", Port: "5432", Name: "resume"}, cfg)
+	})
+
+	t.Run("IAM auth needs no password", func(t *testing.T) {
+		setDBEnv(t, map[string]string{"DB_USER": "app", "DB_HOST": "db", "DB_PORT": "5432", "DB_NAME": "resume", "DB_IAM_AUTH": "gcp"})
+		_, err := loadDBConfig()
+		assert.NoError(t, err)
+	})
+
+	t.Run("reports every problem", func(t *testing.T) {
+		setDBEnv(t, map[string]string{"DB_PORT": "postgres", "DB_IAM_AUTH": "azure", "DB_QUERY_TIMEOUT": "soon", "DB_LOG_QUERIES": "verbose"})
+		_, err := loadDBConfig()
+		var cfgErr *ConfigError
+		require.ErrorAs(t, err, &cfgErr)
+		var names []string
+		for _, p := range cfgErr.Problems {
+			names = append(names, p.Name)
+		}
+		assert.Equal(t, []string{"DB_IAM_AUTH", "DB_USER", "DB_HOST", "DB_PORT", "DB_NAME", "DB_LOG_QUERIES", "DB_QUERY_TIMEOUT"}, names)
+		assert.Contains(t, err.Error(), `DB_PORT is "postgres": must be a port number between 1 and 65535`)
+		assert.Contains(t, err.Error(), "DB_USER is not set: set it to the database role")
+	})
+}
+
+func Test_loadDBConfig_DatabaseURL(t *testing.T) {
+	t.Run("replaces the DB_ settings", func(t *testing.T) {
+		setDBEnv(t, map[string]string{"DATABASE_URL": "postgres://app:p%40ss@db:5432/resume?sslmode=verify-full&pool_max_conns=5"})
+		cfg, err := loadDBConfig()
+		require.NoError(t, err)
+		assert.Equal(t, "postgres://app:p%40ss@db:5432/resume?sslmode=verify-full&pool_max_conns=5", cfg.ConnString())
+		assert.True(t, cfg.setsParam("pool_max_conns"))
+		assert.False(t, cfg.setsParam("pool_min_conns"))
+	})
+
+	t.Run("unparseable", func(t *testing.T) {
+		setDBEnv(t, map[string]string{"DATABASE_URL": "postgres://app:pa%ss@db/resume"})
+		_, err := loadDBConfig()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "DATABASE_URL cannot be parsed")
+		assert.NotContains(t, err.Error(), "pa%ss")
+	})
+
+	t.Run("IAM auth requires TLS", func(t *testing.T) {
+		setDBEnv(t, map[string]string{"DATABASE_URL": "postgres://app@db/resume?sslmode=prefer", "DB_IAM_AUTH": "aws"})
+		_, err := loadDBConfig()
+		assert.ErrorContains(t, err, "DATABASE_URL allows unencrypted connections")
+
+		t.Setenv("DATABASE_URL", "postgres://app@db/resume?sslmode=require")
+		_, err = loadDBConfig()
+		assert.NoError(t, err)
+	})
+}
+
+func Test_loadDBConfig_FailoverURLs(t *testing.T) {
+	setDBEnv(t, map[string]string{
+		"DATABASE_URL":           "postgres://app@primary/resume?sslmode=require",
+		"DATABASE_FAILOVER_URLS": "host=replica dbname=resume",
+		"DB_IAM_AUTH":            "aws",
+	})
+	_, err := loadDBConfig()
+	assert.ErrorContains(t, err, "DATABASE_FAILOVER_URLS entry 1 cannot be parsed")
+
+	t.Setenv("DATABASE_FAILOVER_URLS", "postgres://app@replica/resume?sslmode=prefer")
+	_, err = loadDBConfig()
+	assert.ErrorContains(t, err, "DATABASE_FAILOVER_URLS entry 1 allows unencrypted connections")
+
+	t.Setenv("DATABASE_FAILOVER_URLS", "postgres://app@replica-a/resume?sslmode=require,postgres://app@replica-b/resume?sslmode=require")
+	cfg, err := loadDBConfig()
+	require.NoError(t, err)
+	assert.Len(t, cfg.FailoverURLs, 2)
+}
+
+func Test_dbConfig_ConnString(t *testing.T) {
+	cfg := dbConfig{User: "app", Password: "p@ss:w/rd?#", Host: "db", Port: "5432", Name: "resume", SSLMode: "verify-full"}
+	assert.Equal(t, "postgres://app:p%40ss%3Aw%2Frd%3F%23@db:5432/resume?sslmode=verify-full", cfg.ConnString())
+
+	// IPv6 hosts are bracketed
+	cfg = dbConfig{User: "app", Password: "secret", Host: "::1", Port: "5432", Name: "resume"}
+	assert.Equal(t, "postgres://app:secret@[::1]:5432/resume", cfg.ConnString())
+}
+
+func Test_loadDBConfig_SSLMode(t *testing.T) {
+	base := map[string]string{"DB_USER": "app", "DB_HOST": "db", "DB_PORT": "5432", "DB_NAME": "resume", "DB_PASSWORD": "secret"}
+
+	setDBEnv(t, base)
+	t.Setenv("DB_SSLMODE", "strict")
+	_, err := loadDBConfig()
+	assert.ErrorContains(t, err, "must be one of disable, allow, prefer, require, verify-ca, verify-full")
+
+	// IAM auth defaults to require and refuses weaker modes
+	t.Setenv("DB_SSLMODE", "")
+	t.Setenv("DB_IAM_AUTH", "gcp")
+	cfg, err := loadDBConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "require", cfg.SSLMode)
+
+	t.Setenv("DB_SSLMODE", "prefer")
+	_, err = loadDBConfig()
+	assert.ErrorContains(t, err, "DB_SSLMODE is \"prefer\": must be require or stricter")
+}
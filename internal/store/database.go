@@ -0,0 +1,1912 @@
+// Package store persists visits, events and the aggregates the API serves, behind the
+// DataStore interface.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DatabasePool interface includes methods needed for the database operations
+type DatabasePool interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) // Use pgx.CommandTag for Exec
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+	Close()
+}
+
+// DataStore interface for data operations
+type DataStore interface {
+	IncrementVisitCount(ctx context.Context, visit Visit) error
+	IncrementVisitCounts(ctx context.Context, visits []Visit) error
+	GetVisitCount(ctx context.Context) (int, error)
+	GetVisitCountBetween(ctx context.Context, since, until time.Time) (int, error)
+	GetVisitCountsInRanges(ctx context.Context, ranges []TimeRange) ([]int, error)
+	GetPageCounts(ctx context.Context, pages []string) (map[string]int, error)
+	GetDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error)
+	GetSummary(ctx context.Context, from, to time.Time, loc *time.Location) (Summary, error)
+	GetVisitHeatmap(ctx context.Context, from, to time.Time, loc *time.Location) ([]HeatmapCell, error)
+	RecordUniqueVisitor(ctx context.Context, day time.Time, visitorHash string) error
+	GetUniqueVisitorCount(ctx context.Context, from, to time.Time) (int, error)
+	SaveVisitorSketch(ctx context.Context, day time.Time, sketch []byte) error
+	GetVisitorSketches(ctx context.Context, from, to time.Time) ([][]byte, error)
+	GetTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error)
+	GetCampaignVisits(ctx context.Context, from, to time.Time, limit int) ([]CampaignCount, error)
+	RecordExposure(ctx context.Context, exposure Exposure) error
+	GetExperimentResults(ctx context.Context, experiment string, from, to time.Time) ([]VariantResult, error)
+	StartSession(ctx context.Context, id string, now time.Time) error
+	TouchSession(ctx context.Context, id string, now, idleSince time.Time) (bool, error)
+	CountActiveSessions(ctx context.Context, since time.Time) (int, error)
+	AggregateSessions(ctx context.Context, since, closedBefore time.Time) error
+	GetSessionStats(ctx context.Context, from, to time.Time) ([]SessionDay, error)
+	RecordEvent(ctx context.Context, event Event) error
+	GetEventStats(ctx context.Context, query EventStatsQuery) ([]EventCount, error)
+	GetFunnel(ctx context.Context, from, to time.Time, steps []string) ([]int, error)
+	GetHourlyVisits(ctx context.Context, from, to time.Time) ([]HourlyCount, error)
+	RecordAnomaly(ctx context.Context, anomaly Anomaly) (bool, error)
+	GetAnomalies(ctx context.Context, from, to time.Time) ([]Anomaly, error)
+	GetVisitsAfter(ctx context.Context, afterID int64, before time.Time, limit int) ([]VisitRow, error)
+	StreamVisits(ctx context.Context, afterID int64, before time.Time, limit int, fn func(VisitRow) error) error
+	GetExportMark(ctx context.Context, sink string) (int64, error)
+	SetExportMark(ctx context.Context, sink string, lastID int64) error
+	IncrementVisitCountsWithOutbox(ctx context.Context, visits []Visit, messages []OutboxMessage) error
+	EnqueueOutbox(ctx context.Context, messages []OutboxMessage) error
+	ClaimOutbox(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxMessage, error)
+	CompleteOutbox(ctx context.Context, id int64) error
+	FailOutbox(ctx context.Context, id int64, retryAt *time.Time, lastError string) error
+	GetFailedOutbox(ctx context.Context, limit int) ([]OutboxMessage, error)
+	RetryOutbox(ctx context.Context, id int64) (bool, error)
+	RunMaintenance(ctx context.Context, action MaintenanceAction, progress func(step string, elapsed time.Duration)) error
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+	ClaimDedupeKey(ctx context.Context, key string, window time.Duration) (bool, error)
+	DeleteExpiredDedupeKeys(ctx context.Context) (int, error)
+	EnsureVisitPartitions(ctx context.Context, now time.Time, ahead int) error
+	DropVisitPartitions(ctx context.Context, before time.Time) ([]string, error)
+	GetVisitorData(ctx context.Context, ids VisitorIDs) (VisitorData, error)
+	DeleteVisitorData(ctx context.Context, ids VisitorIDs) (int, error)
+	RefreshSummaries(ctx context.Context) error
+	ListProjects(ctx context.Context) ([]Project, error)
+	GetProject(ctx context.Context, id int64) (Project, bool, error)
+	CreateProject(ctx context.Context, p Project) (Project, error)
+	UpdateProject(ctx context.Context, p Project) (Project, bool, error)
+	DeleteProject(ctx context.Context, id int64) (bool, error)
+	GetPublishedResume(ctx context.Context) (ResumeVersion, bool, error)
+	GetResumeDraft(ctx context.Context) (ResumeVersion, bool, error)
+	SaveResumeDraft(ctx context.Context, content json.RawMessage) (ResumeVersion, error)
+	PublishResumeVersion(ctx context.Context, id int64) (ResumeVersion, bool, error)
+	ListResumeVersions(ctx context.Context, limit int) ([]ResumeVersion, error)
+	ListPosts(ctx context.Context, publishedBy *time.Time, limit, offset int) ([]Post, error)
+	GetPost(ctx context.Context, slug string) (Post, bool, error)
+	SavePost(ctx context.Context, p Post) (Post, bool, error)
+	DeletePost(ctx context.Context, slug string) (bool, error)
+	ListShortLinks(ctx context.Context) ([]ShortLink, error)
+	GetShortLink(ctx context.Context, slug string) (ShortLink, bool, error)
+	SaveShortLink(ctx context.Context, l ShortLink) (ShortLink, bool, error)
+	DeleteShortLink(ctx context.Context, slug string) (bool, error)
+	RecordUptimeCheck(ctx context.Context, check UptimeCheck) error
+	GetUptimeChecks(ctx context.Context, from, to time.Time) ([]UptimeCheck, error)
+	SaveCertificateCheck(ctx context.Context, check CertificateCheck) error
+	GetCertificateChecks(ctx context.Context) ([]CertificateCheck, error)
+	RecordDeploy(ctx context.Context, deploy Deploy) (Deploy, error)
+	GetDeploys(ctx context.Context, from, to time.Time) ([]Deploy, error)
+	GetSummarizedDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error)
+	GetSummarizedTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error)
+	Ping(ctx context.Context) error
+	Close()
+}
+
+// Visit is a single recorded visit
+type Visit struct {
+	Timestamp time.Time
+	Page      string // the page visited, such as "blog"; empty when the frontend doesn't say
+	Referrer  string // normalized referring domain, empty for direct visits
+	UTM       UTM
+}
+
+// VisitRow is a stored visit with its row ID, as shipped by the exporter
+type VisitRow struct {
+	ID int64
+	Visit
+}
+
+// OutboxMessage is a notification waiting to be delivered to a destination, such as a
+// webhook or a stream, after the visit it describes was recorded
+type OutboxMessage struct {
+	ID          int64
+	Destination string          // the name of the processor that delivers it
+	Payload     json.RawMessage // what the processor delivers
+	Attempts    int             // delivery attempts so far, including the one in progress once claimed
+	LastError   string          // why the previous attempt failed, if it did
+	CreatedAt   time.Time       // when it was queued; only set by GetFailedOutbox
+}
+
+// UTM holds the campaign parameters of the link a visitor arrived through
+type UTM struct {
+	Source   string `json:"utm_source"`
+	Medium   string `json:"utm_medium"`
+	Campaign string `json:"utm_campaign"`
+}
+
+// CampaignCount is the number of visits from one combination of UTM parameters
+type CampaignCount struct {
+	UTM
+	Visits int `json:"visits"`
+}
+
+// ReferrerCount is the number of visits from one referring domain
+type ReferrerCount struct {
+	Domain string `json:"domain"`
+	Visits int    `json:"visits"`
+}
+
+// Exposure records that a visitor was shown an experiment variant on a UTC day
+type Exposure struct {
+	Experiment  string
+	Variant     string
+	Day         time.Time
+	VisitorHash string // the visitor's daily hash, as recorded for unique visitors
+}
+
+// VariantResult counts the visitor-days exposed to a variant and how many of them also visited
+type VariantResult struct {
+	Variant     string `json:"variant"`
+	Exposures   int    `json:"exposures"`
+	Conversions int    `json:"conversions"`
+}
+
+// SessionDay totals the finished sessions that started on one UTC day
+type SessionDay struct {
+	Date         time.Time
+	Sessions     int
+	TotalSeconds float64
+}
+
+// Event is a typed interaction, such as "downloaded_resume", with free-form JSON properties
+type Event struct {
+	Type       string
+	Timestamp  time.Time
+	SessionID  string          // optional, from the session heartbeat
+	Properties json.RawMessage // a JSON object; empty means no properties
+}
+
+// EventStatsQuery selects events in [From, To). With Type set only that type is counted,
+// and with Property set as well the counts are grouped by that property's value.
+type EventStatsQuery struct {
+	From, To time.Time
+	Type     string
+	Property string
+}
+
+// EventCount is the number of events of one type, or with one property value
+type EventCount struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+	Count int    `json:"count"`
+}
+
+// HourlyCount is the number of visits in the UTC hour starting at Hour
+type HourlyCount struct {
+	Hour   time.Time
+	Visits int
+}
+
+// Anomaly is an hour whose visits fell outside the rolling baseline
+type Anomaly struct {
+	Hour   time.Time `json:"hour"`
+	Kind   string    `json:"kind"` // "spike" or "drop"
+	Visits int       `json:"visits"`
+	Mean   float64   `json:"mean"`
+	StdDev float64   `json:"stddev"`
+}
+
+// DailyCount is the number of visits on one calendar day
+type DailyCount struct {
+	Date   time.Time `json:"-"`
+	Visits int       `json:"visits"`
+}
+
+// HeatmapCell is the number of visits in one hour of the day on one day of the week
+type HeatmapCell struct {
+	Weekday time.Weekday
+	Hour    int
+	Visits  int
+}
+
+// Summary is the headline numbers for a window of calendar days, read together so they agree
+type Summary struct {
+	Visits         int          // all visits ever recorded
+	UniqueVisitors int          // daily unique visitors summed over the UTC days of the window
+	Daily          []DailyCount // visits per day in the window; days without visits are omitted
+}
+
+// TimeRange is the half-open interval [From, To)
+type TimeRange struct {
+	From, To time.Time
+}
+
+// VisitorIDs identifies the rows held about one visitor: their daily hashes, which only they
+// can reproduce from their IP and User-Agent, and the session IDs their browser holds
+type VisitorIDs struct {
+	Hashes     []VisitorHash
+	SessionIDs []string
+}
+
+// VisitorHash is a visitor's hash for one UTC day
+type VisitorHash struct {
+	Day  time.Time
+	Hash string
+}
+
+// Session is a stored heartbeat session
+type Session struct {
+	ID         string
+	StartedAt  time.Time
+	LastSeen   time.Time
+	Heartbeats int
+}
+
+// VisitorData is everything stored about one visitor
+type VisitorData struct {
+	UniqueDays []VisitorHash // days the visitor was counted as unique
+	Exposures  []Exposure
+	Sessions   []Session
+	Events     []Event
+}
+
+// PostgresStore implements DataStore
+type PostgresStore struct {
+	pool DatabasePool
+	keys *keyRing // encrypts visitor hashes at rest; nil stores them in the clear
+}
+
+// NewPostgresStore returns a store backed by pool. SetupDatabase is the usual way to get one;
+// this is for callers that manage the pool themselves.
+func NewPostgresStore(pool DatabasePool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+// IncrementVisitCount increments the visit count in the database, storing the timestamp in UTC
+func (s *PostgresStore) IncrementVisitCount(ctx context.Context, visit Visit) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO visits (timestamp, page, referrer, utm_source, utm_medium, utm_campaign)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		visit.Timestamp.UTC(), nullIfEmpty(visit.Page), nullIfEmpty(visit.Referrer),
+		nullIfEmpty(visit.UTM.Source), nullIfEmpty(visit.UTM.Medium), nullIfEmpty(visit.UTM.Campaign))
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error incrementing visit count: %v", err)
+		return fmt.Errorf("failed to increment visit count: %w", err)
+	}
+	return nil
+}
+
+// IncrementVisitCounts records several visits in one statement, so either all of them are
+// stored or none are
+func (s *PostgresStore) IncrementVisitCounts(ctx context.Context, visits []Visit) error {
+	if len(visits) == 0 {
+		return nil
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO visits (timestamp, page, referrer, utm_source, utm_medium, utm_campaign)
+		SELECT * FROM unnest($1::timestamptz[], $2::text[], $3::text[], $4::text[], $5::text[], $6::text[])`,
+		visitArrays(visits)...)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error incrementing visit counts: %v", err)
+		return fmt.Errorf("failed to increment visit counts: %w", err)
+	}
+	return nil
+}
+
+// IncrementVisitCountsWithOutbox records the visits and queues the messages in one statement,
+// so either both are stored or neither is and no notification is lost to a crash in between
+func (s *PostgresStore) IncrementVisitCountsWithOutbox(ctx context.Context, visits []Visit, messages []OutboxMessage) error {
+	if len(messages) == 0 {
+		return s.IncrementVisitCounts(ctx, visits)
+	}
+	destinations, payloads := outboxArrays(messages)
+	_, err := s.pool.Exec(ctx, `
+		WITH v AS (
+			INSERT INTO visits (timestamp, page, referrer, utm_source, utm_medium, utm_campaign)
+			SELECT * FROM unnest($1::timestamptz[], $2::text[], $3::text[], $4::text[], $5::text[], $6::text[])
+		)
+		INSERT INTO outbox (destination, payload)
+		SELECT d, p::jsonb FROM unnest($7::text[], $8::text[]) AS o(d, p)`,
+		append(visitArrays(visits), destinations, payloads)...)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error incrementing visit counts with outbox: %v", err)
+		return fmt.Errorf("failed to increment visit counts with outbox: %w", err)
+	}
+	return nil
+}
+
+// EnqueueOutbox queues messages for the dispatcher on their own, for work not tied to a visit
+func (s *PostgresStore) EnqueueOutbox(ctx context.Context, messages []OutboxMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	destinations, payloads := outboxArrays(messages)
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO outbox (destination, payload)
+		SELECT d, p::jsonb FROM unnest($1::text[], $2::text[]) AS o(d, p)`,
+		destinations, payloads)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error enqueueing outbox messages: %v", err)
+		return fmt.Errorf("failed to enqueue outbox messages: %w", err)
+	}
+	return nil
+}
+
+// outboxArrays splits messages into the destination and payload arrays inserted with unnest
+func outboxArrays(messages []OutboxMessage) ([]string, []string) {
+	destinations := make([]string, len(messages))
+	payloads := make([]string, len(messages))
+	for i, m := range messages {
+		destinations[i], payloads[i] = m.Destination, string(m.Payload)
+	}
+	return destinations, payloads
+}
+
+// CopyVisits bulk loads visits with COPY, which is much faster than inserting them for large
+// imports. The visits are loaded all or nothing; it returns how many were.
+func (s *PostgresStore) CopyVisits(ctx context.Context, visits []Visit) (int, error) {
+	rows := make([][]interface{}, len(visits))
+	for i, v := range visits {
+		rows[i] = []interface{}{v.Timestamp.UTC(), nullIfEmpty(v.Page), nullIfEmpty(v.Referrer),
+			nullIfEmpty(v.UTM.Source), nullIfEmpty(v.UTM.Medium), nullIfEmpty(v.UTM.Campaign)}
+	}
+	n, err := s.pool.CopyFrom(ctx, pgx.Identifier{"visits"},
+		[]string{"timestamp", "page", "referrer", "utm_source", "utm_medium", "utm_campaign"}, pgx.CopyFromRows(rows))
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error copying visits: %v", err)
+		return 0, fmt.Errorf("failed to copy visits: %w", err)
+	}
+	return int(n), nil
+}
+
+// visitArrays splits visits into the parallel column arrays inserted with unnest
+func visitArrays(visits []Visit) []interface{} {
+	timestamps := make([]time.Time, len(visits))
+	pages := make([]*string, len(visits))
+	referrers := make([]*string, len(visits))
+	sources := make([]*string, len(visits))
+	mediums := make([]*string, len(visits))
+	campaigns := make([]*string, len(visits))
+	for i, v := range visits {
+		timestamps[i] = v.Timestamp.UTC()
+		pages[i], referrers[i] = nullIfEmpty(v.Page), nullIfEmpty(v.Referrer)
+		sources[i], mediums[i], campaigns[i] = nullIfEmpty(v.UTM.Source), nullIfEmpty(v.UTM.Medium), nullIfEmpty(v.UTM.Campaign)
+	}
+	return []interface{}{timestamps, pages, referrers, sources, mediums, campaigns}
+}
+
+// GetVisitCount retrieves the visit count from the database
+func (s *PostgresStore) GetVisitCount(ctx context.Context) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx, "SELECT COUNT(*) FROM visits").Scan(&count)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visit count: %v", err)
+		return 0, fmt.Errorf("failed to get visit count: %w", err)
+	}
+	return count, nil
+}
+
+// GetVisitCountBetween counts the visits after since up to and including until, so
+// consecutive windows that share a bound count every visit once.
+func (s *PostgresStore) GetVisitCountBetween(ctx context.Context, since, until time.Time) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM visits WHERE timestamp > $1 AND timestamp <= $2", since, until).Scan(&count)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visit count in range: %v", err)
+		return 0, fmt.Errorf("failed to get visit count in range: %w", err)
+	}
+	return count, nil
+}
+
+// GetVisitCountsInRanges counts the visits in each of ranges, in order, in one query so the
+// counts are read from the same snapshot and can be compared.
+func (s *PostgresStore) GetVisitCountsInRanges(ctx context.Context, ranges []TimeRange) ([]int, error) {
+	from := make([]time.Time, len(ranges))
+	to := make([]time.Time, len(ranges))
+	for i, r := range ranges {
+		from[i], to[i] = r.From.UTC(), r.To.UTC()
+	}
+
+	query := `
+		SELECT (SELECT COUNT(*) FROM visits WHERE timestamp >= r.from_ts AND timestamp < r.to_ts)
+		FROM unnest($1::timestamptz[], $2::timestamptz[]) WITH ORDINALITY AS r(from_ts, to_ts, n)
+		ORDER BY r.n`
+	rows, err := s.pool.Query(ctx, query, from, to)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visit counts in ranges: %v", err)
+		return nil, fmt.Errorf("failed to get visit counts in ranges: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make([]int, 0, len(ranges))
+	for rows.Next() {
+		var count int
+		if err := rows.Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to scan visit counts in ranges: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visit counts in ranges: %w", err)
+	}
+	return counts, nil
+}
+
+// GetPageCounts counts the visits to each of pages in one query. Pages without visits are
+// omitted.
+func (s *PostgresStore) GetPageCounts(ctx context.Context, pages []string) (map[string]int, error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT page, COUNT(*) FROM visits WHERE page = ANY($1) GROUP BY page", pages)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting page counts: %v", err)
+		return nil, fmt.Errorf("failed to get page counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var page string
+		var count int
+		if err := rows.Scan(&page, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan page counts: %w", err)
+		}
+		counts[page] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read page counts: %w", err)
+	}
+	return counts, nil
+}
+
+// GetDailyVisits counts visits per calendar day in loc for visits in [from, to).
+// Days without visits are omitted.
+func (s *PostgresStore) GetDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT (visits.timestamp AT TIME ZONE $1)::date AS day, COUNT(*)
+		FROM visits
+		WHERE visits.timestamp >= $2 AND visits.timestamp < $3
+		GROUP BY day
+		ORDER BY day`, loc.String(), from.UTC(), to.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting daily visits: %v", err)
+		return nil, fmt.Errorf("failed to get daily visits: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []DailyCount
+	for rows.Next() {
+		var c DailyCount
+		if err := rows.Scan(&c.Date, &c.Visits); err != nil {
+			return nil, fmt.Errorf("failed to scan daily visits: %w", err)
+		}
+		// DATE values come back as midnight UTC; rebase them onto the requested zone
+		c.Date = time.Date(c.Date.Year(), c.Date.Month(), c.Date.Day(), 0, 0, 0, 0, loc)
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read daily visits: %w", err)
+	}
+	return counts, nil
+}
+
+// GetVisitHeatmap counts visits in [from, to) per day of the week and hour of the day, both
+// on the clock in loc. Cells without visits are omitted.
+func (s *PostgresStore) GetVisitHeatmap(ctx context.Context, from, to time.Time, loc *time.Location) ([]HeatmapCell, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT EXTRACT(DOW FROM visits.timestamp AT TIME ZONE $1)::int AS weekday,
+			EXTRACT(HOUR FROM visits.timestamp AT TIME ZONE $1)::int AS hour,
+			COUNT(*)
+		FROM visits
+		WHERE visits.timestamp >= $2 AND visits.timestamp < $3
+		GROUP BY weekday, hour
+		ORDER BY weekday, hour`, loc.String(), from.UTC(), to.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visit heatmap: %v", err)
+		return nil, fmt.Errorf("failed to get visit heatmap: %w", err)
+	}
+	defer rows.Close()
+
+	var cells []HeatmapCell
+	for rows.Next() {
+		var c HeatmapCell
+		var weekday int
+		if err := rows.Scan(&weekday, &c.Hour, &c.Visits); err != nil {
+			return nil, fmt.Errorf("failed to scan visit heatmap: %w", err)
+		}
+		// DOW counts from Sunday, like time.Weekday
+		c.Weekday = time.Weekday(weekday)
+		cells = append(cells, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visit heatmap: %w", err)
+	}
+	return cells, nil
+}
+
+// GetSummary reads the total visit count, the unique visitors of the UTC days from through
+// to, and the visits per calendar day in loc for visits in [from, to). It is one statement,
+// so the numbers come from the same snapshot of the database: a visit recorded meanwhile is
+// in all of them or none.
+func (s *PostgresStore) GetSummary(ctx context.Context, from, to time.Time, loc *time.Location) (Summary, error) {
+	var (
+		summary Summary
+		days    []time.Time
+		visits  []int64
+	)
+	err := s.pool.QueryRow(ctx, `
+		WITH daily AS (
+			SELECT (timestamp AT TIME ZONE $1)::date AS day, COUNT(*) AS visits
+			FROM visits
+			WHERE timestamp >= $2 AND timestamp < $3
+			GROUP BY day
+		)
+		SELECT
+			(SELECT COUNT(*) FROM visits),
+			(SELECT COUNT(*) FROM unique_visitors WHERE day >= $4 AND day <= $5),
+			ARRAY(SELECT day FROM daily ORDER BY day),
+			ARRAY(SELECT visits FROM daily ORDER BY day)`,
+		loc.String(), from.UTC(), to.UTC(),
+		from.UTC().Format(time.DateOnly), to.Add(-time.Nanosecond).UTC().Format(time.DateOnly),
+	).Scan(&summary.Visits, &summary.UniqueVisitors, &days, &visits)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting summary: %v", err)
+		return Summary{}, fmt.Errorf("failed to get summary: %w", err)
+	}
+	for i, day := range days {
+		// DATE values come back as midnight UTC; rebase them onto the requested zone
+		date := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+		summary.Daily = append(summary.Daily, DailyCount{Date: date, Visits: int(visits[i])})
+	}
+	return summary, nil
+}
+
+// RecordUniqueVisitor stores a visitor hash for the UTC day, ignoring repeats
+func (s *PostgresStore) RecordUniqueVisitor(ctx context.Context, day time.Time, visitorHash string) error {
+	_, err := s.pool.Exec(ctx,
+		"INSERT INTO unique_visitors (day, visitor_hash) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		day.UTC().Format(time.DateOnly), s.keys.sealHash(visitorHash))
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error recording unique visitor: %v", err)
+		return fmt.Errorf("failed to record unique visitor: %w", err)
+	}
+	return nil
+}
+
+// GetUniqueVisitorCount sums the daily unique visitors for the UTC days from through to, inclusive
+func (s *PostgresStore) GetUniqueVisitorCount(ctx context.Context, from, to time.Time) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM unique_visitors WHERE day >= $1 AND day <= $2",
+		from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly)).Scan(&count)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting unique visitor count: %v", err)
+		return 0, fmt.Errorf("failed to get unique visitor count: %w", err)
+	}
+	return count, nil
+}
+
+// SaveVisitorSketch stores the serialized HyperLogLog sketch for the UTC day, replacing any previous one
+func (s *PostgresStore) SaveVisitorSketch(ctx context.Context, day time.Time, sketch []byte) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO visitor_sketches (day, sketch) VALUES ($1, $2)
+		ON CONFLICT (day) DO UPDATE SET sketch = EXCLUDED.sketch`,
+		day.UTC().Format(time.DateOnly), sketch)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error saving visitor sketch: %v", err)
+		return fmt.Errorf("failed to save visitor sketch: %w", err)
+	}
+	return nil
+}
+
+// GetVisitorSketches returns the stored sketches for the UTC days from through to, inclusive
+func (s *PostgresStore) GetVisitorSketches(ctx context.Context, from, to time.Time) ([][]byte, error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT sketch FROM visitor_sketches WHERE day >= $1 AND day <= $2 ORDER BY day",
+		from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly))
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visitor sketches: %v", err)
+		return nil, fmt.Errorf("failed to get visitor sketches: %w", err)
+	}
+	defer rows.Close()
+
+	var sketches [][]byte
+	for rows.Next() {
+		var sketch []byte
+		if err := rows.Scan(&sketch); err != nil {
+			return nil, fmt.Errorf("failed to scan visitor sketch: %w", err)
+		}
+		sketches = append(sketches, sketch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visitor sketches: %w", err)
+	}
+	return sketches, nil
+}
+
+// GetTopReferrers returns the limit referring domains with the most visits in [from, to), busiest first
+func (s *PostgresStore) GetTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT referrer, COUNT(*) AS visits
+		FROM visits
+		WHERE referrer IS NOT NULL AND visits.timestamp >= $1 AND visits.timestamp < $2
+		GROUP BY referrer
+		ORDER BY visits DESC, referrer
+		LIMIT $3`, from.UTC(), to.UTC(), limit)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting top referrers: %v", err)
+		return nil, fmt.Errorf("failed to get top referrers: %w", err)
+	}
+	defer rows.Close()
+
+	var referrers []ReferrerCount
+	for rows.Next() {
+		var c ReferrerCount
+		if err := rows.Scan(&c.Domain, &c.Visits); err != nil {
+			return nil, fmt.Errorf("failed to scan top referrers: %w", err)
+		}
+		referrers = append(referrers, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read top referrers: %w", err)
+	}
+	return referrers, nil
+}
+
+// GetCampaignVisits returns the limit UTM combinations with the most visits in [from, to), busiest first.
+// Visits without any UTM parameters are left out.
+func (s *PostgresStore) GetCampaignVisits(ctx context.Context, from, to time.Time, limit int) ([]CampaignCount, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT COALESCE(utm_source, ''), COALESCE(utm_medium, ''), COALESCE(utm_campaign, ''), COUNT(*) AS visits
+		FROM visits
+		WHERE (utm_source IS NOT NULL OR utm_medium IS NOT NULL OR utm_campaign IS NOT NULL)
+			AND visits.timestamp >= $1 AND visits.timestamp < $2
+		GROUP BY 1, 2, 3
+		ORDER BY visits DESC, 3, 1, 2
+		LIMIT $3`, from.UTC(), to.UTC(), limit)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting campaign visits: %v", err)
+		return nil, fmt.Errorf("failed to get campaign visits: %w", err)
+	}
+	defer rows.Close()
+
+	var campaigns []CampaignCount
+	for rows.Next() {
+		var c CampaignCount
+		if err := rows.Scan(&c.Source, &c.Medium, &c.Campaign, &c.Visits); err != nil {
+			return nil, fmt.Errorf("failed to scan campaign visits: %w", err)
+		}
+		campaigns = append(campaigns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read campaign visits: %w", err)
+	}
+	return campaigns, nil
+}
+
+// RecordExposure stores an experiment exposure, ignoring repeats by the same visitor on the same day
+func (s *PostgresStore) RecordExposure(ctx context.Context, exposure Exposure) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO experiment_exposures (experiment, day, visitor_hash, variant) VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`,
+		exposure.Experiment, exposure.Day.UTC().Format(time.DateOnly), s.keys.sealHash(exposure.VisitorHash), exposure.Variant)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error recording experiment exposure: %v", err)
+		return fmt.Errorf("failed to record experiment exposure: %w", err)
+	}
+	return nil
+}
+
+// GetExperimentResults counts exposures per variant for the UTC days from through to, inclusive.
+// An exposure converts when the same visitor hash recorded a visit that day.
+func (s *PostgresStore) GetExperimentResults(ctx context.Context, experiment string, from, to time.Time) ([]VariantResult, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT e.variant, COUNT(*), COUNT(u.visitor_hash)
+		FROM experiment_exposures e
+		LEFT JOIN unique_visitors u ON u.day = e.day AND u.visitor_hash = e.visitor_hash
+		WHERE e.experiment = $1 AND e.day >= $2 AND e.day <= $3
+		GROUP BY e.variant
+		ORDER BY e.variant`,
+		experiment, from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly))
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting experiment results: %v", err)
+		return nil, fmt.Errorf("failed to get experiment results: %w", err)
+	}
+	defer rows.Close()
+
+	var results []VariantResult
+	for rows.Next() {
+		var r VariantResult
+		if err := rows.Scan(&r.Variant, &r.Exposures, &r.Conversions); err != nil {
+			return nil, fmt.Errorf("failed to scan experiment results: %w", err)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read experiment results: %w", err)
+	}
+	return results, nil
+}
+
+// StartSession stores a new session whose first heartbeat is now
+func (s *PostgresStore) StartSession(ctx context.Context, id string, now time.Time) error {
+	_, err := s.pool.Exec(ctx,
+		"INSERT INTO sessions (id, started_at, last_seen, heartbeats) VALUES ($1, $2, $2, 1)",
+		id, now.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error starting session: %v", err)
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	return nil
+}
+
+// TouchSession extends the session to now if its last heartbeat was at or after idleSince.
+// It reports false when the session doesn't exist or has already gone idle.
+func (s *PostgresStore) TouchSession(ctx context.Context, id string, now, idleSince time.Time) (bool, error) {
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE sessions SET last_seen = $2, heartbeats = heartbeats + 1 WHERE id = $1 AND last_seen >= $3",
+		id, now.UTC(), idleSince.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error touching session: %v", err)
+		return false, fmt.Errorf("failed to touch session: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// CountActiveSessions returns the number of sessions with a heartbeat at or after since.
+func (s *PostgresStore) CountActiveSessions(ctx context.Context, since time.Time) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx, "SELECT COUNT(*) FROM sessions WHERE last_seen >= $1", since.UTC()).Scan(&count)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error counting active sessions: %v", err)
+		return 0, fmt.Errorf("failed to count active sessions: %w", err)
+	}
+	return count, nil
+}
+
+// AggregateSessions recomputes the daily rollup for sessions started at or after since that
+// were last seen before closedBefore. Rerunning it is harmless, so replicas needn't coordinate.
+func (s *PostgresStore) AggregateSessions(ctx context.Context, since, closedBefore time.Time) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO session_daily (day, sessions, total_seconds)
+		SELECT (started_at AT TIME ZONE 'UTC')::date, COUNT(*), SUM(EXTRACT(EPOCH FROM last_seen - started_at))
+		FROM sessions
+		WHERE started_at >= $1 AND last_seen < $2
+		GROUP BY 1
+		ON CONFLICT (day) DO UPDATE SET sessions = EXCLUDED.sessions, total_seconds = EXCLUDED.total_seconds`,
+		since.UTC(), closedBefore.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error aggregating sessions: %v", err)
+		return fmt.Errorf("failed to aggregate sessions: %w", err)
+	}
+	return nil
+}
+
+// GetSessionStats returns the session rollup for the UTC days from through to, inclusive.
+// Days without finished sessions are omitted.
+func (s *PostgresStore) GetSessionStats(ctx context.Context, from, to time.Time) ([]SessionDay, error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT day, sessions, total_seconds FROM session_daily WHERE day >= $1 AND day <= $2 ORDER BY day",
+		from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly))
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting session stats: %v", err)
+		return nil, fmt.Errorf("failed to get session stats: %w", err)
+	}
+	defer rows.Close()
+
+	var days []SessionDay
+	for rows.Next() {
+		var d SessionDay
+		if err := rows.Scan(&d.Date, &d.Sessions, &d.TotalSeconds); err != nil {
+			return nil, fmt.Errorf("failed to scan session stats: %w", err)
+		}
+		days = append(days, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read session stats: %w", err)
+	}
+	return days, nil
+}
+
+// RecordEvent stores an event, with its timestamp in UTC
+func (s *PostgresStore) RecordEvent(ctx context.Context, event Event) error {
+	properties := "{}"
+	if len(event.Properties) > 0 {
+		properties = string(event.Properties)
+	}
+	_, err := s.pool.Exec(ctx,
+		"INSERT INTO events (type, occurred_at, session_id, properties) VALUES ($1, $2, $3, $4::jsonb)",
+		event.Type, event.Timestamp.UTC(), nullIfEmpty(event.SessionID), properties)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error recording event: %v", err)
+		return fmt.Errorf("failed to record event: %w", err)
+	}
+	return nil
+}
+
+// GetEventStats counts events per type, or per property value of one type, busiest first
+func (s *PostgresStore) GetEventStats(ctx context.Context, query EventStatsQuery) ([]EventCount, error) {
+	var (
+		rows pgx.Rows
+		err  error
+	)
+	switch {
+	case query.Property != "":
+		rows, err = s.pool.Query(ctx, `
+			SELECT type, COALESCE(properties->>$4, ''), COUNT(*) AS count
+			FROM events
+			WHERE occurred_at >= $1 AND occurred_at < $2 AND type = $3
+			GROUP BY 1, 2
+			ORDER BY count DESC, 2`, query.From.UTC(), query.To.UTC(), query.Type, query.Property)
+	case query.Type != "":
+		rows, err = s.pool.Query(ctx, `
+			SELECT type, '', COUNT(*) AS count
+			FROM events
+			WHERE occurred_at >= $1 AND occurred_at < $2 AND type = $3
+			GROUP BY 1`, query.From.UTC(), query.To.UTC(), query.Type)
+	default:
+		rows, err = s.pool.Query(ctx, `
+			SELECT type, '', COUNT(*) AS count
+			FROM events
+			WHERE occurred_at >= $1 AND occurred_at < $2
+			GROUP BY 1
+			ORDER BY count DESC, 1`, query.From.UTC(), query.To.UTC())
+	}
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting event stats: %v", err)
+		return nil, fmt.Errorf("failed to get event stats: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []EventCount
+	for rows.Next() {
+		var c EventCount
+		if err := rows.Scan(&c.Type, &c.Value, &c.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan event stats: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read event stats: %w", err)
+	}
+	return counts, nil
+}
+
+// GetFunnel counts the sessions reaching each step, where a step is reached by its earliest
+// event at or after the previous step within the same session, for events in [from, to).
+// Ordered matching can't be expressed with window functions alone, so each step is a CTE
+// that joins the sessions from the step before.
+func (s *PostgresStore) GetFunnel(ctx context.Context, from, to time.Time, steps []string) ([]int, error) {
+	args := []interface{}{from.UTC(), to.UTC()}
+	for _, step := range steps {
+		args = append(args, step)
+	}
+
+	counts := make([]int, len(steps))
+	dest := make([]interface{}, len(steps))
+	for i := range counts {
+		dest[i] = &counts[i]
+	}
+	if err := s.pool.QueryRow(ctx, funnelQuery(len(steps)), args...).Scan(dest...); err != nil {
+		logging.FromContext(ctx).Printf("Error getting funnel: %v", err)
+		return nil, fmt.Errorf("failed to get funnel: %w", err)
+	}
+	return counts, nil
+}
+
+// funnelQuery builds the funnel query for n steps, bound to $3 through $n+2.
+func funnelQuery(n int) string {
+	var b strings.Builder
+	b.WriteString(`
+		WITH e AS (
+			SELECT session_id, type, occurred_at FROM events
+			WHERE session_id IS NOT NULL AND occurred_at >= $1 AND occurred_at < $2
+		),
+		s1 AS (SELECT session_id, MIN(occurred_at) AS t FROM e WHERE type = $3 GROUP BY session_id)`)
+	for i := 2; i <= n; i++ {
+		fmt.Fprintf(&b, `,
+		s%d AS (
+			SELECT p.session_id, MIN(e.occurred_at) AS t
+			FROM s%d p JOIN e ON e.session_id = p.session_id AND e.type = $%d AND e.occurred_at >= p.t
+			GROUP BY p.session_id
+		)`, i, i-1, i+2)
+	}
+	b.WriteString("\n\t\tSELECT ")
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "(SELECT COUNT(*) FROM s%d)", i)
+	}
+	return b.String()
+}
+
+// GetHourlyVisits counts visits per UTC hour in [from, to). Hours without visits are omitted.
+func (s *PostgresStore) GetHourlyVisits(ctx context.Context, from, to time.Time) ([]HourlyCount, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT date_trunc('hour', visits.timestamp AT TIME ZONE 'UTC') AS hour, COUNT(*)
+		FROM visits
+		WHERE visits.timestamp >= $1 AND visits.timestamp < $2
+		GROUP BY hour
+		ORDER BY hour`, from.UTC(), to.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting hourly visits: %v", err)
+		return nil, fmt.Errorf("failed to get hourly visits: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []HourlyCount
+	for rows.Next() {
+		var c HourlyCount
+		if err := rows.Scan(&c.Hour, &c.Visits); err != nil {
+			return nil, fmt.Errorf("failed to scan hourly visits: %w", err)
+		}
+		// TIMESTAMP values come back without a zone; they are UTC by construction
+		c.Hour = time.Date(c.Hour.Year(), c.Hour.Month(), c.Hour.Day(), c.Hour.Hour(), 0, 0, 0, time.UTC)
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read hourly visits: %w", err)
+	}
+	return counts, nil
+}
+
+// RecordAnomaly stores an anomaly, reporting false if one was already recorded for that hour
+func (s *PostgresStore) RecordAnomaly(ctx context.Context, anomaly Anomaly) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		INSERT INTO anomalies (hour, kind, visits, mean, stddev) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (hour) DO NOTHING`,
+		anomaly.Hour.UTC(), anomaly.Kind, anomaly.Visits, anomaly.Mean, anomaly.StdDev)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error recording anomaly: %v", err)
+		return false, fmt.Errorf("failed to record anomaly: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetAnomalies returns the anomalies for hours in [from, to), most recent first
+func (s *PostgresStore) GetAnomalies(ctx context.Context, from, to time.Time) ([]Anomaly, error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT hour, kind, visits, mean, stddev FROM anomalies WHERE hour >= $1 AND hour < $2 ORDER BY hour DESC",
+		from.UTC(), to.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting anomalies: %v", err)
+		return nil, fmt.Errorf("failed to get anomalies: %w", err)
+	}
+	defer rows.Close()
+
+	var anomalies []Anomaly
+	for rows.Next() {
+		var a Anomaly
+		if err := rows.Scan(&a.Hour, &a.Kind, &a.Visits, &a.Mean, &a.StdDev); err != nil {
+			return nil, fmt.Errorf("failed to scan anomalies: %w", err)
+		}
+		a.Hour = a.Hour.UTC()
+		anomalies = append(anomalies, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read anomalies: %w", err)
+	}
+	return anomalies, nil
+}
+
+// GetVisitsAfter returns up to limit visits with IDs above afterID, in ID order. Only visits
+// recorded before before are returned, so rows still being committed aren't skipped past.
+func (s *PostgresStore) GetVisitsAfter(ctx context.Context, afterID int64, before time.Time, limit int) ([]VisitRow, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, timestamp, COALESCE(referrer, ''), COALESCE(utm_source, ''), COALESCE(utm_medium, ''), COALESCE(utm_campaign, '')
+		FROM visits
+		WHERE id > $1 AND timestamp < $2
+		ORDER BY id
+		LIMIT $3`, afterID, before.UTC(), limit)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visits to export: %v", err)
+		return nil, fmt.Errorf("failed to get visits to export: %w", err)
+	}
+	defer rows.Close()
+
+	var visits []VisitRow
+	for rows.Next() {
+		var v VisitRow
+		if err := rows.Scan(&v.ID, &v.Timestamp, &v.Referrer, &v.UTM.Source, &v.UTM.Medium, &v.UTM.Campaign); err != nil {
+			return nil, fmt.Errorf("failed to scan visits to export: %w", err)
+		}
+		v.Timestamp = v.Timestamp.UTC()
+		visits = append(visits, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visits to export: %w", err)
+	}
+	return visits, nil
+}
+
+// StreamVisits calls fn with up to limit visits with IDs above afterID, in ID order, as they
+// arrive from the database rather than after reading them all, so memory stays flat however
+// many there are. Only visits recorded before before are included. It stops at the first
+// error fn returns, and may run past the query timeout while fn waits on a slow reader.
+func (s *PostgresStore) StreamVisits(ctx context.Context, afterID int64, before time.Time, limit int, fn func(VisitRow) error) error {
+	rows, err := s.pool.Query(withoutQueryTimeout(ctx), `
+		SELECT id, timestamp, COALESCE(page, ''), COALESCE(referrer, ''), COALESCE(utm_source, ''), COALESCE(utm_medium, ''), COALESCE(utm_campaign, '')
+		FROM visits
+		WHERE id > $1 AND timestamp < $2
+		ORDER BY id
+		LIMIT $3`, afterID, before.UTC(), limit)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error streaming visits: %v", err)
+		return fmt.Errorf("failed to stream visits: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v VisitRow
+		if err := rows.Scan(&v.ID, &v.Timestamp, &v.Page, &v.Referrer, &v.UTM.Source, &v.UTM.Medium, &v.UTM.Campaign); err != nil {
+			return fmt.Errorf("failed to scan streamed visit: %w", err)
+		}
+		v.Timestamp = v.Timestamp.UTC()
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logging.FromContext(ctx).Printf("Error streaming visits: %v", err)
+		return fmt.Errorf("failed to stream visits: %w", err)
+	}
+	return nil
+}
+
+// GetExportMark returns the ID of the last visit shipped to sink, or 0 if none has been
+func (s *PostgresStore) GetExportMark(ctx context.Context, sink string) (int64, error) {
+	var lastID int64
+	err := s.pool.QueryRow(ctx, "SELECT COALESCE(MAX(last_id), 0) FROM export_marks WHERE sink = $1", sink).Scan(&lastID)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting export mark: %v", err)
+		return 0, fmt.Errorf("failed to get export mark: %w", err)
+	}
+	return lastID, nil
+}
+
+// SetExportMark records lastID as shipped to sink. The mark never moves backwards, so a
+// replica finishing a stale batch can't undo another's progress.
+func (s *PostgresStore) SetExportMark(ctx context.Context, sink string, lastID int64) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO export_marks (sink, last_id, updated_at) VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (sink) DO UPDATE
+		SET last_id = GREATEST(export_marks.last_id, EXCLUDED.last_id), updated_at = EXCLUDED.updated_at`,
+		sink, lastID)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error setting export mark: %v", err)
+		return fmt.Errorf("failed to set export mark: %w", err)
+	}
+	return nil
+}
+
+// ClaimOutbox leases up to limit messages that are due at now until leaseUntil, counting the
+// attempt. A dispatcher that dies mid-delivery leaves its messages to be claimed again once
+// the lease runs out, and SKIP LOCKED lets dispatchers on several replicas share the work.
+func (s *PostgresStore) ClaimOutbox(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxMessage, error) {
+	rows, err := s.pool.Query(ctx, `
+		UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM outbox WHERE next_attempt_at <= $1
+			ORDER BY id LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, destination, payload::text, attempts, COALESCE(last_error, '')`,
+		now.UTC(), leaseUntil.UTC(), limit)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error claiming outbox messages: %v", err)
+		return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var m OutboxMessage
+		var payload string
+		if err := rows.Scan(&m.ID, &m.Destination, &payload, &m.Attempts, &m.LastError); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+		m.Payload = json.RawMessage(payload)
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox messages: %w", err)
+	}
+	return messages, nil
+}
+
+// CompleteOutbox removes a delivered message
+func (s *PostgresStore) CompleteOutbox(ctx context.Context, id int64) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM outbox WHERE id = $1", id)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error completing outbox message: %v", err)
+		return fmt.Errorf("failed to complete outbox message: %w", err)
+	}
+	return nil
+}
+
+// FailOutbox records a failed delivery and schedules the next attempt at retryAt. A nil
+// retryAt gives up on the message, which stays in the table as a dead letter.
+func (s *PostgresStore) FailOutbox(ctx context.Context, id int64, retryAt *time.Time, lastError string) error {
+	var next *time.Time
+	if retryAt != nil {
+		utc := retryAt.UTC()
+		next = &utc
+	}
+	_, err := s.pool.Exec(ctx, "UPDATE outbox SET next_attempt_at = $2, last_error = $3 WHERE id = $1", id, next, lastError)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error recording outbox failure: %v", err)
+		return fmt.Errorf("failed to record outbox failure: %w", err)
+	}
+	return nil
+}
+
+// GetFailedOutbox returns up to limit dead letters, the messages given up on, newest first
+func (s *PostgresStore) GetFailedOutbox(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, destination, payload::text, attempts, COALESCE(last_error, ''), created_at
+		FROM outbox WHERE next_attempt_at IS NULL
+		ORDER BY id DESC LIMIT $1`, limit)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error querying failed outbox messages: %v", err)
+		return nil, fmt.Errorf("failed to query failed outbox messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var m OutboxMessage
+		var payload string
+		if err := rows.Scan(&m.ID, &m.Destination, &payload, &m.Attempts, &m.LastError, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+		m.Payload = json.RawMessage(payload)
+		m.CreatedAt = m.CreatedAt.UTC()
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox messages: %w", err)
+	}
+	return messages, nil
+}
+
+// RetryOutbox makes a dead letter due again with a fresh set of attempts, reporting whether
+// id was a dead letter. The last error is kept until the next attempt replaces it.
+func (s *PostgresStore) RetryOutbox(ctx context.Context, id int64) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE outbox SET attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND next_attempt_at IS NULL`, id)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error retrying outbox message: %v", err)
+		return false, fmt.Errorf("failed to retry outbox message: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// AcquireLease takes or renews the named lease for holder for ttl, reporting whether holder
+// has it. A lease held by another holder can only be taken once it has expired. Expiry uses
+// the database clock, so replicas with skewed clocks agree on it.
+func (s *PostgresStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		INSERT INTO leases (name, holder, expires_at) VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond')
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE leases.holder = EXCLUDED.holder OR leases.expires_at < CURRENT_TIMESTAMP`,
+		name, holder, ttl.Milliseconds())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error acquiring lease: %v", err)
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReleaseLease gives up the named lease if holder has it, so another holder can take it
+// without waiting for it to expire
+func (s *PostgresStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM leases WHERE name = $1 AND holder = $2", name, holder)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error releasing lease: %v", err)
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+// ClaimDedupeKey claims key for window, reporting whether it was free: false means the key
+// was already claimed within the window, by this replica or another. Like leases, windows
+// run on the database clock.
+func (s *PostgresStore) ClaimDedupeKey(ctx context.Context, key string, window time.Duration) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		INSERT INTO dedupe_keys (key, expires_at) VALUES ($1, CURRENT_TIMESTAMP + $2 * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO UPDATE SET expires_at = EXCLUDED.expires_at
+		WHERE dedupe_keys.expires_at <= CURRENT_TIMESTAMP`,
+		key, window.Milliseconds())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error claiming dedupe key: %v", err)
+		return false, fmt.Errorf("failed to claim dedupe key: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// DeleteExpiredDedupeKeys removes the keys whose window has passed, returning how many were removed
+func (s *PostgresStore) DeleteExpiredDedupeKeys(ctx context.Context) (int, error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM dedupe_keys WHERE expires_at <= CURRENT_TIMESTAMP")
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error deleting expired dedupe keys: %v", err)
+		return 0, fmt.Errorf("failed to delete expired dedupe keys: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// visitorHashArrays splits ids.Hashes into parallel day and hash arrays for unnest, with each
+// hash in every form it may be stored in, so rows sealed with an older key are found too
+func (s *PostgresStore) visitorHashArrays(ids VisitorIDs) ([]string, []string) {
+	days := make([]string, 0, len(ids.Hashes))
+	hashes := make([]string, 0, len(ids.Hashes))
+	for _, h := range ids.Hashes {
+		day := h.Day.UTC().Format(time.DateOnly)
+		for _, stored := range s.keys.lookupHashes(h.Hash) {
+			days, hashes = append(days, day), append(hashes, stored)
+		}
+	}
+	return days, hashes
+}
+
+// GetVisitorData returns the unique-visitor days, exposures, sessions and events held for ids
+func (s *PostgresStore) GetVisitorData(ctx context.Context, ids VisitorIDs) (VisitorData, error) {
+	var data VisitorData
+	var err error
+	days, hashes := s.visitorHashArrays(ids)
+	if data.UniqueDays, err = s.getVisitorDays(ctx, days, hashes); err != nil {
+		return VisitorData{}, err
+	}
+	if data.Exposures, err = s.getVisitorExposures(ctx, days, hashes); err != nil {
+		return VisitorData{}, err
+	}
+	if data.Sessions, err = s.getVisitorSessions(ctx, ids.SessionIDs); err != nil {
+		return VisitorData{}, err
+	}
+	if data.Events, err = s.getVisitorEvents(ctx, ids.SessionIDs); err != nil {
+		return VisitorData{}, err
+	}
+	return data, nil
+}
+
+func (s *PostgresStore) getVisitorDays(ctx context.Context, days, hashes []string) ([]VisitorHash, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT u.day, u.visitor_hash
+		FROM unique_visitors u
+		JOIN unnest($1::date[], $2::text[]) AS ids(day, visitor_hash)
+			ON u.day = ids.day AND u.visitor_hash = ids.visitor_hash
+		ORDER BY u.day`, days, hashes)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visitor days: %v", err)
+		return nil, fmt.Errorf("failed to get visitor days: %w", err)
+	}
+	defer rows.Close()
+
+	var visitorDays []VisitorHash
+	for rows.Next() {
+		var h VisitorHash
+		if err := rows.Scan(&h.Day, &h.Hash); err != nil {
+			return nil, fmt.Errorf("failed to scan visitor days: %w", err)
+		}
+		if h.Hash, err = s.keys.openHash(h.Hash); err != nil {
+			return nil, fmt.Errorf("failed to decrypt visitor days: %w", err)
+		}
+		visitorDays = append(visitorDays, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visitor days: %w", err)
+	}
+	return visitorDays, nil
+}
+
+func (s *PostgresStore) getVisitorExposures(ctx context.Context, days, hashes []string) ([]Exposure, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT x.experiment, x.variant, x.day, x.visitor_hash
+		FROM experiment_exposures x
+		JOIN unnest($1::date[], $2::text[]) AS ids(day, visitor_hash)
+			ON x.day = ids.day AND x.visitor_hash = ids.visitor_hash
+		ORDER BY x.day, x.experiment`, days, hashes)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visitor exposures: %v", err)
+		return nil, fmt.Errorf("failed to get visitor exposures: %w", err)
+	}
+	defer rows.Close()
+
+	var exposures []Exposure
+	for rows.Next() {
+		var e Exposure
+		if err := rows.Scan(&e.Experiment, &e.Variant, &e.Day, &e.VisitorHash); err != nil {
+			return nil, fmt.Errorf("failed to scan visitor exposures: %w", err)
+		}
+		if e.VisitorHash, err = s.keys.openHash(e.VisitorHash); err != nil {
+			return nil, fmt.Errorf("failed to decrypt visitor exposures: %w", err)
+		}
+		exposures = append(exposures, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visitor exposures: %w", err)
+	}
+	return exposures, nil
+}
+
+func (s *PostgresStore) getVisitorSessions(ctx context.Context, sessionIDs []string) ([]Session, error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT id, started_at, last_seen, heartbeats FROM sessions WHERE id = ANY($1) ORDER BY started_at",
+		sessionIDs)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visitor sessions: %v", err)
+		return nil, fmt.Errorf("failed to get visitor sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.StartedAt, &sess.LastSeen, &sess.Heartbeats); err != nil {
+			return nil, fmt.Errorf("failed to scan visitor sessions: %w", err)
+		}
+		sess.StartedAt, sess.LastSeen = sess.StartedAt.UTC(), sess.LastSeen.UTC()
+		sessions = append(sessions, sess)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visitor sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+func (s *PostgresStore) getVisitorEvents(ctx context.Context, sessionIDs []string) ([]Event, error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT type, occurred_at, session_id, properties FROM events WHERE session_id = ANY($1) ORDER BY occurred_at, id",
+		sessionIDs)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting visitor events: %v", err)
+		return nil, fmt.Errorf("failed to get visitor events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var properties []byte
+		if err := rows.Scan(&e.Type, &e.Timestamp, &e.SessionID, &properties); err != nil {
+			return nil, fmt.Errorf("failed to scan visitor events: %w", err)
+		}
+		e.Timestamp = e.Timestamp.UTC()
+		e.Properties = json.RawMessage(properties)
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visitor events: %w", err)
+	}
+	return events, nil
+}
+
+// DeleteVisitorData erases every row held for ids in one statement, so a failure leaves
+// nothing half-deleted, and returns how many rows were removed
+func (s *PostgresStore) DeleteVisitorData(ctx context.Context, ids VisitorIDs) (int, error) {
+	days, hashes := s.visitorHashArrays(ids)
+	var deleted int
+	err := s.pool.QueryRow(ctx, `
+		WITH ids AS (
+			SELECT * FROM unnest($1::date[], $2::text[]) AS ids(day, visitor_hash)
+		),
+		u AS (
+			DELETE FROM unique_visitors v USING ids
+			WHERE v.day = ids.day AND v.visitor_hash = ids.visitor_hash
+			RETURNING 1
+		),
+		x AS (
+			DELETE FROM experiment_exposures x USING ids
+			WHERE x.day = ids.day AND x.visitor_hash = ids.visitor_hash
+			RETURNING 1
+		),
+		s AS (DELETE FROM sessions WHERE id = ANY($3) RETURNING 1),
+		e AS (DELETE FROM events WHERE session_id = ANY($3) RETURNING 1)
+		SELECT (SELECT COUNT(*) FROM u) + (SELECT COUNT(*) FROM x) + (SELECT COUNT(*) FROM s) + (SELECT COUNT(*) FROM e)`,
+		days, hashes, ids.SessionIDs).Scan(&deleted)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error deleting visitor data: %v", err)
+		return 0, fmt.Errorf("failed to delete visitor data: %w", err)
+	}
+	return deleted, nil
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// Ping checks that the database is answering queries
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	if _, err := s.pool.Exec(ctx, "SELECT 1"); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database connection pool
+func (s *PostgresStore) Close() {
+	s.pool.Close()
+}
+
+// ResetConnections retires the pooled connections so new ones log in with the current
+// password. Idle connections close straight away and busy ones once their query finishes,
+// so requests in flight are not interrupted.
+func (s *PostgresStore) ResetConnections() {
+	if p, ok := s.pool.(interface{ Reset() }); ok {
+		p.Reset()
+	}
+}
+
+// Failover sends queries to the next database in DATABASE_FAILOVER_URLS that answers, read-only,
+// for when the primary has stayed down. It returns ErrNoFailoverTarget when none is set or
+// none answers.
+func (s *PostgresStore) Failover(ctx context.Context) error {
+	if p, ok := s.pool.(interface{ Failover(context.Context) error }); ok {
+		return p.Failover(ctx)
+	}
+	return ErrNoFailoverTarget
+}
+
+// FailBack returns to the primary after a Failover once it answers again, reporting whether
+// it did.
+func (s *PostgresStore) FailBack(ctx context.Context) bool {
+	if p, ok := s.pool.(interface{ FailBack(context.Context) bool }); ok {
+		return p.FailBack(ctx)
+	}
+	return false
+}
+
+// createTable creates the visits table if it does not exist
+func createTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS visits (
+			id SERIAL PRIMARY KEY,
+			timestamp TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+	return nil
+}
+
+// createUniqueVisitorsTable creates the table of daily visitor hashes if it does not exist
+func createUniqueVisitorsTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS unique_visitors (
+			day DATE NOT NULL,
+			visitor_hash TEXT NOT NULL,
+			PRIMARY KEY (day, visitor_hash)
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create unique_visitors table: %w", err)
+	}
+	return nil
+}
+
+// createVisitorSketchesTable creates the table of daily HyperLogLog sketches if it does not exist
+func createVisitorSketchesTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS visitor_sketches (
+			day DATE PRIMARY KEY,
+			sketch BYTEA NOT NULL
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create visitor_sketches table: %w", err)
+	}
+	return nil
+}
+
+// addReferrerColumn adds the indexed referrer column to visits tables created without it
+func addReferrerColumn(ctx context.Context, pool DatabasePool) error {
+	query := `
+		ALTER TABLE visits ADD COLUMN IF NOT EXISTS referrer TEXT;
+		CREATE INDEX IF NOT EXISTS visits_referrer_timestamp_idx ON visits (referrer, timestamp)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to add referrer column: %w", err)
+	}
+	return nil
+}
+
+// addUTMColumns adds the UTM campaign columns to visits tables created without them
+func addUTMColumns(ctx context.Context, pool DatabasePool) error {
+	query := `
+		ALTER TABLE visits
+			ADD COLUMN IF NOT EXISTS utm_source TEXT,
+			ADD COLUMN IF NOT EXISTS utm_medium TEXT,
+			ADD COLUMN IF NOT EXISTS utm_campaign TEXT;
+		CREATE INDEX IF NOT EXISTS visits_utm_campaign_timestamp_idx ON visits (utm_campaign, timestamp)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to add UTM columns: %w", err)
+	}
+	return nil
+}
+
+// addPageColumn adds the page column to visits tables created without it
+func addPageColumn(ctx context.Context, pool DatabasePool) error {
+	query := `
+		ALTER TABLE visits ADD COLUMN IF NOT EXISTS page TEXT;
+		CREATE INDEX IF NOT EXISTS visits_page_idx ON visits (page)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to add page column: %w", err)
+	}
+	return nil
+}
+
+// createTimestampIndex indexes visits by time alone, for range counts over every visit
+func createTimestampIndex(ctx context.Context, pool DatabasePool) error {
+	query := `CREATE INDEX IF NOT EXISTS visits_timestamp_idx ON visits (timestamp)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create timestamp index: %w", err)
+	}
+	return nil
+}
+
+// createExperimentExposuresTable creates the table of experiment exposures if it does not exist
+func createExperimentExposuresTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS experiment_exposures (
+			experiment TEXT NOT NULL,
+			day DATE NOT NULL,
+			visitor_hash TEXT NOT NULL,
+			variant TEXT NOT NULL,
+			PRIMARY KEY (experiment, day, visitor_hash)
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create experiment_exposures table: %w", err)
+	}
+	return nil
+}
+
+// createSessionTables creates the sessions table and its daily rollup if they do not exist
+func createSessionTables(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			started_at TIMESTAMPTZ NOT NULL,
+			last_seen TIMESTAMPTZ NOT NULL,
+			heartbeats INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS sessions_started_at_idx ON sessions (started_at);
+		CREATE INDEX IF NOT EXISTS sessions_last_seen_idx ON sessions (last_seen);
+		CREATE TABLE IF NOT EXISTS session_daily (
+			day DATE PRIMARY KEY,
+			sessions INTEGER NOT NULL,
+			total_seconds DOUBLE PRECISION NOT NULL
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create session tables: %w", err)
+	}
+	return nil
+}
+
+// createEventsTable creates the events table if it does not exist
+func createEventsTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS events (
+			id BIGSERIAL PRIMARY KEY,
+			type TEXT NOT NULL,
+			occurred_at TIMESTAMPTZ NOT NULL,
+			session_id TEXT,
+			properties JSONB NOT NULL DEFAULT '{}'
+		);
+		CREATE INDEX IF NOT EXISTS events_type_occurred_at_idx ON events (type, occurred_at);
+		CREATE INDEX IF NOT EXISTS events_session_id_occurred_at_idx ON events (session_id, occurred_at)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create events table: %w", err)
+	}
+	return nil
+}
+
+// createAnomaliesTable creates the table of detected visit rate anomalies if it does not exist
+func createAnomaliesTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS anomalies (
+			hour TIMESTAMPTZ PRIMARY KEY,
+			kind TEXT NOT NULL,
+			visits INTEGER NOT NULL,
+			mean DOUBLE PRECISION NOT NULL,
+			stddev DOUBLE PRECISION NOT NULL,
+			detected_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create anomalies table: %w", err)
+	}
+	return nil
+}
+
+// createExportMarksTable creates the table of per-sink export high-water marks if it does not exist
+func createExportMarksTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS export_marks (
+			sink TEXT PRIMARY KEY,
+			last_id BIGINT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create export_marks table: %w", err)
+	}
+	return nil
+}
+
+// createOutboxTable creates the table of notifications waiting for delivery if it does not
+// exist. Rows whose next_attempt_at is NULL have run out of attempts and are kept for inspection.
+func createOutboxTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS outbox (
+			id BIGSERIAL PRIMARY KEY,
+			destination TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			attempts INT NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			last_error TEXT
+		);
+		CREATE INDEX IF NOT EXISTS outbox_next_attempt_idx ON outbox (next_attempt_at) WHERE next_attempt_at IS NOT NULL`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	return nil
+}
+
+// createLeasesTable creates the table of leases replicas hold to run a job alone if it does not exist
+func createLeasesTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS leases (
+			name TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create leases table: %w", err)
+	}
+	return nil
+}
+
+// createDedupeKeysTable creates the table of keys claimed for a dedupe window if it does not exist
+func createDedupeKeysTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS dedupe_keys (
+			key TEXT PRIMARY KEY,
+			expires_at TIMESTAMPTZ NOT NULL
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create dedupe_keys table: %w", err)
+	}
+	return nil
+}
+
+// migrateTimestampsToUTC converts a visits.timestamp column created as TIMESTAMP
+// into TIMESTAMPTZ, interpreting existing rows as UTC. It is a no-op once converted.
+func migrateTimestampsToUTC(ctx context.Context, pool DatabasePool) error {
+	query := `
+		DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'visits' AND column_name = 'timestamp'
+					AND data_type = 'timestamp without time zone'
+			) THEN
+				ALTER TABLE visits ALTER COLUMN timestamp TYPE TIMESTAMPTZ USING visits.timestamp AT TIME ZONE 'UTC';
+			END IF;
+		END $$`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to migrate timestamps to UTC: %w", err)
+	}
+	return nil
+}
+
+// schemaSteps create and upgrade the schema in order; every step must be idempotent
+var schemaSteps = []func(ctx context.Context, pool DatabasePool) error{
+	createTable,
+	migrateTimestampsToUTC, // tables created before timestamps were stored as TIMESTAMPTZ
+	createUniqueVisitorsTable,
+	createVisitorSketchesTable,
+	addReferrerColumn,
+	addUTMColumns,
+	createExperimentExposuresTable,
+	createSessionTables,
+	createEventsTable,
+	createAnomaliesTable,
+	createExportMarksTable,
+	addPageColumn,
+	createOutboxTable,
+	createLeasesTable,
+	createDedupeKeysTable,
+	createSummaryViews,
+	createTimestampIndex,
+	createProjectsTable,
+	createResumeVersionsTable,
+	createPostsTable,
+	createShortLinksTable,
+	createUptimeChecksTable,
+	createCertificateChecksTable,
+	createDeploysTable,
+}
+
+// migrate runs every schema step against pool
+func migrate(ctx context.Context, pool DatabasePool) error {
+	for _, step := range schemaSteps {
+		if err := step(ctx, pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupDatabase initializes and configures the database
+func SetupDatabase(ctx context.Context) (DataStore, error) {
+	cfg, err := loadDBConfig()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := loadQueryLimits()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := loadKeyRing()
+	if err != nil {
+		return nil, err
+	}
+	config, err := newPoolConfig(cfg, cfg.ConnString(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool: %w", err)
+	}
+
+	// Verify connection
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	// Create and upgrade tables. This runs on the bare pool, as migrations can take long.
+	if err := migrate(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	// Seal the visitor hashes left in the clear or under a retired key before serving
+	if keys != nil {
+		encrypted, err := encryptVisitorHashes(ctx, pool, keys)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		if encrypted > 0 {
+			logging.FromContext(ctx).Printf("Encrypted %d visitor hashes with key %q", encrypted, keys.active)
+		}
+	}
+
+	// The failover databases are only connected to once the primary has gone down
+	if len(cfg.FailoverURLs) == 0 {
+		return &PostgresStore{pool: newTimedPool(pool, limits), keys: keys}, nil
+	}
+	failover := newFailoverPool(pool, len(cfg.FailoverURLs), func(ctx context.Context, target int) (DatabasePool, error) {
+		config, err := newPoolConfig(cfg, cfg.FailoverURLs[target-1], true)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		return pool, nil
+	})
+	return &PostgresStore{pool: newTimedPool(failover, limits), keys: keys}, nil
+}
+
+// newPoolConfig returns the pool settings for connecting to dsn. Failover targets connect
+// read-only, and keep a password given in their own DSN rather than using DB_PASSWORD.
+func newPoolConfig(cfg dbConfig, dsn string, failover bool) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	// With IAM auth, each new connection logs in with a fresh token instead of DB_PASSWORD.
+	// Tokens must never be sent in the clear, so loadDBConfig has made sure TLS is required.
+	cc := config.ConnConfig
+	passwords, err := loadPasswordSource(cc.User, cc.Host, strconv.Itoa(int(cc.Port)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure the connection pool, unless the DSN does
+	target := cfg
+	target.URL = dsn
+	if !target.setsParam("pool_max_conns") {
+		config.MaxConns = 20
+	}
+	if !target.setsParam("pool_min_conns") {
+		config.MinConns = min(10, config.MaxConns)
+	}
+	if !target.setsParam("pool_max_conn_lifetime") {
+		config.MaxConnLifetime = time.Minute * 5
+	}
+
+	// Otherwise DB_PASSWORD is read as each connection opens, so a rotated password is
+	// picked up by new connections without a restart
+	if passwords == nil {
+		passwords = envPassword{}
+	}
+	ownPassword := failover && cc.Password != ""
+	config.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
+		if ownPassword {
+			return nil
+		}
+		password, err := passwords.Password(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get database password: %w", err)
+		}
+		// A password given only in DATABASE_URL is kept
+		if password != "" {
+			cc.Password = password
+		}
+		return nil
+	}
+
+	// Writes fail on a failover database rather than diverging from the primary
+	if failover {
+		cc.RuntimeParams["default_transaction_read_only"] = "on"
+	}
+
+	// Every query is reported to the query observer, and logged with DB_LOG_QUERIES
+	cc.Tracer = queryTracer{logQueries: cfg.LogQueries}
+	return config, nil
+}
@@ -0,0 +1,1275 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockDatabasePool struct {
+	mock.Mock
+}
+
+func Test_IncrementVisitCount(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	// Create an instance of PostgresStore with the mock pool
+	s := &PostgresStore{pool: mock} // This works now because mock implements DatabasePool
+
+	ctx := context.Background()
+	timestamp := time.Now()
+
+	// Set up expectations
+	// Timestamps are persisted in UTC
+	none := (*string)(nil)
+	mock.ExpectExec("INSERT INTO visits").WithArgs(timestamp.UTC(), none, none, none, none, none).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	// Call the method under test
+	err = s.IncrementVisitCount(ctx, Visit{Timestamp: timestamp})
+	assert.NoError(t, err)
+
+	page, referrer, source, campaign := "blog", "linkedin.com", "linkedin", "acme-backend"
+	mock.ExpectExec("INSERT INTO visits").WithArgs(timestamp.UTC(), &page, &referrer, &source, none, &campaign).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	err = s.IncrementVisitCount(ctx, Visit{Timestamp: timestamp, Page: page, Referrer: referrer, UTM: UTM{Source: source, Campaign: campaign}})
+	assert.NoError(t, err)
+
+	// Ensure all expectations were met
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_IncrementVisitCounts(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	berlin := time.FixedZone("CET", 3600)
+	first := time.Date(2024, 3, 3, 10, 0, 0, 0, berlin)
+	second := time.Date(2024, 3, 3, 11, 0, 0, 0, time.UTC)
+
+	// Nothing to record makes no query
+	require.NoError(t, s.IncrementVisitCounts(ctx, nil))
+
+	page, source := "blog", "newsletter"
+	none := (*string)(nil)
+	mock.ExpectExec("INSERT INTO visits .* SELECT \\* FROM unnest").
+		WithArgs([]time.Time{first.UTC(), second}, []*string{&page, none}, []*string{none, none},
+			[]*string{none, &source}, []*string{none, none}, []*string{none, none}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	err = s.IncrementVisitCounts(ctx, []Visit{
+		{Timestamp: first, Page: page},
+		{Timestamp: second, UTM: UTM{Source: source}},
+	})
+	require.NoError(t, err)
+
+	mock.ExpectExec("INSERT INTO visits").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("insert error"))
+	require.Error(t, s.IncrementVisitCounts(ctx, []Visit{{Timestamp: second}}))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_EnqueueOutbox(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	messages := []OutboxMessage{{Destination: "anomaly-alert", Payload: json.RawMessage(`{"text":"Visit spike"}`)}}
+
+	mock.ExpectExec("INSERT INTO outbox \\(destination, payload\\)\\s+SELECT d, p::jsonb FROM unnest").
+		WithArgs([]string{"anomaly-alert"}, []string{`{"text":"Visit spike"}`}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	require.NoError(t, s.EnqueueOutbox(ctx, messages))
+
+	// Nothing to queue
+	require.NoError(t, s.EnqueueOutbox(ctx, nil))
+
+	mock.ExpectExec("INSERT INTO outbox").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("insert error"))
+	require.Error(t, s.EnqueueOutbox(ctx, messages))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_IncrementVisitCountsWithOutbox(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	visited := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	none := (*string)(nil)
+	visits := []Visit{{Timestamp: visited}}
+	messages := []OutboxMessage{{Destination: "webhook", Payload: json.RawMessage(`{"text":"New resume visit"}`)}}
+
+	mock.ExpectExec("WITH v AS \\(\\s*INSERT INTO visits .*\\) INSERT INTO outbox").
+		WithArgs([]time.Time{visited}, []*string{none}, []*string{none}, []*string{none}, []*string{none}, []*string{none},
+			[]string{"webhook"}, []string{`{"text":"New resume visit"}`}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	require.NoError(t, s.IncrementVisitCountsWithOutbox(ctx, visits, messages))
+
+	// Without messages the visits are recorded on their own
+	mock.ExpectExec("INSERT INTO visits .* SELECT \\* FROM unnest").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	require.NoError(t, s.IncrementVisitCountsWithOutbox(ctx, visits, nil))
+
+	mock.ExpectExec("INSERT INTO outbox").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(),
+			pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("insert error"))
+	require.Error(t, s.IncrementVisitCountsWithOutbox(ctx, visits, messages))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetVisitCount(t *testing.T) {
+	// Create a mock pool
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		mock    func()
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "success",
+			mock: func() {
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM visits").
+					WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(10))
+			},
+			want:    10,
+			wantErr: false,
+		},
+		{
+			name: "error",
+			mock: func() {
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM visits").
+					WillReturnError(fmt.Errorf("query error"))
+			},
+			want:    0,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup mock behavior
+			tt.mock()
+
+			// Create a new PostgresStore with the mock pool
+			s := &PostgresStore{
+				pool: mock,
+			}
+
+			got, err := s.GetVisitCount(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PostgresStore.GetVisitCount() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("PostgresStore.GetVisitCount() = %v, want %v", got, tt.want)
+			}
+
+			// Ensure all expectations were met
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresStore_GetVisitCountBetween(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	since := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	until := since.Add(5 * time.Minute)
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM visits WHERE timestamp > \\$1 AND timestamp <= \\$2").
+		WithArgs(since, until).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(7))
+	count, err := s.GetVisitCountBetween(ctx, since, until)
+	require.NoError(t, err)
+	assert.Equal(t, 7, count)
+
+	mock.ExpectQuery("SELECT COUNT").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetVisitCountBetween(ctx, since, until)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetVisitCountsInRanges(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	loc, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	thisWeek := TimeRange{From: time.Date(2024, 3, 4, 0, 0, 0, 0, loc), To: time.Date(2024, 3, 6, 12, 0, 0, 0, loc)}
+	lastWeek := TimeRange{From: time.Date(2024, 2, 26, 0, 0, 0, 0, loc), To: time.Date(2024, 2, 28, 12, 0, 0, 0, loc)}
+
+	// Bounds are passed in UTC, one array each, and the counts come back in range order
+	mock.ExpectQuery("FROM unnest\\(\\$1::timestamptz\\[\\], \\$2::timestamptz\\[\\]\\) WITH ORDINALITY").
+		WithArgs([]time.Time{thisWeek.From.UTC(), lastWeek.From.UTC()}, []time.Time{thisWeek.To.UTC(), lastWeek.To.UTC()}).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(12).AddRow(8))
+	counts, err := s.GetVisitCountsInRanges(ctx, []TimeRange{thisWeek, lastWeek})
+	require.NoError(t, err)
+	assert.Equal(t, []int{12, 8}, counts)
+
+	mock.ExpectQuery("FROM unnest").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetVisitCountsInRanges(ctx, []TimeRange{thisWeek})
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetPageCounts(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	pages := []string{"home", "blog", "projects"}
+
+	mock.ExpectQuery("SELECT page, COUNT\\(\\*\\) FROM visits WHERE page = ANY\\(\\$1\\) GROUP BY page").
+		WithArgs(pages).
+		WillReturnRows(pgxmock.NewRows([]string{"page", "count"}).AddRow("home", 12).AddRow("blog", 3))
+	got, err := s.GetPageCounts(ctx, pages)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"home": 12, "blog": 3}, got)
+
+	mock.ExpectQuery("SELECT page, COUNT").WithArgs(pages).WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetPageCounts(ctx, pages)
+	require.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetDailyVisits(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, loc)
+	to := time.Date(2024, 3, 3, 0, 0, 0, 0, loc)
+
+	tests := []struct {
+		name    string
+		mock    func()
+		want    []DailyCount
+		wantErr bool
+	}{
+		{
+			name: "success",
+			mock: func() {
+				mock.ExpectQuery("SELECT \\(visits.timestamp AT TIME ZONE \\$1\\)::date").
+					WithArgs("America/New_York", from.UTC(), to.UTC()).
+					WillReturnRows(pgxmock.NewRows([]string{"day", "count"}).
+						AddRow(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 4).
+						AddRow(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), 2))
+			},
+			want: []DailyCount{
+				{Date: time.Date(2024, 3, 1, 0, 0, 0, 0, loc), Visits: 4},
+				{Date: time.Date(2024, 3, 2, 0, 0, 0, 0, loc), Visits: 2},
+			},
+		},
+		{
+			name: "error",
+			mock: func() {
+				mock.ExpectQuery("SELECT").
+					WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+					WillReturnError(fmt.Errorf("query error"))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+
+			s := &PostgresStore{pool: mock}
+			got, err := s.GetDailyVisits(ctx, from, to, loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PostgresStore.GetDailyVisits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_migrateTimestampsToUTC(t *testing.T) {
+	mockPool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mockPool.Close()
+
+	ctx := context.Background()
+
+	mockPool.ExpectExec("ALTER TABLE visits ALTER COLUMN timestamp TYPE TIMESTAMPTZ").
+		WillReturnResult(pgxmock.NewResult("DO", 0))
+	assert.NoError(t, migrateTimestampsToUTC(ctx, mockPool))
+
+	mockPool.ExpectExec("ALTER TABLE visits").WillReturnError(fmt.Errorf("migration error"))
+	assert.Error(t, migrateTimestampsToUTC(ctx, mockPool))
+
+	require.NoError(t, mockPool.ExpectationsWereMet())
+}
+
+func TestPostgresStore_UniqueVisitors(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	day := time.Date(2024, 3, 3, 23, 30, 0, 0, time.FixedZone("EST", -5*3600))
+
+	// Days are recorded in UTC, so 23:30 EST lands on the next day
+	mock.ExpectExec("INSERT INTO unique_visitors").
+		WithArgs("2024-03-04", "abc").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.RecordUniqueVisitor(ctx, day, "abc"))
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM unique_visitors").
+		WithArgs("2024-03-01", "2024-03-04").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(12))
+	count, err := s.GetUniqueVisitorCount(ctx, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), day)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, count)
+
+	mock.ExpectExec("INSERT INTO unique_visitors").
+		WithArgs("2024-03-04", "abc").
+		WillReturnError(fmt.Errorf("insert error"))
+	assert.Error(t, s.RecordUniqueVisitor(ctx, day, "abc"))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_VisitorSketches(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	day := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("INSERT INTO visitor_sketches").
+		WithArgs("2024-03-03", []byte{1, 2}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.SaveVisitorSketch(ctx, day, []byte{1, 2}))
+
+	mock.ExpectQuery("SELECT sketch FROM visitor_sketches").
+		WithArgs("2024-03-01", "2024-03-03").
+		WillReturnRows(pgxmock.NewRows([]string{"sketch"}).AddRow([]byte{1}).AddRow([]byte{2}))
+	sketches, err := s.GetVisitorSketches(ctx, day.AddDate(0, 0, -2), day)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{1}, {2}}, sketches)
+
+	mock.ExpectQuery("SELECT sketch FROM visitor_sketches").
+		WithArgs("2024-03-03", "2024-03-03").
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetVisitorSketches(ctx, day, day)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetTopReferrers(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	to := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -30)
+
+	mock.ExpectQuery("SELECT referrer, COUNT\\(\\*\\) AS visits").
+		WithArgs(from, to, 5).
+		WillReturnRows(pgxmock.NewRows([]string{"referrer", "visits"}).AddRow("linkedin.com", 9).AddRow("github.com", 4))
+	referrers, err := s.GetTopReferrers(ctx, from, to, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []ReferrerCount{{Domain: "linkedin.com", Visits: 9}, {Domain: "github.com", Visits: 4}}, referrers)
+
+	mock.ExpectQuery("SELECT referrer").
+		WithArgs(from, to, 5).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetTopReferrers(ctx, from, to, 5)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetCampaignVisits(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	to := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -30)
+
+	mock.ExpectQuery("SELECT COALESCE\\(utm_source").
+		WithArgs(from, to, 10).
+		WillReturnRows(pgxmock.NewRows([]string{"utm_source", "utm_medium", "utm_campaign", "visits"}).
+			AddRow("linkedin", "job-application", "acme-backend", 3).
+			AddRow("", "", "newsletter", 1))
+	campaigns, err := s.GetCampaignVisits(ctx, from, to, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []CampaignCount{
+		{UTM: UTM{Source: "linkedin", Medium: "job-application", Campaign: "acme-backend"}, Visits: 3},
+		{UTM: UTM{Campaign: "newsletter"}, Visits: 1},
+	}, campaigns)
+
+	mock.ExpectQuery("SELECT COALESCE").
+		WithArgs(from, to, 10).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetCampaignVisits(ctx, from, to, 10)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_Experiments(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	day := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("INSERT INTO experiment_exposures").
+		WithArgs("layout", "2024-03-03", "abc", "compact").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.RecordExposure(ctx, Exposure{Experiment: "layout", Variant: "compact", Day: day, VisitorHash: "abc"}))
+
+	mock.ExpectQuery("SELECT e.variant").
+		WithArgs("layout", "2024-02-26", "2024-03-03").
+		WillReturnRows(pgxmock.NewRows([]string{"variant", "exposures", "conversions"}).
+			AddRow("compact", 10, 4).
+			AddRow("control", 12, 3))
+	results, err := s.GetExperimentResults(ctx, "layout", day.AddDate(0, 0, -6), day)
+	assert.NoError(t, err)
+	assert.Equal(t, []VariantResult{
+		{Variant: "compact", Exposures: 10, Conversions: 4},
+		{Variant: "control", Exposures: 12, Conversions: 3},
+	}, results)
+
+	mock.ExpectExec("INSERT INTO experiment_exposures").
+		WithArgs("layout", "2024-03-03", "abc", "compact").
+		WillReturnError(fmt.Errorf("insert error"))
+	assert.Error(t, s.RecordExposure(ctx, Exposure{Experiment: "layout", Variant: "compact", Day: day, VisitorHash: "abc"}))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_Sessions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	idleSince := now.Add(-30 * time.Minute)
+
+	mock.ExpectExec("INSERT INTO sessions").
+		WithArgs("abc", now).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.StartSession(ctx, "abc", now))
+
+	mock.ExpectExec("UPDATE sessions").
+		WithArgs("abc", now, idleSince).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	active, err := s.TouchSession(ctx, "abc", now, idleSince)
+	assert.NoError(t, err)
+	assert.True(t, active)
+
+	mock.ExpectExec("UPDATE sessions").
+		WithArgs("gone", now, idleSince).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	active, err = s.TouchSession(ctx, "gone", now, idleSince)
+	assert.NoError(t, err)
+	assert.False(t, active)
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM sessions WHERE last_seen >= \\$1").
+		WithArgs(now.Add(-time.Minute)).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(3))
+	count, err := s.CountActiveSessions(ctx, now.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	mock.ExpectQuery("FROM sessions").WithArgs(pgxmock.AnyArg()).WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.CountActiveSessions(ctx, now)
+	assert.Error(t, err)
+
+	since := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec("INSERT INTO session_daily").
+		WithArgs(since, idleSince).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	assert.NoError(t, s.AggregateSessions(ctx, since, idleSince))
+
+	mock.ExpectQuery("SELECT day, sessions, total_seconds FROM session_daily").
+		WithArgs("2024-03-02", "2024-03-03").
+		WillReturnRows(pgxmock.NewRows([]string{"day", "sessions", "total_seconds"}).AddRow(since, 4, 300.0))
+	days, err := s.GetSessionStats(ctx, since, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []SessionDay{{Date: since, Sessions: 4, TotalSeconds: 300}}, days)
+
+	mock.ExpectExec("INSERT INTO session_daily").
+		WithArgs(since, idleSince).
+		WillReturnError(fmt.Errorf("aggregate error"))
+	assert.Error(t, s.AggregateSessions(ctx, since, idleSince))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_Events(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	from := now.AddDate(0, 0, -30)
+	sessionID := "abc"
+
+	mock.ExpectExec("INSERT INTO events").
+		WithArgs("clicked_github", now, (*string)(nil), "{}").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.RecordEvent(ctx, Event{Type: "clicked_github", Timestamp: now}))
+
+	mock.ExpectExec("INSERT INTO events").
+		WithArgs("expanded_project", now, &sessionID, `{"project":"x"}`).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.RecordEvent(ctx, Event{Type: "expanded_project", Timestamp: now, SessionID: sessionID, Properties: []byte(`{"project":"x"}`)}))
+
+	mock.ExpectQuery("SELECT type, '', COUNT").
+		WithArgs(from, now).
+		WillReturnRows(pgxmock.NewRows([]string{"type", "value", "count"}).AddRow("clicked_github", "", 5))
+	counts, err := s.GetEventStats(ctx, EventStatsQuery{From: from, To: now})
+	assert.NoError(t, err)
+	assert.Equal(t, []EventCount{{Type: "clicked_github", Count: 5}}, counts)
+
+	mock.ExpectQuery("SELECT type, '', COUNT").
+		WithArgs(from, now, "clicked_github").
+		WillReturnRows(pgxmock.NewRows([]string{"type", "value", "count"}).AddRow("clicked_github", "", 5))
+	_, err = s.GetEventStats(ctx, EventStatsQuery{From: from, To: now, Type: "clicked_github"})
+	assert.NoError(t, err)
+
+	mock.ExpectQuery("SELECT type, COALESCE\\(properties->>\\$4").
+		WithArgs(from, now, "expanded_project", "project").
+		WillReturnRows(pgxmock.NewRows([]string{"type", "value", "count"}).AddRow("expanded_project", "x", 2))
+	counts, err = s.GetEventStats(ctx, EventStatsQuery{From: from, To: now, Type: "expanded_project", Property: "project"})
+	assert.NoError(t, err)
+	assert.Equal(t, []EventCount{{Type: "expanded_project", Value: "x", Count: 2}}, counts)
+
+	mock.ExpectQuery("SELECT type").
+		WithArgs(from, now).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetEventStats(ctx, EventStatsQuery{From: from, To: now})
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetFunnel(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	to := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -30)
+
+	mock.ExpectQuery("WITH e AS").
+		WithArgs(from, to, "view", "download", "contact").
+		WillReturnRows(pgxmock.NewRows([]string{"s1", "s2", "s3"}).AddRow(10, 4, 1))
+	counts, err := s.GetFunnel(ctx, from, to, []string{"view", "download", "contact"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 4, 1}, counts)
+
+	mock.ExpectQuery("WITH e AS").
+		WithArgs(from, to, "view", "contact").
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetFunnel(ctx, from, to, []string{"view", "contact"})
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_funnelQuery(t *testing.T) {
+	query := funnelQuery(3)
+
+	for _, want := range []string{"s1 AS", "s2 AS", "s3 AS", "e.type = $4", "e.type = $5", "FROM s2 p JOIN e", "(SELECT COUNT(*) FROM s3)"} {
+		assert.Contains(t, query, want)
+	}
+	assert.NotContains(t, query, "s4")
+}
+
+func TestPostgresStore_Anomalies(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	to := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	from := to.Add(-169 * time.Hour)
+	hour := to.Add(-time.Hour)
+
+	mock.ExpectQuery("SELECT date_trunc\\('hour'").
+		WithArgs(from, to).
+		WillReturnRows(pgxmock.NewRows([]string{"hour", "count"}).AddRow(hour, 42))
+	counts, err := s.GetHourlyVisits(ctx, from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, []HourlyCount{{Hour: hour, Visits: 42}}, counts)
+
+	anomaly := Anomaly{Hour: hour, Kind: "spike", Visits: 42, Mean: 3.5, StdDev: 1.2}
+	mock.ExpectExec("INSERT INTO anomalies").
+		WithArgs(hour, "spike", 42, 3.5, 1.2).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	recorded, err := s.RecordAnomaly(ctx, anomaly)
+	assert.NoError(t, err)
+	assert.True(t, recorded)
+
+	// Another replica already recorded this hour
+	mock.ExpectExec("INSERT INTO anomalies").
+		WithArgs(hour, "spike", 42, 3.5, 1.2).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	recorded, err = s.RecordAnomaly(ctx, anomaly)
+	assert.NoError(t, err)
+	assert.False(t, recorded)
+
+	mock.ExpectQuery("SELECT hour, kind, visits, mean, stddev FROM anomalies").
+		WithArgs(from, to).
+		WillReturnRows(pgxmock.NewRows([]string{"hour", "kind", "visits", "mean", "stddev"}).AddRow(hour, "spike", 42, 3.5, 1.2))
+	anomalies, err := s.GetAnomalies(ctx, from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, []Anomaly{anomaly}, anomalies)
+
+	mock.ExpectQuery("SELECT date_trunc").
+		WithArgs(from, to).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetHourlyVisits(ctx, from, to)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetSummary(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, loc)
+	to := time.Date(2024, 3, 8, 0, 0, 0, 0, loc)
+
+	// Unique visitors are counted for the UTC days the window touches
+	mock.ExpectQuery("WITH daily AS \\(.*SELECT \\(SELECT COUNT\\(\\*\\) FROM visits\\), \\(SELECT COUNT\\(\\*\\) FROM unique_visitors").
+		WithArgs("Asia/Kolkata", from.UTC(), to.UTC(), "2024-02-29", "2024-03-07").
+		WillReturnRows(pgxmock.NewRows([]string{"count", "count", "array", "array"}).
+			AddRow(120, 9,
+				[]time.Time{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC)},
+				[]int64{4, 7}))
+	summary, err := s.GetSummary(ctx, from, to, loc)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{
+		Visits:         120,
+		UniqueVisitors: 9,
+		Daily:          []DailyCount{{Date: from, Visits: 4}, {Date: time.Date(2024, 3, 7, 0, 0, 0, 0, loc), Visits: 7}},
+	}, summary)
+
+	mock.ExpectQuery("WITH daily AS").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetSummary(ctx, from, to, loc)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetVisitHeatmap(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, loc)
+	to := time.Date(2024, 3, 31, 0, 0, 0, 0, loc)
+
+	mock.ExpectQuery("SELECT EXTRACT\\(DOW FROM visits.timestamp AT TIME ZONE \\$1\\)::int AS weekday").
+		WithArgs("America/New_York", from.UTC(), to.UTC()).
+		WillReturnRows(pgxmock.NewRows([]string{"weekday", "hour", "count"}).AddRow(0, 23, 2).AddRow(2, 9, 5))
+	cells, err := s.GetVisitHeatmap(ctx, from, to, loc)
+	require.NoError(t, err)
+	assert.Equal(t, []HeatmapCell{{Weekday: time.Sunday, Hour: 23, Visits: 2}, {Weekday: time.Tuesday, Hour: 9, Visits: 5}}, cells)
+
+	mock.ExpectQuery("SELECT EXTRACT").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetVisitHeatmap(ctx, from, to, loc)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_Export(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	before := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	visited := before.Add(-time.Hour)
+
+	mock.ExpectQuery("SELECT id, timestamp").
+		WithArgs(int64(41), before, 100).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "timestamp", "referrer", "utm_source", "utm_medium", "utm_campaign"}).
+			AddRow(int64(42), visited, "linkedin.com", "", "", "").
+			AddRow(int64(43), visited, "", "newsletter", "email", "launch"))
+	rows, err := s.GetVisitsAfter(ctx, 41, before, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []VisitRow{
+		{ID: 42, Visit: Visit{Timestamp: visited, Referrer: "linkedin.com"}},
+		{ID: 43, Visit: Visit{Timestamp: visited, UTM: UTM{Source: "newsletter", Medium: "email", Campaign: "launch"}}},
+	}, rows)
+
+	mock.ExpectQuery("SELECT id, timestamp").
+		WithArgs(int64(0), before, 100).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetVisitsAfter(ctx, 0, before, 100)
+	assert.Error(t, err)
+
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(last_id\\), 0\\) FROM export_marks").
+		WithArgs("clickhouse").
+		WillReturnRows(pgxmock.NewRows([]string{"last_id"}).AddRow(int64(43)))
+	mark, err := s.GetExportMark(ctx, "clickhouse")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(43), mark)
+
+	mock.ExpectExec("INSERT INTO export_marks").
+		WithArgs("clickhouse", int64(50)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.SetExportMark(ctx, "clickhouse", 50))
+
+	mock.ExpectExec("INSERT INTO export_marks").
+		WithArgs("clickhouse", int64(51)).
+		WillReturnError(fmt.Errorf("exec error"))
+	assert.Error(t, s.SetExportMark(ctx, "clickhouse", 51))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_StreamVisits(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	before := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	visited := before.Add(-time.Hour)
+	columns := []string{"id", "timestamp", "page", "referrer", "utm_source", "utm_medium", "utm_campaign"}
+
+	mock.ExpectQuery("SELECT id, timestamp, COALESCE\\(page, ''\\)").
+		WithArgs(int64(41), before, 100).
+		WillReturnRows(pgxmock.NewRows(columns).
+			AddRow(int64(42), visited, "blog", "linkedin.com", "", "", "").
+			AddRow(int64(43), visited, "", "", "newsletter", "email", "launch"))
+	var rows []VisitRow
+	err = s.StreamVisits(ctx, 41, before, 100, func(v VisitRow) error {
+		rows = append(rows, v)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []VisitRow{
+		{ID: 42, Visit: Visit{Timestamp: visited, Page: "blog", Referrer: "linkedin.com"}},
+		{ID: 43, Visit: Visit{Timestamp: visited, UTM: UTM{Source: "newsletter", Medium: "email", Campaign: "launch"}}},
+	}, rows)
+
+	// An error from fn stops the stream and is returned as is
+	stop := errors.New("client went away")
+	mock.ExpectQuery("SELECT id, timestamp").
+		WithArgs(int64(0), before, 100).
+		WillReturnRows(pgxmock.NewRows(columns).
+			AddRow(int64(42), visited, "", "", "", "", "").
+			AddRow(int64(43), visited, "", "", "", "", ""))
+	calls := 0
+	err = s.StreamVisits(ctx, 0, before, 100, func(v VisitRow) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+
+	mock.ExpectQuery("SELECT id, timestamp").
+		WithArgs(int64(0), before, 100).
+		WillReturnError(fmt.Errorf("query error"))
+	assert.Error(t, s.StreamVisits(ctx, 0, before, 100, func(VisitRow) error { return nil }))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_Outbox(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	leaseUntil := now.Add(time.Minute)
+
+	mock.ExpectQuery("UPDATE outbox SET attempts = attempts \\+ 1.*FOR UPDATE SKIP LOCKED").
+		WithArgs(now, leaseUntil, 50).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "destination", "payload", "attempts", "last_error"}).
+			AddRow(int64(7), "webhook", `{"text":"New resume visit"}`, 2, "webhook returned status 502"))
+	messages, err := s.ClaimOutbox(ctx, now, leaseUntil, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, []OutboxMessage{{
+		ID: 7, Destination: "webhook", Payload: json.RawMessage(`{"text":"New resume visit"}`),
+		Attempts: 2, LastError: "webhook returned status 502",
+	}}, messages)
+
+	mock.ExpectQuery("UPDATE outbox").
+		WithArgs(now, leaseUntil, 50).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.ClaimOutbox(ctx, now, leaseUntil, 50)
+	assert.Error(t, err)
+
+	mock.ExpectExec("DELETE FROM outbox").
+		WithArgs(int64(7)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	assert.NoError(t, s.CompleteOutbox(ctx, 7))
+
+	mock.ExpectExec("DELETE FROM outbox").
+		WithArgs(int64(8)).
+		WillReturnError(fmt.Errorf("exec error"))
+	assert.Error(t, s.CompleteOutbox(ctx, 8))
+
+	mock.ExpectExec("UPDATE outbox SET next_attempt_at").
+		WithArgs(int64(7), &leaseUntil, "timeout").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	assert.NoError(t, s.FailOutbox(ctx, 7, &leaseUntil, "timeout"))
+
+	// Giving up leaves the message as a dead letter
+	mock.ExpectExec("UPDATE outbox SET next_attempt_at").
+		WithArgs(int64(7), (*time.Time)(nil), "timeout").
+		WillReturnError(fmt.Errorf("exec error"))
+	assert.Error(t, s.FailOutbox(ctx, 7, nil, "timeout"))
+
+	mock.ExpectQuery("SELECT id, destination, payload::text, attempts, COALESCE\\(last_error, ''\\), created_at\\s+FROM outbox WHERE next_attempt_at IS NULL").
+		WithArgs(100).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "destination", "payload", "attempts", "last_error", "created_at"}).
+			AddRow(int64(7), "webhook", `{"text":"New resume visit"}`, 10, "webhook returned status 502", now))
+	failed, err := s.GetFailedOutbox(ctx, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []OutboxMessage{{
+		ID: 7, Destination: "webhook", Payload: json.RawMessage(`{"text":"New resume visit"}`),
+		Attempts: 10, LastError: "webhook returned status 502", CreatedAt: now,
+	}}, failed)
+
+	mock.ExpectQuery("SELECT id, destination").
+		WithArgs(100).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetFailedOutbox(ctx, 100)
+	assert.Error(t, err)
+
+	mock.ExpectExec("UPDATE outbox SET attempts = 0, next_attempt_at = CURRENT_TIMESTAMP\\s+WHERE id = \\$1 AND next_attempt_at IS NULL").
+		WithArgs(int64(7)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	retried, err := s.RetryOutbox(ctx, 7)
+	assert.NoError(t, err)
+	assert.True(t, retried)
+
+	// Messages that are pending or gone are not retried
+	mock.ExpectExec("UPDATE outbox SET attempts = 0").
+		WithArgs(int64(8)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	retried, err = s.RetryOutbox(ctx, 8)
+	assert.NoError(t, err)
+	assert.False(t, retried)
+
+	mock.ExpectExec("UPDATE outbox SET attempts = 0").
+		WithArgs(int64(9)).
+		WillReturnError(fmt.Errorf("exec error"))
+	_, err = s.RetryOutbox(ctx, 9)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_VisitorData(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+	day := time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)
+	seen := day.Add(10 * time.Hour)
+	ids := VisitorIDs{
+		Hashes:     []VisitorHash{{Day: day, Hash: "abc"}},
+		SessionIDs: []string{"s1"},
+	}
+	days, hashes := []string{"2024-03-03"}, []string{"abc"}
+
+	mock.ExpectQuery("SELECT u.day, u.visitor_hash FROM unique_visitors").
+		WithArgs(days, hashes).
+		WillReturnRows(pgxmock.NewRows([]string{"day", "visitor_hash"}).AddRow(day, "abc"))
+	mock.ExpectQuery("SELECT x.experiment, x.variant, x.day, x.visitor_hash FROM experiment_exposures").
+		WithArgs(days, hashes).
+		WillReturnRows(pgxmock.NewRows([]string{"experiment", "variant", "day", "visitor_hash"}).AddRow("cta", "b", day, "abc"))
+	mock.ExpectQuery("SELECT id, started_at, last_seen, heartbeats FROM sessions").
+		WithArgs([]string{"s1"}).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "started_at", "last_seen", "heartbeats"}).AddRow("s1", seen, seen.Add(time.Minute), 3))
+	mock.ExpectQuery("SELECT type, occurred_at, session_id, properties FROM events").
+		WithArgs([]string{"s1"}).
+		WillReturnRows(pgxmock.NewRows([]string{"type", "occurred_at", "session_id", "properties"}).AddRow("downloaded_resume", seen, "s1", []byte(`{"format":"pdf"}`)))
+	data, err := s.GetVisitorData(ctx, ids)
+	assert.NoError(t, err)
+	assert.Equal(t, VisitorData{
+		UniqueDays: []VisitorHash{{Day: day, Hash: "abc"}},
+		Exposures:  []Exposure{{Experiment: "cta", Variant: "b", Day: day, VisitorHash: "abc"}},
+		Sessions:   []Session{{ID: "s1", StartedAt: seen, LastSeen: seen.Add(time.Minute), Heartbeats: 3}},
+		Events:     []Event{{Type: "downloaded_resume", Timestamp: seen, SessionID: "s1", Properties: json.RawMessage(`{"format":"pdf"}`)}},
+	}, data)
+
+	mock.ExpectQuery("SELECT u.day, u.visitor_hash FROM unique_visitors").
+		WithArgs(days, hashes).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.GetVisitorData(ctx, ids)
+	assert.Error(t, err)
+
+	mock.ExpectQuery("WITH ids AS").
+		WithArgs(days, hashes, []string{"s1"}).
+		WillReturnRows(pgxmock.NewRows([]string{"deleted"}).AddRow(4))
+	deleted, err := s.DeleteVisitorData(ctx, ids)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, deleted)
+
+	mock.ExpectQuery("WITH ids AS").
+		WithArgs(days, hashes, []string{"s1"}).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = s.DeleteVisitorData(ctx, ids)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_Ping(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+
+	mock.ExpectExec("SELECT 1").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	assert.NoError(t, s.Ping(ctx))
+
+	mock.ExpectExec("SELECT 1").WillReturnError(fmt.Errorf("connection refused"))
+	assert.Error(t, s.Ping(ctx))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_migrate(t *testing.T) {
+	mockPool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mockPool.Close()
+
+	ctx := context.Background()
+
+	mockPool.ExpectExec("CREATE TABLE IF NOT EXISTS visits").WillReturnResult(pgxmock.NewResult("CREATE", 0))
+	mockPool.ExpectExec("ALTER TABLE visits").WillReturnResult(pgxmock.NewResult("DO", 0))
+	mockPool.ExpectExec("CREATE TABLE IF NOT EXISTS unique_visitors").WillReturnError(fmt.Errorf("create error"))
+
+	assert.Error(t, migrate(ctx, mockPool))
+	require.NoError(t, mockPool.ExpectationsWereMet())
+}
+
+func (m *MockDatabasePool) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	args := m.Called(ctx, sql, arguments)
+	return args.Get(0).(pgconn.CommandTag), args.Error(1)
+}
+
+func (m *MockDatabasePool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	// Implement this if needed for other tests
+	return nil
+}
+
+func (m *MockDatabasePool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	// Implement this if needed for other tests
+	return nil, nil
+}
+
+func (m *MockDatabasePool) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	// Implement this if needed for other tests
+	return 0, nil
+}
+
+func (m *MockDatabasePool) Close() {
+	m.Called()
+}
+
+func TestPostgresStore_Close(t *testing.T) {
+	tests := []struct {
+		name string
+		mock func(*MockDatabasePool)
+	}{
+		{
+			name: "close pool",
+			mock: func(m *MockDatabasePool) {
+				m.On("Close").Once() // Expect Close to be called once
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockPool := new(MockDatabasePool)
+			tt.mock(mockPool)
+
+			s := &PostgresStore{
+				pool: mockPool,
+			}
+			s.Close()
+
+			// Assert that all expectations were met
+			mockPool.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPostgresStore_ResetConnections(t *testing.T) {
+	mockPool, err := pgxmock.NewPool()
+	assert.NoError(t, err)
+	defer mockPool.Close()
+
+	mockPool.ExpectReset()
+	NewPostgresStore(mockPool).ResetConnections()
+	assert.NoError(t, mockPool.ExpectationsWereMet())
+
+	// Pools that cannot be reset are left alone
+	NewPostgresStore(new(MockDatabasePool)).ResetConnections()
+}
+
+func Test_createTable(t *testing.T) {
+	// Create a mock pool
+	mockPool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mockPool.Close()
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		mock    func()
+		wantErr bool
+	}{
+		{
+			name: "success",
+			mock: func() {
+				mockPool.ExpectExec("CREATE TABLE IF NOT EXISTS visits").
+					WillReturnResult(pgxmock.NewResult("CREATE", 0))
+			},
+			wantErr: false,
+		},
+		{
+			name: "error",
+			mock: func() {
+				mockPool.ExpectExec("CREATE TABLE IF NOT EXISTS visits").
+					WillReturnError(fmt.Errorf("query error"))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup mock behavior
+			tt.mock()
+
+			// Call createTable
+			err := createTable(ctx, mockPool)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("createTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// Ensure all expectations were met
+			require.NoError(t, mockPool.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSetupDatabase(t *testing.T) {
+	for _, name := range []string{"DB_USER", "DB_PASSWORD", "DB_HOST", "DB_PORT", "DB_NAME", "DB_IAM_AUTH"} {
+		t.Setenv(name, "")
+	}
+
+	// Missing settings fail before any connection is attempted
+	got, err := SetupDatabase(context.Background())
+	var cfgErr *ConfigError
+	require.ErrorAs(t, err, &cfgErr)
+	assert.Len(t, cfgErr.Problems, 5)
+	assert.Nil(t, got)
+}
+
+func TestPostgresStore_Lease(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+
+	mock.ExpectExec("INSERT INTO leases .* ON CONFLICT \\(name\\) DO UPDATE").
+		WithArgs("background-jobs", "pod-a", int64(30000)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	acquired, err := s.AcquireLease(ctx, "background-jobs", "pod-a", 30*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Another holder's unexpired lease is left alone
+	mock.ExpectExec("INSERT INTO leases").
+		WithArgs("background-jobs", "pod-b", int64(30000)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	acquired, err = s.AcquireLease(ctx, "background-jobs", "pod-b", 30*time.Second)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	mock.ExpectExec("INSERT INTO leases").
+		WithArgs("background-jobs", "pod-b", int64(30000)).
+		WillReturnError(fmt.Errorf("exec error"))
+	_, err = s.AcquireLease(ctx, "background-jobs", "pod-b", 30*time.Second)
+	assert.Error(t, err)
+
+	mock.ExpectExec("DELETE FROM leases").
+		WithArgs("background-jobs", "pod-a").
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	assert.NoError(t, s.ReleaseLease(ctx, "background-jobs", "pod-a"))
+
+	mock.ExpectExec("DELETE FROM leases").
+		WithArgs("background-jobs", "pod-a").
+		WillReturnError(fmt.Errorf("exec error"))
+	assert.Error(t, s.ReleaseLease(ctx, "background-jobs", "pod-a"))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_DedupeKeys(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	s := &PostgresStore{pool: mock}
+
+	mock.ExpectExec("INSERT INTO dedupe_keys .* ON CONFLICT \\(key\\) DO UPDATE").
+		WithArgs("abc123", int64(1800000)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	claimed, err := s.ClaimDedupeKey(ctx, "abc123", 30*time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// A key claimed within the window is left alone
+	mock.ExpectExec("INSERT INTO dedupe_keys").
+		WithArgs("abc123", int64(1800000)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	claimed, err = s.ClaimDedupeKey(ctx, "abc123", 30*time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+
+	mock.ExpectExec("INSERT INTO dedupe_keys").
+		WithArgs("abc123", int64(1800000)).
+		WillReturnError(fmt.Errorf("exec error"))
+	_, err = s.ClaimDedupeKey(ctx, "abc123", 30*time.Minute)
+	assert.Error(t, err)
+
+	mock.ExpectExec("DELETE FROM dedupe_keys WHERE expires_at <= CURRENT_TIMESTAMP").
+		WillReturnResult(pgxmock.NewResult("DELETE", 4))
+	deleted, err := s.DeleteExpiredDedupeKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, deleted)
+
+	mock.ExpectExec("DELETE FROM dedupe_keys").
+		WillReturnError(fmt.Errorf("exec error"))
+	_, err = s.DeleteExpiredDedupeKeys(ctx)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_CopyVisits(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	visits := []Visit{
+		{Timestamp: time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC), Referrer: "linkedin.com"},
+		{Timestamp: time.Date(2023, 1, 1, 11, 0, 0, 0, time.UTC), Page: "blog", UTM: UTM{Source: "newsletter"}},
+	}
+	columns := []string{"timestamp", "page", "referrer", "utm_source", "utm_medium", "utm_campaign"}
+
+	mock.ExpectCopyFrom(pgx.Identifier{"visits"}, columns).WillReturnResult(2)
+	n, err := s.CopyVisits(ctx, visits)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	mock.ExpectCopyFrom(pgx.Identifier{"visits"}, columns).WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.CopyVisits(ctx, visits)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
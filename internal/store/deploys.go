@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"resume-backend/internal/logging"
+)
+
+// Deploy is one deployment of the frontend.
+type Deploy struct {
+	ID          int64
+	CommitSHA   string
+	DeployedAt  time.Time
+	Description string
+}
+
+// createDeploysTable creates the table of frontend deployments if it does not exist
+func createDeploysTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS deploys (
+			id BIGSERIAL PRIMARY KEY,
+			commit_sha TEXT NOT NULL,
+			deployed_at TIMESTAMPTZ NOT NULL,
+			description TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS deploys_deployed_at_idx ON deploys (deployed_at)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create deploys table: %w", err)
+	}
+	return nil
+}
+
+// RecordDeploy stores a deployment, returning it with its ID.
+func (s *PostgresStore) RecordDeploy(ctx context.Context, deploy Deploy) (Deploy, error) {
+	deploy.DeployedAt = deploy.DeployedAt.UTC()
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO deploys (commit_sha, deployed_at, description) VALUES ($1, $2, $3) RETURNING id`,
+		deploy.CommitSHA, deploy.DeployedAt, deploy.Description).Scan(&deploy.ID)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error recording deploy: %v", err)
+		return Deploy{}, fmt.Errorf("failed to record deploy: %w", err)
+	}
+	return deploy, nil
+}
+
+// GetDeploys returns the deployments made in [from, to), oldest first.
+func (s *PostgresStore) GetDeploys(ctx context.Context, from, to time.Time) ([]Deploy, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, commit_sha, deployed_at, description FROM deploys
+		WHERE deployed_at >= $1 AND deployed_at < $2 ORDER BY deployed_at, id`,
+		from.UTC(), to.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting deploys: %v", err)
+		return nil, fmt.Errorf("failed to get deploys: %w", err)
+	}
+	defer rows.Close()
+
+	var deploys []Deploy
+	for rows.Next() {
+		var d Deploy
+		if err := rows.Scan(&d.ID, &d.CommitSHA, &d.DeployedAt, &d.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan deploys: %w", err)
+		}
+		d.DeployedAt = d.DeployedAt.UTC()
+		deploys = append(deploys, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read deploys: %w", err)
+	}
+	return deploys, nil
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStore_RecordDeploy(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	deployed := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("INSERT INTO deploys").
+		WithArgs("3f2c1ab", deployed, "New projects page").
+		WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(4)))
+	got, err := s.RecordDeploy(ctx, Deploy{CommitSHA: "3f2c1ab", DeployedAt: deployed.In(time.FixedZone("CET", 3600)), Description: "New projects page"})
+	require.NoError(t, err)
+	assert.Equal(t, Deploy{ID: 4, CommitSHA: "3f2c1ab", DeployedAt: deployed, Description: "New projects page"}, got)
+
+	mock.ExpectQuery("INSERT INTO deploys").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.RecordDeploy(ctx, Deploy{CommitSHA: "3f2c1ab", DeployedAt: deployed})
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetDeploys(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	from := time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	mock.ExpectQuery("FROM deploys\\s+WHERE deployed_at >= \\$1 AND deployed_at < \\$2 ORDER BY deployed_at").
+		WithArgs(from, to).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "commit_sha", "deployed_at", "description"}).
+			AddRow(int64(1), "3f2c1ab", from.Add(time.Hour), "").
+			AddRow(int64(2), "9d8e7f6", from.Add(2*time.Hour), "Fix typo"))
+	deploys, err := s.GetDeploys(ctx, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []Deploy{
+		{ID: 1, CommitSHA: "3f2c1ab", DeployedAt: from.Add(time.Hour)},
+		{ID: 2, CommitSHA: "9d8e7f6", DeployedAt: from.Add(2 * time.Hour), Description: "Fix typo"},
+	}, deploys)
+
+	mock.ExpectQuery("FROM deploys").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.GetDeploys(ctx, from, to)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"resume-backend/internal/logging"
+)
+
+const (
+	// encryptedPrefix starts a value sealed by a keyRing, followed by the key ID, a colon and
+	// the base64 nonce and ciphertext
+	encryptedPrefix = "enc:"
+
+	// visitorHashAAD binds sealed hashes to the visitor_hash columns. unique_visitors and
+	// experiment_exposures share it, so their hashes still join.
+	visitorHashAAD = "visitor_hash"
+
+	encryptionKeyBytes = 32 // AES-256
+	encryptHashBatches = 1000
+)
+
+var encryptionKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// dataKey is one key of a keyRing, split into an AES-GCM key and a key deriving nonces
+type dataKey struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+// keyRing encrypts the visitor hashes stored at rest with AES-256-GCM. The nonce is an HMAC
+// of the value, so a hash always seals to the same ciphertext under a key: sealed hashes
+// still work as primary keys, in ON CONFLICT and in lookups. New values are sealed with the
+// active key; the others are kept to find and open values sealed before a rotation.
+type keyRing struct {
+	active string
+	ids    []string // in the order listed, the active one first
+	keys   map[string]dataKey
+}
+
+// loadKeyRing reads DATA_ENCRYPTION_KEYS, a comma-separated list of id:key pairs with each
+// key 32 bytes in base64, returning nil when it is unset. The first key is the active one;
+// to rotate, put a new key first and keep the old ones until the rows sealed with them have
+// been re-encrypted at startup. SECRETS_PROVIDER can supply the keys from Vault or AWS.
+func loadKeyRing() (*keyRing, error) {
+	v := os.Getenv("DATA_ENCRYPTION_KEYS")
+	if v == "" {
+		return nil, nil
+	}
+	ring := &keyRing{keys: make(map[string]dataKey)}
+	for _, entry := range strings.Split(v, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || !encryptionKeyID.MatchString(id) || err != nil || len(key) != encryptionKeyBytes {
+			return nil, fmt.Errorf("invalid DATA_ENCRYPTION_KEYS entry for key %q: must be an ID of letters, digits, - or _, a colon and a base64 32-byte key", id)
+		}
+		if _, ok := ring.keys[id]; ok {
+			return nil, fmt.Errorf("invalid DATA_ENCRYPTION_KEYS: key %q is listed twice", id)
+		}
+		block, err := aes.NewCipher(deriveKey(key, "encryption"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid DATA_ENCRYPTION_KEYS key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DATA_ENCRYPTION_KEYS key %q: %w", id, err)
+		}
+		ring.ids = append(ring.ids, id)
+		ring.keys[id] = dataKey{aead: aead, nonce: deriveKey(key, "nonce")}
+	}
+	ring.active = ring.ids[0]
+	return ring, nil
+}
+
+// deriveKey derives a subkey of key for one use, so encryption and nonces don't share a key
+func deriveKey(key []byte, use string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(use))
+	return mac.Sum(nil)
+}
+
+// sealWith encrypts value with the key id
+func (k *keyRing) sealWith(id, value, aad string) string {
+	key := k.keys[id]
+	mac := hmac.New(sha256.New, key.nonce)
+	mac.Write([]byte(aad))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	nonce := make([]byte, key.aead.NonceSize(), key.aead.NonceSize()+len(value)+key.aead.Overhead())
+	copy(nonce, mac.Sum(nil))
+	sealed := key.aead.Seal(nonce, nonce, []byte(value), []byte(aad))
+	return encryptedPrefix + id + ":" + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// sealHash encrypts a visitor hash with the active key. Without a key ring the hash is
+// returned as it is.
+func (k *keyRing) sealHash(hash string) string {
+	if k == nil {
+		return hash
+	}
+	return k.sealWith(k.active, hash, visitorHashAAD)
+}
+
+// lookupHashes returns every form hash may be stored in: sealed with each key, newest first,
+// then in the clear.
+func (k *keyRing) lookupHashes(hash string) []string {
+	if k == nil {
+		return []string{hash}
+	}
+	forms := make([]string, 0, len(k.ids)+1)
+	for _, id := range k.ids {
+		forms = append(forms, k.sealWith(id, hash, visitorHashAAD))
+	}
+	return append(forms, hash)
+}
+
+// openHash reverses sealHash. Hashes stored before encryption was turned on are returned as
+// they are.
+func (k *keyRing) openHash(stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		return stored, nil
+	}
+	id, encoded, _ := strings.Cut(strings.TrimPrefix(stored, encryptedPrefix), ":")
+	if k == nil {
+		return "", fmt.Errorf("value is encrypted with key %q, but DATA_ENCRYPTION_KEYS is not set", id)
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("value is encrypted with key %q, which is not in DATA_ENCRYPTION_KEYS", id)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", errors.New("encrypted value is malformed")
+	}
+	value, err := key.aead.Open(nil, sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():], []byte(visitorHashAAD))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value with key %q: %w", id, err)
+	}
+	return string(value), nil
+}
+
+// encryptVisitorHashes seals the visitor hashes stored in the clear or with a key other than
+// the active one, a batch at a time in primary key order, returning how many rows it
+// re-encrypted. A row is moved rather than updated, so one that now duplicates a row sealed
+// with the active key, written by a replica already rotated, is dropped instead.
+func encryptVisitorHashes(ctx context.Context, pool DatabasePool, keys *keyRing) (int, error) {
+	active := encryptedPrefix + keys.active + ":%"
+	encrypted := 0
+
+	day, hash := "-infinity", ""
+	for {
+		rows, err := pool.Query(ctx, `
+			SELECT day::text, visitor_hash FROM unique_visitors
+			WHERE (day, visitor_hash) > ($1::date, $2) AND visitor_hash NOT LIKE $3
+			ORDER BY day, visitor_hash LIMIT $4`,
+			day, hash, active, encryptHashBatches)
+		if err != nil {
+			logging.FromContext(ctx).Printf("Error reading visitor hashes to encrypt: %v", err)
+			return encrypted, fmt.Errorf("failed to read visitor hashes to encrypt: %w", err)
+		}
+		var days, olds, news []string
+		for rows.Next() {
+			if err := rows.Scan(&day, &hash); err != nil {
+				rows.Close()
+				return encrypted, fmt.Errorf("failed to scan visitor hash: %w", err)
+			}
+			sealed, err := reseal(keys, hash)
+			if err != nil {
+				rows.Close()
+				return encrypted, err
+			}
+			days, olds, news = append(days, day), append(olds, hash), append(news, sealed)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return encrypted, fmt.Errorf("failed to read visitor hashes to encrypt: %w", err)
+		}
+		if len(olds) > 0 {
+			_, err = pool.Exec(ctx, `
+				WITH moved AS (
+					DELETE FROM unique_visitors u USING unnest($1::date[], $2::text[], $3::text[]) AS v(day, old, new)
+					WHERE u.day = v.day AND u.visitor_hash = v.old
+					RETURNING v.day, v.new
+				)
+				INSERT INTO unique_visitors (day, visitor_hash) SELECT day, new FROM moved ON CONFLICT DO NOTHING`,
+				days, olds, news)
+			if err != nil {
+				logging.FromContext(ctx).Printf("Error encrypting visitor hashes: %v", err)
+				return encrypted, fmt.Errorf("failed to encrypt visitor hashes: %w", err)
+			}
+			encrypted += len(olds)
+		}
+		if len(olds) < encryptHashBatches {
+			break
+		}
+	}
+
+	experiment := ""
+	day, hash = "-infinity", ""
+	for {
+		rows, err := pool.Query(ctx, `
+			SELECT experiment, day::text, visitor_hash FROM experiment_exposures
+			WHERE (experiment, day, visitor_hash) > ($1, $2::date, $3) AND visitor_hash NOT LIKE $4
+			ORDER BY experiment, day, visitor_hash LIMIT $5`,
+			experiment, day, hash, active, encryptHashBatches)
+		if err != nil {
+			logging.FromContext(ctx).Printf("Error reading exposure hashes to encrypt: %v", err)
+			return encrypted, fmt.Errorf("failed to read exposure hashes to encrypt: %w", err)
+		}
+		var experiments, days, olds, news []string
+		for rows.Next() {
+			if err := rows.Scan(&experiment, &day, &hash); err != nil {
+				rows.Close()
+				return encrypted, fmt.Errorf("failed to scan exposure hash: %w", err)
+			}
+			sealed, err := reseal(keys, hash)
+			if err != nil {
+				rows.Close()
+				return encrypted, err
+			}
+			experiments, days = append(experiments, experiment), append(days, day)
+			olds, news = append(olds, hash), append(news, sealed)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return encrypted, fmt.Errorf("failed to read exposure hashes to encrypt: %w", err)
+		}
+		if len(olds) > 0 {
+			_, err = pool.Exec(ctx, `
+				WITH moved AS (
+					DELETE FROM experiment_exposures x
+					USING unnest($1::text[], $2::date[], $3::text[], $4::text[]) AS v(experiment, day, old, new)
+					WHERE x.experiment = v.experiment AND x.day = v.day AND x.visitor_hash = v.old
+					RETURNING x.experiment, x.day, v.new, x.variant
+				)
+				INSERT INTO experiment_exposures (experiment, day, visitor_hash, variant) SELECT * FROM moved ON CONFLICT DO NOTHING`,
+				experiments, days, olds, news)
+			if err != nil {
+				logging.FromContext(ctx).Printf("Error encrypting exposure hashes: %v", err)
+				return encrypted, fmt.Errorf("failed to encrypt exposure hashes: %w", err)
+			}
+			encrypted += len(olds)
+		}
+		if len(olds) < encryptHashBatches {
+			return encrypted, nil
+		}
+	}
+}
+
+// reseal opens a stored hash and seals it with the active key
+func reseal(keys *keyRing, stored string) (string, error) {
+	hash, err := keys.openHash(stored)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt visitor hash: %w", err)
+	}
+	return keys.sealHash(hash), nil
+}
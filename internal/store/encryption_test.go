@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), encryptionKeyBytes)))
+}
+
+func testKeyRing(t *testing.T, keys string) *keyRing {
+	t.Setenv("DATA_ENCRYPTION_KEYS", keys)
+	ring, err := loadKeyRing()
+	require.NoError(t, err)
+	return ring
+}
+
+func Test_loadKeyRing(t *testing.T) {
+	t.Setenv("DATA_ENCRYPTION_KEYS", "")
+	ring, err := loadKeyRing()
+	require.NoError(t, err)
+	assert.Nil(t, ring)
+
+	ring = testKeyRing(t, "2024-06:"+testKey('b')+", 2024-01:"+testKey('a'))
+	assert.Equal(t, "2024-06", ring.active)
+	assert.Equal(t, []string{"2024-06", "2024-01"}, ring.ids)
+
+	for _, keys := range []string{
+		"2024-06",            // no key
+		"2024-06:not base64", // not base64
+		"2024-06:" + base64.StdEncoding.EncodeToString([]byte("short")), // not 32 bytes
+		"2024:06:" + testKey('a'),                  // colon in the ID
+		"a:" + testKey('a') + ",a:" + testKey('b'), // listed twice
+	} {
+		t.Setenv("DATA_ENCRYPTION_KEYS", keys)
+		_, err := loadKeyRing()
+		assert.Error(t, err, keys)
+	}
+}
+
+func Test_keyRing_sealHash(t *testing.T) {
+	old := testKeyRing(t, "old:"+testKey('a'))
+	ring := testKeyRing(t, "new:"+testKey('b')+",old:"+testKey('a'))
+
+	sealed := ring.sealHash("abc123")
+	assert.True(t, strings.HasPrefix(sealed, "enc:new:"), sealed)
+	assert.NotContains(t, sealed, "abc123")
+	assert.Equal(t, sealed, ring.sealHash("abc123"), "sealing must be deterministic to work as a key")
+	assert.NotEqual(t, sealed, ring.sealHash("abc124"))
+	assert.NotEqual(t, sealed, old.sealHash("abc123"))
+
+	value, err := ring.openHash(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", value)
+
+	// Hashes sealed before the rotation still open
+	value, err = ring.openHash(old.sealHash("abc123"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", value)
+
+	// Hashes stored before encryption was turned on pass through
+	value, err = ring.openHash("abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", value)
+
+	_, err = old.openHash(sealed)
+	assert.Error(t, err, "unknown key")
+	_, err = (*keyRing)(nil).openHash(sealed)
+	assert.Error(t, err, "no keys")
+	_, err = ring.openHash("enc:new:" + base64.RawURLEncoding.EncodeToString([]byte("tampered value")))
+	assert.Error(t, err, "tampered")
+}
+
+func Test_keyRing_lookupHashes(t *testing.T) {
+	old := testKeyRing(t, "old:"+testKey('a'))
+	ring := testKeyRing(t, "new:"+testKey('b')+",old:"+testKey('a'))
+
+	assert.Equal(t, []string{ring.sealHash("abc"), old.sealHash("abc"), "abc"}, ring.lookupHashes("abc"))
+
+	var none *keyRing
+	assert.Equal(t, "abc", none.sealHash("abc"))
+	assert.Equal(t, []string{"abc"}, none.lookupHashes("abc"))
+}
+
+func TestPostgresStore_EncryptedVisitorHashes(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	old := testKeyRing(t, "old:"+testKey('a'))
+	ring := testKeyRing(t, "new:"+testKey('b')+",old:"+testKey('a'))
+	s := &PostgresStore{pool: mock, keys: ring}
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("INSERT INTO unique_visitors").
+		WithArgs("2024-03-04", ring.sealHash("abc")).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.RecordUniqueVisitor(ctx, day, "abc"))
+
+	mock.ExpectExec("INSERT INTO experiment_exposures").
+		WithArgs("cta", "2024-03-04", ring.sealHash("abc"), "b").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.RecordExposure(ctx, Exposure{Experiment: "cta", Variant: "b", Day: day, VisitorHash: "abc"}))
+
+	// Privacy lookups match the hash under every key and in the clear, and return it opened
+	ids := VisitorIDs{Hashes: []VisitorHash{{Day: day, Hash: "abc"}}}
+	days := []string{"2024-03-04", "2024-03-04", "2024-03-04"}
+	hashes := []string{ring.sealHash("abc"), old.sealHash("abc"), "abc"}
+	mock.ExpectQuery("SELECT u.day, u.visitor_hash FROM unique_visitors").
+		WithArgs(days, hashes).
+		WillReturnRows(pgxmock.NewRows([]string{"day", "visitor_hash"}).AddRow(day, old.sealHash("abc")))
+	mock.ExpectQuery("SELECT x.experiment, x.variant, x.day, x.visitor_hash FROM experiment_exposures").
+		WithArgs(days, hashes).
+		WillReturnRows(pgxmock.NewRows([]string{"experiment", "variant", "day", "visitor_hash"}).
+			AddRow("cta", "b", day, ring.sealHash("abc")))
+	mock.ExpectQuery("SELECT id, started_at, last_seen, heartbeats FROM sessions").
+		WithArgs([]string(nil)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "started_at", "last_seen", "heartbeats"}))
+	mock.ExpectQuery("SELECT type, occurred_at, session_id, properties FROM events").
+		WithArgs([]string(nil)).
+		WillReturnRows(pgxmock.NewRows([]string{"type", "occurred_at", "session_id", "properties"}))
+	data, err := s.GetVisitorData(ctx, ids)
+	require.NoError(t, err)
+	assert.Equal(t, []VisitorHash{{Day: day, Hash: "abc"}}, data.UniqueDays)
+	assert.Equal(t, []Exposure{{Experiment: "cta", Variant: "b", Day: day, VisitorHash: "abc"}}, data.Exposures)
+
+	mock.ExpectQuery("WITH ids AS").
+		WithArgs(days, hashes, []string(nil)).
+		WillReturnRows(pgxmock.NewRows([]string{"deleted"}).AddRow(2))
+	deleted, err := s.DeleteVisitorData(ctx, ids)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	// A hash sealed with a key that has been dropped can't be exported
+	gone := testKeyRing(t, "gone:"+testKey('c'))
+	mock.ExpectQuery("SELECT u.day, u.visitor_hash FROM unique_visitors").
+		WithArgs(days, hashes).
+		WillReturnRows(pgxmock.NewRows([]string{"day", "visitor_hash"}).AddRow(day, gone.sealHash("abc")))
+	_, err = s.GetVisitorData(ctx, ids)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_encryptVisitorHashes(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	ctx := context.Background()
+	old := testKeyRing(t, "old:"+testKey('a'))
+	ring := testKeyRing(t, "new:"+testKey('b')+",old:"+testKey('a'))
+
+	mock.ExpectQuery("SELECT day::text, visitor_hash FROM unique_visitors").
+		WithArgs("-infinity", "", "enc:new:%", encryptHashBatches).
+		WillReturnRows(pgxmock.NewRows([]string{"day", "visitor_hash"}).
+			AddRow("2024-03-04", "abc").
+			AddRow("2024-03-04", old.sealHash("def")))
+	mock.ExpectExec("DELETE FROM unique_visitors").
+		WithArgs(
+			[]string{"2024-03-04", "2024-03-04"},
+			[]string{"abc", old.sealHash("def")},
+			[]string{ring.sealHash("abc"), ring.sealHash("def")}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	mock.ExpectQuery("SELECT experiment, day::text, visitor_hash FROM experiment_exposures").
+		WithArgs("", "-infinity", "", "enc:new:%", encryptHashBatches).
+		WillReturnRows(pgxmock.NewRows([]string{"experiment", "day", "visitor_hash"}).
+			AddRow("cta", "2024-03-04", "abc"))
+	mock.ExpectExec("DELETE FROM experiment_exposures").
+		WithArgs([]string{"cta"}, []string{"2024-03-04"}, []string{"abc"}, []string{ring.sealHash("abc")}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	encrypted, err := encryptVisitorHashes(ctx, mock, ring)
+	require.NoError(t, err)
+	assert.Equal(t, 3, encrypted)
+
+	// A hash under a key no longer listed stops the startup rather than being lost
+	gone := testKeyRing(t, "gone:"+testKey('c'))
+	mock.ExpectQuery("SELECT day::text, visitor_hash FROM unique_visitors").
+		WithArgs("-infinity", "", "enc:new:%", encryptHashBatches).
+		WillReturnRows(pgxmock.NewRows([]string{"day", "visitor_hash"}).AddRow("2024-03-04", gone.sealHash("abc")))
+	_, err = encryptVisitorHashes(ctx, mock, ring)
+	assert.Error(t, err)
+
+	mock.ExpectQuery("SELECT day::text, visitor_hash FROM unique_visitors").
+		WithArgs("-infinity", "", "enc:new:%", encryptHashBatches).
+		WillReturnError(fmt.Errorf("query error"))
+	_, err = encryptVisitorHashes(ctx, mock, ring)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrNoFailoverTarget is returned by Failover when no other database is configured or
+// none of them answers.
+var ErrNoFailoverTarget = errors.New("no failover database available")
+
+// TargetObserver is called with the index of the database queries go to whenever it
+// changes: 0 for the primary, and 1 on for the entries of DATABASE_FAILOVER_URLS.
+type TargetObserver func(target int)
+
+// Called by failoverPool when it switches databases; see SetTargetObserver
+var targetObserver TargetObserver = func(int) {}
+
+// SetTargetObserver sets the func told which database is in use, such as to record a metric.
+// It is meant to be called once at startup, before SetupDatabase.
+func SetTargetObserver(o TargetObserver) {
+	targetObserver = o
+}
+
+// failoverPool sends queries to the primary database or, once Failover has been called,
+// to the first of the failover databases that answers, until FailBack finds the primary
+// up again. Failover databases are connected to when first needed.
+type failoverPool struct {
+	open func(ctx context.Context, target int) (DatabasePool, error)
+
+	mu     sync.RWMutex
+	pools  []DatabasePool // by target, nil until opened
+	active int
+}
+
+// newFailoverPool returns a pool using primary, with failovers more databases that open
+// connects to.
+func newFailoverPool(primary DatabasePool, failovers int, open func(ctx context.Context, target int) (DatabasePool, error)) *failoverPool {
+	pools := make([]DatabasePool, 1+failovers)
+	pools[0] = primary
+	targetObserver(0)
+	return &failoverPool{open: open, pools: pools}
+}
+
+func (p *failoverPool) current() DatabasePool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pools[p.active]
+}
+
+// Active returns the index of the database in use; 0 is the primary.
+func (p *failoverPool) Active() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.active
+}
+
+// Failover switches to the next failover database after the active one that answers a
+// ping, reporting ErrNoFailoverTarget if none does. Queries keep going to the active
+// database while the others are tried.
+func (p *failoverPool) Failover(ctx context.Context) error {
+	from := p.Active()
+	for target := from + 1; target < len(p.pools); target++ {
+		pool, err := p.target(ctx, target)
+		if err != nil {
+			logging.FromContext(ctx).Printf("Failed to connect to %s: %v", describeTarget(target), err)
+			continue
+		}
+		if _, err := pool.Exec(ctx, "SELECT 1"); err != nil {
+			logging.FromContext(ctx).Printf("Skipping %s, which is not answering: %v", describeTarget(target), err)
+			continue
+		}
+		logging.FromContext(ctx).Printf("Database failed over from %s to %s; writes are rejected until the primary returns", describeTarget(from), describeTarget(target))
+		p.switchTo(target)
+		return nil
+	}
+	return ErrNoFailoverTarget
+}
+
+// FailBack switches back to the primary if it answers a ping again, reporting whether it
+// did. It does nothing while the primary is in use.
+func (p *failoverPool) FailBack(ctx context.Context) bool {
+	from := p.Active()
+	if from == 0 {
+		return false
+	}
+	if _, err := p.pools[0].Exec(ctx, "SELECT 1"); err != nil {
+		return false
+	}
+	logging.FromContext(ctx).Printf("The primary database is answering again, failing back from %s", describeTarget(from))
+	p.switchTo(0)
+	return true
+}
+
+// target returns the pool for target, connecting to it the first time.
+func (p *failoverPool) target(ctx context.Context, target int) (DatabasePool, error) {
+	p.mu.RLock()
+	pool := p.pools[target]
+	p.mu.RUnlock()
+	if pool != nil {
+		return pool, nil
+	}
+
+	pool, err := p.open(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pools[target] != nil {
+		pool.Close()
+		return p.pools[target], nil
+	}
+	p.pools[target] = pool
+	return pool, nil
+}
+
+// switchTo sends queries to target, retiring the connections to the database left, which
+// may be dead or, when failing back, should stop serving reads.
+func (p *failoverPool) switchTo(target int) {
+	p.mu.Lock()
+	left := p.pools[p.active]
+	p.active = target
+	p.mu.Unlock()
+	resetPool(left)
+	targetObserver(target)
+}
+
+func (p *failoverPool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return p.current().Exec(ctx, sql, args...)
+}
+
+func (p *failoverPool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return p.current().QueryRow(ctx, sql, args...)
+}
+
+func (p *failoverPool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return p.current().Query(ctx, sql, args...)
+}
+
+func (p *failoverPool) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return p.current().CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+// Reset retires the active database's connections, for PostgresStore.ResetConnections
+func (p *failoverPool) Reset() {
+	resetPool(p.current())
+}
+
+// Close closes every database connected to
+func (p *failoverPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pool := range p.pools {
+		if pool != nil {
+			pool.Close()
+		}
+	}
+}
+
+func resetPool(pool DatabasePool) {
+	if r, ok := pool.(interface{ Reset() }); ok {
+		r.Reset()
+	}
+}
+
+// describeTarget names target for logs
+func describeTarget(target int) string {
+	if target == 0 {
+		return "the primary database"
+	}
+	return fmt.Sprintf("failover database %d", target)
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailoverPool(t *testing.T) {
+	primary, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer primary.Close()
+	unreachable, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer unreachable.Close()
+	replica, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer replica.Close()
+
+	var targets []int
+	saved := targetObserver
+	defer SetTargetObserver(saved)
+	SetTargetObserver(func(target int) { targets = append(targets, target) })
+
+	opened := map[int]int{}
+	pool := newFailoverPool(primary, 2, func(ctx context.Context, target int) (DatabasePool, error) {
+		opened[target]++
+		if target == 1 {
+			return unreachable, nil
+		}
+		return replica, nil
+	})
+	ctx := context.Background()
+
+	// Queries go to the primary, and failing back is a no-op
+	primary.ExpectExec("INSERT INTO visits").WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	_, err = pool.Exec(ctx, "INSERT INTO visits DEFAULT VALUES")
+	require.NoError(t, err)
+	assert.False(t, pool.FailBack(ctx))
+
+	// Failover skips a database that doesn't answer and retires the primary's connections
+	unreachable.ExpectExec("SELECT 1").WillReturnError(errors.New("connection refused"))
+	replica.ExpectExec("SELECT 1").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	primary.ExpectReset()
+	require.NoError(t, pool.Failover(ctx))
+	assert.Equal(t, 2, pool.Active())
+
+	replica.ExpectQuery("SELECT COUNT").WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(7))
+	var count int
+	require.NoError(t, pool.QueryRow(ctx, "SELECT COUNT(*) FROM visits").Scan(&count))
+	assert.Equal(t, 7, count)
+
+	// With no database left to try, it stays put
+	assert.ErrorIs(t, pool.Failover(ctx), ErrNoFailoverTarget)
+	assert.Equal(t, 2, pool.Active())
+
+	// It fails back once the primary answers
+	primary.ExpectExec("SELECT 1").WillReturnError(errors.New("connection refused"))
+	assert.False(t, pool.FailBack(ctx))
+	primary.ExpectExec("SELECT 1").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	replica.ExpectReset()
+	assert.True(t, pool.FailBack(ctx))
+	assert.Equal(t, 0, pool.Active())
+
+	// A failover database already connected to is reused
+	unreachable.ExpectExec("SELECT 1").WillReturnError(errors.New("connection refused"))
+	replica.ExpectExec("SELECT 1").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	primary.ExpectReset()
+	require.NoError(t, pool.Failover(ctx))
+	assert.Equal(t, map[int]int{1: 1, 2: 1}, opened)
+
+	assert.Equal(t, []int{0, 2, 0, 2}, targets)
+	for _, mock := range []pgxmock.PgxPoolIface{primary, unreachable, replica} {
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestSplitDSNList(t *testing.T) {
+	assert.Equal(t, []string{
+		"postgres://app@replica-a/resume",
+		"postgres://app@replica-b:5432,replica-c:5432/resume?sslmode=require",
+	}, splitDSNList(" postgres://app@replica-a/resume, postgres://app@replica-b:5432,replica-c:5432/resume?sslmode=require,"))
+	assert.Empty(t, splitDSNList(""))
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"resume-backend/internal/awsauth"
+)
+
+const (
+	// rdsTokenLifetime is how long an RDS auth token is valid; tokens are reused for
+	// rdsTokenReuse, leaving margin for the connection being slow to open
+	rdsTokenLifetime = 15 * time.Minute
+	rdsTokenReuse    = 10 * time.Minute
+
+	// gcpTokenMargin refreshes GCP access tokens this long before they expire
+	gcpTokenMargin = time.Minute
+
+	gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	iamTokenTimeout     = 10 * time.Second
+)
+
+// PasswordSource supplies the database password for each new connection, so short-lived
+// credentials are refreshed as the pool opens connections rather than fixed at startup.
+type PasswordSource interface {
+	Password(ctx context.Context) (string, error)
+}
+
+// envPassword reads DB_PASSWORD whenever a connection opens.
+type envPassword struct{}
+
+func (envPassword) Password(ctx context.Context) (string, error) {
+	return os.Getenv("DB_PASSWORD"), nil
+}
+
+// loadPasswordSource reads DB_IAM_AUTH: "aws" signs RDS IAM auth tokens with the AWS_*
+// credentials for AWS_REGION, and "gcp" uses the service account's access token from the
+// metadata server, as Cloud SQL IAM database authentication expects; DB_USER is then the
+// service account's database user and DB_HOST the instance's private IP or an Auth Proxy
+// sidecar. It returns nil when DB_IAM_AUTH is unset, leaving the password to DB_PASSWORD.
+func loadPasswordSource(user, host, port string) (PasswordSource, error) {
+	switch mode := os.Getenv("DB_IAM_AUTH"); mode {
+	case "":
+		return nil, nil
+	case "aws":
+		region, creds := awsauth.RegionFromEnv(), awsauth.CredentialsFromEnv()
+		if region == "" || !creds.Valid() {
+			return nil, fmt.Errorf("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required when DB_IAM_AUTH is aws")
+		}
+		return &rdsIAMAuth{endpoint: net.JoinHostPort(host, port), region: region, user: user, creds: creds, now: time.Now}, nil
+	case "gcp":
+		return &gcpIAMAuth{tokenURL: gcpMetadataTokenURL, client: &http.Client{Timeout: iamTokenTimeout}, now: time.Now}, nil
+	default:
+		return nil, fmt.Errorf("unknown DB_IAM_AUTH %q: must be aws or gcp", mode)
+	}
+}
+
+// rdsIAMAuth generates RDS IAM auth tokens, which are SigV4-presigned connect requests.
+type rdsIAMAuth struct {
+	endpoint string // host:port of the database
+	region   string
+	user     string
+	creds    awsauth.Credentials
+	now      func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Password returns a token signed within the last rdsTokenReuse.
+func (a *rdsIAMAuth) Password(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now().UTC()
+	if a.token == "" || !now.Before(a.expires) {
+		a.token, a.expires = a.sign(now), now.Add(rdsTokenReuse)
+	}
+	return a.token, nil
+}
+
+// sign presigns the connect request at now.
+func (a *rdsIAMAuth) sign(now time.Time) string {
+	query := url.Values{"Action": {"connect"}, "DBUser": {a.user}}
+	return a.endpoint + "/?" + awsauth.Presign(http.MethodGet, a.endpoint, "/", query, "rds-db", a.region, a.creds, now, rdsTokenLifetime)
+}
+
+// gcpIAMAuth uses the service account's OAuth2 access token as the password, which is how
+// Cloud SQL IAM database authentication logs in the service account's database user.
+type gcpIAMAuth struct {
+	tokenURL string
+	client   *http.Client
+	now      func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Password returns the cached access token, fetching a new one shortly before it expires.
+func (a *gcpIAMAuth) Password(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && a.now().Before(a.expires.Add(-gcpTokenMargin)) {
+		return a.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.tokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch access token: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d", res.StatusCode)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.AccessToken == "" {
+		return "", fmt.Errorf("invalid access token response: %v", err)
+	}
+	a.token, a.expires = body.AccessToken, a.now().Add(time.Duration(body.ExpiresIn)*time.Second)
+	return a.token, nil
+}
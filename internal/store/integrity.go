@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"resume-backend/internal/logging"
+)
+
+// IntegrityCheck is the outcome of one check VerifyIntegrity makes.
+type IntegrityCheck struct {
+	Name        string
+	Description string
+	Problems    int64 // rows or buckets found wrong
+	Repaired    bool  // whether the problems were repaired
+}
+
+// integrityCheck is a query counting the rows or buckets that fail a check, with the
+// statement repairing them. Repairs must leave nothing for the count to find.
+type integrityCheck struct {
+	name        string
+	description string
+	count       string
+	repair      string
+	skewed      bool // whether count and repair take the allowed clock skew in ms as $1
+}
+
+// integrityChecks run in order. Timestamps are fixed before the roll-ups are compared, so
+// the views are refreshed from corrected visits.
+var integrityChecks = []integrityCheck{
+	{
+		name:        "future_visits",
+		description: "visits dated in the future",
+		count:       `SELECT COUNT(*) FROM visits WHERE timestamp > CURRENT_TIMESTAMP + $1 * INTERVAL '1 millisecond'`,
+		repair:      `UPDATE visits SET timestamp = CURRENT_TIMESTAMP WHERE timestamp > CURRENT_TIMESTAMP + $1 * INTERVAL '1 millisecond'`,
+		skewed:      true,
+	},
+	{
+		name:        "future_events",
+		description: "events dated in the future",
+		count:       `SELECT COUNT(*) FROM events WHERE occurred_at > CURRENT_TIMESTAMP + $1 * INTERVAL '1 millisecond'`,
+		repair:      `UPDATE events SET occurred_at = CURRENT_TIMESTAMP WHERE occurred_at > CURRENT_TIMESTAMP + $1 * INTERVAL '1 millisecond'`,
+		skewed:      true,
+	},
+	{
+		name:        "future_sessions",
+		description: "sessions last seen in the future or before they started",
+		count:       `SELECT COUNT(*) FROM sessions WHERE last_seen > CURRENT_TIMESTAMP + $1 * INTERVAL '1 millisecond' OR last_seen < started_at`,
+		repair: `UPDATE sessions SET
+				started_at = LEAST(started_at, CURRENT_TIMESTAMP),
+				last_seen = GREATEST(LEAST(last_seen, CURRENT_TIMESTAMP), LEAST(started_at, CURRENT_TIMESTAMP))
+			WHERE last_seen > CURRENT_TIMESTAMP + $1 * INTERVAL '1 millisecond' OR last_seen < started_at`,
+		skewed: true,
+	},
+	{
+		// The pruner deletes expired keys every hour, so keys expired a day ago mean it has
+		// stopped; claims over them still succeed, but the table grows with every visitor
+		name:        "unswept_dedupe_keys",
+		description: "dedupe keys expired for over a day and not pruned",
+		count:       `SELECT COUNT(*) FROM dedupe_keys WHERE expires_at < CURRENT_TIMESTAMP - INTERVAL '1 day'`,
+		repair:      `DELETE FROM dedupe_keys WHERE expires_at <= CURRENT_TIMESTAMP`,
+	},
+	{
+		name:        "visits_rollup",
+		description: "quarter hours whose visits_by_quarter_hour count differs from the visits",
+		count: `SELECT COUNT(*) FROM (
+				SELECT to_timestamp(floor(extract(epoch FROM timestamp) / 900) * 900) AS bucket, COUNT(*) AS visits
+				FROM visits
+				WHERE timestamp IS NOT NULL
+				GROUP BY bucket
+			) AS raw
+			FULL OUTER JOIN visits_by_quarter_hour AS summary USING (bucket)
+			WHERE raw.visits IS DISTINCT FROM summary.visits`,
+		repair: `REFRESH MATERIALIZED VIEW CONCURRENTLY visits_by_quarter_hour`,
+	},
+	{
+		name:        "referrers_rollup",
+		description: "hours whose referrers_by_hour counts differ from the visits",
+		count: `SELECT COUNT(*) FROM (
+				SELECT date_trunc('hour', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS hour, referrer, COUNT(*) AS visits
+				FROM visits
+				WHERE timestamp IS NOT NULL AND referrer IS NOT NULL
+				GROUP BY hour, referrer
+			) AS raw
+			FULL OUTER JOIN referrers_by_hour AS summary USING (hour, referrer)
+			WHERE raw.visits IS DISTINCT FROM summary.visits`,
+		repair: `REFRESH MATERIALIZED VIEW CONCURRENTLY referrers_by_hour`,
+	},
+}
+
+// VerifyIntegrity cross-checks the stored data: timestamps no further in the future than
+// maxSkew, expired dedupe keys pruned, and the summary views matching the visits they roll
+// up. With repair set, problems found are fixed: future timestamps are moved to now, the
+// expired dedupe keys deleted and the views refreshed. The views lag the visits until
+// their next refresh, so recent visits show up as roll-up problems unless they were just
+// refreshed.
+func (s *PostgresStore) VerifyIntegrity(ctx context.Context, maxSkew time.Duration, repair bool) ([]IntegrityCheck, error) {
+	ctx = withoutQueryTimeout(ctx)
+	results := make([]IntegrityCheck, 0, len(integrityChecks))
+	for _, check := range integrityChecks {
+		result := IntegrityCheck{Name: check.name, Description: check.description}
+		var args []any
+		if check.skewed {
+			args = append(args, maxSkew.Milliseconds())
+		}
+		if err := s.pool.QueryRow(ctx, check.count, args...).Scan(&result.Problems); err != nil {
+			logging.FromContext(ctx).Printf("Error checking %s: %v", check.name, err)
+			return results, fmt.Errorf("failed to check %s: %w", check.name, err)
+		}
+		if repair && result.Problems > 0 {
+			if _, err := s.pool.Exec(ctx, check.repair, args...); err != nil {
+				logging.FromContext(ctx).Printf("Error repairing %s: %v", check.name, err)
+				return results, fmt.Errorf("failed to repair %s: %w", check.name, err)
+			}
+			result.Repaired = true
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
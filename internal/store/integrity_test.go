@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStore_VerifyIntegrity(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	skew := 5 * time.Minute
+
+	expectCount := func(pattern string, n int64, args ...any) {
+		mock.ExpectQuery(pattern).WithArgs(args...).WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(n))
+	}
+
+	// Only checks finding problems are repaired, the views once the visits are fixed
+	expectCount("SELECT COUNT\\(\\*\\) FROM visits WHERE timestamp >", 2, skew.Milliseconds())
+	mock.ExpectExec("UPDATE visits SET timestamp = CURRENT_TIMESTAMP").WithArgs(skew.Milliseconds()).WillReturnResult(pgxmock.NewResult("UPDATE", 2))
+	expectCount("SELECT COUNT\\(\\*\\) FROM events", 0, skew.Milliseconds())
+	expectCount("SELECT COUNT\\(\\*\\) FROM sessions", 0, skew.Milliseconds())
+	expectCount("SELECT COUNT\\(\\*\\) FROM dedupe_keys WHERE expires_at < CURRENT_TIMESTAMP - INTERVAL '1 day'", 1)
+	mock.ExpectExec("DELETE FROM dedupe_keys WHERE expires_at <= CURRENT_TIMESTAMP").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	expectCount("FULL OUTER JOIN visits_by_quarter_hour", 3)
+	mock.ExpectExec("REFRESH MATERIALIZED VIEW CONCURRENTLY visits_by_quarter_hour").WillReturnResult(pgxmock.NewResult("REFRESH", 0))
+	expectCount("FULL OUTER JOIN referrers_by_hour", 0)
+
+	results, err := s.VerifyIntegrity(ctx, skew, true)
+	require.NoError(t, err)
+	require.Len(t, results, len(integrityChecks))
+	assert.Equal(t, IntegrityCheck{Name: "future_visits", Description: "visits dated in the future", Problems: 2, Repaired: true}, results[0])
+	assert.False(t, results[1].Repaired)
+	assert.Equal(t, int64(1), results[3].Problems)
+	assert.True(t, results[4].Repaired)
+	assert.Equal(t, int64(0), results[5].Problems)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// Without repair it only reports, and stops at the first check that fails
+	expectCount("SELECT COUNT\\(\\*\\) FROM visits WHERE timestamp >", 2, skew.Milliseconds())
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM events").WithArgs(skew.Milliseconds()).WillReturnError(fmt.Errorf("canceling statement"))
+	results, err = s.VerifyIntegrity(ctx, skew, false)
+	assert.ErrorContains(t, err, "failed to check future_events")
+	assert.Equal(t, []IntegrityCheck{{Name: "future_visits", Description: "visits dated in the future", Problems: 2}}, results)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MaintenanceAction is a database upkeep task run by RunMaintenance
+type MaintenanceAction string
+
+const (
+	MaintenanceMigrate MaintenanceAction = "migrate" // run the schema steps, which skip what is already done
+	MaintenanceVacuum  MaintenanceAction = "vacuum"  // VACUUM (ANALYZE) each table
+	MaintenanceAnalyze MaintenanceAction = "analyze" // ANALYZE each table
+	MaintenanceReindex MaintenanceAction = "reindex" // rebuild each table's indexes without blocking writes
+)
+
+// MaintenanceActions lists every maintenance action
+var MaintenanceActions = []MaintenanceAction{MaintenanceMigrate, MaintenanceVacuum, MaintenanceAnalyze, MaintenanceReindex}
+
+// RunMaintenance runs action one step at a time, a schema step or a table, calling progress
+// with the name of each step that finishes. It stops at the first step that fails. Steps
+// may run past the query timeout.
+func (s *PostgresStore) RunMaintenance(ctx context.Context, action MaintenanceAction, progress func(step string, elapsed time.Duration)) error {
+	ctx = withoutQueryTimeout(ctx)
+	if action == MaintenanceMigrate {
+		for _, step := range schemaSteps {
+			started := time.Now()
+			if err := step(ctx, s.pool); err != nil {
+				logging.FromContext(ctx).Printf("Error running migrations: %v", err)
+				return fmt.Errorf("failed to run migrations: %w", err)
+			}
+			progress(schemaStepName(step), time.Since(started))
+		}
+		return nil
+	}
+
+	var command string
+	switch action {
+	case MaintenanceVacuum:
+		command = "VACUUM (ANALYZE) %s"
+	case MaintenanceAnalyze:
+		command = "ANALYZE %s"
+	case MaintenanceReindex:
+		command = "REINDEX TABLE CONCURRENTLY %s"
+	default:
+		return fmt.Errorf("unknown maintenance action %q", action)
+	}
+
+	tables, err := s.tables(ctx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		started := time.Now()
+		if _, err := s.pool.Exec(ctx, fmt.Sprintf(command, pgx.Identifier{table}.Sanitize())); err != nil {
+			logging.FromContext(ctx).Printf("Error running %s on %s: %v", action, table, err)
+			return fmt.Errorf("failed to %s %s: %w", action, table, err)
+		}
+		progress(table, time.Since(started))
+	}
+	return nil
+}
+
+// tables lists the tables in the current schema
+func (s *PostgresStore) tables(ctx context.Context) ([]string, error) {
+	rows, err := s.pool.Query(ctx, "SELECT tablename FROM pg_tables WHERE schemaname = current_schema() ORDER BY tablename")
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error listing tables: %v", err)
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read table names: %w", err)
+	}
+	return tables, nil
+}
+
+// schemaStepName returns the name of a schema step's function, such as createOutboxTable
+func schemaStepName(step func(ctx context.Context, pool DatabasePool) error) string {
+	name := runtime.FuncForPC(reflect.ValueOf(step).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStore_RunMaintenance(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	var steps []string
+	progress := func(step string, elapsed time.Duration) { steps = append(steps, step) }
+
+	tests := []struct {
+		action  MaintenanceAction
+		command string
+	}{
+		{MaintenanceVacuum, `VACUUM \(ANALYZE\) "%s"`},
+		{MaintenanceAnalyze, `ANALYZE "%s"`},
+		{MaintenanceReindex, `REINDEX TABLE CONCURRENTLY "%s"`},
+	}
+	for _, tt := range tests {
+		steps = nil
+		mock.ExpectQuery("SELECT tablename FROM pg_tables WHERE schemaname = current_schema\\(\\)").
+			WillReturnRows(pgxmock.NewRows([]string{"tablename"}).AddRow("outbox").AddRow("visits"))
+		mock.ExpectExec(fmt.Sprintf(tt.command, "outbox")).WillReturnResult(pgxmock.NewResult(string(tt.action), 0))
+		mock.ExpectExec(fmt.Sprintf(tt.command, "visits")).WillReturnResult(pgxmock.NewResult(string(tt.action), 0))
+		require.NoError(t, s.RunMaintenance(ctx, tt.action, progress))
+		assert.Equal(t, []string{"outbox", "visits"}, steps)
+	}
+
+	// A failing table stops the action
+	steps = nil
+	mock.ExpectQuery("SELECT tablename FROM pg_tables").
+		WillReturnRows(pgxmock.NewRows([]string{"tablename"}).AddRow("outbox").AddRow("visits"))
+	mock.ExpectExec(`VACUUM \(ANALYZE\) "outbox"`).WillReturnError(fmt.Errorf("canceling statement due to lock timeout"))
+	assert.Error(t, s.RunMaintenance(ctx, MaintenanceVacuum, progress))
+	assert.Empty(t, steps)
+
+	mock.ExpectQuery("SELECT tablename FROM pg_tables").WillReturnError(fmt.Errorf("query error"))
+	assert.Error(t, s.RunMaintenance(ctx, MaintenanceAnalyze, progress))
+
+	// Migrations report each schema step
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS visits").WillReturnError(fmt.Errorf("permission denied"))
+	assert.Error(t, s.RunMaintenance(ctx, MaintenanceMigrate, progress))
+
+	assert.Error(t, s.RunMaintenance(ctx, "defragment", progress))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSchemaStepName(t *testing.T) {
+	assert.Equal(t, "createTable", schemaStepName(schemaSteps[0]))
+	assert.Equal(t, "createOutboxTable", schemaStepName(createOutboxTable))
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"resume-backend/internal/logging"
+)
+
+// Monthly partitions of visits are named for their month, such as visits_y2024m03. The rows
+// stored before partitioning are kept in one partition named for the month it ends before,
+// such as visits_before_y2024m04, and rows outside every range land in visits_default.
+const visitPartitionMonth = "y2006m01"
+
+// partitionVisits turns visits into a table partitioned by month, if it is not already. The
+// old table is kept as a partition covering everything up to the end of its newest month, so
+// no rows are copied; it and its indexes are renamed, and the schema steps then recreate the
+// indexes on the partitioned table, reusing the old ones for the old partition. The summary
+// views would follow the renamed table, so they are dropped for the schema steps to recreate.
+// Replicas starting together serialize on an advisory lock held until the block commits, so
+// only the first converts the table and the others find it partitioned.
+const partitionVisits = `
+	DO $$
+	DECLARE
+		bound TIMESTAMP;
+		legacy TEXT;
+		idx TEXT;
+	BEGIN
+		PERFORM pg_advisory_xact_lock(hashtext('partition visits'));
+		IF EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'visits'::regclass) THEN
+			RETURN;
+		END IF;
+		LOCK TABLE visits IN ACCESS EXCLUSIVE MODE;
+		DROP MATERIALIZED VIEW IF EXISTS visits_by_quarter_hour, referrers_by_hour;
+
+		SELECT date_trunc('month', COALESCE(max(timestamp), CURRENT_TIMESTAMP) AT TIME ZONE 'UTC') + INTERVAL '1 month'
+			INTO bound FROM visits;
+		legacy := 'visits_before_' || to_char(bound, '"y"YYYY"m"MM');
+		EXECUTE format('ALTER TABLE visits RENAME TO %I', legacy);
+		FOR idx IN SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND tablename = legacy LOOP
+			EXECUTE format('ALTER INDEX %I RENAME TO %I', idx, legacy || substr(idx, length('visits') + 1));
+		END LOOP;
+		EXECUTE format('ALTER TABLE %I ALTER COLUMN timestamp SET NOT NULL', legacy);
+
+		EXECUTE format('CREATE TABLE visits (LIKE %I INCLUDING DEFAULTS, PRIMARY KEY (id, timestamp)) PARTITION BY RANGE (timestamp)', legacy);
+		ALTER SEQUENCE visits_id_seq OWNED BY visits.id;
+		CREATE TABLE visits_default PARTITION OF visits DEFAULT;
+		EXECUTE format('ALTER TABLE visits ATTACH PARTITION %I FOR VALUES FROM (MINVALUE) TO (%L)', legacy, bound AT TIME ZONE 'UTC');
+	END $$`
+
+// EnsureVisitPartitions partitions visits by month if it is not already, then creates the
+// partitions for the month of now and the ahead months after it, so visits never have to
+// land in the default partition. Partitioning an existing table locks it while the old rows
+// are checked, so the first call can take a while.
+func (s *PostgresStore) EnsureVisitPartitions(ctx context.Context, now time.Time, ahead int) error {
+	ctx = withoutQueryTimeout(ctx)
+	var partitioned bool
+	err := s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = 'visits'::regclass)").Scan(&partitioned)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error checking visits partitioning: %v", err)
+		return fmt.Errorf("failed to check visits partitioning: %w", err)
+	}
+	if !partitioned {
+		logging.FromContext(ctx).Printf("Partitioning the visits table by month")
+		if _, err := s.pool.Exec(ctx, partitionVisits); err != nil {
+			logging.FromContext(ctx).Printf("Error partitioning visits: %v", err)
+			return fmt.Errorf("failed to partition visits: %w", err)
+		}
+		if err := migrate(ctx, s.pool); err != nil {
+			logging.FromContext(ctx).Printf("Error indexing partitioned visits: %v", err)
+			return err
+		}
+	}
+
+	partitions, err := s.visitPartitions(ctx)
+	if err != nil {
+		return err
+	}
+	var covered time.Time // everything before this is in the partition of pre-partitioning rows
+	existing := map[time.Time]bool{}
+	for _, p := range partitions {
+		if p.before {
+			covered = maxTime(covered, p.end)
+		} else {
+			existing[p.start] = true
+		}
+	}
+
+	month := monthStart(now)
+	for i := 0; i <= ahead; i, month = i+1, month.AddDate(0, 1, 0) {
+		if month.Before(covered) || existing[month] {
+			continue
+		}
+		next := month.AddDate(0, 1, 0)
+		_, err := s.pool.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS visits_%s PARTITION OF visits FOR VALUES FROM ('%s') TO ('%s')",
+			month.Format(visitPartitionMonth), month.Format(time.RFC3339), next.Format(time.RFC3339)))
+		if err != nil {
+			logging.FromContext(ctx).Printf("Error creating visits partition for %s: %v", month.Format("2006-01"), err)
+			return fmt.Errorf("failed to create visits partition for %s: %w", month.Format("2006-01"), err)
+		}
+	}
+	return nil
+}
+
+// DropVisitPartitions drops the partitions of visits holding only visits from before before,
+// returning the names of those dropped. Dropping a partition is much cheaper than deleting
+// its rows, and leaves nothing to vacuum.
+func (s *PostgresStore) DropVisitPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	partitions, err := s.visitPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for _, p := range partitions {
+		if p.end.After(before) {
+			continue
+		}
+		if _, err := s.pool.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", p.name)); err != nil {
+			logging.FromContext(ctx).Printf("Error dropping visits partition %s: %v", p.name, err)
+			return dropped, fmt.Errorf("failed to drop visits partition %s: %w", p.name, err)
+		}
+		dropped = append(dropped, p.name)
+	}
+	return dropped, nil
+}
+
+// visitPartition is a partition of visits holding the visits from start until end
+type visitPartition struct {
+	name       string
+	start, end time.Time
+	before     bool // holds the rows from before partitioning, with no start
+}
+
+// visitPartitions lists the monthly partitions of visits, in no particular order. It is
+// empty when visits is not partitioned.
+func (s *PostgresStore) visitPartitions(ctx context.Context) ([]visitPartition, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'visits'::regclass`)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error listing visits partitions: %v", err)
+		return nil, fmt.Errorf("failed to list visits partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []visitPartition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan visits partition: %w", err)
+		}
+		if p, ok := parseVisitPartition(name); ok {
+			partitions = append(partitions, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read visits partitions: %w", err)
+	}
+	return partitions, nil
+}
+
+// parseVisitPartition reads a partition's range from its name. Partitions not named by
+// EnsureVisitPartitions, such as visits_default, are skipped.
+func parseVisitPartition(name string) (visitPartition, bool) {
+	if month, ok := strings.CutPrefix(name, "visits_before_"); ok {
+		end, err := time.Parse(visitPartitionMonth, month)
+		return visitPartition{name: name, end: end, before: true}, err == nil
+	}
+	if month, ok := strings.CutPrefix(name, "visits_"); ok {
+		start, err := time.Parse(visitPartitionMonth, month)
+		return visitPartition{name: name, start: start, end: start.AddDate(0, 1, 0)}, err == nil
+	}
+	return visitPartition{}, false
+}
+
+// monthStart returns the start of t's month in UTC
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
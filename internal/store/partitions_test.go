@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStore_EnsureVisitPartitions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	now := time.Date(2024, 3, 31, 23, 0, 0, 0, time.FixedZone("UTC-5", -5*3600)) // April in UTC
+
+	// Already partitioned: only missing months are created
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM pg_partitioned_table").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT c.relname FROM pg_inherits").
+		WillReturnRows(pgxmock.NewRows([]string{"relname"}).AddRow("visits_default").AddRow("visits_before_y2024m05").AddRow("visits_y2024m05").AddRow("visits_y2024m06"))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS visits_y2024m07 PARTITION OF visits FOR VALUES FROM \\('2024-07-01T00:00:00Z'\\) TO \\('2024-08-01T00:00:00Z'\\)").
+		WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+	require.NoError(t, s.EnsureVisitPartitions(ctx, now, 3))
+
+	// Not yet partitioned: the table is converted and the schema steps rerun
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM pg_partitioned_table").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	// The check is repeated under the lock, in case another replica got there first
+	mock.ExpectExec("PERFORM pg_advisory_xact_lock\\(hashtext\\('partition visits'\\)\\);\\s+IF EXISTS \\(SELECT 1 FROM pg_partitioned_table(.|\\s)+PARTITION BY RANGE \\(timestamp\\)").WillReturnResult(pgxmock.NewResult("DO", 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS visits").WillReturnError(fmt.Errorf("permission denied"))
+	assert.Error(t, s.EnsureVisitPartitions(ctx, now, 2))
+
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM pg_partitioned_table").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("PARTITION BY RANGE").WillReturnError(fmt.Errorf("column \"timestamp\" contains null values"))
+	assert.Error(t, s.EnsureVisitPartitions(ctx, now, 2))
+
+	// A failed partition is reported
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM pg_partitioned_table").
+		WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT c.relname FROM pg_inherits").
+		WillReturnRows(pgxmock.NewRows([]string{"relname"}).AddRow("visits_default"))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS visits_y2024m04").
+		WillReturnError(fmt.Errorf("updated partition constraint for default partition would be violated"))
+	assert.Error(t, s.EnsureVisitPartitions(ctx, now, 0))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_DropVisitPartitions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	partitions := func() *pgxmock.Rows {
+		return pgxmock.NewRows([]string{"relname"}).AddRow("visits_default").AddRow("visits_before_y2024m01").
+			AddRow("visits_y2024m01").AddRow("visits_y2024m02").AddRow("visits_y2024m03")
+	}
+
+	mock.ExpectQuery("SELECT c.relname FROM pg_inherits").WillReturnRows(partitions())
+	mock.ExpectExec("DROP TABLE IF EXISTS visits_before_y2024m01").WillReturnResult(pgxmock.NewResult("DROP TABLE", 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS visits_y2024m01").WillReturnResult(pgxmock.NewResult("DROP TABLE", 0))
+	dropped, err := s.DropVisitPartitions(ctx, time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"visits_before_y2024m01", "visits_y2024m01"}, dropped)
+
+	mock.ExpectQuery("SELECT c.relname FROM pg_inherits").WillReturnRows(partitions())
+	mock.ExpectExec("DROP TABLE IF EXISTS visits_before_y2024m01").WillReturnError(fmt.Errorf("lock timeout"))
+	_, err = s.DropVisitPartitions(ctx, time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC))
+	assert.Error(t, err)
+
+	mock.ExpectQuery("SELECT c.relname FROM pg_inherits").WillReturnError(fmt.Errorf("query error"))
+	_, err = s.DropVisitPartitions(ctx, time.Now())
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_parseVisitPartition(t *testing.T) {
+	p, ok := parseVisitPartition("visits_y2024m12")
+	assert.True(t, ok)
+	assert.Equal(t, visitPartition{name: "visits_y2024m12", start: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), end: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}, p)
+
+	p, ok = parseVisitPartition("visits_before_y2024m03")
+	assert.True(t, ok)
+	assert.Equal(t, visitPartition{name: "visits_before_y2024m03", end: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), before: true}, p)
+
+	for _, name := range []string{"visits_default", "visits_archive", "events"} {
+		_, ok := parseVisitPartition(name)
+		assert.False(t, ok, name)
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Post is a blog post, addressed by its slug. Its body is Markdown; a post without
+// PublishedAt is a draft, and one published in the future is scheduled.
+type Post struct {
+	ID          int64
+	Slug        string
+	Title       string
+	Summary     string
+	Body        string
+	Tags        []string
+	PublishedAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// createPostsTable creates the table of blog posts if it does not exist
+func createPostsTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS posts (
+			id BIGSERIAL PRIMARY KEY,
+			slug TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL,
+			summary TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL,
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			published_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS posts_published_at_idx ON posts (published_at DESC NULLS FIRST, id DESC)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create posts table: %w", err)
+	}
+	return nil
+}
+
+const postColumns = "id, slug, title, summary, body, tags, published_at, created_at, updated_at"
+
+// scanPost reads a row of postColumns.
+func scanPost(row pgx.Row) (Post, error) {
+	var p Post
+	if err := row.Scan(&p.ID, &p.Slug, &p.Title, &p.Summary, &p.Body, &p.Tags, &p.PublishedAt, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return Post{}, err
+	}
+	p.CreatedAt, p.UpdatedAt = p.CreatedAt.UTC(), p.UpdatedAt.UTC()
+	if p.PublishedAt != nil {
+		published := p.PublishedAt.UTC()
+		p.PublishedAt = &published
+	}
+	return p, nil
+}
+
+// ListPosts returns up to limit posts after skipping offset of them, newest first with drafts
+// ahead of the rest. A non-nil publishedBy limits them to the posts published by then; nil
+// lists every post, drafts included.
+func (s *PostgresStore) ListPosts(ctx context.Context, publishedBy *time.Time, limit, offset int) ([]Post, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+postColumns+` FROM posts
+		WHERE $1::timestamptz IS NULL OR published_at <= $1
+		ORDER BY published_at DESC NULLS FIRST, id DESC
+		LIMIT $2 OFFSET $3`, publishedBy, limit, offset)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error listing posts: %v", err)
+		return nil, fmt.Errorf("failed to list posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan post: %w", err)
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read posts: %w", err)
+	}
+	return posts, nil
+}
+
+// GetPost returns the post with slug, drafts included, reporting whether there is one.
+func (s *PostgresStore) GetPost(ctx context.Context, slug string) (Post, bool, error) {
+	p, err := scanPost(s.pool.QueryRow(ctx, "SELECT "+postColumns+" FROM posts WHERE slug = $1", slug))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Post{}, false, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting post: %v", err)
+		return Post{}, false, fmt.Errorf("failed to get post: %w", err)
+	}
+	return p, true, nil
+}
+
+// SavePost stores p under its slug, replacing the post there if there is one, and returns it
+// as stored, reporting whether it was created.
+func (s *PostgresStore) SavePost(ctx context.Context, p Post) (Post, bool, error) {
+	tags := p.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	var created bool
+	row := s.pool.QueryRow(ctx, `
+		INSERT INTO posts (slug, title, summary, body, tags, published_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (slug) DO UPDATE
+		SET title = EXCLUDED.title, summary = EXCLUDED.summary, body = EXCLUDED.body, tags = EXCLUDED.tags,
+			published_at = EXCLUDED.published_at, updated_at = CURRENT_TIMESTAMP
+		RETURNING `+postColumns+`, (xmax = 0) AS created`, p.Slug, p.Title, p.Summary, p.Body, tags, p.PublishedAt)
+	saved, err := scanPost(createdRow{row, &created})
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error saving post: %v", err)
+		return Post{}, false, fmt.Errorf("failed to save post: %w", err)
+	}
+	return saved, created, nil
+}
+
+// createdRow reads a trailing "inserted rather than updated" column after the ones scanned.
+type createdRow struct {
+	pgx.Row
+	created *bool
+}
+
+func (r createdRow) Scan(dest ...interface{}) error {
+	return r.Row.Scan(append(dest, r.created)...)
+}
+
+// DeletePost deletes the post with slug, reporting whether there was one.
+func (s *PostgresStore) DeletePost(ctx context.Context, slug string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM posts WHERE slug = $1", slug)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error deleting post: %v", err)
+		return false, fmt.Errorf("failed to delete post: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var postRowColumns = []string{"id", "slug", "title", "summary", "body", "tags", "published_at", "created_at", "updated_at"}
+
+func TestPostgresStore_ListPosts(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	now := created.Add(24 * time.Hour)
+
+	mock.ExpectQuery("WHERE \\$1::timestamptz IS NULL OR published_at <= \\$1\\s+ORDER BY published_at DESC NULLS FIRST, id DESC\\s+LIMIT \\$2 OFFSET \\$3").
+		WithArgs(&now, 11, 10).
+		WillReturnRows(pgxmock.NewRows(postRowColumns).
+			AddRow(int64(2), "second", "Second", "", "Body", []string{"go"}, &created, created, created).
+			AddRow(int64(1), "first", "First", "", "Body", []string{}, &created, created, created))
+	posts, err := s.ListPosts(ctx, &now, 11, 10)
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	assert.Equal(t, "second", posts[0].Slug)
+	assert.Equal(t, []string{"go"}, posts[0].Tags)
+
+	mock.ExpectQuery("FROM posts").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.ListPosts(ctx, nil, 10, 0)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetPost(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("FROM posts WHERE slug = \\$1").WithArgs("hello").
+		WillReturnRows(pgxmock.NewRows(postRowColumns).AddRow(int64(1), "hello", "Hello", "", "Body", []string{}, (*time.Time)(nil), created, created))
+	p, ok, err := s.GetPost(ctx, "hello")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, p.PublishedAt)
+
+	mock.ExpectQuery("FROM posts WHERE slug = \\$1").WithArgs("missing").WillReturnError(pgx.ErrNoRows)
+	_, ok, err = s.GetPost(ctx, "missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mock.ExpectQuery("FROM posts").WithArgs("hello").WillReturnError(fmt.Errorf("connection reset"))
+	_, _, err = s.GetPost(ctx, "hello")
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_SavePost(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	columns := append(postRowColumns, "created")
+
+	// Tags are never NULL, and the slug decides whether the post is created or replaced
+	mock.ExpectQuery("INSERT INTO posts \\(slug, title, summary, body, tags, published_at\\)\\s+VALUES \\(\\$1, \\$2, \\$3, \\$4, \\$5, \\$6\\)\\s+ON CONFLICT \\(slug\\) DO UPDATE").
+		WithArgs("hello", "Hello", "", "Body", []string{}, (*time.Time)(nil)).
+		WillReturnRows(pgxmock.NewRows(columns).AddRow(int64(1), "hello", "Hello", "", "Body", []string{}, (*time.Time)(nil), created, created, true))
+	p, ok, err := s.SavePost(ctx, Post{Slug: "hello", Title: "Hello", Body: "Body"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), p.ID)
+
+	mock.ExpectQuery("INSERT INTO posts").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows(columns).AddRow(int64(1), "hello", "Hello again", "", "Body", []string{}, &created, created, created, false))
+	p, ok, err = s.SavePost(ctx, Post{Slug: "hello", Title: "Hello again", Body: "Body", PublishedAt: &created})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, &created, p.PublishedAt)
+
+	mock.ExpectQuery("INSERT INTO posts").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("connection reset"))
+	_, _, err = s.SavePost(ctx, Post{Slug: "hello"})
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_DeletePost(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+
+	mock.ExpectExec("DELETE FROM posts WHERE slug = \\$1").WithArgs("hello").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	deleted, err := s.DeletePost(ctx, "hello")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	mock.ExpectExec("DELETE FROM posts").WithArgs("hello").WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	deleted, err = s.DeletePost(ctx, "hello")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	mock.ExpectExec("DELETE FROM posts").WithArgs("hello").WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.DeletePost(ctx, "hello")
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
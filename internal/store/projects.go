@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Project is one entry of the portfolio the resume site lists, in Position order
+type Project struct {
+	ID          int64
+	Title       string
+	Description string
+	Tags        []string
+	Links       []ProjectLink
+	Position    int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ProjectLink is a labelled link from a project, such as its repository or a demo
+type ProjectLink struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// createProjectsTable creates the table of portfolio projects if it does not exist
+func createProjectsTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS projects (
+			id BIGSERIAL PRIMARY KEY,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			links JSONB NOT NULL DEFAULT '[]',
+			position INT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS projects_position_idx ON projects (position, id)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create projects table: %w", err)
+	}
+	return nil
+}
+
+const projectColumns = "id, title, description, tags, links::text, position, created_at, updated_at"
+
+// scanProject reads a row of projectColumns.
+func scanProject(row pgx.Row) (Project, error) {
+	var p Project
+	var links string
+	if err := row.Scan(&p.ID, &p.Title, &p.Description, &p.Tags, &links, &p.Position, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return Project{}, err
+	}
+	if err := json.Unmarshal([]byte(links), &p.Links); err != nil {
+		return Project{}, fmt.Errorf("invalid links of project %d: %w", p.ID, err)
+	}
+	p.CreatedAt, p.UpdatedAt = p.CreatedAt.UTC(), p.UpdatedAt.UTC()
+	return p, nil
+}
+
+// projectArgs returns the writable columns of p as query arguments, never NULL.
+func projectArgs(p Project) ([]interface{}, error) {
+	tags := p.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	links := p.Links
+	if links == nil {
+		links = []ProjectLink{}
+	}
+	encoded, err := json.Marshal(links)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode project links: %w", err)
+	}
+	return []interface{}{p.Title, p.Description, tags, string(encoded), p.Position}, nil
+}
+
+// ListProjects returns every project in display order: by position, then oldest first.
+func (s *PostgresStore) ListProjects(ctx context.Context) ([]Project, error) {
+	rows, err := s.pool.Query(ctx, "SELECT "+projectColumns+" FROM projects ORDER BY position, id")
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error listing projects: %v", err)
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+	defer rows.Close()
+
+	var projects []Project
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read projects: %w", err)
+	}
+	return projects, nil
+}
+
+// GetProject returns the project with id, reporting whether there is one.
+func (s *PostgresStore) GetProject(ctx context.Context, id int64) (Project, bool, error) {
+	p, err := scanProject(s.pool.QueryRow(ctx, "SELECT "+projectColumns+" FROM projects WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Project{}, false, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting project: %v", err)
+		return Project{}, false, fmt.Errorf("failed to get project: %w", err)
+	}
+	return p, true, nil
+}
+
+// CreateProject stores p as a new project, ignoring its ID and timestamps, and returns it as
+// stored.
+func (s *PostgresStore) CreateProject(ctx context.Context, p Project) (Project, error) {
+	args, err := projectArgs(p)
+	if err != nil {
+		return Project{}, err
+	}
+	created, err := scanProject(s.pool.QueryRow(ctx, `
+		INSERT INTO projects (title, description, tags, links, position)
+		VALUES ($1, $2, $3, $4::jsonb, $5)
+		RETURNING `+projectColumns, args...))
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error creating project: %v", err)
+		return Project{}, fmt.Errorf("failed to create project: %w", err)
+	}
+	return created, nil
+}
+
+// UpdateProject replaces the project with p's ID by p and returns it as stored, reporting
+// whether there was one.
+func (s *PostgresStore) UpdateProject(ctx context.Context, p Project) (Project, bool, error) {
+	args, err := projectArgs(p)
+	if err != nil {
+		return Project{}, false, err
+	}
+	updated, err := scanProject(s.pool.QueryRow(ctx, `
+		UPDATE projects
+		SET title = $1, description = $2, tags = $3, links = $4::jsonb, position = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6
+		RETURNING `+projectColumns, append(args, p.ID)...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Project{}, false, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error updating project: %v", err)
+		return Project{}, false, fmt.Errorf("failed to update project: %w", err)
+	}
+	return updated, true, nil
+}
+
+// DeleteProject deletes the project with id, reporting whether there was one.
+func (s *PostgresStore) DeleteProject(ctx context.Context, id int64) (bool, error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM projects WHERE id = $1", id)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error deleting project: %v", err)
+		return false, fmt.Errorf("failed to delete project: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
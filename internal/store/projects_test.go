@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var projectRowColumns = []string{"id", "title", "description", "tags", "links", "position", "created_at", "updated_at"}
+
+func TestPostgresStore_ListProjects(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT id, title, description, tags, links::text, position, created_at, updated_at FROM projects ORDER BY position, id").
+		WillReturnRows(pgxmock.NewRows(projectRowColumns).
+			AddRow(int64(2), "resume-backend", "Visit counter", []string{"go", "postgres"}, `[{"url": "https://github.com/me/resume-backend", "label": "Source"}]`, 0, created, created).
+			AddRow(int64(1), "Blog", "", []string{}, `[]`, 1, created, created))
+	projects, err := s.ListProjects(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Project{
+		{ID: 2, Title: "resume-backend", Description: "Visit counter", Tags: []string{"go", "postgres"}, Links: []ProjectLink{{Label: "Source", URL: "https://github.com/me/resume-backend"}}, CreatedAt: created, UpdatedAt: created},
+		{ID: 1, Title: "Blog", Tags: []string{}, Links: []ProjectLink{}, Position: 1, CreatedAt: created, UpdatedAt: created},
+	}, projects)
+
+	mock.ExpectQuery("FROM projects").WillReturnError(fmt.Errorf("relation does not exist"))
+	_, err = s.ListProjects(ctx)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetProject(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("FROM projects WHERE id = \\$1").WithArgs(int64(2)).
+		WillReturnRows(pgxmock.NewRows(projectRowColumns).AddRow(int64(2), "Blog", "", []string{"writing"}, `[]`, 0, created, created))
+	p, ok, err := s.GetProject(ctx, 2)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Blog", p.Title)
+
+	// A missing project is not an error
+	mock.ExpectQuery("FROM projects WHERE id = \\$1").WithArgs(int64(3)).WillReturnError(pgx.ErrNoRows)
+	_, ok, err = s.GetProject(ctx, 3)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mock.ExpectQuery("FROM projects WHERE id = \\$1").WithArgs(int64(2)).WillReturnError(fmt.Errorf("connection reset"))
+	_, _, err = s.GetProject(ctx, 2)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_CreateProject(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	// Missing tags and links are stored empty rather than NULL
+	mock.ExpectQuery("INSERT INTO projects \\(title, description, tags, links, position\\)").
+		WithArgs("Blog", "Notes", []string{}, `[]`, 3).
+		WillReturnRows(pgxmock.NewRows(projectRowColumns).AddRow(int64(7), "Blog", "Notes", []string{}, `[]`, 3, created, created))
+	p, err := s.CreateProject(ctx, Project{ID: 99, Title: "Blog", Description: "Notes", Position: 3})
+	require.NoError(t, err)
+	assert.Equal(t, Project{ID: 7, Title: "Blog", Description: "Notes", Tags: []string{}, Links: []ProjectLink{}, Position: 3, CreatedAt: created, UpdatedAt: created}, p)
+
+	mock.ExpectQuery("INSERT INTO projects").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("value too long"))
+	_, err = s.CreateProject(ctx, Project{Title: "Blog"})
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_UpdateProject(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+	links := []ProjectLink{{Label: "Demo", URL: "https://example.com"}}
+
+	mock.ExpectQuery("UPDATE projects SET title = \\$1, .* WHERE id = \\$6").
+		WithArgs("Blog", "", []string{"writing"}, `[{"label":"Demo","url":"https://example.com"}]`, 0, int64(7)).
+		WillReturnRows(pgxmock.NewRows(projectRowColumns).AddRow(int64(7), "Blog", "", []string{"writing"}, `[{"label": "Demo", "url": "https://example.com"}]`, 0, created, updated))
+	p, ok, err := s.UpdateProject(ctx, Project{ID: 7, Title: "Blog", Tags: []string{"writing"}, Links: links})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, links, p.Links)
+	assert.Equal(t, updated, p.UpdatedAt)
+
+	mock.ExpectQuery("UPDATE projects").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), int64(8)).
+		WillReturnError(pgx.ErrNoRows)
+	_, ok, err = s.UpdateProject(ctx, Project{ID: 8, Title: "Blog"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mock.ExpectQuery("UPDATE projects").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("connection reset"))
+	_, _, err = s.UpdateProject(ctx, Project{ID: 7, Title: "Blog"})
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_DeleteProject(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+
+	mock.ExpectExec("DELETE FROM projects WHERE id = \\$1").WithArgs(int64(7)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	deleted, err := s.DeleteProject(ctx, 7)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	mock.ExpectExec("DELETE FROM projects").WithArgs(int64(7)).WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	deleted, err = s.DeleteProject(ctx, 7)
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	mock.ExpectExec("DELETE FROM projects").WithArgs(int64(7)).WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.DeleteProject(ctx, 7)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ResumeVersion is one version of the resume's structured content. There is at most one
+// draft, the version never published; the published version served is the one published
+// last, so publishing an older version again rolls back to it.
+type ResumeVersion struct {
+	ID          int64
+	Content     json.RawMessage
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	PublishedAt *time.Time // nil for the draft
+}
+
+// createResumeVersionsTable creates the table of resume content versions if it does not exist
+func createResumeVersionsTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS resume_versions (
+			id BIGSERIAL PRIMARY KEY,
+			content JSONB NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			published_at TIMESTAMPTZ
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS resume_versions_draft_idx ON resume_versions ((true)) WHERE published_at IS NULL;
+		CREATE INDEX IF NOT EXISTS resume_versions_published_at_idx ON resume_versions (published_at DESC) WHERE published_at IS NOT NULL`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create resume_versions table: %w", err)
+	}
+	return nil
+}
+
+const resumeVersionColumns = "id, content::text, created_at, updated_at, published_at"
+
+// scanResumeVersion reads a row of resumeVersionColumns.
+func scanResumeVersion(row pgx.Row) (ResumeVersion, error) {
+	var v ResumeVersion
+	var content string
+	if err := row.Scan(&v.ID, &content, &v.CreatedAt, &v.UpdatedAt, &v.PublishedAt); err != nil {
+		return ResumeVersion{}, err
+	}
+	v.Content = json.RawMessage(content)
+	v.CreatedAt, v.UpdatedAt = v.CreatedAt.UTC(), v.UpdatedAt.UTC()
+	if v.PublishedAt != nil {
+		published := v.PublishedAt.UTC()
+		v.PublishedAt = &published
+	}
+	return v, nil
+}
+
+// GetPublishedResume returns the resume version published last, reporting whether any has
+// been.
+func (s *PostgresStore) GetPublishedResume(ctx context.Context) (ResumeVersion, bool, error) {
+	v, err := scanResumeVersion(s.pool.QueryRow(ctx, `
+		SELECT `+resumeVersionColumns+` FROM resume_versions
+		WHERE published_at IS NOT NULL
+		ORDER BY published_at DESC, id DESC LIMIT 1`))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ResumeVersion{}, false, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting published resume: %v", err)
+		return ResumeVersion{}, false, fmt.Errorf("failed to get published resume: %w", err)
+	}
+	return v, true, nil
+}
+
+// GetResumeDraft returns the resume's draft, reporting whether there is one.
+func (s *PostgresStore) GetResumeDraft(ctx context.Context) (ResumeVersion, bool, error) {
+	v, err := scanResumeVersion(s.pool.QueryRow(ctx,
+		"SELECT "+resumeVersionColumns+" FROM resume_versions WHERE published_at IS NULL"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ResumeVersion{}, false, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting resume draft: %v", err)
+		return ResumeVersion{}, false, fmt.Errorf("failed to get resume draft: %w", err)
+	}
+	return v, true, nil
+}
+
+// SaveResumeDraft replaces the draft's content, starting a new draft when there is none.
+func (s *PostgresStore) SaveResumeDraft(ctx context.Context, content json.RawMessage) (ResumeVersion, error) {
+	v, err := scanResumeVersion(s.pool.QueryRow(ctx, `
+		INSERT INTO resume_versions (content) VALUES ($1::jsonb)
+		ON CONFLICT ((true)) WHERE published_at IS NULL
+		DO UPDATE SET content = EXCLUDED.content, updated_at = CURRENT_TIMESTAMP
+		RETURNING `+resumeVersionColumns, string(content)))
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error saving resume draft: %v", err)
+		return ResumeVersion{}, fmt.Errorf("failed to save resume draft: %w", err)
+	}
+	return v, nil
+}
+
+// PublishResumeVersion makes the version with id the published one, reporting whether there
+// is such a version. Publishing the draft ends it; the next save starts a new one.
+func (s *PostgresStore) PublishResumeVersion(ctx context.Context, id int64) (ResumeVersion, bool, error) {
+	v, err := scanResumeVersion(s.pool.QueryRow(ctx, `
+		UPDATE resume_versions SET published_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+resumeVersionColumns, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ResumeVersion{}, false, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error publishing resume version: %v", err)
+		return ResumeVersion{}, false, fmt.Errorf("failed to publish resume version: %w", err)
+	}
+	return v, true, nil
+}
+
+// ListResumeVersions returns up to limit resume versions, newest first.
+func (s *PostgresStore) ListResumeVersions(ctx context.Context, limit int) ([]ResumeVersion, error) {
+	rows, err := s.pool.Query(ctx,
+		"SELECT "+resumeVersionColumns+" FROM resume_versions ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error listing resume versions: %v", err)
+		return nil, fmt.Errorf("failed to list resume versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []ResumeVersion
+	for rows.Next() {
+		v, err := scanResumeVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan resume version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read resume versions: %w", err)
+	}
+	return versions, nil
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var resumeVersionRowColumns = []string{"id", "content", "created_at", "updated_at", "published_at"}
+
+func TestPostgresStore_GetPublishedResume(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	published := created.Add(time.Hour)
+
+	mock.ExpectQuery("WHERE published_at IS NOT NULL\\s+ORDER BY published_at DESC, id DESC LIMIT 1").
+		WillReturnRows(pgxmock.NewRows(resumeVersionRowColumns).AddRow(int64(3), `{"skills": []}`, created, created, &published))
+	v, ok, err := s.GetPublishedResume(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ResumeVersion{ID: 3, Content: json.RawMessage(`{"skills": []}`), CreatedAt: created, UpdatedAt: created, PublishedAt: &published}, v)
+
+	// Nothing published yet is not an error
+	mock.ExpectQuery("FROM resume_versions").WillReturnError(pgx.ErrNoRows)
+	_, ok, err = s.GetPublishedResume(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mock.ExpectQuery("FROM resume_versions").WillReturnError(fmt.Errorf("connection reset"))
+	_, _, err = s.GetPublishedResume(ctx)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetResumeDraft(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("FROM resume_versions WHERE published_at IS NULL").
+		WillReturnRows(pgxmock.NewRows(resumeVersionRowColumns).AddRow(int64(4), `{}`, created, created, (*time.Time)(nil)))
+	v, ok, err := s.GetResumeDraft(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, v.PublishedAt)
+
+	mock.ExpectQuery("FROM resume_versions WHERE published_at IS NULL").WillReturnError(pgx.ErrNoRows)
+	_, ok, err = s.GetResumeDraft(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mock.ExpectQuery("FROM resume_versions").WillReturnError(fmt.Errorf("connection reset"))
+	_, _, err = s.GetResumeDraft(ctx)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_SaveResumeDraft(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	content := json.RawMessage(`{"skills":[{"name":"Go"}]}`)
+
+	// The one draft is updated in place
+	mock.ExpectQuery("INSERT INTO resume_versions \\(content\\) VALUES \\(\\$1::jsonb\\)\\s+ON CONFLICT \\(\\(true\\)\\) WHERE published_at IS NULL").
+		WithArgs(string(content)).
+		WillReturnRows(pgxmock.NewRows(resumeVersionRowColumns).AddRow(int64(4), string(content), created, created.Add(time.Minute), (*time.Time)(nil)))
+	v, err := s.SaveResumeDraft(ctx, content)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), v.ID)
+	assert.JSONEq(t, string(content), string(v.Content))
+
+	mock.ExpectQuery("INSERT INTO resume_versions").WithArgs(pgxmock.AnyArg()).WillReturnError(fmt.Errorf("invalid input syntax for type json"))
+	_, err = s.SaveResumeDraft(ctx, content)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_PublishResumeVersion(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	published := created.Add(time.Hour)
+
+	mock.ExpectQuery("UPDATE resume_versions SET published_at = CURRENT_TIMESTAMP\\s+WHERE id = \\$1").WithArgs(int64(4)).
+		WillReturnRows(pgxmock.NewRows(resumeVersionRowColumns).AddRow(int64(4), `{}`, created, created, &published))
+	v, ok, err := s.PublishResumeVersion(ctx, 4)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, &published, v.PublishedAt)
+
+	mock.ExpectQuery("UPDATE resume_versions").WithArgs(int64(9)).WillReturnError(pgx.ErrNoRows)
+	_, ok, err = s.PublishResumeVersion(ctx, 9)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mock.ExpectQuery("UPDATE resume_versions").WithArgs(int64(4)).WillReturnError(fmt.Errorf("connection reset"))
+	_, _, err = s.PublishResumeVersion(ctx, 4)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_ListResumeVersions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	published := created.Add(time.Hour)
+
+	mock.ExpectQuery("FROM resume_versions ORDER BY id DESC LIMIT \\$1").WithArgs(20).
+		WillReturnRows(pgxmock.NewRows(resumeVersionRowColumns).
+			AddRow(int64(4), `{}`, created, created, (*time.Time)(nil)).
+			AddRow(int64(3), `{}`, created, created, &published))
+	versions, err := s.ListResumeVersions(ctx, 20)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Nil(t, versions[0].PublishedAt)
+	assert.Equal(t, &published, versions[1].PublishedAt)
+
+	mock.ExpectQuery("FROM resume_versions").WithArgs(20).WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.ListResumeVersions(ctx, 20)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
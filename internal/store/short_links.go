@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ShortLink redirects its slug to Target; the target can change after the link is shared.
+type ShortLink struct {
+	Slug      string
+	Target    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// createShortLinksTable creates the table of short links if it does not exist
+func createShortLinksTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS short_links (
+			slug TEXT PRIMARY KEY,
+			target TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create short_links table: %w", err)
+	}
+	return nil
+}
+
+const shortLinkColumns = "slug, target, created_at, updated_at"
+
+// scanShortLink reads a row of shortLinkColumns.
+func scanShortLink(row pgx.Row) (ShortLink, error) {
+	var l ShortLink
+	if err := row.Scan(&l.Slug, &l.Target, &l.CreatedAt, &l.UpdatedAt); err != nil {
+		return ShortLink{}, err
+	}
+	l.CreatedAt, l.UpdatedAt = l.CreatedAt.UTC(), l.UpdatedAt.UTC()
+	return l, nil
+}
+
+// ListShortLinks returns every short link by slug.
+func (s *PostgresStore) ListShortLinks(ctx context.Context) ([]ShortLink, error) {
+	rows, err := s.pool.Query(ctx, "SELECT "+shortLinkColumns+" FROM short_links ORDER BY slug")
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error listing short links: %v", err)
+		return nil, fmt.Errorf("failed to list short links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []ShortLink
+	for rows.Next() {
+		l, err := scanShortLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan short link: %w", err)
+		}
+		links = append(links, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read short links: %w", err)
+	}
+	return links, nil
+}
+
+// GetShortLink returns the short link with slug, reporting whether there is one.
+func (s *PostgresStore) GetShortLink(ctx context.Context, slug string) (ShortLink, bool, error) {
+	l, err := scanShortLink(s.pool.QueryRow(ctx, "SELECT "+shortLinkColumns+" FROM short_links WHERE slug = $1", slug))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ShortLink{}, false, nil
+	}
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting short link: %v", err)
+		return ShortLink{}, false, fmt.Errorf("failed to get short link: %w", err)
+	}
+	return l, true, nil
+}
+
+// SaveShortLink stores l under its slug, replacing the link there if there is one, and returns
+// it as stored, reporting whether it was created.
+func (s *PostgresStore) SaveShortLink(ctx context.Context, l ShortLink) (ShortLink, bool, error) {
+	var created bool
+	row := s.pool.QueryRow(ctx, `
+		INSERT INTO short_links (slug, target) VALUES ($1, $2)
+		ON CONFLICT (slug) DO UPDATE SET target = EXCLUDED.target, updated_at = CURRENT_TIMESTAMP
+		RETURNING `+shortLinkColumns+`, (xmax = 0) AS created`, l.Slug, l.Target)
+	saved, err := scanShortLink(createdRow{row, &created})
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error saving short link: %v", err)
+		return ShortLink{}, false, fmt.Errorf("failed to save short link: %w", err)
+	}
+	return saved, created, nil
+}
+
+// DeleteShortLink deletes the short link with slug, reporting whether there was one.
+func (s *PostgresStore) DeleteShortLink(ctx context.Context, slug string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM short_links WHERE slug = $1", slug)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error deleting short link: %v", err)
+		return false, fmt.Errorf("failed to delete short link: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var shortLinkRowColumns = []string{"slug", "target", "created_at", "updated_at"}
+
+func TestPostgresStore_ListShortLinks(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("FROM short_links ORDER BY slug").
+		WillReturnRows(pgxmock.NewRows(shortLinkRowColumns).
+			AddRow("cv", "https://example.com/resume.pdf", created, created).
+			AddRow("gh", "https://github.com/me", created, created))
+	links, err := s.ListShortLinks(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []ShortLink{
+		{Slug: "cv", Target: "https://example.com/resume.pdf", CreatedAt: created, UpdatedAt: created},
+		{Slug: "gh", Target: "https://github.com/me", CreatedAt: created, UpdatedAt: created},
+	}, links)
+
+	mock.ExpectQuery("FROM short_links").WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.ListShortLinks(ctx)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetShortLink(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("FROM short_links WHERE slug = \\$1").WithArgs("cv").
+		WillReturnRows(pgxmock.NewRows(shortLinkRowColumns).AddRow("cv", "https://example.com/resume.pdf", created, created))
+	l, ok, err := s.GetShortLink(ctx, "cv")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com/resume.pdf", l.Target)
+
+	mock.ExpectQuery("FROM short_links WHERE slug = \\$1").WithArgs("missing").WillReturnError(pgx.ErrNoRows)
+	_, ok, err = s.GetShortLink(ctx, "missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mock.ExpectQuery("FROM short_links").WithArgs("cv").WillReturnError(fmt.Errorf("connection reset"))
+	_, _, err = s.GetShortLink(ctx, "cv")
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_SaveShortLink(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	columns := append(shortLinkRowColumns, "created")
+
+	mock.ExpectQuery("INSERT INTO short_links \\(slug, target\\) VALUES \\(\\$1, \\$2\\)\\s+ON CONFLICT \\(slug\\) DO UPDATE").
+		WithArgs("cv", "https://example.com/resume.pdf").
+		WillReturnRows(pgxmock.NewRows(columns).AddRow("cv", "https://example.com/resume.pdf", created, created, true))
+	l, ok, err := s.SaveShortLink(ctx, ShortLink{Slug: "cv", Target: "https://example.com/resume.pdf"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "cv", l.Slug)
+
+	mock.ExpectQuery("INSERT INTO short_links").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnError(fmt.Errorf("connection reset"))
+	_, _, err = s.SaveShortLink(ctx, ShortLink{Slug: "cv"})
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_DeleteShortLink(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+
+	mock.ExpectExec("DELETE FROM short_links WHERE slug = \\$1").WithArgs("cv").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	deleted, err := s.DeleteShortLink(ctx, "cv")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	mock.ExpectExec("DELETE FROM short_links").WithArgs("cv").WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.DeleteShortLink(ctx, "cv")
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	defaultSlowQueryThreshold = 500 * time.Millisecond
+	defaultQueryTimeout       = 30 * time.Second
+)
+
+// queryLimits configures the checks timedPool makes on each query. A zero value turns the
+// check off.
+type queryLimits struct {
+	SlowThreshold time.Duration // log queries that take at least this long
+	Timeout       time.Duration // cancel queries still running after this long
+}
+
+// loadQueryLimits reads DB_SLOW_QUERY_THRESHOLD (default 500ms) and DB_QUERY_TIMEOUT
+// (default 30s); "0" turns either off.
+func loadQueryLimits() (queryLimits, error) {
+	limits := queryLimits{SlowThreshold: defaultSlowQueryThreshold, Timeout: defaultQueryTimeout}
+	for name, d := range map[string]*time.Duration{
+		"DB_SLOW_QUERY_THRESHOLD": &limits.SlowThreshold,
+		"DB_QUERY_TIMEOUT":        &limits.Timeout,
+	} {
+		parsed, ok, err := parseQueryLimit(name)
+		if err != nil {
+			return queryLimits{}, err
+		}
+		if ok {
+			*d = parsed
+		}
+	}
+	return limits, nil
+}
+
+// QueryTimeout returns DB_QUERY_TIMEOUT, the longest a query may run, or 0 when it is off.
+// SetupDatabase has already rejected an invalid value.
+func QueryTimeout() time.Duration {
+	limits, _ := loadQueryLimits()
+	return limits.Timeout
+}
+
+// parseQueryLimit reads the duration in the variable name, reporting false when it is unset.
+func parseQueryLimit(name string) (time.Duration, bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, false, nil
+	}
+	parsed, err := time.ParseDuration(v)
+	if err != nil || parsed < 0 {
+		return 0, false, fmt.Errorf("invalid %s %q: must be a duration such as 2s", name, v)
+	}
+	return parsed, true, nil
+}
+
+type noQueryTimeoutKey struct{}
+
+// withoutQueryTimeout marks ctx so its queries may run past the query timeout, for
+// maintenance that is expected to take long on big tables.
+func withoutQueryTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noQueryTimeoutKey{}, true)
+}
+
+// timedPool wraps a pool, logging slow queries with their SQL, but not their arguments,
+// which may hold visitor data, and cancelling queries that run past the timeout. A query
+// is timed until its rows are closed or its row is scanned, so slow reads count too. Bulk
+// loads with CopyFrom pass through untimed, as they are meant to be long.
+type timedPool struct {
+	DatabasePool
+	limits queryLimits
+}
+
+// newTimedPool returns pool with limits applied to every query.
+func newTimedPool(pool DatabasePool, limits queryLimits) DatabasePool {
+	return &timedPool{DatabasePool: pool, limits: limits}
+}
+
+// start returns the context to run a query under and a func to call once it finishes
+func (p *timedPool) start(ctx context.Context, sql string) (context.Context, func(err error)) {
+	started := time.Now()
+	cancel := context.CancelFunc(func() {})
+	if p.limits.Timeout > 0 && ctx.Value(noQueryTimeoutKey{}) == nil {
+		ctx, cancel = context.WithTimeout(ctx, p.limits.Timeout)
+	}
+	return ctx, func(err error) {
+		elapsed := time.Since(started)
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		cancel()
+		switch {
+		case timedOut && err != nil:
+			logging.FromContext(ctx).Printf("Query timed out after %s: %s", elapsed.Round(time.Millisecond), sqlTemplate(sql))
+		case p.limits.SlowThreshold > 0 && elapsed >= p.limits.SlowThreshold:
+			logging.FromContext(ctx).Printf("Slow query took %s: %s", elapsed.Round(time.Millisecond), sqlTemplate(sql))
+		}
+	}
+}
+
+func (p *timedPool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, done := p.start(ctx, sql)
+	tag, err := p.DatabasePool.Exec(ctx, sql, args...)
+	done(err)
+	return tag, err
+}
+
+func (p *timedPool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	ctx, done := p.start(ctx, sql)
+	rows, err := p.DatabasePool.Query(ctx, sql, args...)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	return &timedRows{Rows: rows, done: done}, nil
+}
+
+func (p *timedPool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	ctx, done := p.start(ctx, sql)
+	return &timedRow{row: p.DatabasePool.QueryRow(ctx, sql, args...), done: done}
+}
+
+// Reset passes through to the pool, for PostgresStore.ResetConnections
+func (p *timedPool) Reset() {
+	if r, ok := p.DatabasePool.(interface{ Reset() }); ok {
+		r.Reset()
+	}
+}
+
+// Failover passes through to the pool, for PostgresStore.Failover
+func (p *timedPool) Failover(ctx context.Context) error {
+	if f, ok := p.DatabasePool.(interface{ Failover(context.Context) error }); ok {
+		return f.Failover(ctx)
+	}
+	return ErrNoFailoverTarget
+}
+
+// FailBack passes through to the pool, for PostgresStore.FailBack
+func (p *timedPool) FailBack(ctx context.Context) bool {
+	if f, ok := p.DatabasePool.(interface{ FailBack(context.Context) bool }); ok {
+		return f.FailBack(ctx)
+	}
+	return false
+}
+
+// timedRows finishes timing its query when closed
+type timedRows struct {
+	pgx.Rows
+	done   func(err error)
+	closed bool
+}
+
+func (r *timedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	// pgx closes rows once they are read to the end
+	r.finish()
+	return false
+}
+
+func (r *timedRows) Close() {
+	r.Rows.Close()
+	r.finish()
+}
+
+func (r *timedRows) finish() {
+	if !r.closed {
+		r.closed = true
+		r.done(r.Rows.Err())
+	}
+}
+
+// timedRow finishes timing its query when scanned
+type timedRow struct {
+	row  pgx.Row
+	done func(err error)
+}
+
+func (r *timedRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		r.done(nil)
+	} else {
+		r.done(err)
+	}
+	return err
+}
+
+// sqlTemplate collapses a query's whitespace so it logs on one line
+func sqlTemplate(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestTimedPool(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	logger := &recordingLogger{}
+	ctx := logging.NewContext(context.Background(), logger)
+	pool := newTimedPool(mock, queryLimits{SlowThreshold: 20 * time.Millisecond, Timeout: 100 * time.Millisecond})
+
+	// Fast queries are not logged
+	mock.ExpectExec("DELETE FROM visits").WithArgs(pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	_, err = pool.Exec(ctx, "DELETE FROM visits WHERE timestamp < $1", time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, logger.lines)
+
+	// Slow queries log their SQL on one line, without their arguments
+	mock.ExpectQuery("SELECT COUNT").WithArgs("/secret").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1)).WillDelayFor(30 * time.Millisecond)
+	var count int
+	require.NoError(t, pool.QueryRow(ctx, "SELECT COUNT(*)\n\t\tFROM visits WHERE page = $1", "/secret").Scan(&count))
+	require.Len(t, logger.lines, 1)
+	assert.Contains(t, logger.lines[0], "Slow query took")
+	assert.Contains(t, logger.lines[0], "SELECT COUNT(*) FROM visits WHERE page = $1")
+	assert.NotContains(t, logger.lines[0], "/secret")
+
+	// Queries are cancelled at the timeout
+	logger.lines = nil
+	mock.ExpectExec("UPDATE outbox").WillReturnResult(pgxmock.NewResult("UPDATE", 1)).WillDelayFor(time.Second)
+	started := time.Now()
+	_, err = pool.Exec(ctx, "UPDATE outbox SET attempts = 0")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(started), time.Second)
+	require.Len(t, logger.lines, 1)
+	assert.True(t, strings.HasPrefix(logger.lines[0], "Query timed out after"), logger.lines[0])
+
+	// Maintenance is exempt from the timeout
+	mock.ExpectExec("VACUUM").WillReturnResult(pgxmock.NewResult("VACUUM", 0)).WillDelayFor(150 * time.Millisecond)
+	_, err = pool.Exec(withoutQueryTimeout(ctx), "VACUUM visits")
+	assert.NoError(t, err)
+
+	// Rows are timed until read to the end
+	logger.lines = nil
+	mock.ExpectQuery("SELECT page").WillReturnRows(pgxmock.NewRows([]string{"page"}).AddRow("/a").AddRow("/b"))
+	rows, err := pool.Query(ctx, "SELECT page FROM visits")
+	require.NoError(t, err)
+	for rows.Next() {
+		time.Sleep(15 * time.Millisecond)
+	}
+	rows.Close()
+	require.Len(t, logger.lines, 1)
+	assert.Contains(t, logger.lines[0], "SELECT page FROM visits")
+
+	// A missing row is not a failure
+	logger.lines = nil
+	mock.ExpectQuery("SELECT id").WillReturnError(pgx.ErrNoRows)
+	assert.True(t, errors.Is(pool.QueryRow(ctx, "SELECT id FROM outbox").Scan(&count), pgx.ErrNoRows))
+	assert.Empty(t, logger.lines)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTimedPool_Reset(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	mock.ExpectReset()
+	NewPostgresStore(newTimedPool(mock, queryLimits{})).ResetConnections()
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_loadQueryLimits(t *testing.T) {
+	limits, err := loadQueryLimits()
+	require.NoError(t, err)
+	assert.Equal(t, queryLimits{SlowThreshold: defaultSlowQueryThreshold, Timeout: defaultQueryTimeout}, limits)
+
+	t.Setenv("DB_SLOW_QUERY_THRESHOLD", "2s")
+	t.Setenv("DB_QUERY_TIMEOUT", "0")
+	limits, err = loadQueryLimits()
+	require.NoError(t, err)
+	assert.Equal(t, queryLimits{SlowThreshold: 2 * time.Second}, limits)
+
+	t.Setenv("DB_QUERY_TIMEOUT", "soon")
+	_, err = loadQueryLimits()
+	assert.Error(t, err)
+}
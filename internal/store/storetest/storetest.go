@@ -0,0 +1,118 @@
+// Package storetest checks that DataStore implementations honour context cancellation.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"resume-backend/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Returned by canceledPool for queries made with a context that is still live
+var errLiveContext = errors.New("query made with a live context")
+
+// canceledPool is a DatabasePool that fails every call with the context's error. It reports
+// calls made with a live context, which means a store dropped its caller's context.
+type canceledPool struct {
+	t      testing.TB
+	method string
+}
+
+func (p *canceledPool) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.t.Errorf("%s queried the database without the caller's context", p.method)
+	return errLiveContext
+}
+
+func (p *canceledPool) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, p.check(ctx)
+}
+
+func (p *canceledPool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return errRow{p.check(ctx)}
+}
+
+func (p *canceledPool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return nil, p.check(ctx)
+}
+
+func (p *canceledPool) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return 0, p.check(ctx)
+}
+
+func (p *canceledPool) Close() {}
+
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...interface{}) error {
+	return r.err
+}
+
+// CheckCancellation calls every DataStore method, except Close, on the store built by
+// newStore with an already canceled context. Each method must take the context as its first
+// argument, pass it down to the pool and return an error wrapping context.Canceled.
+func CheckCancellation(t *testing.T, newStore func(pool store.DatabasePool) store.DataStore) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	contextType := reflect.TypeOf((*context.Context)(nil)).Elem()
+	storeType := reflect.TypeOf((*store.DataStore)(nil)).Elem()
+	for i := 0; i < storeType.NumMethod(); i++ {
+		method := storeType.Method(i)
+		if method.Name == "Close" {
+			continue
+		}
+		t.Run(method.Name, func(t *testing.T) {
+			if method.Type.NumIn() == 0 || method.Type.In(0) != contextType {
+				t.Fatalf("%s must take a context.Context as its first argument", method.Name)
+			}
+			args := []reflect.Value{reflect.ValueOf(ctx)}
+			for j := 1; j < method.Type.NumIn(); j++ {
+				args = append(args, argumentFor(method.Type.In(j)))
+			}
+
+			ds := newStore(&canceledPool{t: t, method: method.Name})
+			out := reflect.ValueOf(ds).MethodByName(method.Name).Call(args)
+			err, _ := out[len(out)-1].Interface().(error)
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("%s returned %v for a canceled context, want context.Canceled", method.Name, err)
+			}
+		})
+	}
+}
+
+// argumentFor returns a plausible argument of type t, so methods get past input checks to
+// their queries.
+func argumentFor(t reflect.Type) reflect.Value {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return reflect.ValueOf(time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC))
+	case reflect.TypeOf(time.UTC):
+		return reflect.ValueOf(time.UTC)
+	case reflect.TypeOf([]string(nil)):
+		return reflect.ValueOf([]string{"viewed_resume", "clicked_github"})
+	case reflect.TypeOf([]store.TimeRange(nil)):
+		return reflect.ValueOf([]store.TimeRange{{From: time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)}})
+	case reflect.TypeOf([]store.Visit(nil)):
+		return reflect.ValueOf([]store.Visit{{Timestamp: time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC), Page: "blog"}})
+	case reflect.TypeOf([]store.OutboxMessage(nil)):
+		return reflect.ValueOf([]store.OutboxMessage{{Destination: "webhook", Payload: []byte(`{}`)}})
+	case reflect.TypeOf(json.RawMessage(nil)):
+		return reflect.ValueOf(json.RawMessage(`{"skills": []}`))
+	case reflect.TypeOf(store.MaintenanceAction("")):
+		return reflect.ValueOf(store.MaintenanceVacuum)
+	}
+	return reflect.Zero(t)
+}
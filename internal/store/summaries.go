@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"resume-backend/internal/logging"
+)
+
+// summaryViews are the materialized views of visits, refreshed by RefreshSummaries. Visits
+// are counted per quarter hour, which lines up with every timezone's days.
+var summaryViews = []string{"visits_by_quarter_hour", "referrers_by_hour"}
+
+// createSummaryViews creates the materialized views behind the summarized stats. Each has a
+// unique index so it can be refreshed without blocking reads.
+func createSummaryViews(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE MATERIALIZED VIEW IF NOT EXISTS visits_by_quarter_hour AS
+			SELECT to_timestamp(floor(extract(epoch FROM timestamp) / 900) * 900) AS bucket, COUNT(*) AS visits
+			FROM visits
+			WHERE timestamp IS NOT NULL
+			GROUP BY bucket;
+		CREATE UNIQUE INDEX IF NOT EXISTS visits_by_quarter_hour_bucket_idx ON visits_by_quarter_hour (bucket);
+		CREATE MATERIALIZED VIEW IF NOT EXISTS referrers_by_hour AS
+			SELECT date_trunc('hour', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS hour, referrer, COUNT(*) AS visits
+			FROM visits
+			WHERE timestamp IS NOT NULL AND referrer IS NOT NULL
+			GROUP BY hour, referrer;
+		CREATE UNIQUE INDEX IF NOT EXISTS referrers_by_hour_hour_referrer_idx ON referrers_by_hour (hour, referrer)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create summary views: %w", err)
+	}
+	return nil
+}
+
+// RefreshSummaries recomputes the summary views from visits. Reads carry on against the
+// previous contents while a view refreshes.
+func (s *PostgresStore) RefreshSummaries(ctx context.Context) error {
+	ctx = withoutQueryTimeout(ctx)
+	for _, view := range summaryViews {
+		if _, err := s.pool.Exec(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY "+view); err != nil {
+			logging.FromContext(ctx).Printf("Error refreshing %s: %v", view, err)
+			return fmt.Errorf("failed to refresh %s: %w", view, err)
+		}
+	}
+	return nil
+}
+
+// GetSummarizedDailyVisits is GetDailyVisits read from the summary views, so it is as of
+// their last refresh.
+func (s *PostgresStore) GetSummarizedDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT (bucket AT TIME ZONE $1)::date AS day, SUM(visits)::bigint
+		FROM visits_by_quarter_hour
+		WHERE bucket >= $2 AND bucket < $3
+		GROUP BY day
+		ORDER BY day`, loc.String(), from.UTC(), to.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting summarized daily visits: %v", err)
+		return nil, fmt.Errorf("failed to get summarized daily visits: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []DailyCount
+	for rows.Next() {
+		var c DailyCount
+		if err := rows.Scan(&c.Date, &c.Visits); err != nil {
+			return nil, fmt.Errorf("failed to scan summarized daily visits: %w", err)
+		}
+		c.Date = time.Date(c.Date.Year(), c.Date.Month(), c.Date.Day(), 0, 0, 0, 0, loc)
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read summarized daily visits: %w", err)
+	}
+	return counts, nil
+}
+
+// GetSummarizedTopReferrers is GetTopReferrers read from the summary views, so it is as of
+// their last refresh and counts whole hours from the one containing from.
+func (s *PostgresStore) GetSummarizedTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT referrer, SUM(visits)::bigint AS total
+		FROM referrers_by_hour
+		WHERE hour >= $1 AND hour < $2
+		GROUP BY referrer
+		ORDER BY total DESC, referrer
+		LIMIT $3`, from.UTC().Truncate(time.Hour), to.UTC(), limit)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting summarized top referrers: %v", err)
+		return nil, fmt.Errorf("failed to get summarized top referrers: %w", err)
+	}
+	defer rows.Close()
+
+	var referrers []ReferrerCount
+	for rows.Next() {
+		var c ReferrerCount
+		if err := rows.Scan(&c.Domain, &c.Visits); err != nil {
+			return nil, fmt.Errorf("failed to scan summarized top referrers: %w", err)
+		}
+		referrers = append(referrers, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read summarized top referrers: %w", err)
+	}
+	return referrers, nil
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStore_RefreshSummaries(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+
+	mock.ExpectExec("REFRESH MATERIALIZED VIEW CONCURRENTLY visits_by_quarter_hour").WillReturnResult(pgxmock.NewResult("REFRESH", 0))
+	mock.ExpectExec("REFRESH MATERIALIZED VIEW CONCURRENTLY referrers_by_hour").WillReturnResult(pgxmock.NewResult("REFRESH", 0))
+	assert.NoError(t, s.RefreshSummaries(ctx))
+
+	mock.ExpectExec("REFRESH MATERIALIZED VIEW CONCURRENTLY visits_by_quarter_hour").WillReturnError(fmt.Errorf("canceling statement"))
+	assert.Error(t, s.RefreshSummaries(ctx))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetSummarizedDailyVisits(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, loc)
+	to := time.Date(2024, 3, 3, 0, 0, 0, 0, loc)
+
+	mock.ExpectQuery("SELECT \\(bucket AT TIME ZONE \\$1\\)::date AS day, SUM\\(visits\\)::bigint FROM visits_by_quarter_hour").
+		WithArgs("Asia/Kolkata", from.UTC(), to.UTC()).
+		WillReturnRows(pgxmock.NewRows([]string{"day", "sum"}).
+			AddRow(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 4).
+			AddRow(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), 7))
+	counts, err := s.GetSummarizedDailyVisits(ctx, from, to, loc)
+	require.NoError(t, err)
+	assert.Equal(t, []DailyCount{{Date: from, Visits: 4}, {Date: from.AddDate(0, 0, 1), Visits: 7}}, counts)
+
+	mock.ExpectQuery("FROM visits_by_quarter_hour").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnError(fmt.Errorf("relation does not exist"))
+	_, err = s.GetSummarizedDailyVisits(ctx, from, to, loc)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetSummarizedTopReferrers(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	from := time.Date(2024, 3, 1, 10, 37, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 3, 10, 37, 0, 0, time.UTC)
+
+	// Whole hours are counted, from the one containing from
+	mock.ExpectQuery("SELECT referrer, SUM\\(visits\\)::bigint AS total FROM referrers_by_hour").
+		WithArgs(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), to, 5).
+		WillReturnRows(pgxmock.NewRows([]string{"referrer", "total"}).AddRow("linkedin.com", 12).AddRow("github.com", 3))
+	referrers, err := s.GetSummarizedTopReferrers(ctx, from, to, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []ReferrerCount{{Domain: "linkedin.com", Visits: 12}, {Domain: "github.com", Visits: 3}}, referrers)
+
+	mock.ExpectQuery("FROM referrers_by_hour").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnError(fmt.Errorf("relation does not exist"))
+	_, err = s.GetSummarizedTopReferrers(ctx, from, to, 5)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_createSummaryViews(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	mock.ExpectExec("CREATE MATERIALIZED VIEW IF NOT EXISTS visits_by_quarter_hour .* CREATE UNIQUE INDEX IF NOT EXISTS referrers_by_hour_hour_referrer_idx").
+		WillReturnResult(pgxmock.NewResult("CREATE INDEX", 0))
+	assert.NoError(t, createSummaryViews(context.Background(), mock))
+
+	mock.ExpectExec("CREATE MATERIALIZED VIEW").WillReturnError(fmt.Errorf("permission denied"))
+	assert.Error(t, createSummaryViews(context.Background(), mock))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// QueryTrace describes one finished query. Arguments are never captured, as they may hold
+// visitor data.
+type QueryTrace struct {
+	SQL       string // the query's SQL template, on one line
+	Operation string // the statement's leading keyword, such as "select", or "other"
+	Rows      int64  // rows returned or affected
+	Duration  time.Duration
+	Err       error
+}
+
+// QueryObserver is called with every query the pool runs, on the context it ran under.
+type QueryObserver func(ctx context.Context, trace QueryTrace)
+
+// Called by queryTracer for every query; see SetQueryObserver
+var queryObserver QueryObserver = func(context.Context, QueryTrace) {}
+
+// SetQueryObserver sets the func told about every query, such as to record metrics. It is
+// meant to be called once at startup, before SetupDatabase.
+func SetQueryObserver(o QueryObserver) {
+	queryObserver = o
+}
+
+// Operations reported as themselves; anything else is "other", keeping metric labels bounded
+var tracedOperations = map[string]bool{
+	"select": true, "insert": true, "update": true, "delete": true, "with": true,
+	"create": true, "alter": true, "drop": true, "truncate": true, "copy": true,
+}
+
+// queryOperation returns the leading keyword of sql, lowercased, or "other".
+func queryOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "other"
+	}
+	op := strings.ToLower(strings.TrimLeft(fields[0], "("))
+	if !tracedOperations[op] {
+		return "other"
+	}
+	return op
+}
+
+type queryTraceKey struct{}
+
+type queryTraceStart struct {
+	sql     string
+	started time.Time
+}
+
+// queryTracer implements pgx.QueryTracer, reporting every query the pool's connections run
+// to the query observer, and logging it too when DB_LOG_QUERIES is set. Being installed on
+// the connection config, it sees every query without the store having to wrap them.
+type queryTracer struct {
+	logQueries bool
+}
+
+func (t queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryTraceKey{}, queryTraceStart{sql: data.SQL, started: time.Now()})
+}
+
+func (t queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryTraceKey{}).(queryTraceStart)
+	if !ok {
+		return
+	}
+	trace := QueryTrace{
+		SQL:       sqlTemplate(start.sql),
+		Operation: queryOperation(start.sql),
+		Rows:      data.CommandTag.RowsAffected(),
+		Duration:  time.Since(start.started),
+		Err:       data.Err,
+	}
+	if t.logQueries {
+		if trace.Err != nil {
+			logging.FromContext(ctx).Printf("Query failed after %s: %s: %v", trace.Duration.Round(time.Microsecond), trace.SQL, trace.Err)
+		} else {
+			logging.FromContext(ctx).Printf("Query returned %d rows in %s: %s", trace.Rows, trace.Duration.Round(time.Microsecond), trace.SQL)
+		}
+	}
+	queryObserver(ctx, trace)
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"resume-backend/internal/logging"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryTracer(t *testing.T) {
+	var traces []QueryTrace
+	saved := queryObserver
+	defer SetQueryObserver(saved)
+	SetQueryObserver(func(_ context.Context, trace QueryTrace) {
+		traces = append(traces, trace)
+	})
+
+	logger := &recordingLogger{}
+	ctx := logging.NewContext(context.Background(), logger)
+	tracer := queryTracer{logQueries: true}
+
+	// A read reports its template, operation and rows, without its arguments
+	queryCtx := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT page\n\t\tFROM visits WHERE page = $1", Args: []any{"/secret"}})
+	tracer.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 3")})
+	require.Len(t, traces, 1)
+	assert.Equal(t, "SELECT page FROM visits WHERE page = $1", traces[0].SQL)
+	assert.Equal(t, "select", traces[0].Operation)
+	assert.Equal(t, int64(3), traces[0].Rows)
+	assert.NoError(t, traces[0].Err)
+	require.Len(t, logger.lines, 1)
+	assert.Contains(t, logger.lines[0], "Query returned 3 rows")
+	assert.NotContains(t, logger.lines[0], "/secret")
+
+	// A failed write reports its error
+	failure := errors.New("deadlock detected")
+	queryCtx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "UPDATE outbox SET attempts = attempts + 1"})
+	tracer.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{Err: failure})
+	require.Len(t, traces, 2)
+	assert.Equal(t, "update", traces[1].Operation)
+	assert.ErrorIs(t, traces[1].Err, failure)
+	assert.Contains(t, logger.lines[1], "Query failed after")
+
+	// Queries are only logged when asked to
+	queryCtx = queryTracer{}.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "INSERT INTO visits (page) VALUES ($1)"})
+	queryTracer{}.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("INSERT 0 1")})
+	assert.Len(t, traces, 3)
+	assert.Equal(t, int64(1), traces[2].Rows)
+	assert.Len(t, logger.lines, 2)
+}
+
+func TestQueryOperation(t *testing.T) {
+	assert.Equal(t, "with", queryOperation("\n\tWITH recent AS (SELECT 1) SELECT * FROM recent"))
+	assert.Equal(t, "select", queryOperation("(SELECT 1) UNION (SELECT 2)"))
+	assert.Equal(t, "other", queryOperation("LISTEN visits"))
+	assert.Equal(t, "other", queryOperation(""))
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"resume-backend/internal/logging"
+)
+
+// UptimeCheck is one fetch of the monitored site.
+type UptimeCheck struct {
+	CheckedAt time.Time
+	URL       string
+	Up        bool
+	Status    int // zero when no response arrived
+	Latency   time.Duration
+	Error     string // why the check failed, empty when it didn't
+}
+
+// createUptimeChecksTable creates the table of site uptime checks if it does not exist
+func createUptimeChecksTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS uptime_checks (
+			id BIGSERIAL PRIMARY KEY,
+			checked_at TIMESTAMPTZ NOT NULL,
+			url TEXT NOT NULL,
+			up BOOLEAN NOT NULL,
+			status INTEGER NOT NULL,
+			latency_ms INTEGER NOT NULL,
+			error TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS uptime_checks_checked_at_idx ON uptime_checks (checked_at)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create uptime_checks table: %w", err)
+	}
+	return nil
+}
+
+// RecordUptimeCheck stores the result of a check.
+func (s *PostgresStore) RecordUptimeCheck(ctx context.Context, check UptimeCheck) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO uptime_checks (checked_at, url, up, status, latency_ms, error) VALUES ($1, $2, $3, $4, $5, $6)`,
+		check.CheckedAt.UTC(), check.URL, check.Up, check.Status, check.Latency.Milliseconds(), check.Error)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error recording uptime check: %v", err)
+		return fmt.Errorf("failed to record uptime check: %w", err)
+	}
+	return nil
+}
+
+// GetUptimeChecks returns the checks made in [from, to), oldest first.
+func (s *PostgresStore) GetUptimeChecks(ctx context.Context, from, to time.Time) ([]UptimeCheck, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT checked_at, url, up, status, latency_ms, error FROM uptime_checks
+		WHERE checked_at >= $1 AND checked_at < $2 ORDER BY checked_at, id`,
+		from.UTC(), to.UTC())
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting uptime checks: %v", err)
+		return nil, fmt.Errorf("failed to get uptime checks: %w", err)
+	}
+	defer rows.Close()
+
+	var checks []UptimeCheck
+	for rows.Next() {
+		var c UptimeCheck
+		var latencyMS int64
+		if err := rows.Scan(&c.CheckedAt, &c.URL, &c.Up, &c.Status, &latencyMS, &c.Error); err != nil {
+			return nil, fmt.Errorf("failed to scan uptime checks: %w", err)
+		}
+		c.CheckedAt, c.Latency = c.CheckedAt.UTC(), time.Duration(latencyMS)*time.Millisecond
+		checks = append(checks, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read uptime checks: %w", err)
+	}
+	return checks, nil
+}
+
+// CertificateCheck is the last look at a domain's TLS certificate.
+type CertificateCheck struct {
+	Domain    string
+	CheckedAt time.Time
+	ExpiresAt time.Time // zero when no certificate could be read
+	Error     string    // why the certificate couldn't be read or isn't trusted, empty when it is
+}
+
+// createCertificateChecksTable creates the table of the latest certificate check of each
+// domain if it does not exist
+func createCertificateChecksTable(ctx context.Context, pool DatabasePool) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS certificate_checks (
+			domain TEXT PRIMARY KEY,
+			checked_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ,
+			error TEXT NOT NULL DEFAULT ''
+		)`
+
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate_checks table: %w", err)
+	}
+	return nil
+}
+
+// SaveCertificateCheck stores check as its domain's latest.
+func (s *PostgresStore) SaveCertificateCheck(ctx context.Context, check CertificateCheck) error {
+	var expiresAt *time.Time
+	if !check.ExpiresAt.IsZero() {
+		t := check.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO certificate_checks (domain, checked_at, expires_at, error) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (domain) DO UPDATE SET checked_at = EXCLUDED.checked_at, expires_at = EXCLUDED.expires_at, error = EXCLUDED.error`,
+		check.Domain, check.CheckedAt.UTC(), expiresAt, check.Error)
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error saving certificate check: %v", err)
+		return fmt.Errorf("failed to save certificate check: %w", err)
+	}
+	return nil
+}
+
+// GetCertificateChecks returns the latest check of each domain, by domain.
+func (s *PostgresStore) GetCertificateChecks(ctx context.Context) ([]CertificateCheck, error) {
+	rows, err := s.pool.Query(ctx, "SELECT domain, checked_at, expires_at, error FROM certificate_checks ORDER BY domain")
+	if err != nil {
+		logging.FromContext(ctx).Printf("Error getting certificate checks: %v", err)
+		return nil, fmt.Errorf("failed to get certificate checks: %w", err)
+	}
+	defer rows.Close()
+
+	var checks []CertificateCheck
+	for rows.Next() {
+		var c CertificateCheck
+		var expiresAt *time.Time
+		if err := rows.Scan(&c.Domain, &c.CheckedAt, &expiresAt, &c.Error); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate checks: %w", err)
+		}
+		c.CheckedAt = c.CheckedAt.UTC()
+		if expiresAt != nil {
+			c.ExpiresAt = expiresAt.UTC()
+		}
+		checks = append(checks, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read certificate checks: %w", err)
+	}
+	return checks, nil
+}
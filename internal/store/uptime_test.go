@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStore_RecordUptimeCheck(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	checked := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("INSERT INTO uptime_checks").
+		WithArgs(checked, "https://example.com", false, 503, int64(250), "status 503").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	err = s.RecordUptimeCheck(ctx, UptimeCheck{CheckedAt: checked, URL: "https://example.com", Status: 503, Latency: 250 * time.Millisecond, Error: "status 503"})
+	require.NoError(t, err)
+
+	mock.ExpectExec("INSERT INTO uptime_checks").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("connection reset"))
+	assert.Error(t, s.RecordUptimeCheck(ctx, UptimeCheck{CheckedAt: checked}))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetUptimeChecks(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	from := time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	mock.ExpectQuery("FROM uptime_checks\\s+WHERE checked_at >= \\$1 AND checked_at < \\$2 ORDER BY checked_at").
+		WithArgs(from, to).
+		WillReturnRows(pgxmock.NewRows([]string{"checked_at", "url", "up", "status", "latency_ms", "error"}).
+			AddRow(from.Add(time.Minute), "https://example.com", true, 200, int64(120), "").
+			AddRow(from.Add(2*time.Minute), "https://example.com", false, 0, int64(10000), "timeout"))
+	checks, err := s.GetUptimeChecks(ctx, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, []UptimeCheck{
+		{CheckedAt: from.Add(time.Minute), URL: "https://example.com", Up: true, Status: 200, Latency: 120 * time.Millisecond},
+		{CheckedAt: from.Add(2 * time.Minute), URL: "https://example.com", Latency: 10 * time.Second, Error: "timeout"},
+	}, checks)
+
+	mock.ExpectQuery("FROM uptime_checks").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.GetUptimeChecks(ctx, from, to)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_SaveCertificateCheck(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	checked := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	expires := checked.Add(30 * 24 * time.Hour)
+
+	mock.ExpectExec("INSERT INTO certificate_checks .+ ON CONFLICT \\(domain\\) DO UPDATE").
+		WithArgs("example.com:443", checked, &expires, "").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	require.NoError(t, s.SaveCertificateCheck(ctx, CertificateCheck{Domain: "example.com:443", CheckedAt: checked, ExpiresAt: expires}))
+
+	// Without a certificate the expiry is stored as NULL
+	mock.ExpectExec("INSERT INTO certificate_checks").
+		WithArgs("down.example.com:443", checked, (*time.Time)(nil), "connection refused").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	require.NoError(t, s.SaveCertificateCheck(ctx, CertificateCheck{Domain: "down.example.com:443", CheckedAt: checked, Error: "connection refused"}))
+
+	mock.ExpectExec("INSERT INTO certificate_checks").WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+		WillReturnError(fmt.Errorf("connection reset"))
+	assert.Error(t, s.SaveCertificateCheck(ctx, CertificateCheck{Domain: "example.com:443", CheckedAt: checked}))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStore_GetCertificateChecks(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer mock.Close()
+
+	s := &PostgresStore{pool: mock}
+	ctx := context.Background()
+	checked := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	expires := checked.Add(30 * 24 * time.Hour)
+
+	mock.ExpectQuery("FROM certificate_checks ORDER BY domain").
+		WillReturnRows(pgxmock.NewRows([]string{"domain", "checked_at", "expires_at", "error"}).
+			AddRow("down.example.com:443", checked, (*time.Time)(nil), "connection refused").
+			AddRow("example.com:443", checked, &expires, ""))
+	checks, err := s.GetCertificateChecks(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []CertificateCheck{
+		{Domain: "down.example.com:443", CheckedAt: checked, Error: "connection refused"},
+		{Domain: "example.com:443", CheckedAt: checked, ExpiresAt: expires},
+	}, checks)
+
+	mock.ExpectQuery("FROM certificate_checks").WillReturnError(fmt.Errorf("connection reset"))
+	_, err = s.GetCertificateChecks(ctx)
+	assert.Error(t, err)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
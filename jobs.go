@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	failedJobsPath = "/api/admin/jobs/failed"
+	retryJobPath   = "/api/admin/jobs/{id}/retry"
+
+	defaultFailedJobsLimit = 100
+	maxFailedJobsLimit     = 1000
+)
+
+// failedJob is a dead letter in the outbox: a webhook, stream record or alert given up on
+// after OUTBOX_MAX_ATTEMPTS.
+type failedJob struct {
+	ID          int64           `json:"id"`
+	Destination string          `json:"destination"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+type failedJobsResponse struct {
+	Jobs []failedJob `json:"jobs"`
+}
+
+// adminAuthorized checks the ADMIN_TOKEN bearer token, answering the request when it fails.
+// Admin endpoints are disabled while no token is configured, except to callers with a
+// client certificate verified by the admin listener or a valid request signature on a
+// SIGNED_ROUTES path, who need no token.
+func adminAuthorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	if hasVerifiedClientCert(r) || requestSigned(r) {
+		return true
+	}
+	if token == "" {
+		http.Error(w, "Admin endpoints are not enabled", http.StatusNotFound)
+		return false
+	}
+	if !hasBearerToken(r, token) {
+		forbiddenLogger.Printf("Invalid admin token: %s %s", r.Method, r.URL.Path)
+		http.Error(w, "Missing or invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// failedJobsHandler lists the outbox's dead letters, newest first.
+func failedJobsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+
+	limit := defaultFailedJobsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFailedJobsLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxFailedJobsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	messages, err := dataStore.GetFailedOutbox(r.Context(), limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get failed jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	jobs := make([]failedJob, len(messages))
+	for i, m := range messages {
+		jobs[i] = failedJob{ID: m.ID, Destination: m.Destination, Payload: m.Payload, Attempts: m.Attempts, LastError: m.LastError, CreatedAt: m.CreatedAt}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, failedJobsResponse{Jobs: jobs})
+}
+
+// retryJobHandler requeues a dead letter, which the dispatcher then delivers with a fresh set
+// of attempts.
+func retryJobHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	retried, err := dataStore.RetryOutbox(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to retry job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !retried {
+		http.Error(w, "No failed job with that ID", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, messageResponse{Message: "Job requeued"})
+}
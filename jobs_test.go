@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_failedJobsHandler(t *testing.T) {
+	created := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	mockDataStore := &MockDataStore{outbox: []OutboxMessage{
+		{ID: 1, Destination: "webhook", Payload: json.RawMessage(`{"text":"New resume visit"}`)},
+		{ID: 2, Destination: "webhook", Payload: json.RawMessage(`{"text":"New resume visit"}`), Attempts: 10, LastError: "webhook returned status 502", CreatedAt: created},
+	}}
+
+	tests := []struct {
+		name   string
+		token  string
+		auth   string
+		url    string
+		status int
+	}{
+		{"Disabled", "", "Bearer admin-token", failedJobsPath, http.StatusNotFound},
+		{"Missing token", "admin-token", "", failedJobsPath, http.StatusUnauthorized},
+		{"Wrong token", "admin-token", "Bearer nope", failedJobsPath, http.StatusUnauthorized},
+		{"Invalid limit", "admin-token", "Bearer admin-token", failedJobsPath + "?limit=5000", http.StatusBadRequest},
+		{"Authorized", "admin-token", "Bearer admin-token", failedJobsPath + "?limit=10", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("Authorization", tt.auth)
+			rr := httptest.NewRecorder()
+			failedJobsHandler(rr, req, mockDataStore, tt.token)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var res failedJobsResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			want := failedJob{ID: 2, Destination: "webhook", Payload: json.RawMessage(`{"text":"New resume visit"}`), Attempts: 10, LastError: "webhook returned status 502", CreatedAt: created}
+			if len(res.Jobs) != 1 || res.Jobs[0].ID != want.ID || string(res.Jobs[0].Payload) != string(want.Payload) ||
+				res.Jobs[0].LastError != want.LastError || !res.Jobs[0].CreatedAt.Equal(created) {
+				t.Errorf("expected only the dead letter; got %+v", res.Jobs)
+			}
+		})
+	}
+}
+
+func Test_retryJobHandler(t *testing.T) {
+	mockDataStore := &MockDataStore{outbox: []OutboxMessage{
+		{ID: 2, Destination: "webhook", Payload: json.RawMessage(`{}`), Attempts: 10},
+	}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(retryJobPath, func(w http.ResponseWriter, r *http.Request) {
+		retryJobHandler(w, r, mockDataStore, "admin-token")
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"Wrong method", http.MethodGet, "/api/admin/jobs/2/retry", http.StatusMethodNotAllowed},
+		{"Invalid ID", http.MethodPost, "/api/admin/jobs/abc/retry", http.StatusBadRequest},
+		{"Unknown job", http.MethodPost, "/api/admin/jobs/9/retry", http.StatusNotFound},
+		{"Retried", http.MethodPost, "/api/admin/jobs/2/retry", http.StatusOK},
+		{"Already requeued", http.MethodPost, "/api/admin/jobs/2/retry", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer admin-token")
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+	if mockDataStore.outbox[0].Attempts != 0 {
+		t.Errorf("expected the job's attempts to be reset; got %d", mockDataStore.outbox[0].Attempts)
+	}
+}
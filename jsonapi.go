@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxJSONAPIPageSize caps page[size], which is never larger than the longest stats window
+const maxJSONAPIPageSize = maxStatsDays
+
+// jsonAPICollection is implemented by the list and stats responses, which JSON:API clients
+// receive as a collection of resources rather than a single meta object.
+type jsonAPICollection interface {
+	// jsonAPICollection names the resource type, the JSON field holding the resources, and
+	// the fields of each resource that together identify it.
+	jsonAPICollection() (resourceType, listField string, idFields []string)
+}
+
+func (statsResponse) jsonAPICollection() (string, string, []string) {
+	return "daily_visits", "days", []string{"date"}
+}
+
+func (deploysResponse) jsonAPICollection() (string, string, []string) {
+	return "deploys", "deploys", []string{"id"}
+}
+
+func (metricDocsResponse) jsonAPICollection() (string, string, []string) {
+	return "metrics", "metrics", []string{"name"}
+}
+
+func (referrersResponse) jsonAPICollection() (string, string, []string) {
+	return "referrers", "referrers", []string{"domain"}
+}
+
+func (campaignsResponse) jsonAPICollection() (string, string, []string) {
+	return "campaigns", "campaigns", []string{"utm_source", "utm_medium", "utm_campaign"}
+}
+
+func (experimentResultsResponse) jsonAPICollection() (string, string, []string) {
+	return "variants", "variants", []string{"variant"}
+}
+
+func (sessionStatsResponse) jsonAPICollection() (string, string, []string) {
+	return "session_days", "daily", []string{"date"}
+}
+
+func (eventStatsResponse) jsonAPICollection() (string, string, []string) {
+	return "event_counts", "events", []string{"type", "value"}
+}
+
+func (funnelResponse) jsonAPICollection() (string, string, []string) {
+	return "funnel_steps", "steps", []string{"step"}
+}
+
+func (anomaliesResponse) jsonAPICollection() (string, string, []string) {
+	return "anomalies", "anomalies", []string{"hour"}
+}
+
+// jsonAPIEncoder wraps responses in a JSON:API document. Collections become data resources,
+// with the response's other fields as meta, and can be paged with page[size] and the
+// page[after] cursor from links.next. Other responses are returned as meta alone.
+type jsonAPIEncoder struct{}
+
+func (jsonAPIEncoder) ContentType() string { return "application/vnd.api+json" }
+
+func (jsonAPIEncoder) Accepts(mediaType string) bool { return mediaType == "application/vnd.api+json" }
+
+// jsonAPIResource is one entry of a JSON:API document's data.
+type jsonAPIResource struct {
+	Type       string     `json:"type"`
+	ID         string     `json:"id"`
+	Attributes jsonObject `json:"attributes"`
+}
+
+// jsonAPIDocument is a JSON:API top-level document.
+type jsonAPIDocument struct {
+	Data  *[]jsonAPIResource `json:"data,omitempty"`
+	Meta  jsonObject         `json:"meta"`
+	Links map[string]string  `json:"links"`
+}
+
+func (jsonAPIEncoder) Encode(w io.Writer, r *http.Request, v interface{}) error {
+	tree, err := toJSONTree(v)
+	if err != nil {
+		return err
+	}
+	fields, _ := tree.(jsonObject)
+	doc := jsonAPIDocument{
+		Meta:  jsonObject{},
+		Links: map[string]string{"self": r.URL.RequestURI(), "describedby": openAPIPath},
+	}
+
+	collection, ok := v.(jsonAPICollection)
+	if !ok {
+		doc.Meta = fields
+		return jsonEncoder{}.Encode(w, r, doc)
+	}
+
+	resourceType, listField, idFields := collection.jsonAPICollection()
+	var resources []jsonAPIResource
+	for _, f := range fields {
+		if f.Name != listField {
+			doc.Meta = append(doc.Meta, f)
+			continue
+		}
+		items, _ := f.Value.([]interface{})
+		for _, item := range items {
+			attrs, _ := item.(jsonObject)
+			resources = append(resources, jsonAPIResource{Type: resourceType, ID: jsonAPIResourceID(attrs, idFields), Attributes: attrs})
+		}
+	}
+
+	page, next := jsonAPIPage(r.URL.Query(), resources)
+	doc.Data = &page
+	doc.Meta = append(doc.Meta, jsonField{Name: "total", Value: json.Number(strconv.Itoa(len(resources)))})
+	if next != "" {
+		doc.Meta = append(doc.Meta, jsonField{Name: "next_cursor", Value: next})
+		q := r.URL.Query()
+		q.Set("page[after]", next)
+		doc.Links["next"] = r.URL.Path + "?" + q.Encode()
+	}
+	return jsonEncoder{}.Encode(w, r, doc)
+}
+
+// jsonAPIResourceID joins the identifying attributes, skipping ones the resource omits.
+func jsonAPIResourceID(attrs jsonObject, idFields []string) string {
+	var parts []string
+	for _, name := range idFields {
+		for _, f := range attrs {
+			if f.Name == name {
+				parts = append(parts, fmt.Sprint(f.Value))
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// jsonAPIPage returns the resources after the page[after] cursor, up to page[size] of them,
+// and the cursor for the following page if there is one. Without page[size] every remaining
+// resource is returned, and an out-of-range size is clamped. Cursors encode the last
+// resource's ID; one that matches no resource, because it was mangled or its resource has
+// aged out of the window, yields an empty page rather than starting over.
+func jsonAPIPage(query url.Values, resources []jsonAPIResource) ([]jsonAPIResource, string) {
+	if after := query.Get("page[after]"); after != "" {
+		id, _ := base64.RawURLEncoding.DecodeString(after)
+		remaining := []jsonAPIResource{}
+		for i, res := range resources {
+			if res.ID == string(id) {
+				remaining = resources[i+1:]
+				break
+			}
+		}
+		resources = remaining
+	}
+
+	size := len(resources)
+	if v := query.Get("page[size]"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			n = maxJSONAPIPageSize
+		}
+		size = min(max(n, 1), maxJSONAPIPageSize, len(resources))
+	}
+
+	page := append([]jsonAPIResource{}, resources[:size]...)
+	if size == len(resources) {
+		return page, ""
+	}
+	return page, base64.RawURLEncoding.EncodeToString([]byte(page[size-1].ID))
+}
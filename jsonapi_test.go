@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// jsonAPITestDocument mirrors jsonAPIDocument for decoding responses.
+type jsonAPITestDocument struct {
+	Data []struct {
+		Type       string                 `json:"type"`
+		ID         string                 `json:"id"`
+		Attributes map[string]interface{} `json:"attributes"`
+	} `json:"data"`
+	Meta  map[string]interface{} `json:"meta"`
+	Links map[string]string      `json:"links"`
+}
+
+func getJSONAPI(t *testing.T, handler http.HandlerFunc, target string) jsonAPITestDocument {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Accept", "application/vnd.api+json")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/vnd.api+json" {
+		t.Fatalf("expected Content-Type application/vnd.api+json; got %s", ct)
+	}
+	var doc jsonAPITestDocument
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	return doc
+}
+
+func Test_jsonAPIEncoder_Collection(t *testing.T) {
+	mockDataStore := &MockDataStore{referrers: []ReferrerCount{
+		{Domain: "linkedin.com", Visits: 5},
+		{Domain: "github.com", Visits: 3},
+		{Domain: "news.ycombinator.com", Visits: 1},
+	}}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	handler := func(w http.ResponseWriter, r *http.Request) { referrersHandler(w, r, mockDataStore, clock) }
+
+	doc := getJSONAPI(t, handler, referrersPath+"?days=7&page[size]=2")
+	if len(doc.Data) != 2 || doc.Data[0].Type != "referrers" || doc.Data[0].ID != "linkedin.com" || doc.Data[1].ID != "github.com" {
+		t.Fatalf("unexpected first page: %+v", doc.Data)
+	}
+	if doc.Data[0].Attributes["visits"] != 5.0 {
+		t.Errorf("expected attributes to carry the counts; got %v", doc.Data[0].Attributes)
+	}
+	if doc.Meta["days"] != 7.0 || doc.Meta["total"] != 3.0 || doc.Meta["next_cursor"] == nil {
+		t.Errorf("unexpected meta: %v", doc.Meta)
+	}
+	if doc.Links["self"] != referrersPath+"?days=7&page[size]=2" || doc.Links["describedby"] != openAPIPath {
+		t.Errorf("unexpected links: %v", doc.Links)
+	}
+
+	doc = getJSONAPI(t, handler, doc.Links["next"])
+	if len(doc.Data) != 1 || doc.Data[0].ID != "news.ycombinator.com" {
+		t.Fatalf("unexpected second page: %+v", doc.Data)
+	}
+	if _, ok := doc.Links["next"]; ok {
+		t.Errorf("expected no next link on the last page; got %v", doc.Links)
+	}
+
+	doc = getJSONAPI(t, handler, referrersPath+"?page[after]=bm90LWEtZG9tYWlu")
+	if len(doc.Data) != 0 {
+		t.Errorf("expected an unknown cursor to yield an empty page; got %+v", doc.Data)
+	}
+}
+
+func Test_jsonAPIEncoder_CompositeID(t *testing.T) {
+	mockDataStore := &MockDataStore{campaigns: []CampaignCount{
+		{UTM: UTM{Source: "newsletter", Medium: "email", Campaign: "launch"}, Visits: 4},
+	}}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+
+	doc := getJSONAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		campaignsHandler(w, r, mockDataStore, clock)
+	}, campaignsPath)
+	if len(doc.Data) != 1 || doc.Data[0].ID != "newsletter/email/launch" || doc.Data[0].Type != "campaigns" {
+		t.Errorf("unexpected data: %+v", doc.Data)
+	}
+}
+
+func Test_jsonAPIEncoder_Meta(t *testing.T) {
+	mockDataStore := &MockDataStore{visitCount: 7}
+
+	doc := getJSONAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		visitCountHandler(w, r, mockDataStore, realClock{})
+	}, apiPath)
+	if doc.Data != nil {
+		t.Errorf("expected no data for a single counter; got %+v", doc.Data)
+	}
+	if doc.Meta["visits"] != 7.0 {
+		t.Errorf("expected the count in meta; got %v", doc.Meta)
+	}
+}
+
+func Test_jsonAPIPage(t *testing.T) {
+	resources := []jsonAPIResource{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantIDs  []string
+		wantNext bool
+	}{
+		{"Everything", "", []string{"a", "b", "c"}, false},
+		{"First page", "page[size]=1", []string{"a"}, true},
+		{"After cursor", "page[after]=YQ&page[size]=1", []string{"b"}, true},
+		{"Last page", "page[after]=Yg", []string{"c"}, false},
+		{"Size clamped up", "page[size]=0", []string{"a"}, true},
+		{"Invalid size", "page[size]=x", []string{"a", "b", "c"}, false},
+		{"Mangled cursor", "page[after]=!!!", []string{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			page, next := jsonAPIPage(req.URL.Query(), resources)
+
+			ids := []string{}
+			for _, res := range page {
+				ids = append(ids, res.ID)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("got %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Fatalf("got %v, want %v", ids, tt.wantIDs)
+				}
+			}
+			if (next != "") != tt.wantNext {
+				t.Errorf("next cursor = %q, want one: %v", next, tt.wantNext)
+			}
+		})
+	}
+}
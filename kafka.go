@@ -0,0 +1,550 @@
+//go:build !no_kafka
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKafkaTopic     = "resume-visits"
+	defaultKafkaBatchSize = 100
+	defaultKafkaLinger    = time.Second
+	maxKafkaBatchSize     = 10000
+	kafkaBufferSize       = 10000 // records waiting to be sent before Publish spills them to the outbox
+	kafkaMaxAttempts      = 5
+	kafkaRetryBackoff     = 500 * time.Millisecond
+	kafkaDialTimeout      = 10 * time.Second
+	kafkaRequestTimeout   = 30 * time.Second
+	kafkaClientID         = "resume-backend"
+	kafkaDestination      = "kafka"
+	maxKafkaResponseBytes = 16 << 20 // far more than a produce or single-topic metadata response
+
+	// Kafka API keys and the versions this producer speaks
+	kafkaProduceAPI     = 0
+	kafkaProduceVersion = 3 // the first version carrying v2 record batches
+	kafkaMetadataAPI    = 3
+	kafkaMetadataVer    = 1
+	kafkaAcksAll        = -1
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+func init() {
+	registerVisitProcessor(kafkaDestination, newKafkaProcessorFromEnv)
+}
+
+// kafkaConfig controls publishing the visit event stream to Kafka.
+type kafkaConfig struct {
+	Brokers   []string // bootstrap brokers as host:port
+	Topic     string
+	BatchSize int           // records per produce request
+	Linger    time.Duration // how long a partial batch waits for more records
+}
+
+// loadKafkaConfig reads the KAFKA_* settings. The Kafka sink is enabled by setting KAFKA_BROKERS.
+func loadKafkaConfig() (kafkaConfig, bool, error) {
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		return kafkaConfig{}, false, nil
+	}
+
+	cfg := kafkaConfig{Topic: defaultKafkaTopic, BatchSize: defaultKafkaBatchSize, Linger: defaultKafkaLinger}
+	for _, b := range strings.Split(brokers, ",") {
+		b = strings.TrimSpace(b)
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			return kafkaConfig{}, false, fmt.Errorf("invalid KAFKA_BROKERS entry %q: must be host:port", b)
+		}
+		cfg.Brokers = append(cfg.Brokers, b)
+	}
+	if v := os.Getenv("KAFKA_TOPIC"); v != "" {
+		cfg.Topic = v
+	}
+	if v := os.Getenv("KAFKA_BATCH_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxKafkaBatchSize {
+			return kafkaConfig{}, false, fmt.Errorf("invalid KAFKA_BATCH_SIZE %q: must be between 1 and %d", v, maxKafkaBatchSize)
+		}
+		cfg.BatchSize = n
+	}
+	if v := os.Getenv("KAFKA_LINGER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return kafkaConfig{}, false, fmt.Errorf("invalid KAFKA_LINGER %q: must be a positive duration", v)
+		}
+		cfg.Linger = d
+	}
+
+	log.Printf("Kafka sink enabled: publishing to %s via %s", cfg.Topic, strings.Join(cfg.Brokers, ","))
+	return cfg, true, nil
+}
+
+// newKafkaProcessorFromEnv publishes visits and events to Kafka when KAFKA_BROKERS is set.
+// With VISIT_OUTBOX set, records Kafka can't take are spilled to the outbox.
+func newKafkaProcessorFromEnv(store DataStore) (VisitProcessor, error) {
+	cfg, enabled, err := loadKafkaConfig()
+	if err != nil || !enabled {
+		return nil, err
+	}
+	outboxCfg, err := loadOutboxConfig()
+	if err != nil {
+		return nil, err
+	}
+	var outbox DataStore
+	if outboxCfg.Enabled {
+		outbox = store
+	}
+	return &streamProcessor{name: kafkaDestination, sink: newKafkaProducer(cfg, outbox)}, nil
+}
+
+// kafkaMessage is one record waiting to be produced.
+type kafkaMessage struct {
+	Value     []byte
+	Timestamp time.Time
+}
+
+// kafkaPartition is a partition of the topic and the broker leading it.
+type kafkaPartition struct {
+	ID     int32
+	Leader int32
+}
+
+// kafkaProducer batches records and produces them to Kafka with acks=all, retrying failed
+// batches, so records are delivered at least once after Publish accepts them. Each batch goes
+// to the next partition in turn. Publish never blocks: when the buffer is full, or a batch
+// runs out of attempts, because Kafka is unreachable, the records are spilled to the outbox
+// for the dispatcher to deliver later. Without an outbox they are dropped, with an error logged.
+type kafkaProducer struct {
+	cfg          kafkaConfig
+	outbox       DataStore // nil unless VISIT_OUTBOX is set
+	retryBackoff time.Duration
+	messages     chan kafkaMessage
+	done         chan struct{}
+
+	// Owned by the run goroutine
+	brokers       map[int32]string
+	partitions    []kafkaPartition
+	conns         map[int32]*kafkaConn
+	next          int
+	correlationID int32
+}
+
+func newKafkaProducer(cfg kafkaConfig, outbox DataStore) *kafkaProducer {
+	p := &kafkaProducer{
+		cfg:          cfg,
+		outbox:       outbox,
+		retryBackoff: kafkaRetryBackoff,
+		messages:     make(chan kafkaMessage, kafkaBufferSize),
+		done:         make(chan struct{}),
+		conns:        make(map[int32]*kafkaConn),
+	}
+	go p.run()
+	return p
+}
+
+// Publish queues a record for the next batch, spilling it if the buffer is full.
+func (p *kafkaProducer) Publish(value []byte, timestamp time.Time) {
+	if err := p.Offer(value, timestamp); err != nil {
+		p.spill([]kafkaMessage{{Value: value, Timestamp: timestamp}}, err)
+	}
+}
+
+// Offer queues a record for the next batch, returning an error if the buffer is full. The
+// outbox dispatcher delivers spilled records with it, so they are retried with backoff
+// rather than spilled again.
+func (p *kafkaProducer) Offer(value []byte, timestamp time.Time) error {
+	select {
+	case p.messages <- kafkaMessage{Value: value, Timestamp: timestamp}:
+		return nil
+	default:
+		return errors.New("Kafka buffer is full")
+	}
+}
+
+// spill queues records Kafka couldn't take in the outbox, or drops them without one.
+func (p *kafkaProducer) spill(messages []kafkaMessage, cause error) {
+	if p.outbox == nil {
+		errorLogger.Printf("Error producing to Kafka, dropping %d records: %v", len(messages), cause)
+		return
+	}
+	queued := make([]OutboxMessage, len(messages))
+	for i, m := range messages {
+		queued[i] = OutboxMessage{Destination: kafkaDestination, Payload: m.Value}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaRequestTimeout)
+	defer cancel()
+	if err := p.outbox.EnqueueOutbox(ctx, queued); err != nil {
+		errorLogger.Printf("Error spilling %d Kafka records to the outbox after %v, dropping them: %v", len(messages), cause, err)
+		return
+	}
+	errorLogger.Printf("Error producing to Kafka, spilled %d records to the outbox: %v", len(messages), cause)
+}
+
+// Close sends any queued records and closes the broker connections.
+func (p *kafkaProducer) Close() {
+	close(p.messages)
+	<-p.done
+}
+
+func (p *kafkaProducer) run() {
+	defer close(p.done)
+	defer p.closeConns()
+
+	batch := make([]kafkaMessage, 0, p.cfg.BatchSize)
+	linger := time.NewTimer(p.cfg.Linger)
+	linger.Stop()
+	for {
+		select {
+		case m, ok := <-p.messages:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			if len(batch) == 0 {
+				linger.Reset(p.cfg.Linger)
+			}
+			batch = append(batch, m)
+			if len(batch) < p.cfg.BatchSize {
+				continue
+			}
+			linger.Stop()
+		case <-linger.C:
+		}
+		p.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush produces batch, retrying with backoff and fresh metadata on failure.
+func (p *kafkaProducer) flush(batch []kafkaMessage) {
+	if len(batch) == 0 {
+		return
+	}
+	backoff := p.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := p.produce(batch)
+		if err == nil {
+			return
+		}
+		if attempt == kafkaMaxAttempts {
+			p.spill(batch, err)
+			return
+		}
+		errorLogger.Printf("Error producing to Kafka, retrying: %v", err)
+
+		// Leadership may have moved; look it up again on the next attempt
+		p.partitions = nil
+		p.closeConns()
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// produce sends batch to the next partition's leader and waits for all in-sync replicas.
+func (p *kafkaProducer) produce(batch []kafkaMessage) error {
+	if len(p.partitions) == 0 {
+		if err := p.refreshMetadata(); err != nil {
+			return err
+		}
+	}
+	partition := p.partitions[p.next%len(p.partitions)]
+	p.next++
+
+	conn, err := p.conn(partition.Leader)
+	if err != nil {
+		return err
+	}
+
+	var req kafkaEncoder
+	req.nullableString(nil) // transactional_id
+	req.int16(kafkaAcksAll)
+	req.int32(int32(kafkaRequestTimeout / time.Millisecond))
+	req.int32(1) // topics
+	req.string(p.cfg.Topic)
+	req.int32(1) // partitions
+	req.int32(partition.ID)
+	req.bytes(encodeRecordBatch(batch))
+
+	res, err := p.roundTrip(conn, kafkaProduceAPI, kafkaProduceVersion, req.b)
+	if err != nil {
+		return err
+	}
+
+	d := kafkaDecoder{b: res}
+	for topics := d.int32(); topics > 0; topics-- {
+		d.string()
+		for partitions := d.int32(); partitions > 0; partitions-- {
+			d.int32() // partition
+			if code := d.int16(); code != 0 {
+				return fmt.Errorf("partition %d of %s rejected the batch with error code %d", partition.ID, p.cfg.Topic, code)
+			}
+			d.int64() // base offset
+			d.int64() // log append time
+		}
+	}
+	return d.err
+}
+
+// refreshMetadata looks up the topic's partition leaders through the first reachable bootstrap broker.
+func (p *kafkaProducer) refreshMetadata() error {
+	var errs []error
+	for _, addr := range p.cfg.Brokers {
+		conn, err := dialKafka(addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var req kafkaEncoder
+		req.int32(1) // topics
+		req.string(p.cfg.Topic)
+		res, err := p.roundTrip(conn, kafkaMetadataAPI, kafkaMetadataVer, req.b)
+		conn.Close()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return p.parseMetadata(res)
+	}
+	return fmt.Errorf("no Kafka broker reachable: %w", errors.Join(errs...))
+}
+
+func (p *kafkaProducer) parseMetadata(res []byte) error {
+	d := kafkaDecoder{b: res}
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.nullableString() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller
+
+	var partitions []kafkaPartition
+	for topics := d.int32(); topics > 0 && d.err == nil; topics-- {
+		code := d.int16()
+		name := d.string()
+		d.int8() // is_internal
+		for n := d.int32(); n > 0 && d.err == nil; n-- {
+			partitionCode := d.int16()
+			partition := kafkaPartition{ID: d.int32(), Leader: d.int32()}
+			d.int32Array() // replicas
+			d.int32Array() // in-sync replicas
+			if partitionCode == 0 && partition.Leader >= 0 && name == p.cfg.Topic {
+				partitions = append(partitions, partition)
+			}
+		}
+		if code != 0 && name == p.cfg.Topic {
+			return fmt.Errorf("metadata for topic %s returned error code %d", name, code)
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("failed to decode Kafka metadata: %w", d.err)
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("topic %s has no partitions with a leader", p.cfg.Topic)
+	}
+	p.brokers, p.partitions = brokers, partitions
+	return nil
+}
+
+// conn returns a connection to the broker with the given node ID, dialing it if needed.
+func (p *kafkaProducer) conn(nodeID int32) (*kafkaConn, error) {
+	if c, ok := p.conns[nodeID]; ok {
+		return c, nil
+	}
+	addr, ok := p.brokers[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("unknown Kafka broker %d", nodeID)
+	}
+	c, err := dialKafka(addr)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[nodeID] = c
+	return c, nil
+}
+
+func (p *kafkaProducer) closeConns() {
+	for id, c := range p.conns {
+		c.Close()
+		delete(p.conns, id)
+	}
+}
+
+// roundTrip sends a request and returns the response body after its correlation ID.
+func (p *kafkaProducer) roundTrip(conn *kafkaConn, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	p.correlationID++
+
+	var req kafkaEncoder
+	req.int32(0) // size, filled in below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(p.correlationID)
+	clientID := kafkaClientID
+	req.nullableString(&clientID)
+	req.b = append(req.b, body...)
+	binary.BigEndian.PutUint32(req.b, uint32(len(req.b)-4))
+
+	if err := conn.SetDeadline(time.Now().Add(kafkaRequestTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req.b); err != nil {
+		return nil, fmt.Errorf("failed to send Kafka request: %w", err)
+	}
+
+	var size int32
+	if err := binary.Read(conn.r, binary.BigEndian, &size); err != nil {
+		return nil, fmt.Errorf("failed to read Kafka response: %w", err)
+	}
+	if size < 4 || size > maxKafkaResponseBytes {
+		return nil, fmt.Errorf("invalid Kafka response size %d", size)
+	}
+	res := make([]byte, size)
+	if _, err := io.ReadFull(conn.r, res); err != nil {
+		return nil, fmt.Errorf("failed to read Kafka response: %w", err)
+	}
+	if int32(binary.BigEndian.Uint32(res)) != p.correlationID {
+		return nil, errors.New("Kafka response doesn't match the request")
+	}
+	return res[4:], nil
+}
+
+// kafkaConn is a broker connection with buffered reads.
+type kafkaConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func dialKafka(addr string) (*kafkaConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, kafkaDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka broker %s: %w", addr, err)
+	}
+	return &kafkaConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// encodeRecordBatch encodes messages as a v2 record batch without compression, keys or headers.
+func encodeRecordBatch(messages []kafkaMessage) []byte {
+	first, last := messages[0].Timestamp, messages[0].Timestamp
+	for _, m := range messages {
+		if m.Timestamp.After(last) {
+			last = m.Timestamp
+		}
+	}
+
+	var records []byte
+	for i, m := range messages {
+		var r []byte
+		r = append(r, 0) // attributes
+		r = binary.AppendVarint(r, m.Timestamp.Sub(first).Milliseconds())
+		r = binary.AppendVarint(r, int64(i)) // offset delta
+		r = binary.AppendVarint(r, -1)       // null key
+		r = binary.AppendVarint(r, int64(len(m.Value)))
+		r = append(r, m.Value...)
+		r = binary.AppendVarint(r, 0) // headers
+		records = binary.AppendVarint(records, int64(len(r)))
+		records = append(records, r...)
+	}
+
+	// The CRC covers everything from the attributes to the end of the batch
+	var tail kafkaEncoder
+	tail.int16(0) // attributes: no compression, create time
+	tail.int32(int32(len(messages) - 1))
+	tail.int64(first.UnixMilli())
+	tail.int64(last.UnixMilli())
+	tail.int64(-1) // producer ID
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(int32(len(messages)))
+	tail.b = append(tail.b, records...)
+
+	var batch kafkaEncoder
+	batch.int64(0) // base offset, assigned by the broker
+	batch.int32(int32(4 + 1 + 4 + len(tail.b)))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(tail.b, crc32c)))
+	batch.b = append(batch.b, tail.b...)
+	return batch.b
+}
+
+// kafkaEncoder appends Kafka protocol primitives.
+type kafkaEncoder struct {
+	b []byte
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.b = append(e.b, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.b = binary.BigEndian.AppendUint16(e.b, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.b = binary.BigEndian.AppendUint32(e.b, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.b = binary.BigEndian.AppendUint64(e.b, uint64(v)) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *kafkaEncoder) nullableString(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+	e.string(*s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// kafkaDecoder reads Kafka protocol primitives, recording the first error instead of returning it.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = io.ErrUnexpectedEOF
+		return make([]byte, max(n, 0))
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int8() int8   { return int8(d.take(1)[0]) }
+func (d *kafkaDecoder) int16() int16 { return int16(binary.BigEndian.Uint16(d.take(2))) }
+func (d *kafkaDecoder) int32() int32 { return int32(binary.BigEndian.Uint32(d.take(4))) }
+func (d *kafkaDecoder) int64() int64 { return int64(binary.BigEndian.Uint64(d.take(8))) }
+
+func (d *kafkaDecoder) string() string {
+	return string(d.take(int(d.int16())))
+}
+
+func (d *kafkaDecoder) nullableString() {
+	if n := d.int16(); n > 0 {
+		d.take(int(n))
+	}
+}
+
+func (d *kafkaDecoder) int32Array() {
+	if n := d.int32(); n > 0 {
+		d.take(4 * int(n))
+	}
+}
@@ -0,0 +1,344 @@
+//go:build !no_kafka
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// decodeRecordBatch returns the values in a v2 record batch, failing the test on a bad CRC
+func decodeRecordBatch(t *testing.T, b []byte) []string {
+	t.Helper()
+	d := kafkaDecoder{b: b}
+	d.int64() // base offset
+	if length := d.int32(); int(length) != len(d.b) {
+		t.Fatalf("batch length %d doesn't match the %d bytes that follow", length, len(d.b))
+	}
+	d.int32() // leader epoch
+	if magic := d.int8(); magic != 2 {
+		t.Fatalf("expected magic 2; got %d", magic)
+	}
+	if crc := uint32(d.int32()); crc != crc32.Checksum(d.b, crc32c) {
+		t.Fatal("record batch CRC doesn't match")
+	}
+	d.take(2 + 4 + 8 + 8 + 8 + 2 + 4)
+	count := d.int32()
+	if d.err != nil {
+		t.Fatalf("truncated record batch: %v", d.err)
+	}
+
+	var values []string
+	r := d.b
+	varint := func() int64 {
+		v, n := binary.Varint(r)
+		r = r[n:]
+		return v
+	}
+	for i := int32(0); i < count; i++ {
+		varint()  // length
+		r = r[1:] // attributes
+		varint()  // timestamp delta
+		if delta := varint(); delta != int64(i) {
+			t.Errorf("record %d has offset delta %d", i, delta)
+		}
+		if key := varint(); key != -1 {
+			t.Errorf("expected a null key; got length %d", key)
+		}
+		n := varint()
+		values = append(values, string(r[:n]))
+		r = r[n:]
+		varint() // headers
+	}
+	return values
+}
+
+// fakeKafkaBroker answers Metadata and Produce requests for a single-broker cluster
+type fakeKafkaBroker struct {
+	t          *testing.T
+	ln         net.Listener
+	partitions int32
+
+	mu             sync.Mutex
+	metadataCalls  int
+	failProduces   int // produce requests to reject with NOT_LEADER_OR_FOLLOWER
+	producedValues []string
+	producedTo     map[int32]int
+}
+
+func newFakeKafkaBroker(t *testing.T, partitions int32) *fakeKafkaBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	b := &fakeKafkaBroker{t: t, ln: ln, partitions: partitions, producedTo: make(map[int32]int)}
+	go b.serve()
+	t.Cleanup(func() { ln.Close() })
+	return b
+}
+
+func (b *fakeKafkaBroker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeKafkaBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		var size int32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		req := make([]byte, size)
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+		d := kafkaDecoder{b: req}
+		apiKey, _, correlationID := d.int16(), d.int16(), d.int32()
+		d.string() // client ID
+
+		var res kafkaEncoder
+		res.int32(0)
+		res.int32(correlationID)
+		switch apiKey {
+		case kafkaMetadataAPI:
+			b.metadata(&res)
+		case kafkaProduceAPI:
+			b.produce(&d, &res)
+		default:
+			b.t.Errorf("unexpected API key %d", apiKey)
+			return
+		}
+		binary.BigEndian.PutUint32(res.b, uint32(len(res.b)-4))
+		if _, err := conn.Write(res.b); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeKafkaBroker) metadata(res *kafkaEncoder) {
+	b.mu.Lock()
+	b.metadataCalls++
+	b.mu.Unlock()
+
+	host, portStr, _ := net.SplitHostPort(b.ln.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	res.int32(1) // brokers
+	res.int32(0)
+	res.string(host)
+	res.int32(int32(port))
+	res.nullableString(nil)
+	res.int32(0) // controller
+	res.int32(1) // topics
+	res.int16(0)
+	res.string(defaultKafkaTopic)
+	res.int8(0)
+	res.int32(b.partitions)
+	for i := int32(0); i < b.partitions; i++ {
+		res.int16(0)
+		res.int32(i)
+		res.int32(0) // leader
+		res.int32(1) // replicas
+		res.int32(0)
+		res.int32(1) // isr
+		res.int32(0)
+	}
+}
+
+func (b *fakeKafkaBroker) produce(d *kafkaDecoder, res *kafkaEncoder) {
+	d.nullableString()
+	if acks := d.int16(); acks != kafkaAcksAll {
+		b.t.Errorf("expected acks=all; got %d", acks)
+	}
+	d.int32() // timeout
+	d.int32() // topics
+	topic := d.string()
+	d.int32() // partitions
+	partition := d.int32()
+	batch := d.take(int(d.int32()))
+
+	b.mu.Lock()
+	code := int16(0)
+	if b.failProduces > 0 {
+		b.failProduces--
+		code = 6 // NOT_LEADER_OR_FOLLOWER
+	} else {
+		b.producedValues = append(b.producedValues, decodeRecordBatch(b.t, batch)...)
+		b.producedTo[partition]++
+	}
+	b.mu.Unlock()
+
+	res.int32(1)
+	res.string(topic)
+	res.int32(1)
+	res.int32(partition)
+	res.int16(code)
+	res.int64(0)
+	res.int64(-1)
+	res.int32(0) // throttle time
+}
+
+func Test_kafkaRegistered(t *testing.T) {
+	if _, ok := visitProcessorFactories["kafka"]; !ok {
+		t.Error("expected the kafka processor to be registered unless built with no_kafka")
+	}
+}
+
+func Test_loadKafkaConfig(t *testing.T) {
+	if _, enabled, err := loadKafkaConfig(); enabled || err != nil {
+		t.Fatalf("expected Kafka to be off without KAFKA_BROKERS; got %v, %v", enabled, err)
+	}
+
+	t.Setenv("KAFKA_BROKERS", "kafka-1:9092, kafka-2:9092")
+	t.Setenv("KAFKA_TOPIC", "visits")
+	t.Setenv("KAFKA_LINGER", "250ms")
+	cfg, enabled, err := loadKafkaConfig()
+	if !enabled || err != nil {
+		t.Fatalf("expected Kafka to be enabled; got %v, %v", enabled, err)
+	}
+	if len(cfg.Brokers) != 2 || cfg.Brokers[1] != "kafka-2:9092" || cfg.Topic != "visits" || cfg.Linger != 250*time.Millisecond || cfg.BatchSize != defaultKafkaBatchSize {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	for _, tt := range []struct{ env, value string }{
+		{"KAFKA_BROKERS", "kafka-1"},
+		{"KAFKA_BATCH_SIZE", "0"},
+		{"KAFKA_LINGER", "-1s"},
+	} {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			if _, _, err := loadKafkaConfig(); err == nil {
+				t.Errorf("expected an error for %s=%q", tt.env, tt.value)
+			}
+		})
+	}
+}
+
+func Test_encodeRecordBatch(t *testing.T) {
+	ts := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	batch := encodeRecordBatch([]kafkaMessage{
+		{Value: []byte(`{"kind":"visit"}`), Timestamp: ts},
+		{Value: []byte(`{"kind":"event"}`), Timestamp: ts.Add(time.Second)},
+	})
+
+	got := decodeRecordBatch(t, batch)
+	if len(got) != 2 || got[0] != `{"kind":"visit"}` || got[1] != `{"kind":"event"}` {
+		t.Errorf("unexpected records %q", got)
+	}
+}
+
+func Test_kafkaProducer(t *testing.T) {
+	broker := newFakeKafkaBroker(t, 2)
+	p := newKafkaProducer(kafkaConfig{
+		Brokers:   []string{broker.ln.Addr().String()},
+		Topic:     defaultKafkaTopic,
+		BatchSize: 2,
+		Linger:    10 * time.Millisecond,
+	}, nil)
+
+	now := time.Now()
+	for _, v := range []string{"a", "b", "c"} {
+		p.Publish([]byte(v), now)
+	}
+	p.Close()
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if len(broker.producedValues) != 3 || broker.producedValues[2] != "c" {
+		t.Errorf("expected all 3 records to be produced; got %q", broker.producedValues)
+	}
+	if broker.producedTo[0] != 1 || broker.producedTo[1] != 1 {
+		t.Errorf("expected the two batches to go to different partitions; got %v", broker.producedTo)
+	}
+}
+
+func Test_kafkaProducer_Retry(t *testing.T) {
+	broker := newFakeKafkaBroker(t, 1)
+	broker.failProduces = 1
+	p := newKafkaProducer(kafkaConfig{
+		Brokers:   []string{broker.ln.Addr().String()},
+		Topic:     defaultKafkaTopic,
+		BatchSize: 10,
+		Linger:    time.Millisecond,
+	}, nil)
+
+	p.Publish([]byte("a"), time.Now())
+	p.Close()
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if len(broker.producedValues) != 1 {
+		t.Errorf("expected the rejected batch to be retried; got %q", broker.producedValues)
+	}
+	if broker.metadataCalls != 2 {
+		t.Errorf("expected metadata to be refreshed after the error; got %d lookups", broker.metadataCalls)
+	}
+}
+
+func Test_kafkaProducer_Spill(t *testing.T) {
+	broker := newFakeKafkaBroker(t, 1)
+	broker.failProduces = kafkaMaxAttempts
+	mockDataStore := &MockDataStore{}
+	// Without run, nothing drains the unbuffered queue, so Publish always finds it full
+	p := &kafkaProducer{
+		cfg:          kafkaConfig{Brokers: []string{broker.ln.Addr().String()}, Topic: defaultKafkaTopic},
+		outbox:       mockDataStore,
+		retryBackoff: time.Millisecond,
+		messages:     make(chan kafkaMessage),
+		conns:        make(map[int32]*kafkaConn),
+	}
+	defer p.closeConns()
+
+	p.Publish([]byte(`{"kind":"visit"}`), time.Now())
+	if err := p.Offer([]byte(`{"kind":"visit"}`), time.Now()); err == nil {
+		t.Error("expected Offer to report the full buffer")
+	}
+	// A batch that runs out of attempts is spilled too
+	p.flush([]kafkaMessage{{Value: []byte(`{"kind":"event"}`), Timestamp: time.Now()}})
+
+	if len(mockDataStore.outbox) != 2 {
+		t.Fatalf("expected 2 records spilled, got %+v", mockDataStore.outbox)
+	}
+	for i, want := range []string{`{"kind":"visit"}`, `{"kind":"event"}`} {
+		if m := mockDataStore.outbox[i]; m.Destination != kafkaDestination || string(m.Payload) != want {
+			t.Errorf("expected %s spilled for kafka, got %+v", want, m)
+		}
+	}
+}
+
+func Test_kafkaProducer_roundTripResponseSize(t *testing.T) {
+	for _, size := range []int32{-1, 0, maxKafkaResponseBytes + 1} {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			var length int32
+			if binary.Read(server, binary.BigEndian, &length) != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, server, int64(length)); err != nil {
+				return
+			}
+			binary.Write(server, binary.BigEndian, size)
+		}()
+		p := &kafkaProducer{}
+		_, err := p.roundTrip(&kafkaConn{Conn: client, r: bufio.NewReader(client)}, kafkaMetadataAPI, kafkaMetadataVer, nil)
+		if err == nil || errors.Is(err, io.EOF) {
+			t.Errorf("expected size %d rejected, got %v", size, err)
+		}
+		client.Close()
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// lambdaRuntimeAPIVersion prefixes the paths of the Lambda runtime API
+const lambdaRuntimeAPIVersion = "/2018-06-01/runtime/invocation/"
+
+// lambdaAdapter serves API Gateway and Lambda Function URL events with the same handler the
+// server uses, so every middleware and store applies unchanged. It speaks the Lambda runtime
+// API directly, which Lambda offers to any binary it runs, from a zip with a bootstrap
+// executable on the provided.al2023 runtime or from the container image.
+type lambdaAdapter struct {
+	api     string // host:port from AWS_LAMBDA_RUNTIME_API
+	handler http.Handler
+	client  *http.Client
+}
+
+func newLambdaAdapter(api string, handler http.Handler) *lambdaAdapter {
+	// No timeout: asking for the next invocation blocks until there is one
+	return &lambdaAdapter{api: api, handler: handler, client: &http.Client{}}
+}
+
+// lambdaHTTPEvent holds the fields used from both payload formats: 2.0, sent by HTTP APIs and
+// Function URLs, and 1.0, sent by REST APIs.
+type lambdaHTTPEvent struct {
+	Version         string            `json:"version"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+
+	// Payload format 2.0
+	RawPath        string   `json:"rawPath"`
+	RawQueryString string   `json:"rawQueryString"`
+	Cookies        []string `json:"cookies"`
+
+	// Payload format 1.0
+	HTTPMethod                      string              `json:"httpMethod"`
+	Path                            string              `json:"path"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+
+	RequestContext struct {
+		HTTP struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+		Identity struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+	} `json:"requestContext"`
+}
+
+// lambdaHTTPResponse is the response for either payload format; format 2.0 ignores
+// multiValueHeaders, taking Set-Cookie from cookies instead.
+type lambdaHTTPResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Cookies           []string            `json:"cookies,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// Run serves invocations until ctx is done or the runtime API fails.
+func (a *lambdaAdapter) Run(ctx context.Context) error {
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+a.api+lambdaRuntimeAPIVersion+"next", nil)
+		if err != nil {
+			return fmt.Errorf("failed to create invocation request: %w", err)
+		}
+		res, err := a.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to get next invocation: %w", err)
+		}
+		payload, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read invocation: %w", err)
+		}
+
+		id := res.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		deadline := time.Now().Add(time.Minute)
+		if ms, err := strconv.ParseInt(res.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+			deadline = time.UnixMilli(ms)
+		}
+		if err := a.respond(ctx, id, payload, deadline); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// respond handles one invocation and reports its outcome to the runtime API.
+func (a *lambdaAdapter) respond(ctx context.Context, id string, payload []byte, deadline time.Time) error {
+	invokeCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	path, body := "/response", []byte(nil)
+	response, err := a.invoke(invokeCtx, payload)
+	if err == nil {
+		body, err = json.Marshal(response)
+	}
+	if err != nil {
+		errorLogger.Printf("Error handling Lambda invocation %s: %v", id, err)
+		path = "/error"
+		body, _ = json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": "InvalidEvent"})
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+a.api+lambdaRuntimeAPIVersion+id+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create invocation response: %w", err)
+	}
+	res, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send invocation response: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("runtime API returned status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// invoke serves an HTTP event with the handler.
+func (a *lambdaAdapter) invoke(ctx context.Context, payload []byte) (*lambdaHTTPResponse, error) {
+	var event lambdaHTTPEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	r, err := event.request(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &lambdaResponseWriter{header: make(http.Header)}
+	a.handler.ServeHTTP(w, r)
+	return w.response(event.Version == "2.0"), nil
+}
+
+// request builds the HTTP request an event describes.
+func (e *lambdaHTTPEvent) request(ctx context.Context) (*http.Request, error) {
+	method, path, query, sourceIP := e.HTTPMethod, e.Path, e.RawQueryString, e.RequestContext.Identity.SourceIP
+	if e.Version == "2.0" {
+		method, path, sourceIP = e.RequestContext.HTTP.Method, e.RawPath, e.RequestContext.HTTP.SourceIP
+	} else {
+		values := url.Values(e.MultiValueQueryStringParameters)
+		if values == nil {
+			values = make(url.Values)
+			for k, v := range e.QueryStringParameters {
+				values.Set(k, v)
+			}
+		}
+		query = values.Encode()
+	}
+	if method == "" || path == "" {
+		return nil, fmt.Errorf("not an HTTP event")
+	}
+
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(e.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event body: %w", err)
+		}
+		body = decoded
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, (&url.URL{Path: path, RawQuery: query}).RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid event request: %w", err)
+	}
+	for k, values := range e.MultiValueHeaders {
+		for _, v := range values {
+			r.Header.Add(k, v)
+		}
+	}
+	for k, v := range e.Headers {
+		if _, ok := r.Header[http.CanonicalHeaderKey(k)]; !ok {
+			r.Header.Set(k, v)
+		}
+	}
+	if len(e.Cookies) > 0 {
+		r.Header.Set("Cookie", strings.Join(e.Cookies, "; "))
+	}
+	r.Host = r.Header.Get("Host")
+	r.RemoteAddr = net.JoinHostPort(sourceIP, "0")
+	r.ContentLength = int64(len(body))
+	r.RequestURI = r.URL.RequestURI()
+	return r, nil
+}
+
+// lambdaResponseWriter buffers a response, as Lambda returns it whole.
+type lambdaResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *lambdaResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *lambdaResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *lambdaResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// response converts the buffered response to the event format. Compressed and other binary
+// bodies are base64-encoded, as the response must be JSON.
+func (w *lambdaResponseWriter) response(v2 bool) *lambdaHTTPResponse {
+	res := &lambdaHTTPResponse{StatusCode: w.status, Headers: make(map[string]string)}
+	if res.StatusCode == 0 {
+		res.StatusCode = http.StatusOK
+	}
+	for k, values := range w.header {
+		switch {
+		case v2 && k == "Set-Cookie":
+			res.Cookies = values
+		case v2:
+			res.Headers[k] = strings.Join(values, ",")
+		default:
+			if res.MultiValueHeaders == nil {
+				res.MultiValueHeaders = make(map[string][]string)
+			}
+			res.MultiValueHeaders[k] = values
+		}
+	}
+
+	body := w.body.Bytes()
+	if w.header.Get("Content-Encoding") != "" || !utf8.Valid(body) {
+		res.Body, res.IsBase64Encoded = base64.StdEncoding.EncodeToString(body), true
+	} else {
+		res.Body = string(body)
+	}
+	return res
+}
+
+// listenAddr is the address the server listens on: PORT when set, as Cloud Functions and
+// Cloud Run expect, or else :8000.
+func listenAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8000"
+}
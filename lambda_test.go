@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoHandler answers with the request it received
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	http.SetCookie(w, &http.Cookie{Name: "a", Value: "1"})
+	http.SetCookie(w, &http.Cookie{Name: "b", Value: "2"})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"method": r.Method,
+		"uri":    r.RequestURI,
+		"host":   r.Host,
+		"ip":     clientIP(r),
+		"cookie": r.Header.Get("Cookie"),
+		"body":   string(body),
+	})
+})
+
+func Test_lambdaAdapter_invoke(t *testing.T) {
+	tests := []struct {
+		name        string
+		event       string
+		wantCookies []string
+		wantHeaders map[string][]string
+	}{
+		{
+			name: "Payload format 2.0",
+			event: `{"version":"2.0","rawPath":"/api/count","rawQueryString":"page=%2Fcv","cookies":["s=1","t=2"],
+				"headers":{"host":"abc.lambda-url.eu-west-1.on.aws","content-type":"application/json"},
+				"requestContext":{"http":{"method":"POST","sourceIp":"203.0.113.7"}},
+				"body":"eyJ4IjoxfQ==","isBase64Encoded":true}`,
+			wantCookies: []string{"a=1", "b=2"},
+		},
+		{
+			name: "Payload format 1.0",
+			event: `{"version":"1.0","httpMethod":"POST","path":"/api/count",
+				"multiValueQueryStringParameters":{"page":["/cv"]},
+				"multiValueHeaders":{"Host":["abc.execute-api.eu-west-1.amazonaws.com"],"Cookie":["s=1; t=2"]},
+				"requestContext":{"identity":{"sourceIp":"203.0.113.7"}},
+				"body":"{\"x\":1}","isBase64Encoded":false}`,
+			wantHeaders: map[string][]string{"Set-Cookie": {"a=1", "b=2"}, "Content-Type": {"application/json"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := newLambdaAdapter("", echoHandler).invoke(context.Background(), []byte(tt.event))
+			if err != nil {
+				t.Fatalf("invoke() error = %v", err)
+			}
+			if res.StatusCode != http.StatusCreated || res.IsBase64Encoded {
+				t.Errorf("invoke() status = %d, base64 = %v", res.StatusCode, res.IsBase64Encoded)
+			}
+			if !reflect.DeepEqual(res.Cookies, tt.wantCookies) {
+				t.Errorf("cookies = %v, want %v", res.Cookies, tt.wantCookies)
+			}
+			if tt.wantHeaders != nil && !reflect.DeepEqual(res.MultiValueHeaders, tt.wantHeaders) {
+				t.Errorf("multiValueHeaders = %v, want %v", res.MultiValueHeaders, tt.wantHeaders)
+			}
+
+			var got map[string]string
+			if err := json.Unmarshal([]byte(res.Body), &got); err != nil {
+				t.Fatalf("invalid body %q: %v", res.Body, err)
+			}
+			if got["method"] != "POST" || got["uri"] != "/api/count?page=%2Fcv" || got["ip"] != "203.0.113.7" ||
+				got["cookie"] != "s=1; t=2" || got["body"] != `{"x":1}` || !strings.HasPrefix(got["host"], "abc.") {
+				t.Errorf("handler saw %v", got)
+			}
+		})
+	}
+}
+
+func Test_lambdaAdapter_invoke_Binary(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte{0x1f, 0x8b, 0x08})
+	})
+	event := `{"version":"2.0","rawPath":"/","requestContext":{"http":{"method":"GET","sourceIp":"203.0.113.7"}}}`
+	res, err := newLambdaAdapter("", handler).invoke(context.Background(), []byte(event))
+	if err != nil {
+		t.Fatalf("invoke() error = %v", err)
+	}
+	if !res.IsBase64Encoded || res.Body != base64.StdEncoding.EncodeToString([]byte{0x1f, 0x8b, 0x08}) {
+		t.Errorf("invoke() body = %q, base64 = %v", res.Body, res.IsBase64Encoded)
+	}
+	if res.StatusCode != http.StatusOK || res.Headers["Content-Encoding"] != "gzip" {
+		t.Errorf("invoke() = %d %v", res.StatusCode, res.Headers)
+	}
+}
+
+func Test_lambdaAdapter_Run(t *testing.T) {
+	events := []string{
+		`{"version":"2.0","rawPath":"/api/count","requestContext":{"http":{"method":"GET","sourceIp":"203.0.113.7"}}}`,
+		`{"source":"aws.events"}`,
+	}
+	posted := make(chan string, len(events))
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			posted <- r.URL.Path + " " + string(body)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if len(events) == 0 {
+			<-r.Context().Done() // no more invocations
+			return
+		}
+		w.Header().Set("Lambda-Runtime-Aws-Request-Id", "req-"+string(rune('1'+2-len(events))))
+		w.Header().Set("Lambda-Runtime-Deadline-Ms", "9999999999999")
+		w.Write([]byte(events[0]))
+		events = events[1:]
+	}))
+	defer runtime.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- newLambdaAdapter(strings.TrimPrefix(runtime.URL, "http://"), echoHandler).Run(ctx)
+	}()
+
+	for _, want := range []string{"/2018-06-01/runtime/invocation/req-1/response", "/2018-06-01/runtime/invocation/req-2/error"} {
+		select {
+		case got := <-posted:
+			if !strings.HasPrefix(got, want+" ") {
+				t.Errorf("posted %q, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func Test_listenAddr(t *testing.T) {
+	t.Setenv("PORT", "")
+	if got := listenAddr(); got != ":8000" {
+		t.Errorf("listenAddr() = %q, want :8000", got)
+	}
+	t.Setenv("PORT", "8080")
+	if got := listenAddr(); got != ":8080" {
+		t.Errorf("listenAddr() = %q, want :8080", got)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLatencyWindow = 5 * time.Minute
+	latencyWindowSlots   = 10 // the window slides one slot at a time
+
+	// Buckets grow geometrically from minTrackedLatency, so percentiles are accurate to
+	// within latencyBucketGrowth-1 of the true value anywhere in the tracked range
+	minTrackedLatency   = 100 * time.Microsecond
+	maxTrackedLatency   = time.Minute
+	latencyBucketGrowth = 1.05
+)
+
+var latencyBucketCount = latencyBucket(maxTrackedLatency) + 1
+
+// latencyBucket returns the histogram bucket for d; bucket i holds latencies below
+// minTrackedLatency*latencyBucketGrowth^(i+1). Out-of-range values land in the end buckets.
+func latencyBucket(d time.Duration) int {
+	if d < minTrackedLatency {
+		return 0
+	}
+	if d > maxTrackedLatency {
+		d = maxTrackedLatency
+	}
+	return int(math.Log(float64(d)/float64(minTrackedLatency)) / math.Log(latencyBucketGrowth))
+}
+
+// latencyBucketUpperBound is the largest latency reported for bucket i.
+func latencyBucketUpperBound(i int) time.Duration {
+	d := time.Duration(float64(minTrackedLatency) * math.Pow(latencyBucketGrowth, float64(i+1)))
+	return min(d, maxTrackedLatency)
+}
+
+// latencySlot is the histogram for one slot of the window, starting at start.
+type latencySlot struct {
+	start  time.Time
+	counts []uint64
+}
+
+// latencyWindow is a sliding-window latency histogram. The window is split into slots that are
+// reset as it moves past them, so memory stays fixed however many requests are recorded.
+type latencyWindow struct {
+	mu        sync.Mutex
+	clock     Clock
+	slotWidth time.Duration
+	slots     []latencySlot
+}
+
+func newLatencyWindow(window time.Duration, clock Clock) *latencyWindow {
+	w := &latencyWindow{
+		clock:     clock,
+		slotWidth: window / latencyWindowSlots,
+		slots:     make([]latencySlot, latencyWindowSlots),
+	}
+	for i := range w.slots {
+		w.slots[i].counts = make([]uint64, latencyBucketCount)
+	}
+	return w
+}
+
+// Window returns the length of time the percentiles cover.
+func (w *latencyWindow) Window() time.Duration {
+	return w.slotWidth * time.Duration(len(w.slots))
+}
+
+// Record adds one request latency to the current slot.
+func (w *latencyWindow) Record(d time.Duration) {
+	start := w.clock.Now().Truncate(w.slotWidth)
+	slot := &w.slots[int(start.UnixNano()/int64(w.slotWidth))%len(w.slots)]
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !slot.start.Equal(start) {
+		// The slot last held data from a previous lap of the window
+		slot.start = start
+		clear(slot.counts)
+	}
+	slot.counts[latencyBucket(d)]++
+}
+
+// latencySummary is the window's request count and latency percentiles.
+type latencySummary struct {
+	Requests      uint64
+	P50, P95, P99 time.Duration
+}
+
+// Summary merges the slots still inside the window and computes percentiles from them.
+func (w *latencyWindow) Summary() latencySummary {
+	oldest := w.clock.Now().Truncate(w.slotWidth).Add(-w.slotWidth * time.Duration(len(w.slots)-1))
+	merged := make([]uint64, latencyBucketCount)
+	var total uint64
+
+	w.mu.Lock()
+	for _, slot := range w.slots {
+		if slot.start.Before(oldest) {
+			continue
+		}
+		for i, c := range slot.counts {
+			merged[i] += c
+			total += c
+		}
+	}
+	w.mu.Unlock()
+
+	summary := latencySummary{Requests: total}
+	if total == 0 {
+		return summary
+	}
+	summary.P50 = percentile(merged, total, 0.50)
+	summary.P95 = percentile(merged, total, 0.95)
+	summary.P99 = percentile(merged, total, 0.99)
+	return summary
+}
+
+// percentile returns the upper bound of the bucket holding the q-th quantile.
+func percentile(counts []uint64, total uint64, q float64) time.Duration {
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen >= rank {
+			return latencyBucketUpperBound(i)
+		}
+	}
+	return maxTrackedLatency
+}
+
+// Handler latencies reported by /api/status
+var handlerLatencies = newLatencyWindow(defaultLatencyWindow, realClock{})
+
+// middleware that records how long each request took in the given window, leaving out long
+// polls, which mostly wait
+func latencyMiddleware(next http.Handler, w *latencyWindow) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if isLongPoll(r) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		start := time.Now()
+		next.ServeHTTP(rw, r)
+		w.Record(time.Since(start))
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// within reports whether got is no more than one bucket above want
+func within(got, want time.Duration) bool {
+	return got >= want && float64(got) <= float64(want)*latencyBucketGrowth*latencyBucketGrowth
+}
+
+func Test_latencyBucket(t *testing.T) {
+	if got := latencyBucket(0); got != 0 {
+		t.Errorf("latencyBucket(0) = %d, want 0", got)
+	}
+	if got := latencyBucket(time.Hour); got != latencyBucketCount-1 {
+		t.Errorf("latencyBucket(1h) = %d, want the last bucket %d", got, latencyBucketCount-1)
+	}
+	for _, d := range []time.Duration{time.Millisecond, 37 * time.Millisecond, 2 * time.Second} {
+		if upper := latencyBucketUpperBound(latencyBucket(d)); !within(upper, d) {
+			t.Errorf("bucket for %v has upper bound %v", d, upper)
+		}
+	}
+}
+
+func Test_latencyWindow_Summary(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	w := newLatencyWindow(time.Minute, clock)
+
+	if got := w.Summary(); got != (latencySummary{}) {
+		t.Errorf("expected an empty summary; got %+v", got)
+	}
+
+	// 1..100ms, one request each
+	for i := 1; i <= 100; i++ {
+		w.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	got := w.Summary()
+	if got.Requests != 100 {
+		t.Errorf("expected 100 requests; got %d", got.Requests)
+	}
+	for _, p := range []struct {
+		name string
+		got  time.Duration
+		want time.Duration
+	}{
+		{"p50", got.P50, 50 * time.Millisecond},
+		{"p95", got.P95, 95 * time.Millisecond},
+		{"p99", got.P99, 99 * time.Millisecond},
+	} {
+		if !within(p.got, p.want) {
+			t.Errorf("%s = %v, want about %v", p.name, p.got, p.want)
+		}
+	}
+}
+
+func Test_latencyWindow_Slides(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	w := newLatencyWindow(time.Minute, clock)
+
+	w.Record(time.Second)
+	clock.Advance(30 * time.Second)
+	w.Record(10 * time.Millisecond)
+
+	if got := w.Summary().Requests; got != 2 {
+		t.Fatalf("expected both requests inside the window; got %d", got)
+	}
+
+	// The first request falls out of the window, the second is still in it
+	clock.Advance(35 * time.Second)
+	got := w.Summary()
+	if got.Requests != 1 || !within(got.P99, 10*time.Millisecond) {
+		t.Errorf("expected only the 10ms request to remain; got %+v", got)
+	}
+
+	// A full lap later the reused slots are reset
+	clock.Advance(2 * time.Minute)
+	w.Record(time.Millisecond)
+	if got := w.Summary().Requests; got != 1 {
+		t.Errorf("expected stale slots to be cleared; got %d requests", got)
+	}
+}
+
+func Test_latencyMiddleware(t *testing.T) {
+	w := newLatencyWindow(time.Minute, realClock{})
+	handler := latencyMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+	}), w)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, statusPath, nil))
+
+	got := w.Summary()
+	if got.Requests != 1 || got.P50 < 2*time.Millisecond {
+		t.Errorf("expected one request of at least 2ms; got %+v", got)
+	}
+}
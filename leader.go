@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// backgroundJobsLease is the lease whose holder runs the scheduled background jobs
+	backgroundJobsLease = "background-jobs"
+
+	defaultLeaderLeaseTTL = 30 * time.Second
+	leaderReleaseTimeout  = 5 * time.Second
+)
+
+// leaderConfig controls electing the replica that runs the scheduled background jobs.
+type leaderConfig struct {
+	Enabled  bool
+	LeaseTTL time.Duration
+	Holder   string // identifies this replica in the lease
+}
+
+// loadLeaderConfig reads LEADER_ELECTION, which should be "true" whenever more than one
+// replica runs, and LEADER_LEASE_TTL, how long a replica that stops renewing keeps the jobs.
+// Replicas are named by POD_NAME, or else the hostname, which Kubernetes sets to the pod name.
+func loadLeaderConfig() (leaderConfig, error) {
+	cfg := leaderConfig{LeaseTTL: defaultLeaderLeaseTTL}
+	if v := os.Getenv("LEADER_ELECTION"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return leaderConfig{}, fmt.Errorf("invalid LEADER_ELECTION %q: must be true or false", v)
+		}
+		cfg.Enabled = enabled
+	}
+	if v := os.Getenv("LEADER_LEASE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 3*time.Second {
+			return leaderConfig{}, fmt.Errorf("invalid LEADER_LEASE_TTL %q: must be at least 3s", v)
+		}
+		cfg.LeaseTTL = d
+	}
+
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return leaderConfig{}, fmt.Errorf("failed to name this replica: %w", err)
+		}
+		name = hostname
+	}
+	// The process ID tells apart replicas sharing a host, and a restart from its old lease
+	cfg.Holder = fmt.Sprintf("%s-%d", name, os.Getpid())
+	return cfg, nil
+}
+
+// leaderElector runs jobs on one replica at a time, whichever holds a lease in the DataStore.
+type leaderElector struct {
+	store DataStore
+	name  string
+	cfg   leaderConfig
+}
+
+func newLeaderElector(store DataStore, name string, cfg leaderConfig) *leaderElector {
+	return &leaderElector{store: store, name: name, cfg: cfg}
+}
+
+// Run campaigns for the lease until ctx is done, running jobs while it holds it. The lease is
+// renewed every third of its TTL, and the jobs are stopped as soon as a renewal fails, well
+// before the lease can expire and pass to another replica.
+func (e *leaderElector) Run(ctx context.Context, jobs ...func(context.Context)) {
+	ticker := time.NewTicker(e.cfg.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	var stop func()
+	defer func() {
+		if stop != nil {
+			stop()
+			// Let another replica take over straight away rather than after the TTL
+			releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), leaderReleaseTimeout)
+			defer cancel()
+			_ = e.store.ReleaseLease(releaseCtx, e.name, e.cfg.Holder)
+		}
+	}()
+
+	for {
+		// Failures are logged by the store and count as not holding the lease
+		acquired, _ := e.store.AcquireLease(ctx, e.name, e.cfg.Holder, e.cfg.LeaseTTL)
+		switch {
+		case acquired && stop == nil:
+			log.Printf("Acquired the %s lease as %s: starting jobs", e.name, e.cfg.Holder)
+			stop = startJobs(ctx, jobs)
+		case !acquired && stop != nil && ctx.Err() == nil:
+			log.Printf("Lost the %s lease: stopping jobs", e.name)
+			stop()
+			stop = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startJobs runs each job in its own goroutine, returning a function that cancels them and
+// waits for them to return.
+func startJobs(ctx context.Context, jobs []func(context.Context)) func() {
+	jobCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job(jobCtx)
+		}()
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// leaseStore keeps a single lease in memory; leases never expire on their own
+type leaseStore struct {
+	MockDataStore
+	mu     sync.Mutex
+	holder string
+	down   bool
+}
+
+func (s *leaseStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return false, context.DeadlineExceeded
+	}
+	if s.holder == "" {
+		s.holder = holder
+	}
+	return s.holder == holder, nil
+}
+
+func (s *leaseStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.holder == holder {
+		s.holder = ""
+	}
+	return nil
+}
+
+func (s *leaseStore) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+// countingJob counts the jobs currently running
+type countingJob struct {
+	running atomic.Int32
+}
+
+func (j *countingJob) Run(ctx context.Context) {
+	j.running.Add(1)
+	defer j.running.Add(-1)
+	<-ctx.Done()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func Test_leaderElector(t *testing.T) {
+	store := &leaseStore{}
+	job := &countingJob{}
+	elector := func(holder string) *leaderElector {
+		return newLeaderElector(store, backgroundJobsLease, leaderConfig{Enabled: true, LeaseTTL: 30 * time.Millisecond, Holder: holder})
+	}
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		elector("pod-a").Run(firstCtx, job.Run)
+	}()
+	waitFor(t, "the first replica to lead", func() bool { return job.running.Load() == 1 })
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go elector("pod-b").Run(secondCtx, job.Run)
+
+	// The second replica keeps campaigning without running the job
+	time.Sleep(50 * time.Millisecond)
+	if n := job.running.Load(); n != 1 {
+		t.Fatalf("expected the job to run once; got %d", n)
+	}
+
+	// Shutting down the leader releases the lease to the other replica
+	stopFirst()
+	<-firstDone
+	waitFor(t, "the second replica to take over", func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.holder == "pod-b" && job.running.Load() == 1
+	})
+}
+
+func Test_leaderElector_RenewalFailure(t *testing.T) {
+	store := &leaseStore{}
+	job := &countingJob{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go newLeaderElector(store, backgroundJobsLease, leaderConfig{Enabled: true, LeaseTTL: 30 * time.Millisecond, Holder: "pod-a"}).Run(ctx, job.Run)
+	waitFor(t, "the replica to lead", func() bool { return job.running.Load() == 1 })
+
+	store.setDown(true)
+	waitFor(t, "the job to stop when the lease can't be renewed", func() bool { return job.running.Load() == 0 })
+
+	store.setDown(false)
+	waitFor(t, "the job to restart once the lease is renewed", func() bool { return job.running.Load() == 1 })
+}
+
+func Test_loadLeaderConfig(t *testing.T) {
+	t.Setenv("POD_NAME", "resume-backend-7d9f")
+	cfg, err := loadLeaderConfig()
+	if err != nil || cfg.Enabled || cfg.LeaseTTL != defaultLeaderLeaseTTL || !strings.HasPrefix(cfg.Holder, "resume-backend-7d9f-") {
+		t.Errorf("unexpected defaults %+v, %v", cfg, err)
+	}
+
+	t.Setenv("LEADER_ELECTION", "true")
+	t.Setenv("LEADER_LEASE_TTL", "1m")
+	cfg, err = loadLeaderConfig()
+	if err != nil || !cfg.Enabled || cfg.LeaseTTL != time.Minute {
+		t.Errorf("unexpected config %+v, %v", cfg, err)
+	}
+
+	for env, v := range map[string]string{"LEADER_ELECTION": "maybe", "LEADER_LEASE_TTL": "1s"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, v)
+			if _, err := loadLeaderConfig(); err == nil {
+				t.Errorf("expected an error for %s=%q", env, v)
+			}
+		})
+	}
+}
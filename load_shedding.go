@@ -0,0 +1,205 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultLoadShedMaxConcurrent = 100
+	defaultLoadShedMinConcurrent = 10
+	defaultLoadShedMaxQueue      = 50
+	defaultLoadShedQueueTimeout  = 100 * time.Millisecond
+	defaultLoadShedRetryAfter    = time.Second
+	defaultLoadShedTargetLatency = 500 * time.Millisecond
+
+	// loadShedBackoff is the factor the limit is cut by when requests are slower than the target
+	loadShedBackoff = 0.9
+)
+
+// loadShedConfig bounds how many requests are served concurrently and how many may wait for a
+// slot. With a TargetLatency, the concurrency limit adapts between MinConcurrent and
+// MaxConcurrent: it grows by one for every limit's worth of requests served within the target,
+// and is cut by loadShedBackoff when they take longer.
+type loadShedConfig struct {
+	MaxConcurrent int
+	MinConcurrent int
+	MaxQueue      int
+	QueueTimeout  time.Duration
+	RetryAfter    time.Duration
+	TargetLatency time.Duration // 0 keeps the limit at MaxConcurrent
+}
+
+// loadLoadShedConfig reads LOAD_SHED_* settings from the environment.
+// LOAD_SHED_MAX_CONCURRENT=0 disables load shedding, and LOAD_SHED_TARGET_LATENCY=0 keeps
+// the limit fixed at LOAD_SHED_MAX_CONCURRENT.
+func loadLoadShedConfig() loadShedConfig {
+	cfg := loadShedConfig{
+		MaxConcurrent: defaultLoadShedMaxConcurrent,
+		MinConcurrent: defaultLoadShedMinConcurrent,
+		MaxQueue:      defaultLoadShedMaxQueue,
+		QueueTimeout:  defaultLoadShedQueueTimeout,
+		RetryAfter:    defaultLoadShedRetryAfter,
+		TargetLatency: defaultLoadShedTargetLatency,
+	}
+
+	for _, setting := range []struct {
+		env string
+		dst *int
+	}{
+		{"LOAD_SHED_MAX_CONCURRENT", &cfg.MaxConcurrent},
+		{"LOAD_SHED_MIN_CONCURRENT", &cfg.MinConcurrent},
+		{"LOAD_SHED_MAX_QUEUE", &cfg.MaxQueue},
+	} {
+		if v := os.Getenv(setting.env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Printf("Invalid %s %q, using %d", setting.env, v, *setting.dst)
+				continue
+			}
+			*setting.dst = n
+		}
+	}
+
+	for _, setting := range []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"LOAD_SHED_QUEUE_TIMEOUT", &cfg.QueueTimeout},
+		{"LOAD_SHED_RETRY_AFTER", &cfg.RetryAfter},
+		{"LOAD_SHED_TARGET_LATENCY", &cfg.TargetLatency},
+	} {
+		if v := os.Getenv(setting.env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				log.Printf("Invalid %s %q, using %s", setting.env, v, *setting.dst)
+				continue
+			}
+			*setting.dst = d
+		}
+	}
+
+	return cfg
+}
+
+// loadShedder admits up to its current limit of requests and queues up to MaxQueue more,
+// admitting queued requests in order as slots free up.
+type loadShedder struct {
+	cfg    loadShedConfig
+	queued atomic.Int64
+
+	mu          sync.Mutex
+	limit       float64 // requests admitted at once, between floor and MaxConcurrent
+	floor       float64
+	inflight    int
+	waiters     list.List // of chan struct{}, closed when the waiter is given a slot
+	lastBackoff time.Time
+}
+
+func newLoadShedder(cfg loadShedConfig) *loadShedder {
+	floor := min(max(cfg.MinConcurrent, 1), cfg.MaxConcurrent)
+	return &loadShedder{cfg: cfg, limit: float64(cfg.MaxConcurrent), floor: float64(floor)}
+}
+
+// acquire reserves a slot, waiting in the queue for at most QueueTimeout.
+// It returns false if the request should be shed.
+func (s *loadShedder) acquire(r *http.Request) bool {
+	s.mu.Lock()
+	if s.inflight < int(s.limit) && s.waiters.Len() == 0 {
+		s.inflight++
+		s.mu.Unlock()
+		return true
+	}
+	if s.waiters.Len() >= s.cfg.MaxQueue {
+		s.mu.Unlock()
+		return false
+	}
+	ready := make(chan struct{})
+	waiter := s.waiters.PushBack(ready)
+	s.queued.Add(1)
+	s.mu.Unlock()
+	defer s.queued.Add(-1)
+
+	timer := time.NewTimer(s.cfg.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-ready:
+		// Given a slot while giving up; pass it on
+		s.inflight--
+		s.admitLocked()
+	default:
+		s.waiters.Remove(waiter)
+	}
+	return false
+}
+
+// release frees the slot of a request that took latency to serve, adjusting the limit.
+func (s *loadShedder) release(latency time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight--
+	if target := s.cfg.TargetLatency; target > 0 {
+		switch {
+		case latency <= target:
+			s.limit = min(s.limit+1/s.limit, float64(s.cfg.MaxConcurrent))
+		case now.Sub(s.lastBackoff) >= target:
+			// Once per target latency, as the requests in flight when it was cut finish slow too
+			s.limit = max(s.limit*loadShedBackoff, s.floor)
+			s.lastBackoff = now
+		}
+	}
+	s.admitLocked()
+}
+
+// admitLocked hands free slots to queued requests. s.mu must be held.
+func (s *loadShedder) admitLocked() {
+	for s.inflight < int(s.limit) && s.waiters.Len() > 0 {
+		close(s.waiters.Remove(s.waiters.Front()).(chan struct{}))
+		s.inflight++
+	}
+}
+
+// middleware that rejects excess requests with 503 and Retry-After before they reach the
+// database. Long polls are let through, as they would hold a slot while idle.
+func loadSheddingMiddleware(next http.Handler, cfg loadShedConfig) http.Handler {
+	if cfg.MaxConcurrent <= 0 {
+		return next
+	}
+	return newLoadShedder(cfg).wrap(next)
+}
+
+func (s *loadShedder) wrap(next http.Handler) http.Handler {
+	// Retry-After is in whole seconds, rounded up
+	retryAfter := strconv.Itoa(int((s.cfg.RetryAfter + time.Second - 1) / time.Second))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isLongPoll(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !s.acquire(r) {
+			appMetrics.RequestShed()
+			w.Header().Set("Retry-After", retryAfter)
+			writeJSONError(w, r, http.StatusServiceUnavailable, "overloaded")
+			return
+		}
+		started := time.Now()
+		defer func() { s.release(time.Since(started), time.Now()) }()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_loadLoadShedConfig(t *testing.T) {
+	t.Setenv("LOAD_SHED_MAX_CONCURRENT", "10")
+	t.Setenv("LOAD_SHED_MAX_QUEUE", "bogus")
+	t.Setenv("LOAD_SHED_QUEUE_TIMEOUT", "250ms")
+	t.Setenv("LOAD_SHED_RETRY_AFTER", "")
+	t.Setenv("LOAD_SHED_MIN_CONCURRENT", "")
+	t.Setenv("LOAD_SHED_TARGET_LATENCY", "1s")
+
+	cfg := loadLoadShedConfig()
+	want := loadShedConfig{
+		MaxConcurrent: 10,
+		MinConcurrent: defaultLoadShedMinConcurrent,
+		MaxQueue:      defaultLoadShedMaxQueue,
+		QueueTimeout:  250 * time.Millisecond,
+		RetryAfter:    defaultLoadShedRetryAfter,
+		TargetLatency: time.Second,
+	}
+	if cfg != want {
+		t.Errorf("loadLoadShedConfig() = %+v, want %+v", cfg, want)
+	}
+}
+
+func Test_loadSheddingMiddleware(t *testing.T) {
+	m := useFakeMetrics(t)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	slowHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cfg := loadShedConfig{MaxConcurrent: 2, MaxQueue: 1, QueueTimeout: time.Second, RetryAfter: 1500 * time.Millisecond}
+	shedder := newLoadShedder(cfg)
+	handler := shedder.wrap(slowHandler)
+
+	// Fill both slots
+	var wg sync.WaitGroup
+	codes := make(chan int, 3)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+			codes <- rr.Code
+		}()
+	}
+	<-started
+	<-started
+
+	// One request may queue
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes <- rr.Code
+	}()
+	for shedder.queued.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so this one is shed immediately
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("expected Retry-After 2, got %q", got)
+	}
+	if m.shed != 1 {
+		t.Errorf("expected 1 shed request, got %d", m.shed)
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("expected admitted and queued requests to succeed, got %d", code)
+		}
+	}
+}
+
+func Test_loadSheddingMiddleware_QueueTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	slowHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	cfg := loadShedConfig{MaxConcurrent: 1, MaxQueue: 5, QueueTimeout: 10 * time.Millisecond, RetryAfter: time.Second}
+	handler := loadSheddingMiddleware(slowHandler, cfg)
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected queued request to time out with %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+func Test_loadSheddingMiddleware_LongPoll(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 2)
+	waitingHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+
+	cfg := loadShedConfig{MaxConcurrent: 1, MaxQueue: 0, RetryAfter: time.Second}
+	handler := loadSheddingMiddleware(waitingHandler, cfg)
+
+	// Waiting polls don't take the only slot
+	for i := 0; i < 2; i++ {
+		go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, pollPath+"?since=1", nil))
+		<-started
+	}
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, apiPath, nil))
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("expected a request to be admitted while polls wait")
+	}
+}
+
+func Test_loadShedder_AdaptiveLimit(t *testing.T) {
+	s := newLoadShedder(loadShedConfig{MaxConcurrent: 10, MinConcurrent: 2, TargetLatency: 100 * time.Millisecond})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	admit := func() int {
+		n := 0
+		for s.acquire(req) {
+			n++
+		}
+		return n
+	}
+	if n := admit(); n != 10 {
+		t.Fatalf("expected 10 requests admitted, got %d", n)
+	}
+
+	// Slow requests cut the limit, once per target latency
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	s.release(time.Second, now)
+	s.release(time.Second, now.Add(10*time.Millisecond))
+	if n := admit(); n != 1 {
+		t.Errorf("expected the limit cut to 9 with 8 in flight, got %d more admitted", n)
+	}
+	s.release(time.Second, now.Add(200*time.Millisecond))
+	if n := admit(); n != 0 {
+		t.Errorf("expected the limit cut to 8 with 8 in flight, got %d more admitted", n)
+	}
+
+	// It never drops below MinConcurrent
+	for i := 0; i < 50; i++ {
+		now = now.Add(time.Second)
+		s.release(time.Second, now)
+		s.acquire(req)
+	}
+	if s.limit != 2 {
+		t.Errorf("expected the limit at the floor of 2, got %v", s.limit)
+	}
+
+	// Fast requests grow it back, up to MaxConcurrent
+	for i := 0; i < 200; i++ {
+		s.release(time.Millisecond, now)
+		s.acquire(req)
+	}
+	if s.limit != 10 {
+		t.Errorf("expected the limit back at 10, got %v", s.limit)
+	}
+}
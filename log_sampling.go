@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultForbiddenLogSampleN = 100
+	defaultErrorSuppressWindow = 10 * time.Second
+	maxSuppressedLogEntries    = 1000
+	forbiddenLogSampleEnv      = "LOG_FORBIDDEN_SAMPLE_N"
+	errorLogSuppressWindowEnv  = "LOG_ERROR_SUPPRESS_WINDOW"
+)
+
+// Loggers for high-volume log lines, configured from env by configureLogSampling
+var (
+	forbiddenLogger = newSampledLogger(defaultForbiddenLogSampleN)
+	errorLogger     = newBurstLogger(defaultErrorSuppressWindow)
+)
+
+// sampledLogger writes only one in every n log lines.
+type sampledLogger struct {
+	n     atomic.Uint64
+	count atomic.Uint64
+}
+
+func newSampledLogger(n uint64) *sampledLogger {
+	l := &sampledLogger{}
+	l.n.Store(n)
+	return l
+}
+
+// Printf logs the first call and every nth call after it.
+func (l *sampledLogger) Printf(format string, v ...interface{}) {
+	c := l.count.Add(1)
+	n := l.n.Load()
+	if n <= 1 {
+		log.Printf(format, v...)
+		return
+	}
+	if c%n == 1 {
+		log.Printf(format+" (sampled 1 in %d)", append(v, n)...)
+	}
+}
+
+// burstLogger suppresses identical log lines repeated within a time window.
+type burstLogger struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*burstEntry
+	clock   Clock
+}
+
+type burstEntry struct {
+	since      time.Time
+	suppressed int
+}
+
+func newBurstLogger(window time.Duration) *burstLogger {
+	return &burstLogger{
+		window:  window,
+		entries: make(map[string]*burstEntry),
+		clock:   realClock{},
+	}
+}
+
+// Printf logs a message unless the identical message was already logged within the window.
+// When a suppressed message is logged again, the number of dropped repeats is appended.
+func (l *burstLogger) Printf(format string, v ...interface{}) {
+	l.printTagged(fmt.Sprintf(format, v...), "")
+}
+
+// printTagged logs msg followed by tags, such as the request ID. Only msg is compared when
+// looking for repeats, so the same error from many requests is still suppressed.
+func (l *burstLogger) printTagged(msg, tags string) {
+	l.mu.Lock()
+	now := l.clock.Now()
+	e, ok := l.entries[msg]
+	if ok && now.Sub(e.since) < l.window {
+		e.suppressed++
+		l.mu.Unlock()
+		return
+	}
+
+	suppressed := 0
+	if ok {
+		suppressed = e.suppressed
+	}
+	if !ok && len(l.entries) >= maxSuppressedLogEntries {
+		l.pruneLocked(now)
+	}
+	// With the map still full of messages inside their window, new ones are logged untracked
+	if ok || len(l.entries) < maxSuppressedLogEntries {
+		l.entries[msg] = &burstEntry{since: now}
+	}
+	l.mu.Unlock()
+
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%s (suppressed %d identical messages)", msg, suppressed)
+	}
+	log.Print(msg + tags)
+}
+
+// pruneLocked drops expired entries so the map can't grow without bound. l.mu must be held.
+func (l *burstLogger) pruneLocked(now time.Time) {
+	for msg, e := range l.entries {
+		if now.Sub(e.since) >= l.window {
+			delete(l.entries, msg)
+		}
+	}
+}
+
+// configureLogSampling applies LOG_FORBIDDEN_SAMPLE_N and LOG_ERROR_SUPPRESS_WINDOW from the environment.
+func configureLogSampling() {
+	if v := os.Getenv(forbiddenLogSampleEnv); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n == 0 {
+			log.Printf("Invalid %s %q, using %d", forbiddenLogSampleEnv, v, defaultForbiddenLogSampleN)
+		} else {
+			forbiddenLogger.n.Store(n)
+		}
+	}
+
+	if v := os.Getenv(errorLogSuppressWindowEnv); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window < 0 {
+			log.Printf("Invalid %s %q, using %s", errorLogSuppressWindowEnv, v, defaultErrorSuppressWindow)
+		} else {
+			errorLogger.mu.Lock()
+			errorLogger.window = window
+			errorLogger.mu.Unlock()
+		}
+	}
+}
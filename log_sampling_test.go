@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_sampledLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	l := newSampledLogger(3)
+	for i := 0; i < 7; i++ {
+		l.Printf("forbidden %d", i)
+	}
+
+	out := buf.String()
+	if got := strings.Count(out, "\n"); got != 3 {
+		t.Fatalf("expected 3 log lines, got %d: %s", got, out)
+	}
+	for _, want := range []string{"forbidden 0", "forbidden 3", "forbidden 6"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q to be logged, got %s", want, out)
+		}
+	}
+}
+
+func Test_burstLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := newBurstLogger(time.Minute)
+	l.clock = clock
+
+	for i := 0; i < 5; i++ {
+		l.Printf("db error: %s", "connection refused")
+	}
+	l.Printf("db error: %s", "timeout")
+
+	if got := strings.Count(buf.String(), "\n"); got != 2 {
+		t.Fatalf("expected 2 log lines within the window, got %d: %s", got, buf.String())
+	}
+
+	buf.Reset()
+	clock.Advance(2 * time.Minute)
+	l.Printf("db error: %s", "connection refused")
+
+	if !strings.Contains(buf.String(), "suppressed 4 identical messages") {
+		t.Errorf("expected suppression summary, got %s", buf.String())
+	}
+}
+
+func Test_burstLogger_Tagged(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	l := newBurstLogger(time.Minute)
+	l.printTagged("db error: timeout", " - Request ID: a")
+	l.printTagged("db error: timeout", " - Request ID: b")
+
+	if got := buf.String(); strings.Count(got, "\n") != 1 || !strings.Contains(got, "db error: timeout - Request ID: a") {
+		t.Errorf("expected repeats from other requests to be suppressed; got %s", got)
+	}
+}
+
+func Test_burstLogger_Cap(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := newBurstLogger(time.Minute)
+	l.clock = clock
+
+	for i := 0; i < maxSuppressedLogEntries+10; i++ {
+		l.Printf("db error %d", i)
+	}
+	if len(l.entries) != maxSuppressedLogEntries {
+		t.Fatalf("expected %d tracked messages, got %d", maxSuppressedLogEntries, len(l.entries))
+	}
+	// Untracked messages are logged rather than suppressed
+	buf.Reset()
+	l.Printf("db error %d", maxSuppressedLogEntries)
+	if got := strings.Count(buf.String(), "\n"); got != 1 {
+		t.Errorf("expected an untracked message logged, got %d lines", got)
+	}
+
+	// Once the window passes, expired messages make room for new ones
+	clock.Advance(2 * time.Minute)
+	l.Printf("db error: timeout")
+	if _, ok := l.entries["db error: timeout"]; !ok || len(l.entries) != 1 {
+		t.Errorf("expected the expired messages pruned, got %d tracked", len(l.entries))
+	}
+}
+
+func Test_configureLogSampling(t *testing.T) {
+	t.Setenv(forbiddenLogSampleEnv, "5")
+	t.Setenv(errorLogSuppressWindowEnv, "30s")
+
+	defer func() {
+		forbiddenLogger.n.Store(defaultForbiddenLogSampleN)
+		errorLogger.window = defaultErrorSuppressWindow
+	}()
+
+	configureLogSampling()
+
+	if got := forbiddenLogger.n.Load(); got != 5 {
+		t.Errorf("expected forbidden sample rate 5, got %d", got)
+	}
+	if errorLogger.window != 30*time.Second {
+		t.Errorf("expected suppress window 30s, got %s", errorLogger.window)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogShippingInterval  = 5 * time.Second
+	defaultLogShippingBatchSize = 1000
+	defaultLogShippingBuffer    = 10000
+	defaultLogShippingService   = "resume-backend"
+	logShippingTimeout          = 10 * time.Second
+)
+
+// Finds the request ID that requestLogger tags lines with
+var logRequestIDPattern = regexp.MustCompile(`Request ID: ([0-9A-Za-z_-]+)`)
+
+// logShippingConfig controls shipping the log to Grafana Loki or an OTLP collector.
+type logShippingConfig struct {
+	Sink       string // "loki" or "otlp"
+	URL        string // the push endpoint, such as http://loki:3100/loki/api/v1/push
+	Headers    map[string]string
+	Username   string // basic auth, e.g. the Grafana Cloud user ID
+	Password   string
+	Service    string // service label in Loki, service.name resource attribute in OTLP
+	Interval   time.Duration
+	BatchSize  int // lines sent per push
+	BufferSize int // lines held while the sink is unavailable, the oldest dropped first
+}
+
+// loadLogShippingConfig reads LOG_SHIPPING, "loki" or "otlp", which enables shipping, and
+// LOG_SHIPPING_URL. For OTLP the URL defaults to OTEL_EXPORTER_OTLP_LOGS_ENDPOINT, or
+// OTEL_EXPORTER_OTLP_ENDPOINT with /v1/logs appended, and LOG_SHIPPING_HEADERS, a
+// comma-separated list of name=value pairs, to OTEL_EXPORTER_OTLP_HEADERS.
+func loadLogShippingConfig() (logShippingConfig, bool, error) {
+	cfg := logShippingConfig{
+		Sink:       strings.ToLower(os.Getenv("LOG_SHIPPING")),
+		URL:        os.Getenv("LOG_SHIPPING_URL"),
+		Username:   os.Getenv("LOG_SHIPPING_USERNAME"),
+		Password:   os.Getenv("LOG_SHIPPING_PASSWORD"),
+		Service:    defaultLogShippingService,
+		Interval:   defaultLogShippingInterval,
+		BatchSize:  defaultLogShippingBatchSize,
+		BufferSize: defaultLogShippingBuffer,
+	}
+	headers := os.Getenv("LOG_SHIPPING_HEADERS")
+	switch cfg.Sink {
+	case "":
+		return logShippingConfig{}, false, nil
+	case "loki":
+	case "otlp":
+		if cfg.URL == "" {
+			cfg.URL = os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT")
+		}
+		if v := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); cfg.URL == "" && v != "" {
+			cfg.URL = strings.TrimSuffix(v, "/") + "/v1/logs"
+		}
+		if headers == "" {
+			headers = os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")
+		}
+	default:
+		return logShippingConfig{}, false, fmt.Errorf("invalid LOG_SHIPPING %q: must be loki or otlp", cfg.Sink)
+	}
+	if cfg.URL == "" {
+		return logShippingConfig{}, false, errors.New("LOG_SHIPPING_URL is required to ship logs")
+	}
+
+	cfg.Headers = make(map[string]string)
+	for _, pair := range strings.Split(headers, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return logShippingConfig{}, false, fmt.Errorf("invalid LOG_SHIPPING_HEADERS entry %q: must be name=value", pair)
+		}
+		cfg.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if v := os.Getenv("LOG_SHIPPING_SERVICE"); v != "" {
+		cfg.Service = v
+	}
+	if v := os.Getenv("LOG_SHIPPING_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return logShippingConfig{}, false, fmt.Errorf("invalid LOG_SHIPPING_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.Interval = d
+	}
+	for _, setting := range []struct {
+		name   string
+		target *int
+	}{{"LOG_SHIPPING_BATCH_SIZE", &cfg.BatchSize}, {"LOG_SHIPPING_BUFFER", &cfg.BufferSize}} {
+		if v := os.Getenv(setting.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return logShippingConfig{}, false, fmt.Errorf("invalid %s %q: must be a positive number", setting.name, v)
+			}
+			*setting.target = n
+		}
+	}
+	if cfg.BufferSize < cfg.BatchSize {
+		return logShippingConfig{}, false, errors.New("LOG_SHIPPING_BUFFER must hold at least LOG_SHIPPING_BATCH_SIZE lines")
+	}
+	if (cfg.Username == "") != (cfg.Password == "") {
+		return logShippingConfig{}, false, errors.New("LOG_SHIPPING_USERNAME and LOG_SHIPPING_PASSWORD must be set together")
+	}
+	return cfg, true, nil
+}
+
+// logEntry is one line of the log, with the time it was written.
+type logEntry struct {
+	Time time.Time
+	Line string
+}
+
+// logShipper collects the lines written to it, as the log package's output alongside stdout,
+// and pushes them in batches. While the sink is unavailable the lines are kept in memory, up to
+// BufferSize, and pushed once it is back; past that the oldest are dropped.
+type logShipper struct {
+	cfg    logShippingConfig
+	clock  Clock
+	client *http.Client
+	full   chan struct{} // signalled when a batch is ready
+
+	mu      sync.Mutex
+	pending []logEntry
+	dropped int
+}
+
+func newLogShipper(cfg logShippingConfig, clock Clock) *logShipper {
+	return &logShipper{cfg: cfg, clock: clock, client: &http.Client{Timeout: logShippingTimeout}, full: make(chan struct{}, 1)}
+}
+
+// Write queues each line of p. It never blocks on the sink, so logging can't stall requests.
+func (s *logShipper) Write(p []byte) (int, error) {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		s.pending = append(s.pending, logEntry{Time: now, Line: line})
+	}
+	if over := len(s.pending) - s.cfg.BufferSize; over > 0 {
+		s.pending = s.pending[over:]
+		s.dropped += over
+	}
+	if len(s.pending) >= s.cfg.BatchSize {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Flush pushes the queued lines a batch at a time, stopping at the first failure so the rest
+// are retried on the next flush.
+func (s *logShipper) Flush(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		batch := s.pending[:min(len(s.pending), s.cfg.BatchSize)]
+		batch = append([]logEntry(nil), batch...)
+		droppedBefore := s.dropped
+		s.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := s.push(ctx, batch); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		// Lines dropped while pushing went from the front, which may have been this batch
+		sent := max(len(batch)-(s.dropped-droppedBefore), 0)
+		s.pending = s.pending[min(sent, len(s.pending)):]
+		dropped := s.dropped
+		s.dropped = 0
+		s.mu.Unlock()
+		if dropped > 0 {
+			errorLogger.Printf("Dropped %d log lines while the log sink was unavailable", dropped)
+		}
+	}
+}
+
+// push sends batch to the sink in its format.
+func (s *logShipper) push(ctx context.Context, batch []logEntry) error {
+	var body []byte
+	var err error
+	if s.cfg.Sink == "loki" {
+		body, err = json.Marshal(s.lokiPush(batch))
+	} else {
+		body, err = json.Marshal(s.otlpLogs(batch))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode logs: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create log shipping request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		errorLogger.Printf("Error shipping logs: %v", err)
+		return fmt.Errorf("failed to ship logs: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		errorLogger.Printf("Log shipping rejected with status %d: %s", res.StatusCode, msg)
+		return fmt.Errorf("log sink returned status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// lokiPush is the body of a push to Loki's /loki/api/v1/push: one stream, labelled with the
+// service, of [nanosecond timestamp, line] pairs.
+func (s *logShipper) lokiPush(batch []logEntry) map[string]any {
+	values := make([][2]string, len(batch))
+	for i, e := range batch {
+		values[i] = [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), e.Line}
+	}
+	return map[string]any{
+		"streams": []map[string]any{{
+			"stream": map[string]string{"service": s.cfg.Service},
+			"values": values,
+		}},
+	}
+}
+
+// otlpLogs is the body of an OTLP/HTTP JSON export to /v1/logs, with the request ID of a
+// line as its request_id attribute.
+func (s *logShipper) otlpLogs(batch []logEntry) map[string]any {
+	records := make([]map[string]any, len(batch))
+	for i, e := range batch {
+		record := map[string]any{
+			"timeUnixNano": strconv.FormatInt(e.Time.UnixNano(), 10),
+			"body":         map[string]string{"stringValue": e.Line},
+		}
+		if m := logRequestIDPattern.FindStringSubmatch(e.Line); m != nil {
+			record["attributes"] = []map[string]any{{"key": "request_id", "value": map[string]string{"stringValue": m[1]}}}
+		}
+		records[i] = record
+	}
+	return map[string]any{
+		"resourceLogs": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": []map[string]any{{"key": "service.name", "value": map[string]string{"stringValue": s.cfg.Service}}},
+			},
+			"scopeLogs": []map[string]any{{
+				"scope":      map[string]string{"name": defaultLogShippingService},
+				"logRecords": records,
+			}},
+		}},
+	}
+}
+
+// Run flushes every interval, or sooner once a batch is ready, until ctx is done, then
+// makes a last attempt to ship what is left.
+func (s *logShipper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), logShippingTimeout)
+			defer cancel()
+			_ = s.Flush(final)
+			return
+		case <-ticker.C:
+		case <-s.full:
+		}
+		// Failures are logged and the lines retried on the next flush
+		_ = s.Flush(ctx)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logSink records the bodies pushed to it, failing while down
+type logSink struct {
+	mu     sync.Mutex
+	down   bool
+	bodies []map[string]any
+	header http.Header
+}
+
+func newLogSink(t *testing.T) (*logSink, *httptest.Server) {
+	sink := &logSink{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		if sink.down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid push body: %v", err)
+		}
+		sink.bodies = append(sink.bodies, body)
+		sink.header = r.Header.Clone()
+	}))
+	t.Cleanup(server.Close)
+	return sink, server
+}
+
+func Test_logShipper_Loki(t *testing.T) {
+	sink, server := newLogSink(t)
+	now := time.Unix(1709460000, 5)
+	shipper := newLogShipper(logShippingConfig{
+		Sink: "loki", URL: server.URL, Headers: map[string]string{"X-Scope-OrgID": "tenant"},
+		Service: "resume-backend", BatchSize: 2, BufferSize: 10,
+	}, newFakeClock(now))
+
+	shipper.Write([]byte("2024/03/03 10:00:00 first\n2024/03/03 10:00:00 second\n"))
+	shipper.Write([]byte("2024/03/03 10:00:01 third\n"))
+	if err := shipper.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.bodies) != 2 {
+		t.Fatalf("expected the lines pushed in 2 batches, got %d", len(sink.bodies))
+	}
+	if sink.header.Get("X-Scope-OrgID") != "tenant" {
+		t.Errorf("expected the configured headers sent, got %v", sink.header)
+	}
+	streams := sink.bodies[0]["streams"].([]any)
+	stream := streams[0].(map[string]any)
+	if !reflect.DeepEqual(stream["stream"], map[string]any{"service": "resume-backend"}) {
+		t.Errorf("unexpected labels %v", stream["stream"])
+	}
+	want := []any{[]any{"1709460000000000005", "2024/03/03 10:00:00 first"}, []any{"1709460000000000005", "2024/03/03 10:00:00 second"}}
+	if !reflect.DeepEqual(stream["values"], want) {
+		t.Errorf("expected values %v, got %v", want, stream["values"])
+	}
+}
+
+func Test_logShipper_OTLP(t *testing.T) {
+	sink, server := newLogSink(t)
+	shipper := newLogShipper(logShippingConfig{Sink: "otlp", URL: server.URL, Service: "resume", BatchSize: 10, BufferSize: 10}, realClock{})
+
+	shipper.Write([]byte("2024/03/03 10:00:00 Error reading count - Request ID: abc123 - GET /api/count\n"))
+	if err := shipper.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, _ := json.Marshal(sink.bodies[0])
+	for _, want := range []string{
+		`"key":"service.name","value":{"stringValue":"resume"}`,
+		`"key":"request_id","value":{"stringValue":"abc123"}`,
+		`"body":{"stringValue":"2024/03/03 10:00:00 Error reading count - Request ID: abc123 - GET /api/count"}`,
+	} {
+		if !strings.Contains(string(encoded), want) {
+			t.Errorf("expected %s in %s", want, encoded)
+		}
+	}
+}
+
+func Test_logShipper_Buffering(t *testing.T) {
+	sink, server := newLogSink(t)
+	shipper := newLogShipper(logShippingConfig{Sink: "loki", URL: server.URL, BatchSize: 5, BufferSize: 3}, realClock{})
+	sink.down = true
+
+	for _, line := range []string{"one", "two", "three", "four"} {
+		shipper.Write([]byte(line + "\n"))
+	}
+	if err := shipper.Flush(context.Background()); err == nil {
+		t.Fatal("expected an error while the sink is down")
+	}
+
+	// The lines are kept, the oldest dropped, until the sink is back
+	sink.down = false
+	if err := shipper.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, body := range sink.bodies {
+		for _, v := range body["streams"].([]any)[0].(map[string]any)["values"].([]any) {
+			lines = append(lines, v.([]any)[1].(string))
+		}
+	}
+	if len(lines) != 3 || !reflect.DeepEqual(lines, []string{"two", "three", "four"}) {
+		t.Errorf("expected the buffered lines less the oldest, got %q", lines)
+	}
+	if len(shipper.pending) != 0 || shipper.dropped != 0 {
+		t.Errorf("expected nothing left pending, got %d and %d dropped", len(shipper.pending), shipper.dropped)
+	}
+}
+
+func Test_loadLogShippingConfig(t *testing.T) {
+	if _, enabled, err := loadLogShippingConfig(); enabled || err != nil {
+		t.Fatalf("expected shipping off by default, got %v, %v", enabled, err)
+	}
+
+	t.Setenv("LOG_SHIPPING", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer token, X-Tenant=resume")
+	cfg, enabled, err := loadLogShippingConfig()
+	if err != nil || !enabled {
+		t.Fatalf("expected OTLP shipping, got %v, %v", enabled, err)
+	}
+	if cfg.URL != "http://collector:4318/v1/logs" || !reflect.DeepEqual(cfg.Headers, map[string]string{"Authorization": "Bearer token", "X-Tenant": "resume"}) {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	for name, env := range map[string]map[string]string{
+		"Unknown sink":    {"LOG_SHIPPING": "syslog"},
+		"No URL":          {"LOG_SHIPPING": "loki"},
+		"Bad header":      {"LOG_SHIPPING_HEADERS": "Authorization"},
+		"Small buffer":    {"LOG_SHIPPING_BUFFER": "10", "LOG_SHIPPING_BATCH_SIZE": "20"},
+		"Bad interval":    {"LOG_SHIPPING_INTERVAL": "0s"},
+		"Half basic auth": {"LOG_SHIPPING_USERNAME": "user"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, _, err := loadLogShippingConfig(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
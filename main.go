@@ -5,104 +5,441 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
+	"resume-backend/internal/logging"
+	"resume-backend/internal/store"
+
 	"github.com/joho/godotenv"
-	"github.com/rs/cors"
 )
 
-const apiPath = "/api/count"
-
-// Kubernetes checks on startup
-func healthAndReadyHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	case "/healthz":
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "OK")
-	case "/readyz":
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "Ready")
-	default:
-		http.Error(w, "Not Found", http.StatusNotFound)
-	}
-}
-
 func main() {
 	// Initialize logger to write to stdout
 	log.SetOutput(os.Stdout)
 
-	http.HandleFunc("/healthz", healthAndReadyHandler)
-	http.HandleFunc("/readyz", healthAndReadyHandler)
+	// Subcommands that run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "slo-rules" {
+		if err := runSLORulesCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("failed to generate SLO rules: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dashboard" {
+		if err := runDashboardCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("failed to generate dashboard: %v", err)
+		}
+		return
+	}
 
-	// Load environment variables
+	// Load settings from the mounted ConfigMap and Secret, then from .env; neither overrides
+	// variables already set in the environment
+	configFiles, err := loadConfigFiles()
+	if err != nil {
+		log.Fatalf("invalid config files: %v", err)
+	}
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, proceeding with default or environment variables")
 	}
 
+	// The backfill command imports into the database configured above
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		if err := runBackfillCommand(os.Args[2:]); err != nil {
+			log.Fatalf("backfill failed: %v", err)
+		}
+		return
+	}
+
+	// The verify command checks the same database
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		if err := runVerifyCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("verify failed: %v", err)
+		}
+		return
+	}
+
 	// Validate required environment variables
 	if os.Getenv("ALLOWED_ORIGINS") == "" {
 		log.Fatal("ALLOWED_ORIGINS environment variable is not set")
 	}
 
-	// Initialize Prometheus metrics
-	initPrometheusMetrics()
+	// Believe X-Forwarded-For only from the proxies in TRUSTED_PROXIES
+	proxies, err := loadTrustedProxies()
+	if err != nil {
+		log.Fatalf("invalid proxy configuration: %v", err)
+	}
+	trustedProxies = proxies
+
+	// Configure sampling for high-volume log lines
+	configureLogSampling()
+	logging.SetDefault(errorLogger)
 
-	// Database setup
+	// Ship the log to Loki or an OTLP collector as well as stdout when LOG_SHIPPING is set.
+	// Shipping stops last, so the shutdown is logged there too.
+	logShippingCfg, logShippingEnabled, err := loadLogShippingConfig()
+	if err != nil {
+		log.Fatalf("invalid log shipping configuration: %v", err)
+	}
+	if logShippingEnabled {
+		shipper := newLogShipper(logShippingCfg, realClock{})
+		log.SetOutput(io.MultiWriter(os.Stdout, shipper))
+		shippingCtx, stopShipping := context.WithCancel(context.Background())
+		shippingDone := make(chan struct{})
+		go func() {
+			defer close(shippingDone)
+			shipper.Run(shippingCtx)
+		}()
+		defer func() {
+			stopShipping()
+			<-shippingDone
+		}()
+		log.Printf("Shipping logs to %s at %s", logShippingCfg.Sink, logShippingCfg.URL)
+	}
+
+	// Report panics and background job failures to Sentry when SENTRY_DSN is set. Reports
+	// still queued at shutdown get a few seconds to be sent.
+	crashReportCfg, crashReportsEnabled, err := loadCrashReportConfig()
+	if err != nil {
+		log.Fatalf("invalid crash report configuration: %v", err)
+	}
+	if crashReportsEnabled {
+		crashReports = newCrashReporter(crashReportCfg, realClock{})
+		reportingCtx, stopReporting := context.WithCancel(context.Background())
+		go crashReports.Run(reportingCtx)
+		defer func() {
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			crashReports.Flush(flushCtx)
+			stopReporting()
+		}()
+		log.Printf("Reporting crashes to Sentry as %s release %s", crashReportCfg.Environment, crashReportCfg.Release)
+	}
+
+	// Enable trace ID propagation for exemplars
+	configureTracing()
+
+	// Select the metrics backend
+	metrics, err := newMetricsFromEnv()
+	if err != nil {
+		log.Fatalf("failed to set up metrics: %v", err)
+	}
+	appMetrics = metrics
+	if closer, ok := appMetrics.(interface{ Close() }); ok {
+		defer closer.Close()
+	}
+	if _, ok := appMetrics.(prometheusMetrics); ok {
+		initPrometheusMetrics()
+	}
+
+	// Database setup, with every query and failover reported to the metrics backend
+	store.SetQueryObserver(observeQuery)
+	store.SetTargetObserver(func(target int) { appMetrics.DatabaseTarget(target) })
 	ctx := context.Background()
-	dataStore, err := SetupDatabase(ctx) // Use SetupDatabase to initialize PostgreSQL DataStore
+	dataStore, err := store.SetupDatabase(ctx) // Use SetupDatabase to initialize PostgreSQL DataStore
 	if err != nil {
 		log.Fatalf("failed to set up database: %v", err)
 	}
 	defer dataStore.Close() // Ensure the database connection is closed
 
-	// Create the handler with dependency injection
-	var handler http.Handler
-	handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		visitCountHandler(w, r, dataStore) // Inject dataStore
-	})
+	// New connections read DB_PASSWORD as they open; when it rotates, retire the pooled
+	// connections so none keep using the old password once it is revoked
+	if resetter, ok := dataStore.(interface{ ResetConnections() }); ok {
+		configFiles.OnChange(func(changed []string) {
+			if slices.Contains(changed, "DB_PASSWORD") {
+				log.Println("DB_PASSWORD changed: reconnecting to the database")
+				resetter.ResetConnections()
+			}
+		})
+	}
 
-	// Apply middleware in the desired order
-	handler = prometheusMiddleware(handler) // Wrap with Prometheus middleware
-	handler = loggingMiddleware(handler)    // Logging middleware
+	// Probe the database so dead connections are replaced before requests hit them, and
+	// the replica is taken out of rotation while it stays unreachable
+	dbHealthCfg := loadDBHealthConfig()
+	if dbHealthCfg.Interval > 0 {
+		dbHealth = newDBHealthChecker(dataStore, dbHealthCfg)
+	}
 
-	corsHandler := cors.New(cors.Options{
-		AllowedOrigins: strings.Split(os.Getenv("ALLOWED_ORIGINS"), ","),
-		AllowedMethods: []string{http.MethodGet, http.MethodPost},
-		AllowedHeaders: []string{"Authorization", "Content-Type"},
+	// Inject faults in staging when CHAOS_MODE is set
+	chaosConfig, chaosEnabled, err := loadChaosConfig()
+	if err != nil {
+		log.Fatalf("invalid chaos configuration: %v", err)
+	}
+	if chaosEnabled {
+		dataStore = NewFaultyStore(dataStore, chaosConfig)
+	}
+
+	// Record visits in memory and write them in batches when VISIT_BUFFERING is set, trading
+	// the visits of the last flush interval on a crash for write throughput
+	bufferCfg, err := loadBufferConfig()
+	if err != nil {
+		log.Fatalf("invalid visit buffering configuration: %v", err)
+	}
+	var buffered *bufferedCountStore
+	if bufferCfg.Enabled {
+		if bufferCfg.WALDir == "" {
+			log.Println("VISIT_WAL_DIR is not set: buffered visits are lost if the process crashes")
+		}
+		if buffered, err = newBufferedCountStore(ctx, dataStore, bufferCfg); err != nil {
+			log.Fatalf("failed to set up visit buffering: %v", err)
+		}
+		dataStore = buffered
+	}
+
+	// Collapse concurrent count queries into one database call
+	dataStore = newCoalescingStore(dataStore, store.QueryTimeout())
+
+	// Serve the last known count while the database is unreachable
+	dataStore = newStaleCountStore(dataStore, realClock{})
+
+	// Answer stats and referrers from summaries refreshed on a schedule when STATS_SUMMARIES is set
+	summaryCfg, err := loadSummaryConfig()
+	if err != nil {
+		log.Fatalf("invalid summary configuration: %v", err)
+	}
+	if summaryCfg.Enabled {
+		dataStore = newSummaryStore(dataStore)
+	}
+
+	// Roll up finished sessions, prune dedupe keys and watch the visit rate until shutdown.
+	// Scheduled jobs are collected in leaderJobs, which run on a single replica.
+	backgroundCtx, stopBackground := context.WithCancel(ctx)
+	defer stopBackground()
+	configFiles.OnChange(func(changed []string) {
+		// Most settings are read at startup, so a rollout is what applies them
+		log.Printf("Config files changed %s; settings read at startup apply after a restart", strings.Join(changed, ", "))
 	})
-	handler = corsHandler.Handler(handler)
+	go configFiles.Watch(backgroundCtx, loadConfigReloadInterval())
+	if dbHealth != nil {
+		go dbHealth.Run(backgroundCtx)
+	}
+	sessionCfg, jobStore := loadSessionConfig(), dataStore
+
+	// Buffered visits are flushed on every replica, and once more after the server stops
+	bufferDone := make(chan struct{})
+	if buffered != nil {
+		go func() {
+			defer close(bufferDone)
+			crashReports.guard("visit_buffer", buffered.Run)(backgroundCtx)
+		}()
+	} else {
+		close(bufferDone)
+	}
+
+	// Deliver webhooks, stream records and alerts through the outbox when VISIT_OUTBOX
+	// is set, retrying failures rather than dropping them
+	outboxCfg, err := loadOutboxConfig()
+	if err != nil {
+		log.Fatalf("invalid outbox configuration: %v", err)
+	}
+	anomalies := newAnomalyDetector(dataStore, loadAnomalyConfig(), realClock{})
+	var anomalyAlerts outboxHandler
+	if outboxCfg.Enabled {
+		anomalyAlerts = anomalies.queueAlerts(jobStore)
+	}
+
+	leaderJobs := []func(context.Context){
+		func(ctx context.Context) { runSessionAggregator(ctx, jobStore, sessionCfg, realClock{}) },
+		anomalies.Run,
+		func(ctx context.Context) { runDedupePruner(ctx, jobStore, defaultDedupePruneInterval) },
+	}
+
+	// Partition visits by month when VISITS_PARTITIONING is set, so old months drop cheaply
+	partitionCfg, err := loadPartitionConfig()
+	if err != nil {
+		log.Fatalf("invalid partitioning configuration: %v", err)
+	}
+	if partitionCfg.Enabled {
+		leaderJobs = append(leaderJobs, func(ctx context.Context) { runVisitPartitioner(ctx, jobStore, partitionCfg, realClock{}) })
+	}
+
+	if summaryCfg.Enabled {
+		leaderJobs = append(leaderJobs, func(ctx context.Context) { runSummaryRefresher(ctx, jobStore, summaryCfg.RefreshInterval) })
+	}
+
+	// Check the resume site when UPTIME_URL is set, alerting when it goes down
+	uptimeCfg, uptimeEnabled, err := loadUptimeConfig()
+	if err != nil {
+		log.Fatalf("invalid uptime configuration: %v", err)
+	}
+	var uptimeAlerts outboxHandler
+	if uptimeEnabled {
+		uptime := newUptimeMonitor(jobStore, uptimeCfg, realClock{})
+		if outboxCfg.Enabled {
+			uptimeAlerts = uptime.queueAlerts(jobStore)
+		}
+		leaderJobs = append(leaderJobs, uptime.Run)
+	}
+
+	// Push the visit count to a Prometheus-compatible TSDB when REMOTE_WRITE_URL is set
+	remoteWriteCfg, remoteWriteEnabled, err := loadRemoteWriteConfig()
+	if err != nil {
+		log.Fatalf("invalid remote write configuration: %v", err)
+	}
+	if remoteWriteEnabled {
+		leaderJobs = append(leaderJobs, newRemoteWriter(dataStore, remoteWriteCfg, realClock{}).Run)
+	}
 
-	// Apply origin check middleware for production
-	if os.Getenv("APP_ENV") == "prod" {
-		handler = originCheckMiddleware(handler)
+	// Ship visits to the analytics warehouse when EXPORT_SINK is set
+	exportCfg, exportSink, err := loadExportConfig()
+	if err != nil {
+		log.Fatalf("invalid export configuration: %v", err)
+	}
+	if exportSink != nil {
+		leaderJobs = append(leaderJobs, newVisitExporter(dataStore, exportSink, exportCfg, realClock{}).Run)
 	}
 
-	// Use the handler for your API endpoint
-	http.Handle(apiPath, handler)
+	// With LEADER_ELECTION set, only the replica holding the lease runs the scheduled jobs
+	leaderCfg, err := loadLeaderConfig()
+	if err != nil {
+		log.Fatalf("invalid leader election configuration: %v", err)
+	}
+	for i, job := range leaderJobs {
+		leaderJobs[i] = crashReports.guard("background_job", job)
+	}
+	leaderDone := make(chan struct{})
+	if leaderCfg.Enabled {
+		elector := newLeaderElector(dataStore, backgroundJobsLease, leaderCfg)
+		go func() {
+			defer close(leaderDone)
+			elector.Run(backgroundCtx, leaderJobs...)
+		}()
+	} else {
+		close(leaderDone)
+		for _, job := range leaderJobs {
+			go job(backgroundCtx)
+		}
+	}
 
-	// Expose Prometheus metrics endpoint
-	handlePrometheusMetrics()
+	// Hand recorded visits to the configured processors (Kafka, MQTT, webhooks)
+	processors, err := loadVisitProcessors(dataStore)
+	if err != nil {
+		log.Fatalf("invalid visit processor configuration: %v", err)
+	}
+	for _, p := range processors {
+		if runner, ok := p.(interface{ Run(context.Context) }); ok {
+			go crashReports.guard("visit_processor", runner.Run)(backgroundCtx)
+		}
+		if closer, ok := p.(interface{ Close() }); ok {
+			defer closer.Close() // Flush queued records before exiting
+		}
+	}
 
-	// Graceful shutdown
-	server := &http.Server{Addr: ":8000", Handler: nil}
-	go func() {
-		log.Println("Server listening on :8000")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	// Every replica dispatches the outbox, as claiming a message locks it from the others
+	if outboxCfg.Enabled {
+		dispatcher := newOutboxDispatcher(dataStore, processors, outboxCfg, realClock{})
+		if anomalyAlerts != nil {
+			dispatcher.Handle(anomalyAlertDestination, anomalyAlerts)
+		}
+		if uptimeAlerts != nil {
+			dispatcher.Handle(uptimeAlertDestination, uptimeAlerts)
+		}
+		go crashReports.guard("outbox", dispatcher.Run)(backgroundCtx)
+	}
+	if len(processors) > 0 {
+		dataStore = newProcessingStore(dataStore, processors, outboxCfg.Enabled)
+	}
+
+	// Register health checks, the API and the metrics endpoint
+	mux := http.NewServeMux()
+	registerRoutes(mux, dataStore, realClock{})
+
+	// Write an Apache-style access log alongside the structured logs when ACCESS_LOG is set
+	accessLog, err := newAccessLogFromEnv()
+	if err != nil {
+		log.Fatalf("invalid access log configuration: %v", err)
+	}
+	if accessLog != nil {
+		defer accessLog.Close()
+	}
+	handler := accessLogMiddleware(mux, accessLog)
+
+	// Listen on the sockets passed by the process this one replaces, if it was started by a
+	// graceful restart, and hand them on in turn on SIGUSR2 when GRACEFUL_RESTART is set
+	restartCfg, err := loadRestartConfig()
+	if err != nil {
+		log.Fatalf("invalid restart configuration: %v", err)
+	}
+	restarts, err := newRestarter(restartCfg)
+	if err != nil {
+		log.Fatalf("failed to set up restarts: %v", err)
+	}
+
+	// On AWS Lambda, take invocations from the runtime API instead of listening
+	server := &http.Server{Addr: listenAddr(), Handler: handler}
+	if runtimeAPI := os.Getenv("AWS_LAMBDA_RUNTIME_API"); runtimeAPI != "" {
+		go func() {
+			log.Println("Serving AWS Lambda invocations")
+			if err := newLambdaAdapter(runtimeAPI, handler).Run(backgroundCtx); err != nil {
+				log.Fatalf("Lambda runtime error: %v", err)
+			}
+		}()
+	} else {
+		listener, err := restarts.Listen("http", server.Addr)
+		if err != nil {
 			log.Fatalf("Server error: %v", err)
 		}
-	}()
+		go func() {
+			log.Printf("Server listening on %s", listener.Addr())
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Server error: %v", err)
+			}
+		}()
+	}
+
+	// Serve the admin endpoints and metrics to client certificates when ADMIN_LISTEN_ADDR is set
+	adminCfg, adminEnabled, err := loadAdminListenerConfig()
+	if err != nil {
+		log.Fatalf("invalid admin listener configuration: %v", err)
+	}
+	var adminServer *http.Server
+	if adminEnabled {
+		if adminServer, err = newAdminServer(adminCfg, handler); err != nil {
+			log.Fatalf("failed to set up admin listener: %v", err)
+		}
+		adminListener, err := restarts.Listen("admin", adminServer.Addr)
+		if err != nil {
+			log.Fatalf("Admin listener error: %v", err)
+		}
+		go func() {
+			log.Printf("Admin listener serving mutual TLS on %s", adminListener.Addr())
+			if err := adminServer.ServeTLS(adminListener, "", ""); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin listener error: %v", err)
+			}
+		}()
+	}
+	if err := restarts.Ready(); err != nil {
+		log.Fatalf("failed to take over from the previous process: %v", err)
+	}
 
 	// Handle SIGINT and SIGTERM signals for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	upgrade := make(chan os.Signal, 1)
+	if restartCfg.Enabled {
+		signal.Notify(upgrade, restartSignals...)
+	}
+	for waiting := true; waiting; {
+		select {
+		case <-quit:
+			waiting = false
+		case <-upgrade:
+			// Once the replacement is serving, drain in-flight requests as for SIGTERM
+			log.Println("Restarting: starting the replacement process...")
+			if err := restarts.Upgrade(); err != nil {
+				log.Printf("Restart failed, still serving: %v", err)
+				continue
+			}
+			log.Println("Replacement process is serving")
+			waiting = false
+		}
+	}
 
 	log.Println("Shutting down server...")
 
@@ -112,6 +449,16 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Printf("Admin listener forced to shutdown: %v", err)
+		}
+	}
+
+	// Stop the background jobs and release the lease, so another replica takes them over
+	stopBackground()
+	<-leaderDone
+	<-bufferDone
 
 	log.Println("Server exiting")
 }
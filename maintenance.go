@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"resume-backend/internal/store"
+)
+
+const maintenancePath = "/api/admin/maintenance"
+
+type maintenanceRequest struct {
+	Action MaintenanceAction `json:"action"`
+}
+
+// maintenanceProgress is one line of the progress a maintenance request streams: a finished
+// step, then a last line that is done or carries the error that stopped the action.
+type maintenanceProgress struct {
+	Step      string  `json:"step,omitempty"`
+	ElapsedMS float64 `json:"elapsed_ms,omitempty"`
+	Done      bool    `json:"done,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// maintenanceHandler runs a database maintenance action, streaming each finished step as a
+// line of JSON so long runs show their progress. Disconnecting cancels the action between or
+// during steps, each of which is safe to interrupt.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+
+	var req maintenanceRequest
+	if r.Body != nil {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVisitBodyBytes)).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, fmt.Sprintf("Invalid maintenance request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if !slices.Contains(store.MaintenanceActions, req.Action) {
+		http.Error(w, fmt.Sprintf("action must be one of %v", store.MaintenanceActions), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // Let progress through buffering proxies
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	rc := http.NewResponseController(w)
+	send := func(p maintenanceProgress) {
+		// A client that went away cancels the request context, which stops the action
+		_ = enc.Encode(p)
+		_ = rc.Flush()
+	}
+
+	log.Printf("Running database maintenance: %s", req.Action)
+	started := time.Now()
+	err := dataStore.RunMaintenance(r.Context(), req.Action, func(step string, elapsed time.Duration) {
+		send(maintenanceProgress{Step: step, ElapsedMS: float64(elapsed.Microseconds()) / 1000})
+	})
+	if err != nil {
+		errorLogger.Printf("Database maintenance %s failed after %s: %v", req.Action, time.Since(started), err)
+		send(maintenanceProgress{Error: err.Error()})
+		return
+	}
+	log.Printf("Database maintenance %s finished in %s", req.Action, time.Since(started))
+	send(maintenanceProgress{Done: true})
+}
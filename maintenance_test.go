@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_maintenanceHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		body   string
+		status int
+		last   maintenanceProgress
+	}{
+		{"Wrong method", http.MethodGet, "Bearer admin-token", "", http.StatusMethodNotAllowed, maintenanceProgress{}},
+		{"Wrong token", http.MethodPost, "Bearer nope", `{"action":"vacuum"}`, http.StatusUnauthorized, maintenanceProgress{}},
+		{"Invalid body", http.MethodPost, "Bearer admin-token", `{"action":`, http.StatusBadRequest, maintenanceProgress{}},
+		{"Unknown action", http.MethodPost, "Bearer admin-token", `{"action":"defragment"}`, http.StatusBadRequest, maintenanceProgress{}},
+		{"Vacuum", http.MethodPost, "Bearer admin-token", `{"action":"vacuum"}`, http.StatusOK, maintenanceProgress{Done: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, maintenancePath, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", tt.auth)
+			rr := httptest.NewRecorder()
+			maintenanceHandler(rr, req, &MockDataStore{}, "admin-token")
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("expected application/x-ndjson, got %q", ct)
+			}
+			var lines []maintenanceProgress
+			scanner := bufio.NewScanner(rr.Body)
+			for scanner.Scan() {
+				var p maintenanceProgress
+				if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+					t.Fatalf("invalid progress line %q: %v", scanner.Text(), err)
+				}
+				lines = append(lines, p)
+			}
+			if len(lines) != 3 || lines[0].Step != "visits" || lines[1].Step != "outbox" || lines[2] != tt.last {
+				t.Errorf("expected a line per step then %+v; got %+v", tt.last, lines)
+			}
+		})
+	}
+}
+
+func Test_maintenanceHandler_failure(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, maintenancePath, strings.NewReader(`{"action":"migrate"}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	maintenanceHandler(rr, req, failingStore{}, "admin-token")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var p maintenanceProgress
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil || p.Error == "" || p.Done {
+		t.Errorf("expected a single error line; got %q", rr.Body.String())
+	}
+}
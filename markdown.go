@@ -0,0 +1,241 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	markdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)(?:\s+#+)?\s*$`)
+	markdownRule    = regexp.MustCompile(`^(?:(?:\*\s*){3,}|(?:-\s*){3,}|(?:_\s*){3,})$`)
+	markdownBullet  = regexp.MustCompile(`^[-*+]\s+`)
+	markdownOrdered = regexp.MustCompile(`^\d{1,9}[.)]\s+`)
+	markdownFence   = regexp.MustCompile("^(`{3,}|~{3,})\\s*([A-Za-z0-9_+-]*)")
+)
+
+// markdownContinuesAt is the indent, in spaces, of the lines that continue a list item.
+const markdownContinuesAt = 2
+
+// renderMarkdown renders the Markdown subset blog posts are written in as HTML: headings,
+// paragraphs, lists, block quotes, fenced code, rules, and inline code, emphasis, links and
+// images. Raw HTML is escaped rather than passed through, and links keep only http, https,
+// mailto and relative URLs, so the result is safe to embed in a page or a feed.
+func renderMarkdown(src string) string {
+	var b strings.Builder
+	renderMarkdownBlocks(&b, strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n"))
+	return b.String()
+}
+
+func renderMarkdownBlocks(b *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		trimmed := strings.TrimSpace(lines[i])
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case markdownFence.MatchString(trimmed):
+			m := markdownFence.FindStringSubmatch(trimmed)
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]); i++ {
+				code = append(code, lines[i])
+			}
+			i++ // the closing fence, if any
+			b.WriteString("<pre><code")
+			if m[2] != "" {
+				b.WriteString(` class="language-` + html.EscapeString(m[2]) + `"`)
+			}
+			b.WriteString(">")
+			for _, l := range code {
+				b.WriteString(html.EscapeString(l) + "\n")
+			}
+			b.WriteString("</code></pre>\n")
+
+		case markdownHeading.MatchString(trimmed):
+			m := markdownHeading.FindStringSubmatch(trimmed)
+			level := string(rune('0' + len(m[1])))
+			b.WriteString("<h" + level + ">" + renderMarkdownInline(m[2]) + "</h" + level + ">\n")
+			i++
+
+		case markdownRule.MatchString(trimmed):
+			b.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				l := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(l, " "))
+			}
+			b.WriteString("<blockquote>\n")
+			renderMarkdownBlocks(b, quoted)
+			b.WriteString("</blockquote>\n")
+
+		case markdownBullet.MatchString(trimmed), markdownOrdered.MatchString(trimmed):
+			marker, tag := markdownBullet, "ul"
+			if !markdownBullet.MatchString(trimmed) {
+				marker, tag = markdownOrdered, "ol"
+			}
+			var items []string
+		list:
+			for ; i < len(lines); i++ {
+				l := strings.TrimSpace(lines[i])
+				switch {
+				case marker.MatchString(l):
+					items = append(items, marker.ReplaceAllString(l, ""))
+				case l != "" && len(lines[i])-len(strings.TrimLeft(lines[i], " ")) >= markdownContinuesAt:
+					items[len(items)-1] += "\n" + l
+				default:
+					break list
+				}
+			}
+			b.WriteString("<" + tag + ">\n")
+			for _, item := range items {
+				b.WriteString("<li>" + renderMarkdownInline(item) + "</li>\n")
+			}
+			b.WriteString("</" + tag + ">\n")
+
+		default:
+			para := []string{trimmed}
+			for i++; i < len(lines) && !startsMarkdownBlock(lines[i]); i++ {
+				para = append(para, strings.TrimSpace(lines[i]))
+			}
+			b.WriteString("<p>" + renderMarkdownInline(strings.Join(para, "\n")) + "</p>\n")
+		}
+	}
+}
+
+// startsMarkdownBlock reports whether line ends a paragraph: it is blank or starts another block.
+func startsMarkdownBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, ">") ||
+		markdownFence.MatchString(trimmed) || markdownHeading.MatchString(trimmed) || markdownRule.MatchString(trimmed) ||
+		markdownBullet.MatchString(trimmed) || markdownOrdered.MatchString(trimmed)
+}
+
+// renderMarkdownInline renders the inline markup of one block's text.
+func renderMarkdownInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_[]()!#>-+.", s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			fence := s[i : i+n]
+			if end := strings.Index(s[i+n:], fence); end >= 0 {
+				b.WriteString("<code>" + html.EscapeString(strings.TrimSpace(s[i+n:i+n+end])) + "</code>")
+				i += 2*n + end
+				continue
+			}
+			b.WriteString(fence)
+			i += n
+			continue
+
+		case c == '!' && strings.HasPrefix(s[i+1:], "["):
+			if alt, dest, n, ok := markdownLink(s[i+1:]); ok {
+				if safeMarkdownURL(dest, false) {
+					b.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(alt) + `">`)
+				} else {
+					b.WriteString(html.EscapeString(alt))
+				}
+				i += 1 + n
+				continue
+			}
+
+		case c == '[':
+			if text, dest, n, ok := markdownLink(s[i:]); ok {
+				if safeMarkdownURL(dest, true) {
+					b.WriteString(`<a href="` + html.EscapeString(dest) + `">` + renderMarkdownInline(text) + "</a>")
+				} else {
+					b.WriteString(renderMarkdownInline(text))
+				}
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_':
+			delim := s[i : i+1]
+			if strings.HasPrefix(s[i:], delim+delim) {
+				delim += delim
+			}
+			// Underscores inside words, as in snake_case, are not emphasis
+			if c == '_' && i > 0 && isWordByte(s[i-1]) {
+				break
+			}
+			rest := s[i+len(delim):]
+			if end := strings.Index(rest, delim); end > 0 && !unicode.IsSpace(rune(rest[0])) && !unicode.IsSpace(rune(rest[end-1])) {
+				tag := "em"
+				if len(delim) == 2 {
+					tag = "strong"
+				}
+				b.WriteString("<" + tag + ">" + renderMarkdownInline(rest[:end]) + "</" + tag + ">")
+				i += 2*len(delim) + end
+				continue
+			}
+			b.WriteString(delim)
+			i += len(delim)
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(html.EscapeString(s[i : i+size]))
+		i += size
+	}
+	return b.String()
+}
+
+// markdownLink parses a [text](destination) at the start of s, returning its parts and length.
+func markdownLink(s string) (text, dest string, n int, ok bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth > 0 {
+				continue
+			}
+			if !strings.HasPrefix(s[i+1:], "(") {
+				return "", "", 0, false
+			}
+			end := strings.IndexByte(s[i+2:], ')')
+			if end < 0 {
+				return "", "", 0, false
+			}
+			return s[1:i], strings.TrimSpace(s[i+2 : i+2+end]), i + 3 + end, true
+		}
+	}
+	return "", "", 0, false
+}
+
+// safeMarkdownURL reports whether u is a relative URL or an http or https one, or, for links,
+// a mailto one.
+func safeMarkdownURL(u string, link bool) bool {
+	if strings.ContainsFunc(u, unicode.IsControl) {
+		return false
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "", "http", "https":
+		return true
+	case "mailto":
+		return link
+	}
+	return false
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func Test_renderMarkdown(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"paragraphs", "One\nline.\n\nTwo.", "<p>One\nline.</p>\n<p>Two.</p>\n"},
+		{"heading", "## Why *Go* ##", "<h2>Why <em>Go</em></h2>\n"},
+		{"emphasis", "**bold**, *em*, _em_ and snake_case_name", "<p><strong>bold</strong>, <em>em</em>, <em>em</em> and snake_case_name</p>\n"},
+		{"unmatched", "2 * 3 and a * b", "<p>2 * 3 and a * b</p>\n"},
+		{"code span", "Run `go test <pkg>`", "<p>Run <code>go test &lt;pkg&gt;</code></p>\n"},
+		{"escapes", `\*not em\*`, "<p>*not em*</p>\n"},
+		{"link", "[the *docs*](https://go.dev/doc?a=1&b=2)", `<p><a href="https://go.dev/doc?a=1&amp;b=2">the <em>docs</em></a></p>` + "\n"},
+		{"relative link", "[home](/)", `<p><a href="/">home</a></p>` + "\n"},
+		{"script link", "[click](javascript:alert(1))", "<p>click)</p>\n"},
+		{"image", "![a gopher](/img/gopher.png)", `<p><img src="/img/gopher.png" alt="a gopher"></p>` + "\n"},
+		{"mailto image", "![me](mailto:me@example.com)", "<p>me</p>\n"},
+		{"raw html", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"bullets", "- one\n- two\n  continued\n\nafter", "<ul>\n<li>one</li>\n<li>two\ncontinued</li>\n</ul>\n<p>after</p>\n"},
+		{"ordered", "1. one\n2) two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+		{"quote", "> quoted\n> # heading", "<blockquote>\n<p>quoted</p>\n<h1>heading</h1>\n</blockquote>\n"},
+		{"fence", "```go\nfmt.Println(\"<hi>\")\n\n```", "<pre><code class=\"language-go\">fmt.Println(&#34;&lt;hi&gt;&#34;)\n\n</code></pre>\n"},
+		{"unclosed fence", "~~~\ncode", "<pre><code>code\n</code></pre>\n"},
+		{"rule", "above\n\n---\nbelow", "<p>above</p>\n<hr>\n<p>below</p>\n"},
+		{"paragraph ends at a block", "text\n- item", "<p>text</p>\n<ul>\n<li>item</li>\n</ul>\n"},
+		{"tab indent", "\t# heading", "<h1>heading</h1>\n"},
+	}
+	for _, tt := range tests {
+		if got := renderMarkdown(tt.src); got != tt.want {
+			t.Errorf("%s: renderMarkdown(%q) = %q, want %q", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
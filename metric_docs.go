@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const metricDocsPath = "/api/metrics/docs"
+
+// descPattern reads the name, help and variable labels back out of a prometheus.Desc, which
+// doesn't export them. Label names never need quoting, so they're joined by bare commas.
+var descPattern = regexp.MustCompile(`^Desc\{fqName: ("(?:[^"\\]|\\.)*"), help: ("(?:[^"\\]|\\.)*"), constLabels: \{.*\}, variableLabels: \{([^}]*)\}\}$`)
+
+// metricDoc describes one metric the service exposes on /metrics.
+type metricDoc struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Help   string   `json:"help"`
+	Labels []string `json:"labels"`
+}
+
+// metricDocsResponse is the body returned by GET /api/metrics/docs.
+type metricDocsResponse struct {
+	Metrics []metricDoc `json:"metrics"`
+}
+
+// describeMetrics documents collectors, sorted by name. Vectors report their type by Go type,
+// since they have no series to inspect until a label value is used; single metrics write one.
+func describeMetrics(collectors []prometheus.Collector) ([]metricDoc, error) {
+	docs := make([]metricDoc, 0, len(collectors))
+	for _, c := range collectors {
+		ch := make(chan *prometheus.Desc, 1)
+		c.Describe(ch)
+		desc := <-ch
+		m := descPattern.FindStringSubmatch(desc.String())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected metric description %s", desc)
+		}
+		doc := metricDoc{Labels: []string{}}
+		doc.Name, _ = strconv.Unquote(m[1])
+		doc.Help, _ = strconv.Unquote(m[2])
+		if m[3] != "" {
+			doc.Labels = strings.Split(m[3], ",")
+		}
+
+		typ, err := collectorType(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe %s: %w", doc.Name, err)
+		}
+		doc.Type = typ
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	return docs, nil
+}
+
+// collectorType returns the Prometheus type of c: counter, gauge, histogram or summary.
+func collectorType(c prometheus.Collector) (string, error) {
+	switch c.(type) {
+	case *prometheus.CounterVec:
+		return "counter", nil
+	case *prometheus.GaugeVec:
+		return "gauge", nil
+	case *prometheus.HistogramVec:
+		return "histogram", nil
+	case *prometheus.SummaryVec:
+		return "summary", nil
+	}
+
+	metric, ok := c.(prometheus.Metric)
+	if !ok {
+		return "", fmt.Errorf("unsupported collector %T", c)
+	}
+	var out dto.Metric
+	if err := metric.Write(&out); err != nil {
+		return "", err
+	}
+	switch {
+	case out.Counter != nil:
+		return "counter", nil
+	case out.Gauge != nil:
+		return "gauge", nil
+	case out.Histogram != nil:
+		return "histogram", nil
+	case out.Summary != nil:
+		return "summary", nil
+	}
+	return "untyped", nil
+}
+
+// metricDocsHandler lists every metric in prometheusCollectors with its type, labels and
+// help, so dashboards and alert rules can be checked against what the service exposes.
+func metricDocsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	docs, err := describeMetrics(prometheusCollectors)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to describe metrics: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeResponse(w, r, metricDocsResponse{Metrics: docs})
+}
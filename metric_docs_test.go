@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_describeMetrics(t *testing.T) {
+	collectors := []prometheus.Collector{
+		prometheus.NewCounterVec(prometheus.CounterOpts{Name: "b_total", Help: `Quoted "help"`}, []string{"method", "endpoint"}),
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "a_gauge", Help: "A gauge", ConstLabels: prometheus.Labels{"app": "resume"}}),
+		prometheus.NewHistogram(prometheus.HistogramOpts{Name: "c_seconds", Help: "A histogram"}),
+		prometheus.NewSummaryVec(prometheus.SummaryOpts{Name: "d_bytes", Help: "A summary"}, []string{"kind"}),
+	}
+	docs, err := describeMetrics(collectors)
+	if err != nil {
+		t.Fatalf("describeMetrics() error = %v", err)
+	}
+
+	want := []metricDoc{
+		{Name: "a_gauge", Type: "gauge", Help: "A gauge", Labels: []string{}},
+		{Name: "b_total", Type: "counter", Help: `Quoted "help"`, Labels: []string{"method", "endpoint"}},
+		{Name: "c_seconds", Type: "histogram", Help: "A histogram", Labels: []string{}},
+		{Name: "d_bytes", Type: "summary", Help: "A summary", Labels: []string{"kind"}},
+	}
+	if len(docs) != len(want) {
+		t.Fatalf("expected %d metrics, got %+v", len(want), docs)
+	}
+	for i := range want {
+		if docs[i].Name != want[i].Name || docs[i].Type != want[i].Type || docs[i].Help != want[i].Help ||
+			strings.Join(docs[i].Labels, ",") != strings.Join(want[i].Labels, ",") {
+			t.Errorf("expected %+v, got %+v", want[i], docs[i])
+		}
+	}
+}
+
+func Test_metricDocsHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	metricDocsHandler(rr, httptest.NewRequest(http.MethodGet, metricDocsPath, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var got metricDocsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+
+	// Every registered collector is documented
+	if len(got.Metrics) != len(prometheusCollectors) {
+		t.Fatalf("expected %d metrics, got %d", len(prometheusCollectors), len(got.Metrics))
+	}
+	byName := map[string]metricDoc{}
+	for _, m := range got.Metrics {
+		byName[m.Name] = m
+	}
+	errors := byName[metricHTTPRequestErrorsTotal]
+	if errors.Type != "counter" || strings.Join(errors.Labels, ",") != "method,endpoint,status_class" {
+		t.Errorf("unexpected documentation %+v", errors)
+	}
+	if inFlight := byName[metricHTTPRequestsInFlight]; inFlight.Type != "gauge" || len(inFlight.Labels) != 0 {
+		t.Errorf("unexpected documentation %+v", inFlight)
+	}
+
+	rr = httptest.NewRecorder()
+	metricDocsHandler(rr, httptest.NewRequest(http.MethodPost, metricDocsPath, nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	metricsBackendEnv     = "METRICS_BACKEND"
+	defaultDogStatsDAddr  = "127.0.0.1:8125"
+	defaultDogStatsPrefix = "resume_backend."
+)
+
+// Metrics abstracts how request metrics are emitted so the service can run
+// against Prometheus or DogStatsD.
+type Metrics interface {
+	RequestStarted()
+	RequestFinished(r *http.Request, method, endpoint string, status, size int, duration time.Duration)
+	PanicRecovered()
+	RequestShed()
+	RequestRateLimited(endpoint string)
+	CaptchaVerified(provider, result string)    // result is "passed", "failed" or "error"
+	OutboxDelivered(destination, result string) // result is "delivered", "retried" or "dead_lettered"
+	CertificateExpiry(domain string, days float64)
+	QueryFinished(ctx context.Context, operation, result string, rows int64, duration time.Duration) // result is "ok" or "error"
+	DatabaseTarget(target int)                                                                       // 0 for the primary, 1 on for DATABASE_FAILOVER_URLS
+	ClientTimestampViolation(endpoint, violation string)                                             // violation is "future", "skewed" or "too_old"
+}
+
+// Backend used by middleware; replaced with the result of newMetricsFromEnv at startup
+var appMetrics Metrics = prometheusMetrics{}
+
+// newMetricsFromEnv selects the metrics backend from METRICS_BACKEND ("prometheus" or "dogstatsd").
+func newMetricsFromEnv() (Metrics, error) {
+	switch backend := strings.ToLower(os.Getenv(metricsBackendEnv)); backend {
+	case "", "prometheus":
+		return prometheusMetrics{}, nil
+	case "dogstatsd", "datadog":
+		addr := os.Getenv("DOGSTATSD_ADDR")
+		if addr == "" {
+			addr = defaultDogStatsDAddr
+		}
+		prefix, ok := os.LookupEnv("DOGSTATSD_PREFIX")
+		if !ok {
+			prefix = defaultDogStatsPrefix
+		}
+		return newDogStatsDMetrics(addr, prefix)
+	default:
+		return nil, fmt.Errorf("unknown %s: %s", metricsBackendEnv, backend)
+	}
+}
+
+// observeQuery reports a query traced by the store to the metrics backend.
+func observeQuery(ctx context.Context, trace QueryTrace) {
+	result := "ok"
+	if trace.Err != nil {
+		result = "error"
+	}
+	appMetrics.QueryFinished(ctx, trace.Operation, result, trace.Rows, trace.Duration)
+}
+
+// Label used for requests that didn't match a registered route or used an unknown method
+const otherLabel = "other"
+
+// Methods kept as-is in the method label; anything else is bucketed as otherLabel
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// methodLabel bounds the method label to the standard HTTP methods.
+func methodLabel(r *http.Request) string {
+	if knownMethods[r.Method] {
+		return r.Method
+	}
+	return otherLabel
+}
+
+// routeLabel returns the route template the mux matched for r, or otherLabel for unmatched paths.
+// The raw URL path is never used so probing random URLs can't create new series.
+func routeLabel(r *http.Request) string {
+	pattern := r.Pattern
+	if pattern == "" {
+		return otherLabel
+	}
+	// Drop the method and host from patterns like "GET example.com/api/count"
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		pattern = path
+	}
+	if i := strings.Index(pattern, "/"); i > 0 {
+		pattern = pattern[i:]
+	}
+	return pattern
+}
+
+// routeMiddleware resolves the route mux matches for r ahead of the middleware chain around mux.
+// Middleware further in gets copies of the request (with a request ID, consent, a timeout),
+// which all carry the resolved pattern, where setting it on the innermost copy would leave the
+// outer ones labelled with the enclosing mux's pattern.
+func routeMiddleware(next http.Handler, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		routed := new(http.Request)
+		*routed = *r
+		routed.Pattern = pattern
+		next.ServeHTTP(w, routed)
+	})
+}
+
+// statusClass returns "4xx" or "5xx" for error statuses and "" otherwise.
+func statusClass(status int) string {
+	switch {
+	case status >= 500:
+		return "5xx"
+	case status >= 400:
+		return "4xx"
+	default:
+		return ""
+	}
+}
+
+// middleware that reports request metrics to the given backend
+func metricsMiddleware(next http.Handler, m Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.RequestStarted()
+
+		start := time.Now()
+		rw := newResponseRecorder(w)
+		next.ServeHTTP(rw, r)
+
+		// Labels are resolved after serving so r.Pattern is set when the middleware wraps a mux
+		m.RequestFinished(r, methodLabel(r), routeLabel(r), rw.Status(), rw.bytes, time.Since(start))
+	})
+}
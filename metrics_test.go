@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetrics records the calls made by metricsMiddleware.
+type fakeMetrics struct {
+	mu                  sync.Mutex
+	started             int
+	finished            []fakeRequestMetric
+	panics              int
+	shed                int
+	rateLimited         []string // endpoints
+	captchas            []string // "provider/result"
+	outbox              []string // "destination/result"
+	certExpiry          map[string]float64
+	queries             []string // "operation/result"
+	dbTarget            int
+	timestampViolations []string // "endpoint/violation"
+}
+
+type fakeRequestMetric struct {
+	method, endpoint string
+	status, size     int
+}
+
+func (m *fakeMetrics) RequestStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started++
+}
+
+func (m *fakeMetrics) RequestFinished(r *http.Request, method, endpoint string, status, size int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finished = append(m.finished, fakeRequestMetric{method, endpoint, status, size})
+}
+
+func (m *fakeMetrics) PanicRecovered() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.panics++
+}
+
+func (m *fakeMetrics) RequestShed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shed++
+}
+
+func (m *fakeMetrics) RequestRateLimited(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rateLimited = append(m.rateLimited, endpoint)
+}
+
+func (m *fakeMetrics) CaptchaVerified(provider, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.captchas = append(m.captchas, provider+"/"+result)
+}
+
+func (m *fakeMetrics) OutboxDelivered(destination, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outbox = append(m.outbox, destination+"/"+result)
+}
+
+func (m *fakeMetrics) CertificateExpiry(domain string, days float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.certExpiry == nil {
+		m.certExpiry = make(map[string]float64)
+	}
+	m.certExpiry[domain] = days
+}
+
+func (m *fakeMetrics) QueryFinished(ctx context.Context, operation, result string, rows int64, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries = append(m.queries, operation+"/"+result)
+}
+
+func (m *fakeMetrics) DatabaseTarget(target int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dbTarget = target
+}
+
+func (m *fakeMetrics) ClientTimestampViolation(endpoint, violation string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timestampViolations = append(m.timestampViolations, endpoint+"/"+violation)
+}
+
+// useFakeMetrics swaps appMetrics for a fakeMetrics for the duration of the test,
+// keeping the global Prometheus collectors untouched.
+func useFakeMetrics(t *testing.T) *fakeMetrics {
+	t.Helper()
+	m := &fakeMetrics{}
+	original := appMetrics
+	appMetrics = m
+	t.Cleanup(func() { appMetrics = original })
+	return m
+}
+
+func Test_newMetricsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		wantErr bool
+	}{
+		{"Default", "", false},
+		{"Prometheus", "prometheus", false},
+		{"DogStatsD", "dogstatsd", false},
+		{"Unknown", "graphite", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(metricsBackendEnv, tt.backend)
+
+			m, err := newMetricsFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newMetricsFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if closer, ok := m.(interface{ Close() }); ok {
+				closer.Close()
+			}
+		})
+	}
+}
+
+func Test_routeLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    string
+	}{
+		{"Unmatched path", "", otherLabel},
+		{"Plain pattern", "/api/count", "/api/count"},
+		{"Method pattern", "GET /api/count", "/api/count"},
+		{"Host pattern", "example.com/api/count", "/api/count"},
+		{"Method and host pattern", "POST example.com/api/count", "/api/count"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+			req.Pattern = tt.pattern
+			if got := routeLabel(req); got != tt.want {
+				t.Errorf("routeLabel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_metricsMiddleware(t *testing.T) {
+	m := &fakeMetrics{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/count", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+	handler := metricsMiddleware(mux, m)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/count", nil))
+
+	if m.started != 1 || len(m.finished) != 1 {
+		t.Fatalf("expected 1 started and 1 finished request, got %d and %d", m.started, len(m.finished))
+	}
+	want := fakeRequestMetric{http.MethodPost, "/api/count", http.StatusTeapot, 15}
+	if m.finished[0] != want {
+		t.Errorf("expected %+v, got %+v", want, m.finished[0])
+	}
+}
+
+func Test_observeQuery(t *testing.T) {
+	m := useFakeMetrics(t)
+	observeQuery(context.Background(), QueryTrace{Operation: "select", Rows: 3, Duration: time.Millisecond})
+	observeQuery(context.Background(), QueryTrace{Operation: "update", Err: context.DeadlineExceeded})
+
+	if got := strings.Join(m.queries, ","); got != "select/ok,update/error" {
+		t.Errorf("expected the queries reported with their result, got %s", got)
+	}
+}
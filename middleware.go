@@ -1,13 +1,219 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"regexp"
+	"runtime/debug"
 	"strings"
 	"time"
+
+	"resume-backend/internal/logging"
 )
 
+type contextKey string
+
+const requestIDKey contextKey = "requestID"
+
+// requestIDPattern is what a client-supplied X-Request-ID must match to be reused; it is
+// echoed back and written into every log line
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDFromContext returns the request ID stored by requestIDMiddleware, if any.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// newRequestID generates a random hex-encoded request ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLogger logs errors through errorLogger, tagged with the request they happened in.
+type requestLogger struct {
+	tags string
+}
+
+func newRequestLogger(id string, r *http.Request) requestLogger {
+	return requestLogger{tags: fmt.Sprintf(" - Request ID: %s - %s %s", id, r.Method, r.URL.Path)}
+}
+
+func (l requestLogger) Printf(format string, v ...interface{}) {
+	errorLogger.printTagged(fmt.Sprintf(format, v...), l.tags)
+}
+
+// middleware that tags each request with an ID, reusing X-Request-ID when it is a short
+// token, and attaches a logger carrying it for handlers and stores to use
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		ctx = logging.NewContext(ctx, newRequestLogger(id, r))
+		if tracingEnabled {
+			ctx = withTraceID(ctx, r)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// middleware that recovers from handler panics and returns a JSON 500 instead of dropping the connection
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// http.ErrAbortHandler is the documented way to abort a response; let net/http handle it
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			log.Printf("Panic recovered: %v - Request ID: %s - %s %s\n%s",
+				rec, requestIDFromContext(r.Context()), r.Method, r.URL, debug.Stack())
+			appMetrics.PanicRecovered()
+			crashReports.CapturePanic(r, rec)
+			writeJSONError(w, r, http.StatusInternalServerError, "internal_error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustedProxies are the proxies in front of the server, set from TRUSTED_PROXIES at startup.
+// X-Forwarded-For is only believed as far as they appended it.
+var trustedProxies []netip.Prefix
+
+// loadTrustedProxies reads TRUSTED_PROXIES, a comma-separated list of IP addresses and CIDR
+// ranges, such as the ingress controller's pod network.
+func loadTrustedProxies() ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: must be an IP address or CIDR range", entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// isTrustedProxy reports whether ip is one of the trusted proxies.
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the originating client address. A request from a trusted proxy is
+// attributed to the right-most X-Forwarded-For entry that isn't another trusted proxy, as
+// anything left of that was sent by the client and can be forged; otherwise, and when the
+// header is missing or malformed, it is RemoteAddr.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	var hops []string
+	for _, xff := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(xff, ",")...)
+	}
+	ip := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// hasBearerToken reports whether r is authorized with token, compared in constant time.
+func hasBearerToken(r *http.Request, token string) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// responseRecorder wraps a ResponseWriter to record the status code and body size.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// Status returns the recorded status code, defaulting to 200 if nothing was written.
+func (rw *responseRecorder) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // middleware for logging with request duration
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -17,8 +223,17 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func originCheckMiddleware(next http.Handler) http.Handler {
+// originCheckMiddleware rejects requests from origins outside ALLOWED_ORIGINS. Server-to-server
+// callers send no Origin, so requests with a verified client certificate, or to a route the
+// signing config covers, are let through: signatureMiddleware rejects those without a valid
+// signature further in.
+func originCheckMiddleware(next http.Handler, signing signingConfig) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hasVerifiedClientCert(r) || (signing.Covers(r) && r.Header.Get(signatureHeader) != "") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		allowedOrigins := os.Getenv("ALLOWED_ORIGINS")
 		if allowedOrigins == "" {
 			http.Error(w, "Allowed origins not set", http.StatusInternalServerError)
@@ -34,6 +249,7 @@ func originCheckMiddleware(next http.Handler) http.Handler {
 		}
 
 		if !allowed {
+			forbiddenLogger.Printf("Forbidden origin %q: %s %s", r.Header.Get("Origin"), r.Method, r.URL)
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"resume-backend/internal/logging"
 )
 
 func Test_loggingMiddleware(t *testing.T) {
@@ -71,7 +79,7 @@ func Test_originCheckMiddleware(t *testing.T) {
 			rr := httptest.NewRecorder()
 
 			// Wrap the dummy handler with the originCheckMiddleware
-			handler := originCheckMiddleware(dummyHandler)
+			handler := originCheckMiddleware(dummyHandler, signingConfig{})
 
 			// Serve the request
 			handler.ServeHTTP(rr, req)
@@ -91,3 +99,179 @@ func Test_originCheckMiddleware(t *testing.T) {
 	// Clean up environment variable
 	os.Unsetenv("ALLOWED_ORIGINS")
 }
+
+func Test_requestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		headerID string
+		reused   bool
+	}{
+		{"Generated request ID", "", false},
+		{"Provided request ID", "abc123", true},
+		{"Provided trace-style ID", "req_2024.06-01", true},
+		{"Too long", strings.Repeat("a", 65), false},
+		{"Log injection", "abc\nFAKE log line", false},
+		{"Spaces", "abc 123", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotID string
+			dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotID = requestIDFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.headerID != "" {
+				req.Header.Set("X-Request-ID", tt.headerID)
+			}
+			rr := httptest.NewRecorder()
+
+			requestIDMiddleware(dummyHandler).ServeHTTP(rr, req)
+
+			if gotID == "" {
+				t.Fatal("expected request ID in context, got empty string")
+			}
+			if tt.reused != (gotID == tt.headerID) {
+				t.Errorf("expected the provided ID reused: %t, got %q", tt.reused, gotID)
+			}
+			if rr.Header().Get("X-Request-ID") != gotID {
+				t.Errorf("expected X-Request-ID header %s, got %s", gotID, rr.Header().Get("X-Request-ID"))
+			}
+		})
+	}
+}
+
+func Test_requestIDMiddleware_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Printf("Error in test handler: %s", "boom")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/count?x=1", nil)
+	req.Header.Set("X-Request-ID", "abc123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if want := "Error in test handler: boom - Request ID: abc123 - POST /api/count\n"; !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("expected the log line to carry the request; got %q", buf.String())
+	}
+}
+
+func Test_recoveryMiddleware(t *testing.T) {
+	panicHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+
+	handler := requestIDMiddleware(recoveryMiddleware(panicHandler))
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, status)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %s", ct)
+	}
+
+	var response map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if response["error"] != "Internal server error" || response["code"] != "internal_error" {
+		t.Errorf("expected error 'Internal server error' with code internal_error, got %v", response)
+	}
+
+	// The message follows Accept-Language, the code doesn't
+	req.Header.Set("Accept-Language", "fr-CH, fr;q=0.9, en;q=0.8")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if response["error"] != "Erreur interne du serveur" || response["code"] != "internal_error" || rr.Header().Get("Content-Language") != "fr" {
+		t.Errorf("expected the error in French, got %v in %q", response, rr.Header().Get("Content-Language"))
+	}
+}
+
+func Test_responseRecorder(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantBytes  int
+	}{
+		{"Implicit 200", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) }, http.StatusOK, 5},
+		{"Explicit status", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }, http.StatusNotFound, 0},
+		{"Nothing written", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := newResponseRecorder(httptest.NewRecorder())
+			tt.handler(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rw.Status() != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rw.Status())
+			}
+			if rw.bytes != tt.wantBytes {
+				t.Errorf("expected %d bytes, got %d", tt.wantBytes, rw.bytes)
+			}
+		})
+	}
+}
+
+func Test_clientIP(t *testing.T) {
+	previous := trustedProxies
+	t.Cleanup(func() { trustedProxies = previous })
+	trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		{"RemoteAddr", "192.0.2.1:5555", "", "192.0.2.1"},
+		{"X-Forwarded-For", "10.0.0.1:5555", "203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"IPv6 RemoteAddr", "[2001:db8::1]:5555", "", "2001:db8::1"},
+		{"Untrusted sender", "192.0.2.1:5555", "203.0.113.7", "192.0.2.1"},
+		{"Forged entry", "10.0.0.1:5555", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"Malformed entry", "10.0.0.1:5555", "203.0.113.7, junk", "10.0.0.1"},
+		{"Only proxies", "10.0.0.1:5555", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := clientIP(req); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.10,2001:db8::/32")
+	proxies, err := loadTrustedProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.10/32"), netip.MustParsePrefix("2001:db8::/32")}
+	if !reflect.DeepEqual(proxies, want) {
+		t.Errorf("expected %v, got %v", want, proxies)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "ingress")
+	if _, err := loadTrustedProxies(); err == nil {
+		t.Error("expected an error for an invalid entry")
+	}
+}
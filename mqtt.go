@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMQTTTopic        = "resume/visits"
+	defaultMQTTClientID     = "resume-backend"
+	defaultMQTTPollInterval = 30 * time.Second
+	mqttKeepAlive           = 60 * time.Second
+	mqttDialTimeout         = 10 * time.Second
+	mqttMinBackoff          = time.Second
+	mqttMaxBackoff          = time.Minute
+
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttPingreq    = 0xC0
+	mqttDisconnect = 0xE0
+)
+
+func init() {
+	registerVisitProcessor("mqtt", newMQTTProcessorFromEnv)
+}
+
+// mqttConfig controls publishing the visit count to an MQTT broker.
+type mqttConfig struct {
+	Addr         string // host:port
+	TLS          bool
+	Topic        string
+	ClientID     string
+	Username     string
+	Password     string
+	PollInterval time.Duration // picks up visits recorded by other replicas
+}
+
+// loadMQTTConfig reads the MQTT_* settings. Publishing is enabled by setting MQTT_BROKER to
+// a URL like tcp://broker:1883 or mqtts://broker:8883.
+func loadMQTTConfig() (mqttConfig, bool, error) {
+	broker := os.Getenv("MQTT_BROKER")
+	if broker == "" {
+		return mqttConfig{}, false, nil
+	}
+
+	u, err := url.Parse(broker)
+	if err != nil || u.Host == "" {
+		return mqttConfig{}, false, fmt.Errorf("invalid MQTT_BROKER %q: must be a URL like tcp://host:1883", broker)
+	}
+	cfg := mqttConfig{
+		Addr:         u.Host,
+		Topic:        defaultMQTTTopic,
+		ClientID:     defaultMQTTClientID,
+		Username:     os.Getenv("MQTT_USERNAME"),
+		Password:     os.Getenv("MQTT_PASSWORD"),
+		PollInterval: defaultMQTTPollInterval,
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		if u.Port() == "" {
+			cfg.Addr = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	case "ssl", "tls", "mqtts":
+		cfg.TLS = true
+		if u.Port() == "" {
+			cfg.Addr = net.JoinHostPort(u.Hostname(), "8883")
+		}
+	default:
+		return mqttConfig{}, false, fmt.Errorf("invalid MQTT_BROKER scheme %q: must be tcp or mqtts", u.Scheme)
+	}
+	if v := os.Getenv("MQTT_TOPIC"); v != "" {
+		cfg.Topic = v
+	}
+	if v := os.Getenv("MQTT_CLIENT_ID"); v != "" {
+		cfg.ClientID = v
+	}
+	if v := os.Getenv("MQTT_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return mqttConfig{}, false, fmt.Errorf("invalid MQTT_POLL_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.PollInterval = d
+	}
+
+	log.Printf("MQTT publishing enabled: visit count to %s on %s", cfg.Topic, cfg.Addr)
+	return cfg, true, nil
+}
+
+// mqttPublisher keeps the visit count published as a retained message, so a display that
+// subscribes gets the current count straight away. It publishes whenever a visit is recorded
+// and on each poll, skipping unchanged counts, and reconnects with exponential backoff.
+type mqttPublisher struct {
+	store   DataStore
+	cfg     mqttConfig
+	changed chan struct{}
+}
+
+func newMQTTPublisher(store DataStore, cfg mqttConfig) *mqttPublisher {
+	return &mqttPublisher{store: store, cfg: cfg, changed: make(chan struct{}, 1)}
+}
+
+// newMQTTProcessorFromEnv keeps the visit count published to MQTT when MQTT_BROKER is set.
+func newMQTTProcessorFromEnv(store DataStore) (VisitProcessor, error) {
+	cfg, enabled, err := loadMQTTConfig()
+	if err != nil || !enabled {
+		return nil, err
+	}
+	return newMQTTPublisher(store, cfg), nil
+}
+
+func (p *mqttPublisher) Name() string {
+	return "mqtt"
+}
+
+// ProcessVisit schedules a publish of the new count.
+func (p *mqttPublisher) ProcessVisit(ctx context.Context, visit Visit) error {
+	p.Notify()
+	return nil
+}
+
+// Notify signals that the count may have changed. It never blocks; signals sent while one
+// is pending are merged.
+func (p *mqttPublisher) Notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run connects and publishes until ctx is done, reconnecting after failures.
+func (p *mqttPublisher) Run(ctx context.Context) {
+	backoff := mqttMinBackoff
+	for {
+		start := time.Now()
+		err := p.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		errorLogger.Printf("MQTT connection lost, reconnecting in %s: %v", backoff, err)
+
+		// A connection that stayed up a while starts the backoff over
+		if time.Since(start) > mqttMaxBackoff {
+			backoff = mqttMinBackoff
+		}
+		if sleepContext(ctx, backoff) != nil {
+			return
+		}
+		backoff = min(backoff*2, mqttMaxBackoff)
+	}
+}
+
+// session runs one connection until it fails or ctx is done.
+func (p *mqttPublisher) session(ctx context.Context) error {
+	conn, err := p.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The only packets a publish-only client receives are PINGRESPs; reading them detects a dead broker
+	readErr := make(chan error, 1)
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			if _, _, err := readMQTTPacket(r); err != nil {
+				readErr <- err
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+
+	poll := time.NewTicker(p.cfg.PollInterval)
+	defer poll.Stop()
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+
+	last := -1
+	publish := func() error {
+		count, err := p.store.GetVisitCount(ctx)
+		if err != nil || count == last {
+			return nil // a failed read is retried on the next poll
+		}
+		if _, err := conn.Write(mqttPublishPacket(p.cfg.Topic, []byte(strconv.Itoa(count)))); err != nil {
+			return fmt.Errorf("failed to publish visit count: %w", err)
+		}
+		last = count
+		return nil
+	}
+	if err := publish(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Write([]byte{mqttDisconnect, 0})
+			return ctx.Err()
+		case err := <-readErr:
+			return err
+		case <-p.changed:
+			err = publish()
+		case <-poll.C:
+			err = publish()
+		case <-ping.C:
+			_, err = conn.Write([]byte{mqttPingreq, 0})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// connect dials the broker and completes the CONNECT handshake.
+func (p *mqttPublisher) connect(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: mqttDialTimeout}
+	var conn net.Conn
+	var err error
+	if p.cfg.TLS {
+		host, _, _ := net.SplitHostPort(p.cfg.Addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", p.cfg.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", p.cfg.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+	if _, err := conn.Write(mqttConnectPacket(p.cfg)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send MQTT CONNECT: %w", err)
+	}
+	packetType, body, err := readMQTTPacket(bufio.NewReader(conn))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read MQTT CONNACK: %w", err)
+	}
+	if packetType != mqttConnack || len(body) != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected MQTT packet type %#x instead of CONNACK", packetType)
+	}
+	if code := body[1]; code != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("MQTT broker refused the connection with code %d", code)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// mqttConnectPacket encodes an MQTT 3.1.1 CONNECT with a clean session.
+func mqttConnectPacket(cfg mqttConfig) []byte {
+	flags := byte(0x02) // clean session
+	var payload []byte
+	payload = appendMQTTString(payload, cfg.ClientID)
+	if cfg.Username != "" {
+		flags |= 0x80
+		payload = appendMQTTString(payload, cfg.Username)
+		if cfg.Password != "" {
+			flags |= 0x40
+			payload = appendMQTTString(payload, cfg.Password)
+		}
+	}
+
+	var body []byte
+	body = appendMQTTString(body, "MQTT")
+	body = append(body, 4, flags) // protocol level 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = append(body, payload...)
+	return appendMQTTPacket(nil, mqttConnect, body)
+}
+
+// mqttPublishPacket encodes a retained QoS 0 PUBLISH; the display only needs the latest value.
+func mqttPublishPacket(topic string, payload []byte) []byte {
+	body := appendMQTTString(nil, topic)
+	body = append(body, payload...)
+	return appendMQTTPacket(nil, mqttPublish|0x01, body)
+}
+
+// appendMQTTPacket adds the fixed header: the packet type and flags, then the remaining
+// length, which uses the same 7-bit continuation encoding as a uvarint.
+func appendMQTTPacket(b []byte, header byte, body []byte) []byte {
+	b = append(b, header)
+	b = binary.AppendUvarint(b, uint64(len(body)))
+	return append(b, body...)
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readMQTTPacket reads one packet, returning its type (the high nibble of the header) and body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > 1<<20 {
+		return 0, nil, errors.New("MQTT packet too large")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xF0, body, nil
+}
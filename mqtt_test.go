@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// atomicCountStore is a DataStore whose visit count can be changed while the publisher reads it
+type atomicCountStore struct {
+	MockDataStore
+	count atomic.Int64
+}
+
+func (s *atomicCountStore) IncrementVisitCount(ctx context.Context, visit Visit) error {
+	s.count.Add(1)
+	return nil
+}
+
+func (s *atomicCountStore) GetVisitCount(ctx context.Context) (int, error) {
+	return int(s.count.Load()), nil
+}
+
+// fakeMQTTBroker accepts connections and records the retained publishes it receives
+type fakeMQTTBroker struct {
+	t        *testing.T
+	ln       net.Listener
+	refuse   atomic.Int32 // connections to refuse with "not authorized"
+	connects atomic.Int32
+	mu       sync.Mutex
+	received chan string
+	clientID string
+	username string
+}
+
+func newFakeMQTTBroker(t *testing.T) *fakeMQTTBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	b := &fakeMQTTBroker{t: t, ln: ln, received: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.handle(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return b
+}
+
+func (b *fakeMQTTBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	packetType, body, err := readMQTTPacket(r)
+	if err != nil || packetType != mqttConnect {
+		b.t.Errorf("expected CONNECT; got %#x, %v", packetType, err)
+		return
+	}
+	b.connects.Add(1)
+	d := body[2+4+1+1+2:] // protocol name, level, flags and keep alive
+	readString := func() string {
+		n := binary.BigEndian.Uint16(d)
+		s := string(d[2 : 2+n])
+		d = d[2+n:]
+		return s
+	}
+	b.mu.Lock()
+	b.clientID = readString()
+	if body[7]&0x80 != 0 {
+		b.username = readString()
+	}
+	b.mu.Unlock()
+
+	if b.refuse.Add(-1) >= 0 {
+		conn.Write([]byte{mqttConnack, 2, 0, 5})
+		return
+	}
+	conn.Write([]byte{mqttConnack, 2, 0, 0})
+
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		r.UnreadByte()
+		packetType, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		switch packetType {
+		case mqttPublish:
+			if header&0x01 == 0 {
+				b.t.Error("expected a retained publish")
+			}
+			n := binary.BigEndian.Uint16(body)
+			if topic := string(body[2 : 2+n]); topic != defaultMQTTTopic {
+				b.t.Errorf("expected topic %s; got %s", defaultMQTTTopic, topic)
+			}
+			b.received <- string(body[2+n:])
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+func (b *fakeMQTTBroker) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-b.received:
+		if got != want {
+			t.Errorf("expected %q to be published; got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q to be published", want)
+	}
+}
+
+func Test_loadMQTTConfig(t *testing.T) {
+	if _, enabled, err := loadMQTTConfig(); enabled || err != nil {
+		t.Fatalf("expected MQTT to be off without MQTT_BROKER; got %v, %v", enabled, err)
+	}
+
+	tests := []struct {
+		broker   string
+		wantAddr string
+		wantTLS  bool
+		wantErr  bool
+	}{
+		{"tcp://broker.local", "broker.local:1883", false, false},
+		{"mqtts://broker.example.com", "broker.example.com:8883", true, false},
+		{"tcp://10.0.0.5:1884", "10.0.0.5:1884", false, false},
+		{"http://broker.local", "", false, true},
+		{"broker.local:1883", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.broker, func(t *testing.T) {
+			t.Setenv("MQTT_BROKER", tt.broker)
+			cfg, _, err := loadMQTTConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadMQTTConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (cfg.Addr != tt.wantAddr || cfg.TLS != tt.wantTLS || cfg.Topic != defaultMQTTTopic) {
+				t.Errorf("unexpected config %+v", cfg)
+			}
+		})
+	}
+}
+
+func Test_appendMQTTPacket_RemainingLength(t *testing.T) {
+	packet := appendMQTTPacket(nil, mqttPublish, make([]byte, 321))
+	// 321 = 0b10_1000001: 0x41 with the continuation bit, then 0x02
+	if packet[1] != 0xC1 || packet[2] != 0x02 || len(packet) != 3+321 {
+		t.Errorf("unexpected fixed header % x", packet[:3])
+	}
+}
+
+func Test_mqttPublisher(t *testing.T) {
+	broker := newFakeMQTTBroker(t)
+	store := &atomicCountStore{}
+	store.count.Store(3)
+
+	publisher := newMQTTPublisher(store, mqttConfig{
+		Addr:         broker.ln.Addr().String(),
+		Topic:        defaultMQTTTopic,
+		ClientID:     "desk-display",
+		Username:     "resume",
+		Password:     "secret",
+		PollInterval: time.Hour,
+	})
+	notifying := newProcessingStore(store, []VisitProcessor{publisher}, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		publisher.Run(ctx)
+		close(done)
+	}()
+
+	// The current count is published on connect, then again on each visit
+	broker.expect(t, "3")
+	if err := notifying.IncrementVisitCount(ctx, Visit{}); err != nil {
+		t.Fatalf("IncrementVisitCount() error = %v", err)
+	}
+	broker.expect(t, "4")
+
+	// Unchanged counts aren't republished
+	publisher.Notify()
+	select {
+	case got := <-broker.received:
+		t.Errorf("expected no publish for an unchanged count; got %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	<-done
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.clientID != "desk-display" || broker.username != "resume" {
+		t.Errorf("unexpected credentials %q, %q", broker.clientID, broker.username)
+	}
+}
+
+func Test_mqttPublisher_Reconnect(t *testing.T) {
+	broker := newFakeMQTTBroker(t)
+	broker.refuse.Store(1)
+	store := &atomicCountStore{}
+	store.count.Store(7)
+
+	publisher := newMQTTPublisher(store, mqttConfig{
+		Addr:         broker.ln.Addr().String(),
+		Topic:        defaultMQTTTopic,
+		ClientID:     defaultMQTTClientID,
+		PollInterval: time.Hour,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.Run(ctx)
+
+	broker.expect(t, "7")
+	if n := broker.connects.Load(); n != 2 {
+		t.Errorf("expected a refused connection and a retry; got %d connects", n)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	onlinePath = "/api/online"
+
+	// onlineWindow is how recent a session's last heartbeat must be for its visitor to count
+	// as online
+	onlineWindow = time.Minute
+
+	// onlineRefreshInterval is how long a replica reuses the count before asking the store again
+	onlineRefreshInterval = 5 * time.Second
+)
+
+// onlineCounter counts the visitors online from the heartbeats of every replica, which all
+// land in the shared sessions table. The count is kept in memory between refreshes, so a
+// "currently viewing" indicator polled by every open page costs the store one query per
+// replica every onlineRefreshInterval rather than one per page.
+type onlineCounter struct {
+	store DataStore
+	clock Clock
+
+	mu        sync.Mutex
+	count     int
+	countedAt time.Time
+}
+
+func newOnlineCounter(store DataStore, clock Clock) *onlineCounter {
+	return &onlineCounter{store: store, clock: clock}
+}
+
+// Count returns the number of sessions with a heartbeat within onlineWindow. Callers
+// arriving while the count is refreshed wait for it rather than query the store themselves.
+func (c *onlineCounter) Count(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if !c.countedAt.IsZero() && now.Sub(c.countedAt) < onlineRefreshInterval {
+		return c.count, nil
+	}
+	count, err := c.store.CountActiveSessions(ctx, now.Add(-onlineWindow))
+	if err != nil {
+		return 0, err
+	}
+	c.count, c.countedAt = count, now
+	return count, nil
+}
+
+// onlineResponse is the body returned by GET /api/online.
+type onlineResponse struct {
+	Online        int `json:"online"`
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// onlineHandler reports the number of visitors currently on the site, those whose session
+// sent a heartbeat within the last minute.
+func onlineHandler(w http.ResponseWriter, r *http.Request, counter *onlineCounter) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	count, err := counter.Count(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to count visitors online: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, r, onlineResponse{Online: count, WindowSeconds: int(onlineWindow / time.Second)})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// onlineCountingStore counts the times it is asked for the active sessions
+type onlineCountingStore struct {
+	MockDataStore
+	queries int
+}
+
+func (s *onlineCountingStore) CountActiveSessions(ctx context.Context, since time.Time) (int, error) {
+	s.queries++
+	return s.MockDataStore.CountActiveSessions(ctx, since)
+}
+
+func Test_onlineCounter(t *testing.T) {
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	clock := newFakeClock(now)
+	store := &onlineCountingStore{MockDataStore: MockDataStore{sessions: map[string]time.Time{
+		"a": now.Add(-10 * time.Second),
+		"b": now.Add(-onlineWindow), // on the edge of the window
+		"c": now.Add(-5 * time.Minute),
+	}}}
+	counter := newOnlineCounter(store, clock)
+
+	if count, err := counter.Count(context.Background()); err != nil || count != 2 {
+		t.Fatalf("Count() = %d, %v; want 2", count, err)
+	}
+
+	// Within the refresh interval the count comes from memory, even as sessions change
+	store.sessions["d"] = now
+	clock.Advance(onlineRefreshInterval - time.Second)
+	if count, _ := counter.Count(context.Background()); count != 2 || store.queries != 1 {
+		t.Errorf("expected the cached count of 2 after one query, got %d after %d", count, store.queries)
+	}
+
+	clock.Advance(time.Second)
+	if count, _ := counter.Count(context.Background()); count != 2 || store.queries != 2 {
+		t.Errorf("expected a refreshed count of 2, with b gone and d new, got %d after %d queries", count, store.queries)
+	}
+
+	// A failed count isn't cached
+	failing := newOnlineCounter(failingStore{}, clock)
+	if _, err := failing.Count(context.Background()); err == nil {
+		t.Error("expected the store error returned")
+	}
+	if !failing.countedAt.IsZero() {
+		t.Error("expected no count cached after an error")
+	}
+}
+
+func Test_onlineHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	mockDataStore := &MockDataStore{sessions: map[string]time.Time{"a": clock.Now(), "b": clock.Now().Add(-time.Hour)}}
+
+	rr := httptest.NewRecorder()
+	onlineHandler(rr, httptest.NewRequest(http.MethodGet, onlinePath, nil), newOnlineCounter(mockDataStore, clock))
+	var resp onlineResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if resp != (onlineResponse{Online: 1, WindowSeconds: 60}) {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	for _, tt := range []struct {
+		method string
+		store  DataStore
+		status int
+	}{
+		{http.MethodPost, mockDataStore, http.StatusMethodNotAllowed},
+		{http.MethodGet, failingStore{}, http.StatusInternalServerError},
+	} {
+		rr := httptest.NewRecorder()
+		onlineHandler(rr, httptest.NewRequest(tt.method, onlinePath, nil), newOnlineCounter(tt.store, clock))
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.method, tt.status, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+const openAPIPath = "/api/openapi.json"
+
+// OpenAPI document describing every public route; kept honest by openapi_test.go
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIHandler serves the embedded OpenAPI document.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
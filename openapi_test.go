@@ -0,0 +1,762 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Minimal OpenAPI 3 model covering what the contract tests check
+type openAPIDoc struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas   map[string]openAPISchema   `json:"schemas"`
+		Responses map[string]openAPIResponse `json:"responses"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string                      `json:"$ref"`
+	Content map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref        string                   `json:"$ref"`
+	Type       string                   `json:"type"`
+	Nullable   bool                     `json:"nullable"`
+	Required   []string                 `json:"required"`
+	Properties map[string]openAPISchema `json:"properties"`
+	Items      *openAPISchema           `json:"items"`
+}
+
+func (d *openAPIDoc) response(r openAPIResponse) openAPIResponse {
+	if name, ok := strings.CutPrefix(r.Ref, "#/components/responses/"); ok {
+		return d.Components.Responses[name]
+	}
+	return r
+}
+
+func (d *openAPIDoc) schema(s openAPISchema) openAPISchema {
+	if name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/"); ok {
+		resolved := d.Components.Schemas[name]
+		resolved.Nullable = resolved.Nullable || s.Nullable // a nullable reference
+		return resolved
+	}
+	return s
+}
+
+// pathTemplate returns the documented path that matches path, treating {param} segments as wildcards.
+func (d *openAPIDoc) pathTemplate(path string) string {
+	if _, ok := d.Paths[path]; ok {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for template := range d.Paths {
+		parts := strings.Split(template, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		match := true
+		for i, part := range parts {
+			if part != segments[i] && !(strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}")) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return template
+		}
+	}
+	return path
+}
+
+// validate checks a decoded JSON value against a schema.
+func (d *openAPIDoc) validate(s openAPISchema, v interface{}, path string) error {
+	s = d.schema(s)
+	if v == nil && s.Nullable {
+		return nil
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", path, v)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, value := range obj {
+			if prop, ok := s.Properties[name]; ok {
+				if err := d.validate(prop, value, path+"."+name); err != nil {
+					return err
+				}
+			} else if len(s.Properties) > 0 {
+				return fmt.Errorf("%s: undocumented property %q", path, name)
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", path, v)
+		}
+		for i, item := range arr {
+			if err := d.validate(*s.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: expected integer, got %v", path, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: expected number, got %T", path, v)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: expected string, got %T", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", path, v)
+		}
+	}
+	return nil
+}
+
+// failingStore is a DataStore whose every call fails.
+type failingStore struct{}
+
+func (failingStore) IncrementVisitCount(ctx context.Context, visit Visit) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) IncrementVisitCounts(ctx context.Context, visits []Visit) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetVisitCount(ctx context.Context) (int, error) {
+	return 0, errors.New("database unavailable")
+}
+
+func (failingStore) GetVisitCountBetween(ctx context.Context, since, until time.Time) (int, error) {
+	return 0, errors.New("database unavailable")
+}
+
+func (failingStore) GetVisitCountsInRanges(ctx context.Context, ranges []TimeRange) ([]int, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetPageCounts(ctx context.Context, pages []string) (map[string]int, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetSummary(ctx context.Context, from, to time.Time, loc *time.Location) (Summary, error) {
+	return Summary{}, errors.New("database unavailable")
+}
+
+func (failingStore) GetVisitHeatmap(ctx context.Context, from, to time.Time, loc *time.Location) ([]HeatmapCell, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) RecordUniqueVisitor(ctx context.Context, day time.Time, visitorHash string) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetUniqueVisitorCount(ctx context.Context, from, to time.Time) (int, error) {
+	return 0, errors.New("database unavailable")
+}
+
+func (failingStore) SaveVisitorSketch(ctx context.Context, day time.Time, sketch []byte) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetVisitorSketches(ctx context.Context, from, to time.Time) ([][]byte, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetCampaignVisits(ctx context.Context, from, to time.Time, limit int) ([]CampaignCount, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) RecordExposure(ctx context.Context, exposure Exposure) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetExperimentResults(ctx context.Context, experiment string, from, to time.Time) ([]VariantResult, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) StartSession(ctx context.Context, id string, now time.Time) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) TouchSession(ctx context.Context, id string, now, idleSince time.Time) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func (failingStore) CountActiveSessions(ctx context.Context, since time.Time) (int, error) {
+	return 0, errors.New("database unavailable")
+}
+
+func (failingStore) AggregateSessions(ctx context.Context, since, closedBefore time.Time) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetSessionStats(ctx context.Context, from, to time.Time) ([]SessionDay, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) RecordEvent(ctx context.Context, event Event) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetEventStats(ctx context.Context, query EventStatsQuery) ([]EventCount, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetFunnel(ctx context.Context, from, to time.Time, steps []string) ([]int, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetHourlyVisits(ctx context.Context, from, to time.Time) ([]HourlyCount, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) RecordAnomaly(ctx context.Context, anomaly Anomaly) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func (failingStore) GetAnomalies(ctx context.Context, from, to time.Time) ([]Anomaly, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetVisitsAfter(ctx context.Context, afterID int64, before time.Time, limit int) ([]VisitRow, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) StreamVisits(ctx context.Context, afterID int64, before time.Time, limit int, fn func(VisitRow) error) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetExportMark(ctx context.Context, sink string) (int64, error) {
+	return 0, errors.New("database unavailable")
+}
+
+func (failingStore) SetExportMark(ctx context.Context, sink string, lastID int64) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) IncrementVisitCountsWithOutbox(ctx context.Context, visits []Visit, messages []OutboxMessage) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) EnqueueOutbox(ctx context.Context, messages []OutboxMessage) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetFailedOutbox(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) RetryOutbox(ctx context.Context, id int64) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func (failingStore) RunMaintenance(ctx context.Context, action MaintenanceAction, progress func(step string, elapsed time.Duration)) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) ClaimOutbox(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxMessage, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) CompleteOutbox(ctx context.Context, id int64) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) FailOutbox(ctx context.Context, id int64, retryAt *time.Time, lastError string) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func (failingStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) ClaimDedupeKey(ctx context.Context, key string, window time.Duration) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func (failingStore) DeleteExpiredDedupeKeys(ctx context.Context) (int, error) {
+	return 0, errors.New("database unavailable")
+}
+
+func (failingStore) EnsureVisitPartitions(ctx context.Context, now time.Time, ahead int) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) DropVisitPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetVisitorData(ctx context.Context, ids VisitorIDs) (VisitorData, error) {
+	return VisitorData{}, errors.New("database unavailable")
+}
+
+func (failingStore) RefreshSummaries(ctx context.Context) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetSummarizedDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetSummarizedTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) ListProjects(ctx context.Context) ([]Project, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetProject(ctx context.Context, id int64) (Project, bool, error) {
+	return Project{}, false, errors.New("database unavailable")
+}
+
+func (failingStore) CreateProject(ctx context.Context, p Project) (Project, error) {
+	return Project{}, errors.New("database unavailable")
+}
+
+func (failingStore) UpdateProject(ctx context.Context, p Project) (Project, bool, error) {
+	return Project{}, false, errors.New("database unavailable")
+}
+
+func (failingStore) DeleteProject(ctx context.Context, id int64) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func (failingStore) GetPublishedResume(ctx context.Context) (ResumeVersion, bool, error) {
+	return ResumeVersion{}, false, errors.New("database unavailable")
+}
+
+func (failingStore) GetResumeDraft(ctx context.Context) (ResumeVersion, bool, error) {
+	return ResumeVersion{}, false, errors.New("database unavailable")
+}
+
+func (failingStore) SaveResumeDraft(ctx context.Context, content json.RawMessage) (ResumeVersion, error) {
+	return ResumeVersion{}, errors.New("database unavailable")
+}
+
+func (failingStore) PublishResumeVersion(ctx context.Context, id int64) (ResumeVersion, bool, error) {
+	return ResumeVersion{}, false, errors.New("database unavailable")
+}
+
+func (failingStore) ListResumeVersions(ctx context.Context, limit int) ([]ResumeVersion, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) ListPosts(ctx context.Context, publishedBy *time.Time, limit, offset int) ([]Post, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetPost(ctx context.Context, slug string) (Post, bool, error) {
+	return Post{}, false, errors.New("database unavailable")
+}
+
+func (failingStore) SavePost(ctx context.Context, p Post) (Post, bool, error) {
+	return Post{}, false, errors.New("database unavailable")
+}
+
+func (failingStore) DeletePost(ctx context.Context, slug string) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func (failingStore) ListShortLinks(ctx context.Context) ([]ShortLink, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) GetShortLink(ctx context.Context, slug string) (ShortLink, bool, error) {
+	return ShortLink{}, false, errors.New("database unavailable")
+}
+
+func (failingStore) SaveShortLink(ctx context.Context, l ShortLink) (ShortLink, bool, error) {
+	return ShortLink{}, false, errors.New("database unavailable")
+}
+
+func (failingStore) DeleteShortLink(ctx context.Context, slug string) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func (failingStore) RecordUptimeCheck(ctx context.Context, check UptimeCheck) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetUptimeChecks(ctx context.Context, from, to time.Time) ([]UptimeCheck, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) SaveCertificateCheck(ctx context.Context, check CertificateCheck) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) GetCertificateChecks(ctx context.Context) ([]CertificateCheck, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) RecordDeploy(ctx context.Context, deploy Deploy) (Deploy, error) {
+	return Deploy{}, errors.New("database unavailable")
+}
+
+func (failingStore) GetDeploys(ctx context.Context, from, to time.Time) ([]Deploy, error) {
+	return nil, errors.New("database unavailable")
+}
+
+func (failingStore) DeleteVisitorData(ctx context.Context, ids VisitorIDs) (int, error) {
+	return 0, errors.New("database unavailable")
+}
+
+func (failingStore) Ping(ctx context.Context) error {
+	return errors.New("database unavailable")
+}
+
+func (failingStore) Close() {}
+
+func loadOpenAPIDoc(t *testing.T) *openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("could not parse OpenAPI document: %v", err)
+	}
+	return &doc
+}
+
+func Test_openAPIContract(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "http://allowed.com")
+	t.Setenv("EXPERIMENTS", "layout:control,compact")
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	t.Setenv("BLOG_URL", "https://example.com/blog")
+	useFakeMetrics(t)
+	doc := loadOpenAPIDoc(t)
+
+	stores := map[string]DataStore{
+		"healthy": &MockDataStore{visitCount: 7},
+		"failing": failingStore{},
+	}
+
+	tests := []struct {
+		store  string
+		method string
+		path   string
+		body   string
+	}{
+		{"healthy", http.MethodGet, apiPath, ""},
+		{"healthy", http.MethodPost, apiPath, ""},
+		{"failing", http.MethodGet, apiPath, ""},
+		{"failing", http.MethodPost, apiPath, ""},
+		{"healthy", http.MethodPost, apiPath, `{"page":"Not a page"}`},
+		{"healthy", http.MethodPost, apiPath, `{"consent":"anonymous","page":"blog"}`},
+		{"healthy", http.MethodPost, batchPath, `[{"page":"blog"},{"timestamp":"2000-01-01T00:00:00Z"}]`},
+		{"healthy", http.MethodPost, batchPath, `[]`},
+		{"failing", http.MethodPost, batchPath, `[{}]`},
+		{"healthy", http.MethodGet, pollPath + "?since=0", ""},
+		{"healthy", http.MethodGet, pollPath + "?since=-1", ""},
+		{"failing", http.MethodGet, pollPath + "?since=0", ""},
+		{"healthy", http.MethodGet, countsPath + "?pages=home,blog", ""},
+		{"healthy", http.MethodGet, countsPath, ""},
+		{"failing", http.MethodGet, countsPath + "?pages=home", ""},
+		{"healthy", http.MethodGet, statsPath + "?days=7&tz=Europe/Berlin", ""},
+		{"healthy", http.MethodGet, statsPath + "?refresh=true", ""},
+		{"healthy", http.MethodGet, statsPath + "?refresh=maybe", ""},
+		{"healthy", http.MethodGet, statsPath + "?tz=Not/AZone", ""},
+		{"failing", http.MethodGet, statsPath, ""},
+		{"healthy", http.MethodGet, compareStatsPath + "?tz=Europe/Berlin", ""},
+		{"healthy", http.MethodGet, compareStatsPath + "?tz=Not/AZone", ""},
+		{"failing", http.MethodGet, compareStatsPath, ""},
+		{"healthy", http.MethodGet, heatmapPath + "?days=90&tz=America/New_York", ""},
+		{"healthy", http.MethodGet, heatmapPath + "?days=0", ""},
+		{"healthy", http.MethodGet, heatmapPath + "?tz=Not/AZone", ""},
+		{"failing", http.MethodGet, heatmapPath, ""},
+		{"healthy", http.MethodGet, summaryPath + "?tz=Europe/Berlin", ""},
+		{"healthy", http.MethodGet, summaryPath + "?tz=Not/AZone", ""},
+		{"failing", http.MethodGet, summaryPath, ""},
+		{"healthy", http.MethodGet, countDeltaPath + "?since=2024-03-03T10:00:00Z", ""},
+		{"healthy", http.MethodGet, countDeltaPath + "?since=yesterday", ""},
+		{"failing", http.MethodGet, countDeltaPath + "?since=2024-03-03T10:00:00Z", ""},
+		{"healthy", http.MethodGet, uniqueCountPath + "?days=7", ""},
+		{"failing", http.MethodGet, uniqueCountPath, ""},
+		{"healthy", http.MethodGet, referrersPath + "?days=7&limit=5", ""},
+		{"healthy", http.MethodGet, referrersPath + "?limit=0", ""},
+		{"failing", http.MethodGet, referrersPath, ""},
+		{"healthy", http.MethodGet, campaignsPath + "?days=7", ""},
+		{"healthy", http.MethodGet, campaignsPath + "?days=abc", ""},
+		{"failing", http.MethodGet, campaignsPath, ""},
+		{"healthy", http.MethodGet, experimentPath + "layout", ""},
+		{"healthy", http.MethodGet, experimentPath + "unknown", ""},
+		{"failing", http.MethodGet, experimentPath + "layout", ""},
+		{"healthy", http.MethodGet, experimentPath + "layout/results?days=7", ""},
+		{"healthy", http.MethodGet, experimentPath + "unknown/results", ""},
+		{"failing", http.MethodGet, experimentPath + "layout/results", ""},
+		{"healthy", http.MethodPost, sessionHeartbeatPath, ""},
+		{"failing", http.MethodPost, sessionHeartbeatPath, ""},
+		{"healthy", http.MethodGet, sessionStatsPath + "?days=7", ""},
+		{"healthy", http.MethodGet, sessionStatsPath + "?days=-1", ""},
+		{"failing", http.MethodGet, sessionStatsPath, ""},
+		{"healthy", http.MethodGet, onlinePath, ""},
+		{"failing", http.MethodGet, onlinePath, ""},
+		{"healthy", http.MethodGet, geoSelfPath, ""},
+		{"healthy", http.MethodPost, eventsPath, `{"type": "downloaded_resume", "properties": {"format": "pdf"}}`},
+		{"healthy", http.MethodPost, eventsPath, `{"type": "Not Valid"}`},
+		{"failing", http.MethodPost, eventsPath, `{"type": "clicked_github"}`},
+		{"healthy", http.MethodGet, eventStatsPath + "?type=expanded_project&property=project", ""},
+		{"healthy", http.MethodGet, eventStatsPath + "?property=project", ""},
+		{"failing", http.MethodGet, eventStatsPath, ""},
+		{"healthy", http.MethodGet, funnelPath + "?steps=view,downloaded_resume,contact", ""},
+		{"healthy", http.MethodGet, funnelPath + "?steps=view", ""},
+		{"failing", http.MethodGet, funnelPath + "?steps=view,contact", ""},
+		{"healthy", http.MethodGet, anomaliesPath + "?days=1", ""},
+		{"healthy", http.MethodGet, anomaliesPath + "?days=x", ""},
+		{"failing", http.MethodGet, anomaliesPath, ""},
+		{"healthy", http.MethodGet, uptimePath, ""},
+		{"healthy", http.MethodGet, uptimePath + "?days=91", ""},
+		{"failing", http.MethodGet, uptimePath, ""},
+		{"healthy", http.MethodGet, deploysPath + "?days=7", ""},
+		{"healthy", http.MethodGet, deploysPath + "?days=0", ""},
+		{"failing", http.MethodGet, deploysPath, ""},
+		{"healthy", http.MethodPost, deploysPath, `{"commit_sha": "3f2c1ab", "description": "New projects page"}`},
+		{"healthy", http.MethodPost, deploysPath, `{"commit_sha": "HEAD"}`},
+		{"failing", http.MethodPost, deploysPath, `{"commit_sha": "3f2c1ab"}`},
+		{"healthy", http.MethodGet, deployAnnotationsPath + "?from=1709424000000&to=1709510400000", ""},
+		{"healthy", http.MethodGet, deployAnnotationsPath + "?from=soon", ""},
+		{"failing", http.MethodGet, deployAnnotationsPath, ""},
+		{"healthy", http.MethodGet, metricDocsPath, ""},
+		{"healthy", http.MethodGet, statusPath, ""},
+		{"failing", http.MethodGet, statusPath, ""},
+		{"healthy", http.MethodPost, adminProjectsPath, `{"title": "resume-backend", "tags": ["go"], "links": [{"label": "Source", "url": "https://github.com/me/resume-backend"}]}`},
+		{"healthy", http.MethodPost, adminProjectsPath, `{"title": ""}`},
+		{"failing", http.MethodPost, adminProjectsPath, `{"title": "Blog"}`},
+		{"healthy", http.MethodGet, projectsPath, ""},
+		{"failing", http.MethodGet, projectsPath, ""},
+		{"healthy", http.MethodGet, "/api/projects/1", ""},
+		{"healthy", http.MethodGet, "/api/projects/99", ""},
+		{"healthy", http.MethodGet, "/api/projects/abc", ""},
+		{"failing", http.MethodGet, "/api/projects/1", ""},
+		{"healthy", http.MethodPut, "/api/admin/projects/1", `{"title": "resume-backend", "position": 2}`},
+		{"healthy", http.MethodPut, "/api/admin/projects/99", `{"title": "Blog"}`},
+		{"healthy", http.MethodPut, "/api/admin/projects/1", `{"title": "Blog", "links": [{"label": "Demo", "url": "javascript:alert(1)"}]}`},
+		{"failing", http.MethodPut, "/api/admin/projects/1", `{"title": "Blog"}`},
+		{"healthy", http.MethodDelete, "/api/admin/projects/1", ""},
+		{"healthy", http.MethodDelete, "/api/admin/projects/1", ""},
+		{"failing", http.MethodDelete, "/api/admin/projects/abc", ""},
+		{"failing", http.MethodDelete, "/api/admin/projects/1", ""},
+		{"healthy", http.MethodGet, resumePath, ""},
+		{"healthy", http.MethodGet, resumeDraftPath, ""},
+		{"healthy", http.MethodPut, resumeDraftPath, `{"skills": [{"name": "Go", "level": "expert"}], "experience": [{"company": "Acme", "title": "Engineer", "start": "2021-04", "highlights": ["Shipped the API"]}], "education": [{"institution": "State University", "degree": "BSc", "end": "2018-06"}]}`},
+		{"healthy", http.MethodPut, resumeDraftPath, `{"experience": [{"company": "Acme", "title": "Engineer", "start": "April 2021"}]}`},
+		{"failing", http.MethodGet, resumeDraftPath, ""},
+		{"failing", http.MethodPut, resumeDraftPath, `{"skills": [{"name": "Go"}]}`},
+		{"healthy", http.MethodPost, "/api/admin/resume/versions/1/publish", ""},
+		{"healthy", http.MethodPost, "/api/admin/resume/versions/99/publish", ""},
+		{"healthy", http.MethodPost, "/api/admin/resume/versions/abc/publish", ""},
+		{"failing", http.MethodPost, "/api/admin/resume/versions/1/publish", ""},
+		{"healthy", http.MethodGet, resumePath, ""},
+		{"failing", http.MethodGet, resumePath, ""},
+		{"healthy", http.MethodGet, resumeVersionsPath + "?limit=5", ""},
+		{"healthy", http.MethodGet, resumeVersionsPath + "?limit=0", ""},
+		{"failing", http.MethodGet, resumeVersionsPath, ""},
+		{"healthy", http.MethodPut, "/api/admin/posts/hello-world", `{"title": "Hello", "body": "Hi, *world*.", "tags": ["go"], "published_at": "2024-03-01T09:00:00Z"}`},
+		{"healthy", http.MethodPut, "/api/admin/posts/hello-world", `{"title": "Hello again", "body": "Hi.", "published_at": "2024-03-01T09:00:00Z"}`},
+		{"healthy", http.MethodPut, "/api/admin/posts/Hello", `{"title": "Hello", "body": "Hi."}`},
+		{"failing", http.MethodPut, "/api/admin/posts/hello-world", `{"title": "Hello", "body": "Hi."}`},
+		{"healthy", http.MethodGet, "/api/admin/posts/hello-world", ""},
+		{"healthy", http.MethodGet, "/api/admin/posts/missing", ""},
+		{"failing", http.MethodGet, "/api/admin/posts/hello-world", ""},
+		{"healthy", http.MethodGet, adminPostsPath + "?per_page=5", ""},
+		{"healthy", http.MethodGet, adminPostsPath + "?page=0", ""},
+		{"failing", http.MethodGet, adminPostsPath, ""},
+		{"healthy", http.MethodGet, postsPath + "?page=1&per_page=10", ""},
+		{"healthy", http.MethodGet, postsPath + "?per_page=100", ""},
+		{"failing", http.MethodGet, postsPath, ""},
+		{"healthy", http.MethodGet, "/api/posts/hello-world", ""},
+		{"healthy", http.MethodGet, "/api/posts/missing", ""},
+		{"failing", http.MethodGet, "/api/posts/hello-world", ""},
+		{"healthy", http.MethodGet, feedPath, ""},
+		{"failing", http.MethodGet, feedPath, ""},
+		{"healthy", http.MethodGet, sitemapPath, ""},
+		{"failing", http.MethodGet, sitemapPath, ""},
+		{"healthy", http.MethodGet, robotsPath, ""},
+		{"healthy", http.MethodDelete, "/api/admin/posts/hello-world", ""},
+		{"healthy", http.MethodDelete, "/api/admin/posts/hello-world", ""},
+		{"failing", http.MethodDelete, "/api/admin/posts/hello-world", ""},
+		{"healthy", http.MethodPut, "/api/admin/links/cv", `{"target": "https://example.com/resume.pdf"}`},
+		{"healthy", http.MethodPut, "/api/admin/links/cv", `{"target": "https://example.com/resume-2024.pdf"}`},
+		{"healthy", http.MethodPut, "/api/admin/links/cv", `{"target": "javascript:alert(1)"}`},
+		{"failing", http.MethodPut, "/api/admin/links/cv", `{"target": "https://example.com/resume.pdf"}`},
+		{"healthy", http.MethodGet, "/r/cv", ""},
+		{"healthy", http.MethodGet, "/r/missing", ""},
+		{"failing", http.MethodGet, "/r/cv", ""},
+		{"healthy", http.MethodGet, "/api/admin/links/cv/qr", ""},
+		{"healthy", http.MethodGet, "/api/admin/links/cv/qr?format=svg&scale=4", ""},
+		{"healthy", http.MethodGet, "/api/admin/links/cv/qr?format=gif", ""},
+		{"healthy", http.MethodGet, "/api/admin/links/missing/qr", ""},
+		{"failing", http.MethodGet, "/api/admin/links/cv/qr", ""},
+		{"healthy", http.MethodGet, adminShortLinksPath + "?days=7", ""},
+		{"healthy", http.MethodGet, adminShortLinksPath + "?days=0", ""},
+		{"failing", http.MethodGet, adminShortLinksPath, ""},
+		{"healthy", http.MethodDelete, "/api/admin/links/cv", ""},
+		{"healthy", http.MethodDelete, "/api/admin/links/cv", ""},
+		{"failing", http.MethodDelete, "/api/admin/links/cv", ""},
+		{"healthy", http.MethodGet, csrfPath, ""},
+		{"healthy", http.MethodGet, visitTokenPath, ""},
+		{"healthy", http.MethodPost, privacyExportPath, `{"session_ids": ["0123456789abcdef0123456789abcdef"]}`},
+		{"healthy", http.MethodPost, privacyExportPath, `{"session_ids": ["not-a-session"]}`},
+		{"failing", http.MethodPost, privacyExportPath, ""},
+		{"healthy", http.MethodPost, privacyDeletePath, ""},
+		{"healthy", http.MethodPost, privacyDeletePath, `{"session_ids": 1}`},
+		{"failing", http.MethodPost, privacyDeletePath, ""},
+		{"healthy", http.MethodPost, purgePath, ""},
+		{"healthy", http.MethodGet, failedJobsPath + "?limit=10", ""},
+		{"healthy", http.MethodGet, failedJobsPath + "?limit=0", ""},
+		{"failing", http.MethodGet, failedJobsPath, ""},
+		{"healthy", http.MethodPost, "/api/admin/jobs/7/retry", ""},
+		{"healthy", http.MethodPost, "/api/admin/jobs/abc/retry", ""},
+		{"failing", http.MethodPost, "/api/admin/jobs/7/retry", ""},
+		{"healthy", http.MethodGet, visitsExportPath + "?after_id=1&limit=100", ""},
+		{"healthy", http.MethodGet, visitsExportPath + "?format=json", ""},
+		{"healthy", http.MethodGet, visitsExportPath + "?format=csv", ""},
+		{"failing", http.MethodGet, visitsExportPath, ""},
+		{"healthy", http.MethodPost, maintenancePath, `{"action":"vacuum"}`},
+		{"healthy", http.MethodPost, maintenancePath, `{"action":"defragment"}`},
+		{"failing", http.MethodPost, maintenancePath, `{"action":"migrate"}`},
+		{"healthy", http.MethodGet, openAPIPath, ""},
+		{"healthy", http.MethodGet, "/healthz", ""},
+		{"healthy", http.MethodGet, "/readyz", ""},
+	}
+
+	// Redirects are checked as served rather than followed
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	exercised := map[string]bool{}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s %s", tt.store, tt.method, tt.path), func(t *testing.T) {
+			mux := http.NewServeMux()
+			registerRoutes(mux, stores[tt.store], realClock{})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			path, _, _ := strings.Cut(tt.path, "?")
+			path = doc.pathTemplate(path)
+			op, ok := doc.Paths[path][strings.ToLower(tt.method)]
+			if !ok {
+				t.Fatalf("%s %s is not documented", tt.method, path)
+			}
+			exercised[tt.method+" "+path] = true
+
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer admin-token")
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+
+			documented, ok := op.Responses[strconv.Itoa(res.StatusCode)]
+			if !ok {
+				t.Fatalf("status %d is not documented for %s %s", res.StatusCode, tt.method, tt.path)
+			}
+			documented = doc.response(documented)
+
+			mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatalf("invalid Content-Type %q: %v", res.Header.Get("Content-Type"), err)
+			}
+			content, ok := documented.Content[mediaType]
+			if !ok {
+				t.Fatalf("content type %s is not documented for status %d", mediaType, res.StatusCode)
+			}
+
+			if mediaType == "application/json" {
+				var v interface{}
+				if err := json.Unmarshal(body, &v); err != nil {
+					t.Fatalf("response is not valid JSON: %v", err)
+				}
+				if err := doc.validate(content.Schema, v, "body"); err != nil {
+					t.Errorf("response doesn't match schema: %v", err)
+				}
+			}
+		})
+	}
+
+	// Every documented operation must be covered above
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if key := strings.ToUpper(method) + " " + path; !exercised[key] {
+				t.Errorf("documented operation %s has no contract test", key)
+			}
+		}
+	}
+}
+
+func Test_openAPIContract_Overloaded(t *testing.T) {
+	useFakeMetrics(t)
+	doc := loadOpenAPIDoc(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	handler := loadSheddingMiddleware(blocking, loadShedConfig{MaxConcurrent: 1, RetryAfter: time.Second})
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, apiPath, nil))
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, apiPath, nil))
+
+	documented := doc.response(doc.Paths[apiPath]["get"].Responses[strconv.Itoa(rr.Code)])
+	content, ok := documented.Content[rr.Header().Get("Content-Type")]
+	if !ok {
+		t.Fatalf("status %d with %s is not documented", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &v); err != nil {
+		t.Fatalf("response is not valid JSON: %v", err)
+	}
+	if err := doc.validate(content.Schema, v, "body"); err != nil {
+		t.Errorf("response doesn't match schema: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutboxPollInterval = time.Second
+	defaultOutboxMaxAttempts  = 10
+	defaultOutboxWorkers      = 4
+	outboxBatchSize           = 50
+
+	// outboxLease is how long a claimed message is left to its dispatcher before another may
+	// claim it, longer than any delivery timeout
+	outboxLease = time.Minute
+
+	// Failed deliveries are retried after outboxMinBackoff, doubling up to outboxMaxBackoff
+	outboxMinBackoff = 5 * time.Second
+	outboxMaxBackoff = time.Hour
+)
+
+// outboxConfig controls delivering processor notifications and other jobs through the outbox
+// table.
+type outboxConfig struct {
+	Enabled      bool
+	PollInterval time.Duration
+	MaxAttempts  int // attempts before a message is kept as a dead letter
+	Workers      int // messages delivered concurrently
+}
+
+// loadOutboxConfig reads VISIT_OUTBOX, which enables the outbox when "true", along with
+// OUTBOX_POLL_INTERVAL, OUTBOX_MAX_ATTEMPTS and OUTBOX_WORKERS.
+func loadOutboxConfig() (outboxConfig, error) {
+	cfg := outboxConfig{PollInterval: defaultOutboxPollInterval, MaxAttempts: defaultOutboxMaxAttempts, Workers: defaultOutboxWorkers}
+	if v := os.Getenv("VISIT_OUTBOX"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return outboxConfig{}, fmt.Errorf("invalid VISIT_OUTBOX %q: must be true or false", v)
+		}
+		cfg.Enabled = enabled
+	}
+	if v := os.Getenv("OUTBOX_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return outboxConfig{}, fmt.Errorf("invalid OUTBOX_POLL_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.PollInterval = d
+	}
+	if v := os.Getenv("OUTBOX_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return outboxConfig{}, fmt.Errorf("invalid OUTBOX_MAX_ATTEMPTS %q: must be a positive number", v)
+		}
+		cfg.MaxAttempts = n
+	}
+	if v := os.Getenv("OUTBOX_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return outboxConfig{}, fmt.Errorf("invalid OUTBOX_WORKERS %q: must be a positive number", v)
+		}
+		cfg.Workers = n
+	}
+	return cfg, nil
+}
+
+// outboxHandler delivers the payloads queued for one destination.
+type outboxHandler interface {
+	Deliver(ctx context.Context, payload json.RawMessage) error
+}
+
+// outboxDispatcher delivers the messages queued in the outbox, by processingStore for visits
+// and through EnqueueOutbox for other jobs. A message is only removed once delivered, so
+// deliveries happen at least once, even across crashes.
+type outboxDispatcher struct {
+	store    DataStore
+	handlers map[string]outboxHandler
+	cfg      outboxConfig
+	clock    Clock
+}
+
+// newOutboxDispatcher delivers messages to the OutboxProcessors among processors.
+func newOutboxDispatcher(store DataStore, processors []VisitProcessor, cfg outboxConfig, clock Clock) *outboxDispatcher {
+	d := &outboxDispatcher{store: store, handlers: make(map[string]outboxHandler), cfg: cfg, clock: clock}
+	for _, p := range processors {
+		if op, ok := p.(OutboxProcessor); ok {
+			d.Handle(p.Name(), op)
+		}
+	}
+	return d
+}
+
+// Handle delivers the messages queued for destination with h.
+func (d *outboxDispatcher) Handle(destination string, h outboxHandler) {
+	d.handlers[destination] = h
+}
+
+// Dispatch delivers the messages that are due, a batch at a time until none are left,
+// returning the number delivered.
+func (d *outboxDispatcher) Dispatch(ctx context.Context) (int, error) {
+	delivered := 0
+	for {
+		// Stop between batches on shutdown; unfinished messages are claimed again after the lease
+		if err := ctx.Err(); err != nil {
+			return delivered, err
+		}
+		now := d.clock.Now()
+		messages, err := d.store.ClaimOutbox(ctx, now, now.Add(outboxLease), outboxBatchSize)
+		if err != nil {
+			return delivered, err
+		}
+		delivered += d.deliverAll(ctx, messages)
+		if len(messages) < outboxBatchSize {
+			return delivered, nil
+		}
+	}
+}
+
+// deliverAll delivers messages with up to cfg.Workers at a time, returning the number delivered.
+func (d *outboxDispatcher) deliverAll(ctx context.Context, messages []OutboxMessage) int {
+	queue := make(chan OutboxMessage)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	delivered := 0
+	for range min(max(d.cfg.Workers, 1), len(messages)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range queue {
+				if d.deliver(ctx, m) {
+					mu.Lock()
+					delivered++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, m := range messages {
+		queue <- m
+	}
+	close(queue)
+	wg.Wait()
+	return delivered
+}
+
+// deliver hands a claimed message to its processor, then completes it or schedules a retry.
+func (d *outboxDispatcher) deliver(ctx context.Context, m OutboxMessage) bool {
+	var err error
+	if h, ok := d.handlers[m.Destination]; ok {
+		err = h.Deliver(ctx, m.Payload)
+	} else {
+		// The destination may be disabled on this replica; leave the message to one that has it
+		err = fmt.Errorf("outbox destination %s is not enabled", m.Destination)
+	}
+	if err == nil {
+		// A failure here redelivers the message once the lease runs out
+		_ = d.store.CompleteOutbox(ctx, m.ID)
+		appMetrics.OutboxDelivered(m.Destination, "delivered")
+		return true
+	}
+
+	var retryAt *time.Time
+	if m.Attempts < d.cfg.MaxAttempts {
+		next := d.clock.Now().Add(outboxBackoff(m.Attempts))
+		retryAt = &next
+		errorLogger.Printf("Error delivering outbox message %d to %s (attempt %d): %v", m.ID, m.Destination, m.Attempts, err)
+		appMetrics.OutboxDelivered(m.Destination, "retried")
+	} else {
+		errorLogger.Printf("Giving up on outbox message %d to %s after %d attempts: %v", m.ID, m.Destination, m.Attempts, err)
+		appMetrics.OutboxDelivered(m.Destination, "dead_lettered")
+		crashReports.CaptureError("outbox", fmt.Errorf("gave up on outbox message %d to %s after %d attempts: %w", m.ID, m.Destination, m.Attempts, err))
+	}
+	_ = d.store.FailOutbox(ctx, m.ID, retryAt, err.Error())
+	return false
+}
+
+// outboxBackoff returns the delay before retrying a message that failed its nth attempt.
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxMinBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
+
+// Run dispatches due messages every poll interval until ctx is done.
+func (d *outboxDispatcher) Run(ctx context.Context) {
+	names := make([]string, 0, len(d.handlers))
+	for name := range d.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	log.Printf("Outbox enabled: delivering to %s with %d workers", strings.Join(names, ", "), d.cfg.Workers)
+
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Failures are logged by the store and retried on the next tick
+			_, _ = d.Dispatch(ctx)
+		}
+	}
+}
+
+// outboxNotifier queues alerts in the outbox, for the dispatcher to post with retries.
+type outboxNotifier struct {
+	store       DataStore
+	destination string
+}
+
+// Notify queues text along with details, encoded as webhookNotifier would post them.
+func (n *outboxNotifier) Notify(ctx context.Context, text string, details interface{}) error {
+	body, err := webhookPayload(text, details)
+	if err != nil {
+		return err
+	}
+	return n.store.EnqueueOutbox(ctx, []OutboxMessage{{Destination: n.destination, Payload: body}})
+}
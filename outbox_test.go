@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingOutboxProcessor delivers through the outbox, failing deliveries while err is set
+type recordingOutboxProcessor struct {
+	recordingProcessor
+	mu        sync.Mutex
+	delivered []string
+	err       error
+}
+
+func (p *recordingOutboxProcessor) OutboxPayload(visit Visit) (json.RawMessage, error) {
+	return json.Marshal(map[string]string{"referrer": visit.Referrer})
+}
+
+func (p *recordingOutboxProcessor) Deliver(ctx context.Context, payload json.RawMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.delivered = append(p.delivered, string(payload))
+	return nil
+}
+
+// outboxStore keeps outbox messages in memory
+type outboxStore struct {
+	MockDataStore
+	mu        sync.Mutex
+	messages  []OutboxMessage
+	due       map[int64]*time.Time
+	lastError map[int64]string
+}
+
+func newOutboxStore(messages ...OutboxMessage) *outboxStore {
+	s := &outboxStore{due: make(map[int64]*time.Time), lastError: make(map[int64]string)}
+	for i, m := range messages {
+		m.ID = int64(i + 1)
+		s.messages = append(s.messages, m)
+		s.due[m.ID] = &time.Time{}
+	}
+	return s
+}
+
+func (s *outboxStore) ClaimOutbox(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed []OutboxMessage
+	for i := range s.messages {
+		m := &s.messages[i]
+		if due := s.due[m.ID]; due == nil || due.After(now) || len(claimed) == limit {
+			continue
+		}
+		m.Attempts++
+		s.due[m.ID] = &leaseUntil
+		claimed = append(claimed, *m)
+	}
+	return claimed, nil
+}
+
+func (s *outboxStore) CompleteOutbox(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, m := range s.messages {
+		if m.ID == id {
+			s.messages = append(s.messages[:i], s.messages[i+1:]...)
+			delete(s.due, id)
+		}
+	}
+	return nil
+}
+
+func (s *outboxStore) FailOutbox(ctx context.Context, id int64, retryAt *time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.due[id] = retryAt
+	s.lastError[id] = lastError
+	return nil
+}
+
+func Test_processingStore_Outbox(t *testing.T) {
+	plain := &recordingProcessor{name: "mqtt"}
+	webhook := &recordingOutboxProcessor{recordingProcessor: recordingProcessor{name: "webhook"}}
+	mockDataStore := &MockDataStore{}
+	s := newProcessingStore(mockDataStore, []VisitProcessor{plain, webhook}, true)
+
+	visit := Visit{Timestamp: time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC), Referrer: "github.com"}
+	if err := s.IncrementVisitCount(context.Background(), visit); err != nil {
+		t.Fatalf("IncrementVisitCount() error = %v", err)
+	}
+	if err := s.IncrementVisitCounts(context.Background(), []Visit{visit, visit}); err != nil {
+		t.Fatalf("IncrementVisitCounts() error = %v", err)
+	}
+
+	if mockDataStore.visitCount != 3 || len(mockDataStore.outbox) != 3 {
+		t.Fatalf("expected 3 visits stored with 3 outbox messages; got %d and %+v", mockDataStore.visitCount, mockDataStore.outbox)
+	}
+	if m := mockDataStore.outbox[0]; m.Destination != "webhook" || string(m.Payload) != `{"referrer":"github.com"}` {
+		t.Errorf("unexpected outbox message %+v", m)
+	}
+	if len(plain.visits) != 3 || len(webhook.visits) != 0 {
+		t.Errorf("expected only the processor without an outbox to process visits; got %d and %d", len(plain.visits), len(webhook.visits))
+	}
+}
+
+func Test_processingStore_OutboxDisabled(t *testing.T) {
+	webhook := &recordingOutboxProcessor{recordingProcessor: recordingProcessor{name: "webhook"}}
+	mockDataStore := &MockDataStore{}
+	s := newProcessingStore(mockDataStore, []VisitProcessor{webhook}, false)
+
+	if err := s.IncrementVisitCount(context.Background(), Visit{}); err != nil {
+		t.Fatalf("IncrementVisitCount() error = %v", err)
+	}
+	if len(mockDataStore.outbox) != 0 || len(webhook.visits) != 1 {
+		t.Errorf("expected the visit to be processed directly without the outbox")
+	}
+}
+
+func Test_outboxDispatcher(t *testing.T) {
+	metrics := useFakeMetrics(t)
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	clock := newFakeClock(now)
+	webhook := &recordingOutboxProcessor{recordingProcessor: recordingProcessor{name: "webhook"}}
+	ds := newOutboxStore(
+		OutboxMessage{Destination: "webhook", Payload: json.RawMessage(`{"n":1}`)},
+		OutboxMessage{Destination: "kafka", Payload: json.RawMessage(`{"n":2}`)},
+	)
+	d := newOutboxDispatcher(ds, []VisitProcessor{webhook, &recordingProcessor{name: "mqtt"}}, outboxConfig{MaxAttempts: 2}, clock)
+
+	delivered, err := d.Dispatch(context.Background())
+	if err != nil || delivered != 1 {
+		t.Fatalf("Dispatch() = %d, %v; want 1 delivered", delivered, err)
+	}
+	if len(webhook.delivered) != 1 || webhook.delivered[0] != `{"n":1}` {
+		t.Errorf("expected the webhook message to be delivered; got %q", webhook.delivered)
+	}
+	if len(ds.messages) != 1 || ds.messages[0].ID != 2 {
+		t.Fatalf("expected only the undeliverable message to remain; got %+v", ds.messages)
+	}
+	if due := ds.due[2]; due == nil || !due.Equal(now.Add(outboxMinBackoff)) || ds.lastError[2] == "" {
+		t.Errorf("expected a retry after %s with the error recorded; got %v, %q", outboxMinBackoff, due, ds.lastError[2])
+	}
+
+	// Nothing is due until the backoff passes
+	if delivered, _ := d.Dispatch(context.Background()); delivered != 0 || ds.messages[0].Attempts != 1 {
+		t.Errorf("expected the message to wait for its retry")
+	}
+
+	// The last attempt leaves a dead letter
+	clock.Advance(outboxMinBackoff)
+	if _, err := d.Dispatch(context.Background()); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if ds.messages[0].Attempts != 2 || ds.due[2] != nil {
+		t.Errorf("expected the message to be given up after 2 attempts; got %+v due %v", ds.messages[0], ds.due[2])
+	}
+
+	want := []string{"webhook/delivered", "kafka/retried", "kafka/dead_lettered"}
+	if len(metrics.outbox) != 3 || metrics.outbox[1] != want[1] || metrics.outbox[2] != want[2] {
+		t.Errorf("outbox metrics = %v, want %v", metrics.outbox, want)
+	}
+}
+
+// concurrentHandler counts deliveries and the most it saw running at once
+type concurrentHandler struct {
+	running, peak, delivered atomic.Int32
+}
+
+func (h *concurrentHandler) Deliver(ctx context.Context, payload json.RawMessage) error {
+	n := h.running.Add(1)
+	defer h.running.Add(-1)
+	for {
+		peak := h.peak.Load()
+		if n <= peak || h.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	h.delivered.Add(1)
+	return nil
+}
+
+func Test_outboxDispatcher_Workers(t *testing.T) {
+	useFakeMetrics(t)
+	var messages []OutboxMessage
+	for range 12 {
+		messages = append(messages, OutboxMessage{Destination: anomalyAlertDestination, Payload: json.RawMessage(`{}`)})
+	}
+	ds := newOutboxStore(messages...)
+	d := newOutboxDispatcher(ds, nil, outboxConfig{MaxAttempts: 1, Workers: 3}, realClock{})
+	h := &concurrentHandler{}
+	d.Handle(anomalyAlertDestination, h)
+
+	delivered, err := d.Dispatch(context.Background())
+	if err != nil || delivered != 12 || h.delivered.Load() != 12 || len(ds.messages) != 0 {
+		t.Fatalf("Dispatch() = %d, %v; want all 12 delivered, %d remaining", delivered, err, len(ds.messages))
+	}
+	if peak := h.peak.Load(); peak < 2 || peak > 3 {
+		t.Errorf("expected deliveries to run on up to 3 workers at once; saw %d", peak)
+	}
+}
+
+func Test_outboxDispatcher_StoreError(t *testing.T) {
+	d := newOutboxDispatcher(failingStore{}, nil, outboxConfig{MaxAttempts: 1}, realClock{})
+	if _, err := d.Dispatch(context.Background()); err == nil {
+		t.Error("expected the store error to be returned")
+	}
+}
+
+func Test_outboxDispatcher_DeliveryError(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	webhook := &recordingOutboxProcessor{recordingProcessor: recordingProcessor{name: "webhook"}, err: errors.New("webhook returned status 502")}
+	ds := newOutboxStore(OutboxMessage{Destination: "webhook", Payload: json.RawMessage(`{}`)})
+	d := newOutboxDispatcher(ds, []VisitProcessor{webhook}, outboxConfig{MaxAttempts: 10}, clock)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if delivered, _ := d.Dispatch(context.Background()); delivered != 0 {
+			t.Fatalf("expected attempt %d to fail", attempt)
+		}
+		clock.Advance(outboxBackoff(attempt))
+	}
+	if ds.messages[0].Attempts != 3 || ds.lastError[1] != "webhook returned status 502" {
+		t.Errorf("expected 3 failed attempts; got %+v, %q", ds.messages[0], ds.lastError[1])
+	}
+
+	webhook.err = nil
+	if delivered, _ := d.Dispatch(context.Background()); delivered != 1 || len(ds.messages) != 0 {
+		t.Errorf("expected the message to be delivered once the webhook recovers")
+	}
+}
+
+func Test_outboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{5, 80 * time.Second},
+		{20, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func Test_loadOutboxConfig(t *testing.T) {
+	cfg, err := loadOutboxConfig()
+	if err != nil || cfg.Enabled || cfg.PollInterval != defaultOutboxPollInterval || cfg.MaxAttempts != defaultOutboxMaxAttempts {
+		t.Errorf("unexpected defaults %+v, %v", cfg, err)
+	}
+
+	t.Setenv("VISIT_OUTBOX", "true")
+	t.Setenv("OUTBOX_POLL_INTERVAL", "250ms")
+	t.Setenv("OUTBOX_MAX_ATTEMPTS", "3")
+	t.Setenv("OUTBOX_WORKERS", "8")
+	cfg, err = loadOutboxConfig()
+	if err != nil || !cfg.Enabled || cfg.PollInterval != 250*time.Millisecond || cfg.MaxAttempts != 3 || cfg.Workers != 8 {
+		t.Errorf("unexpected config %+v, %v", cfg, err)
+	}
+
+	for env, v := range map[string]string{"VISIT_OUTBOX": "yes please", "OUTBOX_POLL_INTERVAL": "0s", "OUTBOX_MAX_ATTEMPTS": "0", "OUTBOX_WORKERS": "none"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, v)
+			if _, err := loadOutboxConfig(); err == nil {
+				t.Errorf("expected an error for %s=%q", env, v)
+			}
+		})
+	}
+}
+
+func Test_anomalyDetector_queueAlerts(t *testing.T) {
+	mockDataStore := &MockDataStore{}
+	d := newAnomalyDetector(mockDataStore, anomalyConfig{WebhookURL: "https://hooks.example.com/alerts"}, realClock{})
+	handler := d.queueAlerts(mockDataStore)
+	if _, ok := handler.(*webhookNotifier); !ok {
+		t.Fatalf("queueAlerts() = %T, want the webhook to deliver queued alerts", handler)
+	}
+
+	if err := d.notifier.Notify(context.Background(), "Visit spike", map[string]int{"visits": 60}); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if len(mockDataStore.outbox) != 1 || mockDataStore.outbox[0].Destination != anomalyAlertDestination ||
+		string(mockDataStore.outbox[0].Payload) != `{"details":{"visits":60},"text":"Visit spike"}` {
+		t.Errorf("expected the alert to be queued; got %+v", mockDataStore.outbox)
+	}
+
+	// Without a webhook there is nothing to queue
+	if handler := newAnomalyDetector(mockDataStore, anomalyConfig{}, realClock{}).queueAlerts(mockDataStore); handler != nil {
+		t.Errorf("queueAlerts() = %T without a webhook, want nil", handler)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	countsPath = "/api/counts"
+
+	// maxCountsPages caps how many pages one GET /api/counts can ask for
+	maxCountsPages = 50
+)
+
+// pagePattern matches the page slugs the frontend counts visits under, such as "blog"
+var pagePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// normalizePage lowercases and trims a page slug, rejecting anything pagePattern doesn't
+// match. An empty page is a visit the frontend didn't attribute to a page.
+func normalizePage(raw string) (string, error) {
+	page := strings.ToLower(strings.TrimSpace(raw))
+	if page != "" && !pagePattern.MatchString(page) {
+		return "", fmt.Errorf("invalid page %q", raw)
+	}
+	return page, nil
+}
+
+// parsePages reads the comma-separated pages parameter, dropping duplicates.
+func parsePages(raw string) ([]string, error) {
+	var pages []string
+	seen := make(map[string]bool)
+	for _, p := range strings.Split(raw, ",") {
+		page, err := normalizePage(p)
+		if err != nil {
+			return nil, err
+		}
+		if page == "" || seen[page] {
+			continue
+		}
+		seen[page] = true
+		pages = append(pages, page)
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("pages is required")
+	}
+	if len(pages) > maxCountsPages {
+		return nil, fmt.Errorf("at most %d pages are allowed", maxCountsPages)
+	}
+	return pages, nil
+}
+
+// countsResponse is the body returned by GET /api/counts.
+type countsResponse struct {
+	Counts map[string]int `json:"counts"`
+}
+
+// countsHandler returns the visit counts of several pages at once, so the frontend can show
+// every counter on a page with one request.
+func countsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pages, err := parsePages(r.URL.Query().Get("pages"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	counts, err := dataStore.GetPageCounts(r.Context(), pages)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get page counts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := countsResponse{Counts: make(map[string]int, len(pages))}
+	for _, page := range pages {
+		response.Counts[page] = counts[page]
+	}
+	writeResponse(w, r, response)
+}
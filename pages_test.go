@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_parsePages(t *testing.T) {
+	tooMany := make([]string, maxCountsPages+1)
+	for i := range tooMany {
+		tooMany[i] = strconv.Itoa(i)
+	}
+
+	tests := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{"home,blog,projects", []string{"home", "blog", "projects"}, false},
+		{" Home , blog,,home", []string{"home", "blog"}, false},
+		{"case-studies_2024", []string{"case-studies_2024"}, false},
+		{"", nil, true},
+		{",,", nil, true},
+		{"home,../etc", nil, true},
+		{"-leading", nil, true},
+		{strings.Repeat("a,", maxCountsPages) + "b", []string{"a", "b"}, false},
+		{strings.Join(tooMany, ","), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parsePages(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePages(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("parsePages(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_countsHandler(t *testing.T) {
+	mockDataStore := &MockDataStore{pageCounts: map[string]int{"home": 12, "blog": 3, "about": 1}}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, countsPath+"?pages=home,blog,projects,home", nil)
+
+	countsHandler(w, req, mockDataStore)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+	if strings.Join(mockDataStore.lastPages, ",") != "home,blog,projects" {
+		t.Errorf("expected one lookup of the distinct pages; got %v", mockDataStore.lastPages)
+	}
+	var resp countsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	want := map[string]int{"home": 12, "blog": 3, "projects": 0}
+	if len(resp.Counts) != len(want) {
+		t.Fatalf("expected counts %v; got %v", want, resp.Counts)
+	}
+	for page, n := range want {
+		if resp.Counts[page] != n {
+			t.Errorf("expected %s to have %d visits; got %d", page, n, resp.Counts[page])
+		}
+	}
+}
+
+func Test_countsHandler_Invalid(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		expectedStatus int
+	}{
+		{"Missing pages", http.MethodGet, countsPath, http.StatusBadRequest},
+		{"Invalid page", http.MethodGet, countsPath + "?pages=home,%3Cscript%3E", http.StatusBadRequest},
+		{"Wrong method", http.MethodPost, countsPath + "?pages=home", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{}
+			w := httptest.NewRecorder()
+			countsHandler(w, httptest.NewRequest(tt.method, tt.target, nil), mockDataStore)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d; got %d", tt.expectedStatus, w.Code)
+			}
+			if mockDataStore.lastPages != nil {
+				t.Error("expected a rejected request not to reach the store")
+			}
+		})
+	}
+}
+
+func Test_incrementVisitCount_Page(t *testing.T) {
+	tests := []struct {
+		body           string
+		expectedStatus int
+		wantPage       string
+	}{
+		{`{"page": "Blog"}`, http.StatusOK, "blog"},
+		{`{}`, http.StatusOK, ""},
+		{`{"page": "blog/post?id=1"}`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			mockDataStore := &MockDataStore{}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, apiPath, strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), trackingConsentKey, &trackingConsent{}))
+
+			incrementVisitCount(w, req, mockDataStore, realClock{})
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %d", tt.expectedStatus, w.Code)
+			}
+			if mockDataStore.lastVisit.Page != tt.wantPage {
+				t.Errorf("expected page %q even without consent; got %q", tt.wantPage, mockDataStore.lastVisit.Page)
+			}
+		})
+	}
+}
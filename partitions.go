@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPartitionInterval is how often partitions are created and pruned; partitions are
+	// made months ahead, so a missed run does no harm
+	defaultPartitionInterval = 6 * time.Hour
+	defaultPartitionsAhead   = 2
+)
+
+type partitionConfig struct {
+	Enabled         bool
+	Ahead           int // months of partitions created ahead of the current one
+	RetentionMonths int // full months of visits kept before the current one; 0 keeps them all
+	Interval        time.Duration
+}
+
+// loadPartitionConfig reads VISITS_PARTITIONING, which partitions the visits table by month
+// when "true", along with VISITS_PARTITIONS_AHEAD and VISITS_RETENTION_MONTHS.
+func loadPartitionConfig() (partitionConfig, error) {
+	cfg := partitionConfig{Ahead: defaultPartitionsAhead, Interval: defaultPartitionInterval}
+	if v := os.Getenv("VISITS_PARTITIONING"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return partitionConfig{}, fmt.Errorf("invalid VISITS_PARTITIONING %q: must be true or false", v)
+		}
+		cfg.Enabled = enabled
+	}
+	if v := os.Getenv("VISITS_PARTITIONS_AHEAD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return partitionConfig{}, fmt.Errorf("invalid VISITS_PARTITIONS_AHEAD %q: must be a positive number", v)
+		}
+		cfg.Ahead = n
+	}
+	if v := os.Getenv("VISITS_RETENTION_MONTHS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return partitionConfig{}, fmt.Errorf("invalid VISITS_RETENTION_MONTHS %q: must be a number of months", v)
+		}
+		if n > 0 && !cfg.Enabled {
+			return partitionConfig{}, fmt.Errorf("VISITS_RETENTION_MONTHS needs VISITS_PARTITIONING")
+		}
+		cfg.RetentionMonths = n
+	}
+	return cfg, nil
+}
+
+// runVisitPartitioner keeps the monthly partitions of visits ahead of the clock and drops
+// those past retention, straight away and then every interval until ctx is done.
+func runVisitPartitioner(ctx context.Context, dataStore DataStore, cfg partitionConfig, clock Clock) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		// Failures are logged by the store and retried on the next tick
+		_ = maintainVisitPartitions(ctx, dataStore, cfg, clock.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// maintainVisitPartitions creates the partitions needed from now on, then drops the ones
+// holding only visits from before the retention period.
+func maintainVisitPartitions(ctx context.Context, dataStore DataStore, cfg partitionConfig, now time.Time) error {
+	if err := dataStore.EnsureVisitPartitions(ctx, now, cfg.Ahead); err != nil {
+		return err
+	}
+	if cfg.RetentionMonths == 0 {
+		return nil
+	}
+	cutoff := retentionCutoff(now, cfg.RetentionMonths)
+	dropped, err := dataStore.DropVisitPartitions(ctx, cutoff)
+	if len(dropped) > 0 {
+		log.Printf("Dropped visits from before %s: %s", cutoff.Format("2006-01"), strings.Join(dropped, ", "))
+	}
+	return err
+}
+
+// retentionCutoff is the start of the oldest month of visits kept with months of retention.
+func retentionCutoff(now time.Time, months int) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month()-time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// partitionRecordingStore records the partitioning it is asked for
+type partitionRecordingStore struct {
+	MockDataStore
+	ensured   chan time.Time
+	ahead     int
+	before    time.Time
+	ensureErr error
+}
+
+func (s *partitionRecordingStore) EnsureVisitPartitions(ctx context.Context, now time.Time, ahead int) error {
+	s.ahead = ahead
+	s.ensured <- now
+	return s.ensureErr
+}
+
+func (s *partitionRecordingStore) DropVisitPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	s.before = before
+	return []string{"visits_y2023m01"}, nil
+}
+
+func Test_maintainVisitPartitions(t *testing.T) {
+	now := time.Date(2024, 3, 31, 22, 0, 0, 0, time.FixedZone("UTC-5", -5*3600)) // April in UTC
+	store := &partitionRecordingStore{ensured: make(chan time.Time, 1)}
+
+	if err := maintainVisitPartitions(context.Background(), store, partitionConfig{Ahead: 2, RetentionMonths: 12}, now); err != nil {
+		t.Fatalf("maintainVisitPartitions() error = %v", err)
+	}
+	if got := <-store.ensured; !got.Equal(now) || store.ahead != 2 {
+		t.Errorf("expected partitions from %s, 2 ahead; got %s, %d", now, got, store.ahead)
+	}
+	if want := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC); !store.before.Equal(want) {
+		t.Errorf("expected visits before %s to be dropped; got %s", want, store.before)
+	}
+
+	// Without retention nothing is dropped
+	store.before = time.Time{}
+	_ = maintainVisitPartitions(context.Background(), store, partitionConfig{Ahead: 2}, now)
+	<-store.ensured
+	if !store.before.IsZero() {
+		t.Errorf("expected nothing to be dropped; got visits before %s", store.before)
+	}
+
+	// Nor when the partitions could not be made
+	store.ensureErr = errors.New("database unavailable")
+	if err := maintainVisitPartitions(context.Background(), store, partitionConfig{Ahead: 2, RetentionMonths: 1}, now); err == nil {
+		t.Error("expected the error to be returned")
+	}
+	<-store.ensured
+	if !store.before.IsZero() {
+		t.Errorf("expected nothing to be dropped; got visits before %s", store.before)
+	}
+}
+
+func Test_runVisitPartitioner(t *testing.T) {
+	store := &partitionRecordingStore{ensured: make(chan time.Time, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runVisitPartitioner(ctx, store, partitionConfig{Ahead: 1, Interval: time.Hour}, newFakeClock(time.Now()))
+
+	// Partitions are made at startup rather than after the first interval
+	select {
+	case <-store.ensured:
+	case <-time.After(time.Second):
+		t.Fatal("expected partitions to be made straight away")
+	}
+}
+
+func Test_loadPartitionConfig(t *testing.T) {
+	cfg, err := loadPartitionConfig()
+	if err != nil || cfg.Enabled || cfg.Ahead != defaultPartitionsAhead || cfg.RetentionMonths != 0 || cfg.Interval != defaultPartitionInterval {
+		t.Errorf("unexpected defaults %+v, %v", cfg, err)
+	}
+
+	t.Setenv("VISITS_PARTITIONING", "true")
+	t.Setenv("VISITS_PARTITIONS_AHEAD", "3")
+	t.Setenv("VISITS_RETENTION_MONTHS", "24")
+	cfg, err = loadPartitionConfig()
+	if err != nil || !cfg.Enabled || cfg.Ahead != 3 || cfg.RetentionMonths != 24 {
+		t.Errorf("unexpected config %+v, %v", cfg, err)
+	}
+
+	for env, v := range map[string]string{"VISITS_PARTITIONING": "monthly", "VISITS_PARTITIONS_AHEAD": "0", "VISITS_RETENTION_MONTHS": "-1"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, v)
+			if _, err := loadPartitionConfig(); err == nil {
+				t.Errorf("expected an error for %s=%q", env, v)
+			}
+		})
+	}
+
+	// Retention drops partitions, so it needs them
+	t.Setenv("VISITS_PARTITIONING", "false")
+	if _, err := loadPartitionConfig(); err == nil {
+		t.Error("expected retention without partitioning to be rejected")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	pollPath = "/api/count/poll"
+
+	// pollTimeout is how long a poll waits for a visit, short of the 30s idle timeout common
+	// to proxies and load balancers
+	pollTimeout = 25 * time.Second
+)
+
+// isLongPoll reports whether r may be held open waiting for a visit. Such requests are idle
+// rather than busy, so they skip load shedding and latency tracking.
+func isLongPoll(r *http.Request) bool {
+	return r.URL.Path == pollPath
+}
+
+// pollHandler is the long-polling fallback for live counters that can't use a push channel.
+// It answers with the visit count once it exceeds since, or with the unchanged count when
+// timeout elapses, after which the client polls again.
+func pollHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, hub *countHub, timeout time.Duration) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	since, err := strconv.Atoi(r.URL.Query().Get("since"))
+	if err != nil || since < 0 {
+		http.Error(w, "since must be a non-negative visit count", http.StatusBadRequest)
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		changed := hub.Changed()
+		count, err := dataStore.GetVisitCount(r.Context())
+		var stale *staleCountError
+		if err != nil && !errors.As(err, &stale) {
+			http.Error(w, fmt.Sprintf("Failed to get visit count: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if count <= since {
+			select {
+			case <-changed:
+				continue
+			case <-r.Context().Done():
+				return
+			case <-timer.C:
+			}
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeVisitCount(w, r, count, err)
+		return
+	}
+}
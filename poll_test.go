@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_countHub(t *testing.T) {
+	hub := newCountHub()
+	first := hub.Changed()
+	if hub.Changed() != first {
+		t.Fatal("expected waiters to share a channel until the next change")
+	}
+
+	hub.Notify()
+	select {
+	case <-first:
+	default:
+		t.Fatal("expected Notify to wake waiters")
+	}
+	select {
+	case <-hub.Changed():
+		t.Fatal("expected a fresh channel after Notify")
+	default:
+	}
+}
+
+func Test_notifyingStore(t *testing.T) {
+	hub := newCountHub()
+	ctx := context.Background()
+
+	changed := hub.Changed()
+	if err := newNotifyingStore(failingStore{}, hub).IncrementVisitCount(ctx, Visit{}); err == nil {
+		t.Fatal("expected the store error to be returned")
+	}
+	if err := newNotifyingStore(&MockDataStore{}, hub).IncrementVisitCounts(ctx, nil); err != nil {
+		t.Fatalf("IncrementVisitCounts() error = %v", err)
+	}
+	select {
+	case <-changed:
+		t.Fatal("expected no notification when no visit was recorded")
+	default:
+	}
+
+	if err := newNotifyingStore(&MockDataStore{}, hub).IncrementVisitCount(ctx, Visit{}); err != nil {
+		t.Fatalf("IncrementVisitCount() error = %v", err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected a recorded visit to notify the hub")
+	}
+}
+
+// pollCount runs pollHandler and decodes the count it answers with.
+func pollCount(t *testing.T, dataStore DataStore, hub *countHub, since string, timeout time.Duration) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	pollHandler(w, httptest.NewRequest(http.MethodGet, pollPath+"?since="+since, nil), dataStore, hub, timeout)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected Cache-Control: no-store; got %q", cc)
+	}
+	var resp map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	return resp["visits"]
+}
+
+func Test_pollHandler_AlreadyChanged(t *testing.T) {
+	if got := pollCount(t, &MockDataStore{visitCount: 7}, newCountHub(), "5", time.Minute); got != 7 {
+		t.Errorf("expected the current count; got %d", got)
+	}
+}
+
+// countingStore is a DataStore whose count can change while a poll reads it.
+type countingStore struct {
+	DataStore
+	visits atomic.Int64
+}
+
+func (s *countingStore) IncrementVisitCount(ctx context.Context, visit Visit) error {
+	s.visits.Add(1)
+	return nil
+}
+
+func (s *countingStore) GetVisitCount(ctx context.Context) (int, error) {
+	return int(s.visits.Load()), nil
+}
+
+func Test_pollHandler_WaitsForVisit(t *testing.T) {
+	hub := newCountHub()
+	ds := newNotifyingStore(&countingStore{}, hub)
+
+	done := make(chan int)
+	go func() {
+		done <- pollCount(t, ds, hub, "0", time.Minute)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := ds.IncrementVisitCount(context.Background(), Visit{}); err != nil {
+		t.Fatalf("IncrementVisitCount() error = %v", err)
+	}
+	select {
+	case got := <-done:
+		if got != 1 {
+			t.Errorf("expected the poll to answer with the new count; got %d", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the visit to wake the poll")
+	}
+}
+
+func Test_pollHandler_Timeout(t *testing.T) {
+	start := time.Now()
+	if got := pollCount(t, &MockDataStore{visitCount: 7}, newCountHub(), "7", 20*time.Millisecond); got != 7 {
+		t.Errorf("expected the unchanged count; got %d", got)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected the poll to wait for the timeout; returned after %s", elapsed)
+	}
+}
+
+func Test_pollHandler_Invalid(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		dataStore      DataStore
+		expectedStatus int
+	}{
+		{"Missing since", http.MethodGet, pollPath, &MockDataStore{}, http.StatusBadRequest},
+		{"Negative since", http.MethodGet, pollPath + "?since=-1", &MockDataStore{}, http.StatusBadRequest},
+		{"Wrong method", http.MethodPost, pollPath + "?since=0", &MockDataStore{}, http.StatusMethodNotAllowed},
+		{"Store error", http.MethodGet, pollPath + "?since=0", failingStore{}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			pollHandler(w, httptest.NewRequest(tt.method, tt.target, nil), tt.dataStore, newCountHub(), time.Minute)
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d; got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func Test_pollHandler_ClientGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, pollPath+"?since=7", nil).WithContext(ctx)
+
+	pollHandler(w, req, &MockDataStore{visitCount: 7}, newCountHub(), time.Minute)
+	if w.Body.Len() != 0 {
+		t.Errorf("expected no response once the client has gone; got %s", w.Body.String())
+	}
+}
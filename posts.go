@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	postsPath      = "/api/posts"
+	postPath       = "/api/posts/{slug}"
+	adminPostsPath = "/api/admin/posts"
+	adminPostPath  = "/api/admin/posts/{slug}"
+	feedPath       = "/feed.xml"
+
+	maxPostBodyBytes     = 512 << 10
+	maxPostSlugLength    = 100
+	maxPostTitleLength   = 200
+	maxPostSummaryLength = 500
+	maxPostLength        = 100000
+	maxPostTags          = 10
+	maxPostTagLength     = 50
+	defaultPostsPerPage  = 10
+	maxPostsPerPage      = 50
+	feedPosts            = 20
+)
+
+// slugPattern matches the lowercase, hyphenated slugs content is addressed by, like "hello-world"
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// blogConfig describes the blog in its feed, from BLOG_TITLE, BLOG_DESCRIPTION and BLOG_URL,
+// the address of the blog's index page on the site; a post's page is its slug under it.
+type blogConfig struct {
+	Title       string
+	Description string
+	URL         string
+}
+
+func loadBlogConfig() blogConfig {
+	cfg := blogConfig{
+		Title:       os.Getenv("BLOG_TITLE"),
+		Description: os.Getenv("BLOG_DESCRIPTION"),
+		URL:         strings.TrimRight(os.Getenv("BLOG_URL"), "/"),
+	}
+	if cfg.Title == "" {
+		cfg.Title = "Blog"
+	}
+	if cfg.URL != "" {
+		if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			log.Printf("Invalid BLOG_URL %q: must be an absolute http or https URL, linking posts to the API", cfg.URL)
+			cfg.URL = ""
+		}
+	}
+	return cfg
+}
+
+// postLink returns the address of the page showing the post with slug. Without BLOG_URL that
+// is the post in the API, on the host the request was made to.
+func (cfg blogConfig) postLink(r *http.Request, slug string) string {
+	if cfg.URL != "" {
+		return cfg.URL + "/" + slug
+	}
+	return requestOrigin(r) + postsPath + "/" + slug
+}
+
+// postRequest is the body of a post save, which replaces every field. Without published_at
+// the post is a draft; a future one schedules it.
+type postRequest struct {
+	Title       string     `json:"title"`
+	Summary     string     `json:"summary"`
+	Body        string     `json:"body"`
+	Tags        []string   `json:"tags"`
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+// postResponse is one post as the API returns it, with its Markdown body rendered as HTML.
+type postResponse struct {
+	Slug        string     `json:"slug"`
+	Title       string     `json:"title"`
+	Summary     string     `json:"summary"`
+	Tags        []string   `json:"tags"`
+	Body        string     `json:"body"`
+	HTML        string     `json:"html"`
+	PublishedAt *time.Time `json:"published_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// postListItem is a post as listings return it, without its body.
+type postListItem struct {
+	Slug        string     `json:"slug"`
+	Title       string     `json:"title"`
+	Summary     string     `json:"summary"`
+	Tags        []string   `json:"tags"`
+	PublishedAt *time.Time `json:"published_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+type postsResponse struct {
+	Posts   []postListItem `json:"posts"`
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+	HasMore bool           `json:"has_more"`
+}
+
+func newPostResponse(p Post) postResponse {
+	resp := postResponse{
+		Slug:        p.Slug,
+		Title:       p.Title,
+		Summary:     p.Summary,
+		Tags:        p.Tags,
+		Body:        p.Body,
+		HTML:        renderMarkdown(p.Body),
+		PublishedAt: p.PublishedAt,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+	}
+	if resp.Tags == nil {
+		resp.Tags = []string{}
+	}
+	return resp
+}
+
+// post validates req and returns it as the post with slug, with its text trimmed and
+// duplicate tags dropped.
+func (req postRequest) post(slug string) (Post, error) {
+	if len(slug) > maxPostSlugLength || !slugPattern.MatchString(slug) {
+		return Post{}, fmt.Errorf("slug must be up to %d lowercase letters, digits and single hyphens", maxPostSlugLength)
+	}
+	p := Post{
+		Slug:        slug,
+		Title:       strings.TrimSpace(req.Title),
+		Summary:     strings.TrimSpace(req.Summary),
+		Body:        strings.TrimSpace(req.Body),
+		PublishedAt: req.PublishedAt,
+	}
+	if p.Title == "" || utf8.RuneCountInString(p.Title) > maxPostTitleLength {
+		return Post{}, fmt.Errorf("title must be 1 to %d characters", maxPostTitleLength)
+	}
+	if utf8.RuneCountInString(p.Summary) > maxPostSummaryLength {
+		return Post{}, fmt.Errorf("summary must be at most %d characters", maxPostSummaryLength)
+	}
+	if p.Body == "" || utf8.RuneCountInString(p.Body) > maxPostLength {
+		return Post{}, fmt.Errorf("body must be 1 to %d characters", maxPostLength)
+	}
+	if p.PublishedAt != nil {
+		published := p.PublishedAt.UTC()
+		p.PublishedAt = &published
+	}
+
+	if len(req.Tags) > maxPostTags {
+		return Post{}, fmt.Errorf("a post can have at most %d tags", maxPostTags)
+	}
+	seen := make(map[string]bool)
+	for _, tag := range req.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > maxPostTagLength {
+			return Post{}, fmt.Errorf("tags must be 1 to %d characters", maxPostTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			p.Tags = append(p.Tags, tag)
+		}
+	}
+	return p, nil
+}
+
+// listPosts answers a listing request with a page of posts, those published by publishedBy or
+// every post when it is nil.
+func listPosts(w http.ResponseWriter, r *http.Request, dataStore DataStore, publishedBy *time.Time) {
+	page, perPage := 1, defaultPostsPerPage
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "page must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		page = n
+	}
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPostsPerPage {
+			http.Error(w, fmt.Sprintf("per_page must be between 1 and %d", maxPostsPerPage), http.StatusBadRequest)
+			return
+		}
+		perPage = n
+	}
+
+	// One post past the page tells whether there is another
+	posts, err := dataStore.ListPosts(r.Context(), publishedBy, perPage+1, (page-1)*perPage)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list posts: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp := postsResponse{Posts: []postListItem{}, Page: page, PerPage: perPage, HasMore: len(posts) > perPage}
+	for _, p := range posts[:min(len(posts), perPage)] {
+		item := postListItem{Slug: p.Slug, Title: p.Title, Summary: p.Summary, Tags: p.Tags, PublishedAt: p.PublishedAt, UpdatedAt: p.UpdatedAt}
+		if item.Tags == nil {
+			item.Tags = []string{}
+		}
+		resp.Posts = append(resp.Posts, item)
+	}
+	writeResponse(w, r, resp)
+}
+
+// postsHandler lists the published posts, newest first.
+func postsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	now := clock.Now().UTC()
+	listPosts(w, r, dataStore, &now)
+}
+
+// postHandler returns a published post; drafts and scheduled posts are not found.
+func postHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, found, err := dataStore.GetPost(r.Context(), r.PathValue("slug"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get post: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found || p.PublishedAt == nil || p.PublishedAt.After(clock.Now()) {
+		http.Error(w, "No post with that slug", http.StatusNotFound)
+		return
+	}
+	writeResponse(w, r, newPostResponse(p))
+}
+
+// adminPostsHandler lists every post, drafts and scheduled posts included.
+func adminPostsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	listPosts(w, r, dataStore, nil)
+}
+
+// adminPostHandler returns a post with GET, creates or replaces it with PUT, and deletes it
+// with DELETE.
+func adminPostHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+	slug := r.PathValue("slug")
+	w.Header().Set("Cache-Control", "no-store")
+
+	switch r.Method {
+	case http.MethodGet:
+		p, found, err := dataStore.GetPost(r.Context(), slug)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get post: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "No post with that slug", http.StatusNotFound)
+			return
+		}
+		writeResponse(w, r, newPostResponse(p))
+
+	case http.MethodDelete:
+		deleted, err := dataStore.DeletePost(r.Context(), slug)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to delete post: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "No post with that slug", http.StatusNotFound)
+			return
+		}
+		writeResponse(w, r, messageResponse{Message: "Post deleted"})
+
+	case http.MethodPut:
+		var req postRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPostBodyBytes))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid post body: %v", err), http.StatusBadRequest)
+			return
+		}
+		p, err := req.post(slug)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		saved, created, err := dataStore.SavePost(r.Context(), p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to save post: %v", err), http.StatusInternalServerError)
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+			w.Header().Set("Location", postsPath+"/"+saved.Slug)
+		}
+		writeResponseStatus(w, r, status, newPostResponse(saved))
+	}
+}
+
+// rssFeed is an RSS 2.0 document.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// feedHandler returns the latest published posts as an RSS feed, each with its full HTML.
+func feedHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock, cfg blogConfig) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := clock.Now().UTC()
+	posts, err := dataStore.ListPosts(r.Context(), &now, feedPosts, 0)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list posts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	feed := rssFeed{Version: "2.0", Channel: rssChannel{Title: cfg.Title, Link: cfg.URL, Description: cfg.Description}}
+	if feed.Channel.Link == "" {
+		feed.Channel.Link = strings.TrimSuffix(cfg.postLink(r, ""), "/")
+	}
+	if feed.Channel.Description == "" {
+		feed.Channel.Description = cfg.Title
+	}
+	for _, p := range posts {
+		link := cfg.postLink(r, p.Slug)
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       p.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     p.PublishedAt.Format(time.RFC1123Z),
+			Description: renderMarkdown(p.Body),
+			Categories:  p.Tags,
+		})
+	}
+	if len(posts) > 0 {
+		feed.Channel.LastBuildDate = posts[0].PublishedAt.Format(time.RFC1123Z)
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode feed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_postRequest_post(t *testing.T) {
+	p, err := postRequest{Title: " Hello ", Body: "Hi.\n", Tags: []string{"go", " go"}}.post("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Slug != "hello-world" || p.Title != "Hello" || p.Body != "Hi." || len(p.Tags) != 1 {
+		t.Errorf("expected the fields trimmed and duplicate tags dropped; got %+v", p)
+	}
+
+	for name, tt := range map[string]struct {
+		slug string
+		req  postRequest
+	}{
+		"uppercase slug": {"Hello", postRequest{Title: "Hello", Body: "Hi."}},
+		"double hyphen":  {"hello--world", postRequest{Title: "Hello", Body: "Hi."}},
+		"long slug":      {strings.Repeat("a", maxPostSlugLength+1), postRequest{Title: "Hello", Body: "Hi."}},
+		"no title":       {"hello", postRequest{Body: "Hi."}},
+		"no body":        {"hello", postRequest{Title: "Hello", Body: " "}},
+		"long summary":   {"hello", postRequest{Title: "Hello", Body: "Hi.", Summary: strings.Repeat("x", maxPostSummaryLength+1)}},
+		"empty tag":      {"hello", postRequest{Title: "Hello", Body: "Hi.", Tags: []string{""}}},
+	} {
+		if _, err := tt.req.post(tt.slug); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func Test_postHandlers(t *testing.T) {
+	t.Setenv("BLOG_TITLE", "Notes")
+	t.Setenv("BLOG_URL", "https://example.com/blog/")
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(now)
+	mockDataStore := &MockDataStore{}
+	cfg := loadBlogConfig()
+	mux := http.NewServeMux()
+	mux.HandleFunc(postsPath, func(w http.ResponseWriter, r *http.Request) {
+		postsHandler(w, r, mockDataStore, clock)
+	})
+	mux.HandleFunc(postPath, func(w http.ResponseWriter, r *http.Request) {
+		postHandler(w, r, mockDataStore, clock)
+	})
+	mux.HandleFunc(adminPostsPath, func(w http.ResponseWriter, r *http.Request) {
+		adminPostsHandler(w, r, mockDataStore, "admin-token")
+	})
+	mux.HandleFunc(adminPostPath, func(w http.ResponseWriter, r *http.Request) {
+		adminPostHandler(w, r, mockDataStore, "admin-token")
+	})
+	mux.HandleFunc(feedPath, func(w http.ResponseWriter, r *http.Request) {
+		feedHandler(w, r, mockDataStore, clock, cfg)
+	})
+	do := func(method, path, auth, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(http.MethodPut, "/api/admin/posts/first", "", `{"title": "First", "body": "Hi."}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+
+	// Saving a new slug creates the post, and saving it again replaces it
+	rr := do(http.MethodPut, "/api/admin/posts/first", "Bearer admin-token", `{"title": "First", "body": "Hi.", "published_at": "2024-03-01T09:00:00Z"}`)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") != "/api/posts/first" {
+		t.Fatalf("expected the post created at /api/posts/first, got %d at %q: %s", rr.Code, rr.Header().Get("Location"), rr.Body.String())
+	}
+	rr = do(http.MethodPut, "/api/admin/posts/first", "Bearer admin-token", `{"title": "First", "body": "Hello, *world*.", "tags": ["go"], "published_at": "2024-03-01T09:00:00Z"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	do(http.MethodPut, "/api/admin/posts/second", "Bearer admin-token", `{"title": "Second", "body": "More.", "published_at": "2024-03-05T09:00:00Z"}`)
+	do(http.MethodPut, "/api/admin/posts/draft", "Bearer admin-token", `{"title": "Draft", "body": "Soon."}`)
+	do(http.MethodPut, "/api/admin/posts/scheduled", "Bearer admin-token", `{"title": "Scheduled", "body": "Later.", "published_at": "2024-04-01T09:00:00Z"}`)
+
+	rr = do(http.MethodGet, "/api/posts/first", "", "")
+	var p postResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if p.HTML != "<p>Hello, <em>world</em>.</p>\n" || p.Body != "Hello, *world*." {
+		t.Errorf("expected the body and its HTML; got %s", rr.Body.String())
+	}
+	for _, slug := range []string{"draft", "scheduled", "missing"} {
+		if rr := do(http.MethodGet, "/api/posts/"+slug, "", ""); rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", slug, rr.Code)
+		}
+	}
+	if rr := do(http.MethodGet, "/api/admin/posts/draft", "Bearer admin-token", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the draft shown to the admin, got %d", rr.Code)
+	}
+
+	// Only published posts are listed, newest first, a page at a time
+	rr = do(http.MethodGet, postsPath+"?per_page=1", "", "")
+	var list postsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if len(list.Posts) != 1 || list.Posts[0].Slug != "second" || !list.HasMore {
+		t.Errorf("expected the newest post and more to come; got %s", rr.Body.String())
+	}
+	rr = do(http.MethodGet, postsPath+"?per_page=1&page=2", "", "")
+	list = postsResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Posts) != 1 || list.Posts[0].Slug != "first" || list.HasMore {
+		t.Errorf("expected the last post; got %s", rr.Body.String())
+	}
+	rr = do(http.MethodGet, adminPostsPath, "Bearer admin-token", "")
+	list = postsResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Posts) != 4 || list.Posts[0].Slug != "draft" {
+		t.Errorf("expected every post, drafts first; got %s", rr.Body.String())
+	}
+
+	// The feed links to the posts' pages and carries their HTML
+	rr = do(http.MethodGet, feedPath, "", "")
+	if ct := rr.Header().Get("Content-Type"); ct != "application/rss+xml; charset=utf-8" {
+		t.Errorf("expected an RSS content type, got %q", ct)
+	}
+	var feed rssFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed %s: %v", rr.Body.String(), err)
+	}
+	if feed.Channel.Title != "Notes" || feed.Channel.Link != "https://example.com/blog" || len(feed.Channel.Items) != 2 {
+		t.Fatalf("expected the published posts in the feed; got %s", rr.Body.String())
+	}
+	if item := feed.Channel.Items[1]; item.Link != "https://example.com/blog/first" || item.Description != p.HTML ||
+		item.PubDate != "Fri, 01 Mar 2024 09:00:00 +0000" || len(item.Categories) != 1 {
+		t.Errorf("unexpected feed item %+v", item)
+	}
+
+	// Scheduled posts appear once their time comes
+	clock.Advance(30 * 24 * time.Hour)
+	if rr := do(http.MethodGet, "/api/posts/scheduled", "", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the scheduled post published, got %d", rr.Code)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPut, "/api/admin/posts/Bad_Slug", `{"title": "Bad", "body": "Bad."}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/posts/first", `{"title": "First", "body": "Hi.", "draft": true}`, http.StatusBadRequest},
+		{http.MethodGet, postsPath + "?page=0", "", http.StatusBadRequest},
+		{http.MethodGet, postsPath + "?per_page=51", "", http.StatusBadRequest},
+		{http.MethodPost, postsPath, "", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/admin/posts/first", "", http.StatusOK},
+		{http.MethodDelete, "/api/admin/posts/first", "", http.StatusNotFound},
+		{http.MethodGet, "/api/posts/first", "", http.StatusNotFound},
+	} {
+		if rr := do(tt.method, tt.path, "Bearer admin-token", tt.body); rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tt.method, tt.path, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func Test_blogConfig_postLink(t *testing.T) {
+	t.Setenv("BLOG_URL", "ftp://example.com")
+	cfg := loadBlogConfig()
+	req := httptest.NewRequest(http.MethodGet, feedPath, nil)
+	req.Host = "api.example.com"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if got := cfg.postLink(req, "hello"); got != "https://api.example.com/api/posts/hello" {
+		t.Errorf("expected an invalid BLOG_URL ignored for the API link, got %q", got)
+	}
+}
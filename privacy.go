@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	privacyExportPath = "/api/privacy/export"
+	privacyDeletePath = "/api/privacy/delete"
+
+	// consentHeader carries the visitor's choice from the frontend's consent banner
+	consentHeader = "X-Tracking-Consent"
+
+	// maxPrivacySessionIDs caps how many session tokens one request can name
+	maxPrivacySessionIDs = 100
+)
+
+const trackingConsentKey contextKey = "trackingConsent"
+
+// privacySignalMode selects which browser privacy signals deny tracking on their own.
+type privacySignalMode string
+
+const (
+	privacySignalsOff privacySignalMode = "off" // signals are ignored
+	privacySignalsGPC privacySignalMode = "gpc" // Sec-GPC: 1 denies tracking
+	privacySignalsAll privacySignalMode = "all" // Sec-GPC: 1 or DNT: 1 denies tracking
+)
+
+// consentLevel is the tracking a visit body asks for, from the frontend's consent banner.
+type consentLevel string
+
+const (
+	consentUnset     consentLevel = ""          // the header or configured default decides
+	consentFull      consentLevel = "full"      // per-visitor data may be stored
+	consentAnonymous consentLevel = "anonymous" // only aggregate counts are stored
+)
+
+// trackingConsent is the request's tracking decision. It is shared through the request
+// context so a handler that reads the visitor's choice from the body can pass it on to the
+// middleware that runs after it, such as unique visitor counting.
+type trackingConsent struct {
+	granted bool
+	refused bool // denied by the header or a privacy signal, which a body can't overrule
+}
+
+// consentConfig decides whether a request may be tracked per visitor.
+type consentConfig struct {
+	DefaultGranted bool              // consent assumed without an X-Tracking-Consent header
+	Signals        privacySignalMode // browser signals that override the header
+}
+
+// loadConsentConfig reads TRACKING_CONSENT_DEFAULT ("granted" unless set to "denied") and
+// PRIVACY_SIGNALS ("off", "gpc" or "all"; "off" unless set).
+func loadConsentConfig() consentConfig {
+	cfg := consentConfig{DefaultGranted: true, Signals: privacySignalsOff}
+
+	switch v := strings.ToLower(os.Getenv("TRACKING_CONSENT_DEFAULT")); v {
+	case "", "granted":
+	case "denied":
+		cfg.DefaultGranted = false
+	default:
+		log.Printf("Invalid TRACKING_CONSENT_DEFAULT %q, using granted", v)
+	}
+
+	switch v := privacySignalMode(strings.ToLower(os.Getenv("PRIVACY_SIGNALS"))); v {
+	case "":
+	case privacySignalsOff, privacySignalsGPC, privacySignalsAll:
+		cfg.Signals = v
+	default:
+		log.Printf("Invalid PRIVACY_SIGNALS %q, using %s", v, privacySignalsOff)
+	}
+
+	return cfg
+}
+
+// optedOut reports whether the request carries a privacy signal the mode honors.
+func (m privacySignalMode) optedOut(r *http.Request) bool {
+	switch m {
+	case privacySignalsGPC:
+		return r.Header.Get("Sec-GPC") == "1"
+	case privacySignalsAll:
+		return r.Header.Get("Sec-GPC") == "1" || r.Header.Get("DNT") == "1"
+	default:
+		return false
+	}
+}
+
+// consentMiddleware records whether the visitor allows per-visitor tracking. An honored
+// privacy signal denies it outright; otherwise the X-Tracking-Consent header decides, or,
+// without one, the configured default.
+func consentMiddleware(next http.Handler, cfg consentConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		consent := &trackingConsent{granted: cfg.DefaultGranted}
+		switch strings.ToLower(r.Header.Get(consentHeader)) {
+		case "granted":
+			consent.granted = true
+		case "denied":
+			consent.granted, consent.refused = false, true
+		}
+		if cfg.Signals.optedOut(r) {
+			consent.granted, consent.refused = false, true
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), trackingConsentKey, consent)))
+	})
+}
+
+// trackingAllowed reports whether per-visitor data (unique hashes, experiment exposures,
+// sessions, a visit's referrer and UTM parameters) may be stored for the request. Aggregate
+// counts are recorded either way.
+func trackingAllowed(ctx context.Context) bool {
+	consent, ok := ctx.Value(trackingConsentKey).(*trackingConsent)
+	return !ok || consent.granted
+}
+
+// trackingAllowedFor is trackingAllowed for a visit whose body asks for level. "anonymous"
+// always denies tracking, and "full" grants it unless the header or a privacy signal refused.
+func trackingAllowedFor(ctx context.Context, level consentLevel) bool {
+	consent, ok := ctx.Value(trackingConsentKey).(*trackingConsent)
+	switch level {
+	case consentAnonymous:
+		return false
+	case consentFull:
+		return !ok || !consent.refused
+	default:
+		return trackingAllowed(ctx)
+	}
+}
+
+// setTrackingAllowed records the decision for the rest of the request, so middleware wrapping
+// the handler sees a choice made in the body. It does nothing outside consentMiddleware.
+func setTrackingAllowed(ctx context.Context, allowed bool) {
+	if consent, ok := ctx.Value(trackingConsentKey).(*trackingConsent); ok {
+		consent.granted = allowed
+	}
+}
+
+// privacyRequest is the optional body of the privacy endpoints.
+type privacyRequest struct {
+	SessionIDs []string `json:"session_ids"` // session tokens the browser has been issued
+}
+
+// visitorIDs identifies the caller's data: their daily hashes for every day stats are kept,
+// which only their own IP and User-Agent reproduce, and the session tokens they name.
+func visitorIDs(w http.ResponseWriter, r *http.Request, hasher *visitorHasher, clock Clock) (VisitorIDs, error) {
+	var req privacyRequest
+	if r.Body != nil {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVisitBodyBytes)).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return VisitorIDs{}, err
+		}
+	}
+	if len(req.SessionIDs) > maxPrivacySessionIDs {
+		return VisitorIDs{}, fmt.Errorf("at most %d session_ids are allowed", maxPrivacySessionIDs)
+	}
+
+	var ids VisitorIDs
+	for _, id := range req.SessionIDs {
+		if !sessionIDPattern.MatchString(id) {
+			return VisitorIDs{}, fmt.Errorf("invalid session ID %q", id)
+		}
+		ids.SessionIDs = append(ids.SessionIDs, id)
+	}
+	today := clock.Now().UTC()
+	for i := 0; i < maxStatsDays; i++ {
+		day := today.AddDate(0, 0, -i)
+		ids.Hashes = append(ids.Hashes, VisitorHash{Day: day, Hash: hasher.Hash(r, day)})
+	}
+	return ids, nil
+}
+
+// privacyDay is a day the visitor was counted as unique.
+type privacyDay struct {
+	Date        string `json:"date"`
+	VisitorHash string `json:"visitor_hash"`
+}
+
+// privacyExposure is an experiment variant the visitor was shown.
+type privacyExposure struct {
+	Experiment string `json:"experiment"`
+	Variant    string `json:"variant"`
+	Date       string `json:"date"`
+}
+
+// privacySession is one of the visitor's sessions.
+type privacySession struct {
+	SessionID  string    `json:"session_id"`
+	StartedAt  time.Time `json:"started_at"`
+	LastSeen   time.Time `json:"last_seen"`
+	Heartbeats int       `json:"heartbeats"`
+}
+
+// privacyEvent is an event recorded in one of the visitor's sessions.
+type privacyEvent struct {
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	SessionID  string          `json:"session_id"`
+	Properties json.RawMessage `json:"properties,omitempty"`
+}
+
+// privacyExportResponse is the body returned by POST /api/privacy/export.
+type privacyExportResponse struct {
+	UniqueDays []privacyDay      `json:"unique_days"`
+	Exposures  []privacyExposure `json:"exposures"`
+	Sessions   []privacySession  `json:"sessions"`
+	Events     []privacyEvent    `json:"events"`
+}
+
+// privacyExportHandler returns everything stored about the caller.
+func privacyExportHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, hasher *visitorHasher, clock Clock) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids, err := visitorIDs(w, r, hasher, clock)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid privacy request: %v", err), http.StatusBadRequest)
+		return
+	}
+	data, err := dataStore.GetVisitorData(r.Context(), ids)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to export visitor data: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := privacyExportResponse{
+		UniqueDays: []privacyDay{},
+		Exposures:  []privacyExposure{},
+		Sessions:   []privacySession{},
+		Events:     []privacyEvent{},
+	}
+	for _, d := range data.UniqueDays {
+		response.UniqueDays = append(response.UniqueDays, privacyDay{Date: d.Day.UTC().Format(time.DateOnly), VisitorHash: d.Hash})
+	}
+	for _, e := range data.Exposures {
+		response.Exposures = append(response.Exposures, privacyExposure{Experiment: e.Experiment, Variant: e.Variant, Date: e.Day.UTC().Format(time.DateOnly)})
+	}
+	for _, s := range data.Sessions {
+		response.Sessions = append(response.Sessions, privacySession{SessionID: s.ID, StartedAt: s.StartedAt, LastSeen: s.LastSeen, Heartbeats: s.Heartbeats})
+	}
+	for _, e := range data.Events {
+		response.Events = append(response.Events, privacyEvent{Type: e.Type, OccurredAt: e.Timestamp, SessionID: e.SessionID, Properties: e.Properties})
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, response)
+}
+
+// privacyDeleteHandler erases everything stored about the caller.
+func privacyDeleteHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, hasher *visitorHasher, clock Clock) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids, err := visitorIDs(w, r, hasher, clock)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid privacy request: %v", err), http.StatusBadRequest)
+		return
+	}
+	deleted, err := dataStore.DeleteVisitorData(r.Context(), ids)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete visitor data: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, r, map[string]int{"deleted": deleted})
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSessionID = "0123456789abcdef0123456789abcdef"
+
+// newPrivacyFixture records a visitor's data on two days alongside another visitor's.
+func newPrivacyFixture(t *testing.T) (*MockDataStore, *visitorHasher, *fakeClock) {
+	t.Helper()
+	h := &visitorHasher{secret: []byte("test-secret")}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	store := &MockDataStore{}
+	ctx := httptest.NewRequest(http.MethodPost, apiPath, nil).Context()
+
+	visitor, other := newVisitorRequest(privacyExportPath, "203.0.113.7", ""), newVisitorRequest(privacyExportPath, "203.0.113.8", "")
+	for _, day := range []time.Time{clock.Now(), clock.Now().AddDate(0, 0, -30)} {
+		store.RecordUniqueVisitor(ctx, day, h.Hash(visitor, day))
+		store.RecordExposure(ctx, Exposure{Experiment: "layout", Variant: "compact", Day: day, VisitorHash: h.Hash(visitor, day)})
+	}
+	store.RecordUniqueVisitor(ctx, clock.Now(), h.Hash(other, clock.Now()))
+	store.StartSession(ctx, testSessionID, clock.Now())
+	store.StartSession(ctx, "fedcba9876543210fedcba9876543210", clock.Now())
+	store.RecordEvent(ctx, Event{Type: "downloaded_resume", Timestamp: clock.Now(), SessionID: testSessionID})
+	store.RecordEvent(ctx, Event{Type: "clicked_github", Timestamp: clock.Now()})
+	return store, h, clock
+}
+
+// newVisitorRequest builds a privacy request from the visitor at ip.
+func newVisitorRequest(path, ip, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.RemoteAddr = ip + ":12345"
+	req.Header.Set("User-Agent", "Firefox")
+	return req
+}
+
+func Test_privacyExportHandler(t *testing.T) {
+	store, h, clock := newPrivacyFixture(t)
+
+	req := newVisitorRequest(privacyExportPath, "203.0.113.7", fmt.Sprintf(`{"session_ids": [%q]}`, testSessionID))
+	w := httptest.NewRecorder()
+	privacyExportHandler(w, req, store, h, clock)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d: %s", w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected Cache-Control no-store; got %q", cc)
+	}
+	var response privacyExportResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(response.UniqueDays) != 2 || len(response.Exposures) != 2 {
+		t.Errorf("expected 2 unique days and 2 exposures; got %+v", response)
+	}
+	if len(response.Sessions) != 1 || response.Sessions[0].SessionID != testSessionID {
+		t.Errorf("expected only the named session; got %+v", response.Sessions)
+	}
+	if len(response.Events) != 1 || response.Events[0].Type != "downloaded_resume" {
+		t.Errorf("expected only the session's event; got %+v", response.Events)
+	}
+	if got := len(store.lastIDs.Hashes); got != maxStatsDays {
+		t.Errorf("expected a hash for each of the last %d days; got %d", maxStatsDays, got)
+	}
+}
+
+func Test_privacyExportHandler_Stranger(t *testing.T) {
+	store, h, clock := newPrivacyFixture(t)
+
+	w := httptest.NewRecorder()
+	privacyExportHandler(w, newVisitorRequest(privacyExportPath, "198.51.100.1", ""), store, h, clock)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+	if want := `{"unique_days":[],"exposures":[],"sessions":[],"events":[]}`; strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("expected nothing for another visitor; got %s", w.Body.String())
+	}
+}
+
+func Test_privacyDeleteHandler(t *testing.T) {
+	store, h, clock := newPrivacyFixture(t)
+
+	req := newVisitorRequest(privacyDeletePath, "203.0.113.7", fmt.Sprintf(`{"session_ids": [%q]}`, testSessionID))
+	w := httptest.NewRecorder()
+	privacyDeleteHandler(w, req, store, h, clock)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d: %s", w.Code, w.Body.String())
+	}
+	var response map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	// 2 unique days, 2 exposures, 1 session and 1 event
+	if response["deleted"] != 6 {
+		t.Errorf("expected 6 rows deleted; got %d", response["deleted"])
+	}
+	if len(store.uniques) != 1 || len(store.exposures) != 0 || len(store.sessions) != 1 || len(store.events) != 1 {
+		t.Errorf("expected only the other visitor's data to remain; got uniques=%d exposures=%d sessions=%d events=%d",
+			len(store.uniques), len(store.exposures), len(store.sessions), len(store.events))
+	}
+}
+
+func Test_privacyHandlers_InvalidRequest(t *testing.T) {
+	h := &visitorHasher{secret: []byte("test-secret")}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	tooMany := `{"session_ids": [` + strings.TrimSuffix(strings.Repeat(fmt.Sprintf("%q,", testSessionID), maxPrivacySessionIDs+1), ",") + `]}`
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{"Wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"Malformed body", http.MethodPost, `{"session_ids": "abc"}`, http.StatusBadRequest},
+		{"Invalid session ID", http.MethodPost, `{"session_ids": ["not-a-session"]}`, http.StatusBadRequest},
+		{"Too many session IDs", http.MethodPost, tooMany, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		for path, handler := range map[string]func(http.ResponseWriter, *http.Request, DataStore, *visitorHasher, Clock){
+			privacyExportPath: privacyExportHandler,
+			privacyDeletePath: privacyDeleteHandler,
+		} {
+			t.Run(tt.name+" "+path, func(t *testing.T) {
+				store := &MockDataStore{}
+				w := httptest.NewRecorder()
+				handler(w, httptest.NewRequest(tt.method, path, strings.NewReader(tt.body)), store, h, clock)
+
+				if w.Code != tt.wantStatus {
+					t.Errorf("expected status %d; got %d", tt.wantStatus, w.Code)
+				}
+				if store.lastIDs.Hashes != nil {
+					t.Error("expected the store not to be queried")
+				}
+			})
+		}
+	}
+}
+
+func Test_consentMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     consentConfig
+		headers map[string]string
+		want    bool
+	}{
+		{"Granted", consentConfig{}, map[string]string{consentHeader: "granted"}, true},
+		{"Denied", consentConfig{DefaultGranted: true}, map[string]string{consentHeader: "denied"}, false},
+		{"Case insensitive", consentConfig{DefaultGranted: true}, map[string]string{consentHeader: "DENIED"}, false},
+		{"Default granted", consentConfig{DefaultGranted: true}, nil, true},
+		{"Default denied", consentConfig{}, nil, false},
+		{"Unknown value uses default", consentConfig{}, map[string]string{consentHeader: "maybe"}, false},
+		{"Signals off ignores GPC", consentConfig{DefaultGranted: true, Signals: privacySignalsOff}, map[string]string{"Sec-GPC": "1"}, true},
+		{"Signals off ignores DNT", consentConfig{DefaultGranted: true, Signals: privacySignalsOff}, map[string]string{"DNT": "1"}, true},
+		{"GPC mode honors GPC", consentConfig{DefaultGranted: true, Signals: privacySignalsGPC}, map[string]string{"Sec-GPC": "1"}, false},
+		{"GPC mode ignores DNT", consentConfig{DefaultGranted: true, Signals: privacySignalsGPC}, map[string]string{"DNT": "1"}, true},
+		{"All mode honors GPC", consentConfig{DefaultGranted: true, Signals: privacySignalsAll}, map[string]string{"Sec-GPC": "1"}, false},
+		{"All mode honors DNT", consentConfig{DefaultGranted: true, Signals: privacySignalsAll}, map[string]string{"DNT": "1"}, false},
+		{"All mode ignores DNT 0", consentConfig{DefaultGranted: true, Signals: privacySignalsAll}, map[string]string{"DNT": "0"}, true},
+		{"Signal overrides granted consent", consentConfig{Signals: privacySignalsAll}, map[string]string{consentHeader: "granted", "DNT": "1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bool
+			handler := consentMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = trackingAllowed(r.Context())
+			}), tt.cfg)
+			req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("trackingAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadConsentConfig(t *testing.T) {
+	tests := []struct {
+		consentDefault string
+		signals        string
+		want           consentConfig
+	}{
+		{"", "", consentConfig{DefaultGranted: true, Signals: privacySignalsOff}},
+		{"granted", "off", consentConfig{DefaultGranted: true, Signals: privacySignalsOff}},
+		{"Denied", "gpc", consentConfig{DefaultGranted: false, Signals: privacySignalsGPC}},
+		{"denied", "ALL", consentConfig{DefaultGranted: false, Signals: privacySignalsAll}},
+		{"bogus", "bogus", consentConfig{DefaultGranted: true, Signals: privacySignalsOff}},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("TRACKING_CONSENT_DEFAULT", tt.consentDefault)
+		t.Setenv("PRIVACY_SIGNALS", tt.signals)
+		if got := loadConsentConfig(); got != tt.want {
+			t.Errorf("TRACKING_CONSENT_DEFAULT=%q PRIVACY_SIGNALS=%q: got %+v, want %+v", tt.consentDefault, tt.signals, got, tt.want)
+		}
+	}
+}
+
+func Test_incrementVisitCount_PrivacySignals(t *testing.T) {
+	body := `{"referrer": "https://www.linkedin.com/feed", "utm_source": "newsletter"}`
+	tests := []struct {
+		name         string
+		signals      privacySignalMode
+		headers      map[string]string
+		wantMetadata bool
+	}{
+		{"Off", privacySignalsOff, map[string]string{"DNT": "1", "Sec-GPC": "1"}, true},
+		{"GPC with GPC", privacySignalsGPC, map[string]string{"Sec-GPC": "1"}, false},
+		{"GPC with DNT", privacySignalsGPC, map[string]string{"DNT": "1"}, true},
+		{"All with DNT", privacySignalsAll, map[string]string{"DNT": "1"}, false},
+		{"All without signals", privacySignalsAll, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &MockDataStore{}
+			h := &visitorHasher{secret: []byte("test-secret")}
+			clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+			handler := consentMiddleware(uniqueVisitorMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				visitCountHandler(w, r, store, clock)
+			}), store, h, newVisitorSketches(store, 0), clock), consentConfig{DefaultGranted: true, Signals: tt.signals})
+
+			req := httptest.NewRequest(http.MethodPost, apiPath, strings.NewReader(body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK || store.visitCount != 1 {
+				t.Fatalf("expected the visit to be counted; got status %d, %d visits", w.Code, store.visitCount)
+			}
+			if got := store.lastVisit.Referrer != "" && store.lastVisit.UTM.Source != ""; got != tt.wantMetadata {
+				t.Errorf("expected metadata stored = %v; got visit %+v", tt.wantMetadata, store.lastVisit)
+			}
+			if got := len(store.uniques) == 1; got != tt.wantMetadata {
+				t.Errorf("expected unique visitor stored = %v; got %d", tt.wantMetadata, len(store.uniques))
+			}
+		})
+	}
+}
+
+func Test_incrementVisitCount_ConsentLevel(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          consentConfig
+		headers      map[string]string
+		consent      string
+		wantMetadata bool
+	}{
+		{"Full", consentConfig{}, nil, "full", true},
+		{"Anonymous", consentConfig{DefaultGranted: true}, nil, "anonymous", false},
+		{"Anonymous overrides granted header", consentConfig{}, map[string]string{consentHeader: "granted"}, "anonymous", false},
+		{"Unset uses default", consentConfig{DefaultGranted: true}, nil, "", true},
+		{"Full can't overrule denied header", consentConfig{DefaultGranted: true}, map[string]string{consentHeader: "denied"}, "full", false},
+		{"Full can't overrule GPC", consentConfig{DefaultGranted: true, Signals: privacySignalsGPC}, map[string]string{"Sec-GPC": "1"}, "full", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &MockDataStore{}
+			h := &visitorHasher{secret: []byte("test-secret")}
+			clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+			handler := consentMiddleware(uniqueVisitorMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				visitCountHandler(w, r, store, clock)
+			}), store, h, newVisitorSketches(store, 0), clock), tt.cfg)
+
+			body := fmt.Sprintf(`{"consent": %q, "page": "blog", "referrer": "https://github.com/", "utm_source": "newsletter"}`, tt.consent)
+			req := httptest.NewRequest(http.MethodPost, apiPath, strings.NewReader(body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK || store.visitCount != 1 || store.lastVisit.Page != "blog" {
+				t.Fatalf("expected the visit to be counted; got status %d, visit %+v", w.Code, store.lastVisit)
+			}
+			if got := store.lastVisit.Referrer != "" && store.lastVisit.UTM.Source != ""; got != tt.wantMetadata {
+				t.Errorf("expected metadata stored = %v; got visit %+v", tt.wantMetadata, store.lastVisit)
+			}
+			if got := len(store.uniques) == 1; got != tt.wantMetadata {
+				t.Errorf("expected unique visitor stored = %v; got %d", tt.wantMetadata, len(store.uniques))
+			}
+		})
+	}
+}
+
+func Test_visitRequest_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     visitRequest
+		wantErr bool
+	}{
+		{"Empty", visitRequest{}, false},
+		{"Full", visitRequest{Consent: consentFull, Page: "Blog"}, false},
+		{"Anonymous", visitRequest{Consent: consentAnonymous}, false},
+		{"Unknown consent", visitRequest{Consent: "granted"}, true},
+		{"Invalid page", visitRequest{Page: "blog/post"}, true},
+		{"Long referrer", visitRequest{Referrer: "https://example.com/" + strings.Repeat("a", maxReferrerLength)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_trackingConsentDenied(t *testing.T) {
+	store := &MockDataStore{}
+	h := &visitorHasher{secret: []byte("test-secret")}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	sketches := newVisitorSketches(store, 0)
+
+	mux := http.NewServeMux()
+	mux.Handle(apiPath, uniqueVisitorMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		visitCountHandler(w, r, store, clock)
+	}), store, h, sketches, clock))
+	mux.HandleFunc(experimentPath+"{name}", func(w http.ResponseWriter, r *http.Request) {
+		experimentHandler(w, r, store, h, experiments{"layout": {"control", "compact"}}, clock)
+	})
+	mux.HandleFunc(sessionHeartbeatPath, func(w http.ResponseWriter, r *http.Request) {
+		sessionHeartbeatHandler(w, r, store, sessionConfig{IdleTimeout: time.Minute}, clock)
+	})
+	mux.HandleFunc(eventsPath, func(w http.ResponseWriter, r *http.Request) {
+		eventsHandler(w, r, store, clock)
+	})
+	handler := consentMiddleware(mux, consentConfig{DefaultGranted: true})
+
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, apiPath, ""},
+		{http.MethodGet, experimentPath + "layout", ""},
+		{http.MethodPost, sessionHeartbeatPath, ""},
+		{http.MethodPost, eventsPath, fmt.Sprintf(`{"type": "downloaded_resume", "session_id": %q}`, testSessionID)},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		req.Header.Set(consentHeader, "denied")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: expected status 200; got %d", r.method, r.path, w.Code)
+		}
+		if r.path == sessionHeartbeatPath && strings.TrimSpace(w.Body.String()) != `{"session_id":""}` {
+			t.Errorf("expected an empty session_id; got %s", w.Body.String())
+		}
+	}
+
+	if store.visitCount != 1 || len(store.events) != 1 {
+		t.Errorf("expected the visit and event to still be counted; got visits=%d events=%d", store.visitCount, len(store.events))
+	}
+	if len(store.uniques) != 0 || len(store.exposures) != 0 || len(store.sessions) != 0 {
+		t.Errorf("expected no per-visitor data; got uniques=%d exposures=%d sessions=%d", len(store.uniques), len(store.exposures), len(store.sessions))
+	}
+	if store.events[0].SessionID != "" {
+		t.Errorf("expected the event to be detached from the session; got %q", store.events[0].SessionID)
+	}
+}
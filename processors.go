@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"resume-backend/internal/logging"
+)
+
+// VisitProcessor is called after each visit is recorded. ProcessVisit runs on the request
+// path, so processors must hand slow work such as network calls to a background goroutine.
+// Errors are logged and never fail the visit.
+//
+// Processors can also implement EventProcessor to see recorded events, Run(ctx) to do
+// background work until shutdown, and Close() to flush on exit.
+type VisitProcessor interface {
+	Name() string
+	ProcessVisit(ctx context.Context, visit Visit) error
+}
+
+// EventProcessor is implemented by processors that also want each recorded event.
+type EventProcessor interface {
+	ProcessEvent(ctx context.Context, event Event) error
+}
+
+// OutboxProcessor is implemented by processors whose deliveries must survive a crash. With
+// the outbox enabled, OutboxPayload is stored in the same statement as the visit and the
+// outbox dispatcher calls Deliver, retrying with backoff, in place of ProcessVisit.
+type OutboxProcessor interface {
+	VisitProcessor
+	OutboxPayload(visit Visit) (json.RawMessage, error)
+	Deliver(ctx context.Context, payload json.RawMessage) error
+}
+
+// visitProcessorFactory builds a processor from its environment settings, returning a nil
+// processor when it isn't configured.
+type visitProcessorFactory func(store DataStore) (VisitProcessor, error)
+
+// Factories by processor name, added by registerVisitProcessor from each processor's file
+var visitProcessorFactories = map[string]visitProcessorFactory{}
+
+// registerVisitProcessor makes a processor available under name. It is meant to be called
+// from init functions and panics on duplicate names.
+func registerVisitProcessor(name string, factory visitProcessorFactory) {
+	if _, ok := visitProcessorFactories[name]; ok {
+		panic("visit processor registered twice: " + name)
+	}
+	visitProcessorFactories[name] = factory
+}
+
+// loadVisitProcessors builds the registered processors that are configured, in name order.
+// VISIT_PROCESSORS, a comma-separated list of names, limits which ones may run.
+func loadVisitProcessors(store DataStore) ([]VisitProcessor, error) {
+	names := make([]string, 0, len(visitProcessorFactories))
+	for name := range visitProcessorFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if v := os.Getenv("VISIT_PROCESSORS"); v != "" {
+		allowed := make(map[string]bool)
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := visitProcessorFactories[name]; !ok {
+				return nil, fmt.Errorf("unknown visit processor %q in VISIT_PROCESSORS: available are %s", name, strings.Join(names, ", "))
+			}
+			allowed[name] = true
+		}
+		kept := names[:0]
+		for _, name := range names {
+			if allowed[name] {
+				kept = append(kept, name)
+			}
+		}
+		names = kept
+	}
+
+	var processors []VisitProcessor
+	for _, name := range names {
+		p, err := visitProcessorFactories[name](store)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up visit processor %s: %w", name, err)
+		}
+		if p != nil {
+			processors = append(processors, p)
+		}
+	}
+	if len(processors) > 0 {
+		enabled := make([]string, len(processors))
+		for i, p := range processors {
+			enabled[i] = p.Name()
+		}
+		log.Printf("Visit processors enabled: %s", strings.Join(enabled, ", "))
+	}
+	return processors, nil
+}
+
+// processingStore is a DataStore decorator that runs the processors after each recorded visit and event.
+// With outbox set, visits for OutboxProcessors are queued in the outbox instead.
+type processingStore struct {
+	DataStore
+	processors []VisitProcessor
+	outbox     bool
+}
+
+func newProcessingStore(ds DataStore, processors []VisitProcessor, outbox bool) *processingStore {
+	return &processingStore{DataStore: ds, processors: processors, outbox: outbox}
+}
+
+// IncrementVisitCount records the visit, then hands it to every processor.
+func (s *processingStore) IncrementVisitCount(ctx context.Context, visit Visit) error {
+	var err error
+	if messages := s.outboxMessages(ctx, []Visit{visit}); messages != nil {
+		err = s.DataStore.IncrementVisitCountsWithOutbox(ctx, []Visit{visit}, messages)
+	} else {
+		err = s.DataStore.IncrementVisitCount(ctx, visit)
+	}
+	if err != nil {
+		return err
+	}
+	s.processVisit(ctx, visit)
+	return nil
+}
+
+// IncrementVisitCounts records the visits, then hands each one to every processor.
+func (s *processingStore) IncrementVisitCounts(ctx context.Context, visits []Visit) error {
+	var err error
+	if messages := s.outboxMessages(ctx, visits); messages != nil {
+		err = s.DataStore.IncrementVisitCountsWithOutbox(ctx, visits, messages)
+	} else {
+		err = s.DataStore.IncrementVisitCounts(ctx, visits)
+	}
+	if err != nil {
+		return err
+	}
+	for _, visit := range visits {
+		s.processVisit(ctx, visit)
+	}
+	return nil
+}
+
+// outboxMessages returns the outbox payloads of visits for the OutboxProcessors, or nil when
+// the outbox is disabled. A payload that can't be encoded is logged and left out.
+func (s *processingStore) outboxMessages(ctx context.Context, visits []Visit) []OutboxMessage {
+	if !s.outbox {
+		return nil
+	}
+	var messages []OutboxMessage
+	for _, visit := range visits {
+		for _, p := range s.processors {
+			op, ok := p.(OutboxProcessor)
+			if !ok {
+				continue
+			}
+			payload, err := op.OutboxPayload(visit)
+			if err != nil {
+				logging.FromContext(ctx).Printf("Error in visit processor %s: %v", p.Name(), err)
+				continue
+			}
+			messages = append(messages, OutboxMessage{Destination: p.Name(), Payload: payload})
+		}
+	}
+	return messages
+}
+
+// processVisit hands visit to the processors that don't deliver through the outbox.
+func (s *processingStore) processVisit(ctx context.Context, visit Visit) {
+	for _, p := range s.processors {
+		if _, ok := p.(OutboxProcessor); ok && s.outbox {
+			continue
+		}
+		if err := p.ProcessVisit(ctx, visit); err != nil {
+			logging.FromContext(ctx).Printf("Error in visit processor %s: %v", p.Name(), err)
+		}
+	}
+}
+
+// RecordEvent records the event, then hands it to the processors that take events.
+func (s *processingStore) RecordEvent(ctx context.Context, event Event) error {
+	if err := s.DataStore.RecordEvent(ctx, event); err != nil {
+		return err
+	}
+	for _, p := range s.processors {
+		if ep, ok := p.(EventProcessor); ok {
+			if err := ep.ProcessEvent(ctx, event); err != nil {
+				logging.FromContext(ctx).Printf("Error in visit processor %s: %v", p.Name(), err)
+			}
+		}
+	}
+	return nil
+}
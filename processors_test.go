@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"resume-backend/internal/store"
+	"resume-backend/internal/store/storetest"
+)
+
+// recordingProcessor keeps the visits it is given and fails if err is set
+type recordingProcessor struct {
+	name   string
+	visits []Visit
+	err    error
+}
+
+func (p *recordingProcessor) Name() string {
+	return p.name
+}
+
+func (p *recordingProcessor) ProcessVisit(ctx context.Context, visit Visit) error {
+	p.visits = append(p.visits, visit)
+	return p.err
+}
+
+// recordingEventProcessor also takes events
+type recordingEventProcessor struct {
+	recordingProcessor
+	events []Event
+}
+
+func (p *recordingEventProcessor) ProcessEvent(ctx context.Context, event Event) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+// useVisitProcessors swaps the registry for the duration of a test
+func useVisitProcessors(t *testing.T, factories map[string]visitProcessorFactory) {
+	t.Helper()
+	saved := visitProcessorFactories
+	visitProcessorFactories = factories
+	t.Cleanup(func() { visitProcessorFactories = saved })
+}
+
+func Test_registerVisitProcessor(t *testing.T) {
+	useVisitProcessors(t, map[string]visitProcessorFactory{})
+
+	factory := func(DataStore) (VisitProcessor, error) { return nil, nil }
+	registerVisitProcessor("test", factory)
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a name twice to panic")
+		}
+	}()
+	registerVisitProcessor("test", factory)
+}
+
+func Test_loadVisitProcessors(t *testing.T) {
+	configured := func(name string) visitProcessorFactory {
+		return func(DataStore) (VisitProcessor, error) { return &recordingProcessor{name: name}, nil }
+	}
+	useVisitProcessors(t, map[string]visitProcessorFactory{
+		"zeta":  configured("zeta"),
+		"alpha": configured("alpha"),
+		"off":   func(DataStore) (VisitProcessor, error) { return nil, nil },
+	})
+
+	names := func(processors []VisitProcessor) []string {
+		var out []string
+		for _, p := range processors {
+			out = append(out, p.Name())
+		}
+		return out
+	}
+
+	processors, err := loadVisitProcessors(&MockDataStore{})
+	if err != nil {
+		t.Fatalf("loadVisitProcessors() error = %v", err)
+	}
+	if got := names(processors); !reflect.DeepEqual(got, []string{"alpha", "zeta"}) {
+		t.Errorf("expected the configured processors in name order; got %v", got)
+	}
+
+	t.Setenv("VISIT_PROCESSORS", "zeta, off")
+	processors, _ = loadVisitProcessors(&MockDataStore{})
+	if got := names(processors); !reflect.DeepEqual(got, []string{"zeta"}) {
+		t.Errorf("expected VISIT_PROCESSORS to limit the processors; got %v", got)
+	}
+
+	t.Setenv("VISIT_PROCESSORS", "geoip")
+	if _, err := loadVisitProcessors(&MockDataStore{}); err == nil {
+		t.Error("expected an error for an unknown processor")
+	}
+}
+
+func Test_loadVisitProcessors_FactoryError(t *testing.T) {
+	useVisitProcessors(t, map[string]visitProcessorFactory{
+		"broken": func(DataStore) (VisitProcessor, error) { return nil, errors.New("missing setting") },
+	})
+	if _, err := loadVisitProcessors(&MockDataStore{}); err == nil {
+		t.Error("expected the factory error to be returned")
+	}
+}
+
+func Test_processingStore(t *testing.T) {
+	failing := &recordingProcessor{name: "failing", err: errors.New("unreachable")}
+	events := &recordingEventProcessor{recordingProcessor: recordingProcessor{name: "events"}}
+	mockDataStore := &MockDataStore{}
+	s := newProcessingStore(mockDataStore, []VisitProcessor{failing, events}, false)
+
+	visit := Visit{Timestamp: time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC), Referrer: "github.com"}
+	if err := s.IncrementVisitCount(context.Background(), visit); err != nil {
+		t.Fatalf("expected a processor error not to fail the visit; got %v", err)
+	}
+	if mockDataStore.visitCount != 1 || len(failing.visits) != 1 || len(events.visits) != 1 || events.visits[0] != visit {
+		t.Errorf("expected the visit to be recorded and processed by both processors")
+	}
+
+	if err := s.IncrementVisitCounts(context.Background(), []Visit{visit, visit}); err != nil {
+		t.Fatalf("IncrementVisitCounts() error = %v", err)
+	}
+	if mockDataStore.visitCount != 3 || len(failing.visits) != 3 || len(events.visits) != 3 {
+		t.Errorf("expected each batched visit to be recorded and processed")
+	}
+
+	if err := s.RecordEvent(context.Background(), Event{Type: "clicked_github"}); err != nil {
+		t.Fatalf("RecordEvent() error = %v", err)
+	}
+	if len(events.events) != 1 || len(mockDataStore.events) != 1 {
+		t.Errorf("expected the event to be recorded and processed; got %d", len(events.events))
+	}
+}
+
+func Test_processingStore_NotRecorded(t *testing.T) {
+	p := &recordingEventProcessor{recordingProcessor: recordingProcessor{name: "events"}}
+	s := newProcessingStore(failingStore{}, []VisitProcessor{p}, false)
+
+	if err := s.IncrementVisitCount(context.Background(), Visit{}); err == nil {
+		t.Error("expected the store error to be returned")
+	}
+	if err := s.IncrementVisitCounts(context.Background(), []Visit{{}}); err == nil {
+		t.Error("expected the store error to be returned")
+	}
+	if err := s.RecordEvent(context.Background(), Event{}); err == nil {
+		t.Error("expected the store error to be returned")
+	}
+	if len(p.visits) != 0 || len(p.events) != 0 {
+		t.Error("expected nothing to be processed for unrecorded writes")
+	}
+}
+
+func Test_processingStore_Cancellation(t *testing.T) {
+	storetest.CheckCancellation(t, func(pool store.DatabasePool) store.DataStore {
+		return newProcessingStore(store.NewPostgresStore(pool), []VisitProcessor{&recordingEventProcessor{}}, false)
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	projectsPath      = "/api/projects"
+	projectPath       = "/api/projects/{id}"
+	adminProjectsPath = "/api/admin/projects"
+	adminProjectPath  = "/api/admin/projects/{id}"
+
+	maxProjectBodyBytes         = 64 << 10
+	maxProjectTitleLength       = 200
+	maxProjectDescriptionLength = 10000
+	maxProjectTags              = 20
+	maxProjectTagLength         = 50
+	maxProjectLinks             = 10
+	maxProjectLinkLabelLength   = 100
+)
+
+// projectRequest is the body of a project create or update; an update replaces every field.
+type projectRequest struct {
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	Tags        []string      `json:"tags"`
+	Links       []ProjectLink `json:"links"`
+	Position    int           `json:"position"`
+}
+
+// projectResponse is one project as the API returns it.
+type projectResponse struct {
+	ID          int64         `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	Tags        []string      `json:"tags"`
+	Links       []ProjectLink `json:"links"`
+	Position    int           `json:"position"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+type projectsResponse struct {
+	Projects []projectResponse `json:"projects"`
+}
+
+func newProjectResponse(p Project) projectResponse {
+	resp := projectResponse{
+		ID:          p.ID,
+		Title:       p.Title,
+		Description: p.Description,
+		Tags:        p.Tags,
+		Links:       p.Links,
+		Position:    p.Position,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+	}
+	if resp.Tags == nil {
+		resp.Tags = []string{}
+	}
+	if resp.Links == nil {
+		resp.Links = []ProjectLink{}
+	}
+	return resp
+}
+
+// project validates req and returns it as a project, with its title, tags and links trimmed
+// and duplicate tags dropped.
+func (req projectRequest) project() (Project, error) {
+	p := Project{
+		Title:       strings.TrimSpace(req.Title),
+		Description: strings.TrimSpace(req.Description),
+		Position:    req.Position,
+	}
+	if p.Title == "" || utf8.RuneCountInString(p.Title) > maxProjectTitleLength {
+		return Project{}, fmt.Errorf("title must be 1 to %d characters", maxProjectTitleLength)
+	}
+	if utf8.RuneCountInString(p.Description) > maxProjectDescriptionLength {
+		return Project{}, fmt.Errorf("description must be at most %d characters", maxProjectDescriptionLength)
+	}
+
+	if len(req.Tags) > maxProjectTags {
+		return Project{}, fmt.Errorf("a project can have at most %d tags", maxProjectTags)
+	}
+	seen := make(map[string]bool)
+	for _, tag := range req.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > maxProjectTagLength {
+			return Project{}, fmt.Errorf("tags must be 1 to %d characters", maxProjectTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			p.Tags = append(p.Tags, tag)
+		}
+	}
+
+	if len(req.Links) > maxProjectLinks {
+		return Project{}, fmt.Errorf("a project can have at most %d links", maxProjectLinks)
+	}
+	for _, link := range req.Links {
+		link.Label, link.URL = strings.TrimSpace(link.Label), strings.TrimSpace(link.URL)
+		if link.Label == "" || utf8.RuneCountInString(link.Label) > maxProjectLinkLabelLength {
+			return Project{}, fmt.Errorf("link labels must be 1 to %d characters", maxProjectLinkLabelLength)
+		}
+		u, err := url.Parse(link.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return Project{}, fmt.Errorf("invalid link URL %q: must be an absolute http or https URL", link.URL)
+		}
+		p.Links = append(p.Links, link)
+	}
+	return p, nil
+}
+
+// projectID reads the id path parameter, answering the request when it is invalid.
+func projectID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// decodeProject reads and validates a project request body, answering the request when it
+// is invalid.
+func decodeProject(w http.ResponseWriter, r *http.Request) (Project, bool) {
+	var req projectRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProjectBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid project body: %v", err), http.StatusBadRequest)
+		return Project{}, false
+	}
+	p, err := req.project()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return Project{}, false
+	}
+	return p, true
+}
+
+// projectsHandler lists the portfolio's projects in display order.
+func projectsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projects, err := dataStore.ListProjects(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list projects: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp := projectsResponse{Projects: make([]projectResponse, len(projects))}
+	for i, p := range projects {
+		resp.Projects[i] = newProjectResponse(p)
+	}
+	writeResponse(w, r, resp)
+}
+
+// projectHandler returns one project.
+func projectHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := projectID(w, r)
+	if !ok {
+		return
+	}
+
+	p, found, err := dataStore.GetProject(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get project: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "No project with that ID", http.StatusNotFound)
+		return
+	}
+	writeResponse(w, r, newProjectResponse(p))
+}
+
+// adminProjectsHandler creates a project.
+func adminProjectsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+	p, ok := decodeProject(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := dataStore.CreateProject(r.Context(), p)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create project: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", projectsPath+"/"+strconv.FormatInt(created.ID, 10))
+	writeResponseStatus(w, r, http.StatusCreated, newProjectResponse(created))
+}
+
+// adminProjectHandler replaces a project with PUT and deletes it with DELETE.
+func adminProjectHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+	id, ok := projectID(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == http.MethodDelete {
+		deleted, err := dataStore.DeleteProject(r.Context(), id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to delete project: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "No project with that ID", http.StatusNotFound)
+			return
+		}
+		writeResponse(w, r, messageResponse{Message: "Project deleted"})
+		return
+	}
+
+	p, ok := decodeProject(w, r)
+	if !ok {
+		return
+	}
+	p.ID = id
+	updated, found, err := dataStore.UpdateProject(r.Context(), p)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update project: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "No project with that ID", http.StatusNotFound)
+		return
+	}
+	writeResponse(w, r, newProjectResponse(updated))
+}
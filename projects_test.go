@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_projectRequest_project(t *testing.T) {
+	p, err := projectRequest{
+		Title: "  resume-backend ",
+		Tags:  []string{"go", " postgres", "go"},
+		Links: []ProjectLink{{Label: "Source ", URL: " https://github.com/me/resume-backend"}},
+	}.project()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "resume-backend" || strings.Join(p.Tags, ",") != "go,postgres" ||
+		p.Links[0] != (ProjectLink{Label: "Source", URL: "https://github.com/me/resume-backend"}) {
+		t.Errorf("expected the fields trimmed and duplicate tags dropped; got %+v", p)
+	}
+
+	for name, req := range map[string]projectRequest{
+		"no title":       {Title: " "},
+		"long title":     {Title: strings.Repeat("x", maxProjectTitleLength+1)},
+		"empty tag":      {Title: "Blog", Tags: []string{""}},
+		"too many tags":  {Title: "Blog", Tags: make([]string, maxProjectTags+1)},
+		"unlabeled link": {Title: "Blog", Links: []ProjectLink{{URL: "https://example.com"}}},
+		"script link":    {Title: "Blog", Links: []ProjectLink{{Label: "Demo", URL: "javascript:alert(1)"}}},
+		"relative link":  {Title: "Blog", Links: []ProjectLink{{Label: "Demo", URL: "/demo"}}},
+	} {
+		if _, err := req.project(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func Test_projectHandlers(t *testing.T) {
+	mockDataStore := &MockDataStore{}
+	mux := http.NewServeMux()
+	mux.HandleFunc(projectsPath, func(w http.ResponseWriter, r *http.Request) {
+		projectsHandler(w, r, mockDataStore)
+	})
+	mux.HandleFunc(projectPath, func(w http.ResponseWriter, r *http.Request) {
+		projectHandler(w, r, mockDataStore)
+	})
+	mux.HandleFunc(adminProjectsPath, func(w http.ResponseWriter, r *http.Request) {
+		adminProjectsHandler(w, r, mockDataStore, "admin-token")
+	})
+	mux.HandleFunc(adminProjectPath, func(w http.ResponseWriter, r *http.Request) {
+		adminProjectHandler(w, r, mockDataStore, "admin-token")
+	})
+	do := func(method, path, auth, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Writes need the admin token
+	if rr := do(http.MethodPost, adminProjectsPath, "", `{"title": "Blog"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+
+	rr := do(http.MethodPost, adminProjectsPath, "Bearer admin-token", `{"title": "Blog", "position": 2}`)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") != "/api/projects/1" {
+		t.Fatalf("expected the project created at /api/projects/1, got %d at %q: %s", rr.Code, rr.Header().Get("Location"), rr.Body.String())
+	}
+	if rr := do(http.MethodPost, adminProjectsPath, "Bearer admin-token", `{"title": "resume-backend", "tags": ["go"], "position": 1}`); rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPost, adminProjectsPath, "Bearer admin-token", `{"title": "Blog", "stars": 5}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown fields rejected, got %d", rr.Code)
+	}
+
+	// Reads are public and in display order
+	rr = do(http.MethodGet, projectsPath, "", "")
+	var list projectsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if len(list.Projects) != 2 || list.Projects[0].Title != "resume-backend" || list.Projects[1].Title != "Blog" {
+		t.Fatalf("expected the projects by position; got %+v", list.Projects)
+	}
+	if list.Projects[1].Tags == nil || list.Projects[1].Links == nil {
+		t.Errorf("expected empty tags and links as arrays; got %s", rr.Body.String())
+	}
+
+	rr = do(http.MethodPut, "/api/admin/projects/1", "Bearer admin-token", `{"title": "Notes", "position": 0}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = do(http.MethodGet, "/api/projects/1", "", "")
+	var p projectResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil || p.ID != 1 || p.Title != "Notes" {
+		t.Errorf("expected the project replaced; got %s, %v", rr.Body.String(), err)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPut, "/api/admin/projects/9", `{"title": "Notes"}`, http.StatusNotFound},
+		{http.MethodPut, "/api/admin/projects/0", `{"title": "Notes"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/admin/projects/1", `{"title": "Notes"}`, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/admin/projects/1", "", http.StatusOK},
+		{http.MethodDelete, "/api/admin/projects/1", "", http.StatusNotFound},
+		{http.MethodGet, "/api/projects/1", "", http.StatusNotFound},
+		{http.MethodGet, "/api/projects/abc", "", http.StatusBadRequest},
+	} {
+		if rr := do(tt.method, tt.path, "Bearer admin-token", tt.body); rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tt.method, tt.path, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -2,48 +2,266 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Metric names, shared with the SLO rules and dashboard generators so alerts and panels stay
+// in sync with the code
+const (
+	metricHTTPRequestsTotal         = "http_requests_total"
+	metricHTTPRequestDuration       = "http_request_duration_seconds"
+	metricHTTPRequestErrorsTotal    = "http_request_errors_total"
+	metricPanicsTotal               = "panics_total"
+	metricHTTPRequestsShedTotal     = "http_requests_shed_total"
+	metricHTTPRequestsRateLimited   = "http_requests_rate_limited_total"
+	metricHTTPRequestsInFlight      = "http_requests_in_flight"
+	metricHTTPResponseSize          = "http_response_size_bytes"
+	metricCaptchaVerificationsTotal = "captcha_verifications_total"
+	metricOutboxDeliveriesTotal     = "outbox_deliveries_total"
+	metricCertificateExpiryDays     = "tls_certificate_expiry_days"
+	metricDBQueriesTotal            = "db_queries_total"
+	metricDBQueryDuration           = "db_query_duration_seconds"
+	metricDBQueryRows               = "db_query_rows"
+	metricDBActiveTarget            = "db_active_target"
+	metricClientTimestampViolations = "client_timestamp_violations_total"
+)
+
+// Latency buckets for a counter API, from 0.5ms to 500ms
+var httpLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.0075, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5}
+
 // Define Prometheus metrics
 var (
 	httpRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "http_requests_total",
+			Name: metricHTTPRequestsTotal,
 			Help: "Total number of HTTP requests",
 		},
 		[]string{"method", "endpoint"},
 	)
 
 	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "http_request_duration_seconds",
+		Name:    metricHTTPRequestDuration,
 		Help:    "Duration of HTTP requests",
-		Buckets: prometheus.DefBuckets,
+		Buckets: httpLatencyBuckets,
 	},
 		[]string{"method", "endpoint"})
+
+	httpPanicsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: metricPanicsTotal,
+		Help: "Total number of panics recovered from HTTP handlers",
+	})
+
+	httpRequestsShedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: metricHTTPRequestsShedTotal,
+		Help: "Total number of HTTP requests rejected by the load shedder",
+	})
+
+	httpRequestsRateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricHTTPRequestsRateLimited,
+			Help: "Total number of HTTP requests rejected by their route's rate limit",
+		},
+		[]string{"endpoint"},
+	)
+
+	httpRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: metricHTTPRequestsInFlight,
+		Help: "Number of HTTP requests currently being served",
+	})
+
+	httpResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    metricHTTPResponseSize,
+		Help:    "Size of HTTP response bodies",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 6),
+	},
+		[]string{"method", "endpoint"})
+
+	captchaVerificationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricCaptchaVerificationsTotal,
+			Help: "Total number of CAPTCHA verifications by provider and result",
+		},
+		[]string{"provider", "result"},
+	)
+
+	outboxDeliveriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricOutboxDeliveriesTotal,
+			Help: "Total number of outbox delivery attempts by destination and result",
+		},
+		[]string{"destination", "result"},
+	)
+
+	certificateExpiryDays = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: metricCertificateExpiryDays,
+			Help: "Days until the TLS certificate of each monitored domain expires",
+		},
+		[]string{"domain"},
+	)
+
+	dbQueriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricDBQueriesTotal,
+			Help: "Total number of database queries by operation and result",
+		},
+		[]string{"operation", "result"},
+	)
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    metricDBQueryDuration,
+		Help:    "Duration of database queries",
+		Buckets: httpLatencyBuckets,
+	},
+		[]string{"operation"})
+
+	dbQueryRows = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    metricDBQueryRows,
+		Help:    "Rows returned or affected by database queries",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 6),
+	},
+		[]string{"operation"})
+
+	dbActiveTarget = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: metricDBActiveTarget,
+		Help: "Which database queries go to: 0 for the primary, 1 and up for the failover databases",
+	})
+
+	clientTimestampViolationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricClientTimestampViolations,
+			Help: "Total number of client-supplied timestamps rejected or moved to now, by endpoint and violation",
+		},
+		[]string{"endpoint", "violation"},
+	)
+
+	httpRequestErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metricHTTPRequestErrorsTotal,
+			Help: "Total number of HTTP requests answered with a 4xx or 5xx status",
+		},
+		[]string{"method", "endpoint", "status_class"},
+	)
 )
 
+// prometheusCollectors are the metrics the service exposes, in the order they're registered
+var prometheusCollectors = []prometheus.Collector{
+	httpRequestsTotal,
+	httpRequestDuration,
+	httpPanicsTotal,
+	httpRequestsInFlight,
+	httpResponseSize,
+	httpRequestErrorsTotal,
+	httpRequestsShedTotal,
+	httpRequestsRateLimitedTotal,
+	captchaVerificationsTotal,
+	outboxDeliveriesTotal,
+	certificateExpiryDays,
+	dbQueriesTotal,
+	dbQueryDuration,
+	dbQueryRows,
+	dbActiveTarget,
+	clientTimestampViolationsTotal,
+}
+
 // Initialize Prometheus metrics
 func initPrometheusMetrics() {
-	prometheus.MustRegister(httpRequestsTotal)
-	prometheus.MustRegister(httpRequestDuration)
+	prometheus.MustRegister(prometheusCollectors...)
+}
+
+// prometheusMetrics emits request metrics to the Prometheus collectors above.
+type prometheusMetrics struct{}
+
+func (prometheusMetrics) RequestStarted() {
+	httpRequestsInFlight.Inc()
+}
+
+func (prometheusMetrics) RequestFinished(r *http.Request, method, endpoint string, status, size int, duration time.Duration) {
+	httpRequestsInFlight.Dec()
+	observeDuration(httpRequestDuration.WithLabelValues(method, endpoint), r, duration.Seconds())
+	httpRequestsTotal.WithLabelValues(method, endpoint).Inc()
+	httpResponseSize.WithLabelValues(method, endpoint).Observe(float64(size))
+	if class := statusClass(status); class != "" {
+		httpRequestErrorsTotal.WithLabelValues(method, endpoint, class).Inc()
+	}
+}
+
+func (prometheusMetrics) PanicRecovered() {
+	httpPanicsTotal.Inc()
+}
+
+func (prometheusMetrics) RequestShed() {
+	httpRequestsShedTotal.Inc()
 }
 
-// Prometheus middleware to track request count and duration
+func (prometheusMetrics) RequestRateLimited(endpoint string) {
+	httpRequestsRateLimitedTotal.WithLabelValues(endpoint).Inc()
+}
+
+func (prometheusMetrics) CaptchaVerified(provider, result string) {
+	captchaVerificationsTotal.WithLabelValues(provider, result).Inc()
+}
+
+func (prometheusMetrics) OutboxDelivered(destination, result string) {
+	outboxDeliveriesTotal.WithLabelValues(destination, result).Inc()
+}
+
+func (prometheusMetrics) CertificateExpiry(domain string, days float64) {
+	certificateExpiryDays.WithLabelValues(domain).Set(days)
+}
+
+func (prometheusMetrics) QueryFinished(ctx context.Context, operation, result string, rows int64, duration time.Duration) {
+	dbQueriesTotal.WithLabelValues(operation, result).Inc()
+	traceID, _ := traceIDFromContext(ctx)
+	observeWithTraceID(dbQueryDuration.WithLabelValues(operation), traceID, duration.Seconds())
+	if result == "ok" {
+		dbQueryRows.WithLabelValues(operation).Observe(float64(rows))
+	}
+}
+
+func (prometheusMetrics) DatabaseTarget(target int) {
+	dbActiveTarget.Set(float64(target))
+}
+
+func (prometheusMetrics) ClientTimestampViolation(endpoint, violation string) {
+	clientTimestampViolationsTotal.WithLabelValues(endpoint, violation).Inc()
+}
+
+// Prometheus middleware to track request count, duration, in-flight requests, response sizes and errors
 func prometheusMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		timer := prometheus.NewTimer(httpRequestDuration.WithLabelValues(r.Method, r.URL.Path))
-		defer timer.ObserveDuration()
+	return metricsMiddleware(next, prometheusMetrics{})
+}
 
-		httpRequestsTotal.WithLabelValues(r.Method, r.URL.Path).Inc()
-		next.ServeHTTP(w, r)
-	})
+// observeDuration records a duration, attaching the request's trace ID as an exemplar when tracing is enabled.
+func observeDuration(o prometheus.Observer, r *http.Request, seconds float64) {
+	var traceID string
+	if tracingEnabled {
+		traceID, _ = traceIDFromRequest(r)
+	}
+	observeWithTraceID(o, traceID, seconds)
+}
+
+// observeWithTraceID records a duration with traceID as its exemplar, unless it is empty.
+func observeWithTraceID(o prometheus.Observer, traceID string, seconds float64) {
+	if traceID != "" {
+		if eo, ok := o.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(seconds, prometheus.Labels{"trace_id": traceID})
+			return
+		}
+	}
+	o.Observe(seconds)
 }
 
 // Handle Prometheus metrics endpoint
-func handlePrometheusMetrics() {
-	http.Handle("/metrics", promhttp.Handler())
+func handlePrometheusMetrics(mux *http.ServeMux) {
+	// OpenMetrics is required for exemplars to be exposed
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	))
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
 )
 
@@ -97,6 +98,7 @@ func (m *mockRegistry) Gather() ([]*dto.MetricFamily, error) {
 			name := desc.String() // This is not ideal, but we don't have access to the actual name
 			family := &dto.MetricFamily{
 				Name:   &name,
+				Type:   metricType(dtoMetric),
 				Metric: []*dto.Metric{dtoMetric},
 			}
 			families = append(families, family)
@@ -105,6 +107,22 @@ func (m *mockRegistry) Gather() ([]*dto.MetricFamily, error) {
 	return families, nil
 }
 
+// metricType infers the family type from the populated field so the text encoder can render it
+func metricType(m *dto.Metric) *dto.MetricType {
+	t := dto.MetricType_UNTYPED
+	switch {
+	case m.Counter != nil:
+		t = dto.MetricType_COUNTER
+	case m.Gauge != nil:
+		t = dto.MetricType_GAUGE
+	case m.Histogram != nil:
+		t = dto.MetricType_HISTOGRAM
+	case m.Summary != nil:
+		t = dto.MetricType_SUMMARY
+	}
+	return &t
+}
+
 func Test_initPrometheusMetrics(t *testing.T) {
 	mockReg := newMockRegistry()
 
@@ -115,21 +133,35 @@ func Test_initPrometheusMetrics(t *testing.T) {
 
 	prometheus.DefaultRegisterer = originalRegistry
 
-	if len(mockReg.descs) != 2 {
-		t.Fatalf("Expected 2 descriptors to be registered, got %d", len(mockReg.descs))
+	if len(mockReg.descs) != 16 {
+		t.Fatalf("Expected 16 descriptors to be registered, got %d", len(mockReg.descs))
 	}
 
 	expectedMetrics := map[string]bool{
-		"http_requests_total":           false,
-		"http_request_duration_seconds": false,
+		"http_requests_total":               false,
+		"http_request_duration_seconds":     false,
+		"panics_total":                      false,
+		"http_requests_in_flight":           false,
+		"http_response_size_bytes":          false,
+		"http_request_errors_total":         false,
+		"http_requests_shed_total":          false,
+		"http_requests_rate_limited_total":  false,
+		"captcha_verifications_total":       false,
+		"outbox_deliveries_total":           false,
+		"tls_certificate_expiry_days":       false,
+		"db_queries_total":                  false,
+		"db_query_duration_seconds":         false,
+		"db_query_rows":                     false,
+		"db_active_target":                  false,
+		"client_timestamp_violations_total": false,
 	}
 
 	for _, desc := range mockReg.descs {
 		name := desc.String()
-		if strings.Contains(name, "http_requests_total") {
-			expectedMetrics["http_requests_total"] = true
-		} else if strings.Contains(name, "http_request_duration_seconds") {
-			expectedMetrics["http_request_duration_seconds"] = true
+		for metric := range expectedMetrics {
+			if strings.Contains(name, `"`+metric+`"`) {
+				expectedMetrics[metric] = true
+			}
 		}
 	}
 
@@ -175,7 +207,7 @@ func Test_handlePrometheusMetrics(t *testing.T) {
 	prometheus.DefaultRegisterer = mockReg
 	initPrometheusMetrics()
 
-	handlePrometheusMetrics()
+	handlePrometheusMetrics(http.NewServeMux())
 
 	req := httptest.NewRequest("GET", "/metrics", nil)
 	rr := httptest.NewRecorder()
@@ -195,3 +227,130 @@ func Test_handlePrometheusMetrics(t *testing.T) {
 		}
 	}
 }
+
+func Test_prometheusMiddleware_BoundedLabels(t *testing.T) {
+	httpRequestsTotal.Reset()
+	httpRequestDuration.Reset()
+	defer httpRequestsTotal.Reset()
+	defer httpRequestDuration.Reset()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/count", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := prometheusMiddleware(mux)
+
+	paths := []string{"/api/count", "/api/count", "/wp-admin", "/.env", "/random/1", "/random/2", "/api/count/../x"}
+	for _, path := range paths {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/api/count", nil))
+
+	// GET /api/count, GET other, other /api/count
+	if got := testutil.CollectAndCount(httpRequestsTotal); got != 3 {
+		t.Errorf("expected 3 label combinations, got %d", got)
+	}
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(http.MethodGet, "/api/count")); got != 2 {
+		t.Errorf("expected 2 requests for /api/count, got %v", got)
+	}
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(http.MethodGet, otherLabel)); got != 5 {
+		t.Errorf("expected 5 requests in the other bucket, got %v", got)
+	}
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues(otherLabel, "/api/count")); got != 1 {
+		t.Errorf("expected 1 request with an unknown method, got %v", got)
+	}
+}
+
+func Test_observeDuration_Exemplar(t *testing.T) {
+	tests := []struct {
+		name         string
+		tracing      bool
+		wantExemplar bool
+	}{
+		{"Tracing enabled", true, true},
+		{"Tracing disabled", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracingEnabled = tt.tracing
+			defer func() { tracingEnabled = false }()
+
+			h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Buckets: httpLatencyBuckets})
+			req := httptest.NewRequest(http.MethodGet, "/api/count", nil)
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+			observeDuration(h, req, 0.002)
+
+			m := &dto.Metric{}
+			if err := h.Write(m); err != nil {
+				t.Fatalf("could not write metric: %v", err)
+			}
+
+			var exemplarTrace string
+			for _, b := range m.GetHistogram().GetBucket() {
+				for _, l := range b.GetExemplar().GetLabel() {
+					if l.GetName() == "trace_id" {
+						exemplarTrace = l.GetValue()
+					}
+				}
+			}
+
+			if tt.wantExemplar && exemplarTrace != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("expected trace_id exemplar, got %q", exemplarTrace)
+			}
+			if !tt.wantExemplar && exemplarTrace != "" {
+				t.Errorf("expected no exemplar, got %q", exemplarTrace)
+			}
+			if m.GetHistogram().GetSampleCount() != 1 {
+				t.Errorf("expected 1 observation, got %d", m.GetHistogram().GetSampleCount())
+			}
+		})
+	}
+}
+
+func Test_prometheusMiddleware_GoldenSignals(t *testing.T) {
+	httpResponseSize.Reset()
+	httpRequestErrorsTotal.Reset()
+	defer httpResponseSize.Reset()
+	defer httpRequestErrorsTotal.Reset()
+
+	var inFlight float64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		inFlight = testutil.ToFloat64(httpRequestsInFlight)
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	handler := prometheusMiddleware(mux)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	if inFlight != 1 {
+		t.Errorf("expected 1 in-flight request while serving, got %v", inFlight)
+	}
+	if got := testutil.ToFloat64(httpRequestsInFlight); got != 0 {
+		t.Errorf("expected 0 in-flight requests after serving, got %v", got)
+	}
+	if got := testutil.ToFloat64(httpRequestErrorsTotal.WithLabelValues(http.MethodGet, "/fail", "5xx")); got != 1 {
+		t.Errorf("expected 1 5xx error, got %v", got)
+	}
+	if got := testutil.ToFloat64(httpRequestErrorsTotal.WithLabelValues(http.MethodGet, otherLabel, "4xx")); got != 1 {
+		t.Errorf("expected 1 4xx error, got %v", got)
+	}
+	if got := testutil.CollectAndCount(httpRequestErrorsTotal); got != 2 {
+		t.Errorf("expected only error statuses to be counted, got %d series", got)
+	}
+
+	m := &dto.Metric{}
+	if err := httpResponseSize.WithLabelValues(http.MethodGet, "/ok").(prometheus.Histogram).Write(m); err != nil {
+		t.Fatalf("could not write metric: %v", err)
+	}
+	if got := m.GetHistogram().GetSampleSum(); got != 5 {
+		t.Errorf("expected 5 response bytes, got %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	referrersPath         = "/api/referrers"
+	defaultReferrersLimit = 10
+	maxReferrersLimit     = 100
+)
+
+// referrerAliases maps short-link and mobile hosts onto the site they belong to
+var referrerAliases = map[string]string{
+	"lnkd.in":     "linkedin.com",
+	"t.co":        "x.com",
+	"twitter.com": "x.com",
+}
+
+// normalizeReferrer reduces a referrer URL to its lowercase domain without "www.", "m." or
+// "l." prefixes, which also drops paths and UTM parameters. Self-referrals from the origins
+// in ALLOWED_ORIGINS and anything that isn't an http(s) URL return "".
+func normalizeReferrer(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, prefix := range []string{"www.", "m.", "l."} {
+		host = strings.TrimPrefix(host, prefix)
+	}
+	if alias, ok := referrerAliases[host]; ok {
+		host = alias
+	}
+	if host == "" || isOwnHost(host) {
+		return ""
+	}
+	return host
+}
+
+// isOwnHost reports whether host serves the frontend, per ALLOWED_ORIGINS.
+func isOwnHost(host string) bool {
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		u, err := url.Parse(strings.TrimSpace(origin))
+		if err != nil {
+			continue
+		}
+		own := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		if own != "" && own == host {
+			return true
+		}
+	}
+	return false
+}
+
+// referrersResponse is the body returned by GET /api/referrers.
+type referrersResponse struct {
+	Days      int             `json:"days"`
+	Referrers []ReferrerCount `json:"referrers"`
+}
+
+// referrersHandler returns the top referring domains over the last days days. With summaries
+// on, refresh=true counts the visits live instead.
+func referrersHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	limit := defaultReferrersLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxReferrersLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxReferrersLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx, ok := statsContext(w, r)
+	if !ok {
+		return
+	}
+
+	now := clock.Now()
+	referrers, err := dataStore.GetTopReferrers(ctx, now.AddDate(0, 0, -days), now, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get referrers: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if referrers == nil {
+		referrers = []ReferrerCount{}
+	}
+
+	writeResponse(w, r, referrersResponse{Days: days, Referrers: referrers})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_normalizeReferrer(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "https://www.example.dev,http://localhost:3000")
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"https://www.linkedin.com/feed/?utm_source=share&utm_medium=member", "linkedin.com"},
+		{"https://GitHub.com/someone", "github.com"},
+		{"https://m.facebook.com/", "facebook.com"},
+		{"https://l.facebook.com/l.php?u=x", "facebook.com"},
+		{"https://lnkd.in/abc123", "linkedin.com"},
+		{"https://t.co/xyz", "x.com"},
+		{"https://news.ycombinator.com:443/item?id=1", "news.ycombinator.com"},
+		{"https://example.dev/projects", ""},
+		{"http://localhost:3000/", ""},
+		{"", ""},
+		{"not a url", ""},
+		{"javascript:alert(1)", ""},
+		{"android-app://com.google.android.gm/", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := normalizeReferrer(tt.raw); got != tt.want {
+				t.Errorf("normalizeReferrer(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_referrersHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		wantDays       int
+		wantLimit      int
+	}{
+		{"Defaults", "", http.StatusOK, defaultStatsDays, defaultReferrersLimit},
+		{"Custom window", "?days=7&limit=3", http.StatusOK, 7, 3},
+		{"Invalid days", "?days=0", http.StatusBadRequest, 0, 0},
+		{"Limit too large", "?limit=101", http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{referrers: []ReferrerCount{{Domain: "linkedin.com", Visits: 4}}}
+			w := httptest.NewRecorder()
+			referrersHandler(w, httptest.NewRequest(http.MethodGet, referrersPath+tt.query, nil), mockDataStore, clock)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response referrersResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if response.Days != tt.wantDays || len(response.Referrers) != 1 || response.Referrers[0].Domain != "linkedin.com" {
+				t.Errorf("unexpected response %+v", response)
+			}
+			if mockDataStore.lastLimit != tt.wantLimit {
+				t.Errorf("expected limit %d; got %d", tt.wantLimit, mockDataStore.lastLimit)
+			}
+			if want := clock.Now().AddDate(0, 0, -tt.wantDays); !mockDataStore.lastFrom.Equal(want) {
+				t.Errorf("expected window to start at %v; got %v", want, mockDataStore.lastFrom)
+			}
+		})
+	}
+}
+
+func Test_referrersHandler_Empty(t *testing.T) {
+	w := httptest.NewRecorder()
+	referrersHandler(w, httptest.NewRequest(http.MethodGet, referrersPath, nil), &MockDataStore{}, realClock{})
+
+	if body := w.Body.String(); body != "{\"days\":30,\"referrers\":[]}\n" {
+		t.Errorf("expected an empty list rather than null; got %s", body)
+	}
+}
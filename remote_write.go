@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	remoteWriteSeries          = "resume_visits"
+	defaultRemoteWriteInterval = time.Minute
+	defaultRemoteWriteJob      = "resume-backend"
+	remoteWriteTimeout         = 30 * time.Second
+)
+
+// remoteWriteConfig controls pushing the visit count to a Prometheus remote-write endpoint.
+type remoteWriteConfig struct {
+	URL      string
+	Interval time.Duration
+	Job      string // job label on the series
+	Username string // basic auth, e.g. the Grafana Cloud instance ID
+	Password string
+}
+
+// loadRemoteWriteConfig reads the REMOTE_WRITE_* settings. Remote write is enabled by setting REMOTE_WRITE_URL.
+func loadRemoteWriteConfig() (remoteWriteConfig, bool, error) {
+	cfg := remoteWriteConfig{
+		URL:      os.Getenv("REMOTE_WRITE_URL"),
+		Interval: defaultRemoteWriteInterval,
+		Job:      defaultRemoteWriteJob,
+		Username: os.Getenv("REMOTE_WRITE_USERNAME"),
+		Password: os.Getenv("REMOTE_WRITE_PASSWORD"),
+	}
+	if cfg.URL == "" {
+		return remoteWriteConfig{}, false, nil
+	}
+
+	if v := os.Getenv("REMOTE_WRITE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return remoteWriteConfig{}, false, fmt.Errorf("invalid REMOTE_WRITE_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.Interval = d
+	}
+	if v := os.Getenv("REMOTE_WRITE_JOB"); v != "" {
+		cfg.Job = v
+	}
+	if (cfg.Username == "") != (cfg.Password == "") {
+		return remoteWriteConfig{}, false, errors.New("REMOTE_WRITE_USERNAME and REMOTE_WRITE_PASSWORD must be set together")
+	}
+
+	log.Printf("Remote write enabled: pushing %s every %s", remoteWriteSeries, cfg.Interval)
+	return cfg, true, nil
+}
+
+// remoteSample is a single sample of a labelled series.
+type remoteSample struct {
+	Labels    map[string]string // including __name__
+	Value     float64
+	Timestamp time.Time
+}
+
+// encodeWriteRequest encodes samples as a Prometheus remote-write WriteRequest protobuf,
+// one series per sample. Only the fields the protocol requires are written.
+func encodeWriteRequest(samples []remoteSample) []byte {
+	var req []byte
+	for _, s := range samples {
+		names := make([]string, 0, len(s.Labels))
+		for name := range s.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names) // receivers expect labels sorted by name
+
+		var series []byte
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, s.Labels[name])
+
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.Timestamp.UnixMilli()))
+
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, series)
+	}
+	return req
+}
+
+// remoteWriter periodically pushes the total visit count as the resume_visits series, so
+// visit history survives in the TSDB even if old rows are pruned from the database.
+// The series is a counter; a drop after pruning reads as a counter reset to rate() and increase().
+type remoteWriter struct {
+	store  DataStore
+	cfg    remoteWriteConfig
+	clock  Clock
+	client *http.Client
+}
+
+func newRemoteWriter(store DataStore, cfg remoteWriteConfig, clock Clock) *remoteWriter {
+	return &remoteWriter{store: store, cfg: cfg, clock: clock, client: &http.Client{Timeout: remoteWriteTimeout}}
+}
+
+// Push sends the current visit count.
+func (w *remoteWriter) Push(ctx context.Context) error {
+	count, err := w.store.GetVisitCount(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := snappy.Encode(nil, encodeWriteRequest([]remoteSample{{
+		Labels:    map[string]string{"__name__": remoteWriteSeries, "job": w.cfg.Job},
+		Value:     float64(count),
+		Timestamp: w.clock.Now(),
+	}}))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create remote write request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.cfg.Username != "" {
+		req.SetBasicAuth(w.cfg.Username, w.cfg.Password)
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		errorLogger.Printf("Error sending remote write: %v", err)
+		return fmt.Errorf("failed to send remote write: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		errorLogger.Printf("Remote write rejected with status %d: %s", res.StatusCode, msg)
+		return fmt.Errorf("remote write returned status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Run pushes every Interval until ctx is done.
+func (w *remoteWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Failures are logged and the next tick sends a fresh count
+			_ = w.Push(ctx)
+		}
+	}
+}
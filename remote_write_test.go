@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodedSeries is a remote-write TimeSeries read back from the wire
+type decodedSeries struct {
+	labels    [][2]string
+	value     float64
+	timestamp int64
+}
+
+// fields splits a protobuf message into its length-delimited and fixed/varint fields
+func fields(t *testing.T, b []byte, visit func(num protowire.Number, typ protowire.Type, v []byte, n uint64)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			visit(num, typ, v, 0)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			visit(num, typ, nil, v)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			visit(num, typ, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+	}
+}
+
+func decodeWriteRequest(t *testing.T, b []byte) []decodedSeries {
+	var out []decodedSeries
+	fields(t, b, func(_ protowire.Number, _ protowire.Type, series []byte, _ uint64) {
+		var s decodedSeries
+		fields(t, series, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				var label [2]string
+				fields(t, v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+					label[num-1] = string(v)
+				})
+				s.labels = append(s.labels, label)
+			case 2:
+				fields(t, v, func(num protowire.Number, _ protowire.Type, _ []byte, n uint64) {
+					if num == 1 {
+						s.value = math.Float64frombits(n)
+					} else {
+						s.timestamp = int64(n)
+					}
+				})
+			}
+		})
+		out = append(out, s)
+	})
+	return out
+}
+
+func Test_loadRemoteWriteConfig(t *testing.T) {
+	_, enabled, err := loadRemoteWriteConfig()
+	if enabled || err != nil {
+		t.Fatalf("expected remote write to be off without REMOTE_WRITE_URL; got %v, %v", enabled, err)
+	}
+
+	t.Setenv("REMOTE_WRITE_URL", "https://prometheus.example.com/api/prom/push")
+	t.Setenv("REMOTE_WRITE_INTERVAL", "30s")
+	t.Setenv("REMOTE_WRITE_USERNAME", "12345")
+	t.Setenv("REMOTE_WRITE_PASSWORD", "secret")
+	cfg, enabled, err := loadRemoteWriteConfig()
+	if !enabled || err != nil {
+		t.Fatalf("expected remote write to be enabled; got %v, %v", enabled, err)
+	}
+	want := remoteWriteConfig{
+		URL:      "https://prometheus.example.com/api/prom/push",
+		Interval: 30 * time.Second,
+		Job:      defaultRemoteWriteJob,
+		Username: "12345",
+		Password: "secret",
+	}
+	if cfg != want {
+		t.Errorf("loadRemoteWriteConfig() = %+v, want %+v", cfg, want)
+	}
+
+	t.Setenv("REMOTE_WRITE_PASSWORD", "")
+	if _, _, err := loadRemoteWriteConfig(); err == nil {
+		t.Error("expected an error for a username without a password")
+	}
+
+	t.Setenv("REMOTE_WRITE_USERNAME", "")
+	t.Setenv("REMOTE_WRITE_INTERVAL", "soon")
+	if _, _, err := loadRemoteWriteConfig(); err == nil {
+		t.Error("expected an error for an invalid interval")
+	}
+}
+
+func Test_encodeWriteRequest(t *testing.T) {
+	ts := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	got := decodeWriteRequest(t, encodeWriteRequest([]remoteSample{
+		{Labels: map[string]string{"job": "resume-backend", "__name__": "resume_visits"}, Value: 42, Timestamp: ts},
+	}))
+
+	if len(got) != 1 {
+		t.Fatalf("expected one series; got %d", len(got))
+	}
+	wantLabels := [][2]string{{"__name__", "resume_visits"}, {"job", "resume-backend"}}
+	if len(got[0].labels) != 2 || got[0].labels[0] != wantLabels[0] || got[0].labels[1] != wantLabels[1] {
+		t.Errorf("expected sorted labels %v; got %v", wantLabels, got[0].labels)
+	}
+	if got[0].value != 42 || got[0].timestamp != ts.UnixMilli() {
+		t.Errorf("expected sample 42 at %d; got %v at %d", ts.UnixMilli(), got[0].value, got[0].timestamp)
+	}
+}
+
+func Test_remoteWriter_Push(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+
+	var series []decodedSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected encoding headers %q, %q", r.Header.Get("Content-Encoding"), r.Header.Get("Content-Type"))
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "12345" || pass != "secret" {
+			t.Errorf("expected basic auth credentials; got %q, %q", user, pass)
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("body is not snappy-compressed: %v", err)
+		}
+		series = decodeWriteRequest(t, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg := remoteWriteConfig{URL: server.URL, Job: "resume-backend", Username: "12345", Password: "secret"}
+	w := newRemoteWriter(&MockDataStore{visitCount: 7}, cfg, clock)
+	if err := w.Push(context.Background()); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if len(series) != 1 || series[0].value != 7 || series[0].timestamp != clock.Now().UnixMilli() {
+		t.Errorf("expected the visit count 7 to be pushed; got %+v", series)
+	}
+}
+
+func Test_remoteWriter_Push_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	w := newRemoteWriter(&MockDataStore{}, remoteWriteConfig{URL: server.URL}, realClock{})
+	if err := w.Push(context.Background()); err == nil {
+		t.Error("expected an error when the endpoint rejects the write")
+	}
+
+	w = newRemoteWriter(failingStore{}, remoteWriteConfig{URL: server.URL}, realClock{})
+	if err := w.Push(context.Background()); err == nil {
+		t.Error("expected an error when the visit count can't be read")
+	}
+}
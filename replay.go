@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const visitNonceHeader = "X-Visit-Nonce"
+
+// Nonces are random values the client generates, such as a UUID or 16 or more random bytes
+// in base64url, so they're long enough not to collide by chance
+var visitNoncePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// visitNonces rejects a visit whose X-Visit-Nonce was already used within the window. The
+// client picks the nonce, so with visit tokens enabled each token is also only accepted once:
+// a captured request replayed with a fresh nonce still carries a used token. Nonces and
+// tokens are claimed as dedupe keys, so a replay sent to another replica is caught as well.
+type visitNonces struct {
+	store    DataStore
+	window   time.Duration
+	required bool         // whether visits without a nonce are rejected
+	tokens   *visitTokens // nil when visit tokens are off
+}
+
+// newVisitNoncesFromEnv checks nonces when VISIT_NONCE_WINDOW is set to a positive duration,
+// returning nil otherwise. With VISIT_NONCE_REQUIRED set to true, visits must carry one.
+func newVisitNoncesFromEnv(store DataStore, tokens *visitTokens) *visitNonces {
+	v := os.Getenv("VISIT_NONCE_WINDOW")
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid VISIT_NONCE_WINDOW %q, not checking visit nonces", v)
+		return nil
+	}
+	n := &visitNonces{store: store, window: d, tokens: tokens}
+	if v := os.Getenv("VISIT_NONCE_REQUIRED"); v != "" {
+		required, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Printf("Invalid VISIT_NONCE_REQUIRED %q, accepting visits without a nonce", v)
+		}
+		n.required = required
+	}
+	return n
+}
+
+// Check returns the message to reject r with, or "" if it may be counted, claiming its nonce
+// and visit token. Store failures let the visit through, as the visit token still bounds
+// replays to its lifetime.
+func (n *visitNonces) Check(r *http.Request) string {
+	nonce := r.Header.Get(visitNonceHeader)
+	if (nonce != "" || n.required) && !visitNoncePattern.MatchString(nonce) {
+		return "visit_nonce_invalid"
+	}
+	// visitTokenMiddleware has checked the token, so it is claimed for no longer than it's valid
+	if token := r.Header.Get(visitTokenHeader); n.tokens != nil && token != "" {
+		claimed, err := n.store.ClaimDedupeKey(r.Context(), "token:"+token, n.tokens.ttl)
+		if err == nil && !claimed {
+			return "visit_token_replayed"
+		}
+	}
+	if nonce == "" {
+		return ""
+	}
+	claimed, err := n.store.ClaimDedupeKey(r.Context(), "nonce:"+nonce, n.window)
+	if err != nil || claimed {
+		return ""
+	}
+	return "visit_nonce_replayed"
+}
+
+// middleware that rejects visits replaying a nonce seen within the window or a visit token
+// already used, or missing a nonce when nonces are required
+func visitNonceMiddleware(next http.Handler, nonces *visitNonces) http.Handler {
+	if nonces == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			switch message := nonces.Check(r); message {
+			case "":
+			case "visit_nonce_invalid":
+				http.Error(w, localize(w, r, message), http.StatusBadRequest)
+				return
+			default:
+				forbiddenLogger.Printf("Replayed visit: %s %s", r.Method, r.URL.Path)
+				http.Error(w, localize(w, r, message), http.StatusConflict)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
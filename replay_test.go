@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_visitNonceMiddleware(t *testing.T) {
+	nonces := &visitNonces{store: &MockDataStore{}, window: time.Hour}
+	counted := 0
+	handler := visitNonceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counted++
+	}), nonces)
+
+	post := func(nonce string) int {
+		req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+		if nonce != "" {
+			req.Header.Set(visitNonceHeader, nonce)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	nonce := "4f1c8a2e-9b7d-4e3a-8c6f-2d5b9e1a7c30"
+	if code := post(nonce); code != http.StatusOK {
+		t.Fatalf("expected a fresh nonce to be counted, got %d", code)
+	}
+	if code := post(nonce); code != http.StatusConflict {
+		t.Errorf("expected a replayed nonce to be rejected with 409, got %d", code)
+	}
+	if code := post("short"); code != http.StatusBadRequest {
+		t.Errorf("expected a malformed nonce to be rejected with 400, got %d", code)
+	}
+
+	// A nonce is optional unless required
+	if code := post(""); code != http.StatusOK {
+		t.Errorf("expected a visit without a nonce to be counted, got %d", code)
+	}
+	nonces.required = true
+	if code := post(""); code != http.StatusBadRequest {
+		t.Errorf("expected a missing nonce to be rejected once required, got %d", code)
+	}
+	if counted != 2 {
+		t.Errorf("expected 2 visits counted, got %d", counted)
+	}
+
+	// Reads aren't checked
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, apiPath, nil))
+	if counted != 3 {
+		t.Errorf("expected GET to pass through; counted %d", counted)
+	}
+}
+
+func Test_visitNonceMiddleware_TokenReplay(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	tokens := &visitTokens{secret: []byte("token-secret"), ttl: 10 * time.Minute}
+	nonces := &visitNonces{store: &MockDataStore{}, window: time.Hour, tokens: tokens}
+	counted := 0
+	handler := visitTokenMiddleware(visitNonceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counted++
+	}), nonces), tokens, clock)
+
+	post := func(token, nonce string) int {
+		req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+		req.Header.Set(visitTokenHeader, token)
+		req.Header.Set(visitNonceHeader, nonce)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	token, _ := tokens.Issue(httptest.NewRequest(http.MethodGet, visitTokenPath, nil), clock.Now())
+	if code := post(token, "4f1c8a2e-9b7d-4e3a-8c6f-2d5b9e1a7c30"); code != http.StatusOK {
+		t.Fatalf("expected the first visit to be counted, got %d", code)
+	}
+	// A captured request replayed with a fresh nonce still carries the used token
+	if code := post(token, "0d9e7b1c-3a5f-4c2e-9b8d-6f1a2c4e8b70"); code != http.StatusConflict {
+		t.Errorf("expected a replay with a fresh nonce to be rejected with 409, got %d", code)
+	}
+	clock.Advance(time.Second)
+	token, _ = tokens.Issue(httptest.NewRequest(http.MethodGet, visitTokenPath, nil), clock.Now())
+	if code := post(token, "0d9e7b1c-3a5f-4c2e-9b8d-6f1a2c4e8b70"); code != http.StatusOK {
+		t.Errorf("expected a visit with a new token to be counted, got %d", code)
+	}
+	if counted != 2 {
+		t.Errorf("expected 2 visits counted, got %d", counted)
+	}
+}
+
+func Test_visitNonceMiddleware_StoreError(t *testing.T) {
+	counted := 0
+	handler := visitNonceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counted++
+	}), &visitNonces{store: failingStore{}, window: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+		req.Header.Set(visitNonceHeader, "AAAAAAAAAAAAAAAAAAAAAA")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if counted != 2 {
+		t.Errorf("expected store failures to let visits through; counted %d", counted)
+	}
+}
+
+func Test_newVisitNoncesFromEnv(t *testing.T) {
+	if newVisitNoncesFromEnv(&MockDataStore{}, nil) != nil {
+		t.Error("expected nonces off without VISIT_NONCE_WINDOW")
+	}
+	t.Setenv("VISIT_NONCE_WINDOW", "24h")
+	t.Setenv("VISIT_NONCE_REQUIRED", "true")
+	tokens := &visitTokens{ttl: time.Minute}
+	n := newVisitNoncesFromEnv(&MockDataStore{}, tokens)
+	if n == nil || n.window != 24*time.Hour || !n.required || n.tokens != tokens {
+		t.Errorf("expected a required 24h window, got %+v", n)
+	}
+	t.Setenv("VISIT_NONCE_WINDOW", "forever")
+	if newVisitNoncesFromEnv(&MockDataStore{}, nil) != nil {
+		t.Error("expected an invalid window to leave nonces off")
+	}
+}
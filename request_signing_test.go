@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_loadSigningConfig(t *testing.T) {
+	t.Setenv("SIGNING_SECRET", "")
+	t.Setenv("SIGNED_ROUTES", "/api/events")
+	if loadSigningConfig().Enabled() {
+		t.Error("expected signing disabled without a secret")
+	}
+
+	t.Setenv("SIGNING_SECRET", "s3cret")
+	t.Setenv("SIGNED_ROUTES", "/api/events, /healthz")
+	t.Setenv("SIGNING_MAX_SKEW", "30s")
+	cfg := loadSigningConfig()
+	if !cfg.Enabled() || !cfg.Routes["/api/events"] || cfg.Routes["/healthz"] {
+		t.Errorf("unexpected routes %v", cfg.Routes)
+	}
+	if cfg.MaxSkew != 30*time.Second {
+		t.Errorf("expected a 30s skew, got %s", cfg.MaxSkew)
+	}
+}
+
+func Test_signatureMiddleware(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	handler := signatureMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}), signingConfig{Secret: secret, Routes: map[string]bool{eventsPath: true}, MaxSkew: time.Minute}, newFakeClock(now))
+
+	const body = `{"name":"deploy"}`
+	sign := func(signedAt time.Time, body string) (string, string) {
+		ts := strconv.FormatInt(signedAt.Unix(), 10)
+		return ts, signaturePrefix + requestSignature(secret, http.MethodPost, eventsPath+"?env=prod", ts, []byte(body))
+	}
+	validTS, validSig := sign(now, body)
+	aheadTS, aheadSig := sign(now.Add(30*time.Second), body)
+	skewedTS, skewedSig := sign(now.Add(-2*time.Minute), body)
+
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		timestamp string
+		signature string
+		want      int
+	}{
+		{"Valid signature", eventsPath + "?env=prod", body, validTS, validSig, http.StatusOK},
+		{"Clock slightly ahead", eventsPath + "?env=prod", body, aheadTS, aheadSig, http.StatusOK},
+		{"Missing signature", eventsPath + "?env=prod", body, validTS, "", http.StatusUnauthorized},
+		{"Missing timestamp", eventsPath + "?env=prod", body, "", validSig, http.StatusUnauthorized},
+		{"Tampered body", eventsPath + "?env=prod", `{"name":"other"}`, validTS, validSig, http.StatusUnauthorized},
+		{"Tampered query", eventsPath + "?env=staging", body, validTS, validSig, http.StatusUnauthorized},
+		{"Replayed later", eventsPath + "?env=prod", body, skewedTS, skewedSig, http.StatusUnauthorized},
+		{"Unprotected route", apiPath, body, "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.timestamp != "" {
+				req.Header.Set(signatureTimestampHeader, tt.timestamp)
+			}
+			if tt.signature != "" {
+				req.Header.Set(signatureHeader, tt.signature)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("expected %d; got %d", tt.want, rr.Code)
+			}
+			// The handler still reads the verified body
+			if tt.want == http.StatusOK && rr.Body.String() != tt.body {
+				t.Errorf("expected the body passed on, got %q", rr.Body.String())
+			}
+		})
+	}
+}
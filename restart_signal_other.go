@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Passing listeners to a new process needs Unix file descriptors
+var restartSignals []os.Signal
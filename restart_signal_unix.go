@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals that start a graceful restart, as for nginx's binary upgrade
+var restartSignals = []os.Signal{syscall.SIGUSR2}
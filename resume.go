@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	resumePath         = "/api/resume"
+	resumeDraftPath    = "/api/admin/resume/draft"
+	resumeVersionsPath = "/api/admin/resume/versions"
+	publishResumePath  = "/api/admin/resume/versions/{id}/publish"
+
+	maxResumeBodyBytes    = 256 << 10
+	maxResumeEntries      = 100 // per section
+	maxResumeHighlights   = 20  // per position
+	maxResumeFieldLength  = 200
+	maxResumeTextLength   = 5000
+	resumeMonthLayout     = "2006-01"
+	defaultResumeVersions = 20
+	maxResumeVersions     = 100
+)
+
+// resumeContent is the structured resume one version holds.
+type resumeContent struct {
+	Skills     []resumeSkill      `json:"skills"`
+	Experience []resumeExperience `json:"experience"`
+	Education  []resumeEducation  `json:"education"`
+}
+
+type resumeSkill struct {
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	Level    string `json:"level,omitempty"`
+}
+
+// resumeExperience is one position held; an empty End means it is the current one.
+type resumeExperience struct {
+	Company    string   `json:"company"`
+	Title      string   `json:"title"`
+	Location   string   `json:"location,omitempty"`
+	Start      string   `json:"start"`
+	End        string   `json:"end,omitempty"`
+	Summary    string   `json:"summary,omitempty"`
+	Highlights []string `json:"highlights,omitempty"`
+}
+
+type resumeEducation struct {
+	Institution string `json:"institution"`
+	Degree      string `json:"degree,omitempty"`
+	Field       string `json:"field,omitempty"`
+	Start       string `json:"start,omitempty"`
+	End         string `json:"end,omitempty"`
+}
+
+// resumeResponse is the body returned by GET /api/resume.
+type resumeResponse struct {
+	Version     int64              `json:"version"`
+	PublishedAt time.Time          `json:"published_at"`
+	Skills      []resumeSkill      `json:"skills"`
+	Experience  []resumeExperience `json:"experience"`
+	Education   []resumeEducation  `json:"education"`
+}
+
+// resumeVersionResponse is one version as the admin endpoints return it.
+type resumeVersionResponse struct {
+	ID          int64         `json:"id"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	PublishedAt *time.Time    `json:"published_at"` // null for the draft
+	Content     resumeContent `json:"content"`
+}
+
+type resumeVersionsResponse struct {
+	Versions []resumeVersionResponse `json:"versions"`
+}
+
+// checkText trims s and checks it is at most max characters, and not empty when required.
+func checkText(s *string, field string, max int, required bool) error {
+	*s = strings.TrimSpace(*s)
+	if required && *s == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	if utf8.RuneCountInString(*s) > max {
+		return fmt.Errorf("%s must be at most %d characters", field, max)
+	}
+	return nil
+}
+
+// checkMonths checks that start and end are YYYY-MM months, when set, and in order.
+func checkMonths(field, start, end string, startRequired bool) error {
+	var from, to time.Time
+	var err error
+	if start != "" || startRequired {
+		if from, err = time.Parse(resumeMonthLayout, start); err != nil {
+			return fmt.Errorf("%s.start must be a YYYY-MM month", field)
+		}
+	}
+	if end != "" {
+		if to, err = time.Parse(resumeMonthLayout, end); err != nil {
+			return fmt.Errorf("%s.end must be a YYYY-MM month", field)
+		}
+		if start != "" && to.Before(from) {
+			return fmt.Errorf("%s ends before it starts", field)
+		}
+	}
+	return nil
+}
+
+// fillSections sets c's missing sections to empty ones, so they encode as arrays.
+func (c *resumeContent) fillSections() {
+	if c.Skills == nil {
+		c.Skills = []resumeSkill{}
+	}
+	if c.Experience == nil {
+		c.Experience = []resumeExperience{}
+	}
+	if c.Education == nil {
+		c.Education = []resumeEducation{}
+	}
+}
+
+// validate checks c and trims its text, leaving every section an array rather than null.
+func (c *resumeContent) validate() error {
+	if len(c.Skills) > maxResumeEntries || len(c.Experience) > maxResumeEntries || len(c.Education) > maxResumeEntries {
+		return fmt.Errorf("each section can have at most %d entries", maxResumeEntries)
+	}
+	c.fillSections()
+
+	for i := range c.Skills {
+		s := &c.Skills[i]
+		field := fmt.Sprintf("skills[%d]", i)
+		if err := errors.Join(
+			checkText(&s.Name, field+".name", maxResumeFieldLength, true),
+			checkText(&s.Category, field+".category", maxResumeFieldLength, false),
+			checkText(&s.Level, field+".level", maxResumeFieldLength, false),
+		); err != nil {
+			return err
+		}
+	}
+	for i := range c.Experience {
+		e := &c.Experience[i]
+		field := fmt.Sprintf("experience[%d]", i)
+		if err := errors.Join(
+			checkText(&e.Company, field+".company", maxResumeFieldLength, true),
+			checkText(&e.Title, field+".title", maxResumeFieldLength, true),
+			checkText(&e.Location, field+".location", maxResumeFieldLength, false),
+			checkText(&e.Summary, field+".summary", maxResumeTextLength, false),
+			checkMonths(field, e.Start, e.End, true),
+		); err != nil {
+			return err
+		}
+		if len(e.Highlights) > maxResumeHighlights {
+			return fmt.Errorf("%s can have at most %d highlights", field, maxResumeHighlights)
+		}
+		for j := range e.Highlights {
+			if err := checkText(&e.Highlights[j], fmt.Sprintf("%s.highlights[%d]", field, j), maxResumeTextLength, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range c.Education {
+		e := &c.Education[i]
+		field := fmt.Sprintf("education[%d]", i)
+		if err := errors.Join(
+			checkText(&e.Institution, field+".institution", maxResumeFieldLength, true),
+			checkText(&e.Degree, field+".degree", maxResumeFieldLength, false),
+			checkText(&e.Field, field+".field", maxResumeFieldLength, false),
+			checkMonths(field, e.Start, e.End, false),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeResumeContent reads a version's stored content.
+func decodeResumeContent(v ResumeVersion) (resumeContent, error) {
+	var c resumeContent
+	if err := json.Unmarshal(v.Content, &c); err != nil {
+		return resumeContent{}, fmt.Errorf("invalid content in resume version %d: %w", v.ID, err)
+	}
+	c.fillSections()
+	return c, nil
+}
+
+// writeResumeVersion writes v as the admin endpoints return it.
+func writeResumeVersion(w http.ResponseWriter, r *http.Request, v ResumeVersion) {
+	content, err := decodeResumeContent(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read resume version: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, resumeVersionResponse{ID: v.ID, CreatedAt: v.CreatedAt, UpdatedAt: v.UpdatedAt, PublishedAt: v.PublishedAt, Content: content})
+}
+
+// resumeHandler returns the published resume.
+func resumeHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v, found, err := dataStore.GetPublishedResume(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get resume: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "No resume has been published", http.StatusNotFound)
+		return
+	}
+	content, err := decodeResumeContent(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get resume: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, r, resumeResponse{
+		Version:     v.ID,
+		PublishedAt: *v.PublishedAt,
+		Skills:      content.Skills,
+		Experience:  content.Experience,
+		Education:   content.Education,
+	})
+}
+
+// resumeDraftHandler returns the resume's draft with GET and replaces it with PUT, starting a
+// new draft when the last one was published.
+func resumeDraftHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		v, found, err := dataStore.GetResumeDraft(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get resume draft: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "There is no resume draft", http.StatusNotFound)
+			return
+		}
+		writeResumeVersion(w, r, v)
+		return
+	}
+
+	var content resumeContent
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxResumeBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&content); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid resume body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := content.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode resume: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	v, err := dataStore.SaveResumeDraft(r.Context(), encoded)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save resume draft: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeResumeVersion(w, r, v)
+}
+
+// resumeVersionsHandler lists the resume's versions, newest first.
+func resumeVersionsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+
+	limit := defaultResumeVersions
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxResumeVersions {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxResumeVersions), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	versions, err := dataStore.ListResumeVersions(r.Context(), limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list resume versions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp := resumeVersionsResponse{Versions: make([]resumeVersionResponse, len(versions))}
+	for i, v := range versions {
+		content, err := decodeResumeContent(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list resume versions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		resp.Versions[i] = resumeVersionResponse{ID: v.ID, CreatedAt: v.CreatedAt, UpdatedAt: v.UpdatedAt, PublishedAt: v.PublishedAt, Content: content}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, resp)
+}
+
+// publishResumeHandler publishes a version: the draft, or an older version to roll back to.
+func publishResumeHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		http.Error(w, "Invalid resume version", http.StatusBadRequest)
+		return
+	}
+	v, found, err := dataStore.PublishResumeVersion(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to publish resume version: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "No resume version with that ID", http.StatusNotFound)
+		return
+	}
+	writeResumeVersion(w, r, v)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_resumeContent_validate(t *testing.T) {
+	c := resumeContent{
+		Skills:     []resumeSkill{{Name: " Go ", Level: "expert "}},
+		Experience: []resumeExperience{{Company: "Acme", Title: " Engineer", Start: "2021-04", Highlights: []string{" Shipped the API "}}},
+	}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Skills[0] != (resumeSkill{Name: "Go", Level: "expert"}) || c.Experience[0].Title != "Engineer" || c.Experience[0].Highlights[0] != "Shipped the API" {
+		t.Errorf("expected the text trimmed; got %+v", c)
+	}
+	if c.Education == nil {
+		t.Error("expected an empty section as an array")
+	}
+
+	for name, c := range map[string]resumeContent{
+		"unnamed skill":      {Skills: []resumeSkill{{Level: "expert"}}},
+		"long skill":         {Skills: []resumeSkill{{Name: strings.Repeat("x", maxResumeFieldLength+1)}}},
+		"too many skills":    {Skills: make([]resumeSkill, maxResumeEntries+1)},
+		"no start":           {Experience: []resumeExperience{{Company: "Acme", Title: "Engineer"}}},
+		"bad start":          {Experience: []resumeExperience{{Company: "Acme", Title: "Engineer", Start: "2021-04-01"}}},
+		"ends before starts": {Experience: []resumeExperience{{Company: "Acme", Title: "Engineer", Start: "2021-04", End: "2020-12"}}},
+		"empty highlight":    {Experience: []resumeExperience{{Company: "Acme", Title: "Engineer", Start: "2021-04", Highlights: []string{" "}}}},
+		"no institution":     {Education: []resumeEducation{{Degree: "BSc"}}},
+		"bad end":            {Education: []resumeEducation{{Institution: "State University", End: "June 2018"}}},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func Test_resumeHandlers(t *testing.T) {
+	mockDataStore := &MockDataStore{}
+	mux := http.NewServeMux()
+	mux.HandleFunc(resumePath, func(w http.ResponseWriter, r *http.Request) {
+		resumeHandler(w, r, mockDataStore)
+	})
+	mux.HandleFunc(resumeDraftPath, func(w http.ResponseWriter, r *http.Request) {
+		resumeDraftHandler(w, r, mockDataStore, "admin-token")
+	})
+	mux.HandleFunc(resumeVersionsPath, func(w http.ResponseWriter, r *http.Request) {
+		resumeVersionsHandler(w, r, mockDataStore, "admin-token")
+	})
+	mux.HandleFunc(publishResumePath, func(w http.ResponseWriter, r *http.Request) {
+		publishResumeHandler(w, r, mockDataStore, "admin-token")
+	})
+	do := func(method, path, auth, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Nothing is served until a version is published
+	if rr := do(http.MethodGet, resumePath, "", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, resumeDraftPath, "", `{"skills": [{"name": "Go"}]}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, resumeDraftPath, "Bearer admin-token", `{"skills": [{"name": "Go", "years": 8}]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown fields rejected, got %d", rr.Code)
+	}
+
+	rr := do(http.MethodPut, resumeDraftPath, "Bearer admin-token", `{"skills": [{"name": "Go"}]}`)
+	var draft resumeVersionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &draft); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected the draft saved, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if draft.PublishedAt != nil || draft.Content.Experience == nil {
+		t.Errorf("expected an unpublished draft with empty sections as arrays; got %s", rr.Body.String())
+	}
+	if rr := do(http.MethodGet, resumePath, "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected the draft not served, got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodPost, "/api/admin/resume/versions/1/publish", "Bearer admin-token", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = do(http.MethodGet, resumePath, "", "")
+	var published resumeResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &published); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if published.Version != 1 || len(published.Skills) != 1 || published.Skills[0].Name != "Go" {
+		t.Errorf("expected version 1 published; got %s", rr.Body.String())
+	}
+
+	// Saving after publishing starts a new draft, leaving the published version served
+	do(http.MethodPut, resumeDraftPath, "Bearer admin-token", `{"skills": [{"name": "Rust"}]}`)
+	do(http.MethodPost, "/api/admin/resume/versions/2/publish", "Bearer admin-token", "")
+	rr = do(http.MethodGet, resumeVersionsPath, "Bearer admin-token", "")
+	var list resumeVersionsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Versions) != 2 || list.Versions[0].ID != 2 {
+		t.Fatalf("expected both versions newest first; got %s, %v", rr.Body.String(), err)
+	}
+
+	// Publishing an older version again rolls back to it
+	do(http.MethodPost, "/api/admin/resume/versions/1/publish", "Bearer admin-token", "")
+	rr = do(http.MethodGet, resumePath, "", "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &published); err != nil || published.Version != 1 {
+		t.Errorf("expected version 1 served after the rollback; got %s", rr.Body.String())
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, resumeDraftPath, http.StatusNotFound},
+		{http.MethodPost, "/api/admin/resume/versions/9/publish", http.StatusNotFound},
+		{http.MethodPost, "/api/admin/resume/versions/abc/publish", http.StatusBadRequest},
+		{http.MethodGet, resumeVersionsPath + "?limit=101", http.StatusBadRequest},
+		{http.MethodDelete, resumeDraftPath, http.StatusMethodNotAllowed},
+		{http.MethodPost, resumePath, http.StatusMethodNotAllowed},
+	} {
+		if rr := do(tt.method, tt.path, "Bearer admin-token", ""); rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tt.method, tt.path, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// How long a client's rate limit bucket is kept once full again
+const rateLimitIdleTimeout = 10 * time.Minute
+
+// Auth requirements a route policy can set
+const (
+	routeAuthNone        = "none"
+	routeAuthAdmin       = "admin"        // every request needs admin authorization
+	routeAuthAdminWrites = "admin_writes" // requests other than GET and HEAD need it
+)
+
+// routePolicy is what the route wrapper enforces for one API route before its handler runs.
+// Zero values leave the route alone.
+type routePolicy struct {
+	RateLimit float64       `yaml:"rate_limit"` // requests per second per client IP; 0 is unlimited
+	Burst     int           `yaml:"burst"`      // requests a client may make at once; at least the rate limit, rounded up
+	Auth      string        `yaml:"auth"`       // "none", "admin" or "admin_writes"
+	Timeout   time.Duration `yaml:"timeout"`    // deadline on the request's context, which its queries run under
+	Cache     string        `yaml:"cache"`      // Cache-Control sent unless the handler sets its own
+}
+
+// defaultRoutePolicies are the policies of the API routes, by the pattern they're registered
+// with. ROUTES_FILE can change them and add more.
+var defaultRoutePolicies = map[string]routePolicy{
+	deploysPath:          {Auth: routeAuthAdminWrites},
+	failedJobsPath:       {Auth: routeAuthAdmin, Cache: "no-store"},
+	retryJobPath:         {Auth: routeAuthAdmin, Cache: "no-store"},
+	visitsExportPath:     {Auth: routeAuthAdmin, Cache: "no-store"},
+	maintenancePath:      {Auth: routeAuthAdmin, Cache: "no-store"},
+	adminProjectsPath:    {Auth: routeAuthAdmin, Cache: "no-store"},
+	adminProjectPath:     {Auth: routeAuthAdmin, Cache: "no-store"},
+	resumeDraftPath:      {Auth: routeAuthAdmin, Cache: "no-store"},
+	resumeVersionsPath:   {Auth: routeAuthAdmin, Cache: "no-store"},
+	publishResumePath:    {Auth: routeAuthAdmin, Cache: "no-store"},
+	adminPostsPath:       {Auth: routeAuthAdmin, Cache: "no-store"},
+	adminPostPath:        {Auth: routeAuthAdmin, Cache: "no-store"},
+	adminShortLinksPath:  {Auth: routeAuthAdmin, Cache: "no-store"},
+	adminShortLinkPath:   {Auth: routeAuthAdmin, Cache: "no-store"},
+	adminShortLinkQRPath: {Auth: routeAuthAdmin},
+}
+
+// loadRoutePolicies returns the default policies updated from ROUTES_FILE, a YAML map of
+// API route patterns to policies, such as:
+//
+//	/api/count:
+//	  rate_limit: 2
+//	  burst: 10
+//	  timeout: 2s
+//
+// A route's fields left out of the file keep their defaults.
+func loadRoutePolicies() (map[string]routePolicy, error) {
+	policies := copyRoutePolicies(defaultRoutePolicies)
+	path := os.Getenv("ROUTES_FILE")
+	if path == "" {
+		return policies, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ROUTES_FILE: %w", err)
+	}
+	var nodes map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to parse ROUTES_FILE %s: %w", path, err)
+	}
+	for route, node := range nodes {
+		if !strings.HasPrefix(route, "/api/") {
+			return nil, fmt.Errorf("invalid route %q in ROUTES_FILE: must be an API path", route)
+		}
+		// Decoded again from its YAML, as only a Decoder can reject unknown fields
+		data, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy for %s in ROUTES_FILE: %w", route, err)
+		}
+		p := policies[route]
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("invalid policy for %s in ROUTES_FILE: %w", route, err)
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("invalid policy for %s in ROUTES_FILE: %w", route, err)
+		}
+		policies[route] = p
+	}
+	return policies, nil
+}
+
+func copyRoutePolicies(policies map[string]routePolicy) map[string]routePolicy {
+	c := make(map[string]routePolicy, len(policies))
+	for route, p := range policies {
+		c[route] = p
+	}
+	return c
+}
+
+func (p routePolicy) validate() error {
+	switch {
+	case p.RateLimit < 0 || math.IsNaN(p.RateLimit) || math.IsInf(p.RateLimit, 0):
+		return fmt.Errorf("rate_limit must be a positive number of requests per second")
+	case p.Burst < 0:
+		return fmt.Errorf("burst must not be negative")
+	case p.Timeout < 0:
+		return fmt.Errorf("timeout must not be negative")
+	}
+	switch p.Auth {
+	case "", routeAuthNone, routeAuthAdmin, routeAuthAdminWrites:
+	default:
+		return fmt.Errorf("auth must be %s, %s or %s", routeAuthNone, routeAuthAdmin, routeAuthAdminWrites)
+	}
+	return nil
+}
+
+// rateLimiter is a token bucket per client, refilled at rate and holding up to burst
+// requests. Limits are kept in memory, so each replica enforces its own.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	b := math.Max(float64(burst), math.Ceil(rate))
+	return &rateLimiter{rate: rate, burst: math.Max(b, 1), buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes a token from key's bucket, reporting whether there was one and, if not, how
+// long until there will be.
+func (l *rateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) > rateLimitIdleTimeout {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets of clients idle long enough to have refilled.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) > rateLimitIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// routePolicyMiddleware applies the policy of the route mux matches each request to, so
+// limits, auth, timeouts and caching are declared per route rather than wrapped around each
+// handler.
+func routePolicyMiddleware(mux *http.ServeMux, policies map[string]routePolicy, adminToken string, clock Clock) http.Handler {
+	limiters := make(map[string]*rateLimiter)
+	for route, p := range policies {
+		if p.RateLimit > 0 {
+			limiters[route] = newRateLimiter(p.RateLimit, p.Burst)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		// Set ahead of the mux so requests rejected here are labelled with their route
+		r.Pattern = pattern
+		route := routeLabel(r)
+		p, ok := policies[route]
+		if !ok {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		if limiter := limiters[route]; limiter != nil {
+			if ok, wait := limiter.Allow(clientIP(r), clock.Now()); !ok {
+				appMetrics.RequestRateLimited(route)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeJSONError(w, r, http.StatusTooManyRequests, "rate_limited")
+				return
+			}
+		}
+		switch p.Auth {
+		case routeAuthAdmin:
+			if !adminAuthorized(w, r, adminToken) {
+				return
+			}
+		case routeAuthAdminWrites:
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && !adminAuthorized(w, r, adminToken) {
+				return
+			}
+		}
+		if p.Timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), p.Timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		if p.Cache != "" {
+			w.Header().Set("Cache-Control", p.Cache)
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
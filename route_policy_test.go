@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeRoutesFile(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ROUTES_FILE", path)
+}
+
+func Test_loadRoutePolicies(t *testing.T) {
+	writeRoutesFile(t, `
+/api/count:
+  rate_limit: 2
+  burst: 10
+  timeout: 2s
+/api/admin/maintenance:
+  timeout: 30s
+`)
+	policies, err := loadRoutePolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (routePolicy{RateLimit: 2, Burst: 10, Timeout: 2 * time.Second}); policies[apiPath] != want {
+		t.Errorf("expected %+v for %s, got %+v", want, apiPath, policies[apiPath])
+	}
+	// Fields left out keep their defaults
+	if want := (routePolicy{Auth: routeAuthAdmin, Cache: "no-store", Timeout: 30 * time.Second}); policies[maintenancePath] != want {
+		t.Errorf("expected %+v for %s, got %+v", want, maintenancePath, policies[maintenancePath])
+	}
+	if !reflect.DeepEqual(defaultRoutePolicies[maintenancePath], routePolicy{Auth: routeAuthAdmin, Cache: "no-store"}) {
+		t.Error("expected the defaults left untouched")
+	}
+
+	for name, content := range map[string]string{
+		"Not an API path": "/count:\n  rate_limit: 1\n",
+		"Unknown field":   "/api/count:\n  rate: 1\n",
+		"Negative rate":   "/api/count:\n  rate_limit: -1\n",
+		"Unknown auth":    "/api/count:\n  auth: basic\n",
+		"Bad timeout":     "/api/count:\n  timeout: soon\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeRoutesFile(t, content)
+			if _, err := loadRoutePolicies(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func Test_rateLimiter(t *testing.T) {
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(2, 3)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("203.0.113.7", now); !ok {
+			t.Fatalf("expected request %d within the burst to be allowed", i+1)
+		}
+	}
+	ok, wait := limiter.Allow("203.0.113.7", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("expected a rejection with a 500ms wait, got %v and %s", ok, wait)
+	}
+	if ok, _ := limiter.Allow("198.51.100.2", now); !ok {
+		t.Error("expected other clients to have their own bucket")
+	}
+	if ok, _ := limiter.Allow("203.0.113.7", now.Add(500*time.Millisecond)); !ok {
+		t.Error("expected a request once a token was refilled")
+	}
+
+	// Idle clients are forgotten
+	limiter.Allow("198.51.100.2", now.Add(time.Hour))
+	if len(limiter.buckets) != 1 {
+		t.Errorf("expected idle buckets pruned, got %d", len(limiter.buckets))
+	}
+}
+
+func Test_routePolicyMiddleware(t *testing.T) {
+	m := useFakeMetrics(t)
+	var deadline bool
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPath, func(w http.ResponseWriter, r *http.Request) {
+		_, deadline = r.Context().Deadline()
+	})
+	mux.HandleFunc(deploysPath, func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc(retryJobPath, func(w http.ResponseWriter, r *http.Request) {})
+	policies := map[string]routePolicy{
+		apiPath:      {RateLimit: 1, Burst: 1, Timeout: time.Second, Cache: "no-cache"},
+		deploysPath:  {Auth: routeAuthAdminWrites},
+		retryJobPath: {Auth: routeAuthAdmin},
+	}
+	handler := routePolicyMiddleware(mux, policies, "secret", newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)))
+
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodGet, apiPath, "")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" || !deadline {
+		t.Errorf("expected the cache policy and timeout applied, got %d, %q and deadline %v", w.Code, w.Header().Get("Cache-Control"), deadline)
+	}
+	w = serve(http.MethodGet, apiPath, "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 with Retry-After 1, got %d and %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// A forged X-Forwarded-For doesn't get a fresh bucket
+	req := httptest.NewRequest(http.MethodGet, apiPath, nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the forged address limited too, got %d", w.Code)
+	}
+	if !reflect.DeepEqual(m.rateLimited, []string{apiPath, apiPath}) {
+		t.Errorf("expected the rejection counted, got %v", m.rateLimited)
+	}
+
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, deploysPath, "", http.StatusOK},
+		{http.MethodPost, deploysPath, "", http.StatusUnauthorized},
+		{http.MethodPost, deploysPath, "secret", http.StatusOK},
+		{http.MethodPost, "/api/admin/jobs/7/retry", "wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/api/admin/jobs/7/retry", "secret", http.StatusOK},
+		{http.MethodGet, "/api/unknown", "", http.StatusNotFound},
+	} {
+		if w := serve(tt.method, tt.path, tt.token); w.Code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.want, w.Code)
+		}
+	}
+}
+
+func Test_routePolicyMiddleware_Timeout(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(statsPath, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		if r.Context().Err() != context.DeadlineExceeded {
+			t.Errorf("expected the deadline to cancel the request, got %v", r.Context().Err())
+		}
+	})
+	handler := routePolicyMiddleware(mux, map[string]routePolicy{statsPath: {Timeout: time.Millisecond}}, "", realClock{})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, statsPath, nil))
+}
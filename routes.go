@@ -0,0 +1,258 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+// registerRoutes wires the health checks, API handlers and metrics endpoint onto mux.
+func registerRoutes(mux *http.ServeMux, dataStore DataStore, clock Clock) {
+	mux.HandleFunc("/healthz", healthAndReadyHandler)
+	mux.HandleFunc("/readyz", healthAndReadyHandler)
+	mux.HandleFunc(openAPIPath, openAPIHandler)
+
+	// API routes share one middleware chain so limits like load shedding apply across them
+	api := http.NewServeMux()
+	hub := newCountHub()
+	dataStore = newNotifyingStore(dataStore, hub)
+	hasher := newVisitorHasherFromEnv()
+	sketches := newVisitorSketches(dataStore, loadSketchFlushInterval())
+	exps := loadExperiments()
+	sessionCfg := loadSessionConfig()
+	csrfCfg := loadCSRFConfig()
+	tokens := newVisitTokensFromEnv()
+	dedupe := newVisitDedupeFromEnv(dataStore, hasher)
+	nonces := newVisitNoncesFromEnv(dataStore, tokens)
+	clientTimes := loadClientTimeConfig()
+	started := clock.Now()
+	purger, err := loadCDNPurger()
+	if err != nil {
+		log.Printf("CDN purging disabled: %v", err)
+	}
+	purgeToken := os.Getenv("CDN_PURGE_TOKEN")
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	api.Handle(apiPath, visitTokenMiddleware(visitNonceMiddleware(visitDedupeMiddleware(uniqueVisitorMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		visitCountHandler(w, r, dataStore, clock) // Inject dataStore and clock
+	}), dataStore, hasher, sketches, clock), dedupe, clock), nonces), tokens, clock))
+	api.Handle(batchPath, visitTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		batchHandler(w, r, dataStore, clientTimes, clock)
+	}), tokens, clock))
+	api.HandleFunc(pollPath, func(w http.ResponseWriter, r *http.Request) {
+		pollHandler(w, r, dataStore, hub, pollTimeout)
+	})
+	api.HandleFunc(countsPath, func(w http.ResponseWriter, r *http.Request) {
+		countsHandler(w, r, dataStore)
+	})
+	api.HandleFunc(countDeltaPath, func(w http.ResponseWriter, r *http.Request) {
+		countDeltaHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(uniqueCountPath, func(w http.ResponseWriter, r *http.Request) {
+		uniqueCountHandler(w, r, dataStore, sketches, clock)
+	})
+	api.HandleFunc(statsPath, func(w http.ResponseWriter, r *http.Request) {
+		statsHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(compareStatsPath, func(w http.ResponseWriter, r *http.Request) {
+		compareStatsHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(heatmapPath, func(w http.ResponseWriter, r *http.Request) {
+		heatmapHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(summaryPath, func(w http.ResponseWriter, r *http.Request) {
+		summaryHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(referrersPath, func(w http.ResponseWriter, r *http.Request) {
+		referrersHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(campaignsPath, func(w http.ResponseWriter, r *http.Request) {
+		campaignsHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(experimentPath+"{name}", func(w http.ResponseWriter, r *http.Request) {
+		experimentHandler(w, r, dataStore, hasher, exps, clock)
+	})
+	api.HandleFunc(experimentPath+"{name}/results", func(w http.ResponseWriter, r *http.Request) {
+		experimentResultsHandler(w, r, dataStore, exps, clock)
+	})
+	api.HandleFunc(sessionHeartbeatPath, func(w http.ResponseWriter, r *http.Request) {
+		sessionHeartbeatHandler(w, r, dataStore, sessionCfg, clock)
+	})
+	api.HandleFunc(sessionStatsPath, func(w http.ResponseWriter, r *http.Request) {
+		sessionStatsHandler(w, r, dataStore, clock)
+	})
+	online := newOnlineCounter(dataStore, clock)
+	api.HandleFunc(onlinePath, func(w http.ResponseWriter, r *http.Request) {
+		onlineHandler(w, r, online)
+	})
+	api.HandleFunc(eventsPath, func(w http.ResponseWriter, r *http.Request) {
+		eventsHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(eventStatsPath, func(w http.ResponseWriter, r *http.Request) {
+		eventStatsHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(funnelPath, func(w http.ResponseWriter, r *http.Request) {
+		funnelHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(deploysPath, func(w http.ResponseWriter, r *http.Request) {
+		deploysHandler(w, r, dataStore, clock, adminToken)
+	})
+	api.HandleFunc(deployAnnotationsPath, func(w http.ResponseWriter, r *http.Request) {
+		deployAnnotationsHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(metricDocsPath, metricDocsHandler)
+	api.HandleFunc(anomaliesPath, func(w http.ResponseWriter, r *http.Request) {
+		anomaliesHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(uptimePath, func(w http.ResponseWriter, r *http.Request) {
+		uptimeHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(statusPath, func(w http.ResponseWriter, r *http.Request) {
+		statusHandler(w, r, dataStore, handlerLatencies, started, clock)
+	})
+	api.HandleFunc(csrfPath, func(w http.ResponseWriter, r *http.Request) {
+		csrfHandler(w, r, csrfCfg)
+	})
+	api.HandleFunc(privacyExportPath, func(w http.ResponseWriter, r *http.Request) {
+		privacyExportHandler(w, r, dataStore, hasher, clock)
+	})
+	api.HandleFunc(privacyDeletePath, func(w http.ResponseWriter, r *http.Request) {
+		privacyDeleteHandler(w, r, dataStore, hasher, clock)
+	})
+	api.HandleFunc(purgePath, func(w http.ResponseWriter, r *http.Request) {
+		purgeHandler(w, r, purger, purgeToken)
+	})
+	api.HandleFunc(failedJobsPath, func(w http.ResponseWriter, r *http.Request) {
+		failedJobsHandler(w, r, dataStore, adminToken)
+	})
+	api.HandleFunc(retryJobPath, func(w http.ResponseWriter, r *http.Request) {
+		retryJobHandler(w, r, dataStore, adminToken)
+	})
+	api.HandleFunc(visitsExportPath, func(w http.ResponseWriter, r *http.Request) {
+		visitsExportHandler(w, r, dataStore, clock, adminToken)
+	})
+	api.HandleFunc(maintenancePath, func(w http.ResponseWriter, r *http.Request) {
+		maintenanceHandler(w, r, dataStore, adminToken)
+	})
+	api.HandleFunc(projectsPath, func(w http.ResponseWriter, r *http.Request) {
+		projectsHandler(w, r, dataStore)
+	})
+	api.HandleFunc(projectPath, func(w http.ResponseWriter, r *http.Request) {
+		projectHandler(w, r, dataStore)
+	})
+	api.HandleFunc(adminProjectsPath, func(w http.ResponseWriter, r *http.Request) {
+		adminProjectsHandler(w, r, dataStore, adminToken)
+	})
+	api.HandleFunc(adminProjectPath, func(w http.ResponseWriter, r *http.Request) {
+		adminProjectHandler(w, r, dataStore, adminToken)
+	})
+	api.HandleFunc(resumePath, func(w http.ResponseWriter, r *http.Request) {
+		resumeHandler(w, r, dataStore)
+	})
+	api.HandleFunc(resumeDraftPath, func(w http.ResponseWriter, r *http.Request) {
+		resumeDraftHandler(w, r, dataStore, adminToken)
+	})
+	api.HandleFunc(resumeVersionsPath, func(w http.ResponseWriter, r *http.Request) {
+		resumeVersionsHandler(w, r, dataStore, adminToken)
+	})
+	api.HandleFunc(publishResumePath, func(w http.ResponseWriter, r *http.Request) {
+		publishResumeHandler(w, r, dataStore, adminToken)
+	})
+	api.HandleFunc(postsPath, func(w http.ResponseWriter, r *http.Request) {
+		postsHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(postPath, func(w http.ResponseWriter, r *http.Request) {
+		postHandler(w, r, dataStore, clock)
+	})
+	api.HandleFunc(adminPostsPath, func(w http.ResponseWriter, r *http.Request) {
+		adminPostsHandler(w, r, dataStore, adminToken)
+	})
+	api.HandleFunc(adminPostPath, func(w http.ResponseWriter, r *http.Request) {
+		adminPostHandler(w, r, dataStore, adminToken)
+	})
+	siteCfg := loadSiteConfig()
+	api.HandleFunc(adminShortLinksPath, func(w http.ResponseWriter, r *http.Request) {
+		adminShortLinksHandler(w, r, dataStore, clock, adminToken)
+	})
+	api.HandleFunc(adminShortLinkPath, func(w http.ResponseWriter, r *http.Request) {
+		adminShortLinkHandler(w, r, dataStore, adminToken)
+	})
+	qrCodes := newQRCache()
+	api.HandleFunc(adminShortLinkQRPath, func(w http.ResponseWriter, r *http.Request) {
+		adminShortLinkQRHandler(w, r, dataStore, siteCfg, qrCodes, adminToken)
+	})
+	geoCfg, err := loadGeoConfig()
+	if err != nil {
+		log.Printf("GeoIP disabled: %v", err)
+		geoCfg = defaultGeoConfig()
+	}
+	api.HandleFunc(geoSelfPath, func(w http.ResponseWriter, r *http.Request) {
+		geoSelfHandler(w, r, geoCfg)
+	})
+	api.HandleFunc(visitTokenPath, func(w http.ResponseWriter, r *http.Request) {
+		visitTokenHandler(w, r, tokens, clock)
+	})
+	routePolicies, err := loadRoutePolicies()
+	if err != nil {
+		log.Printf("Using the default route policies: %v", err)
+		routePolicies = copyRoutePolicies(defaultRoutePolicies)
+	}
+	mux.Handle("/api/", apiMiddleware(routePolicyMiddleware(api, routePolicies, adminToken, clock), api, csrfCfg, clock))
+	blogCfg := loadBlogConfig()
+	mux.HandleFunc(feedPath, func(w http.ResponseWriter, r *http.Request) {
+		feedHandler(w, r, dataStore, clock, blogCfg)
+	})
+	mux.HandleFunc(sitemapPath, func(w http.ResponseWriter, r *http.Request) {
+		sitemapHandler(w, r, dataStore, clock, siteCfg, blogCfg)
+	})
+	mux.HandleFunc(robotsPath, func(w http.ResponseWriter, r *http.Request) {
+		robotsHandler(w, r, siteCfg)
+	})
+	mux.HandleFunc(shortLinkPath, func(w http.ResponseWriter, r *http.Request) {
+		shortLinkHandler(w, r, dataStore, clock)
+	})
+
+	// Expose Prometheus metrics endpoint
+	if _, ok := appMetrics.(prometheusMetrics); ok {
+		handlePrometheusMetrics(mux)
+	}
+}
+
+// apiMiddleware wraps an API handler with the shared middleware chain. routes is the mux
+// the handler serves, which the chain labels requests with the routes of.
+func apiMiddleware(handler http.Handler, routes *http.ServeMux, csrfCfg csrfConfig, clock Clock) http.Handler {
+	signing := loadSigningConfig()
+
+	// Apply middleware in the desired order
+	handler = consentMiddleware(handler, loadConsentConfig())        // X-Tracking-Consent, DNT and GPC for per-visitor data
+	handler = surrogateKeyMiddleware(handler, loadCDNCacheTTL())     // Surrogate keys for CDN caching and purges
+	handler = captchaMiddleware(handler, loadCaptchaConfig())        // CAPTCHA check on CAPTCHA_ROUTES
+	handler = csrfMiddleware(handler, csrfCfg)                       // Double-submit CSRF check on CSRF_ROUTES
+	handler = signatureMiddleware(handler, signing, clock)           // HMAC request signatures on SIGNED_ROUTES
+	handler = recoveryMiddleware(handler)                            // Recover from panics with a JSON 500
+	handler = loadSheddingMiddleware(handler, loadLoadShedConfig())  // Reject excess load with 503
+	handler = metricsMiddleware(handler, appMetrics)                 // Request metrics
+	handler = latencyMiddleware(handler, handlerLatencies)           // Latency percentiles for /api/status
+	handler = loggingMiddleware(handler)                             // Logging middleware
+	handler = debugLoggingMiddleware(handler, loadDebugHTTPConfig()) // DEBUG_HTTP request/response logging
+	handler = requestIDMiddleware(handler)                           // Tag requests with an ID
+	handler = routeMiddleware(handler, routes)                       // Resolve the route for metrics and policies
+
+	corsHandler := cors.New(cors.Options{
+		AllowedOrigins: strings.Split(os.Getenv("ALLOWED_ORIGINS"), ","),
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowedHeaders: []string{"Authorization", "Content-Type", csrfHeaderName, visitTokenHeader, visitNonceHeader, captchaHeader, consentHeader},
+		// The CSRF cookie has to be sent with cross-origin requests
+		AllowCredentials: csrfCfg.Enabled(),
+	})
+	handler = corsHandler.Handler(handler)
+
+	// Apply origin check middleware for production
+	if os.Getenv("APP_ENV") == "prod" {
+		handler = originCheckMiddleware(handler, signing)
+	}
+
+	return handler
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_registerRoutes(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "http://allowed.com")
+	useFakeMetrics(t)
+
+	mux := http.NewServeMux()
+	registerRoutes(mux, &MockDataStore{visitCount: 3}, realClock{})
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{"Health check", http.MethodGet, "/healthz", http.StatusOK},
+		{"Readiness check", http.MethodGet, "/readyz", http.StatusOK},
+		{"Get visit count", http.MethodGet, apiPath, http.StatusOK},
+		{"Increment visit count", http.MethodPost, apiPath, http.StatusOK},
+		{"Unknown route", http.MethodGet, "/unknown", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func Test_registerRoutes_RouteLabels(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "http://allowed.com")
+	m := useFakeMetrics(t)
+
+	mux := http.NewServeMux()
+	registerRoutes(mux, &MockDataStore{visitCount: 3}, realClock{})
+
+	// The labels come from the API mux, through every middleware that copies the request
+	for _, path := range []string{apiPath, statsPath, "/api/unknown"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("DNT", "1")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var got []string
+	for _, f := range m.finished {
+		got = append(got, f.endpoint)
+	}
+	if want := []string{apiPath, statsPath, otherLabel}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected endpoints %v, got %v", want, got)
+	}
+}
+
+func Test_registerRoutes_ProdServerToServer(t *testing.T) {
+	t.Setenv("APP_ENV", "prod")
+	t.Setenv("ALLOWED_ORIGINS", "http://allowed.com")
+	t.Setenv("ADMIN_TOKEN", "admin-token")
+	t.Setenv("SIGNING_SECRET", "pipeline-secret")
+	t.Setenv("SIGNED_ROUTES", deploysPath)
+	useFakeMetrics(t)
+
+	mockDataStore := &MockDataStore{}
+	mux := http.NewServeMux()
+	registerRoutes(mux, mockDataStore, realClock{})
+
+	const body = `{"commit_sha": "3f2c1ab"}`
+	signed := func(signature string) *http.Request {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		if signature == "" {
+			signature = signaturePrefix + requestSignature([]byte("pipeline-secret"), http.MethodPost, deploysPath, ts, []byte(body))
+		}
+		req := httptest.NewRequest(http.MethodPost, deploysPath, strings.NewReader(body))
+		req.Header.Set(signatureTimestampHeader, ts)
+		req.Header.Set(signatureHeader, signature)
+		return req
+	}
+	// Internal tooling on the admin listener authenticates with its certificate alone
+	withClientCert := httptest.NewRequest(http.MethodPost, adminProjectsPath, strings.NewReader(`{"title": "Blog"}`))
+	withClientCert.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+
+	// None of these send an Origin
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"Verified client certificate", withClientCert, http.StatusCreated},
+		{"Signed request", signed(""), http.StatusCreated},
+		{"Invalid signature", signed(signaturePrefix + "00"), http.StatusUnauthorized},
+		{"Neither", httptest.NewRequest(http.MethodPost, deploysPath, strings.NewReader(body)), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, tt.req)
+			if rr.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+	if len(mockDataStore.projects) != 1 || len(mockDataStore.deploys) != 1 {
+		t.Errorf("expected a project and a deploy recorded, got %+v and %+v", mockDataStore.projects, mockDataStore.deploys)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"resume-backend/internal/awsauth"
+)
+
+const (
+	defaultSecretsRefreshInterval = 5 * time.Minute
+	secretsFetchTimeout           = 10 * time.Second
+)
+
+// secretsProvider fetches settings, such as DB_PASSWORD and API keys, from a secrets manager.
+type secretsProvider interface {
+	Name() string
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// loadSecretsProvider reads SECRETS_PROVIDER, "vault" or "aws", returning nil when it is unset.
+// It is read after the config files are applied, so the provider's own settings may come from
+// a mounted Secret.
+func loadSecretsProvider() (secretsProvider, error) {
+	switch name := os.Getenv("SECRETS_PROVIDER"); name {
+	case "":
+		return nil, nil
+	case "vault":
+		return newVaultProviderFromEnv()
+	case "aws":
+		return newAWSSecretsProviderFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_PROVIDER %q: must be vault or aws", name)
+	}
+}
+
+// loadSecretsRefreshInterval reads SECRETS_REFRESH_INTERVAL, how often secrets are fetched
+// again to pick up rotations.
+func loadSecretsRefreshInterval() time.Duration {
+	v := os.Getenv("SECRETS_REFRESH_INTERVAL")
+	if v == "" {
+		return defaultSecretsRefreshInterval
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid SECRETS_REFRESH_INTERVAL %q, using %s", v, defaultSecretsRefreshInterval)
+		return defaultSecretsRefreshInterval
+	}
+	return d
+}
+
+// secretsCache holds the values last fetched from a provider, fetching them again once they
+// are older than interval. A failed refresh keeps the last good values.
+type secretsCache struct {
+	provider secretsProvider
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	values  map[string]string
+	fetched time.Time
+}
+
+func newSecretsCache(provider secretsProvider, interval time.Duration) *secretsCache {
+	return &secretsCache{provider: provider, interval: interval, now: time.Now}
+}
+
+// Values returns the cached values, refreshing them when due. It only fails when nothing has
+// been fetched yet.
+func (c *secretsCache) Values() (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.values != nil && now.Sub(c.fetched) < c.interval {
+		return c.values, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretsFetchTimeout)
+	defer cancel()
+	values, err := c.provider.Fetch(ctx)
+	switch {
+	case err == nil:
+		c.values, c.fetched = values, now
+	case c.values == nil:
+		return nil, fmt.Errorf("failed to fetch secrets from %s: %w", c.provider.Name(), err)
+	default:
+		errorLogger.Printf("Error refreshing secrets from %s, keeping the last ones: %v", c.provider.Name(), err)
+		c.fetched = now
+	}
+	return c.values, nil
+}
+
+// vaultProvider reads a secret from HashiCorp Vault's KV engine, version 1 or 2.
+type vaultProvider struct {
+	addr   string
+	path   string
+	client *http.Client
+}
+
+// newVaultProviderFromEnv reads VAULT_ADDR and VAULT_SECRET_PATH, the secret's API path such
+// as secret/data/resume-backend. VAULT_TOKEN is read on every fetch, so a token kept fresh
+// by Vault Agent in the file VAULT_TOKEN_FILE points to is picked up.
+func newVaultProviderFromEnv() (*vaultProvider, error) {
+	addr, path := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_SECRET_PATH")
+	if addr == "" || path == "" {
+		return nil, fmt.Errorf("VAULT_ADDR and VAULT_SECRET_PATH are required when SECRETS_PROVIDER is vault")
+	}
+	if os.Getenv("VAULT_TOKEN") == "" {
+		return nil, fmt.Errorf("VAULT_TOKEN is required when SECRETS_PROVIDER is vault")
+	}
+	return &vaultProvider{
+		addr:   strings.TrimRight(addr, "/"),
+		path:   strings.Trim(path, "/"),
+		client: &http.Client{Timeout: secretsFetchTimeout},
+	}, nil
+}
+
+func (p *vaultProvider) Name() string {
+	return "vault"
+}
+
+// Fetch reads the secret's key-value pairs.
+func (p *vaultProvider) Fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.addr+"/v1/"+p.path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault secret: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned status %d", res.StatusCode)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+
+	// KV version 2 nests the pairs under data.data, beside data.metadata
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	return secretValues(data)
+}
+
+// awsSecretsProvider reads a secret holding a JSON object from AWS Secrets Manager.
+type awsSecretsProvider struct {
+	endpoint string
+	region   string
+	secretID string
+	client   *http.Client
+}
+
+// newAWSSecretsProviderFromEnv reads AWS_SECRETS_ID, the secret's name or ARN, and the region
+// and credentials from the usual AWS_* variables. Credentials are read on every fetch, so
+// rotated session credentials are picked up.
+func newAWSSecretsProviderFromEnv() (*awsSecretsProvider, error) {
+	secretID, region := os.Getenv("AWS_SECRETS_ID"), awsauth.RegionFromEnv()
+	if secretID == "" || region == "" {
+		return nil, fmt.Errorf("AWS_SECRETS_ID and AWS_REGION are required when SECRETS_PROVIDER is aws")
+	}
+	if !awsauth.CredentialsFromEnv().Valid() {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required when SECRETS_PROVIDER is aws")
+	}
+	return &awsSecretsProvider{
+		endpoint: fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", region),
+		region:   region,
+		secretID: secretID,
+		client:   &http.Client{Timeout: secretsFetchTimeout},
+	}, nil
+}
+
+func (p *awsSecretsProvider) Name() string {
+	return "aws"
+}
+
+// Fetch calls GetSecretValue and decodes the secret string's key-value pairs.
+func (p *awsSecretsProvider) Fetch(ctx context.Context) (map[string]string, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": p.secretID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode secrets manager request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secrets manager request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	awsauth.Sign(req, body, "secretsmanager", p.region, awsauth.CredentialsFromEnv(), time.Now())
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("secrets manager returned status %d", res.StatusCode)
+	}
+	var out struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid secrets manager response: %w", err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(out.SecretString), &data); err != nil {
+		return nil, fmt.Errorf("secret %s is not a JSON object: %w", p.secretID, err)
+	}
+	return secretValues(data)
+}
+
+// secretValues converts a secret's pairs to settings, rejecting names that are not variable
+// names. Values that are not strings, such as ports, are formatted.
+func secretValues(data map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(data))
+	for name, value := range data {
+		if !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid setting name %q in secret", name)
+		}
+		if s, ok := value.(string); ok {
+			values[name] = s
+		} else {
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSecrets returns values, or err when set, counting fetches
+type fakeSecrets struct {
+	values  map[string]string
+	err     error
+	fetches int
+}
+
+func (f *fakeSecrets) Name() string { return "fake" }
+
+func (f *fakeSecrets) Fetch(ctx context.Context) (map[string]string, error) {
+	f.fetches++
+	if f.err != nil {
+		return nil, f.err
+	}
+	values := make(map[string]string, len(f.values))
+	for k, v := range f.values {
+		values[k] = v
+	}
+	return values, nil
+}
+
+func Test_vaultProvider(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"KV version 2", `{"data":{"data":{"DB_PASSWORD":"s3cret","DB_PORT":5432},"metadata":{"version":3}}}`},
+		{"KV version 1", `{"data":{"DB_PASSWORD":"s3cret","DB_PORT":5432}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/secret/data/resume-backend" || r.Header.Get("X-Vault-Token") != "root" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			t.Setenv("VAULT_ADDR", server.URL+"/")
+			t.Setenv("VAULT_SECRET_PATH", "/secret/data/resume-backend")
+			t.Setenv("VAULT_TOKEN", "root")
+			p, err := newVaultProviderFromEnv()
+			if err != nil {
+				t.Fatalf("newVaultProviderFromEnv() error = %v", err)
+			}
+			got, err := p.Fetch(context.Background())
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if want := map[string]string{"DB_PASSWORD": "s3cret", "DB_PORT": "5432"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Fetch() = %v, want %v", got, want)
+			}
+
+			// The token is read on every fetch
+			t.Setenv("VAULT_TOKEN", "revoked")
+			if _, err := p.Fetch(context.Background()); err == nil {
+				t.Error("Fetch() with a rejected token succeeded")
+			}
+		})
+	}
+}
+
+func Test_awsSecretsProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIA/") ||
+			req.SecretId != "prod/resume-backend" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"DB_PASSWORD":"s3cret","CDN_PURGE_TOKEN":"token"}`})
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_SECRETS_ID", "prod/resume-backend")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIA")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	p, err := newAWSSecretsProviderFromEnv()
+	if err != nil {
+		t.Fatalf("newAWSSecretsProviderFromEnv() error = %v", err)
+	}
+	if p.endpoint != "https://secretsmanager.eu-west-1.amazonaws.com/" {
+		t.Errorf("endpoint = %q", p.endpoint)
+	}
+	p.endpoint = server.URL
+
+	got, err := p.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if want := map[string]string{"DB_PASSWORD": "s3cret", "CDN_PURGE_TOKEN": "token"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Fetch() = %v, want %v", got, want)
+	}
+}
+
+func Test_loadSecretsProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"Unset", nil, "<nil>", false},
+		{"Vault", map[string]string{"SECRETS_PROVIDER": "vault", "VAULT_ADDR": "http://vault:8200", "VAULT_SECRET_PATH": "secret/data/app", "VAULT_TOKEN": "t"}, "*main.vaultProvider", false},
+		{"Vault without token", map[string]string{"SECRETS_PROVIDER": "vault", "VAULT_ADDR": "http://vault:8200", "VAULT_SECRET_PATH": "secret/data/app"}, "<nil>", true},
+		{"AWS", map[string]string{"SECRETS_PROVIDER": "aws", "AWS_SECRETS_ID": "app", "AWS_REGION": "eu-west-1", "AWS_ACCESS_KEY_ID": "AKIA", "AWS_SECRET_ACCESS_KEY": "secret"}, "*main.awsSecretsProvider", false},
+		{"AWS without credentials", map[string]string{"SECRETS_PROVIDER": "aws", "AWS_SECRETS_ID": "app", "AWS_REGION": "eu-west-1"}, "<nil>", true},
+		{"Unknown", map[string]string{"SECRETS_PROVIDER": "gcp"}, "<nil>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"SECRETS_PROVIDER", "VAULT_ADDR", "VAULT_SECRET_PATH", "VAULT_TOKEN", "AWS_SECRETS_ID", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+				t.Setenv(k, tt.env[k])
+			}
+			got, err := loadSecretsProvider()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadSecretsProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if typ := reflect.TypeOf(got); (typ == nil && tt.want != "<nil>") || (typ != nil && typ.String() != tt.want) {
+					t.Errorf("loadSecretsProvider() = %v, want %s", typ, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_secretsCache(t *testing.T) {
+	provider := &fakeSecrets{err: errors.New("vault sealed")}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newSecretsCache(provider, time.Minute)
+	c.now = func() time.Time { return now }
+
+	// Nothing to fall back on at startup
+	if _, err := c.Values(); err == nil {
+		t.Fatal("Values() succeeded without secrets")
+	}
+
+	provider.err, provider.values = nil, map[string]string{"DB_PASSWORD": "first"}
+	if got, _ := c.Values(); got["DB_PASSWORD"] != "first" {
+		t.Fatalf("Values() = %v", got)
+	}
+
+	// Cached until the interval passes
+	provider.values["DB_PASSWORD"] = "second"
+	now = now.Add(30 * time.Second)
+	if got, _ := c.Values(); got["DB_PASSWORD"] != "first" || provider.fetches != 2 {
+		t.Errorf("Values() = %v after %d fetches, want the cached value", got, provider.fetches)
+	}
+	now = now.Add(time.Minute)
+	if got, _ := c.Values(); got["DB_PASSWORD"] != "second" {
+		t.Errorf("Values() = %v, want the rotated value", got)
+	}
+
+	// A failed refresh keeps the last good values
+	provider.err = errors.New("vault sealed")
+	now = now.Add(time.Minute)
+	if got, err := c.Values(); err != nil || got["DB_PASSWORD"] != "second" {
+		t.Errorf("Values() = %v, %v, want the last good values", got, err)
+	}
+}
+
+func Test_configFiles_Secrets(t *testing.T) {
+	unsetenv(t, "DB_PASSWORD", "CDN_PURGE_TOKEN")
+	t.Setenv("DB_HOST", "env-host")
+	provider := &fakeSecrets{values: map[string]string{"DB_PASSWORD": "first", "CDN_PURGE_TOKEN": "token", "DB_HOST": "secret-host"}}
+	c := &configFiles{explicit: map[string]bool{"DB_HOST": true}, applied: make(map[string]string), secrets: newSecretsCache(provider, 0)}
+	var rotated []string
+	c.OnChange(func(changed []string) { rotated = changed })
+
+	if _, err := c.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	for name, want := range map[string]string{"DB_PASSWORD": "first", "CDN_PURGE_TOKEN": "token", "DB_HOST": "env-host"} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	provider.values["DB_PASSWORD"] = "second"
+	if _, err := c.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := os.Getenv("DB_PASSWORD"); got != "second" {
+		t.Errorf("DB_PASSWORD = %q after rotation", got)
+	}
+	if want := []string{"DB_PASSWORD"}; !reflect.DeepEqual(rotated, want) {
+		t.Errorf("changed = %v, want %v", rotated, want)
+	}
+}
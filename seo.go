@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sitemapPath = "/sitemap.xml"
+	robotsPath  = "/robots.txt"
+
+	// maxSitemapURLs is the most URLs the sitemap protocol allows in one file
+	maxSitemapURLs = 50000
+	sitemapBatch   = 1000
+)
+
+// siteConfig describes the site for crawlers: SITE_URL, the address the site and these files
+// are served under; SITEMAP_PAGES, the comma-separated paths of its pages; and
+// ROBOTS_DISALLOW, the comma-separated path prefixes crawlers are asked to skip, /api/ and the
+// short links under /r/ unless set, and nothing when set empty.
+type siteConfig struct {
+	URL      string
+	Pages    []string
+	Disallow []string
+}
+
+func loadSiteConfig() siteConfig {
+	cfg := siteConfig{URL: strings.TrimRight(os.Getenv("SITE_URL"), "/")}
+	if cfg.URL != "" {
+		if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			log.Printf("Invalid SITE_URL %q: must be an absolute http or https URL, using the request's host", cfg.URL)
+			cfg.URL = ""
+		}
+	}
+
+	pages := os.Getenv("SITEMAP_PAGES")
+	if pages == "" {
+		pages = "/"
+	}
+	cfg.Pages = sitePaths("SITEMAP_PAGES", pages)
+
+	disallow, ok := os.LookupEnv("ROBOTS_DISALLOW")
+	if !ok {
+		disallow = "/api/,/r/"
+	}
+	cfg.Disallow = sitePaths("ROBOTS_DISALLOW", disallow)
+	return cfg
+}
+
+// sitePaths splits a comma-separated list of paths, skipping ones that aren't.
+func sitePaths(name, list string) []string {
+	var paths []string
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t\r\n") {
+			log.Printf("Invalid %s entry %q: must be a path, skipping", name, path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// origin returns SITE_URL, or without it the scheme and host the request was made to.
+func (cfg siteConfig) origin(r *http.Request) string {
+	if cfg.URL != "" {
+		return cfg.URL
+	}
+	return requestOrigin(r)
+}
+
+// requestOrigin returns the scheme and host r was made to, trusting a proxy's
+// X-Forwarded-Proto for the scheme.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// sitemapURLSet is a sitemap document.
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapHandler lists the site's pages and, with BLOG_URL set, the blog and its published
+// posts, each post with the time it last changed.
+func sitemapHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock, cfg siteConfig, blog blogConfig) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	set := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	origin := cfg.origin(r)
+	for _, page := range cfg.Pages {
+		set.URLs = append(set.URLs, sitemapURL{Loc: origin + page})
+	}
+
+	if blog.URL != "" {
+		now := clock.Now().UTC()
+		var posts []Post
+		for len(posts) < maxSitemapURLs {
+			batch, err := dataStore.ListPosts(r.Context(), &now, sitemapBatch, len(posts))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list posts: %v", err), http.StatusInternalServerError)
+				return
+			}
+			posts = append(posts, batch...)
+			if len(batch) < sitemapBatch {
+				break
+			}
+		}
+
+		index := sitemapURL{Loc: blog.URL}
+		if len(posts) > 0 {
+			index.LastMod = posts[0].PublishedAt.Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, index)
+		for _, p := range posts[:min(len(posts), maxSitemapURLs-len(set.URLs))] {
+			// A post scheduled after its last edit changes when it is published
+			changed := p.UpdatedAt
+			if p.PublishedAt.After(changed) {
+				changed = *p.PublishedAt
+			}
+			set.URLs = append(set.URLs, sitemapURL{Loc: blog.postLink(r, p.Slug), LastMod: changed.Format(time.RFC3339)})
+		}
+	}
+
+	body, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode sitemap: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// robotsHandler returns robots.txt, asking every crawler to skip the ROBOTS_DISALLOW paths
+// and pointing them at the sitemap.
+func robotsHandler(w http.ResponseWriter, r *http.Request, cfg siteConfig) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if len(cfg.Disallow) == 0 {
+		b.WriteString("Disallow:\n") // an empty rule allows everything
+	}
+	for _, path := range cfg.Disallow {
+		b.WriteString("Disallow: " + path + "\n")
+	}
+	b.WriteString("\nSitemap: " + cfg.origin(r) + sitemapPath + "\n")
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_loadSiteConfig(t *testing.T) {
+	t.Setenv("SITE_URL", "https://example.com/")
+	t.Setenv("SITEMAP_PAGES", "/, /resume,projects,/blog")
+	cfg := loadSiteConfig()
+	if cfg.URL != "https://example.com" {
+		t.Errorf("expected the trailing slash trimmed, got %q", cfg.URL)
+	}
+	if !slices.Equal(cfg.Pages, []string{"/", "/resume", "/blog"}) {
+		t.Errorf("expected the invalid page skipped, got %q", cfg.Pages)
+	}
+	if !slices.Equal(cfg.Disallow, []string{"/api/", "/r/"}) {
+		t.Errorf("expected /api/ and /r/ disallowed by default, got %q", cfg.Disallow)
+	}
+
+	t.Setenv("SITE_URL", "example.com")
+	t.Setenv("SITEMAP_PAGES", "")
+	t.Setenv("ROBOTS_DISALLOW", "")
+	cfg = loadSiteConfig()
+	if cfg.URL != "" || !slices.Equal(cfg.Pages, []string{"/"}) || len(cfg.Disallow) != 0 {
+		t.Errorf("expected the invalid URL dropped, the home page and nothing disallowed; got %+v", cfg)
+	}
+}
+
+func Test_sitemapHandler(t *testing.T) {
+	created := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	published, scheduled := created.Add(time.Hour), created.Add(30*24*time.Hour)
+	mockDataStore := &MockDataStore{posts: []Post{
+		{ID: 1, Slug: "first", PublishedAt: &created, UpdatedAt: created.Add(48 * time.Hour)},
+		{ID: 2, Slug: "second", PublishedAt: &published, UpdatedAt: created},
+		{ID: 3, Slug: "draft", UpdatedAt: created},
+		{ID: 4, Slug: "scheduled", PublishedAt: &scheduled, UpdatedAt: created},
+	}}
+	clock := newFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	site := siteConfig{URL: "https://example.com", Pages: []string{"/", "/resume"}}
+
+	get := func(blog blogConfig) sitemapURLSet {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		sitemapHandler(rr, httptest.NewRequest(http.MethodGet, sitemapPath, nil), mockDataStore, clock, site, blog)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/xml; charset=utf-8" {
+			t.Fatalf("expected an XML sitemap, got %d with %q", rr.Code, rr.Header().Get("Content-Type"))
+		}
+		var set sitemapURLSet
+		if err := xml.Unmarshal(rr.Body.Bytes(), &set); err != nil {
+			t.Fatalf("invalid sitemap %s: %v", rr.Body.String(), err)
+		}
+		return set
+	}
+
+	set := get(blogConfig{URL: "https://example.com/blog"})
+	want := []sitemapURL{
+		{Loc: "https://example.com/"},
+		{Loc: "https://example.com/resume"},
+		{Loc: "https://example.com/blog", LastMod: "2024-03-01T10:00:00Z"},
+		{Loc: "https://example.com/blog/second", LastMod: "2024-03-01T10:00:00Z"},
+		{Loc: "https://example.com/blog/first", LastMod: "2024-03-03T09:00:00Z"},
+	}
+	if !slices.Equal(set.URLs, want) {
+		t.Errorf("expected the pages and published posts, got %+v", set.URLs)
+	}
+
+	// Without the blog's address on the site, posts have no page to list
+	if set := get(blogConfig{}); len(set.URLs) != 2 {
+		t.Errorf("expected only the pages without BLOG_URL, got %+v", set.URLs)
+	}
+
+	rr := httptest.NewRecorder()
+	sitemapHandler(rr, httptest.NewRequest(http.MethodGet, sitemapPath, nil), failingStore{}, clock, site, blogConfig{URL: "https://example.com/blog"})
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rr.Code)
+	}
+}
+
+func Test_robotsHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, robotsPath, nil)
+	req.Host = "api.example.com"
+
+	rr := httptest.NewRecorder()
+	robotsHandler(rr, req, siteConfig{Disallow: []string{"/api/", "/admin"}})
+	want := "User-agent: *\nDisallow: /api/\nDisallow: /admin\n\nSitemap: http://api.example.com/sitemap.xml\n"
+	if rr.Body.String() != want {
+		t.Errorf("got %q, want %q", rr.Body.String(), want)
+	}
+
+	rr = httptest.NewRecorder()
+	robotsHandler(rr, req, siteConfig{URL: "https://example.com"})
+	want = "User-agent: *\nDisallow:\n\nSitemap: https://example.com/sitemap.xml\n"
+	if rr.Body.String() != want {
+		t.Errorf("got %q, want %q", rr.Body.String(), want)
+	}
+}
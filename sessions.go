@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	sessionHeartbeatPath = "/api/session/heartbeat"
+	sessionStatsPath     = "/api/session/stats"
+
+	defaultSessionIdleTimeout       = 30 * time.Minute
+	defaultSessionAggregateInterval = 5 * time.Minute
+)
+
+// sessionIDPattern matches the IDs handed out by newSessionID
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// sessionConfig controls how heartbeats are stitched into sessions and how often they are rolled up.
+type sessionConfig struct {
+	IdleTimeout       time.Duration // a heartbeat after this much silence starts a new session
+	AggregateInterval time.Duration
+}
+
+// loadSessionConfig reads SESSION_IDLE_TIMEOUT and SESSION_AGGREGATE_INTERVAL from the environment.
+func loadSessionConfig() sessionConfig {
+	cfg := sessionConfig{
+		IdleTimeout:       defaultSessionIdleTimeout,
+		AggregateInterval: defaultSessionAggregateInterval,
+	}
+
+	for _, setting := range []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"SESSION_IDLE_TIMEOUT", &cfg.IdleTimeout},
+		{"SESSION_AGGREGATE_INTERVAL", &cfg.AggregateInterval},
+	} {
+		if v := os.Getenv(setting.env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Printf("Invalid %s %q, using %s", setting.env, v, *setting.dst)
+				continue
+			}
+			*setting.dst = d
+		}
+	}
+
+	return cfg
+}
+
+// newSessionID returns a random 128-bit session token.
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// heartbeatRequest is the optional body of POST /api/session/heartbeat.
+type heartbeatRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+// heartbeatResponse returns the session token the frontend should send with its next heartbeat.
+type heartbeatResponse struct {
+	SessionID string `json:"session_id"`
+}
+
+// sessionHeartbeatHandler extends the caller's session, or starts a new one when the token
+// is missing, unknown or has been idle for longer than the idle timeout.
+func sessionHeartbeatHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, cfg sessionConfig, clock Clock) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req heartbeatRequest
+	if r.Body != nil {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVisitBodyBytes)).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, fmt.Sprintf("Invalid heartbeat body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if !trackingAllowed(r.Context()) {
+		// Without consent no session is kept; an empty token tells the frontend to stop
+		writeResponse(w, r, heartbeatResponse{})
+		return
+	}
+
+	now := clock.Now()
+	id := req.SessionID
+	active := false
+	if sessionIDPattern.MatchString(id) {
+		var err error
+		active, err = dataStore.TouchSession(r.Context(), id, now, now.Add(-cfg.IdleTimeout))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to record heartbeat: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !active {
+		var err error
+		if id, err = newSessionID(); err != nil {
+			http.Error(w, "Failed to start session", http.StatusInternalServerError)
+			return
+		}
+		if err := dataStore.StartSession(r.Context(), id, now); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start session: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeResponse(w, r, heartbeatResponse{SessionID: id})
+}
+
+// sessionDayStat is one day of the session stats response.
+type sessionDayStat struct {
+	Date                   string  `json:"date"`
+	Sessions               int     `json:"sessions"`
+	AverageDurationSeconds float64 `json:"average_duration_seconds"`
+}
+
+// sessionStatsResponse is the body returned by GET /api/session/stats.
+type sessionStatsResponse struct {
+	Days                   int              `json:"days"`
+	Sessions               int              `json:"sessions"`
+	AverageDurationSeconds float64          `json:"average_duration_seconds"`
+	Daily                  []sessionDayStat `json:"daily"`
+}
+
+// sessionStatsHandler reports average time on page for finished sessions over the last days UTC days.
+// Figures come from the rollup, so they trail real time by up to the idle timeout plus the aggregate interval.
+func sessionStatsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	now := clock.Now().UTC()
+	stats, err := dataStore.GetSessionStats(r.Context(), now.AddDate(0, 0, -(days-1)), now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get session stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := sessionStatsResponse{Days: days, Daily: make([]sessionDayStat, 0, len(stats))}
+	var totalSeconds float64
+	for _, d := range stats {
+		day := sessionDayStat{Date: d.Date.Format(time.DateOnly), Sessions: d.Sessions}
+		if d.Sessions > 0 {
+			day.AverageDurationSeconds = d.TotalSeconds / float64(d.Sessions)
+		}
+		response.Daily = append(response.Daily, day)
+		response.Sessions += d.Sessions
+		totalSeconds += d.TotalSeconds
+	}
+	if response.Sessions > 0 {
+		response.AverageDurationSeconds = totalSeconds / float64(response.Sessions)
+	}
+
+	writeResponse(w, r, response)
+}
+
+// aggregateSessions rolls up sessions that went idle, recomputing yesterday and today in UTC
+// so sessions that closed after midnight are picked up.
+func aggregateSessions(ctx context.Context, dataStore DataStore, cfg sessionConfig, now time.Time) error {
+	now = now.UTC()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	return dataStore.AggregateSessions(ctx, since, now.Add(-cfg.IdleTimeout))
+}
+
+// runSessionAggregator aggregates sessions every AggregateInterval until ctx is done.
+func runSessionAggregator(ctx context.Context, dataStore DataStore, cfg sessionConfig, clock Clock) {
+	ticker := time.NewTicker(cfg.AggregateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Failures are logged by the store and retried on the next tick
+			_ = aggregateSessions(ctx, dataStore, cfg, clock.Now())
+		}
+	}
+}
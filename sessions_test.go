@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_loadSessionConfig(t *testing.T) {
+	t.Setenv("SESSION_IDLE_TIMEOUT", "10m")
+	t.Setenv("SESSION_AGGREGATE_INTERVAL", "never")
+
+	cfg := loadSessionConfig()
+	if cfg.IdleTimeout != 10*time.Minute {
+		t.Errorf("expected idle timeout 10m, got %s", cfg.IdleTimeout)
+	}
+	if cfg.AggregateInterval != defaultSessionAggregateInterval {
+		t.Errorf("expected the default aggregate interval for an invalid value, got %s", cfg.AggregateInterval)
+	}
+}
+
+func Test_sessionHeartbeatHandler(t *testing.T) {
+	mockDataStore := &MockDataStore{}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	cfg := sessionConfig{IdleTimeout: 30 * time.Minute}
+
+	heartbeat := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		sessionHeartbeatHandler(w, httptest.NewRequest(http.MethodPost, sessionHeartbeatPath, strings.NewReader(body)), mockDataStore, cfg, clock)
+		var response heartbeatResponse
+		json.NewDecoder(w.Body).Decode(&response)
+		return w.Code, response.SessionID
+	}
+
+	status, first := heartbeat("")
+	if status != http.StatusOK || !sessionIDPattern.MatchString(first) {
+		t.Fatalf("expected a new session, got status %d and id %q", status, first)
+	}
+
+	clock.Advance(time.Minute)
+	if _, id := heartbeat(`{"session_id": "` + first + `"}`); id != first {
+		t.Errorf("expected the session to continue, got %q", id)
+	}
+	if !mockDataStore.sessions[first].Equal(clock.Now()) {
+		t.Errorf("expected last seen to move to %v, got %v", clock.Now(), mockDataStore.sessions[first])
+	}
+
+	clock.Advance(31 * time.Minute)
+	if _, id := heartbeat(`{"session_id": "` + first + `"}`); id == first {
+		t.Error("expected an idle session to be replaced")
+	}
+
+	if _, id := heartbeat(`{"session_id": "../../etc/passwd"}`); !sessionIDPattern.MatchString(id) {
+		t.Errorf("expected a malformed token to get a new session, got %q", id)
+	}
+	if len(mockDataStore.sessions) != 3 {
+		t.Errorf("expected 3 sessions, got %d", len(mockDataStore.sessions))
+	}
+
+	if status, _ := heartbeat(`{"session_id":`); status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid body, got %d", status)
+	}
+}
+
+func Test_sessionStatsHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	mockDataStore := &MockDataStore{sessionDays: []SessionDay{
+		{Date: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), Sessions: 2, TotalSeconds: 120},
+		{Date: time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC), Sessions: 1, TotalSeconds: 30},
+	}}
+
+	w := httptest.NewRecorder()
+	sessionStatsHandler(w, httptest.NewRequest(http.MethodGet, sessionStatsPath+"?days=7", nil), mockDataStore, clock)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+
+	var response sessionStatsResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if response.Sessions != 3 || response.AverageDurationSeconds != 50 {
+		t.Errorf("expected 3 sessions averaging 50s, got %+v", response)
+	}
+	if len(response.Daily) != 2 || response.Daily[0].Date != "2024-03-02" || response.Daily[0].AverageDurationSeconds != 60 {
+		t.Errorf("unexpected daily stats %+v", response.Daily)
+	}
+	if want := time.Date(2024, 2, 26, 10, 0, 0, 0, time.UTC); !mockDataStore.lastFrom.Equal(want) {
+		t.Errorf("expected window to start on %v; got %v", want, mockDataStore.lastFrom)
+	}
+
+	w = httptest.NewRecorder()
+	sessionStatsHandler(w, httptest.NewRequest(http.MethodGet, sessionStatsPath+"?days=400", nil), mockDataStore, clock)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid days; got %d", w.Code)
+	}
+}
+
+func Test_aggregateSessions(t *testing.T) {
+	mockDataStore := &MockDataStore{}
+	cfg := sessionConfig{IdleTimeout: 30 * time.Minute}
+
+	if err := aggregateSessions(context.Background(), mockDataStore, cfg, time.Date(2024, 3, 3, 0, 10, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("aggregateSessions() error = %v", err)
+	}
+
+	wantSince := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	wantClosedBefore := time.Date(2024, 3, 2, 23, 40, 0, 0, time.UTC)
+	if !mockDataStore.aggregated[0].Equal(wantSince) || !mockDataStore.aggregated[1].Equal(wantClosedBefore) {
+		t.Errorf("expected aggregation of [%v, %v), got %v", wantSince, wantClosedBefore, mockDataStore.aggregated)
+	}
+}
+
+func Test_runSessionAggregator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	mockDataStore := &MockDataStore{}
+
+	go func() {
+		runSessionAggregator(ctx, mockDataStore, sessionConfig{IdleTimeout: time.Minute, AggregateInterval: time.Hour}, realClock{})
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the aggregator to stop when its context is cancelled")
+	}
+}
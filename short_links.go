@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"resume-backend/internal/logging"
+	"resume-backend/internal/qrcode"
+)
+
+const (
+	shortLinkPath        = "/r/{slug}"
+	adminShortLinksPath  = "/api/admin/links"
+	adminShortLinkPath   = "/api/admin/links/{slug}"
+	adminShortLinkQRPath = "/api/admin/links/{slug}/qr"
+
+	// shortLinkClickEvent is the event each redirect records, with the link's slug and the
+	// host of the page the click came from as properties
+	shortLinkClickEvent = "short_link_clicked"
+
+	maxShortLinkBodyBytes    = 8 << 10
+	maxShortLinkSlugLength   = 64
+	maxShortLinkTargetLength = 2048
+
+	defaultQRScale    = 8  // pixels a module
+	maxQRScale        = 40 // large enough for print at 300 dpi
+	maxQRCacheEntries = 256
+)
+
+// shortLinkRequest is the body of a short link save.
+type shortLinkRequest struct {
+	Target string `json:"target"`
+}
+
+// shortLinkResponse is one short link as a save returns it.
+type shortLinkResponse struct {
+	Slug      string    `json:"slug"`
+	Target    string    `json:"target"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// shortLinkClicks is one short link as listings return it, with its recent clicks.
+type shortLinkClicks struct {
+	Slug      string    `json:"slug"`
+	Target    string    `json:"target"`
+	Clicks    int       `json:"clicks"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// shortLinksResponse is the body returned by GET /api/admin/links.
+type shortLinksResponse struct {
+	Days  int               `json:"days"`
+	Links []shortLinkClicks `json:"links"`
+}
+
+// shortLinkHandler redirects to a short link's target, recording the click as an event. The
+// redirect is temporary and uncached, so every click is counted and the target can change.
+func shortLinkHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slug := r.PathValue("slug")
+	link, found, err := dataStore.GetShortLink(r.Context(), slug)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get short link: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "No link with that name", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		props := map[string]string{"slug": link.Slug}
+		if u, err := url.Parse(r.Referer()); err == nil && u.Host != "" {
+			props["referrer"] = u.Hostname()
+		}
+		encoded, _ := json.Marshal(props)
+		// A click that can't be recorded still gets where it was going
+		if err := dataStore.RecordEvent(r.Context(), Event{Type: shortLinkClickEvent, Timestamp: clock.Now(), Properties: encoded}); err != nil {
+			logging.FromContext(r.Context()).Printf("Error recording click on short link %s: %v", link.Slug, err)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, link.Target, http.StatusFound)
+}
+
+// adminShortLinksHandler lists the short links with their clicks over the last days days.
+func adminShortLinksHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock, token string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	links, err := dataStore.ListShortLinks(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list short links: %v", err), http.StatusInternalServerError)
+		return
+	}
+	now := clock.Now()
+	counts, err := dataStore.GetEventStats(r.Context(), EventStatsQuery{
+		From:     now.AddDate(0, 0, -days),
+		To:       now,
+		Type:     shortLinkClickEvent,
+		Property: "slug",
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to count clicks: %v", err), http.StatusInternalServerError)
+		return
+	}
+	clicks := make(map[string]int, len(counts))
+	for _, c := range counts {
+		clicks[c.Value] = c.Count
+	}
+
+	resp := shortLinksResponse{Days: days, Links: make([]shortLinkClicks, len(links))}
+	for i, l := range links {
+		resp.Links[i] = shortLinkClicks{Slug: l.Slug, Target: l.Target, Clicks: clicks[l.Slug], CreatedAt: l.CreatedAt, UpdatedAt: l.UpdatedAt}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, resp)
+}
+
+// adminShortLinkHandler creates or retargets a short link with PUT and deletes it with DELETE.
+func adminShortLinkHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, token string) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+	slug := r.PathValue("slug")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == http.MethodDelete {
+		deleted, err := dataStore.DeleteShortLink(r.Context(), slug)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to delete short link: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "No link with that name", http.StatusNotFound)
+			return
+		}
+		writeResponse(w, r, messageResponse{Message: "Link deleted"})
+		return
+	}
+
+	if len(slug) > maxShortLinkSlugLength || !slugPattern.MatchString(slug) {
+		http.Error(w, fmt.Sprintf("slug must be up to %d lowercase letters, digits and single hyphens", maxShortLinkSlugLength), http.StatusBadRequest)
+		return
+	}
+	var req shortLinkRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxShortLinkBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid link body: %v", err), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(req.Target)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(req.Target) > maxShortLinkTargetLength {
+		http.Error(w, fmt.Sprintf("target must be an absolute http or https URL of at most %d characters", maxShortLinkTargetLength), http.StatusBadRequest)
+		return
+	}
+
+	saved, created, err := dataStore.SaveShortLink(r.Context(), ShortLink{Slug: slug, Target: req.Target})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save short link: %v", err), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		w.Header().Set("Location", "/r/"+saved.Slug)
+	}
+	writeResponseStatus(w, r, status, shortLinkResponse{Slug: saved.Slug, Target: saved.Target, CreatedAt: saved.CreatedAt, UpdatedAt: saved.UpdatedAt})
+}
+
+// qrCache holds rendered QR codes by what they encode and how they were drawn. A code only
+// depends on the short link's URL, not its target, so entries never go stale; the cache is
+// emptied when it fills up.
+type qrCache struct {
+	mu     sync.Mutex
+	images map[string][]byte
+}
+
+func newQRCache() *qrCache {
+	return &qrCache{images: make(map[string][]byte)}
+}
+
+// image returns the code for content drawn as format at scale, rendering it on a miss.
+func (c *qrCache) image(content, format string, scale int) ([]byte, error) {
+	key := format + " " + strconv.Itoa(scale) + " " + content
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if img, ok := c.images[key]; ok {
+		return img, nil
+	}
+
+	code, err := qrcode.Encode([]byte(content), qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	var img []byte
+	if format == "svg" {
+		img = code.SVG(scale)
+	} else if img, err = code.PNG(scale); err != nil {
+		return nil, err
+	}
+	if len(c.images) >= maxQRCacheEntries {
+		clear(c.images)
+	}
+	c.images[key] = img
+	return img, nil
+}
+
+// adminShortLinkQRHandler returns a QR code of a short link's URL, as a PNG or with
+// ?format=svg an SVG, ?scale= pixels a module. Scans go through the redirect, so they are
+// counted and follow the link when it is retargeted.
+func adminShortLinkQRHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, site siteConfig, cache *qrCache, token string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		http.Error(w, "format must be png or svg", http.StatusBadRequest)
+		return
+	}
+	scale := defaultQRScale
+	if v := query.Get("scale"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxQRScale {
+			http.Error(w, fmt.Sprintf("scale must be between 1 and %d", maxQRScale), http.StatusBadRequest)
+			return
+		}
+		scale = n
+	}
+
+	slug := r.PathValue("slug")
+	_, found, err := dataStore.GetShortLink(r.Context(), slug)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get short link: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "No link with that name", http.StatusNotFound)
+		return
+	}
+
+	img, err := cache.image(site.origin(r)+"/r/"+slug, format, scale)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to draw QR code: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+	}
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("Content-Length", strconv.Itoa(len(img)))
+	w.Write(img)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"resume-backend/internal/qrcode"
+)
+
+func Test_shortLinkHandlers(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	mockDataStore := &MockDataStore{}
+	mux := http.NewServeMux()
+	mux.HandleFunc(shortLinkPath, func(w http.ResponseWriter, r *http.Request) {
+		shortLinkHandler(w, r, mockDataStore, clock)
+	})
+	mux.HandleFunc(adminShortLinksPath, func(w http.ResponseWriter, r *http.Request) {
+		adminShortLinksHandler(w, r, mockDataStore, clock, "admin-token")
+	})
+	mux.HandleFunc(adminShortLinkPath, func(w http.ResponseWriter, r *http.Request) {
+		adminShortLinkHandler(w, r, mockDataStore, "admin-token")
+	})
+	do := func(method, path, auth, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", auth)
+		req.Header.Set("Referer", "https://www.linkedin.com/in/someone?trk=profile")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(http.MethodPut, "/api/admin/links/cv", "", `{"target": "https://example.com/resume.pdf"}`); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+
+	// Saving a new name creates the link, and saving it again retargets it
+	rr := do(http.MethodPut, "/api/admin/links/cv", "Bearer admin-token", `{"target": "https://example.com/resume.pdf"}`)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") != "/r/cv" {
+		t.Fatalf("expected the link created at /r/cv, got %d at %q: %s", rr.Code, rr.Header().Get("Location"), rr.Body.String())
+	}
+	rr = do(http.MethodPut, "/api/admin/links/cv", "Bearer admin-token", `{"target": "https://example.com/resume-2024.pdf"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Following the link redirects to its current target and records the click
+	rr = do(http.MethodGet, "/r/cv", "", "")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://example.com/resume-2024.pdf" {
+		t.Fatalf("expected a redirect to the new target, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected the redirect not cached, got %q", cc)
+	}
+	if len(mockDataStore.events) != 1 {
+		t.Fatalf("expected one click recorded, got %d", len(mockDataStore.events))
+	}
+	var props map[string]string
+	if err := json.Unmarshal(mockDataStore.events[0].Properties, &props); err != nil {
+		t.Fatalf("invalid event properties: %v", err)
+	}
+	if e := mockDataStore.events[0]; e.Type != shortLinkClickEvent || !e.Timestamp.Equal(clock.Now()) ||
+		props["slug"] != "cv" || props["referrer"] != "www.linkedin.com" {
+		t.Errorf("unexpected click event %+v with %v", e, props)
+	}
+	if rr := do(http.MethodHead, "/r/cv", "", ""); rr.Code != http.StatusFound || len(mockDataStore.events) != 1 {
+		t.Errorf("expected HEAD redirected without recording a click, got %d with %d events", rr.Code, len(mockDataStore.events))
+	}
+	if rr := do(http.MethodGet, "/r/missing", "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+
+	// The listing counts each link's clicks over the window
+	do(http.MethodPut, "/api/admin/links/gh", "Bearer admin-token", `{"target": "https://github.com/someone"}`)
+	mockDataStore.eventCounts = []EventCount{{Value: "cv", Count: 3}}
+	rr = do(http.MethodGet, adminShortLinksPath+"?days=7", "Bearer admin-token", "")
+	var list shortLinksResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if list.Days != 7 || len(list.Links) != 2 || list.Links[0].Clicks != 3 || list.Links[1].Clicks != 0 {
+		t.Errorf("expected both links with their clicks; got %s", rr.Body.String())
+	}
+	if q := mockDataStore.lastQuery; q.Type != shortLinkClickEvent || q.Property != "slug" || !q.From.Equal(clock.Now().AddDate(0, 0, -7)) {
+		t.Errorf("unexpected click query %+v", q)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPut, "/api/admin/links/Bad_Name", `{"target": "https://example.com"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/links/cv", `{"target": "javascript:alert(1)"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/links/cv", `{"target": "/relative"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/links/cv", `{"target": "https://example.com", "note": "x"}`, http.StatusBadRequest},
+		{http.MethodGet, adminShortLinksPath + "?days=0", "", http.StatusBadRequest},
+		{http.MethodPost, "/r/cv", "", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/admin/links/cv", "", http.StatusOK},
+		{http.MethodDelete, "/api/admin/links/cv", "", http.StatusNotFound},
+		{http.MethodGet, "/r/cv", "", http.StatusNotFound},
+	} {
+		if rr := do(tt.method, tt.path, "Bearer admin-token", tt.body); rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tt.method, tt.path, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func Test_adminShortLinkQRHandler(t *testing.T) {
+	mockDataStore := &MockDataStore{links: []ShortLink{{Slug: "cv", Target: "https://example.com/resume.pdf"}}}
+	cache := newQRCache()
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminShortLinkQRPath, func(w http.ResponseWriter, r *http.Request) {
+		adminShortLinkQRHandler(w, r, mockDataStore, siteConfig{URL: "https://example.com"}, cache, "admin-token")
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/api/admin/links/cv/qr?scale=2")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("expected a PNG, got %d with %q: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	img, err := png.Decode(rr.Body)
+	if err != nil {
+		t.Fatalf("invalid PNG: %v", err)
+	}
+	// https://example.com/r/cv fits version 2, 25 modules and the quiet zone on each side
+	if side := (25 + 2*qrcode.QuietZone) * 2; img.Bounds().Dx() != side {
+		t.Errorf("expected %dpx, got %v", side, img.Bounds())
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "private, max-age=86400" {
+		t.Errorf("expected the code cached privately, got %q", cc)
+	}
+
+	rr = get("/api/admin/links/cv/qr?format=svg")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" || !strings.HasPrefix(rr.Body.String(), "<svg") {
+		t.Errorf("expected an SVG, got %d with %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	want, _ := qrcode.Encode([]byte("https://example.com/r/cv"), qrcode.Medium)
+	if rr.Body.String() != string(want.SVG(defaultQRScale)) {
+		t.Error("expected the code of the short link's URL")
+	}
+
+	// Codes are kept by the URL they encode, which retargeting the link doesn't change
+	if _, ok := cache.images["svg 8 https://example.com/r/cv"]; !ok || len(cache.images) != 2 {
+		t.Errorf("expected both codes cached, got %d", len(cache.images))
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/api/admin/links/missing/qr", http.StatusNotFound},
+		{"/api/admin/links/cv/qr?format=gif", http.StatusBadRequest},
+		{"/api/admin/links/cv/qr?scale=0", http.StatusBadRequest},
+		{"/api/admin/links/cv/qr?scale=41", http.StatusBadRequest},
+	} {
+		if rr := get(tt.path); rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rr.Code)
+		}
+	}
+}
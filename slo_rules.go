@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sloConfig describes the availability and latency objectives for one endpoint.
+type sloConfig struct {
+	Endpoint         string
+	Availability     float64 // fraction of requests that must not fail with a 5xx
+	LatencyTarget    float64 // fraction of requests that must finish within LatencyThreshold
+	LatencyThreshold float64 // seconds; must be one of httpLatencyBuckets
+}
+
+// burnRateWindow is one multiwindow, multi-burn-rate alert from the Google SRE workbook.
+type burnRateWindow struct {
+	Long, Short string
+	BurnRate    float64
+	Severity    string
+}
+
+var burnRateWindows = []burnRateWindow{
+	{Long: "1h", Short: "5m", BurnRate: 14.4, Severity: "page"},
+	{Long: "6h", Short: "30m", BurnRate: 6, Severity: "page"},
+	{Long: "1d", Short: "2h", BurnRate: 3, Severity: "ticket"},
+	{Long: "3d", Short: "6h", BurnRate: 1, Severity: "ticket"},
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// validate checks the objectives are fractions and the threshold matches a histogram bucket.
+func (c sloConfig) validate() error {
+	if c.Availability <= 0 || c.Availability >= 1 {
+		return fmt.Errorf("availability must be between 0 and 1, got %v", c.Availability)
+	}
+	if c.LatencyTarget <= 0 || c.LatencyTarget >= 1 {
+		return fmt.Errorf("latency target must be between 0 and 1, got %v", c.LatencyTarget)
+	}
+	if !slices.Contains(httpLatencyBuckets, c.LatencyThreshold) {
+		return fmt.Errorf("latency threshold %v is not a histogram bucket, use one of %v", c.LatencyThreshold, httpLatencyBuckets)
+	}
+	return nil
+}
+
+// sloRules builds recording and alerting rules for the availability and latency SLOs.
+func sloRules(c sloConfig) ruleFile {
+	selector := fmt.Sprintf(`endpoint=%q`, c.Endpoint)
+	le := strconv.FormatFloat(c.LatencyThreshold, 'f', -1, 64)
+
+	var records, alerts []rule
+	windows := []string{}
+	for _, w := range burnRateWindows {
+		for _, window := range []string{w.Long, w.Short} {
+			if !slices.Contains(windows, window) {
+				windows = append(windows, window)
+			}
+		}
+	}
+
+	for _, window := range windows {
+		records = append(records,
+			rule{
+				Record: "slo:http_request_errors:ratio_rate" + window,
+				Expr: fmt.Sprintf(`sum(rate(%s{%s,status_class="5xx"}[%s])) / sum(rate(%s{%s}[%s]))`,
+					metricHTTPRequestErrorsTotal, selector, window, metricHTTPRequestsTotal, selector, window),
+				Labels: map[string]string{"endpoint": c.Endpoint},
+			},
+			rule{
+				Record: "slo:http_request_slow:ratio_rate" + window,
+				Expr: fmt.Sprintf(`1 - sum(rate(%s_bucket{%s,le="%s"}[%s])) / sum(rate(%s_count{%s}[%s]))`,
+					metricHTTPRequestDuration, selector, le, window, metricHTTPRequestDuration, selector, window),
+				Labels: map[string]string{"endpoint": c.Endpoint},
+			},
+		)
+	}
+
+	objectives := []struct {
+		name, record, summary string
+		budget                float64
+	}{
+		{"Availability", "slo:http_request_errors:ratio_rate", "error budget", 1 - c.Availability},
+		{"Latency", "slo:http_request_slow:ratio_rate", fmt.Sprintf("latency budget (%ss)", le), 1 - c.LatencyTarget},
+	}
+	for _, o := range objectives {
+		for _, w := range burnRateWindows {
+			threshold := strconv.FormatFloat(w.BurnRate*o.budget, 'g', 6, 64)
+			alerts = append(alerts, rule{
+				Alert: fmt.Sprintf("%sSLOBurnRate%s", o.name, strings.ToUpper(w.Long)),
+				Expr: fmt.Sprintf(`%s%s{%s} > %s and %s%s{%s} > %s`,
+					o.record, w.Long, selector, threshold, o.record, w.Short, selector, threshold),
+				Labels: map[string]string{"severity": w.Severity, "endpoint": c.Endpoint},
+				Annotations: map[string]string{
+					"summary": fmt.Sprintf("%s is burning its %s %vx too fast over %s", c.Endpoint, o.summary, w.BurnRate, w.Long),
+				},
+			})
+		}
+	}
+
+	return ruleFile{Groups: []ruleGroup{
+		{Name: "resume-backend-slo-recordings", Rules: records},
+		{Name: "resume-backend-slo-alerts", Rules: alerts},
+	}}
+}
+
+// runSLORulesCommand implements the "slo-rules" subcommand, writing Prometheus rules as YAML to out.
+func runSLORulesCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("slo-rules", flag.ContinueOnError)
+	c := sloConfig{}
+	fs.StringVar(&c.Endpoint, "endpoint", apiPath, "endpoint label the SLOs apply to")
+	fs.Float64Var(&c.Availability, "availability", 0.999, "availability objective (fraction of non-5xx responses)")
+	fs.Float64Var(&c.LatencyTarget, "latency-target", 0.99, "fraction of requests that must complete within -latency-threshold")
+	fs.Float64Var(&c.LatencyThreshold, "latency-threshold", 0.1, "latency threshold in seconds (must be a histogram bucket)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(sloRules(c)); err != nil {
+		return fmt.Errorf("failed to encode rules: %w", err)
+	}
+	return enc.Close()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func Test_sloConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  sloConfig
+		wantErr bool
+	}{
+		{"Valid", sloConfig{Endpoint: apiPath, Availability: 0.999, LatencyTarget: 0.99, LatencyThreshold: 0.1}, false},
+		{"Availability out of range", sloConfig{Endpoint: apiPath, Availability: 1, LatencyTarget: 0.99, LatencyThreshold: 0.1}, true},
+		{"Latency target out of range", sloConfig{Endpoint: apiPath, Availability: 0.999, LatencyTarget: 0, LatencyThreshold: 0.1}, true},
+		{"Threshold not a bucket", sloConfig{Endpoint: apiPath, Availability: 0.999, LatencyTarget: 0.99, LatencyThreshold: 0.2}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_runSLORulesCommand(t *testing.T) {
+	var out bytes.Buffer
+	if err := runSLORulesCommand([]string{"-availability", "0.995", "-latency-threshold", "0.05"}, &out); err != nil {
+		t.Fatalf("runSLORulesCommand() error = %v", err)
+	}
+
+	var rules ruleFile
+	if err := yaml.Unmarshal(out.Bytes(), &rules); err != nil {
+		t.Fatalf("could not parse generated rules: %v", err)
+	}
+	if len(rules.Groups) != 2 {
+		t.Fatalf("expected 2 rule groups, got %d", len(rules.Groups))
+	}
+
+	// Every rule must reference metrics the service actually exports
+	exported := []string{metricHTTPRequestsTotal, metricHTTPRequestDuration, metricHTTPRequestErrorsTotal}
+	records := map[string]bool{}
+	for _, r := range rules.Groups[0].Rules {
+		records[r.Record] = true
+		found := false
+		for _, m := range exported {
+			if strings.Contains(r.Expr, m) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("recording rule %s doesn't reference an exported metric: %s", r.Record, r.Expr)
+		}
+		if !strings.Contains(r.Expr, `endpoint="/api/count"`) {
+			t.Errorf("recording rule %s isn't scoped to the endpoint: %s", r.Record, r.Expr)
+		}
+	}
+
+	// Every alert must only use recording rules defined above
+	if got := len(rules.Groups[1].Rules); got != 2*len(burnRateWindows) {
+		t.Errorf("expected %d alerts, got %d", 2*len(burnRateWindows), got)
+	}
+	for _, a := range rules.Groups[1].Rules {
+		for _, field := range strings.Fields(a.Expr) {
+			name, _, ok := strings.Cut(field, "{")
+			if ok && !records[name] {
+				t.Errorf("alert %s references unknown series %s", a.Alert, name)
+			}
+		}
+	}
+
+	if !strings.Contains(out.String(), `le="0.05"`) {
+		t.Errorf("expected latency rules to use the 0.05 bucket, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), "> 0.072") {
+		t.Errorf("expected a 14.4x burn rate threshold of 0.072 for a 99.5%% SLO, got %s", out.String())
+	}
+}
+
+func Test_runSLORulesCommand_InvalidFlags(t *testing.T) {
+	var out bytes.Buffer
+	if err := runSLORulesCommand([]string{"-latency-threshold", "0.3"}, &out); err == nil {
+		t.Error("expected an error for a threshold that isn't a bucket")
+	}
+}
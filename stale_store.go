@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"resume-backend/internal/logging"
+)
+
+// staleWarning is the Warning header sent with a count the database couldn't confirm
+const staleWarning = `111 - "Revalidation Failed"`
+
+// staleCountError is returned with the last known visit count when reading the current one
+// failed. Callers that can live with an old count use it, others treat it as the error.
+type staleCountError struct {
+	err error
+	age time.Duration // how long ago the count was read
+}
+
+func (e *staleCountError) Error() string {
+	return fmt.Sprintf("serving visit count from %s ago: %v", e.age.Round(time.Second), e.err)
+}
+
+func (e *staleCountError) Unwrap() error {
+	return e.err
+}
+
+// staleCountStore is a DataStore decorator that remembers the last visit count read, so a
+// database outage doesn't turn the resume page's counter into an error.
+type staleCountStore struct {
+	DataStore
+	clock Clock
+
+	mu     sync.Mutex
+	count  int
+	readAt time.Time // zero until a count has been read
+}
+
+func newStaleCountStore(ds DataStore, clock Clock) *staleCountStore {
+	return &staleCountStore{DataStore: ds, clock: clock}
+}
+
+// GetVisitCount reads the count, falling back to the last one read with a *staleCountError
+// when that fails. A canceled or timed-out caller gets its own error instead.
+func (s *staleCountStore) GetVisitCount(ctx context.Context) (int, error) {
+	count, err := s.DataStore.GetVisitCount(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.count, s.readAt = count, s.clock.Now()
+		return count, nil
+	}
+	if s.readAt.IsZero() || ctx.Err() != nil {
+		return 0, err
+	}
+	return s.count, &staleCountError{err: err, age: s.clock.Now().Sub(s.readAt)}
+}
+
+// visitCountResponse is the body returned by GET /api/count and GET /api/count/poll.
+type visitCountResponse struct {
+	Visits int  `json:"visits"`
+	Stale  bool `json:"stale,omitempty"` // the database is unreachable and this is the last known count
+}
+
+// appendJSON appends the response as encoding/json would write it, newline included, without
+// the reflection and allocations that takes.
+func (resp visitCountResponse) appendJSON(b []byte) []byte {
+	b = append(b, `{"visits":`...)
+	b = strconv.AppendInt(b, int64(resp.Visits), 10)
+	if resp.Stale {
+		b = append(b, `,"stale":true`...)
+	}
+	return append(b, "}\n"...)
+}
+
+// writeVisitCount answers with the count read from dataStore, or with the last known one,
+// marked stale, when dataStore returned it along with a *staleCountError. As the most read
+// response, it is written without allocating when the client takes JSON.
+func writeVisitCount(w http.ResponseWriter, r *http.Request, count int, err error) {
+	resp := visitCountResponse{Visits: count}
+	if err != nil && isStaleCount(err) {
+		w.Header().Set("Warning", staleWarning)
+		w.Header().Set("Cache-Control", "no-store")
+		resp.Stale = true
+	}
+
+	enc := negotiateEncoder(r)
+	if _, ok := enc.(jsonEncoder); !ok {
+		writeResponse(w, r, resp)
+		return
+	}
+	setResponseHeaders(w, enc)
+	b := getResponseBuffer()
+	defer putResponseBuffer(b)
+	if _, err := w.Write(resp.appendJSON(b.buf.AvailableBuffer())); err != nil {
+		logging.FromContext(r.Context()).Printf("Error encoding response: %v", err)
+	}
+}
+
+// isStaleCount reports whether err came with the last known count. It's kept out of the
+// callers so their success path doesn't allocate the target errors.As needs.
+func isStaleCount(err error) bool {
+	var stale *staleCountError
+	return errors.As(err, &stale)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"resume-backend/internal/store"
+	"resume-backend/internal/store/storetest"
+)
+
+// outageStore returns err from GetVisitCount once it is set.
+type outageStore struct {
+	MockDataStore
+	err error
+}
+
+func (s *outageStore) GetVisitCount(ctx context.Context) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.MockDataStore.GetVisitCount(ctx)
+}
+
+func Test_staleCountStore(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	outage := errors.New("connection refused")
+	ds := &outageStore{MockDataStore: MockDataStore{visitCount: 7}, err: outage}
+	s := newStaleCountStore(ds, clock)
+	ctx := context.Background()
+
+	if _, err := s.GetVisitCount(ctx); !errors.Is(err, outage) || errors.As(err, new(*staleCountError)) {
+		t.Fatalf("expected the outage error before any count was read; got %v", err)
+	}
+
+	ds.err = nil
+	if count, err := s.GetVisitCount(ctx); err != nil || count != 7 {
+		t.Fatalf("GetVisitCount() = %d, %v; want 7", count, err)
+	}
+
+	ds.err = outage
+	ds.visitCount = 9
+	clock.Advance(90 * time.Second)
+	count, err := s.GetVisitCount(ctx)
+	var stale *staleCountError
+	if !errors.As(err, &stale) || !errors.Is(err, outage) || count != 7 {
+		t.Fatalf("expected the last known count with a stale error; got %d, %v", count, err)
+	}
+	if stale.age != 90*time.Second {
+		t.Errorf("expected the count's age; got %s", stale.age)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.GetVisitCount(canceled); errors.As(err, new(*staleCountError)) {
+		t.Errorf("expected a canceled caller to get its own error; got %v", err)
+	}
+}
+
+func Test_staleCountStore_Cancellation(t *testing.T) {
+	storetest.CheckCancellation(t, func(pool store.DatabasePool) store.DataStore {
+		return newStaleCountStore(store.NewPostgresStore(pool), realClock{})
+	})
+}
+
+func Test_getVisitCount_Stale(t *testing.T) {
+	ds := &outageStore{MockDataStore: MockDataStore{visitCount: 7}}
+	s := newStaleCountStore(ds, realClock{})
+	if _, err := s.GetVisitCount(context.Background()); err != nil {
+		t.Fatalf("GetVisitCount() error = %v", err)
+	}
+	ds.err = errors.New("connection refused")
+
+	w := httptest.NewRecorder()
+	getVisitCount(w, httptest.NewRequest(http.MethodGet, apiPath, nil), s)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200; got %d", w.Code)
+	}
+	if got := w.Header().Get("Warning"); got != staleWarning {
+		t.Errorf("expected Warning %q; got %q", staleWarning, got)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("expected a stale count not to be cached; got Cache-Control %q", got)
+	}
+	var resp visitCountResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if resp != (visitCountResponse{Visits: 7, Stale: true}) {
+		t.Errorf("expected the last known count marked stale; got %+v", resp)
+	}
+}
+
+func Test_getVisitCount_Fresh(t *testing.T) {
+	w := httptest.NewRecorder()
+	getVisitCount(w, httptest.NewRequest(http.MethodGet, apiPath, nil), newStaleCountStore(&MockDataStore{visitCount: 7}, realClock{}))
+
+	if w.Header().Get("Warning") != "" {
+		t.Errorf("expected no Warning for a fresh count; got %q", w.Header().Get("Warning"))
+	}
+	if body := w.Body.String(); body != `{"visits":7}`+"\n" {
+		t.Errorf("expected no stale field for a fresh count; got %s", body)
+	}
+}
+
+func Test_visitCountResponse_appendJSON(t *testing.T) {
+	for _, resp := range []visitCountResponse{{}, {Visits: 1234567}, {Visits: -1}, {Visits: 7, Stale: true}} {
+		want, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(resp.appendJSON(nil)); got != string(want)+"\n" {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+}
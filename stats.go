@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	statsPath        = "/api/stats"
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+)
+
+// parseTimezone returns the location named by the tz query parameter, UTC without one. The
+// name is passed on to Postgres, so only IANA names are accepted: not "Local", which
+// LoadLocation would take to mean the server's own zone, nor an empty name.
+func parseTimezone(r *http.Request) (*time.Location, error) {
+	query := r.URL.Query()
+	if !query.Has("tz") {
+		return time.UTC, nil
+	}
+	tz := query.Get("tz")
+	if tz == "" || tz == "Local" {
+		return nil, fmt.Errorf("invalid timezone %q: must be an IANA name such as Europe/Berlin", tz)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone: %s", tz)
+	}
+	return loc, nil
+}
+
+// dailyStat is one entry of the stats response.
+type dailyStat struct {
+	Date   string `json:"date"`
+	Visits int    `json:"visits"`
+}
+
+// statsResponse is the body returned by GET /api/stats. Deploys marks the frontend
+// deployments made in the window, so traffic changes can be lined up with site updates.
+type statsResponse struct {
+	Timezone string         `json:"timezone"`
+	Days     []dailyStat    `json:"days"`
+	Deploys  []deployMarker `json:"deploys"`
+}
+
+// dailyBuckets returns one bucket per calendar day in loc, oldest first, filling in
+// days without visits, for the days ending with the day containing now.
+func dailyBuckets(counts []DailyCount, now time.Time, days int, loc *time.Location) []dailyStat {
+	byDate := make(map[string]int, len(counts))
+	for _, c := range counts {
+		byDate[c.Date.Format(time.DateOnly)] += c.Visits
+	}
+
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	stats := make([]dailyStat, 0, days)
+	for i := days - 1; i >= 0; i-- {
+		date := today.AddDate(0, 0, -i).Format(time.DateOnly)
+		stats = append(stats, dailyStat{Date: date, Visits: byDate[date]})
+	}
+	return stats
+}
+
+// statsHandler returns daily visit counts, bucketed by calendar day in the tz query parameter.
+// With summaries on, refresh=true counts the visits live instead.
+func statsHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	loc, err := parseTimezone(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, ok := statsContext(w, r)
+	if !ok {
+		return
+	}
+
+	now := clock.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from := today.AddDate(0, 0, -(days - 1))
+	to := today.AddDate(0, 0, 1)
+
+	counts, err := dataStore.GetDailyVisits(ctx, from, to, loc)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	deploys, err := dataStore.GetDeploys(ctx, from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get deploys: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := statsResponse{Timezone: loc.String(), Days: dailyBuckets(counts, now, days, loc), Deploys: deployMarkers(deploys)}
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_dailyBuckets(t *testing.T) {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatalf("could not load location: %v", err)
+	}
+
+	// 02:00 UTC on March 3rd is still March 2nd in Los Angeles
+	now := time.Date(2024, 3, 3, 2, 0, 0, 0, time.UTC)
+	counts := []DailyCount{
+		{Date: time.Date(2024, 3, 2, 0, 0, 0, 0, loc), Visits: 5},
+		{Date: time.Date(2024, 2, 29, 0, 0, 0, 0, loc), Visits: 1},
+	}
+
+	got := dailyBuckets(counts, now, 4, loc)
+	want := []dailyStat{
+		{Date: "2024-02-28", Visits: 0},
+		{Date: "2024-02-29", Visits: 1},
+		{Date: "2024-03-01", Visits: 0},
+		{Date: "2024-03-02", Visits: 5},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("dailyBuckets() returned %d days, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("dailyBuckets()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func Test_statsHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 2, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name           string
+		query          string
+		method         string
+		expectedStatus int
+		wantTimezone   string
+		wantDays       int
+		wantFrom       time.Time
+	}{
+		{"Defaults to UTC and 30 days", "", http.MethodGet, http.StatusOK, "UTC", 30, time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{"Timezone aligns buckets", "?days=2&tz=America/Los_Angeles", http.MethodGet, http.StatusOK, "America/Los_Angeles", 2, time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)},
+		{"Unknown timezone", "?tz=Mars/Olympus", http.MethodGet, http.StatusBadRequest, "", 0, time.Time{}},
+		{"Server's local timezone", "?tz=Local", http.MethodGet, http.StatusBadRequest, "", 0, time.Time{}},
+		{"Empty timezone", "?tz=", http.MethodGet, http.StatusBadRequest, "", 0, time.Time{}},
+		{"Invalid days", "?days=0", http.MethodGet, http.StatusBadRequest, "", 0, time.Time{}},
+		{"Invalid method", "", http.MethodPost, http.StatusMethodNotAllowed, "", 0, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, statsPath+tt.query, nil)
+
+			statsHandler(w, req, mockDataStore, clock)
+
+			res := w.Result()
+			if res.StatusCode != tt.expectedStatus {
+				t.Fatalf("expected status %d; got %v", tt.expectedStatus, res.Status)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response statsResponse
+			if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if response.Timezone != tt.wantTimezone {
+				t.Errorf("expected timezone %s; got %s", tt.wantTimezone, response.Timezone)
+			}
+			if len(response.Days) != tt.wantDays {
+				t.Errorf("expected %d days; got %d", tt.wantDays, len(response.Days))
+			}
+			if !mockDataStore.lastFrom.Equal(tt.wantFrom) {
+				t.Errorf("expected query to start at %v; got %v", tt.wantFrom, mockDataStore.lastFrom.UTC())
+			}
+		})
+	}
+}
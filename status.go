@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	statusPath = "/api/status"
+
+	statusPingTimeout = 2 * time.Second
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = ""
+
+// buildVersion returns version, falling back to the VCS revision Go stamps into the binary.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+				return setting.Value[:12]
+			}
+		}
+	}
+	return "dev"
+}
+
+// statusDatabase is the database part of the status response.
+type statusDatabase struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// statusLatency reports handler latency percentiles in milliseconds.
+type statusLatency struct {
+	WindowSeconds float64 `json:"window_seconds"`
+	Requests      uint64  `json:"requests"`
+	P50Ms         float64 `json:"p50_ms"`
+	P95Ms         float64 `json:"p95_ms"`
+	P99Ms         float64 `json:"p99_ms"`
+}
+
+// statusResponse is the body returned by GET /api/status.
+type statusResponse struct {
+	Status        string         `json:"status"` // "ok", or "degraded" when the database is unreachable
+	Version       string         `json:"version"`
+	UptimeSeconds float64        `json:"uptime_seconds"`
+	Database      statusDatabase `json:"database"`
+	Latency       statusLatency  `json:"latency"`
+}
+
+// statusHandler reports the service's own health for a status badge. It answers 200 even
+// when the database is down, so the badge can say so instead of failing to load.
+func statusHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, latencies *latencyWindow, started time.Time, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := statusResponse{
+		Status:        "ok",
+		Version:       buildVersion(),
+		UptimeSeconds: clock.Now().Sub(started).Seconds(),
+		Database:      statusDatabase{Healthy: true},
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), statusPingTimeout)
+	defer cancel()
+	if err := dataStore.Ping(ctx); err != nil {
+		response.Status = "degraded"
+		response.Database = statusDatabase{Healthy: false, Error: err.Error()}
+	}
+
+	summary := latencies.Summary()
+	response.Latency = statusLatency{
+		WindowSeconds: latencies.Window().Seconds(),
+		Requests:      summary.Requests,
+		P50Ms:         milliseconds(summary.P50),
+		P95Ms:         milliseconds(summary.P95),
+		P99Ms:         milliseconds(summary.P99),
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, response)
+}
+
+// milliseconds converts d to fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
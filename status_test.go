@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_statusHandler(t *testing.T) {
+	started := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	clock := newFakeClock(started.Add(90 * time.Second))
+	latencies := newLatencyWindow(time.Minute, clock)
+	latencies.Record(20 * time.Millisecond)
+
+	tests := []struct {
+		name       string
+		store      *MockDataStore
+		wantStatus string
+		wantError  string
+	}{
+		{"Healthy", &MockDataStore{}, "ok", ""},
+		{"Database down", &MockDataStore{pingErr: errors.New("connection refused")}, "degraded", "connection refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			statusHandler(w, httptest.NewRequest(http.MethodGet, statusPath, nil), tt.store, latencies, started, clock)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200; got %d", w.Code)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("expected Cache-Control no-store; got %q", got)
+			}
+
+			var response statusResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if response.Status != tt.wantStatus || response.Database.Healthy != (tt.wantError == "") || response.Database.Error != tt.wantError {
+				t.Errorf("expected status %q with database error %q; got %+v", tt.wantStatus, tt.wantError, response)
+			}
+			if response.UptimeSeconds != 90 {
+				t.Errorf("expected 90s of uptime; got %v", response.UptimeSeconds)
+			}
+			if response.Version == "" {
+				t.Error("expected a version")
+			}
+			if response.Latency.WindowSeconds != 60 || response.Latency.Requests != 1 || response.Latency.P99Ms < 20 {
+				t.Errorf("expected one 20ms request in a 60s window; got %+v", response.Latency)
+			}
+		})
+	}
+}
+
+func Test_statusHandler_InvalidMethod(t *testing.T) {
+	w := httptest.NewRecorder()
+	statusHandler(w, httptest.NewRequest(http.MethodPost, statusPath, nil), &MockDataStore{}, newLatencyWindow(time.Minute, realClock{}), time.Now(), realClock{})
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405; got %d", w.Code)
+	}
+}
+
+func Test_buildVersion(t *testing.T) {
+	defer func(v string) { version = v }(version)
+
+	version = "v1.2.3"
+	if got := buildVersion(); got != "v1.2.3" {
+		t.Errorf("buildVersion() = %q, want the linker-set version", got)
+	}
+
+	version = ""
+	if got := buildVersion(); got == "" {
+		t.Error("buildVersion() returned an empty version")
+	}
+}
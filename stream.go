@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// streamSink publishes records to a message stream; kafkaProducer is the implementation.
+type streamSink interface {
+	Publish(value []byte, timestamp time.Time)
+}
+
+// streamRecord is the JSON value published for each recorded visit or event.
+type streamRecord struct {
+	Kind        string          `json:"kind"` // "visit" or "event"
+	Timestamp   string          `json:"timestamp"`
+	Referrer    string          `json:"referrer,omitempty"`
+	UTMSource   string          `json:"utm_source,omitempty"`
+	UTMMedium   string          `json:"utm_medium,omitempty"`
+	UTMCampaign string          `json:"utm_campaign,omitempty"`
+	EventType   string          `json:"event_type,omitempty"`
+	SessionID   string          `json:"session_id,omitempty"`
+	Properties  json.RawMessage `json:"properties,omitempty"`
+}
+
+// streamProcessor publishes visits and events to a stream.
+type streamProcessor struct {
+	name string
+	sink streamSink
+}
+
+func (p *streamProcessor) Name() string {
+	return p.name
+}
+
+// ProcessVisit publishes the visit.
+func (p *streamProcessor) ProcessVisit(ctx context.Context, visit Visit) error {
+	return p.publish(newVisitRecord(visit), visit.Timestamp)
+}
+
+// ProcessEvent publishes the event.
+func (p *streamProcessor) ProcessEvent(ctx context.Context, event Event) error {
+	return p.publish(streamRecord{
+		Kind:       "event",
+		Timestamp:  event.Timestamp.UTC().Format(time.RFC3339Nano),
+		EventType:  event.Type,
+		SessionID:  event.SessionID,
+		Properties: event.Properties,
+	}, event.Timestamp)
+}
+
+// OutboxPayload returns the stream record for the visit.
+func (p *streamProcessor) OutboxPayload(visit Visit) (json.RawMessage, error) {
+	value, err := json.Marshal(newVisitRecord(visit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode stream record: %w", err)
+	}
+	return value, nil
+}
+
+// Deliver publishes a record returned by OutboxPayload. The outbox covers the way to the
+// sink; once published, the record is subject to the sink's own buffering.
+func (p *streamProcessor) Deliver(ctx context.Context, payload json.RawMessage) error {
+	var record streamRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return fmt.Errorf("failed to decode stream record: %w", err)
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, record.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid stream record timestamp %q: %w", record.Timestamp, err)
+	}
+	// A sink that can refuse a record fails the delivery, so the outbox retries it later
+	if offerer, ok := p.sink.(interface {
+		Offer(value []byte, timestamp time.Time) error
+	}); ok {
+		return offerer.Offer(payload, timestamp)
+	}
+	p.sink.Publish(payload, timestamp)
+	return nil
+}
+
+// Close flushes the sink if it buffers records.
+func (p *streamProcessor) Close() {
+	if closer, ok := p.sink.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+func (p *streamProcessor) publish(record streamRecord, timestamp time.Time) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode stream record: %w", err)
+	}
+	p.sink.Publish(value, timestamp)
+	return nil
+}
+
+// newVisitRecord returns the stream record for a visit.
+func newVisitRecord(visit Visit) streamRecord {
+	return streamRecord{
+		Kind:        "visit",
+		Timestamp:   visit.Timestamp.UTC().Format(time.RFC3339Nano),
+		Referrer:    visit.Referrer,
+		UTMSource:   visit.UTM.Source,
+		UTMMedium:   visit.UTM.Medium,
+		UTMCampaign: visit.UTM.Campaign,
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// recordingStream keeps published values
+type recordingStream struct {
+	values []string
+	closed bool
+}
+
+func (s *recordingStream) Publish(value []byte, timestamp time.Time) {
+	s.values = append(s.values, string(value))
+}
+
+func (s *recordingStream) Close() {
+	s.closed = true
+}
+
+func Test_streamProcessor(t *testing.T) {
+	sink := &recordingStream{}
+	p := &streamProcessor{name: "test", sink: sink}
+	ts := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	if err := p.ProcessVisit(context.Background(), Visit{Timestamp: ts, Referrer: "github.com", UTM: UTM{Campaign: "launch"}}); err != nil {
+		t.Fatalf("ProcessVisit() error = %v", err)
+	}
+	event := Event{Type: "clicked_github", Timestamp: ts, SessionID: "abc", Properties: json.RawMessage(`{"section":"header"}`)}
+	if err := p.ProcessEvent(context.Background(), event); err != nil {
+		t.Fatalf("ProcessEvent() error = %v", err)
+	}
+
+	want := []string{
+		`{"kind":"visit","timestamp":"2024-03-03T10:00:00Z","referrer":"github.com","utm_campaign":"launch"}`,
+		`{"kind":"event","timestamp":"2024-03-03T10:00:00Z","event_type":"clicked_github","session_id":"abc","properties":{"section":"header"}}`,
+	}
+	if len(sink.values) != len(want) {
+		t.Fatalf("expected %d records; got %q", len(want), sink.values)
+	}
+	for i := range want {
+		if sink.values[i] != want[i] {
+			t.Errorf("record %d = %s, want %s", i, sink.values[i], want[i])
+		}
+	}
+
+	p.Close()
+	if !sink.closed {
+		t.Error("expected Close to flush the sink")
+	}
+}
+
+func Test_streamProcessor_Outbox(t *testing.T) {
+	sink := &recordingStream{}
+	p := &streamProcessor{name: "test", sink: sink}
+	visit := Visit{Timestamp: time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC), Referrer: "github.com"}
+
+	payload, err := p.OutboxPayload(visit)
+	if err != nil {
+		t.Fatalf("OutboxPayload() error = %v", err)
+	}
+	if len(sink.values) != 0 {
+		t.Fatal("expected nothing to be published before delivery")
+	}
+	if err := p.Deliver(context.Background(), payload); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+	if want := `{"kind":"visit","timestamp":"2024-03-03T10:00:00Z","referrer":"github.com"}`; len(sink.values) != 1 || sink.values[0] != want {
+		t.Errorf("expected %s to be published; got %q", want, sink.values)
+	}
+
+	if err := p.Deliver(context.Background(), json.RawMessage(`{"kind":"visit"}`)); err == nil {
+		t.Error("expected an error for a record without a timestamp")
+	}
+}
+
+// fullStream refuses every record offered to it
+type fullStream struct {
+	recordingStream
+}
+
+func (s *fullStream) Offer(value []byte, timestamp time.Time) error {
+	return errors.New("buffer is full")
+}
+
+func Test_streamProcessor_DeliverFull(t *testing.T) {
+	sink := &fullStream{}
+	p := &streamProcessor{name: "test", sink: sink}
+	payload, err := p.OutboxPayload(Visit{Timestamp: time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("OutboxPayload() error = %v", err)
+	}
+	// The dispatcher retries the message, rather than it being spilled again
+	if err := p.Deliver(context.Background(), payload); err == nil || len(sink.values) != 0 {
+		t.Errorf("expected the delivery to fail without publishing, got %v and %q", err, sink.values)
+	}
+}
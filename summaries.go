@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultSummaryRefreshInterval bounds how far behind the summarized stats can fall
+const defaultSummaryRefreshInterval = 5 * time.Minute
+
+type summaryConfig struct {
+	Enabled         bool
+	RefreshInterval time.Duration
+}
+
+// loadSummaryConfig reads STATS_SUMMARIES, which serves stats and referrers from summaries
+// of the visits when "true", and STATS_SUMMARY_REFRESH_INTERVAL.
+func loadSummaryConfig() (summaryConfig, error) {
+	cfg := summaryConfig{RefreshInterval: defaultSummaryRefreshInterval}
+	if v := os.Getenv("STATS_SUMMARIES"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return summaryConfig{}, fmt.Errorf("invalid STATS_SUMMARIES %q: must be true or false", v)
+		}
+		cfg.Enabled = enabled
+	}
+	if v := os.Getenv("STATS_SUMMARY_REFRESH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return summaryConfig{}, fmt.Errorf("invalid STATS_SUMMARY_REFRESH_INTERVAL %q: must be a positive duration", v)
+		}
+		cfg.RefreshInterval = d
+	}
+	return cfg, nil
+}
+
+type liveStatsKey struct{}
+
+// statsContext returns the request's context, marked to skip the summaries when the refresh
+// query parameter is true. It writes a 400 and returns false when refresh is not a boolean.
+func statsContext(w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	ctx := r.Context()
+	if v := r.URL.Query().Get("refresh"); v != "" {
+		refresh, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "refresh must be true or false", http.StatusBadRequest)
+			return nil, false
+		}
+		if refresh {
+			ctx = context.WithValue(ctx, liveStatsKey{}, true)
+		}
+	}
+	return ctx, true
+}
+
+// summaryStore is a DataStore decorator that answers the heavy aggregations from summaries
+// refreshed on a schedule, unless the caller asked for live numbers with statsContext.
+type summaryStore struct {
+	DataStore
+}
+
+func newSummaryStore(ds DataStore) *summaryStore {
+	return &summaryStore{DataStore: ds}
+}
+
+func (s *summaryStore) GetDailyVisits(ctx context.Context, from, to time.Time, loc *time.Location) ([]DailyCount, error) {
+	if ctx.Value(liveStatsKey{}) != nil {
+		return s.DataStore.GetDailyVisits(ctx, from, to, loc)
+	}
+	return s.DataStore.GetSummarizedDailyVisits(ctx, from, to, loc)
+}
+
+func (s *summaryStore) GetTopReferrers(ctx context.Context, from, to time.Time, limit int) ([]ReferrerCount, error) {
+	if ctx.Value(liveStatsKey{}) != nil {
+		return s.DataStore.GetTopReferrers(ctx, from, to, limit)
+	}
+	return s.DataStore.GetSummarizedTopReferrers(ctx, from, to, limit)
+}
+
+// runSummaryRefresher refreshes the summaries straight away and then every interval until
+// ctx is done.
+func runSummaryRefresher(ctx context.Context, dataStore DataStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Failures are logged by the store and retried on the next tick
+		_ = dataStore.RefreshSummaries(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
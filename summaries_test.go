@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_summaryStore(t *testing.T) {
+	now := time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		handler    func(http.ResponseWriter, *http.Request, DataStore, Clock)
+		url        string
+		status     int
+		summarized int
+	}{
+		{"Summarized stats", statsHandler, statsPath + "?days=7", http.StatusOK, 1},
+		{"Live stats", statsHandler, statsPath + "?days=7&refresh=true", http.StatusOK, 0},
+		{"Summarized referrers", referrersHandler, referrersPath + "?refresh=false", http.StatusOK, 1},
+		{"Live referrers", referrersHandler, referrersPath + "?refresh=1", http.StatusOK, 0},
+		{"Invalid refresh", referrersHandler, referrersPath + "?refresh=soon", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDataStore := &MockDataStore{}
+			rr := httptest.NewRecorder()
+			tt.handler(rr, httptest.NewRequest(http.MethodGet, tt.url, nil), newSummaryStore(mockDataStore), newFakeClock(now))
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			if mockDataStore.summarized != tt.summarized {
+				t.Errorf("expected %d summarized reads, got %d", tt.summarized, mockDataStore.summarized)
+			}
+		})
+	}
+}
+
+// refreshCountingStore counts the summary refreshes it is asked for
+type refreshCountingStore struct {
+	MockDataStore
+	refreshed chan struct{}
+}
+
+func (s *refreshCountingStore) RefreshSummaries(ctx context.Context) error {
+	s.refreshed <- struct{}{}
+	return nil
+}
+
+func Test_runSummaryRefresher(t *testing.T) {
+	store := &refreshCountingStore{refreshed: make(chan struct{}, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runSummaryRefresher(ctx, store, 10*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-store.refreshed:
+		case <-time.After(time.Second):
+			t.Fatalf("expected refresh %d", i+1)
+		}
+	}
+}
+
+func Test_loadSummaryConfig(t *testing.T) {
+	cfg, err := loadSummaryConfig()
+	if err != nil || cfg.Enabled || cfg.RefreshInterval != defaultSummaryRefreshInterval {
+		t.Errorf("unexpected defaults %+v, %v", cfg, err)
+	}
+
+	t.Setenv("STATS_SUMMARIES", "true")
+	t.Setenv("STATS_SUMMARY_REFRESH_INTERVAL", "1m")
+	cfg, err = loadSummaryConfig()
+	if err != nil || !cfg.Enabled || cfg.RefreshInterval != time.Minute {
+		t.Errorf("unexpected config %+v, %v", cfg, err)
+	}
+
+	for env, v := range map[string]string{"STATS_SUMMARIES": "sometimes", "STATS_SUMMARY_REFRESH_INTERVAL": "-1m"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, v)
+			if _, err := loadSummaryConfig(); err == nil {
+				t.Errorf("expected an error for %s=%q", env, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	summaryPath = "/api/summary"
+
+	// summaryTrendDays is how many days the summary's trend covers, today included
+	summaryTrendDays = 7
+)
+
+// summaryResponse is the body returned by GET /api/summary.
+type summaryResponse struct {
+	Visits         int         `json:"visits"`
+	Today          int         `json:"today"`
+	UniqueVisitors int         `json:"unique_visitors"` // daily unique visitors summed over the trend's days
+	Timezone       string      `json:"timezone"`
+	Trend          []dailyStat `json:"trend"`
+}
+
+// summaryHandler returns the total visit count, today's visits, unique visitors and the
+// daily trend of the last week in one response, read in one query so they agree with each
+// other. Days are calendar days in the tz query parameter, as for the stats.
+func summaryHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	loc, err := parseTimezone(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := clock.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from := today.AddDate(0, 0, -(summaryTrendDays - 1))
+	to := today.AddDate(0, 0, 1)
+
+	summary, err := dataStore.GetSummary(r.Context(), from, to, loc)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get summary: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Today's count is the trend's last day, so the two can't disagree
+	trend := dailyBuckets(summary.Daily, now, summaryTrendDays, loc)
+	writeResponse(w, r, summaryResponse{
+		Visits:         summary.Visits,
+		Today:          trend[len(trend)-1].Visits,
+		UniqueVisitors: summary.UniqueVisitors,
+		Timezone:       loc.String(),
+		Trend:          trend,
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_summaryHandler(t *testing.T) {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatalf("could not load location: %v", err)
+	}
+	// 02:00 UTC on March 3rd is still March 2nd in Los Angeles
+	now := time.Date(2024, 3, 3, 2, 0, 0, 0, time.UTC)
+	mockDataStore := &MockDataStore{
+		visitCount: 120,
+		uniques:    map[string]bool{"2024-03-02/a": true, "2024-03-02/b": true},
+		dailyCounts: []DailyCount{
+			{Date: time.Date(2024, 2, 27, 0, 0, 0, 0, loc), Visits: 3},
+			{Date: time.Date(2024, 3, 2, 0, 0, 0, 0, loc), Visits: 5},
+		},
+	}
+
+	rr := httptest.NewRecorder()
+	summaryHandler(rr, httptest.NewRequest(http.MethodGet, summaryPath+"?tz=America/Los_Angeles", nil), mockDataStore, newFakeClock(now))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp summaryResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if resp.Visits != 120 || resp.Today != 5 || resp.UniqueVisitors != 2 || resp.Timezone != "America/Los_Angeles" {
+		t.Errorf("unexpected summary %+v", resp)
+	}
+	if len(resp.Trend) != summaryTrendDays || resp.Trend[0] != (dailyStat{Date: "2024-02-25"}) || resp.Trend[2] != (dailyStat{Date: "2024-02-27", Visits: 3}) {
+		t.Errorf("unexpected trend %+v", resp.Trend)
+	}
+
+	// The week ends with today in the requested zone
+	wantFrom, wantTo := time.Date(2024, 2, 25, 0, 0, 0, 0, loc), time.Date(2024, 3, 3, 0, 0, 0, 0, loc)
+	if !mockDataStore.lastFrom.Equal(wantFrom) || !mockDataStore.lastTo.Equal(wantTo) {
+		t.Errorf("expected the window [%s, %s); got [%s, %s)", wantFrom, wantTo, mockDataStore.lastFrom, mockDataStore.lastTo)
+	}
+}
+
+func Test_summaryHandler_InvalidRequest(t *testing.T) {
+	for _, tt := range []struct {
+		method, url string
+		status      int
+	}{
+		{http.MethodPost, summaryPath, http.StatusMethodNotAllowed},
+		{http.MethodGet, summaryPath + "?tz=Not/AZone", http.StatusBadRequest},
+		{http.MethodGet, summaryPath + "?tz=Local", http.StatusBadRequest},
+	} {
+		rr := httptest.NewRecorder()
+		summaryHandler(rr, httptest.NewRequest(tt.method, tt.url, nil), &MockDataStore{}, newFakeClock(time.Now()))
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.url, tt.status, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Set from TRACING_ENABLED by configureTracing
+var tracingEnabled bool
+
+// configureTracing enables trace propagation features when TRACING_ENABLED is set.
+func configureTracing() {
+	tracingEnabled, _ = strconv.ParseBool(os.Getenv("TRACING_ENABLED"))
+}
+
+// traceIDFromRequest extracts the trace ID from a W3C traceparent header
+// ("version-traceid-parentid-flags"), returning false if it's missing or malformed.
+func traceIDFromRequest(r *http.Request) (string, bool) {
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return "", false
+	}
+
+	traceID := strings.ToLower(parts[1])
+	if _, err := hex.DecodeString(traceID); err != nil || traceID == strings.Repeat("0", 32) {
+		return "", false
+	}
+	return traceID, true
+}
+
+type traceIDKey struct{}
+
+// withTraceID returns a copy of ctx carrying r's trace ID, if it has one, so work done for the
+// request outside the handler, such as database queries, can be tied to its trace.
+func withTraceID(ctx context.Context, r *http.Request) context.Context {
+	if traceID, ok := traceIDFromRequest(r); ok {
+		return context.WithValue(ctx, traceIDKey{}, traceID)
+	}
+	return ctx
+}
+
+// traceIDFromContext returns the trace ID withTraceID attached to ctx.
+func traceIDFromContext(ctx context.Context) (string, bool) {
+	traceID, ok := ctx.Value(traceIDKey{}).(string)
+	return traceID, ok
+}
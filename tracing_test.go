@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_traceIDFromRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		want        string
+		wantOK      bool
+	}{
+		{"Valid traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"Uppercase trace ID", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"Missing header", "", "", false},
+		{"Wrong field count", "00-4bf92f3577b34da6a3ce929d0e0e4736-01", "", false},
+		{"Non-hex trace ID", "00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", false},
+		{"All-zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+
+			got, ok := traceIDFromRequest(req)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("traceIDFromRequest() = (%s, %v), want (%s, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_withTraceID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := traceIDFromContext(withTraceID(req.Context(), req)); ok {
+		t.Error("expected no trace ID without a traceparent header")
+	}
+
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if got, ok := traceIDFromContext(withTraceID(req.Context(), req)); !ok || got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the request's trace ID, got (%s, %v)", got, ok)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const uniqueCountPath = "/api/count/unique"
+
+const defaultSketchFlushInterval = 10 * time.Second
+
+// visitorHasher derives privacy-preserving visitor IDs. The salt rotates every UTC day,
+// so hashes can't be linked across days, and the raw IP is never stored.
+type visitorHasher struct {
+	secret []byte
+}
+
+// newVisitorHasherFromEnv keys the hasher with UNIQUES_SECRET. Without it a random key is
+// generated, which means a restart starts the day's unique count over.
+func newVisitorHasherFromEnv() *visitorHasher {
+	if secret := os.Getenv("UNIQUES_SECRET"); secret != "" {
+		return &visitorHasher{secret: []byte(secret)}
+	}
+
+	log.Println("UNIQUES_SECRET not set, using a random key; unique counts reset on restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("failed to generate uniques key: %v", err)
+	}
+	return &visitorHasher{secret: secret}
+}
+
+// dailySalt derives the salt for the UTC day containing t.
+func (h *visitorHasher) dailySalt(t time.Time) []byte {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(t.UTC().Format(time.DateOnly)))
+	return mac.Sum(nil)
+}
+
+// Hash returns the visitor's ID for the day containing now, from their IP and User-Agent.
+func (h *visitorHasher) Hash(r *http.Request, now time.Time) string {
+	mac := hmac.New(sha256.New, h.dailySalt(now))
+	mac.Write([]byte(clientIP(r)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(r.UserAgent()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SketchKey returns a 64-bit key for the visitor's IP and User-Agent that, unlike Hash, is
+// stable across days. It only ever feeds a HyperLogLog, which keeps register maxima rather
+// than keys, so multi-day uniques can be estimated without storing anything linkable.
+func (h *visitorHasher) SketchKey(r *http.Request) uint64 {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(clientIP(r)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(r.UserAgent()))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// visitorSketches keeps the current UTC day's HyperLogLog in memory and persists it to the
+// DataStore at most once per flush interval. Persisting merges with the stored sketch first,
+// so restarts and several instances writing the same day don't lose visitors.
+type visitorSketches struct {
+	store         DataStore
+	flushInterval time.Duration
+
+	mu        sync.Mutex
+	day       string
+	today     *hyperLogLog
+	dirty     bool
+	flushing  bool // a flush of today's sketch is in progress
+	lastFlush time.Time
+}
+
+func newVisitorSketches(store DataStore, flushInterval time.Duration) *visitorSketches {
+	return &visitorSketches{store: store, flushInterval: flushInterval}
+}
+
+// loadSketchFlushInterval reads UNIQUES_SKETCH_FLUSH_INTERVAL from the environment.
+func loadSketchFlushInterval() time.Duration {
+	v := os.Getenv("UNIQUES_SKETCH_FLUSH_INTERVAL")
+	if v == "" {
+		return defaultSketchFlushInterval
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("Invalid UNIQUES_SKETCH_FLUSH_INTERVAL %q, using %s", v, defaultSketchFlushInterval)
+		return defaultSketchFlushInterval
+	}
+	return d
+}
+
+// Add records key for the UTC day containing now, persisting the day's sketch when it is due.
+// The sketch is copied under the lock and written once it is released, so other visits aren't
+// held up behind the database.
+func (v *visitorSketches) Add(ctx context.Context, now time.Time, key uint64) error {
+	v.mu.Lock()
+	var previous, due *daySketch
+	if day := now.UTC().Format(time.DateOnly); day != v.day {
+		// Best effort: the previous day's sketch is dropped from memory either way
+		if v.dirty {
+			previous = &daySketch{day: v.day, sketch: *v.today}
+		}
+		v.day, v.today, v.dirty, v.lastFlush = day, newHyperLogLog(), false, time.Time{}
+	}
+
+	v.today.Add(key)
+	v.dirty = true
+	if !v.flushing && now.Sub(v.lastFlush) >= v.flushInterval {
+		due = &daySketch{day: v.day, sketch: *v.today}
+		v.flushing, v.dirty, v.lastFlush = true, false, now
+	}
+	v.mu.Unlock()
+
+	if previous != nil {
+		_ = v.flush(ctx, previous)
+	}
+	if due == nil {
+		return nil
+	}
+	err := v.flush(ctx, due)
+	v.mu.Lock()
+	v.flushing = false
+	if err != nil && v.day == due.day {
+		// Retried by the next add
+		v.dirty, v.lastFlush = true, time.Time{}
+	}
+	v.mu.Unlock()
+	return err
+}
+
+// daySketch is a copy of a day's in-memory sketch, taken to be persisted without the lock.
+type daySketch struct {
+	day    string
+	sketch hyperLogLog
+}
+
+// flush merges the stored sketch for the day into the copy and writes it back. Merging is
+// idempotent, so a failed or racing flush is repaired by the next one.
+func (v *visitorSketches) flush(ctx context.Context, s *daySketch) error {
+	day, _ := time.Parse(time.DateOnly, s.day)
+	stored, err := v.store.GetVisitorSketches(ctx, day, day)
+	if err != nil {
+		return err
+	}
+	for _, data := range stored {
+		sketch := newHyperLogLog()
+		if err := sketch.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("failed to decode visitor sketch for %s: %w", s.day, err)
+		}
+		s.sketch.Merge(sketch)
+	}
+
+	data, _ := s.sketch.MarshalBinary()
+	return v.store.SaveVisitorSketch(ctx, day, data)
+}
+
+// Estimate approximates the distinct visitors across the UTC days from through to, inclusive,
+// merging the stored daily sketches with the unflushed in-memory one.
+func (v *visitorSketches) Estimate(ctx context.Context, from, to time.Time) (int, error) {
+	stored, err := v.store.GetVisitorSketches(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	merged, sketch := newHyperLogLog(), newHyperLogLog()
+	for _, data := range stored {
+		if err := sketch.UnmarshalBinary(data); err != nil {
+			return 0, fmt.Errorf("failed to decode visitor sketch: %w", err)
+		}
+		merged.Merge(sketch)
+	}
+
+	v.mu.Lock()
+	if v.today != nil && v.day >= from.UTC().Format(time.DateOnly) && v.day <= to.UTC().Format(time.DateOnly) {
+		merged.Merge(v.today)
+	}
+	v.mu.Unlock()
+
+	return merged.Estimate(), nil
+}
+
+// middleware that records the visitor's daily hash and sketch key after each successful POST
+func uniqueVisitorMiddleware(next http.Handler, dataStore DataStore, hasher *visitorHasher, sketches *visitorSketches, clock Clock) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := newResponseRecorder(w)
+		next.ServeHTTP(rw, r)
+
+		if r.Method != http.MethodPost || rw.Status() >= http.StatusMultipleChoices || !trackingAllowed(r.Context()) {
+			return
+		}
+		// The visit itself is already recorded, so a failure here only affects unique counts
+		now := clock.Now()
+		_ = dataStore.RecordUniqueVisitor(r.Context(), now, hasher.Hash(r, now))
+		_ = sketches.Add(r.Context(), now, hasher.SketchKey(r))
+	})
+}
+
+// uniqueCountHandler returns raw visits alongside unique visitors for the last days UTC days.
+// unique_visitors sums exact daily uniques; approximate_unique_visitors deduplicates across days.
+func uniqueCountHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, sketches *visitorSketches, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := 1
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	now := clock.Now().UTC()
+	from := now.AddDate(0, 0, -(days - 1))
+	uniques, err := dataStore.GetUniqueVisitorCount(r.Context(), from, now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get unique visitor count: %v", err), http.StatusInternalServerError)
+		return
+	}
+	approxUniques, err := sketches.Estimate(r.Context(), from, now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to estimate unique visitors: %v", err), http.StatusInternalServerError)
+		return
+	}
+	visits, err := dataStore.GetVisitCount(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get visit count: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]int{
+		"visits":                      visits,
+		"unique_visitors":             uniques,
+		"approximate_unique_visitors": approxUniques,
+		"days":                        days,
+	}
+	writeResponse(w, r, response)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_visitorHasher_Hash(t *testing.T) {
+	h := &visitorHasher{secret: []byte("test-secret")}
+	day := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	newReq := func(ip, ua string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+		req.RemoteAddr = ip + ":12345"
+		req.Header.Set("User-Agent", ua)
+		return req
+	}
+
+	a := h.Hash(newReq("203.0.113.7", "Firefox"), day)
+	if a != h.Hash(newReq("203.0.113.7", "Firefox"), day.Add(5*time.Hour)) {
+		t.Error("expected the same visitor to hash identically within a UTC day")
+	}
+	if a == h.Hash(newReq("203.0.113.7", "Firefox"), day.Add(24*time.Hour)) {
+		t.Error("expected the salt to rotate on the next UTC day")
+	}
+	if a == h.Hash(newReq("203.0.113.8", "Firefox"), day) {
+		t.Error("expected different IPs to hash differently")
+	}
+	if a == h.Hash(newReq("203.0.113.7", "Chrome"), day) {
+		t.Error("expected different User-Agents to hash differently")
+	}
+	if len(a) != 64 {
+		t.Errorf("expected a hex SHA-256 hash, got %q", a)
+	}
+}
+
+func Test_uniqueVisitorMiddleware(t *testing.T) {
+	mockDataStore := &MockDataStore{}
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	h := &visitorHasher{secret: []byte("test-secret")}
+	sketches := newVisitorSketches(mockDataStore, 0)
+
+	tests := []struct {
+		name        string
+		method      string
+		status      int
+		ip          string
+		wantUniques int
+	}{
+		{"First visit recorded", http.MethodPost, http.StatusOK, "203.0.113.7", 1},
+		{"Repeat visit not double counted", http.MethodPost, http.StatusOK, "203.0.113.7", 1},
+		{"GET not recorded", http.MethodGet, http.StatusOK, "203.0.113.8", 1},
+		{"Failed visit not recorded", http.MethodPost, http.StatusInternalServerError, "203.0.113.9", 1},
+		{"New visitor recorded", http.MethodPost, http.StatusOK, "203.0.113.10", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			})
+			req := httptest.NewRequest(tt.method, apiPath, nil)
+			req.RemoteAddr = tt.ip + ":12345"
+
+			uniqueVisitorMiddleware(next, mockDataStore, h, sketches, clock).ServeHTTP(httptest.NewRecorder(), req)
+
+			if len(mockDataStore.uniques) != tt.wantUniques {
+				t.Errorf("expected %d unique visitors; got %d", tt.wantUniques, len(mockDataStore.uniques))
+			}
+			if got, _ := sketches.Estimate(context.Background(), clock.Now(), clock.Now()); got != tt.wantUniques {
+				t.Errorf("expected an estimate of %d unique visitors; got %d", tt.wantUniques, got)
+			}
+		})
+	}
+}
+
+func Test_uniqueCountHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	mockDataStore := &MockDataStore{visitCount: 9, uniques: map[string]bool{"a": true, "b": true}}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, uniqueCountPath+"?days=3", nil)
+	uniqueCountHandler(w, req, mockDataStore, newVisitorSketches(mockDataStore, 0), clock)
+
+	res := w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 OK; got %v", res.Status)
+	}
+
+	var response map[string]int
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if response["visits"] != 9 || response["unique_visitors"] != 2 || response["days"] != 3 {
+		t.Errorf("unexpected response %v", response)
+	}
+	if want := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC); !mockDataStore.lastFrom.Equal(want) {
+		t.Errorf("expected window to start on %v; got %v", want, mockDataStore.lastFrom)
+	}
+
+	w = httptest.NewRecorder()
+	uniqueCountHandler(w, httptest.NewRequest(http.MethodGet, uniqueCountPath+"?days=abc", nil), mockDataStore, newVisitorSketches(mockDataStore, 0), clock)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid days; got %d", w.Code)
+	}
+}
+
+func Test_visitorHasher_SketchKey(t *testing.T) {
+	h := &visitorHasher{secret: []byte("test-secret")}
+	req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+	req.Header.Set("User-Agent", "Firefox")
+
+	other := httptest.NewRequest(http.MethodPost, apiPath, nil)
+	other.Header.Set("User-Agent", "Chrome")
+
+	if h.SketchKey(req) != h.SketchKey(req) {
+		t.Error("expected sketch keys to be stable")
+	}
+	if h.SketchKey(req) == h.SketchKey(other) {
+		t.Error("expected different visitors to get different sketch keys")
+	}
+}
+
+func Test_visitorSketches(t *testing.T) {
+	ctx := context.Background()
+	mockDataStore := &MockDataStore{}
+	day1 := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+
+	// Estimates are approximate, so allow for a couple of hash collisions
+	assertAbout := func(t *testing.T, what string, got, want int) {
+		t.Helper()
+		if got < want-2 || got > want+2 {
+			t.Errorf("expected about %d %s; got %d", want, what, got)
+		}
+	}
+	persisted := func(day time.Time) int {
+		n, err := newVisitorSketches(mockDataStore, 0).Estimate(ctx, day, day)
+		if err != nil {
+			t.Fatalf("Estimate failed: %v", err)
+		}
+		return n
+	}
+
+	sketches := newVisitorSketches(mockDataStore, time.Minute)
+	for i := uint64(0); i < 100; i++ {
+		if err := sketches.Add(ctx, day1, splitmix64(i)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	// The first add flushes; the rest wait for the interval
+	assertAbout(t, "visitors persisted before the interval", persisted(day1), 1)
+
+	// Day two repeats half of day one's visitors, and rolling over persists day one in full
+	for i := uint64(50); i < 150; i++ {
+		if err := sketches.Add(ctx, day2, splitmix64(i)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	assertAbout(t, "visitors persisted for day one", persisted(day1), 100)
+
+	both, err := sketches.Estimate(ctx, day1, day2)
+	if err != nil {
+		t.Fatalf("Estimate failed: %v", err)
+	}
+	assertAbout(t, "distinct visitors across both days", both, 150)
+
+	// Once the interval passes the next add persists the whole day
+	if err := sketches.Add(ctx, day2.Add(time.Minute), splitmix64(50)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	assertAbout(t, "visitors persisted for day two", persisted(day2), 100)
+
+	// A restarted instance merges with what is already stored instead of overwriting it
+	restarted := newVisitorSketches(mockDataStore, 0)
+	if err := restarted.Add(ctx, day2, splitmix64(1000)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	assertAbout(t, "visitors persisted after a restart", persisted(day2), 101)
+}
+
+// blockingSketchStore holds sketch reads until released
+type blockingSketchStore struct {
+	*MockDataStore
+	entered, release chan struct{}
+}
+
+func (s blockingSketchStore) GetVisitorSketches(ctx context.Context, from, to time.Time) ([][]byte, error) {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.MockDataStore.GetVisitorSketches(ctx, from, to)
+}
+
+func Test_visitorSketches_FlushUnlocked(t *testing.T) {
+	ctx := context.Background()
+	store := blockingSketchStore{&MockDataStore{}, make(chan struct{}), make(chan struct{})}
+	sketches := newVisitorSketches(store, time.Minute)
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	flushed := make(chan error)
+	go func() { flushed <- sketches.Add(ctx, now, splitmix64(1)) }()
+	<-store.entered
+
+	// Visits keep being counted while the flush waits on the database, without a second flush
+	added := make(chan error)
+	go func() { added <- sketches.Add(ctx, now.Add(2*time.Minute), splitmix64(2)) }()
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Add blocked behind the flush")
+	}
+
+	close(store.release)
+	if err := <-flushed; err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	// The sketch was copied before the second visit
+	if n, _ := newVisitorSketches(store.MockDataStore, 0).Estimate(ctx, now, now); n != 1 {
+		t.Errorf("expected 1 visitor persisted, got %d", n)
+	}
+	go func() { <-store.entered }()
+	if err := sketches.Add(ctx, now.Add(4*time.Minute), splitmix64(2)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if n, _ := newVisitorSketches(store.MockDataStore, 0).Estimate(ctx, now, now); n != 2 {
+		t.Errorf("expected 2 visitors persisted, got %d", n)
+	}
+}
+
+func Test_visitorSketches_StoreErrors(t *testing.T) {
+	sketches := newVisitorSketches(failingStore{}, 0)
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	if err := sketches.Add(context.Background(), now, 1); err == nil {
+		t.Error("expected Add to report the failed flush")
+	}
+	if _, err := sketches.Estimate(context.Background(), now, now); err == nil {
+		t.Error("expected Estimate to fail when sketches can't be read")
+	}
+}
+
+func Test_loadSketchFlushInterval(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", defaultSketchFlushInterval},
+		{"1m", time.Minute},
+		{"0", 0},
+		{"soon", defaultSketchFlushInterval},
+		{"-1s", defaultSketchFlushInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("UNIQUES_SKETCH_FLUSH_INTERVAL", tt.value)
+			if got := loadSketchFlushInterval(); got != tt.want {
+				t.Errorf("loadSketchFlushInterval() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
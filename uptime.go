@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	uptimePath = "/api/uptime"
+
+	defaultUptimeInterval = time.Minute
+	defaultUptimeTimeout  = 10 * time.Second
+	defaultUptimeFailures = 2
+	defaultUptimeDays     = 7
+	maxUptimeDays         = 90
+
+	// maxUptimeBodyBytes is how much of the page a check reads, so a slow body counts
+	// towards latency without a large one being downloaded every interval
+	maxUptimeBodyBytes = 1 << 20
+
+	// uptimeAlertDestination names uptime alerts in the outbox
+	uptimeAlertDestination = "uptime-alert"
+)
+
+// uptimeConfig controls the site uptime monitor.
+type uptimeConfig struct {
+	URL        string
+	Interval   time.Duration
+	Timeout    time.Duration
+	Failures   int    // consecutive failed checks before the site is reported down
+	WebhookURL string // alerts are only logged when empty
+
+	CertDomains     []string // host:port of each TLS certificate to watch
+	CertInterval    time.Duration
+	CertWarningDays int // days left below which a certificate is alerted
+}
+
+// loadUptimeConfig reads UPTIME_URL, the page to check, which enables the monitor, along
+// with UPTIME_INTERVAL, UPTIME_TIMEOUT, UPTIME_FAILURES and UPTIME_WEBHOOK_URL. The
+// certificates of UPTIME_CERT_DOMAINS, comma-separated hosts or host:ports, are checked every
+// UPTIME_CERT_INTERVAL and alerted below UPTIME_CERT_WARNING_DAYS; the domains default to
+// UPTIME_URL's host when it is https, and none when set empty.
+func loadUptimeConfig() (uptimeConfig, bool, error) {
+	cfg := uptimeConfig{
+		URL:             os.Getenv("UPTIME_URL"),
+		Interval:        defaultUptimeInterval,
+		Timeout:         defaultUptimeTimeout,
+		Failures:        defaultUptimeFailures,
+		WebhookURL:      os.Getenv("UPTIME_WEBHOOK_URL"),
+		CertInterval:    defaultCertInterval,
+		CertWarningDays: defaultCertWarningDays,
+	}
+	if cfg.URL == "" {
+		return uptimeConfig{}, false, nil
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return uptimeConfig{}, false, fmt.Errorf("invalid UPTIME_URL %q: must be an absolute http or https URL", cfg.URL)
+	}
+
+	domains, ok := os.LookupEnv("UPTIME_CERT_DOMAINS")
+	if !ok && u.Scheme == "https" {
+		domains = u.Host
+	}
+	for _, domain := range strings.Split(domains, ",") {
+		if domain = strings.TrimSpace(domain); domain == "" {
+			continue
+		}
+		addr, err := certAddress(domain)
+		if err != nil {
+			return uptimeConfig{}, false, fmt.Errorf("invalid UPTIME_CERT_DOMAINS: %w", err)
+		}
+		cfg.CertDomains = append(cfg.CertDomains, addr)
+	}
+	if v := os.Getenv("UPTIME_CERT_WARNING_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return uptimeConfig{}, false, fmt.Errorf("invalid UPTIME_CERT_WARNING_DAYS %q: must be a positive number of days", v)
+		}
+		cfg.CertWarningDays = n
+	}
+
+	for _, d := range []struct {
+		name  string
+		value *time.Duration
+	}{{"UPTIME_INTERVAL", &cfg.Interval}, {"UPTIME_TIMEOUT", &cfg.Timeout}, {"UPTIME_CERT_INTERVAL", &cfg.CertInterval}} {
+		if v := os.Getenv(d.name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed <= 0 {
+				return uptimeConfig{}, false, fmt.Errorf("invalid %s %q: must be a positive duration", d.name, v)
+			}
+			*d.value = parsed
+		}
+	}
+	if v := os.Getenv("UPTIME_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return uptimeConfig{}, false, fmt.Errorf("invalid UPTIME_FAILURES %q: must be a positive number of checks", v)
+		}
+		cfg.Failures = n
+	}
+
+	log.Printf("Uptime monitor enabled: checking %s every %s", cfg.URL, cfg.Interval)
+	if len(cfg.CertDomains) > 0 {
+		log.Printf("Checking the certificates of %s every %s", strings.Join(cfg.CertDomains, ", "), cfg.CertInterval)
+	}
+	return cfg, true, nil
+}
+
+// uptimeAlert is the detail sent along with an uptime alert.
+type uptimeAlert struct {
+	URL       string    `json:"url"`
+	Up        bool      `json:"up"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	DownSince time.Time `json:"down_since"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// uptimeMonitor fetches the site every interval, recording each check, and alerts once the
+// site has failed Failures checks in a row and again when it recovers. It checks the
+// certificates of CertDomains too. The run of failures and the certificates alerted on are
+// kept in memory, so a new leader starts afresh.
+type uptimeMonitor struct {
+	store    DataStore
+	cfg      uptimeConfig
+	clock    Clock
+	client   *http.Client
+	notifier notifier // nil disables alerting
+
+	failures  int
+	downSince time.Time
+	alerted   bool
+
+	certRoots  *x509.CertPool // nil trusts the system's roots
+	certsDue   time.Time
+	certWarned map[string]time.Time // the expiry alerted on, by domain
+}
+
+func newUptimeMonitor(store DataStore, cfg uptimeConfig, clock Clock) *uptimeMonitor {
+	m := &uptimeMonitor{
+		store:      store,
+		cfg:        cfg,
+		clock:      clock,
+		client:     &http.Client{Timeout: cfg.Timeout},
+		certWarned: make(map[string]time.Time),
+	}
+	if cfg.WebhookURL != "" {
+		m.notifier = newWebhookNotifier(cfg.WebhookURL)
+	}
+	return m
+}
+
+// queueAlerts makes the monitor queue its alerts in store's outbox, as
+// anomalyDetector.queueAlerts does. It returns the handler that posts them, or nil when
+// alerting is disabled; call it before Run.
+func (m *uptimeMonitor) queueAlerts(store DataStore) outboxHandler {
+	webhook, ok := m.notifier.(*webhookNotifier)
+	if !ok {
+		return nil
+	}
+	m.notifier = &outboxNotifier{store: store, destination: uptimeAlertDestination}
+	return webhook
+}
+
+// fetch makes one check of the site. Any response below 400, after redirects, is up.
+func (m *uptimeMonitor) fetch(ctx context.Context) UptimeCheck {
+	check := UptimeCheck{CheckedAt: m.clock.Now(), URL: m.cfg.URL}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.cfg.URL, nil)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	req.Header.Set("User-Agent", "resume-backend uptime monitor")
+	res, err := m.client.Do(req)
+	if err != nil {
+		check.Latency = m.clock.Now().Sub(check.CheckedAt)
+		check.Error = err.Error()
+		return check
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(io.Discard, io.LimitReader(res.Body, maxUptimeBodyBytes))
+	check.Latency = m.clock.Now().Sub(check.CheckedAt)
+	check.Status = res.StatusCode
+	switch {
+	case res.StatusCode >= 400:
+		check.Error = fmt.Sprintf("status %d", res.StatusCode)
+	case err != nil:
+		check.Error = fmt.Sprintf("failed to read the page: %v", err)
+	default:
+		check.Up = true
+	}
+	return check
+}
+
+// Check fetches the site, records the result and alerts on a change of state.
+func (m *uptimeMonitor) Check(ctx context.Context) error {
+	check := m.fetch(ctx)
+	// A check that can't be recorded still counts towards alerting
+	recordErr := m.store.RecordUptimeCheck(ctx, check)
+
+	var text string
+	if check.Up {
+		if m.alerted {
+			text = fmt.Sprintf("Site %s is back up after being down for %s", m.cfg.URL, check.CheckedAt.Sub(m.downSince).Round(time.Second))
+		}
+		m.failures = 0
+	} else {
+		if m.failures == 0 {
+			m.downSince = check.CheckedAt
+		}
+		m.failures++
+		if m.failures >= m.cfg.Failures && !m.alerted {
+			text = fmt.Sprintf("Site %s is down: %s, since %s", m.cfg.URL, check.Error, m.downSince.Format(time.RFC3339))
+		}
+	}
+	if text == "" {
+		return recordErr
+	}
+
+	log.Println(text)
+	if m.notifier == nil {
+		m.alerted = !check.Up
+		return recordErr
+	}
+	alert := uptimeAlert{URL: check.URL, Up: check.Up, Status: check.Status, Error: check.Error, DownSince: m.downSince, CheckedAt: check.CheckedAt}
+	if err := m.notifier.Notify(ctx, text, alert); err != nil {
+		// Retried on the next check, as the state is only changed once the alert is out
+		errorLogger.Printf("Error sending uptime alert: %v", err)
+		return err
+	}
+	m.alerted = !check.Up
+	return recordErr
+}
+
+// Run checks straight away and then every Interval, and the certificates every
+// CertInterval, until ctx is done.
+func (m *uptimeMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		// Failures are logged by the store or notifier and retried on the next tick
+		_ = m.Check(ctx)
+		if len(m.cfg.CertDomains) > 0 && !m.clock.Now().Before(m.certsDue) {
+			_ = m.CheckCertificates(ctx)
+			m.certsDue = m.clock.Now().Add(m.cfg.CertInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// uptimeCheckResponse is one check as GET /api/uptime returns it.
+type uptimeCheckResponse struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Up        bool      `json:"up"`
+	Status    int       `json:"status"`
+	LatencyMS int64     `json:"latency_ms"`
+}
+
+// uptimeOutage is a run of failed checks.
+type uptimeOutage struct {
+	Start  time.Time  `json:"start"`
+	End    *time.Time `json:"end"` // the first check back up; null while the outage lasts
+	Checks int        `json:"checks"`
+}
+
+// uptimeResponse is the body returned by GET /api/uptime.
+type uptimeResponse struct {
+	Days             int                   `json:"days"`
+	Checks           int                   `json:"checks"`
+	Uptime           *float64              `json:"uptime"` // percentage of checks up; null without checks
+	AverageLatencyMS int64                 `json:"average_latency_ms"`
+	LastCheck        *uptimeCheckResponse  `json:"last_check"`
+	Outages          []uptimeOutage        `json:"outages"`
+	Certificates     []certificateResponse `json:"certificates"`
+}
+
+// summarizeUptime sums up checks, oldest first. Latency is averaged over the checks that
+// were up, as failures are often timeouts.
+func summarizeUptime(days int, checks []UptimeCheck) uptimeResponse {
+	resp := uptimeResponse{Days: days, Checks: len(checks), Outages: []uptimeOutage{}, Certificates: []certificateResponse{}}
+	if len(checks) == 0 {
+		return resp
+	}
+
+	var up int
+	var latency time.Duration
+	var outage *uptimeOutage
+	for _, c := range checks {
+		if c.Up {
+			up++
+			latency += c.Latency
+			if outage != nil {
+				end := c.CheckedAt
+				outage.End = &end
+				resp.Outages = append(resp.Outages, *outage)
+				outage = nil
+			}
+			continue
+		}
+		if outage == nil {
+			outage = &uptimeOutage{Start: c.CheckedAt}
+		}
+		outage.Checks++
+	}
+	if outage != nil {
+		resp.Outages = append(resp.Outages, *outage)
+	}
+
+	uptime := 100 * float64(up) / float64(len(checks))
+	resp.Uptime = &uptime
+	if up > 0 {
+		resp.AverageLatencyMS = (latency / time.Duration(up)).Milliseconds()
+	}
+	last := checks[len(checks)-1]
+	resp.LastCheck = &uptimeCheckResponse{CheckedAt: last.CheckedAt, Up: last.Up, Status: last.Status, LatencyMS: last.Latency.Milliseconds()}
+	return resp
+}
+
+// uptimeHandler reports the site's uptime over the last days days, and the latest check of
+// each certificate.
+func uptimeHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultUptimeDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxUptimeDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxUptimeDays), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	now := clock.Now()
+	checks, err := dataStore.GetUptimeChecks(r.Context(), now.AddDate(0, 0, -days), now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get uptime checks: %v", err), http.StatusInternalServerError)
+		return
+	}
+	certs, err := dataStore.GetCertificateChecks(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get certificate checks: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp := summarizeUptime(days, checks)
+	resp.Certificates = certificateResponses(certs, now)
+	writeResponse(w, r, resp)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_loadUptimeConfig(t *testing.T) {
+	if _, enabled, err := loadUptimeConfig(); enabled || err != nil {
+		t.Fatalf("expected the monitor disabled without UPTIME_URL, got %v, %v", enabled, err)
+	}
+
+	t.Setenv("UPTIME_URL", "https://example.com")
+	t.Setenv("UPTIME_INTERVAL", "30s")
+	t.Setenv("UPTIME_FAILURES", "3")
+	t.Setenv("UPTIME_WEBHOOK_URL", "https://hooks.example.com/x")
+	cfg, enabled, err := loadUptimeConfig()
+	want := uptimeConfig{
+		URL:             "https://example.com",
+		Interval:        30 * time.Second,
+		Timeout:         defaultUptimeTimeout,
+		Failures:        3,
+		WebhookURL:      "https://hooks.example.com/x",
+		CertDomains:     []string{"example.com:443"}, // the site's own, by default
+		CertInterval:    defaultCertInterval,
+		CertWarningDays: defaultCertWarningDays,
+	}
+	if err != nil || !enabled || !reflect.DeepEqual(cfg, want) {
+		t.Errorf("loadUptimeConfig() = %+v, %v, %v; want %+v", cfg, enabled, err, want)
+	}
+
+	t.Setenv("UPTIME_CERT_DOMAINS", "example.com, api.example.com:8443")
+	t.Setenv("UPTIME_CERT_INTERVAL", "6h")
+	t.Setenv("UPTIME_CERT_WARNING_DAYS", "21")
+	cfg, _, err = loadUptimeConfig()
+	if err != nil || !slices.Equal(cfg.CertDomains, []string{"example.com:443", "api.example.com:8443"}) ||
+		cfg.CertInterval != 6*time.Hour || cfg.CertWarningDays != 21 {
+		t.Errorf("unexpected certificate settings %+v, %v", cfg, err)
+	}
+	t.Setenv("UPTIME_CERT_DOMAINS", "")
+	if cfg, _, _ := loadUptimeConfig(); len(cfg.CertDomains) != 0 {
+		t.Errorf("expected no certificates checked, got %q", cfg.CertDomains)
+	}
+
+	for name, value := range map[string]string{
+		"UPTIME_URL":               "example.com",
+		"UPTIME_TIMEOUT":           "0s",
+		"UPTIME_FAILURES":          "0",
+		"UPTIME_CERT_DOMAINS":      "https://example.com/",
+		"UPTIME_CERT_WARNING_DAYS": "0",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, _, err := loadUptimeConfig(); err == nil {
+				t.Errorf("expected %s=%q rejected", name, value)
+			}
+		})
+	}
+}
+
+func Test_uptimeMonitor_Check(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer site.Close()
+
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+	mockDataStore := &MockDataStore{}
+	n := &recordingNotifier{}
+	m := newUptimeMonitor(mockDataStore, uptimeConfig{URL: site.URL, Timeout: time.Second, Failures: 2}, clock)
+	m.notifier = n
+	check := func() {
+		t.Helper()
+		if err := m.Check(context.Background()); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		clock.Advance(time.Minute)
+	}
+
+	check()
+	if c := mockDataStore.uptimeChecks[0]; !c.Up || c.Status != http.StatusOK || c.URL != site.URL || c.Error != "" {
+		t.Errorf("expected an up check recorded, got %+v", c)
+	}
+
+	// A single failure isn't reported; the second in a row is, once
+	status.Store(http.StatusBadGateway)
+	check()
+	if len(n.texts) != 0 {
+		t.Fatalf("expected no alert after one failure, got %q", n.texts)
+	}
+	check()
+	check()
+	if len(n.texts) != 1 || !strings.Contains(n.texts[0], "is down: status 502, since 2024-03-03T10:01:00Z") {
+		t.Fatalf("expected one down alert, got %q", n.texts)
+	}
+	if c := mockDataStore.uptimeChecks[3]; c.Up || c.Status != http.StatusBadGateway || c.Error != "status 502" {
+		t.Errorf("expected a failed check recorded, got %+v", c)
+	}
+
+	// Recovery is reported, and a failed alert is retried on the next check
+	status.Store(http.StatusOK)
+	n.err = errors.New("webhook down")
+	if err := m.Check(context.Background()); err == nil {
+		t.Error("expected the notifier error returned")
+	}
+	clock.Advance(time.Minute)
+	n.err = nil
+	check()
+	check()
+	if len(n.texts) != 3 || !strings.Contains(n.texts[2], "is back up after being down for 4m0s") {
+		t.Errorf("expected the recovery alerted once it went through, got %q", n.texts)
+	}
+	if len(mockDataStore.uptimeChecks) != 7 {
+		t.Errorf("expected every check recorded, got %d", len(mockDataStore.uptimeChecks))
+	}
+}
+
+func Test_uptimeMonitor_Check_Unreachable(t *testing.T) {
+	site := httptest.NewServer(http.NotFoundHandler())
+	site.Close()
+
+	mockDataStore := &MockDataStore{}
+	n := &recordingNotifier{}
+	m := newUptimeMonitor(mockDataStore, uptimeConfig{URL: site.URL, Timeout: time.Second, Failures: 1}, newFakeClock(time.Now()))
+	m.notifier = n
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if c := mockDataStore.uptimeChecks[0]; c.Up || c.Status != 0 || c.Error == "" {
+		t.Errorf("expected a failed check without a status, got %+v", c)
+	}
+	if len(n.texts) != 1 {
+		t.Errorf("expected the site reported down, got %q", n.texts)
+	}
+
+	// A check that can't be recorded is still counted
+	m = newUptimeMonitor(failingStore{}, uptimeConfig{URL: site.URL, Timeout: time.Second, Failures: 1}, newFakeClock(time.Now()))
+	m.notifier = n
+	if err := m.Check(context.Background()); err == nil || len(n.texts) != 2 {
+		t.Errorf("expected the store error returned after alerting, got %v with %d alerts", err, len(n.texts))
+	}
+}
+
+func Test_summarizeUptime(t *testing.T) {
+	start := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+	checks := []UptimeCheck{
+		{CheckedAt: at(0), Up: true, Status: 200, Latency: 100 * time.Millisecond},
+		{CheckedAt: at(1), Status: 503, Latency: 50 * time.Millisecond},
+		{CheckedAt: at(2), Latency: 10 * time.Second},
+		{CheckedAt: at(3), Up: true, Status: 200, Latency: 300 * time.Millisecond},
+		{CheckedAt: at(4), Status: 500},
+	}
+
+	got := summarizeUptime(7, checks)
+	if got.Checks != 5 || got.Uptime == nil || *got.Uptime != 40 || got.AverageLatencyMS != 200 {
+		t.Errorf("unexpected summary %+v", got)
+	}
+	if len(got.Outages) != 2 || !got.Outages[0].Start.Equal(at(1)) || !got.Outages[0].End.Equal(at(3)) || got.Outages[0].Checks != 2 ||
+		!got.Outages[1].Start.Equal(at(4)) || got.Outages[1].End != nil {
+		t.Errorf("expected an outage that ended and one that hasn't, got %+v", got.Outages)
+	}
+	if got.LastCheck == nil || got.LastCheck.Up || got.LastCheck.Status != 500 {
+		t.Errorf("unexpected last check %+v", got.LastCheck)
+	}
+
+	if empty := summarizeUptime(7, nil); empty.Uptime != nil || empty.LastCheck != nil || empty.Outages == nil {
+		t.Errorf("expected no uptime without checks, got %+v", empty)
+	}
+}
+
+func Test_uptimeHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	mockDataStore := &MockDataStore{uptimeChecks: []UptimeCheck{
+		{CheckedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Status: 500},
+		{CheckedAt: time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC), Up: true, Status: 200},
+	}, certChecks: []CertificateCheck{
+		{Domain: "example.com:443", CheckedAt: time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC), ExpiresAt: time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)},
+	}}
+
+	rr := httptest.NewRecorder()
+	uptimeHandler(rr, httptest.NewRequest(http.MethodGet, uptimePath, nil), mockDataStore, clock)
+	var resp uptimeResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rr.Body.String(), err)
+	}
+	if resp.Days != defaultUptimeDays || resp.Checks != 1 || *resp.Uptime != 100 {
+		t.Errorf("expected the last week's check only, got %s", rr.Body.String())
+	}
+	if !mockDataStore.lastFrom.Equal(time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected range start %v", mockDataStore.lastFrom)
+	}
+	if len(resp.Certificates) != 1 || *resp.Certificates[0].DaysRemaining != 10 {
+		t.Errorf("expected the certificate with 10 days left, got %+v", resp.Certificates)
+	}
+
+	for _, tt := range []struct {
+		method, query string
+		store         DataStore
+		status        int
+	}{
+		{http.MethodGet, "?days=30", mockDataStore, http.StatusOK},
+		{http.MethodGet, "?days=91", mockDataStore, http.StatusBadRequest},
+		{http.MethodPost, "", mockDataStore, http.StatusMethodNotAllowed},
+		{http.MethodGet, "", failingStore{}, http.StatusInternalServerError},
+	} {
+		rr := httptest.NewRecorder()
+		uptimeHandler(rr, httptest.NewRequest(tt.method, uptimePath+tt.query, nil), tt.store, clock)
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.query, tt.status, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"resume-backend/internal/store"
+)
+
+const defaultVerifyMaxSkew = 5 * time.Minute
+
+// integrityVerifier cross-checks stored data; the Postgres store does it in SQL
+type integrityVerifier interface {
+	VerifyIntegrity(ctx context.Context, maxSkew time.Duration, repair bool) ([]IntegrityCheck, error)
+}
+
+// runVerifyCommand checks the database configured by the environment for future
+// timestamps, unpruned dedupe keys and summary views out of step with the visits, printing
+// a report to out. It fails if problems are found and, with -repair, not fixed.
+func runVerifyCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "fix the problems found")
+	maxSkew := flags.Duration("max-skew", defaultVerifyMaxSkew, "how far in the future a timestamp may be before it counts as wrong, allowing for clock skew")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *maxSkew < 0 {
+		return errors.New("-max-skew must not be negative")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	dataStore, err := store.SetupDatabase(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to set up database: %w", err)
+	}
+	defer dataStore.Close()
+	verifier, ok := dataStore.(integrityVerifier)
+	if !ok {
+		return errors.New("the database does not support integrity checks")
+	}
+	return verify(ctx, verifier, *maxSkew, *repair, out)
+}
+
+// verify runs the checks and reports each one, returning an error if any problem is left.
+func verify(ctx context.Context, verifier integrityVerifier, maxSkew time.Duration, repair bool, out io.Writer) error {
+	results, err := verifier.VerifyIntegrity(ctx, maxSkew, repair)
+	for _, result := range results {
+		switch {
+		case result.Problems == 0:
+			fmt.Fprintf(out, "ok       %s\n", result.Name)
+		case result.Repaired:
+			fmt.Fprintf(out, "repaired %s: %d %s\n", result.Name, result.Problems, result.Description)
+		default:
+			fmt.Fprintf(out, "FAILED   %s: %d %s\n", result.Name, result.Problems, result.Description)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var left int64
+	for _, result := range results {
+		if !result.Repaired {
+			left += result.Problems
+		}
+	}
+	if left > 0 {
+		return fmt.Errorf("%d integrity problems found; run verify -repair to fix them", left)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeVerifier reports its results, marking them repaired when asked to repair
+type fakeVerifier struct {
+	results []IntegrityCheck
+	err     error
+}
+
+func (v *fakeVerifier) VerifyIntegrity(ctx context.Context, maxSkew time.Duration, repair bool) ([]IntegrityCheck, error) {
+	results := append([]IntegrityCheck(nil), v.results...)
+	for i := range results {
+		results[i].Repaired = repair && results[i].Problems > 0
+	}
+	return results, v.err
+}
+
+func Test_verify(t *testing.T) {
+	verifier := &fakeVerifier{results: []IntegrityCheck{
+		{Name: "future_visits", Description: "visits dated in the future", Problems: 2},
+		{Name: "visits_rollup", Description: "quarter hours out of step"},
+	}}
+
+	var out bytes.Buffer
+	err := verify(context.Background(), verifier, time.Minute, false, &out)
+	if err == nil || !strings.Contains(err.Error(), "2 integrity problems") {
+		t.Errorf("expected the problems left reported, got %v", err)
+	}
+	want := "FAILED   future_visits: 2 visits dated in the future\nok       visits_rollup\n"
+	if out.String() != want {
+		t.Errorf("expected report %q, got %q", want, out.String())
+	}
+
+	out.Reset()
+	if err := verify(context.Background(), verifier, time.Minute, true, &out); err != nil {
+		t.Errorf("expected repaired problems to pass, got %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "repaired future_visits: 2") {
+		t.Errorf("expected the repair reported, got %q", out.String())
+	}
+
+	// Checks made before a failure are still reported
+	verifier.err = errors.New("failed to check future_events")
+	out.Reset()
+	if err := verify(context.Background(), verifier, time.Minute, true, &out); err != verifier.err {
+		t.Errorf("expected the check error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "visits_rollup") {
+		t.Errorf("expected the completed checks reported, got %q", out.String())
+	}
+}
+
+func Test_runVerifyCommand_Flags(t *testing.T) {
+	for _, args := range [][]string{{"-max-skew", "-1m"}, {"-unknown"}} {
+		if err := runVerifyCommand(args, io.Discard); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	visitTokenPath       = "/api/token"
+	visitTokenHeader     = "X-Visit-Token"
+	defaultVisitTokenTTL = 10 * time.Minute
+)
+
+// visitTokens issues and checks the short-lived tokens the frontend echoes when it records a
+// visit. A token is its expiry plus an HMAC over the expiry and the client's IP and
+// User-Agent, so it can't be forged or shared with other clients, and nothing is stored.
+// With visit nonces on, each token is also only accepted once; see visitNonces.
+type visitTokens struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// newVisitTokensFromEnv requires tokens on POST /api/count when VISIT_TOKEN_SECRET is set,
+// returning nil otherwise. VISIT_TOKEN_TTL sets how long a token stays valid.
+func newVisitTokensFromEnv() *visitTokens {
+	secret := os.Getenv("VISIT_TOKEN_SECRET")
+	if secret == "" {
+		return nil
+	}
+
+	t := &visitTokens{secret: []byte(secret), ttl: defaultVisitTokenTTL}
+	if v := os.Getenv("VISIT_TOKEN_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid VISIT_TOKEN_TTL %q, using %s", v, t.ttl)
+		} else {
+			t.ttl = d
+		}
+	}
+	return t
+}
+
+// sign returns the MAC binding expires to the client making r.
+func (t *visitTokens) sign(r *http.Request, expires int64) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(clientIP(r)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(r.UserAgent()))
+	return mac.Sum(nil)
+}
+
+// Issue returns a token for the client making r, valid until the returned time.
+func (t *visitTokens) Issue(r *http.Request, now time.Time) (string, time.Time) {
+	expires := now.Add(t.ttl).Truncate(time.Second)
+	token := strconv.FormatInt(expires.Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString(t.sign(r, expires.Unix()))
+	return token, expires
+}
+
+// Valid reports whether token was issued to the client making r and hasn't expired.
+func (t *visitTokens) Valid(r *http.Request, token string, now time.Time) bool {
+	expiresPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresPart, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sig, t.sign(r, expires))
+}
+
+// visitTokenResponse is returned by GET /api/token.
+type visitTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// visitTokenHandler issues a token for the caller to send in the X-Visit-Token header.
+func visitTokenHandler(w http.ResponseWriter, r *http.Request, tokens *visitTokens, clock Clock) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if tokens == nil {
+		http.Error(w, "Visit tokens are not enabled", http.StatusNotFound)
+		return
+	}
+
+	token, expires := tokens.Issue(r, clock.Now())
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, visitTokenResponse{Token: token, ExpiresAt: expires.UTC()})
+}
+
+// middleware that rejects visits without a valid X-Visit-Token when tokens are enabled
+func visitTokenMiddleware(next http.Handler, tokens *visitTokens, clock Clock) http.Handler {
+	if tokens == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && !tokens.Valid(r, r.Header.Get(visitTokenHeader), clock.Now()) {
+			forbiddenLogger.Printf("Missing or invalid visit token: %s %s", r.Method, r.URL.Path)
+			http.Error(w, localize(w, r, "visit_token_invalid"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
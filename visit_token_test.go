@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_newVisitTokensFromEnv(t *testing.T) {
+	if tokens := newVisitTokensFromEnv(); tokens != nil {
+		t.Fatal("expected visit tokens to be off without VISIT_TOKEN_SECRET")
+	}
+
+	t.Setenv("VISIT_TOKEN_SECRET", "secret")
+	t.Setenv("VISIT_TOKEN_TTL", "2m")
+	if tokens := newVisitTokensFromEnv(); tokens == nil || tokens.ttl != 2*time.Minute {
+		t.Errorf("expected a 2m TTL; got %+v", tokens)
+	}
+
+	t.Setenv("VISIT_TOKEN_TTL", "forever")
+	if tokens := newVisitTokensFromEnv(); tokens.ttl != defaultVisitTokenTTL {
+		t.Errorf("expected an invalid TTL to fall back to %s; got %s", defaultVisitTokenTTL, tokens.ttl)
+	}
+}
+
+func Test_visitTokens(t *testing.T) {
+	tokens := &visitTokens{secret: []byte("secret"), ttl: time.Minute}
+	now := time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	visitor := func(ip, userAgent string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, apiPath, nil)
+		r.RemoteAddr = ip + ":5555"
+		r.Header.Set("User-Agent", userAgent)
+		return r
+	}
+	token, expires := tokens.Issue(visitor("203.0.113.7", "Firefox"), now)
+	if !expires.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected the token to expire after the TTL; got %s", expires)
+	}
+
+	tests := []struct {
+		name  string
+		r     *http.Request
+		token string
+		now   time.Time
+		want  bool
+	}{
+		{"Same client", visitor("203.0.113.7", "Firefox"), token, now.Add(30 * time.Second), true},
+		{"Expired", visitor("203.0.113.7", "Firefox"), token, now.Add(time.Minute), false},
+		{"Other IP", visitor("198.51.100.1", "Firefox"), token, now, false},
+		{"Other User-Agent", visitor("203.0.113.7", "curl/8.0"), token, now, false},
+		{"Extended expiry", visitor("203.0.113.7", "Firefox"), "9999999999" + token[len("1709460060"):], now, false},
+		{"Malformed", visitor("203.0.113.7", "Firefox"), "not-a-token", now, false},
+		{"Missing", visitor("203.0.113.7", "Firefox"), "", now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokens.Valid(tt.r, tt.token, tt.now); got != tt.want {
+				t.Errorf("Valid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_visitTokenHandler(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 3, 10, 0, 0, 0, time.UTC))
+
+	rr := httptest.NewRecorder()
+	visitTokenHandler(rr, httptest.NewRequest(http.MethodGet, visitTokenPath, nil), nil, clock)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 when tokens are off; got %d", rr.Code)
+	}
+
+	tokens := &visitTokens{secret: []byte("secret"), ttl: time.Minute}
+	rr = httptest.NewRecorder()
+	visitTokenHandler(rr, httptest.NewRequest(http.MethodGet, visitTokenPath, nil), tokens, clock)
+	var body visitTokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if !body.ExpiresAt.Equal(clock.Now().Add(time.Minute)) || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("unexpected response %+v with Cache-Control %q", body, rr.Header().Get("Cache-Control"))
+	}
+
+	rr = httptest.NewRecorder()
+	visitTokenHandler(rr, httptest.NewRequest(http.MethodPost, visitTokenPath, nil), tokens, clock)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST; got %d", rr.Code)
+	}
+}
+
+func Test_visitTokenRoutes(t *testing.T) {
+	t.Setenv("VISIT_TOKEN_SECRET", "secret")
+	useFakeMetrics(t)
+	mockDataStore := &MockDataStore{}
+	mux := http.NewServeMux()
+	registerRoutes(mux, mockDataStore, realClock{})
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, apiPath, nil))
+	if rr.Code != http.StatusForbidden || mockDataStore.visitCount != 0 {
+		t.Fatalf("expected a visit without a token to be rejected; got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, visitTokenPath, nil))
+	var body visitTokenResponse
+	json.NewDecoder(rr.Body).Decode(&body)
+
+	req := httptest.NewRequest(http.MethodPost, apiPath, nil)
+	req.Header.Set(visitTokenHeader, body.Token)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || mockDataStore.visitCount != 1 {
+		t.Errorf("expected a visit with a token to be recorded; got %d", rr.Code)
+	}
+
+	// Reading the count needs no token
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, apiPath, nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected GET to be allowed without a token; got %d", rr.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	visitsExportPath         = "/api/admin/visits/export"
+	defaultVisitsExportLimit = 10000
+	maxVisitsExportLimit     = 1000000
+
+	// visitsExportFlushRows is how many visits are written between flushes, so the client
+	// sees steady progress without a flush per row
+	visitsExportFlushRows = 500
+
+	// visitsExportWriteTimeout bounds each write, so a client that stops reading releases the
+	// database connection rather than holding it open
+	visitsExportWriteTimeout = 30 * time.Second
+)
+
+// visitsExportHandler streams visits with IDs above after_id, oldest first, as the database
+// returns them. Writes block while the client is slow to read, which holds back the rows
+// still to come rather than buffering them. Each response stops at limit visits; the client
+// pages on by passing the last ID it got as after_id. Visits from the last minute are left
+// out until they settle, as with the exporter, so paging never skips a row.
+func visitsExportHandler(w http.ResponseWriter, r *http.Request, dataStore DataStore, clock Clock, token string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !adminAuthorized(w, r, token) {
+		return
+	}
+
+	var afterID int64
+	if v := r.URL.Query().Get("after_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "after_id must be a visit ID", http.StatusBadRequest)
+			return
+		}
+		afterID = n
+	}
+	limit := defaultVisitsExportLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxVisitsExportLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxVisitsExportLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	array := false
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	case "json":
+		array = true
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		http.Error(w, "format must be ndjson or json", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	written := 0
+	err := dataStore.StreamVisits(r.Context(), afterID, clock.Now().Add(-exportSettleDelay), limit, func(v VisitRow) error {
+		// Not every writer supports deadlines; those that don't are not sockets
+		_ = rc.SetWriteDeadline(time.Now().Add(visitsExportWriteTimeout))
+		if array {
+			sep := ","
+			if written == 0 {
+				sep = "["
+			}
+			if _, err := io.WriteString(w, sep); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(newVisitLine(v)); err != nil {
+			return err
+		}
+		written++
+		if written%visitsExportFlushRows == 0 {
+			_ = rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if written == 0 {
+			http.Error(w, fmt.Sprintf("Failed to export visits: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// The status is already sent; cutting the response short tells the client it is
+		// incomplete, where ending it normally would look like the last page
+		errorLogger.Printf("Visits export failed after %d visits: %v", written, err)
+		panic(http.ErrAbortHandler)
+	}
+	if array {
+		end := "]\n"
+		if written == 0 {
+			end = "[]\n"
+		}
+		_, _ = io.WriteString(w, end)
+	}
+}
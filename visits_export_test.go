@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func exportTestStore(now time.Time) *MockDataStore {
+	return &MockDataStore{visitRows: []VisitRow{
+		{ID: 1, Visit: Visit{Timestamp: now.Add(-time.Hour), Page: "blog", Referrer: "linkedin.com"}},
+		{ID: 2, Visit: Visit{Timestamp: now.Add(-30 * time.Minute), UTM: UTM{Source: "newsletter"}}},
+		{ID: 3, Visit: Visit{Timestamp: now.Add(-10 * time.Minute)}},
+		// Too recent to have settled
+		{ID: 4, Visit: Visit{Timestamp: now.Add(-time.Second)}},
+	}}
+}
+
+func Test_visitsExportHandler(t *testing.T) {
+	now := time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		url    string
+		auth   string
+		status int
+		ids    []int64
+	}{
+		{"Wrong token", visitsExportPath, "Bearer nope", http.StatusUnauthorized, nil},
+		{"All settled visits", visitsExportPath, "Bearer admin-token", http.StatusOK, []int64{1, 2, 3}},
+		{"Next page", visitsExportPath + "?after_id=1&limit=1", "Bearer admin-token", http.StatusOK, []int64{2}},
+		{"Last page", visitsExportPath + "?after_id=3", "Bearer admin-token", http.StatusOK, nil},
+		{"Invalid after_id", visitsExportPath + "?after_id=-1", "Bearer admin-token", http.StatusBadRequest, nil},
+		{"Limit too high", visitsExportPath + "?limit=1000001", "Bearer admin-token", http.StatusBadRequest, nil},
+		{"Unknown format", visitsExportPath + "?format=csv", "Bearer admin-token", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("Authorization", tt.auth)
+			rr := httptest.NewRecorder()
+			visitsExportHandler(rr, req, exportTestStore(now), newFakeClock(now), "admin-token")
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("expected application/x-ndjson, got %q", ct)
+			}
+			var ids []int64
+			scanner := bufio.NewScanner(rr.Body)
+			for scanner.Scan() {
+				var line visitLine
+				if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+					t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+				}
+				// Every line is one the backfill command can import
+				if _, err := parseBackfillVisit(scanner.Bytes()); err != nil {
+					t.Errorf("line %q can't be imported: %v", scanner.Text(), err)
+				}
+				ids = append(ids, line.ID)
+			}
+			if len(ids) != len(tt.ids) {
+				t.Fatalf("expected visits %v, got %v", tt.ids, ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.ids[i] {
+					t.Fatalf("expected visits %v, got %v", tt.ids, ids)
+				}
+			}
+		})
+	}
+}
+
+func Test_visitsExportHandler_JSONArray(t *testing.T) {
+	now := time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		url  string
+		want int
+	}{
+		{visitsExportPath + "?format=json", 3},
+		{visitsExportPath + "?format=json&after_id=3", 0},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rr := httptest.NewRecorder()
+		visitsExportHandler(rr, req, exportTestStore(now), newFakeClock(now), "admin-token")
+
+		var visits []visitLine
+		if err := json.Unmarshal(rr.Body.Bytes(), &visits); err != nil {
+			t.Fatalf("%s: invalid JSON array %q: %v", tt.url, rr.Body.String(), err)
+		}
+		if visits == nil || len(visits) != tt.want {
+			t.Errorf("%s: expected %d visits, got %+v", tt.url, tt.want, visits)
+		}
+	}
+}
+
+// brokenStreamStore returns one visit and then fails, as when the connection drops mid-export
+type brokenStreamStore struct {
+	MockDataStore
+}
+
+func (s *brokenStreamStore) StreamVisits(ctx context.Context, afterID int64, before time.Time, limit int, fn func(VisitRow) error) error {
+	if err := fn(VisitRow{ID: 1, Visit: Visit{Timestamp: before.Add(-time.Hour)}}); err != nil {
+		return err
+	}
+	return errors.New("connection reset")
+}
+
+func Test_visitsExportHandler_FailureMidStream(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, visitsExportPath, nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+
+	// The response can't be marked as failed once started, so it is cut short instead
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("expected the handler to abort the response; got %v", r)
+		}
+	}()
+	visitsExportHandler(rr, req, &brokenStreamStore{}, newFakeClock(time.Now()), "admin-token")
+}
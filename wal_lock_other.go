@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockWALDir opens dir's lock file without locking it. Without graceful restarts, which need
+// Unix, no other process logs to the directory while this one runs.
+func lockWALDir(dir string) (*os.File, bool, error) {
+	f, err := os.OpenFile(filepath.Join(dir, walLockFile), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, false, err
+	}
+	return f, true, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// webhookNotifier posts JSON alerts to a URL. Payloads carry a "text" field, so Slack and
+// Discord-compatible incoming webhooks render them without an adapter.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Notify posts text along with details, which is encoded under "details".
+func (n *webhookNotifier) Notify(ctx context.Context, text string, details interface{}) error {
+	body, err := webhookPayload(text, details)
+	if err != nil {
+		return err
+	}
+	return n.post(ctx, body)
+}
+
+// webhookPayload encodes the body Notify posts.
+func webhookPayload(text string, details interface{}) ([]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{"text": text, "details": details})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	return body, nil
+}
+
+// Deliver posts a payload queued by outboxNotifier.
+func (n *webhookNotifier) Deliver(ctx context.Context, payload json.RawMessage) error {
+	return n.post(ctx, payload)
+}
+
+// post sends an encoded payload.
+func (n *webhookNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return nil
+}
+
+const visitWebhookQueueSize = 100
+
+func init() {
+	registerVisitProcessor("webhook", newVisitWebhookFromEnv)
+}
+
+// visitWebhook posts each recorded visit to VISIT_WEBHOOK_URL from a background goroutine.
+// Visits arriving while the queue is full are dropped rather than slowing the request path,
+// unless the outbox is enabled, which delivers every visit through Deliver.
+type visitWebhook struct {
+	notifier *webhookNotifier
+	queue    chan Visit
+}
+
+// newVisitWebhookFromEnv posts visits to a webhook when VISIT_WEBHOOK_URL is set.
+func newVisitWebhookFromEnv(DataStore) (VisitProcessor, error) {
+	url := os.Getenv("VISIT_WEBHOOK_URL")
+	if url == "" {
+		return nil, nil
+	}
+	return &visitWebhook{notifier: newWebhookNotifier(url), queue: make(chan Visit, visitWebhookQueueSize)}, nil
+}
+
+func (h *visitWebhook) Name() string {
+	return "webhook"
+}
+
+// ProcessVisit queues the visit for delivery.
+func (h *visitWebhook) ProcessVisit(ctx context.Context, visit Visit) error {
+	select {
+	case h.queue <- visit:
+		return nil
+	default:
+		return errors.New("webhook queue full, dropping visit")
+	}
+}
+
+// Run delivers queued visits until ctx is done.
+func (h *visitWebhook) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case visit := <-h.queue:
+			payload, err := h.OutboxPayload(visit)
+			if err == nil {
+				err = h.Deliver(ctx, payload)
+			}
+			if err != nil {
+				errorLogger.Printf("Error posting visit webhook: %v", err)
+			}
+		}
+	}
+}
+
+// OutboxPayload returns the webhook body for the visit.
+func (h *visitWebhook) OutboxPayload(visit Visit) (json.RawMessage, error) {
+	text := "New resume visit"
+	if visit.Referrer != "" {
+		text += " from " + visit.Referrer
+	}
+	return webhookPayload(text, newVisitRecord(visit))
+}
+
+// Deliver posts a body returned by OutboxPayload.
+func (h *visitWebhook) Deliver(ctx context.Context, payload json.RawMessage) error {
+	return h.notifier.post(ctx, payload)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_webhookNotifier_Notify(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON POST; got %s %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("could not decode payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	err := newWebhookNotifier(server.URL).Notify(context.Background(), "Visit spike", map[string]int{"visits": 60})
+	if err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if payload["text"] != "Visit spike" {
+		t.Errorf("expected text %q; got %v", "Visit spike", payload["text"])
+	}
+	if details, ok := payload["details"].(map[string]interface{}); !ok || details["visits"] != float64(60) {
+		t.Errorf("expected details with 60 visits; got %v", payload["details"])
+	}
+}
+
+func Test_webhookNotifier_Notify_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer server.Close()
+
+	if err := newWebhookNotifier(server.URL).Notify(context.Background(), "Visit drop", nil); err == nil {
+		t.Error("expected an error for a non-2xx response")
+	}
+}
+
+func Test_visitWebhook(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("could not decode payload: %v", err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	if p, _ := newVisitWebhookFromEnv(&MockDataStore{}); p != nil {
+		t.Fatal("expected no processor without VISIT_WEBHOOK_URL")
+	}
+	t.Setenv("VISIT_WEBHOOK_URL", server.URL)
+	p, err := newVisitWebhookFromEnv(&MockDataStore{})
+	if err != nil || p == nil {
+		t.Fatalf("expected a webhook processor; got %v, %v", p, err)
+	}
+	h := p.(*visitWebhook)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Run(ctx)
+
+	if err := h.ProcessVisit(ctx, Visit{Timestamp: time.Now(), Referrer: "linkedin.com"}); err != nil {
+		t.Fatalf("ProcessVisit() error = %v", err)
+	}
+	select {
+	case payload := <-received:
+		if payload["text"] != "New resume visit from linkedin.com" {
+			t.Errorf("unexpected text %v", payload["text"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the webhook")
+	}
+}
+
+func Test_visitWebhook_QueueFull(t *testing.T) {
+	h := &visitWebhook{queue: make(chan Visit, 1)}
+	if err := h.ProcessVisit(context.Background(), Visit{}); err != nil {
+		t.Fatalf("ProcessVisit() error = %v", err)
+	}
+	if err := h.ProcessVisit(context.Background(), Visit{}); err == nil {
+		t.Error("expected a full queue to drop the visit with an error")
+	}
+}
+
+func Test_visitWebhook_Outbox(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("could not decode payload: %v", err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	h := &visitWebhook{notifier: newWebhookNotifier(server.URL)}
+	payload, err := h.OutboxPayload(Visit{Timestamp: time.Now(), Referrer: "linkedin.com"})
+	if err != nil {
+		t.Fatalf("OutboxPayload() error = %v", err)
+	}
+	if err := h.Deliver(context.Background(), payload); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+	if got := <-received; got["text"] != "New resume visit from linkedin.com" || got["details"] == nil {
+		t.Errorf("unexpected payload %v", got)
+	}
+}